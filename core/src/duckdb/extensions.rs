@@ -0,0 +1,327 @@
+use std::collections::BTreeMap;
+use std::fs::File;
+use std::io::Read;
+use duckdb::Connection;
+use serde::{Serialize, Deserialize};
+use sha2::{Digest, Sha256};
+use crate::query::lexer::{tokenize, Token};
+use crate::{LumosError, Result};
+
+/// Extensions allowed when no allowlist is configured
+pub const DEFAULT_ALLOWED_EXTENSIONS: &[&str] = &["httpfs", "parquet", "json", "spatial"];
+
+/// Install path DuckDB reports for extensions linked into the library
+const BUILT_IN_PATH: &str = "(BUILT-IN)";
+
+/// State of a DuckDB extension
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExtensionInfo {
+    /// Extension name
+    pub name: String,
+    /// Whether the extension is loaded in the connection
+    pub loaded: bool,
+    /// Whether the extension is installed on this host
+    pub installed: bool,
+    /// Path of the installed extension file
+    pub install_path: String,
+    /// Extension description
+    pub description: String,
+    /// Whether the allowlist permits installing and loading the extension
+    pub allowed: bool,
+}
+
+/// An `INSTALL` or `LOAD` statement found in submitted SQL
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum ExtensionStatement {
+    /// `[FORCE] INSTALL name`
+    Install(String),
+    /// `LOAD name`
+    Load(String),
+}
+
+impl ExtensionStatement {
+    /// Find the `INSTALL` and `LOAD` statements in `sql`
+    ///
+    /// The name is returned as written. Paths, quoted names and trailing
+    /// clauses such as `FROM <repository>` are kept in it so that the name
+    /// check rejects them.
+    pub fn find_all(sql: &str) -> Vec<Self> {
+        let tokens = tokenize(sql);
+        tokens
+            .split(|token| *token == Token::Symbol(';'))
+            .filter_map(|statement| {
+                let statement = match statement.first() {
+                    Some(first) if first.is_keyword("FORCE") => &statement[1..],
+                    _ => statement,
+                };
+                let load = match statement.first() {
+                    Some(first) if first.is_keyword("INSTALL") => false,
+                    Some(first) if first.is_keyword("LOAD") => true,
+                    _ => return None,
+                };
+                let name = statement[1..]
+                    .iter()
+                    .map(|token| match token {
+                        Token::Word(w) | Token::Number(w) | Token::Param(w) => w.clone(),
+                        Token::QuotedIdent(q) => q.clone(),
+                        Token::String(s) => format!("'{}'", s),
+                        Token::Symbol(c) => c.to_string(),
+                    })
+                    .collect::<Vec<_>>()
+                    .join(" ");
+                Some(if load { ExtensionStatement::Load(name) } else { ExtensionStatement::Install(name) })
+            })
+            .collect()
+    }
+}
+
+/// Which DuckDB extensions may be installed and loaded
+///
+/// Each allowed extension may carry the expected SHA-256 of its extension
+/// file. Installed files are checked against it before they are loaded, so
+/// an extension replaced on disk or served by a tampered repository is
+/// refused. Extensions linked into DuckDB itself are not checked.
+#[derive(Debug, Clone, Default)]
+pub struct ExtensionPolicy {
+    /// Allowed extensions and their expected checksums
+    allowed: BTreeMap<String, Option<String>>,
+}
+
+impl ExtensionPolicy {
+    /// Create a policy that allows no extensions
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Create a policy that allows `DEFAULT_ALLOWED_EXTENSIONS` without pinned checksums
+    pub fn with_default_allowlist() -> Self {
+        Self::from_entries(DEFAULT_ALLOWED_EXTENSIONS).expect("default extension names are valid")
+    }
+
+    /// Create a policy from `name` or `name=sha256` entries
+    pub fn from_entries<S: AsRef<str>>(entries: &[S]) -> Result<Self> {
+        let mut policy = Self::new();
+        for entry in entries {
+            let entry = entry.as_ref().trim();
+            if entry.is_empty() {
+                continue;
+            }
+            policy = match entry.split_once('=') {
+                Some((name, checksum)) => policy.allow(name.trim(), Some(checksum.trim().to_string()))?,
+                None => policy.allow(entry, None)?,
+            };
+        }
+        Ok(policy)
+    }
+
+    /// Allow an extension, optionally pinning the SHA-256 of its file
+    pub fn allow(mut self, name: &str, checksum: Option<String>) -> Result<Self> {
+        validate_name(name)?;
+        self.allowed.insert(name.to_lowercase(), checksum.map(|c| c.to_lowercase()));
+        Ok(self)
+    }
+
+    /// Whether the extension may be installed and loaded
+    pub fn is_allowed(&self, name: &str) -> bool {
+        self.allowed.contains_key(&name.to_lowercase())
+    }
+
+    /// Names of the allowed extensions
+    pub fn allowed(&self) -> Vec<String> {
+        self.allowed.keys().cloned().collect()
+    }
+
+    /// Stop the connection from installing or loading extensions on its own
+    ///
+    /// DuckDB otherwise fetches and loads known extensions when a query needs
+    /// them (`read_parquet`, `s3://` paths, ...), bypassing the allowlist.
+    /// Call `lock_configuration` once the connection is set up so SQL cannot
+    /// turn this back on.
+    pub fn disable_autoloading(&self, conn: &Connection) -> Result<()> {
+        conn.execute_batch("SET autoinstall_known_extensions=false; SET autoload_known_extensions=false;")?;
+        Ok(())
+    }
+
+    /// Forbid further `SET` statements on the connection
+    pub fn lock_configuration(&self, conn: &Connection) -> Result<()> {
+        conn.execute_batch("SET lock_configuration=true")?;
+        Ok(())
+    }
+
+    /// Run an `INSTALL` or `LOAD` statement submitted as SQL through the policy
+    ///
+    /// Only allowlisted extensions are installed, and files are checked
+    /// against their pinned checksums before they are loaded.
+    pub fn apply(&self, conn: &Connection, statement: &ExtensionStatement) -> Result<ExtensionInfo> {
+        match statement {
+            ExtensionStatement::Install(name) => self.install(conn, name),
+            ExtensionStatement::Load(name) => self.load(conn, name),
+        }
+    }
+
+    /// Install an extension on this host and verify its checksum
+    pub fn install(&self, conn: &Connection, name: &str) -> Result<ExtensionInfo> {
+        self.check_allowed(name)?;
+        conn.execute_batch(&format!("INSTALL {}", name.to_lowercase()))?;
+        let info = self.info(conn, name)?;
+        self.verify(&info)?;
+        log::info!("Installed DuckDB extension '{}' at {}", info.name, info.install_path);
+        Ok(info)
+    }
+
+    /// Verify and load an installed extension into the connection
+    pub fn load(&self, conn: &Connection, name: &str) -> Result<ExtensionInfo> {
+        self.check_allowed(name)?;
+        let info = self.info(conn, name)?;
+        if !info.installed {
+            return Err(LumosError::NotFound(format!("Extension '{}' is not installed", name)));
+        }
+        self.verify(&info)?;
+        conn.execute_batch(&format!("LOAD {}", name.to_lowercase()))?;
+        log::info!("Loaded DuckDB extension '{}'", info.name);
+        self.info(conn, name)
+    }
+
+    /// Install an extension if necessary and load it
+    pub fn enable(&self, conn: &Connection, name: &str) -> Result<ExtensionInfo> {
+        if !self.info(conn, name)?.installed {
+            self.install(conn, name)?;
+        }
+        self.load(conn, name)
+    }
+
+    /// List all extensions known to DuckDB, marking which ones are allowed
+    pub fn list(&self, conn: &Connection) -> Result<Vec<ExtensionInfo>> {
+        let mut stmt = conn.prepare(
+            "SELECT extension_name, loaded, installed, COALESCE(install_path, ''), COALESCE(description, '')
+             FROM duckdb_extensions() ORDER BY extension_name",
+        )?;
+        let extensions = stmt
+            .query_map([], |row| {
+                let name: String = row.get(0)?;
+                Ok(ExtensionInfo {
+                    allowed: self.is_allowed(&name),
+                    name,
+                    loaded: row.get(1)?,
+                    installed: row.get(2)?,
+                    install_path: row.get(3)?,
+                    description: row.get(4)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(extensions)
+    }
+
+    /// Check the installed extension file against the pinned checksum
+    pub fn verify(&self, info: &ExtensionInfo) -> Result<()> {
+        let expected = match self.allowed.get(&info.name.to_lowercase()) {
+            Some(Some(checksum)) => checksum,
+            _ => return Ok(()),
+        };
+        if info.install_path.is_empty() || info.install_path == BUILT_IN_PATH {
+            return Ok(());
+        }
+
+        let actual = file_sha256(&info.install_path)?;
+        if &actual != expected {
+            return Err(LumosError::InvalidArgument(format!(
+                "Checksum mismatch for extension '{}': expected {}, found {}",
+                info.name, expected, actual
+            )));
+        }
+        Ok(())
+    }
+
+    fn info(&self, conn: &Connection, name: &str) -> Result<ExtensionInfo> {
+        let name = name.to_lowercase();
+        self.list(conn)?
+            .into_iter()
+            .find(|e| e.name == name)
+            .ok_or_else(|| LumosError::NotFound(format!("Unknown DuckDB extension '{}'", name)))
+    }
+
+    fn check_allowed(&self, name: &str) -> Result<()> {
+        validate_name(name)?;
+        if !self.is_allowed(name) {
+            return Err(LumosError::InvalidArgument(format!("Extension '{}' is not in the allowlist", name)));
+        }
+        Ok(())
+    }
+}
+
+/// SHA-256 of a file as lowercase hex
+pub fn file_sha256(path: &str) -> Result<String> {
+    let mut file = File::open(path)?;
+    let mut hasher = Sha256::new();
+    let mut buffer = [0u8; 8192];
+    loop {
+        let read = file.read(&mut buffer)?;
+        if read == 0 {
+            break;
+        }
+        hasher.update(&buffer[..read]);
+    }
+    Ok(hex::encode(hasher.finalize()))
+}
+
+/// Extension names are interpolated into INSTALL/LOAD and must be plain identifiers
+fn validate_name(name: &str) -> Result<()> {
+    if name.is_empty() || !name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+        return Err(LumosError::InvalidArgument(format!("Invalid extension name '{}'", name)));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::Write;
+
+    #[test]
+    fn test_extension_policy() {
+        let policy = ExtensionPolicy::from_entries(&["httpfs", "JSON=ABCDEF"]).unwrap();
+        assert!(policy.is_allowed("json"));
+        assert!(!policy.is_allowed("spatial"));
+        assert!(ExtensionPolicy::from_entries(&["httpfs; DROP TABLE x"]).is_err());
+
+        // A file that does not match the pinned checksum is refused
+        let mut file = tempfile::NamedTempFile::new().unwrap();
+        file.write_all(b"extension").unwrap();
+        let path = file.path().to_str().unwrap().to_string();
+        let info = ExtensionInfo {
+            name: "json".to_string(),
+            loaded: false,
+            installed: true,
+            install_path: path.clone(),
+            description: String::new(),
+            allowed: true,
+        };
+        assert!(policy.verify(&info).is_err());
+
+        let pinned = ExtensionPolicy::new().allow("json", Some(file_sha256(&path).unwrap())).unwrap();
+        assert!(pinned.verify(&info).is_ok());
+
+        let conn = Connection::open_in_memory().unwrap();
+        assert!(policy.load(&conn, "spatial").is_err());
+    }
+
+    #[test]
+    fn test_find_extension_statements() {
+        assert_eq!(
+            ExtensionStatement::find_all("/* setup */ force install httpfs; LOAD httpfs"),
+            vec![
+                ExtensionStatement::Install("httpfs".to_string()),
+                ExtensionStatement::Load("httpfs".to_string()),
+            ]
+        );
+        assert!(ExtensionStatement::find_all("SELECT 'LOAD x' AS load").is_empty());
+
+        // Paths and repositories stay in the name and fail the name check
+        let policy = ExtensionPolicy::from_entries(&["httpfs"]).unwrap();
+        let conn = Connection::open_in_memory().unwrap();
+        for sql in ["LOAD '/tmp/evil.duckdb_extension'", "INSTALL httpfs FROM 'http://example.com'"] {
+            let statement = &ExtensionStatement::find_all(sql)[0];
+            assert!(policy.apply(&conn, statement).is_err(), "{}", sql);
+        }
+    }
+}
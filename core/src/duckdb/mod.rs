@@ -1,6 +1,8 @@
 pub mod connection;
 pub mod analytics;
+pub mod extensions;
 
+use std::sync::atomic::{AtomicU64, Ordering};
 use duckdb::{Connection, params, Result as DuckResult};
 use crate::{LumosError, Result};
 use serde_json::Value as JsonValue;
@@ -27,12 +29,17 @@ pub fn value_ref_to_json(value_ref: &duckdb::types::ValueRef) -> JsonValue {
     }
 }
 
+/// Number of compiled statements the connection keeps for `prepare` and `exec`
+pub const STATEMENT_CACHE_CAPACITY: usize = crate::query::prepared::DEFAULT_PREPARED_CACHE_SIZE;
+
 /// DuckDB engine for Lumos-DB
 pub struct DuckDbEngine {
     /// Path to the DuckDB database file
     path: String,
     /// Connection to the DuckDB database
     connection: Option<Connection>,
+    /// Extensions the connection may install and load
+    extension_policy: Option<extensions::ExtensionPolicy>,
 }
 
 impl DuckDbEngine {
@@ -41,9 +48,24 @@ impl DuckDbEngine {
         Self {
             path: path.to_string(),
             connection: None,
+            extension_policy: None,
         }
     }
 
+    /// Restrict the engine to the extensions allowed by `policy`
+    ///
+    /// `init` then turns off extension autoloading and locks the connection's
+    /// configuration, and `INSTALL`/`LOAD` statements go through the policy.
+    pub fn with_extension_policy(mut self, policy: extensions::ExtensionPolicy) -> Self {
+        self.extension_policy = Some(policy);
+        self
+    }
+
+    /// Extension policy set with `with_extension_policy`
+    pub fn extension_policy(&self) -> Option<&extensions::ExtensionPolicy> {
+        self.extension_policy.as_ref()
+    }
+
     /// Initialize the DuckDB engine
     pub fn init(&mut self) -> Result<()> {
         log::info!("Initializing DuckDB engine with database at: {}", self.path);
@@ -60,6 +82,15 @@ impl DuckDbEngine {
         conn.execute("SET enable_progress_bar=true", [])
             .map_err(|e| LumosError::DuckDb(e.to_string()))?;
         
+        // Keep as many compiled statements as the prepared statement cache
+        conn.set_prepared_statement_cache_capacity(STATEMENT_CACHE_CAPACITY);
+        
+        // Extensions are only installed and loaded through the policy
+        if let Some(policy) = &self.extension_policy {
+            policy.disable_autoloading(&conn)?;
+            policy.lock_configuration(&conn)?;
+        }
+        
         // Store the connection
         self.connection = Some(conn);
         
@@ -88,6 +119,27 @@ impl DuckDbEngine {
         Ok(rows_affected)
     }
     
+    /// Compile a statement and keep it in the connection's statement cache, returning its parameter count
+    ///
+    /// The connection keeps up to `STATEMENT_CACHE_CAPACITY` compiled
+    /// statements keyed by SQL text, so `exec` with the same SQL skips parsing
+    /// and planning.
+    pub fn prepare(&self, sql: &str) -> Result<usize> {
+        let conn = self.connection()?;
+        let stmt = conn.prepare_cached(sql)
+            .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+        Ok(stmt.parameter_count())
+    }
+    
+    /// Execute a statement compiled by `prepare`, returning the number of rows affected
+    pub fn exec(&self, sql: &str, params: &[&dyn duckdb::ToSql]) -> Result<usize> {
+        let conn = self.connection()?;
+        let mut stmt = conn.prepare_cached(sql)
+            .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+        stmt.execute(params)
+            .map_err(|e| LumosError::DuckDb(e.to_string()))
+    }
+    
     /// Execute a query and return all rows as a vector of tuples
     pub fn query<T, F>(&self, sql: &str, params: &[&dyn duckdb::ToSql], mut map_fn: F) -> Result<Vec<T>>
     where
@@ -168,6 +220,55 @@ impl DuckDbEngine {
         Ok(())
     }
     
+    /// Write vectors to a Parquet file, returning the number of rows written
+    ///
+    /// The file has `id`, `vector` (FLOAT[]) and `metadata` (JSON text)
+    /// columns. Rows are appended to a temporary staging table, which is
+    /// written with `COPY ... (FORMAT PARQUET)` and dropped afterwards.
+    pub fn export_vectors_parquet<'a, I>(&self, rows: I, file_path: &str) -> Result<usize>
+    where
+        I: IntoIterator<Item = (&'a str, &'a [f32], Option<String>)>,
+    {
+        static NEXT_STAGING: AtomicU64 = AtomicU64::new(0);
+        let staging = format!("_lumos_vector_export_{}", NEXT_STAGING.fetch_add(1, Ordering::Relaxed));
+        let conn = self.connection()?;
+        conn.execute_batch(&format!(
+            "CREATE TEMP TABLE {} (id VARCHAR, vector VARCHAR, metadata VARCHAR)",
+            staging
+        )).map_err(|e| LumosError::DuckDb(e.to_string()))?;
+
+        let result = (|| -> Result<usize> {
+            let mut count = 0;
+            let mut appender = conn.appender(&staging)
+                .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+            for (id, vector, metadata) in rows {
+                let vector = format!(
+                    "[{}]",
+                    vector.iter().map(|v| v.to_string()).collect::<Vec<_>>().join(", ")
+                );
+                appender.append_row(params![id, vector, metadata])
+                    .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+                count += 1;
+            }
+            appender.flush().map_err(|e| LumosError::DuckDb(e.to_string()))?;
+            drop(appender);
+
+            self.execute(&format!(
+                "COPY (SELECT id, CAST(vector AS FLOAT[]) AS vector, metadata FROM {}) TO '{}' (FORMAT PARQUET)",
+                staging, file_path.replace('\'', "''")
+            ))?;
+            Ok(count)
+        })();
+
+        if let Err(e) = self.execute(&format!("DROP TABLE IF EXISTS {}", staging)) {
+            log::warn!("Failed to drop export staging table '{}': {}", staging, e);
+        }
+        if let Ok(count) = &result {
+            log::debug!("Exported {} vectors to Parquet '{}'", count, file_path);
+        }
+        result
+    }
+
     /// Check if a table exists
     pub fn table_exists(&self, table_name: &str) -> Result<bool> {
         let sql = "SELECT COUNT(*) FROM information_schema.tables WHERE table_name = ?";
@@ -179,6 +280,16 @@ impl DuckDbEngine {
         Ok(count > 0)
     }
     
+    /// Install an allowed extension if necessary and load it
+    pub fn enable_extension(&self, policy: &extensions::ExtensionPolicy, name: &str) -> Result<extensions::ExtensionInfo> {
+        policy.enable(self.connection()?, name)
+    }
+    
+    /// List the extensions known to DuckDB
+    pub fn list_extensions(&self, policy: &extensions::ExtensionPolicy) -> Result<Vec<extensions::ExtensionInfo>> {
+        policy.list(self.connection()?)
+    }
+    
     /// Close the connection
     pub fn close(self) -> Result<()> {
         if let Some(_conn) = self.connection {
@@ -29,6 +29,8 @@ pub enum LumosError {
     Internal(String),
     /// Not found errors
     NotFound(String),
+    /// Query exceeded its execution time limit
+    Timeout(String),
     /// Generic errors
     Other(String),
 }
@@ -45,6 +47,7 @@ impl fmt::Display for LumosError {
             LumosError::InvalidArgument(msg) => write!(f, "Invalid argument: {}", msg),
             LumosError::Internal(msg) => write!(f, "Internal error: {}", msg),
             LumosError::NotFound(msg) => write!(f, "Not found: {}", msg),
+            LumosError::Timeout(msg) => write!(f, "Query timed out: {}", msg),
             LumosError::Other(msg) => write!(f, "Error: {}", msg),
         }
     }
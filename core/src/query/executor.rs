@@ -5,87 +5,266 @@ use crate::{
     duckdb::{DuckDbEngine, value_ref_to_json}
 };
 use serde_json::Value as JsonValue;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{mpsc, Arc};
+use std::thread::{self, JoinHandle};
+use std::time::{Duration, Instant};
+
+/// Summary of a streamed query
+#[derive(Debug, Clone, PartialEq)]
+pub struct StreamSummary {
+    /// Column names
+    pub columns: Vec<String>,
+    /// Number of rows passed to the callback
+    pub rows: usize,
+    /// Whether the callback stopped the stream before the last row
+    pub stopped: bool,
+}
 
 /// Execute a query on SQLite
 pub fn execute_on_sqlite(engine: &SqliteEngine, query: &Query) -> Result<QueryResult> {
-    let conn = engine.connection()?;
-    
-    // Convert query parameters to SQLite parameters
-    let params: Vec<&dyn rusqlite::ToSql> = query.params.iter()
-        .map(|p| -> &dyn rusqlite::ToSql {
-            match p {
-                QueryParam::String(s) => s,
-                QueryParam::Integer(i) => i,
-                QueryParam::Float(f) => f,
-                QueryParam::Boolean(b) => b,
-                QueryParam::Null => &None::<i32> as &dyn rusqlite::ToSql,
-            }
-        })
-        .collect();
-    
-    // Prepare the statement
-    let mut stmt = conn.prepare(&query.sql)?;
-    
-    // Get column information
-    let column_count = stmt.column_count();
-    let mut columns = Vec::with_capacity(column_count);
-    
-    for i in 0..column_count {
-        if let Ok(name) = stmt.column_name(i) {
-            columns.push(name.to_string());
-        } else {
-            columns.push(format!("column_{}", i));
-        }
+    if query.query_type == crate::query::QueryType::Select {
+        // Query rows and convert to JSON format
+        let mut rows_result = Vec::new();
+        let summary = stream_on_sqlite(engine, query, |_, row| {
+            rows_result.push(row);
+            Ok(true)
+        })?;
+        return Ok(select_result(summary.columns, rows_result, EngineType::Sqlite));
     }
-    
-    // Execute the query
-    let mut rows_result = Vec::new();
-    let mut rows_affected = 0;
-    
-    match query.query_type {
-        crate::query::QueryType::Select => {
-            // Query rows and convert to JSON format
-            let mut rows = stmt.query(&params[..])?;
-            
-            while let Some(row) = rows.next()? {
-                let mut json_row = Vec::with_capacity(column_count);
-                
-                for i in 0..column_count {
-                    let value = match row.get_ref(i)? {
-                        rusqlite::types::ValueRef::Null => JsonValue::Null,
-                        rusqlite::types::ValueRef::Integer(i) => JsonValue::from(i),
-                        rusqlite::types::ValueRef::Real(f) => JsonValue::from(f),
-                        rusqlite::types::ValueRef::Text(s) => JsonValue::from(std::str::from_utf8(s).unwrap_or_default()),
-                        rusqlite::types::ValueRef::Blob(b) => JsonValue::from(format!("<BLOB: {} bytes>", b.len())),
-                    };
-                    
-                    json_row.push(value);
-                }
-                
-                rows_result.push(json_row);
-            }
-        },
-        _ => {
-            // For non-SELECT queries, execute and get the number of affected rows
-            rows_affected = stmt.execute(&params[..])?;
-        }
-    }
-    
+
+    let conn = engine.connection()?;
+    let params = sqlite_params(&query.params);
+
+    let watchdog = Watchdog::start(conn.get_interrupt_handle(), query.timeout);
+
+    // Reuse the connection's compiled statement for repeated SQL text
+    let mut stmt = conn.prepare_cached(&query.sql)?;
+    let columns = column_names(stmt.column_count(), |i| stmt.column_name(i).ok().map(str::to_string));
+
+    // For non-SELECT queries, execute and get the number of affected rows
+    let rows_affected = watchdog.check(query, stmt.execute(&params[..]).map_err(LumosError::from))?;
+
     Ok(QueryResult {
         columns,
-        rows: rows_result,
+        rows: Vec::new(),
         rows_affected,
         engine_used: EngineType::Sqlite,
         execution_time_ms: 0, // Will be filled in by the caller
     })
 }
 
+/// Stream the rows of a query on SQLite to `on_row` without materializing them
+///
+/// `on_row` receives the column names and one row at a time, and returns
+/// `false` to stop reading further rows.
+pub fn stream_on_sqlite<F>(engine: &SqliteEngine, query: &Query, mut on_row: F) -> Result<StreamSummary>
+where
+    F: FnMut(&[String], Vec<JsonValue>) -> Result<bool>,
+{
+    let conn = engine.connection()?;
+    let params = sqlite_params(&query.params);
+    let watchdog = Watchdog::start(conn.get_interrupt_handle(), query.timeout);
+
+    let mut stmt = conn.prepare_cached(&query.sql)?;
+    let column_count = stmt.column_count();
+    let columns = column_names(column_count, |i| stmt.column_name(i).ok().map(str::to_string));
+
+    let mut summary = StreamSummary { columns, rows: 0, stopped: false };
+    let mut rows = watchdog.check(query, stmt.query(&params[..]).map_err(LumosError::from))?;
+
+    while let Some(row) = watchdog.check(query, rows.next().map_err(LumosError::from))? {
+        let mut json_row = Vec::with_capacity(column_count);
+
+        for i in 0..column_count {
+            let value = match row.get_ref(i)? {
+                rusqlite::types::ValueRef::Null => JsonValue::Null,
+                rusqlite::types::ValueRef::Integer(i) => JsonValue::from(i),
+                rusqlite::types::ValueRef::Real(f) => JsonValue::from(f),
+                rusqlite::types::ValueRef::Text(s) => JsonValue::from(std::str::from_utf8(s).unwrap_or_default()),
+                rusqlite::types::ValueRef::Blob(b) => JsonValue::from(format!("<BLOB: {} bytes>", b.len())),
+            };
+
+            json_row.push(value);
+        }
+
+        summary.rows += 1;
+        if !on_row(&summary.columns, json_row)? {
+            summary.stopped = true;
+            break;
+        }
+    }
+
+    Ok(summary)
+}
+
 /// Execute a query on DuckDB
 pub fn execute_on_duckdb(engine: &DuckDbEngine, query: &Query) -> Result<QueryResult> {
+    if query.query_type == crate::query::QueryType::Select {
+        // Query rows and convert to JSON format
+        let mut rows_result = Vec::new();
+        let summary = stream_on_duckdb(engine, query, |_, row| {
+            rows_result.push(row);
+            Ok(true)
+        })?;
+        return Ok(select_result(summary.columns, rows_result, EngineType::DuckDb));
+    }
+
+    let conn = engine.connection()?;
+    let params = duckdb_params(&query.params);
+
+    // Reuse the connection's compiled statement for repeated SQL text
+    let mut stmt = conn.prepare_cached(&query.sql)
+        .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+
+    // For non-SELECT queries, execute and get the number of affected rows
+    let rows_affected = stmt.execute(&params[..])
+        .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+
+    Ok(QueryResult {
+        columns: Vec::new(),
+        rows: Vec::new(),
+        rows_affected,
+        engine_used: EngineType::DuckDb,
+        execution_time_ms: 0, // Will be filled in by the caller
+    })
+}
+
+/// Stream the rows of a query on DuckDB to `on_row` without materializing them
+///
+/// `on_row` receives the column names and one row at a time, and returns
+/// `false` to stop reading further rows.
+pub fn stream_on_duckdb<F>(engine: &DuckDbEngine, query: &Query, mut on_row: F) -> Result<StreamSummary>
+where
+    F: FnMut(&[String], Vec<JsonValue>) -> Result<bool>,
+{
     let conn = engine.connection()?;
-    
-    // Convert query parameters to DuckDB parameters
-    let params: Vec<&dyn duckdb::ToSql> = query.params.iter()
+    let params = duckdb_params(&query.params);
+    // The duckdb 0.8 bindings expose no interrupt handle, so the deadline is
+    // checked between rows; a statement that is slow to produce its first row
+    // runs to completion
+    let deadline = query.timeout.map(|timeout| Instant::now() + timeout);
+
+    let mut stmt = conn.prepare_cached(&query.sql)
+        .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+    let mut rows = stmt.query(&params[..])
+        .map_err(|e| LumosError::DuckDb(e.to_string()))?;
+
+    // DuckDB only knows the result columns once the statement has run
+    let column_count = rows.as_ref().map_or(0, |stmt| stmt.column_count());
+    let columns = column_names(column_count, |i| {
+        rows.as_ref().and_then(|stmt| stmt.column_name(i).ok().map(|s| s.to_string()))
+    });
+
+    let mut summary = StreamSummary { columns, rows: 0, stopped: false };
+
+    while let Some(row) = rows.next()
+        .map_err(|e| LumosError::DuckDb(e.to_string()))? {
+
+        if deadline.map_or(false, |deadline| Instant::now() > deadline) {
+            return Err(timeout_error(query));
+        }
+
+        let mut json_row = Vec::with_capacity(column_count);
+
+        for i in 0..column_count {
+            let value_ref = match row.get_ref(i)
+                .map_err(|e| LumosError::DuckDb(e.to_string())) {
+                Ok(val) => val,
+                Err(_) => continue,
+            };
+
+            let value = value_ref_to_json(&value_ref);
+            json_row.push(value);
+        }
+
+        summary.rows += 1;
+        if !on_row(&summary.columns, json_row)? {
+            summary.stopped = true;
+            break;
+        }
+    }
+
+    Ok(summary)
+}
+
+/// Interrupts a running SQLite statement when the query's timeout elapses
+///
+/// The watchdog thread is stopped and joined when the guard is dropped, so
+/// an interrupt never reaches a later statement on the same connection.
+struct Watchdog {
+    done: Option<mpsc::Sender<()>>,
+    fired: Arc<AtomicBool>,
+    thread: Option<JoinHandle<()>>,
+}
+
+impl Watchdog {
+    /// Start a watchdog; without a timeout nothing is spawned
+    fn start(interrupt: rusqlite::InterruptHandle, timeout: Option<Duration>) -> Self {
+        let fired = Arc::new(AtomicBool::new(false));
+        let timeout = match timeout {
+            Some(timeout) => timeout,
+            None => return Self { done: None, fired, thread: None },
+        };
+
+        let (done, finished) = mpsc::channel::<()>();
+        let thread_fired = fired.clone();
+        let thread = thread::spawn(move || {
+            if let Err(mpsc::RecvTimeoutError::Timeout) = finished.recv_timeout(timeout) {
+                thread_fired.store(true, Ordering::SeqCst);
+                interrupt.interrupt();
+            }
+        });
+
+        Self { done: Some(done), fired, thread: Some(thread) }
+    }
+
+    /// Turn an error caused by the interrupt into a timeout error
+    fn check<T>(&self, query: &Query, result: Result<T>) -> Result<T> {
+        match result {
+            Err(_) if self.fired.load(Ordering::SeqCst) => Err(timeout_error(query)),
+            other => other,
+        }
+    }
+}
+
+impl Drop for Watchdog {
+    fn drop(&mut self) {
+        // Closing the channel wakes the watchdog thread
+        self.done.take();
+        if let Some(thread) = self.thread.take() {
+            let _ = thread.join();
+        }
+    }
+}
+
+/// Error for a query that exceeded its execution time limit
+fn timeout_error(query: &Query) -> LumosError {
+    LumosError::Timeout(format!(
+        "query exceeded {} ms: {}",
+        query.timeout.map_or(0, |timeout| timeout.as_millis()),
+        query.sql
+    ))
+}
+
+/// Convert query parameters to SQLite parameters
+fn sqlite_params(params: &[QueryParam]) -> Vec<&dyn rusqlite::ToSql> {
+    params.iter()
+        .map(|p| -> &dyn rusqlite::ToSql {
+            match p {
+                QueryParam::String(s) => s,
+                QueryParam::Integer(i) => i,
+                QueryParam::Float(f) => f,
+                QueryParam::Boolean(b) => b,
+                QueryParam::Null => &None::<i32> as &dyn rusqlite::ToSql,
+            }
+        })
+        .collect()
+}
+
+/// Convert query parameters to DuckDB parameters
+fn duckdb_params(params: &[QueryParam]) -> Vec<&dyn duckdb::ToSql> {
+    params.iter()
         .map(|p| -> &dyn duckdb::ToSql {
             match p {
                 QueryParam::String(s) => s,
@@ -95,65 +274,26 @@ pub fn execute_on_duckdb(engine: &DuckDbEngine, query: &Query) -> Result<QueryRe
                 QueryParam::Null => &None::<i32> as &dyn duckdb::ToSql,
             }
         })
-        .collect();
-    
-    // Prepare the statement
-    let mut stmt = conn.prepare(&query.sql)
-        .map_err(|e| LumosError::DuckDb(e.to_string()))?;
-    
-    // Get column information
-    let column_count = stmt.column_count();
-    let mut columns = Vec::with_capacity(column_count);
-    
-    for i in 0..column_count {
-        if let Ok(name) = stmt.column_name(i) {
-            columns.push(name.to_string());
-        } else {
-            columns.push(format!("column_{}", i));
-        }
-    }
-    
-    // Execute the query
-    let mut rows_result = Vec::new();
-    let mut rows_affected = 0;
-    
-    match query.query_type {
-        crate::query::QueryType::Select => {
-            // Query rows and convert to JSON format
-            let mut rows = stmt.query(&params[..])
-                .map_err(|e| LumosError::DuckDb(e.to_string()))?;
-            
-            while let Some(row) = rows.next()
-                .map_err(|e| LumosError::DuckDb(e.to_string()))? {
-                
-                let mut json_row = Vec::with_capacity(column_count);
-                
-                for i in 0..column_count {
-                    let value_ref = match row.get_ref(i)
-                        .map_err(|e| LumosError::DuckDb(e.to_string())) {
-                        Ok(val) => val,
-                        Err(_) => continue,
-                    };
-                    
-                    let value = value_ref_to_json(&value_ref);
-                    json_row.push(value);
-                }
-                
-                rows_result.push(json_row);
-            }
-        },
-        _ => {
-            // For non-SELECT queries, execute and get the number of affected rows
-            rows_affected = stmt.execute(&params[..])
-                .map_err(|e| LumosError::DuckDb(e.to_string()))?;
-        }
-    }
-    
-    Ok(QueryResult {
+        .collect()
+}
+
+/// Get column names, falling back to `column_{i}` for unnamed columns
+fn column_names<F>(column_count: usize, name: F) -> Vec<String>
+where
+    F: Fn(usize) -> Option<String>,
+{
+    (0..column_count)
+        .map(|i| name(i).unwrap_or_else(|| format!("column_{}", i)))
+        .collect()
+}
+
+/// Build the result of a SELECT query
+fn select_result(columns: Vec<String>, rows: Vec<Vec<JsonValue>>, engine: EngineType) -> QueryResult {
+    QueryResult {
         columns,
-        rows: rows_result,
-        rows_affected,
-        engine_used: EngineType::DuckDb,
+        rows,
+        rows_affected: 0,
+        engine_used: engine,
         execution_time_ms: 0, // Will be filled in by the caller
-    })
+    }
 }
@@ -0,0 +1,308 @@
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+use serde::{Serialize, Deserialize};
+use crate::query::lexer::{read_quoted, tokenize, Token};
+
+/// Default number of distinct query shapes kept by a collector
+pub const DEFAULT_MAX_FINGERPRINTS: usize = 1000;
+
+/// Reduce a statement to its shape
+///
+/// Literals and bind parameters become `?`, a list of them such as an IN
+/// list collapses to a single `?`, keywords and unquoted identifiers are
+/// uppercased, and whitespace and comments are normalized away. So
+/// `select * from t where id = 1` and `SELECT * FROM t WHERE id=2` share a
+/// fingerprint.
+pub fn fingerprint(sql: &str) -> String {
+    let mut parts: Vec<String> = Vec::new();
+
+    for token in tokenize(sql) {
+        let part = match token {
+            Token::Word(word) => word.to_uppercase(),
+            Token::QuotedIdent(ident) => format!("\"{}\"", ident),
+            Token::String(_) | Token::Number(_) | Token::Param(_) => "?".to_string(),
+            Token::Symbol(c) => c.to_string(),
+        };
+
+        // `?, ?` collapses into the preceding `?`
+        let len = parts.len();
+        if part == "?" && len >= 2 && parts[len - 1] == "," && parts[len - 2] == "?" {
+            parts.pop();
+            continue;
+        }
+        parts.push(part);
+    }
+
+    while parts.last().map_or(false, |p| p == ";") {
+        parts.pop();
+    }
+
+    let mut out = String::with_capacity(sql.len());
+    for (i, part) in parts.iter().enumerate() {
+        let tight = i == 0
+            || matches!(part.as_str(), "," | ")" | ".")
+            || matches!(parts[i - 1].as_str(), "(" | ".");
+        if !tight {
+            out.push(' ');
+        }
+        out.push_str(part);
+    }
+    out
+}
+
+/// Replace the literals of a statement, keeping it runnable
+///
+/// String literals become `'?'` (blob literals `X''`), numbers become `0`
+/// and comments are dropped, so values such as emails or tokens are not
+/// kept, while identifiers, operators and bind parameters stay as written
+/// and the statement can still be explained or executed.
+pub fn redact(sql: &str) -> String {
+    let chars: Vec<char> = sql.chars().collect();
+    let mut out = String::with_capacity(sql.len());
+    let mut i = 0;
+
+    while i < chars.len() {
+        let c = chars[i];
+
+        if c == '-' && chars.get(i + 1) == Some(&'-') {
+            while i < chars.len() && chars[i] != '\n' {
+                i += 1;
+            }
+        } else if c == '/' && chars.get(i + 1) == Some(&'*') {
+            i += 2;
+            while i < chars.len() && !(chars[i] == '*' && chars.get(i + 1) == Some(&'/')) {
+                i += 1;
+            }
+            i += 2;
+            out.push(' ');
+        } else if c == '\'' {
+            let blob = i > 0 && chars[i - 1].eq_ignore_ascii_case(&'x')
+                && (i < 2 || !(chars[i - 2].is_alphanumeric() || chars[i - 2] == '_'));
+            out.push_str(if blob { "''" } else { "'?'" });
+            i = read_quoted(&chars, i, '\'').1;
+        } else if c == '"' || c == '`' || c == '[' {
+            let next = read_quoted(&chars, i, if c == '[' { ']' } else { c }).1;
+            out.extend(&chars[i..next.min(chars.len())]);
+            i = next;
+        } else if c.is_ascii_digit() || (c == '.' && chars.get(i + 1).map_or(false, |n| n.is_ascii_digit())) {
+            while i < chars.len() && (chars[i].is_ascii_alphanumeric() || chars[i] == '.') {
+                i += 1;
+            }
+            out.push('0');
+        } else if c.is_alphanumeric() || c == '_' || c == '$' || c == ':' || c == '@' || c == '?' {
+            // Words and bind parameters are copied whole, so digits inside them stay
+            let start = i;
+            i += 1;
+            while i < chars.len() && (chars[i].is_alphanumeric() || chars[i] == '_') {
+                i += 1;
+            }
+            out.extend(&chars[start..i]);
+        } else {
+            out.push(c);
+            i += 1;
+        }
+    }
+
+    out.trim().to_string()
+}
+
+/// Execution statistics of one query shape
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct QueryShapeStats {
+    /// Normalized query shape
+    pub fingerprint: String,
+    /// SQL text of the first query seen with this shape, with its literals
+    /// replaced by [`redact`]
+    pub sample: String,
+    /// Number of executions
+    pub calls: u64,
+    /// Number of failed executions
+    pub errors: u64,
+    /// Total rows returned or affected
+    pub rows: u64,
+    /// Total execution time in milliseconds
+    pub total_ms: f64,
+    /// Shortest execution time in milliseconds
+    pub min_ms: f64,
+    /// Longest execution time in milliseconds
+    pub max_ms: f64,
+}
+
+impl QueryShapeStats {
+    /// Average execution time in milliseconds
+    pub fn avg_ms(&self) -> f64 {
+        if self.calls == 0 {
+            0.0
+        } else {
+            self.total_ms / self.calls as f64
+        }
+    }
+}
+
+/// Aggregates execution statistics by query fingerprint
+///
+/// When `max_fingerprints` shapes are tracked, executions of new shapes are
+/// not recorded, so a workload of ad hoc queries cannot grow it unbounded.
+/// [`prune`](Self::prune) frees room by dropping shapes that are no longer
+/// executed.
+pub struct QueryStatsCollector {
+    stats: Mutex<HashMap<String, TrackedShape>>,
+    max_fingerprints: usize,
+}
+
+struct TrackedShape {
+    stats: QueryShapeStats,
+    last_seen: Instant,
+}
+
+impl QueryStatsCollector {
+    /// Create a collector tracking up to `max_fingerprints` query shapes
+    pub fn new(max_fingerprints: usize) -> Self {
+        Self {
+            stats: Mutex::new(HashMap::new()),
+            max_fingerprints,
+        }
+    }
+
+    /// Record one execution of `sql`
+    pub fn record(&self, sql: &str, elapsed: Duration, rows: u64, failed: bool) {
+        let key = fingerprint(sql);
+        let ms = elapsed.as_secs_f64() * 1000.0;
+
+        let mut stats = self.stats.lock().unwrap();
+        if !stats.contains_key(&key) && stats.len() >= self.max_fingerprints {
+            return;
+        }
+
+        let tracked = stats.entry(key.clone()).or_insert_with(|| TrackedShape {
+            stats: QueryShapeStats {
+                fingerprint: key,
+                sample: redact(sql),
+                calls: 0,
+                errors: 0,
+                rows: 0,
+                total_ms: 0.0,
+                min_ms: f64::MAX,
+                max_ms: 0.0,
+            },
+            last_seen: Instant::now(),
+        });
+        tracked.last_seen = Instant::now();
+        let entry = &mut tracked.stats;
+        entry.calls += 1;
+        entry.rows += rows;
+        entry.total_ms += ms;
+        entry.min_ms = entry.min_ms.min(ms);
+        entry.max_ms = entry.max_ms.max(ms);
+        if failed {
+            entry.errors += 1;
+        }
+    }
+
+    /// Statistics for all shapes, by total execution time, largest first
+    pub fn snapshot(&self) -> Vec<QueryShapeStats> {
+        let mut stats: Vec<QueryShapeStats> = self.stats.lock().unwrap().values().map(|t| t.stats.clone()).collect();
+        stats.sort_by(|a, b| b.total_ms.partial_cmp(&a.total_ms).unwrap_or(std::cmp::Ordering::Equal));
+        stats
+    }
+
+    /// Statistics for the shape of `sql`
+    pub fn get(&self, sql: &str) -> Option<QueryShapeStats> {
+        self.stats.lock().unwrap().get(&fingerprint(sql)).map(|t| t.stats.clone())
+    }
+
+    /// Drop shapes not executed within `max_age`, then keep at most
+    /// `max_entries` shapes, those with the largest total execution time.
+    /// Returns the number of shapes dropped.
+    pub fn prune(&self, max_age: Option<Duration>, max_entries: Option<usize>) -> usize {
+        let mut stats = self.stats.lock().unwrap();
+        let before = stats.len();
+        if let Some(max_age) = max_age {
+            stats.retain(|_, tracked| tracked.last_seen.elapsed() <= max_age);
+        }
+        if let Some(max_entries) = max_entries.filter(|max| stats.len() > *max) {
+            let mut by_time: Vec<(String, f64)> = stats.iter()
+                .map(|(key, tracked)| (key.clone(), tracked.stats.total_ms))
+                .collect();
+            by_time.sort_by(|a, b| b.1.partial_cmp(&a.1).unwrap_or(std::cmp::Ordering::Equal));
+            for (key, _) in by_time.into_iter().skip(max_entries) {
+                stats.remove(&key);
+            }
+        }
+        before - stats.len()
+    }
+
+    /// Forget all statistics
+    pub fn reset(&self) {
+        self.stats.lock().unwrap().clear();
+    }
+}
+
+impl Default for QueryStatsCollector {
+    fn default() -> Self {
+        Self::new(DEFAULT_MAX_FINGERPRINTS)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_fingerprint() {
+        assert_eq!(
+            fingerprint("select * from users where id=1 and name = 'bob';"),
+            "SELECT * FROM USERS WHERE ID = ? AND NAME = ?"
+        );
+        assert_eq!(
+            fingerprint("SELECT u.id FROM users u WHERE id IN (1, 2, 3) -- ids\n"),
+            fingerprint("SELECT u.id FROM users u WHERE id IN (?)")
+        );
+        assert_eq!(fingerprint("SELECT \"Name\" FROM t"), "SELECT \"Name\" FROM T");
+    }
+
+    #[test]
+    fn test_redact() {
+        assert_eq!(
+            redact("SELECT * FROM users WHERE email = 'a@b.com' AND id IN (1, 2.5) LIMIT 10 -- token=abc\n"),
+            "SELECT * FROM users WHERE email = '?' AND id IN (0, 0) LIMIT 0"
+        );
+        assert_eq!(
+            redact("SELECT t1.\"col 2\" FROM t1 WHERE a <= ?1 AND b = :name AND c = x'00ff' /* secret */"),
+            "SELECT t1.\"col 2\" FROM t1 WHERE a <= ?1 AND b = :name AND c = x''"
+        );
+        assert_eq!(redact("UPDATE t SET a = 'it''s' WHERE id = 7"), "UPDATE t SET a = '?' WHERE id = 0");
+    }
+
+    #[test]
+    fn test_stats_aggregate_by_shape() {
+        let collector = QueryStatsCollector::new(2);
+        collector.record("SELECT * FROM t WHERE id = 1", Duration::from_millis(10), 1, false);
+        collector.record("SELECT * FROM t WHERE id = 2", Duration::from_millis(30), 1, true);
+        collector.record("DELETE FROM t", Duration::from_millis(5), 3, false);
+        collector.record("UPDATE t SET a = 1", Duration::from_millis(5), 3, false);
+
+        let stats = collector.snapshot();
+        assert_eq!(stats.len(), 2);
+        assert_eq!(stats[0].sample, "SELECT * FROM t WHERE id = 0");
+        assert_eq!((stats[0].calls, stats[0].errors, stats[0].rows), (2, 1, 2));
+        assert!((stats[0].avg_ms() - 20.0).abs() < 1.0);
+        assert_eq!(collector.get("delete from t").map(|s| s.rows), Some(3));
+    }
+
+    #[test]
+    fn test_prune_stats() {
+        let collector = QueryStatsCollector::new(10);
+        collector.record("SELECT * FROM t WHERE id = 1", Duration::from_millis(10), 1, false);
+        collector.record("DELETE FROM t", Duration::from_millis(50), 3, false);
+        collector.record("UPDATE t SET a = 1", Duration::from_millis(5), 3, false);
+
+        assert_eq!(collector.prune(None, Some(2)), 1);
+        assert!(collector.get("UPDATE t SET a = 2").is_none());
+        assert_eq!(collector.prune(Some(Duration::from_secs(60)), None), 0);
+        std::thread::sleep(Duration::from_millis(2));
+        assert_eq!(collector.prune(Some(Duration::from_millis(1)), None), 2);
+        assert!(collector.snapshot().is_empty());
+    }
+}
@@ -0,0 +1,243 @@
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+use serde::{Serialize, Deserialize};
+use crate::query::planner::LogicalPlan;
+
+/// Runtime counters for one plan node
+///
+/// Counters are atomic so operators running on different threads can report
+/// into the same node.
+#[derive(Debug)]
+pub struct NodeRecorder {
+    id: usize,
+    parent: Option<usize>,
+    operator: String,
+    rows: AtomicU64,
+    batches: AtomicU64,
+    elapsed_nanos: AtomicU64,
+    current_memory: AtomicU64,
+    peak_memory: AtomicU64,
+}
+
+impl NodeRecorder {
+    /// Node id within its profile
+    pub fn id(&self) -> usize {
+        self.id
+    }
+
+    /// Record rows produced by the node
+    pub fn add_rows(&self, rows: u64) {
+        self.rows.fetch_add(rows, Ordering::Relaxed);
+        self.batches.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Record time spent in the node
+    pub fn add_elapsed(&self, elapsed: Duration) {
+        self.elapsed_nanos.fetch_add(elapsed.as_nanos() as u64, Ordering::Relaxed);
+    }
+
+    /// Start timing; the elapsed time is recorded when the guard is dropped
+    pub fn start_timer(&self) -> NodeTimer<'_> {
+        NodeTimer {
+            recorder: self,
+            start: Instant::now(),
+        }
+    }
+
+    /// Record memory allocated by the node
+    pub fn allocate(&self, bytes: u64) {
+        let current = self.current_memory.fetch_add(bytes, Ordering::Relaxed) + bytes;
+        self.peak_memory.fetch_max(current, Ordering::Relaxed);
+    }
+
+    /// Record memory released by the node
+    pub fn release(&self, bytes: u64) {
+        let _ = self.current_memory.fetch_update(Ordering::Relaxed, Ordering::Relaxed, |current| {
+            Some(current.saturating_sub(bytes))
+        });
+    }
+
+    /// Take a snapshot of the node's counters
+    pub fn snapshot(&self) -> NodeStats {
+        NodeStats {
+            id: self.id,
+            parent: self.parent,
+            operator: self.operator.clone(),
+            rows: self.rows.load(Ordering::Relaxed),
+            batches: self.batches.load(Ordering::Relaxed),
+            elapsed_ms: self.elapsed_nanos.load(Ordering::Relaxed) as f64 / 1_000_000.0,
+            peak_memory_bytes: self.peak_memory.load(Ordering::Relaxed),
+        }
+    }
+}
+
+/// Records elapsed time for a node when dropped
+pub struct NodeTimer<'a> {
+    recorder: &'a NodeRecorder,
+    start: Instant,
+}
+
+impl Drop for NodeTimer<'_> {
+    fn drop(&mut self) {
+        self.recorder.add_elapsed(self.start.elapsed());
+    }
+}
+
+/// Snapshot of the runtime statistics of one plan node
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct NodeStats {
+    /// Node id, in pre-order of the plan
+    pub id: usize,
+    /// Parent node id
+    pub parent: Option<usize>,
+    /// Operator name
+    pub operator: String,
+    /// Rows produced
+    pub rows: u64,
+    /// Number of times rows were reported
+    pub batches: u64,
+    /// Time spent in the node, including its children if they run inside it
+    pub elapsed_ms: f64,
+    /// Peak memory held by the node
+    pub peak_memory_bytes: u64,
+}
+
+/// Consumer of completed query profiles, e.g. EXPLAIN ANALYZE output or
+/// statistics that refine future cardinality estimates
+pub trait ProfileObserver: Send + Sync {
+    /// Called once when a profiled query finishes
+    fn on_query_complete(&self, sql: &str, stats: &[NodeStats]);
+}
+
+/// Runtime profile of one query execution
+pub struct QueryProfile {
+    sql: String,
+    nodes: Mutex<Vec<Arc<NodeRecorder>>>,
+    observers: Vec<Arc<dyn ProfileObserver>>,
+}
+
+impl QueryProfile {
+    /// Create an empty profile
+    pub fn new(sql: &str) -> Self {
+        Self {
+            sql: sql.to_string(),
+            nodes: Mutex::new(Vec::new()),
+            observers: Vec::new(),
+        }
+    }
+
+    /// Create a profile with one recorder per node of `plan`, in pre-order
+    pub fn for_plan(sql: &str, plan: &LogicalPlan) -> Self {
+        let profile = Self::new(sql);
+        profile.register_plan(plan, None);
+        profile
+    }
+
+    /// Notify `observer` when the profile is finished
+    pub fn with_observer(mut self, observer: Arc<dyn ProfileObserver>) -> Self {
+        self.observers.push(observer);
+        self
+    }
+
+    /// Register a node and return its recorder
+    pub fn register(&self, operator: &str, parent: Option<usize>) -> Arc<NodeRecorder> {
+        let mut nodes = self.nodes.lock().unwrap();
+        let recorder = Arc::new(NodeRecorder {
+            id: nodes.len(),
+            parent,
+            operator: operator.to_string(),
+            rows: AtomicU64::new(0),
+            batches: AtomicU64::new(0),
+            elapsed_nanos: AtomicU64::new(0),
+            current_memory: AtomicU64::new(0),
+            peak_memory: AtomicU64::new(0),
+        });
+        nodes.push(recorder.clone());
+        recorder
+    }
+
+    /// Get the recorder of a registered node
+    pub fn node(&self, id: usize) -> Option<Arc<NodeRecorder>> {
+        self.nodes.lock().unwrap().get(id).cloned()
+    }
+
+    /// Snapshot the statistics of all nodes
+    pub fn snapshot(&self) -> Vec<NodeStats> {
+        self.nodes.lock().unwrap().iter().map(|node| node.snapshot()).collect()
+    }
+
+    /// Finish the profile, notify observers and return the final statistics
+    pub fn finish(self) -> Vec<NodeStats> {
+        let stats = self.snapshot();
+        for observer in &self.observers {
+            observer.on_query_complete(&self.sql, &stats);
+        }
+        stats
+    }
+
+    fn register_plan(&self, plan: &LogicalPlan, parent: Option<usize>) {
+        let id = self.register(&operator_name(plan), parent).id();
+        for child in plan.children() {
+            self.register_plan(child, Some(id));
+        }
+    }
+}
+
+/// Operator name of a plan node, e.g. `Scan(users)`
+pub fn operator_name(plan: &LogicalPlan) -> String {
+    match plan {
+        LogicalPlan::Scan { table, .. } => format!("Scan({})", table),
+        LogicalPlan::Subquery { .. } => "Subquery".to_string(),
+        LogicalPlan::Empty => "Empty".to_string(),
+        LogicalPlan::Join { join_type, .. } => format!("Join({})", join_type),
+        LogicalPlan::Filter { .. } => "Filter".to_string(),
+        LogicalPlan::Aggregate { .. } => "Aggregate".to_string(),
+        LogicalPlan::Projection { .. } => "Projection".to_string(),
+        LogicalPlan::Sort { .. } => "Sort".to_string(),
+        LogicalPlan::Limit { .. } => "Limit".to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::planner::QueryPlanner;
+
+    struct Collector(Mutex<Vec<NodeStats>>);
+
+    impl ProfileObserver for Collector {
+        fn on_query_complete(&self, _sql: &str, stats: &[NodeStats]) {
+            self.0.lock().unwrap().extend_from_slice(stats);
+        }
+    }
+
+    #[test]
+    fn test_profile_for_plan() {
+        let sql = "SELECT a FROM t JOIN u ON t.id = u.id WHERE a > 1";
+        let plan = QueryPlanner::new().plan(sql).unwrap();
+        let collector = Arc::new(Collector(Mutex::new(Vec::new())));
+        let profile = QueryProfile::for_plan(sql, &plan).with_observer(collector.clone());
+
+        let operators: Vec<String> = profile.snapshot().into_iter().map(|s| s.operator).collect();
+        assert_eq!(operators, vec!["Projection", "Filter", "Join(INNER)", "Scan(t)", "Scan(u)"]);
+
+        let scan = profile.node(3).unwrap();
+        assert_eq!(scan.snapshot().parent, Some(2));
+        {
+            let _timer = scan.start_timer();
+            scan.add_rows(10);
+            scan.add_rows(5);
+            scan.allocate(100);
+            scan.release(60);
+            scan.allocate(10);
+        }
+
+        let stats = profile.finish();
+        assert_eq!(stats[3].rows, 15);
+        assert_eq!(stats[3].batches, 2);
+        assert_eq!(stats[3].peak_memory_bytes, 100);
+        assert!(stats[3].elapsed_ms >= 0.0);
+        assert_eq!(collector.0.lock().unwrap().len(), 5);
+    }
+}
@@ -0,0 +1,166 @@
+/// A lexical token of a SQL statement
+#[derive(Debug, Clone, PartialEq)]
+pub enum Token {
+    /// Unquoted keyword or identifier, as written
+    Word(String),
+    /// Quoted identifier ("name", `name` or [name]), as written
+    QuotedIdent(String),
+    /// String literal, without quotes
+    String(String),
+    /// Numeric literal
+    Number(String),
+    /// Bind parameter (?, ?1, :name, @name, $name)
+    Param(String),
+    /// Punctuation or operator character
+    Symbol(char),
+}
+
+impl Token {
+    /// Check whether the token is the given (uppercase) keyword, ignoring case
+    pub fn is_keyword(&self, keyword: &str) -> bool {
+        matches!(self, Token::Word(w) if w.eq_ignore_ascii_case(keyword))
+    }
+}
+
+/// Split a SQL statement into tokens, skipping whitespace and comments
+///
+/// Keywords inside string literals, quoted identifiers and comments never
+/// produce `Word` tokens, and identifiers such as `updated_at` stay a single
+/// word, so callers can match keywords without false positives.
+pub fn tokenize(sql: &str) -> Vec<Token> {
+    scan(sql).into_iter().map(|(token, _)| token).collect()
+}
+
+/// Names written as double-quoted identifiers, in order of appearance
+///
+/// SQLite reads a double-quoted name that matches no table or column as a
+/// string literal, so callers that must rule out inline literals check these
+/// names against the schema. Backtick and bracket quoting never fall back.
+pub fn double_quoted_identifiers(sql: &str) -> Vec<String> {
+    scan(sql).into_iter()
+        .filter_map(|(token, first)| match token {
+            Token::QuotedIdent(name) if first == '"' => Some(name),
+            _ => None,
+        })
+        .collect()
+}
+
+/// Tokenize, pairing each token with the first character it was read from
+fn scan(sql: &str) -> Vec<(Token, char)> {
+    let chars: Vec<char> = sql.chars().collect();
+    let mut tokens = Vec::new();
+    let mut i = 0;
+
+    while i < chars.len() {
+        let c = chars[i];
+
+        if c.is_whitespace() {
+            i += 1;
+        } else if c == '-' && chars.get(i + 1) == Some(&'-') {
+            // Line comment
+            while i < chars.len() && chars[i] != '\n' {
+                i += 1;
+            }
+        } else if c == '/' && chars.get(i + 1) == Some(&'*') {
+            // Block comment
+            i += 2;
+            while i < chars.len() && !(chars[i] == '*' && chars.get(i + 1) == Some(&'/')) {
+                i += 1;
+            }
+            i += 2;
+        } else if c == '\'' {
+            let (text, next) = read_quoted(&chars, i, '\'');
+            tokens.push((Token::String(text), c));
+            i = next;
+        } else if c == '"' || c == '`' {
+            let (text, next) = read_quoted(&chars, i, c);
+            tokens.push((Token::QuotedIdent(text), c));
+            i = next;
+        } else if c == '[' {
+            let (text, next) = read_quoted(&chars, i, ']');
+            tokens.push((Token::QuotedIdent(text), c));
+            i = next;
+        } else if c.is_ascii_digit() || (c == '.' && chars.get(i + 1).map_or(false, |n| n.is_ascii_digit())) {
+            let start = i;
+            while i < chars.len() && (chars[i].is_ascii_alphanumeric() || chars[i] == '.') {
+                i += 1;
+            }
+            tokens.push((Token::Number(chars[start..i].iter().collect()), c));
+        } else if c == '?' || ((c == ':' || c == '@' || c == '$') && chars.get(i + 1).map_or(false, |n| is_word_char(*n))) {
+            let start = i;
+            i += 1;
+            while i < chars.len() && is_word_char(chars[i]) {
+                i += 1;
+            }
+            tokens.push((Token::Param(chars[start..i].iter().collect()), c));
+        } else if is_word_char(c) {
+            let start = i;
+            while i < chars.len() && is_word_char(chars[i]) {
+                i += 1;
+            }
+            let word: String = chars[start..i].iter().collect();
+            tokens.push((Token::Word(word), c));
+        } else {
+            tokens.push((Token::Symbol(c), c));
+            i += 1;
+        }
+    }
+
+    tokens
+}
+
+/// Characters allowed in unquoted identifiers and keywords
+fn is_word_char(c: char) -> bool {
+    c.is_alphanumeric() || c == '_'
+}
+
+/// Read a quoted section starting at `start`; a doubled closing quote is an escape
+pub(crate) fn read_quoted(chars: &[char], start: usize, close: char) -> (String, usize) {
+    let mut text = String::new();
+    let mut i = start + 1;
+
+    while i < chars.len() {
+        if chars[i] == close {
+            if close != ']' && chars.get(i + 1) == Some(&close) {
+                text.push(close);
+                i += 2;
+                continue;
+            }
+            return (text, i + 1);
+        }
+        text.push(chars[i]);
+        i += 1;
+    }
+
+    (text, i)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_tokenize() {
+        let tokens = tokenize("SELECT updated_at, 'it''s UPDATE' FROM \"order\" -- DELETE\nwhere id = ?1 /* JOIN */");
+
+        assert_eq!(tokens, vec![
+            Token::Word("SELECT".to_string()),
+            Token::Word("updated_at".to_string()),
+            Token::Symbol(','),
+            Token::String("it's UPDATE".to_string()),
+            Token::Word("FROM".to_string()),
+            Token::QuotedIdent("order".to_string()),
+            Token::Word("where".to_string()),
+            Token::Word("id".to_string()),
+            Token::Symbol('='),
+            Token::Param("?1".to_string()),
+        ]);
+        assert!(tokens[6].is_keyword("WHERE"));
+    }
+
+    #[test]
+    fn test_double_quoted_identifiers() {
+        let names = double_quoted_identifiers("SELECT \"a\"\"b\", `c`, [d], 'e' FROM \"t\" -- \"f\"");
+        assert_eq!(names, vec!["a\"b".to_string(), "t".to_string()]);
+    }
+}
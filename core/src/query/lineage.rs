@@ -0,0 +1,440 @@
+use std::collections::HashMap;
+use serde::{Serialize, Deserialize};
+use crate::query::lexer::{tokenize, Token};
+
+/// Keywords that can appear inside a projection expression but are never
+/// column references
+const EXPRESSION_KEYWORDS: &[&str] = &[
+    "AND", "OR", "NOT", "NULL", "IS", "IN", "LIKE", "GLOB", "BETWEEN", "CASE", "WHEN",
+    "THEN", "ELSE", "END", "CAST", "AS", "DISTINCT", "ALL", "TRUE", "FALSE", "ESCAPE",
+    "COLLATE", "EXISTS", "OVER", "PARTITION", "BY", "ORDER", "ASC", "DESC", "FILTER",
+    "CURRENT_DATE", "CURRENT_TIME", "CURRENT_TIMESTAMP", "INTEGER", "TEXT", "REAL",
+    "BLOB", "NUMERIC", "VARCHAR", "INT", "BIGINT", "DOUBLE", "FLOAT", "BOOLEAN", "DATE",
+];
+
+/// Keywords that end the FROM clause
+const FROM_TERMINATORS: &[&str] = &[
+    "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "UNION", "INTERSECT", "EXCEPT", "WINDOW",
+    "RETURNING",
+];
+
+/// A column read by a projection expression
+#[derive(Debug, Clone, PartialEq, Eq, Hash, Serialize, Deserialize)]
+pub struct SourceColumn {
+    /// Source table, `None` when the column cannot be attributed to a single table
+    pub table: Option<String>,
+    /// Column name, `*` for wildcard projections
+    pub column: String,
+}
+
+/// How a target column is derived from its sources
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum LineageKind {
+    /// Copied unchanged from a single source column
+    Direct,
+    /// Computed by an expression over zero or more source columns
+    Expression,
+}
+
+/// Lineage of one target column
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ColumnLineage {
+    pub target_table: String,
+    /// Target column, `None` for an INSERT without a column list; the caller
+    /// can resolve it from the table schema using `position`
+    pub target_column: Option<String>,
+    /// Zero-based position in the projection
+    pub position: usize,
+    pub sources: Vec<SourceColumn>,
+    pub kind: LineageKind,
+    /// Projection expression as written, normalized to single spaces
+    pub expression: String,
+}
+
+/// Extract column-level lineage from an `INSERT ... SELECT` or
+/// `CREATE TABLE ... AS SELECT` statement
+///
+/// Returns `None` for other statements. Columns are attributed through
+/// table aliases in the top-level FROM clause; unqualified columns are
+/// attributed only when the query reads a single table. Names are
+/// lowercased with quotes and schema prefixes removed.
+pub fn extract_column_lineage(sql: &str) -> Option<Vec<ColumnLineage>> {
+    let tokens = tokenize(sql);
+    let (target_table, target_columns, select_start) = parse_target(&tokens)?;
+
+    let select = &tokens[select_start..];
+    let from = top_level_position(select, |t| t.is_keyword("FROM"));
+    let mut projection_start = 1;
+    while select.get(projection_start).map_or(false, |t| t.is_keyword("DISTINCT") || t.is_keyword("ALL")) {
+        projection_start += 1;
+    }
+    let projection = &select[projection_start..from.unwrap_or(select.len())];
+    let aliases = match from {
+        Some(from) => parse_from(&select[from + 1..]),
+        None => HashMap::new(),
+    };
+    let single_table = {
+        let mut tables: Vec<&String> = aliases.values().flatten().collect();
+        tables.sort();
+        tables.dedup();
+        if tables.len() == 1 && aliases.values().all(|t| t.is_some()) { Some(tables[0].clone()) } else { None }
+    };
+
+    let lineage = split_top_level(projection)
+        .into_iter()
+        .enumerate()
+        .map(|(position, item)| {
+            let (expression, alias) = split_alias(item);
+            let sources = column_references(expression, &aliases, single_table.as_deref());
+            let direct = is_column_reference(expression);
+            let target_column = match &target_columns {
+                Some(columns) => columns.get(position).cloned(),
+                // CREATE TABLE AS names columns after the alias or the column
+                None if target_table.1 => alias.or_else(|| if direct { sources.first().map(|s| s.column.clone()) } else { None }),
+                None => None,
+            };
+            ColumnLineage {
+                target_table: target_table.0.clone(),
+                target_column,
+                position,
+                kind: if direct { LineageKind::Direct } else { LineageKind::Expression },
+                sources,
+                expression: render(expression),
+            }
+        })
+        .collect();
+
+    Some(lineage)
+}
+
+/// Target table (and whether the statement is CREATE TABLE AS), the INSERT
+/// column list, and the index of the SELECT keyword
+fn parse_target(tokens: &[Token]) -> Option<((String, bool), Option<Vec<String>>, usize)> {
+    let first = tokens.first()?;
+    if first.is_keyword("INSERT") || first.is_keyword("REPLACE") {
+        let into = tokens.iter().position(|t| t.is_keyword("INTO"))?;
+        let (table, mut idx) = qualified_name(tokens, into + 1)?;
+        let mut columns = None;
+        if tokens.get(idx) == Some(&Token::Symbol('(')) {
+            let close = matching_paren(tokens, idx)?;
+            columns = Some(
+                split_top_level(&tokens[idx + 1..close]).iter()
+                    .filter_map(|item| item.first().and_then(identifier))
+                    .collect(),
+            );
+            idx = close + 1;
+        }
+        tokens.get(idx).filter(|t| t.is_keyword("SELECT"))?;
+        Some(((table, false), columns, idx))
+    } else if first.is_keyword("CREATE") {
+        let table_kw = tokens.iter().position(|t| t.is_keyword("TABLE"))?;
+        let mut idx = table_kw + 1;
+        while tokens.get(idx).map_or(false, |t| t.is_keyword("IF") || t.is_keyword("NOT") || t.is_keyword("EXISTS")) {
+            idx += 1;
+        }
+        let (table, idx) = qualified_name(tokens, idx)?;
+        tokens.get(idx).filter(|t| t.is_keyword("AS"))?;
+        let mut select = idx + 1;
+        if tokens.get(select) == Some(&Token::Symbol('(')) {
+            select += 1;
+        }
+        tokens.get(select).filter(|t| t.is_keyword("SELECT"))?;
+        Some(((table, true), None, select))
+    } else {
+        None
+    }
+}
+
+/// Read a possibly schema-qualified name at `idx`, returning the last part
+fn qualified_name(tokens: &[Token], mut idx: usize) -> Option<(String, usize)> {
+    let mut name = identifier(tokens.get(idx)?)?;
+    idx += 1;
+    while tokens.get(idx) == Some(&Token::Symbol('.')) {
+        name = identifier(tokens.get(idx + 1)?)?;
+        idx += 2;
+    }
+    Some((name, idx))
+}
+
+/// Lowercased identifier name of a word or quoted identifier
+fn identifier(token: &Token) -> Option<String> {
+    match token {
+        Token::Word(w) | Token::QuotedIdent(w) => Some(w.to_lowercase()),
+        _ => None,
+    }
+}
+
+fn matching_paren(tokens: &[Token], open: usize) -> Option<usize> {
+    let mut depth = 0;
+    for (i, token) in tokens.iter().enumerate().skip(open) {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(i);
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Position of the first token at parenthesis depth zero matching `pred`
+fn top_level_position(tokens: &[Token], pred: impl Fn(&Token) -> bool) -> Option<usize> {
+    let mut depth = 0;
+    for (i, token) in tokens.iter().enumerate() {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            _ if depth == 0 && pred(token) => return Some(i),
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Split tokens on commas at parenthesis depth zero
+fn split_top_level(tokens: &[Token]) -> Vec<&[Token]> {
+    let mut items = Vec::new();
+    let mut depth = 0;
+    let mut start = 0;
+    for (i, token) in tokens.iter().enumerate() {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            Token::Symbol(',') if depth == 0 => {
+                items.push(&tokens[start..i]);
+                start = i + 1;
+            }
+            _ => {}
+        }
+    }
+    if start < tokens.len() {
+        items.push(&tokens[start..]);
+    }
+    items
+}
+
+/// Split a projection item into its expression and optional alias
+fn split_alias(item: &[Token]) -> (&[Token], Option<String>) {
+    let len = item.len();
+    if len >= 3 && item[len - 2].is_keyword("AS") {
+        return (&item[..len - 2], identifier(&item[len - 1]));
+    }
+    // Implicit alias: an identifier directly after an identifier or `)`
+    if len >= 2 {
+        let last_is_name = matches!(&item[len - 1], Token::Word(w) if !is_expression_keyword(w))
+            || matches!(item[len - 1], Token::QuotedIdent(_));
+        let prev_ends_expr = matches!(&item[len - 2], Token::Word(w) if !is_expression_keyword(w))
+            || matches!(item[len - 2], Token::QuotedIdent(_) | Token::Symbol(')') | Token::String(_) | Token::Number(_));
+        if last_is_name && prev_ends_expr {
+            return (&item[..len - 1], identifier(&item[len - 1]));
+        }
+    }
+    (item, None)
+}
+
+/// Map of alias (or table name) to table, `None` for subqueries
+fn parse_from(tokens: &[Token]) -> HashMap<String, Option<String>> {
+    let end = top_level_position(tokens, |t| FROM_TERMINATORS.iter().any(|k| t.is_keyword(k)))
+        .unwrap_or(tokens.len());
+    let tokens = &tokens[..end];
+    let mut aliases = HashMap::new();
+    let mut expect_table = true;
+    let mut i = 0;
+
+    while i < tokens.len() {
+        match &tokens[i] {
+            Token::Symbol('(') => {
+                let close = matching_paren(tokens, i).unwrap_or(tokens.len() - 1);
+                i = close + 1;
+                // Subquery source: its alias follows the closing parenthesis
+                if expect_table {
+                    let (alias, next) = read_alias(tokens, i);
+                    if let Some(alias) = alias {
+                        aliases.insert(alias, None);
+                    }
+                    i = next;
+                    expect_table = false;
+                }
+                continue;
+            }
+            Token::Symbol(',') => expect_table = true,
+            token if token.is_keyword("JOIN") => expect_table = true,
+            Token::Word(_) | Token::QuotedIdent(_) if expect_table => {
+                if let Some((table, next)) = qualified_name(tokens, i) {
+                    let (alias, next) = read_alias(tokens, next);
+                    aliases.insert(table.clone(), Some(table.clone()));
+                    if let Some(alias) = alias {
+                        aliases.insert(alias, Some(table));
+                    }
+                    i = next;
+                    expect_table = false;
+                    continue;
+                }
+            }
+            // Join modifiers and ON/USING conditions
+            _ => {}
+        }
+        i += 1;
+    }
+    aliases
+}
+
+/// Read an optional `[AS] alias` at `idx`
+fn read_alias(tokens: &[Token], idx: usize) -> (Option<String>, usize) {
+    let idx = if tokens.get(idx).map_or(false, |t| t.is_keyword("AS")) { idx + 1 } else { idx };
+    match tokens.get(idx) {
+        Some(Token::Word(w)) if !is_join_modifier(w)
+            && !["JOIN", "ON", "USING"].iter().any(|k| w.eq_ignore_ascii_case(k))
+            && !FROM_TERMINATORS.iter().any(|k| w.eq_ignore_ascii_case(k)) => (Some(w.to_lowercase()), idx + 1),
+        Some(Token::QuotedIdent(w)) => (Some(w.to_lowercase()), idx + 1),
+        _ => (None, idx),
+    }
+}
+
+fn is_join_modifier(word: &str) -> bool {
+    ["LEFT", "RIGHT", "FULL", "INNER", "OUTER", "CROSS", "NATURAL"].iter().any(|k| word.eq_ignore_ascii_case(k))
+}
+
+fn is_expression_keyword(word: &str) -> bool {
+    EXPRESSION_KEYWORDS.iter().any(|k| word.eq_ignore_ascii_case(k))
+}
+
+/// Whether the expression is a bare or qualified column reference
+fn is_column_reference(expression: &[Token]) -> bool {
+    let is_name = |t: &Token| matches!(t, Token::Word(w) if !is_expression_keyword(w)) || matches!(t, Token::QuotedIdent(_));
+    match expression {
+        [column] => is_name(column) || *column == Token::Symbol('*'),
+        [table, Token::Symbol('.'), column] => is_name(table) && (is_name(column) || *column == Token::Symbol('*')),
+        _ => false,
+    }
+}
+
+/// Columns referenced by an expression, in order of first appearance
+fn column_references(expression: &[Token], aliases: &HashMap<String, Option<String>>, single_table: Option<&str>) -> Vec<SourceColumn> {
+    let mut sources: Vec<SourceColumn> = Vec::new();
+    let mut push = |source: SourceColumn| {
+        if !sources.contains(&source) {
+            sources.push(source);
+        }
+    };
+
+    let mut i = 0;
+    while i < expression.len() {
+        let token = &expression[i];
+        let next = expression.get(i + 1);
+        match token {
+            Token::Symbol('*') if expression.len() == 1 => {
+                push(SourceColumn { table: single_table.map(str::to_string), column: "*".to_string() });
+            }
+            Token::Word(_) | Token::QuotedIdent(_) if next == Some(&Token::Symbol('.')) => {
+                let qualifier = identifier(token).unwrap_or_default();
+                let column = match expression.get(i + 2) {
+                    Some(Token::Symbol('*')) => Some("*".to_string()),
+                    Some(t) => identifier(t),
+                    None => None,
+                };
+                if let Some(column) = column {
+                    let table = aliases.get(&qualifier).cloned().unwrap_or(Some(qualifier));
+                    push(SourceColumn { table, column });
+                }
+                i += 3;
+                continue;
+            }
+            // Function names are followed by an opening parenthesis
+            Token::Word(w) if next != Some(&Token::Symbol('(')) && !is_expression_keyword(w) => {
+                // The type name in CAST(x AS type) is not a column
+                if i > 0 && expression[i - 1].is_keyword("AS") {
+                    i += 1;
+                    continue;
+                }
+                push(SourceColumn { table: single_table.map(str::to_string), column: w.to_lowercase() });
+            }
+            Token::QuotedIdent(w) => {
+                push(SourceColumn { table: single_table.map(str::to_string), column: w.to_lowercase() });
+            }
+            _ => {}
+        }
+        i += 1;
+    }
+    sources
+}
+
+/// Render tokens back to text with single spaces
+fn render(tokens: &[Token]) -> String {
+    let mut out = String::new();
+    for (i, token) in tokens.iter().enumerate() {
+        let text = match token {
+            Token::Word(w) | Token::Number(w) | Token::Param(w) => w.clone(),
+            Token::QuotedIdent(w) => format!("\"{}\"", w),
+            Token::String(s) => format!("'{}'", s.replace('\'', "''")),
+            Token::Symbol(c) => c.to_string(),
+        };
+        let tight = i == 0
+            || matches!(token, Token::Symbol(',') | Token::Symbol(')') | Token::Symbol('.') | Token::Symbol('('))
+            || matches!(tokens[i - 1], Token::Symbol('(') | Token::Symbol('.'));
+        if !tight {
+            out.push(' ');
+        }
+        out.push_str(&text);
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn source(table: &str, column: &str) -> SourceColumn {
+        SourceColumn { table: Some(table.to_string()), column: column.to_string() }
+    }
+
+    #[test]
+    fn test_insert_select_lineage() {
+        let lineage = extract_column_lineage(
+            "INSERT INTO daily_sales (day, customer, total) \
+             SELECT date(o.created_at), c.name, SUM(o.amount * o.qty) \
+             FROM orders o JOIN customers AS c ON c.id = o.customer_id GROUP BY 1, 2"
+        ).unwrap();
+
+        assert_eq!(lineage.len(), 3);
+        assert_eq!(lineage[0].target_column.as_deref(), Some("day"));
+        assert_eq!(lineage[0].sources, vec![source("orders", "created_at")]);
+        assert_eq!(lineage[0].kind, LineageKind::Expression);
+        assert_eq!(lineage[1].sources, vec![source("customers", "name")]);
+        assert_eq!(lineage[1].kind, LineageKind::Direct);
+        assert_eq!(lineage[2].sources, vec![source("orders", "amount"), source("orders", "qty")]);
+        assert_eq!(lineage[2].expression, "SUM(o.amount * o.qty)");
+    }
+
+    #[test]
+    fn test_create_table_as_lineage() {
+        let lineage = extract_column_lineage(
+            "CREATE TABLE IF NOT EXISTS active_users AS SELECT id, upper(email) AS email_upper, CAST(age AS INTEGER) years FROM main.users WHERE active = 1"
+        ).unwrap();
+
+        assert_eq!(lineage[0].target_table, "active_users");
+        assert_eq!(lineage[0].target_column.as_deref(), Some("id"));
+        assert_eq!(lineage[0].sources, vec![source("users", "id")]);
+        assert_eq!(lineage[1].target_column.as_deref(), Some("email_upper"));
+        assert_eq!(lineage[1].sources, vec![source("users", "email")]);
+        assert_eq!(lineage[2].target_column.as_deref(), Some("years"));
+        assert_eq!(lineage[2].sources, vec![source("users", "age")]);
+    }
+
+    #[test]
+    fn test_unattributed_and_unsupported() {
+        // Unqualified columns over several tables cannot be attributed
+        let lineage = extract_column_lineage("INSERT INTO t SELECT a, b.c FROM x, y AS b").unwrap();
+        assert_eq!(lineage[0].target_column, None);
+        assert_eq!(lineage[0].sources, vec![SourceColumn { table: None, column: "a".to_string() }]);
+        assert_eq!(lineage[1].sources, vec![source("y", "c")]);
+
+        assert!(extract_column_lineage("INSERT INTO t VALUES (1, 2)").is_none());
+        assert!(extract_column_lineage("SELECT * FROM t").is_none());
+    }
+}
@@ -0,0 +1,236 @@
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+use crate::{LumosError, Result};
+use crate::query::lexer::{tokenize, Token};
+
+/// Prefix of the DuckDB tables holding view results, so views cannot replace user tables
+const VIEW_TABLE_PREFIX: &str = "_lumos_mv_";
+
+/// When a materialized view is refreshed
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum RefreshPolicy {
+    /// Only when refreshed explicitly
+    Manual,
+    /// When the given time has passed since the last refresh
+    Interval(Duration),
+    /// After a write to one of the view's base tables
+    OnWrite,
+}
+
+/// A SELECT whose result is stored as a DuckDB table
+#[derive(Debug, Clone)]
+pub struct MaterializedView {
+    /// View name
+    pub name: String,
+    /// Name of the DuckDB table holding the result
+    pub table: String,
+    /// Defining SELECT
+    pub sql: String,
+    /// Lowercased names of the tables the SELECT reads
+    pub base_tables: Vec<String>,
+    /// Refresh policy
+    pub policy: RefreshPolicy,
+    /// Time of the last refresh, `None` before the first one
+    pub last_refresh: Option<Instant>,
+    /// Whether a base table was written since the last refresh
+    pub stale: bool,
+    /// Token form of the SELECT, used to recognize matching queries
+    key: Vec<Token>,
+}
+
+impl MaterializedView {
+    /// Whether the view needs a refresh at `now`
+    pub fn is_due(&self, now: Instant) -> bool {
+        match (self.policy, self.last_refresh) {
+            (_, None) => true,
+            (RefreshPolicy::Manual, _) => false,
+            (RefreshPolicy::OnWrite, _) => self.stale,
+            (RefreshPolicy::Interval(interval), Some(last)) => now.duration_since(last) >= interval,
+        }
+    }
+}
+
+/// Registry of materialized views
+///
+/// The registry only tracks views; `QueryExecutor` materializes them in
+/// DuckDB. A query whose text matches a fresh view's SELECT, ignoring case,
+/// whitespace and comments, is rewritten to read the view instead.
+#[derive(Default)]
+pub struct MaterializedViewManager {
+    views: Mutex<HashMap<String, MaterializedView>>,
+}
+
+impl MaterializedViewManager {
+    /// Create an empty registry
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Register a view; an existing view with the same name is replaced
+    pub fn register(&self, name: &str, sql: &str, policy: RefreshPolicy) -> Result<MaterializedView> {
+        if name.is_empty() || !name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+            return Err(LumosError::InvalidArgument(format!("Invalid materialized view name: '{}'", name)));
+        }
+
+        let key = view_key(sql);
+        if !key.first().map_or(false, |t| t.is_keyword("SELECT") || t.is_keyword("WITH")) {
+            return Err(LumosError::InvalidArgument("A materialized view must be defined by a SELECT".to_string()));
+        }
+
+        let view = MaterializedView {
+            name: name.to_string(),
+            table: format!("{}{}", VIEW_TABLE_PREFIX, name),
+            sql: sql.trim().trim_end_matches(';').to_string(),
+            base_tables: base_tables(&key),
+            policy,
+            last_refresh: None,
+            stale: true,
+            key,
+        };
+        self.views.lock().unwrap().insert(name.to_string(), view.clone());
+        Ok(view)
+    }
+
+    /// Remove a view from the registry
+    pub fn unregister(&self, name: &str) -> Option<MaterializedView> {
+        self.views.lock().unwrap().remove(name)
+    }
+
+    /// Get a view by name
+    pub fn get(&self, name: &str) -> Option<MaterializedView> {
+        self.views.lock().unwrap().get(name).cloned()
+    }
+
+    /// List all views
+    pub fn list(&self) -> Vec<MaterializedView> {
+        self.views.lock().unwrap().values().cloned().collect()
+    }
+
+    /// Mark views reading any of `tables` as stale, returning their names
+    pub fn note_write(&self, tables: &[String]) -> Vec<String> {
+        let mut stale = Vec::new();
+        for view in self.views.lock().unwrap().values_mut() {
+            if view.base_tables.iter().any(|t| tables.iter().any(|w| w.eq_ignore_ascii_case(t))) {
+                view.stale = true;
+                stale.push(view.name.clone());
+            }
+        }
+        stale
+    }
+
+    /// Names of views that need a refresh at `now`
+    pub fn due(&self, now: Instant) -> Vec<String> {
+        self.views.lock().unwrap()
+            .values()
+            .filter(|view| view.is_due(now))
+            .map(|view| view.name.clone())
+            .collect()
+    }
+
+    /// Record a successful refresh
+    pub fn mark_refreshed(&self, name: &str) {
+        if let Some(view) = self.views.lock().unwrap().get_mut(name) {
+            view.last_refresh = Some(Instant::now());
+            view.stale = false;
+        }
+    }
+
+    /// Rewrite `sql` to read a fresh view with the same SELECT
+    pub fn rewrite(&self, sql: &str) -> Option<String> {
+        let key = view_key(sql);
+        self.views.lock().unwrap()
+            .values()
+            .find(|view| view.key == key && view.last_refresh.is_some() && !view.stale)
+            .map(|view| format!("SELECT * FROM {}", view.table))
+    }
+}
+
+/// Tokens of a statement with keywords uppercased and trailing semicolons dropped
+fn view_key(sql: &str) -> Vec<Token> {
+    let mut tokens: Vec<Token> = tokenize(sql)
+        .into_iter()
+        .map(|token| match token {
+            Token::Word(word) => Token::Word(word.to_uppercase()),
+            other => other,
+        })
+        .collect();
+    while tokens.last() == Some(&Token::Symbol(';')) {
+        tokens.pop();
+    }
+    tokens
+}
+
+/// Tables named after FROM or JOIN, and in comma-separated FROM lists
+fn base_tables(tokens: &[Token]) -> Vec<String> {
+    const CLAUSE_END: [&str; 8] = ["WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "UNION", "ON", "USING"];
+
+    let mut tables: Vec<String> = Vec::new();
+    let mut in_from = false;
+    let mut expect_table = false;
+
+    for token in tokens {
+        let name = match token {
+            Token::Word(word) if word == "FROM" || word == "JOIN" => {
+                in_from = true;
+                expect_table = true;
+                continue;
+            }
+            Token::Word(word) if CLAUSE_END.contains(&word.as_str()) => {
+                in_from = false;
+                expect_table = false;
+                continue;
+            }
+            Token::Symbol(',') if in_from => {
+                expect_table = true;
+                continue;
+            }
+            Token::Word(word) | Token::QuotedIdent(word) if expect_table => word,
+            _ => {
+                expect_table = false;
+                continue;
+            }
+        };
+
+        expect_table = false;
+        // Drop a schema prefix such as main.orders
+        let table = name.rsplit('.').next().unwrap_or(name).to_lowercase();
+        if !tables.contains(&table) {
+            tables.push(table);
+        }
+    }
+
+    tables
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_register_refresh_and_rewrite() {
+        let manager = MaterializedViewManager::new();
+        let view = manager.register(
+            "daily_sales",
+            "SELECT day, SUM(amount) FROM orders o JOIN customers c ON o.cid = c.id GROUP BY day;",
+            RefreshPolicy::OnWrite,
+        ).unwrap();
+        assert_eq!(view.base_tables, vec!["orders", "customers"]);
+        assert!(manager.register("bad name", "SELECT 1", RefreshPolicy::Manual).is_err());
+        assert!(manager.register("v", "DELETE FROM orders", RefreshPolicy::Manual).is_err());
+
+        // Not used before the first refresh
+        let query = "select day, sum(amount)\n  from orders o join customers c on o.cid = c.id group by day";
+        assert_eq!(manager.rewrite(query), None);
+        assert_eq!(manager.due(Instant::now()), vec!["daily_sales"]);
+
+        manager.mark_refreshed("daily_sales");
+        assert_eq!(manager.rewrite(query).as_deref(), Some("SELECT * FROM _lumos_mv_daily_sales"));
+        assert!(manager.due(Instant::now()).is_empty());
+
+        // A write to a base table makes it stale until refreshed again
+        assert_eq!(manager.note_write(&["ORDERS".to_string()]), vec!["daily_sales"]);
+        assert_eq!(manager.rewrite(query), None);
+        assert_eq!(manager.due(Instant::now()), vec!["daily_sales"]);
+    }
+}
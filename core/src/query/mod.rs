@@ -1,8 +1,21 @@
+pub mod lexer;
 pub mod parser;
+pub mod planner;
+pub mod instrument;
+pub mod pushdown;
+pub mod prepared;
+pub mod matview;
+pub mod pinned;
+pub mod fingerprint;
+pub mod lineage;
+pub mod stats;
 pub mod router;
 pub mod executor;
 
 use std::fmt;
+use std::sync::Arc;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::Duration;
 use crate::{LumosError, Result, sqlite::SqliteEngine, duckdb::DuckDbEngine};
 use serde::{Serialize, Deserialize};
 use serde_json::Value as JsonValue;
@@ -44,7 +57,7 @@ impl fmt::Display for QueryType {
 }
 
 /// Database engine type
-#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
 pub enum EngineType {
     /// SQLite engine for transactional queries
     Sqlite,
@@ -132,6 +145,14 @@ pub struct Query {
     pub query_type: QueryType,
     /// Target engine
     pub engine_type: EngineType,
+    /// Maximum execution time
+    ///
+    /// On SQLite the running statement is interrupted when it is exceeded.
+    /// The DuckDB bindings cannot interrupt a statement, so on DuckDB the
+    /// deadline is only checked between result rows: a write, or a long
+    /// aggregation that returns few rows, runs to completion before the
+    /// timeout is reported.
+    pub timeout: Option<Duration>,
 }
 
 impl Query {
@@ -143,6 +164,7 @@ impl Query {
             params: Vec::new(),
             query_type: QueryType::Other, // Will be set by the parser
             engine_type: EngineType::Auto,
+            timeout: None,
         }
     }
 
@@ -165,6 +187,13 @@ impl Query {
         self.engine_type = engine_type;
         self
     }
+
+    /// Set the maximum execution time for the query, see [`Query::timeout`]
+    /// for how it is enforced on each engine
+    pub fn timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = Some(timeout);
+        self
+    }
 }
 
 /// Result of a query execution
@@ -247,6 +276,20 @@ pub struct QueryExecutor {
     parser: parser::QueryParser,
     /// Query router
     router: router::QueryRouter,
+    /// Prepared statements keyed by SQL text
+    prepared: prepared::PreparedStatementCache,
+    /// Execution time limit for queries that do not set their own
+    default_timeout: Option<Duration>,
+    /// Number of queries that exceeded their execution time limit
+    timeouts: AtomicU64,
+    /// Materialized views stored in DuckDB
+    views: matview::MaterializedViewManager,
+    /// Tables pinned in memory as DuckDB copies
+    pinned: pinned::PinnedTableManager,
+    /// Execution statistics by query fingerprint
+    stats: fingerprint::QueryStatsCollector,
+    /// Table and column statistics for row estimates
+    table_stats: stats::StatsCollector,
 }
 
 impl QueryExecutor {
@@ -257,16 +300,49 @@ impl QueryExecutor {
             duckdb,
             parser: parser::QueryParser::new(),
             router: router::QueryRouter::new(),
+            prepared: prepared::PreparedStatementCache::default(),
+            default_timeout: None,
+            timeouts: AtomicU64::new(0),
+            views: matview::MaterializedViewManager::new(),
+            pinned: pinned::PinnedTableManager::default(),
+            stats: fingerprint::QueryStatsCollector::default(),
+            table_stats: stats::StatsCollector::default(),
         }
     }
 
+    /// Set the execution time limit for queries that do not set their own
+    ///
+    /// DuckDB statements are not interrupted; see [`Query::timeout`].
+    pub fn set_default_timeout(&mut self, timeout: Option<Duration>) {
+        self.default_timeout = timeout;
+    }
+
+    /// Number of queries that exceeded their execution time limit
+    pub fn timeout_count(&self) -> u64 {
+        self.timeouts.load(Ordering::Relaxed)
+    }
+
     /// Execute an SQL query and return the result
     pub fn execute(&mut self, query: Query) -> Result<QueryResult> {
         let mut query = query;
         
+        // INSTALL and LOAD go through the extension policy, never straight to DuckDB
+        let extension_statements = crate::duckdb::extensions::ExtensionStatement::find_all(&query.sql);
+        if !extension_statements.is_empty() {
+            return self.apply_extension_statements(&query.sql, &extension_statements);
+        }
+        
         // Parse the query to determine the query type
         query.query_type = self.parser.parse_query_type(&query.sql)?;
         
+        // Read a fresh materialized view instead of recomputing its SELECT
+        if query.query_type == QueryType::Select && query.engine_type != EngineType::Sqlite {
+            if let Some(rewritten) = self.views.rewrite(&query.sql) {
+                query.sql = rewritten;
+                query.engine_type = EngineType::DuckDb;
+            }
+        }
+        
         // Use the router to determine the best engine
         let engine = if query.engine_type == EngineType::Auto {
             self.router.route(&query)?
@@ -274,15 +350,274 @@ impl QueryExecutor {
             query.engine_type
         };
         
-        // Execute based on the selected engine
+        // Analytical reads of pinned tables scan their in-memory copies
+        if query.query_type == QueryType::Select && engine == EngineType::DuckDb {
+            for table in self.pinned.referenced(&query.sql).into_iter().filter(|t| !t.is_fresh()) {
+                if let Err(e) = self.load_pinned_table(&table) {
+                    log::warn!("Failed to reload pinned table '{}': {}", table.table, e);
+                }
+            }
+            if let Some(rewritten) = self.pinned.rewrite(&query.sql) {
+                query.sql = rewritten;
+            }
+        }
+        
+        self.run(engine, &query)
+    }
+
+    /// Install or load extensions in the DuckDB engine as allowed by its policy
+    ///
+    /// Engines without a policy fall back to `DEFAULT_ALLOWED_EXTENSIONS`.
+    /// Extension statements must be submitted on their own so they cannot be
+    /// mixed into a batch that DuckDB would run directly.
+    fn apply_extension_statements(&self, sql: &str, statements: &[crate::duckdb::extensions::ExtensionStatement]) -> Result<QueryResult> {
+        let statement_count = lexer::tokenize(sql)
+            .split(|token| *token == lexer::Token::Symbol(';'))
+            .filter(|statement| !statement.is_empty())
+            .count();
+        if statement_count != statements.len() {
+            return Err(LumosError::InvalidArgument(
+                "INSTALL and LOAD cannot be combined with other statements".to_string(),
+            ));
+        }
+
+        let policy = self.duckdb.extension_policy()
+            .cloned()
+            .unwrap_or_else(crate::duckdb::extensions::ExtensionPolicy::with_default_allowlist);
+        let conn = self.duckdb.connection()?;
+        let start_time = std::time::Instant::now();
+        let mut rows = Vec::with_capacity(statements.len());
+        for statement in statements {
+            let info = policy.apply(conn, statement)?;
+            rows.push(vec![
+                JsonValue::String(info.name),
+                JsonValue::Bool(info.installed),
+                JsonValue::Bool(info.loaded),
+            ]);
+        }
+
+        Ok(QueryResult::new(
+            vec!["extension_name".to_string(), "installed".to_string(), "loaded".to_string()],
+            rows,
+            0,
+            EngineType::DuckDb,
+            start_time.elapsed().as_millis() as u64,
+        ))
+    }
+
+    /// Register a SELECT as a materialized view and materialize it in DuckDB
+    ///
+    /// The result is stored in a DuckDB table named after the view with a
+    /// `_lumos_mv_` prefix. Writes through `execute` or `execute_prepared`
+    /// to the view's base tables mark it stale, and
+    /// stale views are not used to answer queries until refreshed. Views are
+    /// refreshed by `refresh_materialized_view` or, according to their
+    /// policy, by `refresh_due_views`, which callers run periodically.
+    pub fn create_materialized_view(&self, name: &str, sql: &str, policy: matview::RefreshPolicy) -> Result<matview::MaterializedView> {
+        let view = self.views.register(name, sql, policy)?;
+        if let Err(e) = self.refresh_materialized_view(name) {
+            self.views.unregister(name);
+            return Err(e);
+        }
+        Ok(self.views.get(name).unwrap_or(view))
+    }
+
+    /// Recompute a materialized view
+    pub fn refresh_materialized_view(&self, name: &str) -> Result<()> {
+        let view = self.views.get(name)
+            .ok_or_else(|| LumosError::NotFound(format!("Materialized view '{}' not found", name)))?;
+
+        self.duckdb.create_table_as(&view.table, &view.sql)?;
+        self.views.mark_refreshed(name);
+        Ok(())
+    }
+
+    /// Refresh the views whose policy calls for it, returning their names
+    ///
+    /// A failed refresh is logged and the view stays due for the next call.
+    pub fn refresh_due_views(&self) -> Vec<String> {
+        let mut refreshed = Vec::new();
+        for name in self.views.due(std::time::Instant::now()) {
+            match self.refresh_materialized_view(&name) {
+                Ok(()) => refreshed.push(name),
+                Err(e) => log::warn!("Failed to refresh materialized view '{}': {}", name, e),
+            }
+        }
+        refreshed
+    }
+
+    /// Remove a materialized view and its DuckDB table
+    pub fn drop_materialized_view(&self, name: &str) -> Result<()> {
+        let view = self.views.unregister(name)
+            .ok_or_else(|| LumosError::NotFound(format!("Materialized view '{}' not found", name)))?;
+        self.duckdb.execute(&format!("DROP TABLE IF EXISTS {}", view.table))?;
+        Ok(())
+    }
+
+    /// List registered materialized views
+    pub fn materialized_views(&self) -> Vec<matview::MaterializedView> {
+        self.views.list()
+    }
+
+    /// Pin a SQLite table in memory for analytical queries
+    ///
+    /// The table is copied into a DuckDB temporary table, which DuckDB-routed
+    /// SELECTs read instead of the row store. Writes through this executor,
+    /// prepared statements included, mark the copy stale and it is reloaded
+    /// before the next query that reads it. Writes made directly on the SQLite
+    /// engine are not seen.
+    /// Pinning an already pinned table reloads it.
+    pub fn pin_table(&self, table: &str) -> Result<pinned::PinnedTable> {
+        let existed = self.pinned.get(table).is_some();
+        let entry = self.pinned.register(table)?;
+        if let Err(e) = self.load_pinned_table(&entry) {
+            if !existed {
+                self.pinned.unregister(table);
+            }
+            return Err(e);
+        }
+        Ok(self.pinned.get(table).unwrap_or(entry))
+    }
+
+    /// Unpin a table and drop its in-memory copy
+    pub fn unpin_table(&self, table: &str) -> Result<()> {
+        let entry = self.pinned.unregister(table)
+            .ok_or_else(|| LumosError::NotFound(format!("Pinned table '{}' not found", table)))?;
+        self.duckdb.execute(&format!("DROP TABLE IF EXISTS {}", entry.cache_table))?;
+        Ok(())
+    }
+
+    /// List pinned tables
+    pub fn pinned_tables(&self) -> Vec<pinned::PinnedTable> {
+        self.pinned.list()
+    }
+
+    fn load_pinned_table(&self, entry: &pinned::PinnedTable) -> Result<()> {
+        let (rows, columns) = pinned::load(&self.sqlite, &self.duckdb, entry, self.pinned.max_rows())?;
+        self.pinned.mark_loaded(&entry.table, rows, columns);
+        Ok(())
+    }
+
+    /// Execute a SELECT query and pass its rows to `on_row` one at a time
+    ///
+    /// Unlike `execute`, rows are not collected into a `QueryResult`, so large
+    /// results can be written out without holding them in memory. `on_row`
+    /// returns `false` to stop early.
+    pub fn execute_streaming<F>(&mut self, query: Query, on_row: F) -> Result<executor::StreamSummary>
+    where
+        F: FnMut(&[String], Vec<JsonValue>) -> Result<bool>,
+    {
+        let mut query = query;
+        query.query_type = self.parser.parse_query_type(&query.sql)?;
+        if query.query_type != QueryType::Select {
+            return Err(LumosError::InvalidArgument("Only SELECT queries can be streamed".to_string()));
+        }
+
+        let engine = if query.engine_type == EngineType::Auto {
+            self.router.route(&query)?
+        } else {
+            query.engine_type
+        };
+
+        if query.timeout.is_none() {
+            query.timeout = self.default_timeout;
+        }
+
+        let result = match engine {
+            EngineType::Sqlite => executor::stream_on_sqlite(&self.sqlite, &query, on_row),
+            EngineType::DuckDb => executor::stream_on_duckdb(&self.duckdb, &query, on_row),
+            EngineType::Auto => Err(LumosError::Other("Auto engine type not resolved".to_string())),
+        };
+        self.count_timeout(result)
+    }
+
+    /// Prepare a statement once so repeated executions skip parsing and routing
+    ///
+    /// Statements are cached by SQL text. The chosen engine compiles the
+    /// statement with its own `prepare`, which validates it, counts its
+    /// parameters and keeps the compiled statement for later executions.
+    pub fn prepare(&self, sql: &str, engine_type: EngineType) -> Result<Arc<prepared::PreparedStatement>> {
+        if !crate::duckdb::extensions::ExtensionStatement::find_all(sql).is_empty() {
+            return Err(LumosError::InvalidArgument("INSTALL and LOAD cannot be prepared".to_string()));
+        }
+        self.prepared.get_or_prepare(sql, engine_type, |id| {
+            let mut query = Query::new(sql).engine(engine_type);
+            query.query_type = self.parser.parse_query_type(sql)?;
+
+            let engine = if engine_type == EngineType::Auto {
+                self.router.route(&query)?
+            } else {
+                engine_type
+            };
+
+            let param_count = match engine {
+                EngineType::Sqlite => Some(self.sqlite.prepare(sql)?),
+                EngineType::DuckDb => Some(self.duckdb.prepare(sql)?),
+                EngineType::Auto => None,
+            };
+
+            Ok(prepared::PreparedStatement {
+                id,
+                sql: sql.to_string(),
+                query_type: query.query_type,
+                engine,
+                param_count,
+            })
+        })
+    }
+
+    /// Execute a prepared statement with the given parameters
+    pub fn execute_prepared(&self, statement: &prepared::PreparedStatement, params: Vec<QueryParam>) -> Result<QueryResult> {
+        if let Some(expected) = statement.param_count {
+            if params.len() != expected {
+                return Err(LumosError::InvalidArgument(format!(
+                    "Prepared statement expects {} parameters, got {}", expected, params.len()
+                )));
+            }
+        }
+
+        let query = Query {
+            sql: statement.sql.clone(),
+            params,
+            query_type: statement.query_type,
+            engine_type: statement.engine,
+            timeout: None,
+        };
+        self.run(statement.engine, &query)
+    }
+
+    /// Get prepared statement cache statistics
+    pub fn prepared_stats(&self) -> prepared::PreparedCacheStats {
+        self.prepared.stats()
+    }
+
+    /// Drop all prepared statements, e.g. after a schema change
+    pub fn clear_prepared(&self) {
+        self.prepared.clear();
+    }
+
+    /// Execute a classified query on a resolved engine and time it
+    ///
+    /// Every write path goes through here, so successful writes mark the
+    /// materialized views and pinned copies of the written tables stale.
+    fn run(&self, engine: EngineType, query: &Query) -> Result<QueryResult> {
+        let defaulted;
+        let query = match (query.timeout, self.default_timeout) {
+            (None, Some(timeout)) => {
+                defaulted = query.clone().timeout(timeout);
+                &defaulted
+            }
+            _ => query,
+        };
+
         let start_time = std::time::Instant::now();
         
         let result = match engine {
             EngineType::Sqlite => {
-                executor::execute_on_sqlite(&self.sqlite, &query)?
+                self.count_timeout(executor::execute_on_sqlite(&self.sqlite, query))
             },
             EngineType::DuckDb => {
-                executor::execute_on_duckdb(&self.duckdb, &query)?
+                self.count_timeout(executor::execute_on_duckdb(&self.duckdb, query))
             },
             EngineType::Auto => {
                 // This shouldn't happen as we've already resolved it above
@@ -291,7 +626,20 @@ impl QueryExecutor {
         };
         
         let end_time = std::time::Instant::now();
-        let execution_time = end_time.duration_since(start_time).as_millis() as u64;
+        let elapsed = end_time.duration_since(start_time);
+        let rows = result.as_ref().map_or(0, |r| (r.rows.len() + r.rows_affected) as u64);
+        self.stats.record(&query.sql, elapsed, rows, result.is_err());
+        
+        let result = result?;
+        let execution_time = elapsed.as_millis() as u64;
+        
+        if query.query_type != QueryType::Select {
+            let tables = self.parser.extract_write_tables(&query.sql);
+            if !tables.is_empty() {
+                self.views.note_write(&tables);
+                self.pinned.note_write(&tables);
+            }
+        }
         
         Ok(QueryResult {
             columns: result.columns,
@@ -302,6 +650,41 @@ impl QueryExecutor {
         })
     }
 
+    /// Execution statistics aggregated by query fingerprint
+    pub fn query_stats(&self) -> Vec<fingerprint::QueryShapeStats> {
+        self.stats.snapshot()
+    }
+
+    /// Collect table and column statistics from both engines now
+    pub fn collect_table_stats(&self) -> Result<usize> {
+        self.table_stats.collect(&self.sqlite, &self.duckdb)
+    }
+
+    /// Collect table and column statistics if they are older than the
+    /// collection interval; callers run this periodically
+    pub fn collect_table_stats_if_due(&self) -> Result<Option<usize>> {
+        self.table_stats.collect_if_due(&self.sqlite, &self.duckdb)
+    }
+
+    /// Get the collected table statistics
+    pub fn table_stats(&self) -> &stats::StatsCollector {
+        &self.table_stats
+    }
+
+    /// Estimate the number of rows a SELECT returns from the collected statistics
+    pub fn estimate_rows(&self, sql: &str) -> Result<Option<u64>> {
+        let plan = planner::QueryPlanner::new().plan(sql)?;
+        Ok(self.table_stats.estimate_rows(&plan))
+    }
+
+    /// Count a result that failed because the query timed out
+    fn count_timeout<T>(&self, result: Result<T>) -> Result<T> {
+        if let Err(LumosError::Timeout(_)) = &result {
+            self.timeouts.fetch_add(1, Ordering::Relaxed);
+        }
+        result
+    }
+
     /// Get a reference to the SQLite engine
     pub fn sqlite(&self) -> &SqliteEngine {
         &self.sqlite
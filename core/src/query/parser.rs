@@ -1,6 +1,50 @@
 use crate::{LumosError, Result, query::QueryType};
+use crate::query::lexer::{tokenize, Token};
+use serde::{Serialize, Deserialize};
 use std::collections::HashMap;
 
+/// Aggregate functions recognised when classifying and planning queries
+pub(crate) const AGGREGATE_FUNCTIONS: &[&str] = &[
+    "COUNT", "SUM", "AVG", "MIN", "MAX", "TOTAL", "GROUP_CONCAT", "STRING_AGG",
+    "ARRAY_AGG", "STDDEV", "STDDEV_POP", "STDDEV_SAMP", "VARIANCE", "VAR_POP",
+    "VAR_SAMP", "MEDIAN", "MODE", "QUANTILE", "PERCENTILE_CONT", "PERCENTILE_DISC",
+];
+
+/// Structural features of a statement, derived from its tokens
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct QueryFeatures {
+    /// Statement type
+    pub query_type: QueryType,
+    /// Uses aggregate functions
+    pub has_aggregates: bool,
+    /// Has a GROUP BY or HAVING clause
+    pub has_group_by: bool,
+    /// Joins tables
+    pub has_joins: bool,
+    /// Contains a subquery
+    pub has_subqueries: bool,
+    /// Uses window functions
+    pub has_window_functions: bool,
+    /// Combines results with UNION, INTERSECT or EXCEPT
+    pub has_set_operations: bool,
+    /// Has an ORDER BY clause
+    pub has_order_by: bool,
+    /// Has a LIMIT clause
+    pub has_limit: bool,
+}
+
+impl QueryFeatures {
+    /// Whether the statement is better suited to the analytical engine
+    pub fn is_analytical(&self) -> bool {
+        self.has_aggregates ||
+        self.has_group_by ||
+        self.has_joins ||
+        self.has_subqueries ||
+        self.has_window_functions ||
+        self.has_set_operations
+    }
+}
+
 /// Parser for SQL queries
 pub struct QueryParser {
     /// Cache of parsed query types
@@ -22,25 +66,12 @@ impl QueryParser {
             return Ok(*query_type);
         }
         
-        // Normalize the SQL by removing extra whitespace and comments
-        let normalized_sql = self.normalize_sql(sql);
-        
-        // Extract the first token to determine the query type
-        let first_word = normalized_sql.split_whitespace()
-            .next()
-            .ok_or_else(|| LumosError::Query("Empty query".to_string()))?
-            .to_uppercase();
+        let tokens = tokenize(sql);
+        if tokens.is_empty() {
+            return Err(LumosError::Query("Empty query".to_string()));
+        }
         
-        let query_type = match first_word.as_str() {
-            "SELECT" => QueryType::Select,
-            "INSERT" => QueryType::Insert,
-            "UPDATE" => QueryType::Update,
-            "DELETE" => QueryType::Delete,
-            "CREATE" => QueryType::Create,
-            "ALTER" => QueryType::Alter,
-            "DROP" => QueryType::Drop,
-            _ => QueryType::Other,
-        };
+        let query_type = statement_type(&tokens);
         
         // Cache the result
         self.query_cache.insert(sql.to_string(), query_type);
@@ -48,31 +79,47 @@ impl QueryParser {
         Ok(query_type)
     }
 
-    /// Check if a query is analytical (potentially complex, with aggregations, joins, etc.)
+    /// Check if a query is analytical (aggregations, grouping, joins, subqueries, window functions)
     pub fn is_analytical_query(&self, sql: &str) -> bool {
-        let normalized_sql = self.normalize_sql(sql).to_uppercase();
-        
-        // Check for common analytical query patterns
-        normalized_sql.contains("GROUP BY") ||
-        normalized_sql.contains("HAVING") ||
-        normalized_sql.contains("ORDER BY") ||
-        normalized_sql.contains("LIMIT") ||
-        normalized_sql.contains("JOIN") ||
-        normalized_sql.contains("UNION") ||
-        normalized_sql.contains("INTERSECT") ||
-        normalized_sql.contains("EXCEPT") ||
-        // Check for common analytical functions
-        normalized_sql.contains("COUNT(") ||
-        normalized_sql.contains("SUM(") ||
-        normalized_sql.contains("AVG(") ||
-        normalized_sql.contains("MIN(") ||
-        normalized_sql.contains("MAX(") ||
-        normalized_sql.contains("STDDEV") ||
-        normalized_sql.contains("VARIANCE") ||
-        normalized_sql.contains("PERCENTILE") ||
-        normalized_sql.contains("WINDOW") ||
-        normalized_sql.contains("OVER(") ||
-        normalized_sql.contains("PARTITION BY")
+        self.analyze(sql).map_or(false, |features| features.is_analytical())
+    }
+
+    /// Classify a statement by its structure
+    ///
+    /// Works on tokens rather than substrings, so keywords inside identifiers
+    /// (`updated_at`), string literals and comments are ignored.
+    pub fn analyze(&self, sql: &str) -> Result<QueryFeatures> {
+        let tokens = tokenize(sql);
+        if tokens.is_empty() {
+            return Err(LumosError::Query("Empty query".to_string()));
+        }
+
+        let keyword_pair = |first: &str, second: &str| {
+            tokens.windows(2).any(|w| w[0].is_keyword(first) && w[1].is_keyword(second))
+        };
+        let any_keyword = |keywords: &[&str]| {
+            tokens.iter().any(|t| keywords.iter().any(|k| t.is_keyword(k)))
+        };
+
+        let has_aggregates = tokens.windows(2).any(|w| match (&w[0], &w[1]) {
+            (Token::Word(name), Token::Symbol('(')) => is_aggregate_function(name),
+            _ => false,
+        });
+        let has_subqueries = tokens.windows(2).any(|w| {
+            w[0] == Token::Symbol('(') && (w[1].is_keyword("SELECT") || w[1].is_keyword("WITH"))
+        });
+
+        Ok(QueryFeatures {
+            query_type: statement_type(&tokens),
+            has_aggregates,
+            has_group_by: keyword_pair("GROUP", "BY") || any_keyword(&["HAVING"]),
+            has_joins: any_keyword(&["JOIN"]),
+            has_subqueries,
+            has_window_functions: any_keyword(&["OVER", "WINDOW"]),
+            has_set_operations: any_keyword(&["UNION", "INTERSECT", "EXCEPT"]),
+            has_order_by: keyword_pair("ORDER", "BY"),
+            has_limit: any_keyword(&["LIMIT"]),
+        })
     }
 
     /// Parse SQL query to determine if it's a write query
@@ -85,6 +132,46 @@ impl QueryParser {
         }
     }
 
+    /// Extract the tables modified by a write query
+    ///
+    /// Handles INSERT/REPLACE, UPDATE, DELETE, TRUNCATE and table-level
+    /// CREATE/ALTER/DROP statements. Names are lowercased with quotes and
+    /// schema prefixes removed. Returns an empty list for reads and for
+    /// statements whose target cannot be determined.
+    pub fn extract_write_tables(&self, sql: &str) -> Vec<String> {
+        let normalized_sql = self.normalize_sql(sql);
+        let tokens: Vec<&str> = normalized_sql.split_whitespace().collect();
+        let upper: Vec<String> = tokens.iter().map(|t| t.to_uppercase()).collect();
+
+        // Position of the first token after `keyword`, skipping optional words
+        let after = |keyword: &str, optional: &[&str]| -> Option<usize> {
+            let mut idx = upper.iter().position(|t| t == keyword)? + 1;
+            while idx < upper.len() && optional.contains(&upper[idx].as_str()) {
+                idx += 1;
+            }
+            Some(idx)
+        };
+
+        let target = match upper.first().map(String::as_str) {
+            Some("INSERT") | Some("REPLACE") => after("INTO", &[]),
+            Some("UPDATE") => after("UPDATE", &["OR", "ROLLBACK", "ABORT", "REPLACE", "FAIL", "IGNORE"]),
+            Some("DELETE") => after("FROM", &[]),
+            Some("TRUNCATE") => after("TRUNCATE", &["TABLE"]),
+            Some("ALTER") => after("TABLE", &[]),
+            Some("CREATE") | Some("DROP") if upper.iter().any(|t| t == "TABLE") => {
+                after("TABLE", &["IF", "NOT", "EXISTS"])
+            }
+            _ => None,
+        };
+
+        target
+            .and_then(|idx| tokens.get(idx))
+            .map(|token| clean_table_name(token))
+            .filter(|name| !name.is_empty())
+            .into_iter()
+            .collect()
+    }
+
     /// Normalize SQL by removing comments and extra whitespace
     fn normalize_sql(&self, sql: &str) -> String {
         let mut normalized = String::with_capacity(sql.len());
@@ -222,9 +309,128 @@ impl QueryParser {
         
         // Convert back to original case and deduplicate
         tables.iter()
-            .map(|t| t.to_lowercase())
+            .map(|t| clean_table_name(t))
             .collect::<std::collections::HashSet<_>>()
             .into_iter()
             .collect()
     }
 }
+
+/// Check whether a function name is a known aggregate function
+pub(crate) fn is_aggregate_function(name: &str) -> bool {
+    AGGREGATE_FUNCTIONS.iter().any(|f| f.eq_ignore_ascii_case(name))
+}
+
+/// Determine the statement type from its tokens
+///
+/// For `WITH` statements the type is taken from the first top-level
+/// statement keyword after the common table expressions.
+fn statement_type(tokens: &[Token]) -> QueryType {
+    let leading_with = tokens.first().map_or(false, |t| t.is_keyword("WITH"));
+    let mut depth = 0i32;
+
+    for token in tokens {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            Token::Word(word) if !leading_with || depth == 0 => {
+                let query_type = match word.to_uppercase().as_str() {
+                    "SELECT" => Some(QueryType::Select),
+                    "INSERT" | "REPLACE" => Some(QueryType::Insert),
+                    "UPDATE" => Some(QueryType::Update),
+                    "DELETE" => Some(QueryType::Delete),
+                    "CREATE" => Some(QueryType::Create),
+                    "ALTER" => Some(QueryType::Alter),
+                    "DROP" => Some(QueryType::Drop),
+                    _ => None,
+                };
+
+                match query_type {
+                    Some(query_type) => return query_type,
+                    // Without WITH the first keyword decides
+                    None if !leading_with => return QueryType::Other,
+                    None => {}
+                }
+            }
+            _ => {}
+        }
+    }
+
+    QueryType::Other
+}
+
+/// Strip quoting, schema prefix and trailing column list from a table reference
+fn clean_table_name(token: &str) -> String {
+    let name = token.split('(').next().unwrap_or(token).trim_end_matches(';');
+    let name = name.rsplit('.').next().unwrap_or(name);
+    name.trim_matches(|c| c == '"' || c == '`' || c == '[' || c == ']' || c == '\'')
+        .to_lowercase()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_classification_ignores_identifiers_and_literals() {
+        let mut parser = QueryParser::new();
+
+        assert_eq!(parser.parse_query_type("SELECT updated_at FROM t").unwrap(), QueryType::Select);
+        assert_eq!(parser.parse_query_type("-- DELETE\n SELECT 'DROP' FROM t").unwrap(), QueryType::Select);
+        assert_eq!(parser.parse_query_type("(SELECT 1) UNION (SELECT 2)").unwrap(), QueryType::Select);
+        assert_eq!(
+            parser.parse_query_type("WITH recent AS (SELECT id FROM t) DELETE FROM t WHERE id IN recent").unwrap(),
+            QueryType::Delete
+        );
+        assert!(!parser.is_write_query("WITH x AS (SELECT 1) SELECT * FROM x").unwrap());
+        assert!(parser.parse_query_type("  -- only a comment").is_err());
+
+        assert!(!parser.is_analytical_query("SELECT discount, max_value FROM t ORDER BY id LIMIT 10"));
+        assert!(!parser.is_analytical_query("SELECT 'GROUP BY' FROM t"));
+    }
+
+    #[test]
+    fn test_analyze() {
+        let parser = QueryParser::new();
+
+        let features = parser.analyze(
+            "SELECT u.name, COUNT(*) FROM users u JOIN orders o ON o.user_id = u.id \
+             WHERE u.id IN (SELECT user_id FROM vip) GROUP BY u.name"
+        ).unwrap();
+        assert_eq!(features.query_type, QueryType::Select);
+        assert!(features.has_aggregates);
+        assert!(features.has_joins);
+        assert!(features.has_subqueries);
+        assert!(features.has_group_by);
+        assert!(!features.has_window_functions);
+        assert!(features.is_analytical());
+
+        let features = parser.analyze("SELECT id, ROW_NUMBER() OVER (ORDER BY id) FROM t").unwrap();
+        assert!(features.has_window_functions);
+        assert!(features.has_order_by);
+    }
+
+    #[test]
+    fn test_extract_write_tables() {
+        let parser = QueryParser::new();
+
+        assert_eq!(parser.extract_write_tables("INSERT INTO users (name) VALUES ('a')"), vec!["users"]);
+        assert_eq!(parser.extract_write_tables("insert or replace into \"Users\"(id) values (1)"), vec!["users"]);
+        assert_eq!(parser.extract_write_tables("UPDATE OR IGNORE main.orders SET x = 1"), vec!["orders"]);
+        assert_eq!(parser.extract_write_tables("DELETE FROM `logs` WHERE id = 1;"), vec!["logs"]);
+        assert_eq!(parser.extract_write_tables("DROP TABLE IF EXISTS tmp"), vec!["tmp"]);
+        assert_eq!(parser.extract_write_tables("ALTER TABLE users ADD COLUMN email TEXT"), vec!["users"]);
+
+        assert!(parser.extract_write_tables("SELECT * FROM users").is_empty());
+        assert!(parser.extract_write_tables("CREATE INDEX idx ON users (name)").is_empty());
+    }
+
+    #[test]
+    fn test_extract_table_names_matches_write_tables() {
+        let parser = QueryParser::new();
+
+        let tables = parser.extract_table_names("SELECT * FROM main.\"Users\" u JOIN orders o ON u.id = o.user_id");
+        assert!(tables.contains(&"users".to_string()));
+        assert!(tables.contains(&"orders".to_string()));
+    }
+}
@@ -0,0 +1,344 @@
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::Instant;
+use crate::{LumosError, Result, sqlite::SqliteEngine, duckdb::DuckDbEngine};
+use crate::query::lexer::{tokenize, Token};
+use crate::query::planner::render;
+
+/// Default maximum number of rows of a pinned table
+pub const DEFAULT_MAX_PINNED_ROWS: usize = 1_000_000;
+
+/// Prefix of the in-memory DuckDB tables holding pinned copies
+const CACHE_TABLE_PREFIX: &str = "_lumos_pinned_";
+
+/// A SQLite table kept fully in memory as a DuckDB temporary table
+///
+/// DuckDB stores tables column by column, so analytical queries scan the
+/// pinned copy in columnar form instead of the row store.
+#[derive(Debug, Clone)]
+pub struct PinnedTable {
+    /// Lowercased name of the SQLite table
+    pub table: String,
+    /// Name of the DuckDB temporary table holding the copy
+    pub cache_table: String,
+    /// Number of rows in the copy
+    pub rows: usize,
+    /// Column names of the copy
+    pub columns: Vec<String>,
+    /// Time of the last load, `None` before the first one
+    pub loaded_at: Option<Instant>,
+    /// Whether the table was written since the last load
+    pub stale: bool,
+}
+
+impl PinnedTable {
+    /// Whether the copy can answer queries
+    pub fn is_fresh(&self) -> bool {
+        self.loaded_at.is_some() && !self.stale
+    }
+}
+
+/// Registry of pinned tables
+///
+/// The registry only tracks pins; its owner copies the tables into DuckDB
+/// and calls `note_write` for every successful write, which marks the copy
+/// stale. A stale copy is reloaded before the next analytical query that
+/// reads the table.
+pub struct PinnedTableManager {
+    tables: Mutex<HashMap<String, PinnedTable>>,
+    max_rows: usize,
+}
+
+impl PinnedTableManager {
+    /// Create an empty registry allowing tables of up to `max_rows` rows
+    pub fn new(max_rows: usize) -> Self {
+        Self {
+            tables: Mutex::new(HashMap::new()),
+            max_rows,
+        }
+    }
+
+    /// Maximum number of rows of a pinned table
+    pub fn max_rows(&self) -> usize {
+        self.max_rows
+    }
+
+    /// Register a table; pinning an already pinned table marks it stale
+    pub fn register(&self, table: &str) -> Result<PinnedTable> {
+        if table.is_empty() || !table.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+            return Err(LumosError::InvalidArgument(format!("Invalid table name: '{}'", table)));
+        }
+
+        let table = table.to_lowercase();
+        let mut tables = self.tables.lock().unwrap();
+        let pinned = tables.entry(table.clone()).or_insert_with(|| PinnedTable {
+            cache_table: format!("{}{}", CACHE_TABLE_PREFIX, table),
+            table,
+            rows: 0,
+            columns: Vec::new(),
+            loaded_at: None,
+            stale: true,
+        });
+        pinned.stale = true;
+        Ok(pinned.clone())
+    }
+
+    /// Remove a table from the registry
+    pub fn unregister(&self, table: &str) -> Option<PinnedTable> {
+        self.tables.lock().unwrap().remove(&table.to_lowercase())
+    }
+
+    /// Get a pinned table by name
+    pub fn get(&self, table: &str) -> Option<PinnedTable> {
+        self.tables.lock().unwrap().get(&table.to_lowercase()).cloned()
+    }
+
+    /// List all pinned tables
+    pub fn list(&self) -> Vec<PinnedTable> {
+        self.tables.lock().unwrap().values().cloned().collect()
+    }
+
+    /// Mark pinned tables among `tables` as stale, returning their names
+    pub fn note_write(&self, tables: &[String]) -> Vec<String> {
+        let mut stale = Vec::new();
+        let mut pinned = self.tables.lock().unwrap();
+        for table in tables {
+            if let Some(entry) = pinned.get_mut(&table.to_lowercase()) {
+                entry.stale = true;
+                stale.push(entry.table.clone());
+            }
+        }
+        stale
+    }
+
+    /// Record a successful load
+    pub fn mark_loaded(&self, table: &str, rows: usize, columns: Vec<String>) {
+        if let Some(pinned) = self.tables.lock().unwrap().get_mut(&table.to_lowercase()) {
+            pinned.rows = rows;
+            pinned.columns = columns;
+            pinned.loaded_at = Some(Instant::now());
+            pinned.stale = false;
+        }
+    }
+
+    /// Pinned tables read by `sql`, fresh or not
+    pub fn referenced(&self, sql: &str) -> Vec<PinnedTable> {
+        let tables = self.tables.lock().unwrap();
+        let mut referenced: Vec<PinnedTable> = Vec::new();
+        for (_, name) in table_references(&tokenize(sql)) {
+            if let Some(pinned) = tables.get(&name) {
+                if !referenced.iter().any(|p| p.table == pinned.table) {
+                    referenced.push(pinned.clone());
+                }
+            }
+        }
+        referenced
+    }
+
+    /// Rewrite `sql` to read the fresh pinned copies of the tables it names
+    ///
+    /// Returns `None` when no fresh pinned table is referenced.
+    pub fn rewrite(&self, sql: &str) -> Option<String> {
+        let mut tokens = tokenize(sql);
+        let tables = self.tables.lock().unwrap();
+        let mut rewritten = false;
+        for (index, name) in table_references(&tokens) {
+            if let Some(pinned) = tables.get(&name).filter(|p| p.is_fresh()) {
+                tokens[index] = Token::Word(pinned.cache_table.clone());
+                rewritten = true;
+            }
+        }
+        if rewritten { Some(render(&tokens)) } else { None }
+    }
+}
+
+impl Default for PinnedTableManager {
+    fn default() -> Self {
+        Self::new(DEFAULT_MAX_PINNED_ROWS)
+    }
+}
+
+/// Copy a pinned table from SQLite into its DuckDB temporary table
+///
+/// Returns the number of rows and the column names of the copy. Tables with
+/// more than `max_rows` rows are refused, since the copy is held in memory.
+pub fn load(sqlite: &SqliteEngine, duckdb: &DuckDbEngine, pinned: &PinnedTable, max_rows: usize) -> Result<(usize, Vec<String>)> {
+    let conn = sqlite.connection()?;
+    let columns: Vec<(String, String)> = conn
+        .prepare("SELECT name, type FROM pragma_table_info(?1) ORDER BY cid")?
+        .query_map([&pinned.table], |row| Ok((row.get(0)?, row.get(1)?)))?
+        .collect::<std::result::Result<_, _>>()?;
+    if columns.is_empty() {
+        return Err(LumosError::NotFound(format!("Table '{}' does not exist", pinned.table)));
+    }
+
+    let count: i64 = conn.query_row(&format!("SELECT COUNT(*) FROM \"{}\"", pinned.table), [], |row| row.get(0))?;
+    if count as usize > max_rows {
+        return Err(LumosError::InvalidArgument(format!(
+            "Table '{}' has {} rows, more than the {} allowed for a pinned table", pinned.table, count, max_rows
+        )));
+    }
+
+    let definition = columns.iter()
+        .map(|(name, declared)| format!("\"{}\" {}", name.replace('"', "\"\""), duckdb_type(declared)))
+        .collect::<Vec<_>>()
+        .join(", ");
+    let duck = duckdb.connection()?;
+    duck.execute_batch(&format!("CREATE OR REPLACE TEMP TABLE {} ({})", pinned.cache_table, definition))?;
+
+    let placeholders = vec!["?"; columns.len()].join(", ");
+    let mut insert = duck.prepare(&format!("INSERT INTO {} VALUES ({})", pinned.cache_table, placeholders))?;
+    let mut select = conn.prepare(&format!("SELECT * FROM \"{}\"", pinned.table))?;
+    let mut rows = select.query([])?;
+
+    duck.execute_batch("BEGIN TRANSACTION")?;
+    let copied = (|| -> Result<usize> {
+        let mut copied = 0;
+        while let Some(row) = rows.next()? {
+            let values = (0..columns.len())
+                .map(|i| row.get::<_, rusqlite::types::Value>(i).map(to_duckdb_param))
+                .collect::<std::result::Result<Vec<_>, _>>()?;
+            let params: Vec<&dyn duckdb::ToSql> = values.iter().map(|v| v.as_ref()).collect();
+            insert.execute(&params[..])?;
+            copied += 1;
+        }
+        Ok(copied)
+    })();
+    match copied {
+        Ok(copied) => {
+            duck.execute_batch("COMMIT")?;
+            log::info!("Pinned {} rows of table '{}' in memory", copied, pinned.table);
+            Ok((copied, columns.into_iter().map(|(name, _)| name).collect()))
+        }
+        Err(e) => {
+            let _ = duck.execute_batch("ROLLBACK");
+            Err(e)
+        }
+    }
+}
+
+/// DuckDB column type for a SQLite declared type
+///
+/// Boolean, date and time declarations map to the matching DuckDB types, so
+/// their values must be 0/1 or ISO-8601 text; otherwise the load fails.
+/// Everything else follows SQLite's affinity rules.
+fn duckdb_type(declared: &str) -> &'static str {
+    let declared = declared.to_uppercase();
+    if declared.contains("INT") {
+        "BIGINT"
+    } else if declared.contains("BOOL") {
+        "BOOLEAN"
+    } else if declared.contains("TIMESTAMP") || declared.contains("DATETIME") {
+        "TIMESTAMP"
+    } else if declared.contains("DATE") {
+        "DATE"
+    } else if declared.contains("TIME") {
+        "TIME"
+    } else if declared.contains("CHAR") || declared.contains("CLOB") || declared.contains("TEXT") {
+        "VARCHAR"
+    } else if declared.contains("BLOB") {
+        "BLOB"
+    } else if declared.contains("REAL") || declared.contains("FLOA") || declared.contains("DOUB") {
+        "DOUBLE"
+    } else if declared.is_empty() {
+        "VARCHAR"
+    } else {
+        "DOUBLE"
+    }
+}
+
+fn to_duckdb_param(value: rusqlite::types::Value) -> Box<dyn duckdb::ToSql> {
+    match value {
+        rusqlite::types::Value::Null => Box::new(Option::<String>::None),
+        rusqlite::types::Value::Integer(i) => Box::new(i),
+        rusqlite::types::Value::Real(f) => Box::new(f),
+        rusqlite::types::Value::Text(s) => Box::new(s),
+        rusqlite::types::Value::Blob(b) => Box::new(b),
+    }
+}
+
+/// Positions and lowercased names of unqualified tables named after FROM or
+/// JOIN, and in comma-separated FROM lists
+///
+/// Schema-qualified names such as `main.orders` are left alone, since they
+/// explicitly ask for a particular copy.
+fn table_references(tokens: &[Token]) -> Vec<(usize, String)> {
+    const CLAUSE_END: [&str; 8] = ["WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "UNION", "ON", "USING"];
+
+    let mut references = Vec::new();
+    let mut in_from = false;
+    let mut expect_table = false;
+
+    for (index, token) in tokens.iter().enumerate() {
+        match token {
+            Token::Word(word) if word.eq_ignore_ascii_case("FROM") || word.eq_ignore_ascii_case("JOIN") => {
+                in_from = true;
+                expect_table = true;
+            }
+            Token::Word(word) if CLAUSE_END.iter().any(|k| word.eq_ignore_ascii_case(k)) => {
+                in_from = false;
+                expect_table = false;
+            }
+            Token::Symbol(',') if in_from => expect_table = true,
+            Token::Word(word) | Token::QuotedIdent(word) if expect_table => {
+                expect_table = false;
+                let qualified = tokens.get(index + 1) == Some(&Token::Symbol('.'))
+                    || (index > 0 && tokens[index - 1] == Token::Symbol('.'));
+                if !qualified {
+                    references.push((index, word.to_lowercase()));
+                }
+            }
+            _ => expect_table = false,
+        }
+    }
+
+    references
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pin_rewrite_and_invalidate() {
+        let manager = PinnedTableManager::default();
+        manager.register("Customers").unwrap();
+        manager.register("regions").unwrap();
+        assert!(manager.register("bad name").is_err());
+
+        let query = "SELECT r.name, SUM(o.amount) FROM orders o JOIN customers c ON o.cid = c.id JOIN regions r ON c.rid = r.id GROUP BY r.name";
+        let names: Vec<String> = manager.referenced(query).into_iter().map(|p| p.table).collect();
+        assert_eq!(names, vec!["customers", "regions"]);
+
+        // Not used before the first load
+        assert_eq!(manager.rewrite(query), None);
+
+        manager.mark_loaded("customers", 10, vec!["id".to_string(), "rid".to_string()]);
+        let rewritten = manager.rewrite(query).unwrap();
+        assert!(rewritten.contains("JOIN _lumos_pinned_customers c"));
+        assert!(rewritten.contains("JOIN regions r"));
+        assert!(rewritten.contains("FROM orders o"));
+
+        // Qualified names are left alone
+        assert_eq!(manager.rewrite("SELECT * FROM main.customers"), None);
+
+        // A write makes the copy stale until it is reloaded
+        assert_eq!(manager.note_write(&["CUSTOMERS".to_string(), "orders".to_string()]), vec!["customers"]);
+        assert_eq!(manager.rewrite(query), None);
+        assert!(manager.get("customers").unwrap().stale);
+    }
+
+    #[test]
+    fn test_duckdb_type() {
+        assert_eq!(duckdb_type("INTEGER"), "BIGINT");
+        assert_eq!(duckdb_type("varchar(20)"), "VARCHAR");
+        assert_eq!(duckdb_type("DOUBLE PRECISION"), "DOUBLE");
+        assert_eq!(duckdb_type("NUMERIC"), "DOUBLE");
+        assert_eq!(duckdb_type(""), "VARCHAR");
+        assert_eq!(duckdb_type("BOOLEAN"), "BOOLEAN");
+        assert_eq!(duckdb_type("DATE"), "DATE");
+        assert_eq!(duckdb_type("DATETIME"), "TIMESTAMP");
+        assert_eq!(duckdb_type("timestamp"), "TIMESTAMP");
+        assert_eq!(duckdb_type("TIME"), "TIME");
+    }
+}
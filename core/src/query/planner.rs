@@ -0,0 +1,572 @@
+use serde::{Serialize, Deserialize};
+use crate::{LumosError, Result};
+use crate::query::lexer::{tokenize, Token};
+use crate::query::parser::is_aggregate_function;
+
+/// Logical query plan built from a SELECT statement
+///
+/// Nodes are nested bottom-up in evaluation order: table access and joins,
+/// WHERE filter, aggregation, HAVING filter, projection, sort and limit.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(tag = "node", rename_all = "snake_case")]
+pub enum LogicalPlan {
+    /// Read all rows of a table
+    Scan {
+        table: String,
+        alias: Option<String>,
+    },
+    /// Derived table from a subquery in FROM
+    Subquery {
+        alias: Option<String>,
+        input: Box<LogicalPlan>,
+    },
+    /// Single empty row, for SELECT without FROM
+    Empty,
+    /// Join two inputs
+    Join {
+        join_type: String,
+        condition: Option<String>,
+        left: Box<LogicalPlan>,
+        right: Box<LogicalPlan>,
+    },
+    /// Keep rows matching a predicate
+    Filter {
+        predicate: String,
+        input: Box<LogicalPlan>,
+    },
+    /// Group rows and compute aggregates
+    Aggregate {
+        group_by: Vec<String>,
+        aggregates: Vec<String>,
+        input: Box<LogicalPlan>,
+    },
+    /// Compute the output columns
+    Projection {
+        columns: Vec<String>,
+        distinct: bool,
+        input: Box<LogicalPlan>,
+    },
+    /// Order rows
+    Sort {
+        keys: Vec<String>,
+        input: Box<LogicalPlan>,
+    },
+    /// Limit the number of rows
+    Limit {
+        limit: String,
+        offset: Option<String>,
+        input: Box<LogicalPlan>,
+    },
+}
+
+impl LogicalPlan {
+    /// Direct inputs of this node
+    pub fn children(&self) -> Vec<&LogicalPlan> {
+        match self {
+            LogicalPlan::Scan { .. } | LogicalPlan::Empty => Vec::new(),
+            LogicalPlan::Join { left, right, .. } => vec![left.as_ref(), right.as_ref()],
+            LogicalPlan::Subquery { input, .. } |
+            LogicalPlan::Filter { input, .. } |
+            LogicalPlan::Aggregate { input, .. } |
+            LogicalPlan::Projection { input, .. } |
+            LogicalPlan::Sort { input, .. } |
+            LogicalPlan::Limit { input, .. } => vec![input.as_ref()],
+        }
+    }
+
+    /// Names of all tables scanned by the plan, including subqueries
+    pub fn tables(&self) -> Vec<String> {
+        let mut tables = Vec::new();
+        self.collect_tables(&mut tables);
+        tables
+    }
+
+    fn collect_tables(&self, tables: &mut Vec<String>) {
+        if let LogicalPlan::Scan { table, .. } = self {
+            if !tables.contains(table) {
+                tables.push(table.clone());
+            }
+        }
+        for child in self.children() {
+            child.collect_tables(tables);
+        }
+    }
+}
+
+/// Builds logical plans from SQL text
+pub struct QueryPlanner;
+
+impl QueryPlanner {
+    /// Create a new query planner
+    pub fn new() -> Self {
+        Self
+    }
+
+    /// Build a logical plan for a SELECT statement
+    ///
+    /// Common table expressions and compound selects (UNION, INTERSECT,
+    /// EXCEPT) are not supported and return an error.
+    pub fn plan(&self, sql: &str) -> Result<LogicalPlan> {
+        let tokens = tokenize(sql);
+        let tokens = match tokens.last() {
+            Some(Token::Symbol(';')) => &tokens[..tokens.len() - 1],
+            _ => &tokens[..],
+        };
+        plan_select(tokens)
+    }
+}
+
+/// Clause bodies of a SELECT statement
+#[derive(Default)]
+struct Clauses<'a> {
+    select: &'a [Token],
+    from: Option<&'a [Token]>,
+    filter: Option<&'a [Token]>,
+    group_by: Option<&'a [Token]>,
+    having: Option<&'a [Token]>,
+    order_by: Option<&'a [Token]>,
+    limit: Option<&'a [Token]>,
+    offset: Option<&'a [Token]>,
+}
+
+/// Plan a SELECT statement given as tokens
+fn plan_select(tokens: &[Token]) -> Result<LogicalPlan> {
+    let clauses = split_clauses(tokens)?;
+
+    let mut plan = match clauses.from {
+        Some(from) => plan_from(from)?,
+        None => LogicalPlan::Empty,
+    };
+
+    if let Some(filter) = clauses.filter {
+        plan = LogicalPlan::Filter { predicate: render(filter), input: Box::new(plan) };
+    }
+
+    let (distinct, select) = match clauses.select.first() {
+        Some(t) if t.is_keyword("DISTINCT") => (true, &clauses.select[1..]),
+        Some(t) if t.is_keyword("ALL") => (false, &clauses.select[1..]),
+        _ => (false, clauses.select),
+    };
+    let columns: Vec<&[Token]> = split_commas(select);
+
+    let group_by: Vec<String> = clauses.group_by.map(split_commas).unwrap_or_default()
+        .into_iter().map(render).collect();
+    let aggregates: Vec<String> = columns.iter()
+        .filter(|column| contains_aggregate(column))
+        .map(|column| render(column))
+        .collect();
+
+    if !group_by.is_empty() || !aggregates.is_empty() {
+        plan = LogicalPlan::Aggregate { group_by, aggregates, input: Box::new(plan) };
+    }
+
+    if let Some(having) = clauses.having {
+        plan = LogicalPlan::Filter { predicate: render(having), input: Box::new(plan) };
+    }
+
+    plan = LogicalPlan::Projection {
+        columns: columns.into_iter().map(render).collect(),
+        distinct,
+        input: Box::new(plan),
+    };
+
+    if let Some(order_by) = clauses.order_by {
+        plan = LogicalPlan::Sort {
+            keys: split_commas(order_by).into_iter().map(render).collect(),
+            input: Box::new(plan),
+        };
+    }
+
+    if let Some(limit) = clauses.limit {
+        // SQLite also accepts "LIMIT offset, count"
+        let parts = split_commas(limit);
+        let (limit, offset) = match (parts.len(), clauses.offset) {
+            (2, _) => (render(parts[1]), Some(render(parts[0]))),
+            (_, offset) => (render(limit), offset.map(render)),
+        };
+        plan = LogicalPlan::Limit { limit, offset, input: Box::new(plan) };
+    }
+
+    Ok(plan)
+}
+
+/// Locate the top-level clauses of a SELECT statement
+fn split_clauses(tokens: &[Token]) -> Result<Clauses<'_>> {
+    match tokens.first() {
+        Some(t) if t.is_keyword("SELECT") => {}
+        Some(t) if t.is_keyword("WITH") => {
+            return Err(LumosError::Query("Planning WITH queries is not supported".to_string()));
+        }
+        _ => return Err(LumosError::Query("Only SELECT statements can be planned".to_string())),
+    }
+
+    // (clause name, start of clause body) for each top-level clause keyword
+    let mut markers: Vec<(&str, usize, usize)> = vec![("SELECT", 0, 1)];
+    let mut depth = 0i32;
+    let mut i = 1;
+
+    while i < tokens.len() {
+        match &tokens[i] {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            token @ Token::Word(_) if depth == 0 => {
+                let next_is_by = tokens.get(i + 1).map_or(false, |t| t.is_keyword("BY"));
+                let clause = if token.is_keyword("FROM") {
+                    Some(("FROM", 1))
+                } else if token.is_keyword("WHERE") {
+                    Some(("WHERE", 1))
+                } else if token.is_keyword("GROUP") && next_is_by {
+                    Some(("GROUP BY", 2))
+                } else if token.is_keyword("HAVING") {
+                    Some(("HAVING", 1))
+                } else if token.is_keyword("ORDER") && next_is_by {
+                    Some(("ORDER BY", 2))
+                } else if token.is_keyword("LIMIT") {
+                    Some(("LIMIT", 1))
+                } else if token.is_keyword("OFFSET") {
+                    Some(("OFFSET", 1))
+                } else if ["UNION", "INTERSECT", "EXCEPT"].iter().any(|k| token.is_keyword(k)) {
+                    return Err(LumosError::Query("Planning compound SELECT statements is not supported".to_string()));
+                } else {
+                    None
+                };
+
+                if let Some((name, len)) = clause {
+                    markers.push((name, i, i + len));
+                    i += len;
+                    continue;
+                }
+            }
+            _ => {}
+        }
+        i += 1;
+    }
+
+    let mut clauses = Clauses::default();
+    for (idx, (name, _, body_start)) in markers.iter().enumerate() {
+        let body_end = markers.get(idx + 1).map_or(tokens.len(), |(_, start, _)| *start);
+        let body = &tokens[*body_start..body_end];
+        if body.is_empty() {
+            return Err(LumosError::Query(format!("Empty {} clause", name)));
+        }
+
+        match *name {
+            "SELECT" => clauses.select = body,
+            "FROM" => clauses.from = Some(body),
+            "WHERE" => clauses.filter = Some(body),
+            "GROUP BY" => clauses.group_by = Some(body),
+            "HAVING" => clauses.having = Some(body),
+            "ORDER BY" => clauses.order_by = Some(body),
+            "LIMIT" => clauses.limit = Some(body),
+            _ => clauses.offset = Some(body),
+        }
+    }
+
+    Ok(clauses)
+}
+
+/// Plan the FROM clause: table references combined with joins
+fn plan_from(tokens: &[Token]) -> Result<LogicalPlan> {
+    // Each segment is (join type, tokens of the table reference and its condition)
+    let mut segments: Vec<(String, &[Token])> = Vec::new();
+    let mut join_type = String::new();
+    let mut start = 0;
+    let mut depth = 0i32;
+    let mut i = 0;
+
+    while i < tokens.len() {
+        match &tokens[i] {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            Token::Symbol(',') if depth == 0 => {
+                segments.push((join_type.clone(), &tokens[start..i]));
+                join_type = "CROSS".to_string();
+                start = i + 1;
+            }
+            // LEFT(...) / RIGHT(...) are string functions, not join operators
+            token if depth == 0 && is_join_keyword(token) && tokens.get(i + 1) != Some(&Token::Symbol('(')) => {
+                segments.push((join_type.clone(), &tokens[start..i]));
+
+                let mut words = Vec::new();
+                while i < tokens.len() && !tokens[i].is_keyword("JOIN") {
+                    if let Token::Word(w) = &tokens[i] {
+                        if !w.eq_ignore_ascii_case("OUTER") {
+                            words.push(w.to_uppercase());
+                        }
+                    }
+                    i += 1;
+                }
+                if i >= tokens.len() {
+                    return Err(LumosError::Query(format!("Expected JOIN after {}", words.join(" "))));
+                }
+                join_type = if words.is_empty() { "INNER".to_string() } else { words.join(" ") };
+                start = i + 1;
+            }
+            _ => {}
+        }
+        i += 1;
+    }
+    if start >= tokens.len() && !segments.is_empty() {
+        return Err(LumosError::Query("Missing table reference after JOIN".to_string()));
+    }
+    segments.push((join_type, &tokens[start..]));
+
+    let mut plan: Option<LogicalPlan> = None;
+    for (join_type, segment) in segments {
+        let (reference, condition) = split_join_condition(segment);
+        let right = plan_table_ref(reference)?;
+
+        plan = Some(match plan {
+            None => right,
+            Some(left) => LogicalPlan::Join {
+                join_type,
+                condition,
+                left: Box::new(left),
+                right: Box::new(right),
+            },
+        });
+    }
+
+    plan.ok_or_else(|| LumosError::Query("Empty FROM clause".to_string()))
+}
+
+/// Keywords that start a join operator
+fn is_join_keyword(token: &Token) -> bool {
+    ["JOIN", "NATURAL", "LEFT", "RIGHT", "FULL", "INNER", "CROSS"].iter().any(|k| token.is_keyword(k))
+}
+
+/// Split a join segment into the table reference and its ON/USING condition
+fn split_join_condition(tokens: &[Token]) -> (&[Token], Option<String>) {
+    let mut depth = 0i32;
+    for (i, token) in tokens.iter().enumerate() {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            t if depth == 0 && t.is_keyword("ON") => return (&tokens[..i], Some(render(&tokens[i + 1..]))),
+            t if depth == 0 && t.is_keyword("USING") => return (&tokens[..i], Some(render(&tokens[i..]))),
+            _ => {}
+        }
+    }
+    (tokens, None)
+}
+
+/// Plan a single table reference: a (possibly qualified) table or a subquery, with optional alias
+fn plan_table_ref(tokens: &[Token]) -> Result<LogicalPlan> {
+    match tokens.first() {
+        Some(Token::Symbol('(')) => {
+            let close = matching_paren(tokens)
+                .ok_or_else(|| LumosError::Query("Unbalanced parentheses in FROM clause".to_string()))?;
+            let input = plan_select(&tokens[1..close])?;
+            Ok(LogicalPlan::Subquery {
+                alias: parse_alias(&tokens[close + 1..]),
+                input: Box::new(input),
+            })
+        }
+        Some(Token::Word(_)) | Some(Token::QuotedIdent(_)) => {
+            // Qualified names such as main.users
+            let mut end = 1;
+            while tokens.get(end) == Some(&Token::Symbol('.')) && tokens.get(end + 1).map_or(false, is_identifier) {
+                end += 2;
+            }
+            let table = tokens[end - 1..end].iter().filter_map(identifier_text).next().unwrap_or_default();
+            Ok(LogicalPlan::Scan {
+                table,
+                alias: parse_alias(&tokens[end..]),
+            })
+        }
+        _ => Err(LumosError::Query(format!("Invalid table reference: {}", render(tokens)))),
+    }
+}
+
+/// Index of the parenthesis closing the one at position 0
+fn matching_paren(tokens: &[Token]) -> Option<usize> {
+    let mut depth = 0i32;
+    for (i, token) in tokens.iter().enumerate() {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(i);
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Alias following a table reference, with or without AS
+fn parse_alias(tokens: &[Token]) -> Option<String> {
+    let tokens = match tokens.first() {
+        Some(t) if t.is_keyword("AS") => &tokens[1..],
+        _ => tokens,
+    };
+    tokens.first().filter(|t| is_identifier(t)).and_then(identifier_text)
+}
+
+fn is_identifier(token: &Token) -> bool {
+    matches!(token, Token::Word(_) | Token::QuotedIdent(_))
+}
+
+fn identifier_text(token: &Token) -> Option<String> {
+    match token {
+        Token::Word(w) | Token::QuotedIdent(w) => Some(w.clone()),
+        _ => None,
+    }
+}
+
+/// Whether an expression calls an aggregate function
+fn contains_aggregate(tokens: &[Token]) -> bool {
+    tokens.windows(2).any(|w| match (&w[0], &w[1]) {
+        (Token::Word(name), Token::Symbol('(')) => is_aggregate_function(name),
+        _ => false,
+    })
+}
+
+/// Split tokens on top-level commas
+fn split_commas(tokens: &[Token]) -> Vec<&[Token]> {
+    let mut parts = Vec::new();
+    let mut depth = 0i32;
+    let mut start = 0;
+
+    for (i, token) in tokens.iter().enumerate() {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            Token::Symbol(',') if depth == 0 => {
+                parts.push(&tokens[start..i]);
+                start = i + 1;
+            }
+            _ => {}
+        }
+    }
+    parts.push(&tokens[start..]);
+    parts
+}
+
+/// Render tokens back to SQL text
+pub(crate) fn render(tokens: &[Token]) -> String {
+    let mut out = String::new();
+    let mut prev: Option<&Token> = None;
+
+    for token in tokens {
+        let text = match token {
+            Token::Word(w) => w.clone(),
+            Token::QuotedIdent(q) => format!("\"{}\"", q.replace('"', "\"\"")),
+            Token::String(s) => format!("'{}'", s.replace('\'', "''")),
+            Token::Number(n) | Token::Param(n) => n.clone(),
+            Token::Symbol(c) => c.to_string(),
+        };
+
+        let attach = match (prev, token) {
+            (None, _) => true,
+            (_, Token::Symbol(',')) | (_, Token::Symbol(')')) | (_, Token::Symbol('.')) => true,
+            (Some(Token::Symbol('(')), _) | (Some(Token::Symbol('.')), _) => true,
+            (Some(Token::Word(_)), Token::Symbol('(')) => true,
+            // Multi-character operators such as <>, >=, != and ||
+            (Some(Token::Symbol(a)), Token::Symbol(b)) => is_operator_char(*a) && is_operator_char(*b),
+            _ => false,
+        };
+        if !attach {
+            out.push(' ');
+        }
+        out.push_str(&text);
+        prev = Some(token);
+    }
+
+    out
+}
+
+/// Characters that combine into multi-character operators
+fn is_operator_char(c: char) -> bool {
+    matches!(c, '<' | '>' | '=' | '!' | '|')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_plan_select() {
+        let plan = QueryPlanner::new().plan(
+            "SELECT u.name, COUNT(o.id) AS orders FROM main.users u \
+             LEFT OUTER JOIN orders AS o ON o.user_id = u.id \
+             WHERE u.active = 1 GROUP BY u.name HAVING COUNT(o.id) > 2 \
+             ORDER BY orders DESC LIMIT 10 OFFSET 5;"
+        ).unwrap();
+
+        let expected = LogicalPlan::Limit {
+            limit: "10".to_string(),
+            offset: Some("5".to_string()),
+            input: Box::new(LogicalPlan::Sort {
+                keys: vec!["orders DESC".to_string()],
+                input: Box::new(LogicalPlan::Projection {
+                    columns: vec!["u.name".to_string(), "COUNT(o.id) AS orders".to_string()],
+                    distinct: false,
+                    input: Box::new(LogicalPlan::Filter {
+                        predicate: "COUNT(o.id) > 2".to_string(),
+                        input: Box::new(LogicalPlan::Aggregate {
+                            group_by: vec!["u.name".to_string()],
+                            aggregates: vec!["COUNT(o.id) AS orders".to_string()],
+                            input: Box::new(LogicalPlan::Filter {
+                                predicate: "u.active = 1".to_string(),
+                                input: Box::new(LogicalPlan::Join {
+                                    join_type: "LEFT".to_string(),
+                                    condition: Some("o.user_id = u.id".to_string()),
+                                    left: Box::new(LogicalPlan::Scan {
+                                        table: "users".to_string(),
+                                        alias: Some("u".to_string()),
+                                    }),
+                                    right: Box::new(LogicalPlan::Scan {
+                                        table: "orders".to_string(),
+                                        alias: Some("o".to_string()),
+                                    }),
+                                }),
+                            }),
+                        }),
+                    }),
+                }),
+            }),
+        };
+        assert_eq!(plan, expected);
+        assert_eq!(plan.tables(), vec!["users".to_string(), "orders".to_string()]);
+    }
+
+    #[test]
+    fn test_plan_subquery_and_cross_join() {
+        let plan = QueryPlanner::new()
+            .plan("SELECT DISTINCT t.x FROM (SELECT x FROM a WHERE x > 0) t, b")
+            .unwrap();
+
+        match plan {
+            LogicalPlan::Projection { distinct, input, .. } => {
+                assert!(distinct);
+                match *input {
+                    LogicalPlan::Join { ref join_type, ref left, .. } => {
+                        assert_eq!(join_type, "CROSS");
+                        assert!(matches!(**left, LogicalPlan::Subquery { ref alias, .. } if alias.as_deref() == Some("t")));
+                    }
+                    ref other => panic!("unexpected plan: {:?}", other),
+                }
+                assert_eq!(input.tables(), vec!["a".to_string(), "b".to_string()]);
+            }
+            other => panic!("unexpected plan: {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_plan_rejects_unsupported() {
+        let planner = QueryPlanner::new();
+        assert!(planner.plan("DELETE FROM t").is_err());
+        assert!(planner.plan("SELECT 1 UNION SELECT 2").is_err());
+        assert!(planner.plan("SELECT * FROM a LEFT").is_err());
+        assert!(planner.plan("SELECT * FROM a JOIN").is_err());
+        assert!(planner.plan("SELECT * FROM a JOIN b ON LEFT(a.x, 2) = b.y").is_ok());
+        assert_eq!(planner.plan("SELECT 1").unwrap(), LogicalPlan::Projection {
+            columns: vec!["1".to_string()],
+            distinct: false,
+            input: Box::new(LogicalPlan::Empty),
+        });
+    }
+}
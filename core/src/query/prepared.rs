@@ -0,0 +1,183 @@
+use std::collections::{HashMap, VecDeque};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use crate::query::{QueryType, EngineType};
+
+/// Default number of prepared statements kept by a cache
+pub const DEFAULT_PREPARED_CACHE_SIZE: usize = 256;
+
+/// A statement that has been parsed and routed once
+///
+/// Executing a prepared statement skips query classification and routing.
+/// The engines keep the compiled statements from their `prepare` in
+/// per-connection caches keyed by the same SQL text, sized to
+/// `DEFAULT_PREPARED_CACHE_SIZE`, so execution also skips parsing and planning.
+#[derive(Debug, Clone, PartialEq)]
+pub struct PreparedStatement {
+    /// Statement id, unique within the cache that created it
+    pub id: u64,
+    /// SQL text
+    pub sql: String,
+    /// Query type
+    pub query_type: QueryType,
+    /// Engine the statement runs on
+    pub engine: EngineType,
+    /// Number of bind parameters, if the engine reports it
+    pub param_count: Option<usize>,
+}
+
+/// Prepared statement cache statistics
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct PreparedCacheStats {
+    /// Number of cached statements
+    pub entries: usize,
+    /// Lookups that found a cached statement
+    pub hits: u64,
+    /// Lookups that had to prepare the statement
+    pub misses: u64,
+}
+
+/// Cache key: the requested engine and the SQL text
+///
+/// The same SQL prepared for different engines routes differently, so the
+/// requested engine (before `Auto` is resolved) is part of the key.
+type StatementKey = (EngineType, String);
+
+/// Cache of prepared statements keyed by requested engine and SQL text
+///
+/// When full, the statement prepared first is evicted.
+pub struct PreparedStatementCache {
+    statements: Mutex<(HashMap<StatementKey, Arc<PreparedStatement>>, VecDeque<StatementKey>)>,
+    capacity: usize,
+    next_id: AtomicU64,
+    hits: AtomicU64,
+    misses: AtomicU64,
+}
+
+impl PreparedStatementCache {
+    /// Create a cache holding up to `capacity` statements
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            statements: Mutex::new((HashMap::new(), VecDeque::new())),
+            capacity: capacity.max(1),
+            next_id: AtomicU64::new(1),
+            hits: AtomicU64::new(0),
+            misses: AtomicU64::new(0),
+        }
+    }
+
+    /// Get the statement for `sql` on `engine`, preparing it with `prepare` on a miss
+    pub fn get_or_prepare<F>(&self, sql: &str, engine: EngineType, prepare: F) -> crate::Result<Arc<PreparedStatement>>
+    where
+        F: FnOnce(u64) -> crate::Result<PreparedStatement>,
+    {
+        let key = (engine, sql.to_string());
+        if let Some(statement) = self.statements.lock().unwrap().0.get(&key) {
+            self.hits.fetch_add(1, Ordering::Relaxed);
+            return Ok(statement.clone());
+        }
+
+        // Prepare without holding the lock; a concurrent prepare of the same
+        // SQL just replaces the entry
+        self.misses.fetch_add(1, Ordering::Relaxed);
+        let statement = Arc::new(prepare(self.next_id.fetch_add(1, Ordering::Relaxed))?);
+
+        let mut guard = self.statements.lock().unwrap();
+        let (statements, order) = &mut *guard;
+        if statements.insert(key.clone(), statement.clone()).is_none() {
+            order.push_back(key);
+        }
+        while statements.len() > self.capacity {
+            match order.pop_front() {
+                Some(oldest) => {
+                    statements.remove(&oldest);
+                }
+                None => break,
+            }
+        }
+
+        Ok(statement)
+    }
+
+    /// Remove the statement for `sql` on `engine`
+    pub fn remove(&self, sql: &str, engine: EngineType) -> bool {
+        let key = (engine, sql.to_string());
+        let mut guard = self.statements.lock().unwrap();
+        let (statements, order) = &mut *guard;
+        order.retain(|k| *k != key);
+        statements.remove(&key).is_some()
+    }
+
+    /// Remove all statements, e.g. after a schema change
+    pub fn clear(&self) {
+        let mut guard = self.statements.lock().unwrap();
+        guard.0.clear();
+        guard.1.clear();
+    }
+
+    /// Get cache statistics
+    pub fn stats(&self) -> PreparedCacheStats {
+        PreparedCacheStats {
+            entries: self.statements.lock().unwrap().0.len(),
+            hits: self.hits.load(Ordering::Relaxed),
+            misses: self.misses.load(Ordering::Relaxed),
+        }
+    }
+}
+
+impl Default for PreparedStatementCache {
+    fn default() -> Self {
+        Self::new(DEFAULT_PREPARED_CACHE_SIZE)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn prepare(sql: &str) -> impl FnOnce(u64) -> crate::Result<PreparedStatement> + '_ {
+        prepare_on(sql, EngineType::Sqlite)
+    }
+
+    fn prepare_on(sql: &str, engine: EngineType) -> impl FnOnce(u64) -> crate::Result<PreparedStatement> + '_ {
+        move |id| Ok(PreparedStatement {
+            id,
+            sql: sql.to_string(),
+            query_type: QueryType::Select,
+            engine,
+            param_count: Some(1),
+        })
+    }
+
+    #[test]
+    fn test_prepared_cache() {
+        let cache = PreparedStatementCache::new(2);
+
+        let first = cache.get_or_prepare("SELECT ?", EngineType::Sqlite, prepare("SELECT ?")).unwrap();
+        let again = cache.get_or_prepare("SELECT ?", EngineType::Sqlite, prepare("SELECT ?")).unwrap();
+        assert_eq!(first.id, again.id);
+
+        cache.get_or_prepare("SELECT 2", EngineType::Sqlite, prepare("SELECT 2")).unwrap();
+        cache.get_or_prepare("SELECT 3", EngineType::Sqlite, prepare("SELECT 3")).unwrap();
+
+        // The oldest statement was evicted
+        let evicted = cache.get_or_prepare("SELECT ?", EngineType::Sqlite, prepare("SELECT ?")).unwrap();
+        assert_ne!(first.id, evicted.id);
+        assert_eq!(cache.stats(), PreparedCacheStats { entries: 2, hits: 1, misses: 4 });
+
+        assert!(cache.remove("SELECT 3", EngineType::Sqlite));
+        cache.clear();
+        assert_eq!(cache.stats().entries, 0);
+    }
+
+    #[test]
+    fn test_prepared_cache_keys_by_engine() {
+        let cache = PreparedStatementCache::new(4);
+
+        let sqlite = cache.get_or_prepare("SELECT 1", EngineType::Sqlite, prepare_on("SELECT 1", EngineType::Sqlite)).unwrap();
+        let duckdb = cache.get_or_prepare("SELECT 1", EngineType::DuckDb, prepare_on("SELECT 1", EngineType::DuckDb)).unwrap();
+        assert_ne!(sqlite.id, duckdb.id);
+        assert_eq!(duckdb.engine, EngineType::DuckDb);
+        assert_eq!(cache.stats().entries, 2);
+    }
+}
@@ -0,0 +1,367 @@
+use std::collections::HashSet;
+use crate::query::lexer::{tokenize, Token};
+use crate::query::planner::{render, LogicalPlan};
+
+/// Push filter predicates as close to the scans as possible
+///
+/// WHERE predicates are split on top-level AND. Each conjunct is moved below
+/// joins and into subqueries when every column it references is qualified
+/// with an alias of that input. Conjuncts are never pushed into the
+/// null-extended side of an outer join, below a LIMIT, or into a grouped,
+/// windowed or DISTINCT subquery. Predicates that cannot move stay where they were.
+pub fn push_down_predicates(plan: LogicalPlan) -> LogicalPlan {
+    push(plan, Vec::new())
+}
+
+/// Sink `conjuncts` into `plan`, optimizing the whole subtree
+fn push(plan: LogicalPlan, mut conjuncts: Vec<String>) -> LogicalPlan {
+    match plan {
+        LogicalPlan::Filter { predicate, input } => {
+            conjuncts.extend(split_conjuncts(&predicate));
+            push(*input, conjuncts)
+        }
+        LogicalPlan::Join { join_type, condition, left, right } => {
+            let left_aliases = aliases(&left);
+            let right_aliases = aliases(&right);
+            let (to_left_allowed, to_right_allowed) = match join_type.as_str() {
+                "INNER" | "CROSS" => (true, true),
+                "LEFT" => (true, false),
+                "RIGHT" => (false, true),
+                _ => (false, false),
+            };
+
+            let mut to_left = Vec::new();
+            let mut to_right = Vec::new();
+            let mut remaining = Vec::new();
+            for conjunct in conjuncts {
+                let qualifiers = qualifiers(&conjunct);
+                if to_left_allowed && references_only(&qualifiers, &left_aliases) {
+                    to_left.push(conjunct);
+                } else if to_right_allowed && references_only(&qualifiers, &right_aliases) {
+                    to_right.push(conjunct);
+                } else {
+                    remaining.push(conjunct);
+                }
+            }
+
+            let join = LogicalPlan::Join {
+                join_type,
+                condition,
+                left: Box::new(push(*left, to_left)),
+                right: Box::new(push(*right, to_right)),
+            };
+            wrap(join, remaining)
+        }
+        LogicalPlan::Subquery { alias, input } => {
+            let mut pushed = Vec::new();
+            let mut remaining = Vec::new();
+            for conjunct in conjuncts {
+                match alias.as_deref().and_then(|a| rewrite_into_subquery(&conjunct, a, &input)) {
+                    Some(rewritten) => pushed.push(rewritten),
+                    None => remaining.push(conjunct),
+                }
+            }
+
+            let input = match *input {
+                // Filters go below the projection of the subquery
+                LogicalPlan::Projection { columns, distinct, input } if !pushed.is_empty() => {
+                    LogicalPlan::Projection { columns, distinct, input: Box::new(push(*input, pushed)) }
+                }
+                other => push(other, Vec::new()),
+            };
+            wrap(LogicalPlan::Subquery { alias, input: Box::new(input) }, remaining)
+        }
+        other => {
+            let optimized = map_children(other);
+            wrap(optimized, conjuncts)
+        }
+    }
+}
+
+/// Optimize the children of a node that filters cannot pass through
+fn map_children(plan: LogicalPlan) -> LogicalPlan {
+    match plan {
+        LogicalPlan::Aggregate { group_by, aggregates, input } => {
+            LogicalPlan::Aggregate { group_by, aggregates, input: Box::new(push(*input, Vec::new())) }
+        }
+        LogicalPlan::Projection { columns, distinct, input } => {
+            LogicalPlan::Projection { columns, distinct, input: Box::new(push(*input, Vec::new())) }
+        }
+        LogicalPlan::Sort { keys, input } => {
+            LogicalPlan::Sort { keys, input: Box::new(push(*input, Vec::new())) }
+        }
+        LogicalPlan::Limit { limit, offset, input } => {
+            LogicalPlan::Limit { limit, offset, input: Box::new(push(*input, Vec::new())) }
+        }
+        other => other,
+    }
+}
+
+/// Put the remaining conjuncts in a filter above `plan`
+fn wrap(plan: LogicalPlan, conjuncts: Vec<String>) -> LogicalPlan {
+    if conjuncts.is_empty() {
+        plan
+    } else {
+        LogicalPlan::Filter {
+            predicate: conjuncts.join(" AND "),
+            input: Box::new(plan),
+        }
+    }
+}
+
+/// Split a predicate on top-level AND, leaving BETWEEN ... AND ... intact
+fn split_conjuncts(predicate: &str) -> Vec<String> {
+    let tokens = tokenize(predicate);
+    let mut conjuncts = Vec::new();
+    let mut depth = 0i32;
+    let mut pending_between = false;
+    let mut start = 0;
+
+    for (i, token) in tokens.iter().enumerate() {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth -= 1,
+            t if depth == 0 && t.is_keyword("BETWEEN") => pending_between = true,
+            t if depth == 0 && t.is_keyword("AND") => {
+                if pending_between {
+                    pending_between = false;
+                } else {
+                    conjuncts.push(render(&tokens[start..i]));
+                    start = i + 1;
+                }
+            }
+            t if depth == 0 && t.is_keyword("OR") => {
+                // A top-level OR makes the whole predicate a single conjunct
+                return vec![predicate.to_string()];
+            }
+            _ => {}
+        }
+    }
+    conjuncts.push(render(&tokens[start..]));
+    conjuncts
+}
+
+/// Table aliases (or table names) visible from a FROM-clause subtree
+fn aliases(plan: &LogicalPlan) -> HashSet<String> {
+    let mut names = HashSet::new();
+    match plan {
+        LogicalPlan::Scan { table, alias } => {
+            names.insert(alias.clone().unwrap_or_else(|| table.clone()).to_lowercase());
+        }
+        LogicalPlan::Subquery { alias: Some(alias), .. } => {
+            names.insert(alias.to_lowercase());
+        }
+        LogicalPlan::Join { left, right, .. } => {
+            names.extend(aliases(left));
+            names.extend(aliases(right));
+        }
+        LogicalPlan::Filter { input, .. } => names.extend(aliases(input)),
+        _ => {}
+    }
+    names
+}
+
+/// Column references of a conjunct: `Some(qualifier)` for `t.col`, `None` for an unqualified column
+fn qualifiers(conjunct: &str) -> Vec<Option<String>> {
+    let tokens = tokenize(conjunct);
+    let mut refs = Vec::new();
+    let mut i = 0;
+
+    while i < tokens.len() {
+        let is_ident = matches!(tokens[i], Token::Word(_) | Token::QuotedIdent(_));
+        if is_ident && tokens.get(i + 1) == Some(&Token::Symbol('.')) {
+            if let Token::Word(q) | Token::QuotedIdent(q) = &tokens[i] {
+                refs.push(Some(q.to_lowercase()));
+            }
+            i += 3;
+            continue;
+        }
+        if let Token::Word(w) = &tokens[i] {
+            // Function calls and keywords are not column references
+            let is_call = tokens.get(i + 1) == Some(&Token::Symbol('('));
+            if !is_call && !is_keyword(w) {
+                refs.push(None);
+            }
+        } else if let Token::QuotedIdent(_) = &tokens[i] {
+            refs.push(None);
+        }
+        i += 1;
+    }
+    refs
+}
+
+/// Whether every column reference is qualified with one of `aliases`
+fn references_only(refs: &[Option<String>], aliases: &HashSet<String>) -> bool {
+    !refs.is_empty() && refs.iter().all(|r| r.as_ref().map_or(false, |q| aliases.contains(q)))
+}
+
+/// Rewrite `alias.col` references to `col` so the conjunct can be evaluated inside the subquery
+fn rewrite_into_subquery(conjunct: &str, alias: &str, input: &LogicalPlan) -> Option<String> {
+    let (columns, subquery_input) = match input {
+        LogicalPlan::Projection { columns, distinct: false, input } => (columns, input.as_ref()),
+        _ => return None,
+    };
+    // Filtering before grouping, limiting or windowing would change the result
+    if is_grouped_or_limited(subquery_input) || columns.iter().any(|c| has_window(c)) {
+        return None;
+    }
+
+    let refs = qualifiers(conjunct);
+    let mut aliases = HashSet::new();
+    aliases.insert(alias.to_lowercase());
+    if !references_only(&refs, &aliases) {
+        return None;
+    }
+
+    let tokens = tokenize(conjunct);
+    let mut rewritten = Vec::with_capacity(tokens.len());
+    let mut i = 0;
+    while i < tokens.len() {
+        let qualified = match (&tokens[i], tokens.get(i + 1), tokens.get(i + 2)) {
+            (Token::Word(q) | Token::QuotedIdent(q), Some(Token::Symbol('.')), Some(column))
+                if q.eq_ignore_ascii_case(alias) => Some(column),
+            _ => None,
+        };
+
+        match qualified {
+            Some(column) => {
+                let name = match column {
+                    Token::Word(c) | Token::QuotedIdent(c) => c,
+                    _ => return None,
+                };
+                // Only plain projected columns can be referenced without substitution
+                let projected = columns.iter().any(|c| {
+                    c == "*" || c.eq_ignore_ascii_case(name) || c.to_lowercase().ends_with(&format!(".{}", name.to_lowercase()))
+                });
+                if !projected {
+                    return None;
+                }
+                rewritten.push(column.clone());
+                i += 3;
+            }
+            None => {
+                rewritten.push(tokens[i].clone());
+                i += 1;
+            }
+        }
+    }
+
+    Some(render(&rewritten))
+}
+
+/// Whether a subquery body groups or limits rows below its projection
+///
+/// HAVING plans as a Filter above the Aggregate, so filters are looked through.
+fn is_grouped_or_limited(plan: &LogicalPlan) -> bool {
+    match plan {
+        LogicalPlan::Aggregate { .. } | LogicalPlan::Limit { .. } => true,
+        LogicalPlan::Filter { input, .. } => is_grouped_or_limited(input),
+        _ => false,
+    }
+}
+
+/// Whether a projected column calls a window function
+fn has_window(column: &str) -> bool {
+    tokenize(column).iter().any(|t| t.is_keyword("OVER"))
+}
+
+/// SQL keywords that may appear in a predicate
+fn is_keyword(word: &str) -> bool {
+    const KEYWORDS: &[&str] = &[
+        "AND", "OR", "NOT", "IS", "NULL", "IN", "LIKE", "GLOB", "BETWEEN", "EXISTS",
+        "TRUE", "FALSE", "CASE", "WHEN", "THEN", "ELSE", "END", "ESCAPE", "COLLATE",
+    ];
+    KEYWORDS.iter().any(|k| k.eq_ignore_ascii_case(word))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::planner::QueryPlanner;
+
+    fn plan(sql: &str) -> LogicalPlan {
+        push_down_predicates(QueryPlanner::new().plan(sql).unwrap())
+    }
+
+    fn scan(table: &str, alias: &str) -> LogicalPlan {
+        LogicalPlan::Scan { table: table.to_string(), alias: Some(alias.to_string()) }
+    }
+
+    fn filter(predicate: &str, input: LogicalPlan) -> LogicalPlan {
+        LogicalPlan::Filter { predicate: predicate.to_string(), input: Box::new(input) }
+    }
+
+    fn projection_input(plan: LogicalPlan) -> LogicalPlan {
+        match plan {
+            LogicalPlan::Projection { input, .. } => *input,
+            other => panic!("expected projection, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_push_below_inner_join() {
+        let optimized = plan(
+            "SELECT * FROM users u JOIN orders o ON o.user_id = u.id \
+             WHERE u.age BETWEEN 18 AND 30 AND o.total > 10 AND u.id = o.user_id"
+        );
+
+        assert_eq!(projection_input(optimized), filter(
+            "u.id = o.user_id",
+            LogicalPlan::Join {
+                join_type: "INNER".to_string(),
+                condition: Some("o.user_id = u.id".to_string()),
+                left: Box::new(filter("u.age BETWEEN 18 AND 30", scan("users", "u"))),
+                right: Box::new(filter("o.total > 10", scan("orders", "o"))),
+            },
+        ));
+    }
+
+    #[test]
+    fn test_outer_join_keeps_null_extended_side() {
+        let optimized = plan("SELECT * FROM users u LEFT JOIN orders o ON o.user_id = u.id WHERE o.total > 10 AND u.active = 1");
+
+        assert_eq!(projection_input(optimized), filter(
+            "o.total > 10",
+            LogicalPlan::Join {
+                join_type: "LEFT".to_string(),
+                condition: Some("o.user_id = u.id".to_string()),
+                left: Box::new(filter("u.active = 1", scan("users", "u"))),
+                right: Box::new(scan("orders", "o")),
+            },
+        ));
+    }
+
+    #[test]
+    fn test_push_into_subquery() {
+        let optimized = plan("SELECT * FROM (SELECT id, name FROM users) s WHERE s.id > 5 AND name <> 'x'");
+
+        assert_eq!(projection_input(optimized), filter(
+            "name <> 'x'",
+            LogicalPlan::Subquery {
+                alias: Some("s".to_string()),
+                input: Box::new(LogicalPlan::Projection {
+                    columns: vec!["id".to_string(), "name".to_string()],
+                    distinct: false,
+                    input: Box::new(LogicalPlan::Filter {
+                        predicate: "id > 5".to_string(),
+                        input: Box::new(LogicalPlan::Scan { table: "users".to_string(), alias: None }),
+                    }),
+                }),
+            },
+        ));
+
+        // Grouped subqueries are left alone
+        let grouped = plan("SELECT * FROM (SELECT id, COUNT(*) FROM t GROUP BY id) s WHERE s.id > 5");
+        assert!(matches!(projection_input(grouped), LogicalPlan::Filter { .. }));
+        let having = plan("SELECT * FROM (SELECT id, COUNT(*) FROM t GROUP BY id HAVING COUNT(*) > 1) s WHERE s.id > 5");
+        assert!(matches!(projection_input(having), LogicalPlan::Filter { .. }));
+        let windowed = plan("SELECT * FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY id) rn FROM t) s WHERE s.id > 5");
+        assert!(matches!(projection_input(windowed), LogicalPlan::Filter { .. }));
+    }
+
+    #[test]
+    fn test_or_is_not_split() {
+        assert_eq!(split_conjuncts("a.x = 1 OR b.y = 2"), vec!["a.x = 1 OR b.y = 2".to_string()]);
+        assert_eq!(split_conjuncts("(a.x = 1 OR a.y = 2) AND b.z = 3"), vec!["(a.x = 1 OR a.y = 2)".to_string(), "b.z = 3".to_string()]);
+    }
+}
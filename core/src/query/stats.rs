@@ -0,0 +1,371 @@
+use std::collections::HashMap;
+use std::sync::RwLock;
+use std::time::{Duration, Instant, SystemTime};
+use serde::{Serialize, Deserialize};
+use crate::{LumosError, Result};
+use crate::sqlite::SqliteEngine;
+use crate::duckdb::DuckDbEngine;
+use crate::query::EngineType;
+use crate::query::lexer::{tokenize, Token};
+use crate::query::planner::LogicalPlan;
+
+/// Default interval between automatic statistics collections
+pub const DEFAULT_STATS_INTERVAL: Duration = Duration::from_secs(3600);
+
+/// Selectivity assumed for predicates the statistics cannot estimate
+pub(crate) const DEFAULT_SELECTIVITY: f64 = 1.0 / 3.0;
+
+/// Statistics of one column
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct ColumnStats {
+    /// Column name
+    pub name: String,
+    /// Number of distinct values, exact or approximate
+    pub distinct_count: Option<u64>,
+    /// Number of NULL values
+    pub null_count: Option<u64>,
+}
+
+/// Statistics of one table
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct TableStats {
+    /// Table name
+    pub table: String,
+    /// Engine the statistics were collected from
+    pub engine: EngineType,
+    /// Number of rows
+    pub row_count: u64,
+    /// Column statistics; SQLite only reports the leading column of each index
+    pub columns: Vec<ColumnStats>,
+    /// Collection time
+    pub collected_at: SystemTime,
+}
+
+impl TableStats {
+    /// Statistics of a column, ignoring case
+    pub fn column(&self, name: &str) -> Option<&ColumnStats> {
+        self.columns.iter().find(|c| c.name.eq_ignore_ascii_case(name))
+    }
+}
+
+/// Collects table and column statistics for the planner's row estimates
+///
+/// Collection runs `ANALYZE` on SQLite and summary queries on DuckDB. Like
+/// the sync manager, the collector does not run its own thread: callers
+/// trigger `collect` directly or call `collect_if_due` periodically.
+pub struct StatsCollector {
+    stats: RwLock<HashMap<String, TableStats>>,
+    interval: Duration,
+    last_run: RwLock<Option<Instant>>,
+}
+
+impl StatsCollector {
+    /// Create a collector that is due every `interval`
+    pub fn new(interval: Duration) -> Self {
+        Self {
+            stats: RwLock::new(HashMap::new()),
+            interval,
+            last_run: RwLock::new(None),
+        }
+    }
+
+    /// Collect statistics from both engines, returning the number of tables
+    ///
+    /// DuckDB statistics replace SQLite ones for tables present in both,
+    /// since they include all columns.
+    pub fn collect(&self, sqlite: &SqliteEngine, duckdb: &DuckDbEngine) -> Result<usize> {
+        let mut collected = HashMap::new();
+
+        for table in collect_sqlite(sqlite)? {
+            collected.insert(table.table.to_lowercase(), table);
+        }
+        match collect_duckdb(duckdb) {
+            Ok(tables) => {
+                for table in tables {
+                    collected.insert(table.table.to_lowercase(), table);
+                }
+            }
+            // DuckDB may not be initialized; SQLite statistics are still useful
+            Err(e) => log::warn!("Skipping DuckDB statistics: {}", e),
+        }
+
+        let count = collected.len();
+        *self.stats.write().unwrap() = collected;
+        *self.last_run.write().unwrap() = Some(Instant::now());
+        log::info!("Collected statistics for {} tables", count);
+        Ok(count)
+    }
+
+    /// Collect statistics if the interval has passed since the last run
+    pub fn collect_if_due(&self, sqlite: &SqliteEngine, duckdb: &DuckDbEngine) -> Result<Option<usize>> {
+        let due = self.last_run.read().unwrap().map_or(true, |last| last.elapsed() >= self.interval);
+        if !due {
+            return Ok(None);
+        }
+        self.collect(sqlite, duckdb).map(Some)
+    }
+
+    /// Collect SQLite statistics if the interval has passed, for callers without a DuckDB engine
+    ///
+    /// Statistics of tables collected from DuckDB are kept.
+    pub fn collect_sqlite_if_due(&self, sqlite: &SqliteEngine) -> Result<Option<usize>> {
+        let due = self.last_run.read().unwrap().map_or(true, |last| last.elapsed() >= self.interval);
+        if !due {
+            return Ok(None);
+        }
+        let tables = collect_sqlite(sqlite)?;
+        let count = tables.len();
+        {
+            let mut stats = self.stats.write().unwrap();
+            stats.retain(|_, table| table.engine != EngineType::Sqlite);
+            for table in tables {
+                stats.insert(table.table.to_lowercase(), table);
+            }
+        }
+        *self.last_run.write().unwrap() = Some(Instant::now());
+        log::info!("Collected statistics for {} SQLite tables", count);
+        Ok(Some(count))
+    }
+
+    /// Statistics of a table, ignoring case
+    pub fn table(&self, name: &str) -> Option<TableStats> {
+        self.stats.read().unwrap().get(&name.to_lowercase()).cloned()
+    }
+
+    /// Statistics of all tables
+    pub fn tables(&self) -> Vec<TableStats> {
+        self.stats.read().unwrap().values().cloned().collect()
+    }
+
+    /// Replace the statistics of a table
+    pub fn set_table(&self, stats: TableStats) {
+        self.stats.write().unwrap().insert(stats.table.to_lowercase(), stats);
+    }
+
+    /// Estimate the number of rows a plan produces
+    ///
+    /// Returns `None` when a scanned table has no statistics.
+    pub fn estimate_rows(&self, plan: &LogicalPlan) -> Option<u64> {
+        let stats = self.stats.read().unwrap();
+        estimate(plan, &stats).map(|rows| rows.round() as u64)
+    }
+}
+
+impl Default for StatsCollector {
+    fn default() -> Self {
+        Self::new(DEFAULT_STATS_INTERVAL)
+    }
+}
+
+/// Row estimate for a plan node
+fn estimate(plan: &LogicalPlan, stats: &HashMap<String, TableStats>) -> Option<f64> {
+    match plan {
+        LogicalPlan::Scan { table, .. } => stats.get(&table.to_lowercase()).map(|t| t.row_count as f64),
+        LogicalPlan::Empty => Some(1.0),
+        LogicalPlan::Subquery { input, .. } |
+        LogicalPlan::Projection { input, .. } |
+        LogicalPlan::Sort { input, .. } => estimate(input, stats),
+        LogicalPlan::Filter { predicate, input } => {
+            let rows = estimate(input, stats)?;
+            let tables = input.tables();
+            Some(rows * selectivity(predicate, &tables, stats))
+        }
+        LogicalPlan::Join { join_type, condition, left, right } => {
+            let (l, r) = (estimate(left, stats)?, estimate(right, stats)?);
+            if condition.is_none() || join_type.to_uppercase().contains("CROSS") {
+                Some(l * r)
+            } else {
+                // Equi-join on a key: each row matches about one row on the other side
+                Some(l.max(r))
+            }
+        }
+        LogicalPlan::Aggregate { group_by, input, .. } => {
+            let rows = estimate(input, stats)?;
+            if group_by.is_empty() {
+                return Some(1.0);
+            }
+            let tables = input.tables();
+            let groups = group_by.iter()
+                .map(|column| distinct(column, &tables, stats).unwrap_or(rows))
+                .product::<f64>();
+            Some(groups.min(rows))
+        }
+        LogicalPlan::Limit { limit, input, .. } => {
+            let rows = estimate(input, stats)?;
+            Some(limit.trim().parse::<f64>().map_or(rows, |n| rows.min(n)))
+        }
+    }
+}
+
+/// Fraction of rows kept by a predicate
+///
+/// Conjuncts are treated as independent. `column = value` keeps one
+/// distinct value's share of the rows; other comparisons use a default.
+fn selectivity(predicate: &str, tables: &[String], stats: &HashMap<String, TableStats>) -> f64 {
+    let tokens = tokenize(predicate);
+    let mut result = 1.0;
+
+    for conjunct in tokens.split(|t| t.is_keyword("AND")) {
+        let column = match conjunct {
+            [Token::Word(c), Token::Symbol('='), value] |
+            [Token::Word(_), Token::Symbol('.'), Token::Word(c), Token::Symbol('='), value]
+                if !matches!(value, Token::Word(_)) => Some(c),
+            _ => None,
+        };
+
+        result *= column
+            .and_then(|c| distinct(c, tables, stats))
+            .filter(|d| *d >= 1.0)
+            .map_or(DEFAULT_SELECTIVITY, |d| 1.0 / d);
+    }
+
+    result
+}
+
+/// Distinct values of a (possibly qualified) column in any of `tables`
+fn distinct(column: &str, tables: &[String], stats: &HashMap<String, TableStats>) -> Option<f64> {
+    let column = column.rsplit('.').next().unwrap_or(column).trim();
+    tables.iter()
+        .filter_map(|t| stats.get(&t.to_lowercase()))
+        .find_map(|t| t.column(column).and_then(|c| c.distinct_count))
+        .map(|d| d as f64)
+}
+
+/// Run ANALYZE and read row counts and index statistics from sqlite_stat1
+fn collect_sqlite(engine: &SqliteEngine) -> Result<Vec<TableStats>> {
+    let conn = engine.connection()?;
+    let conn = &conn.conn;
+    conn.execute_batch("ANALYZE")?;
+
+    let mut stmt = conn.prepare("SELECT tbl, idx, stat FROM sqlite_stat1")?;
+    let rows = stmt.query_map([], |row| {
+        Ok((row.get::<_, String>(0)?, row.get::<_, Option<String>>(1)?, row.get::<_, String>(2)?))
+    })?.collect::<std::result::Result<Vec<_>, _>>()?;
+
+    let now = SystemTime::now();
+    let mut tables: HashMap<String, TableStats> = HashMap::new();
+
+    for (table, index, stat) in rows {
+        // stat is "rows avg_rows_per_key1 avg_rows_per_key2 ..."
+        let numbers: Vec<u64> = stat.split_whitespace().filter_map(|n| n.parse().ok()).collect();
+        let row_count = numbers.first().copied().unwrap_or(0);
+
+        let entry = tables.entry(table.clone()).or_insert_with(|| TableStats {
+            table: table.clone(),
+            engine: EngineType::Sqlite,
+            row_count,
+            columns: Vec::new(),
+            collected_at: now,
+        });
+        entry.row_count = entry.row_count.max(row_count);
+
+        let (index, per_key) = match (index, numbers.get(1)) {
+            (Some(index), Some(per_key)) if *per_key > 0 => (index, *per_key),
+            _ => continue,
+        };
+        let leading: Option<String> = conn
+            .query_row(
+                &format!("SELECT name FROM pragma_index_info('{}') WHERE seqno = 0", index.replace('\'', "''")),
+                [],
+                |row| row.get(0),
+            )
+            .ok();
+        if let Some(column) = leading {
+            if entry.column(&column).is_none() {
+                entry.columns.push(ColumnStats {
+                    name: column,
+                    distinct_count: Some((row_count / per_key).max(1)),
+                    null_count: None,
+                });
+            }
+        }
+    }
+
+    Ok(tables.into_values().collect())
+}
+
+/// Count rows, approximate distinct values and NULLs of every DuckDB table
+fn collect_duckdb(engine: &DuckDbEngine) -> Result<Vec<TableStats>> {
+    let table_names = engine.query(
+        "SELECT table_name FROM information_schema.tables WHERE table_schema = 'main' AND table_type = 'BASE TABLE'",
+        &[],
+        |row| row.get::<_, String>(0),
+    )?;
+
+    let now = SystemTime::now();
+    let mut tables = Vec::with_capacity(table_names.len());
+
+    for table in table_names {
+        let columns = engine.query(
+            "SELECT column_name FROM information_schema.columns WHERE table_name = ? ORDER BY ordinal_position",
+            &[&table as &dyn duckdb::ToSql],
+            |row| row.get::<_, String>(0),
+        )?;
+
+        // One pass computing COUNT(*), then distinct and NULL counts per column
+        let mut select = vec!["COUNT(*)".to_string()];
+        for column in &columns {
+            let quoted = format!("\"{}\"", column.replace('"', "\"\""));
+            select.push(format!("approx_count_distinct({})", quoted));
+            select.push(format!("COUNT(*) - COUNT({})", quoted));
+        }
+        let sql = format!("SELECT {} FROM \"{}\"", select.join(", "), table.replace('"', "\"\""));
+
+        let counts = engine.query(&sql, &[], |row| {
+            (0..select.len()).map(|i| row.get::<_, i64>(i)).collect::<std::result::Result<Vec<_>, _>>()
+        })?;
+        let counts = counts.into_iter().next()
+            .ok_or_else(|| LumosError::DuckDb(format!("No statistics returned for table '{}'", table)))?;
+
+        tables.push(TableStats {
+            row_count: counts[0].max(0) as u64,
+            columns: columns.into_iter().enumerate()
+                .map(|(i, name)| ColumnStats {
+                    name,
+                    distinct_count: Some(counts[1 + 2 * i].max(0) as u64),
+                    null_count: Some(counts[2 + 2 * i].max(0) as u64),
+                })
+                .collect(),
+            table,
+            engine: EngineType::DuckDb,
+            collected_at: now,
+        });
+    }
+
+    Ok(tables)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::planner::QueryPlanner;
+
+    fn table(name: &str, rows: u64, columns: &[(&str, u64)]) -> TableStats {
+        TableStats {
+            table: name.to_string(),
+            engine: EngineType::DuckDb,
+            row_count: rows,
+            columns: columns.iter()
+                .map(|(c, d)| ColumnStats { name: c.to_string(), distinct_count: Some(*d), null_count: Some(0) })
+                .collect(),
+            collected_at: SystemTime::now(),
+        }
+    }
+
+    #[test]
+    fn test_estimate_rows() {
+        let collector = StatsCollector::default();
+        collector.set_table(table("orders", 10_000, &[("status", 4), ("customer_id", 1000)]));
+        collector.set_table(table("customers", 1000, &[("id", 1000), ("country", 50)]));
+
+        let planner = QueryPlanner::new();
+        let estimate = |sql: &str| collector.estimate_rows(&planner.plan(sql).unwrap());
+
+        assert_eq!(estimate("SELECT * FROM orders"), Some(10_000));
+        assert_eq!(estimate("SELECT * FROM orders WHERE status = 'open'"), Some(2_500));
+        assert_eq!(estimate("SELECT * FROM orders o WHERE o.status = 'open' AND o.customer_id = 7"), Some(3));
+        assert_eq!(estimate("SELECT country, COUNT(*) FROM customers GROUP BY country"), Some(50));
+        assert_eq!(estimate("SELECT * FROM orders LIMIT 10"), Some(10));
+        assert_eq!(estimate("SELECT * FROM unknown"), None);
+    }
+}
@@ -0,0 +1,406 @@
+use std::collections::HashMap;
+use rusqlite::Connection;
+use serde::{Serialize, Deserialize};
+use crate::Result;
+use crate::query::fingerprint::QueryShapeStats;
+use crate::query::lexer::{tokenize, Token};
+use crate::sqlite::explain::explain_query;
+use crate::sqlite::import::quote_identifier;
+
+/// A suggested index
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct IndexSuggestion {
+    /// Table to index
+    pub table: String,
+    /// Indexed columns, in index order
+    pub columns: Vec<String>,
+    /// Statement creating the index
+    pub create_sql: String,
+    /// Time spent in the slow queries that would use the index, in
+    /// milliseconds; an upper bound on the time the index saves
+    pub estimated_benefit_ms: f64,
+    /// Fingerprints of the slow queries that would use the index
+    pub queries: Vec<String>,
+}
+
+/// Columns a query filters, joins or sorts on, per table
+#[derive(Debug, Default, Clone, PartialEq)]
+pub struct ColumnUsage {
+    /// Columns compared with `=` or `IN`, and join columns
+    pub equality: Vec<String>,
+    /// Columns compared with a range operator, `LIKE` or `BETWEEN`
+    pub range: Vec<String>,
+    /// ORDER BY columns
+    pub order_by: Vec<String>,
+}
+
+impl ColumnUsage {
+    /// Index columns: equality columns first, then one range column or the
+    /// sort columns
+    pub fn index_columns(&self) -> Vec<String> {
+        let mut columns = self.equality.clone();
+        match self.range.first() {
+            Some(range) => push_unique(&mut columns, range),
+            None => {
+                for column in &self.order_by {
+                    push_unique(&mut columns, column);
+                }
+            }
+        }
+        columns
+    }
+}
+
+/// Suggest indexes for the slow query shapes in `stats`
+///
+/// Shapes averaging at least `slow_ms` are explained against `conn`. For
+/// every table the plan reads with a full scan or sorts with a temporary
+/// b-tree, the WHERE, JOIN and ORDER BY columns become a suggested index,
+/// unless an existing index already starts with those columns. Suggestions
+/// shared by several queries are merged, and the result is ordered by
+/// estimated benefit.
+pub fn advise_indexes(conn: &Connection, stats: &[QueryShapeStats], slow_ms: f64) -> Result<Vec<IndexSuggestion>> {
+    let mut suggestions: Vec<IndexSuggestion> = Vec::new();
+    let mut existing: HashMap<String, Vec<Vec<String>>> = HashMap::new();
+
+    for shape in stats.iter().filter(|s| s.avg_ms() >= slow_ms) {
+        // Queries the planner cannot handle (e.g. for a dropped table) are skipped
+        let plan = match explain_query(conn, &shape.sample, &[], false) {
+            Ok(plan) => plan,
+            Err(_) => continue,
+        };
+        let sorts = plan.optimizations.iter().any(|o| o.contains("temp b-tree"));
+
+        for (table, usage) in column_usage(conn, &shape.sample) {
+            let scanned = plan.full_scans.iter().any(|t| t.eq_ignore_ascii_case(&table));
+            if !scanned && !(sorts && !usage.order_by.is_empty()) {
+                continue;
+            }
+
+            let columns = usage.index_columns();
+            if columns.is_empty() {
+                continue;
+            }
+
+            if !existing.contains_key(&table) {
+                existing.insert(table.clone(), index_columns(conn, &table)?);
+            }
+            let covered = existing[&table].iter()
+                .any(|index| index.len() >= columns.len() && index[..columns.len()].iter().zip(&columns).all(|(a, b)| a.eq_ignore_ascii_case(b)));
+            if covered {
+                continue;
+            }
+
+            match suggestions.iter_mut().find(|s| s.table == table && s.columns == columns) {
+                Some(suggestion) => {
+                    suggestion.estimated_benefit_ms += shape.total_ms;
+                    suggestion.queries.push(shape.fingerprint.clone());
+                }
+                None => suggestions.push(IndexSuggestion {
+                    create_sql: format!(
+                        "CREATE INDEX IF NOT EXISTS {} ON {} ({})",
+                        quote_identifier(&format!("idx_{}_{}", table, columns.join("_"))),
+                        quote_identifier(&table),
+                        columns.iter().map(|c| quote_identifier(c)).collect::<Vec<_>>().join(", ")
+                    ),
+                    table,
+                    columns,
+                    estimated_benefit_ms: shape.total_ms,
+                    queries: vec![shape.fingerprint.clone()],
+                }),
+            }
+        }
+    }
+
+    suggestions.sort_by(|a, b| b.estimated_benefit_ms.partial_cmp(&a.estimated_benefit_ms).unwrap_or(std::cmp::Ordering::Equal));
+    Ok(suggestions)
+}
+
+/// Columns of each existing index on `table`, in index order
+fn index_columns(conn: &Connection, table: &str) -> Result<Vec<Vec<String>>> {
+    let mut stmt = conn.prepare(&format!("PRAGMA index_list(\"{}\")", table.replace('"', "\"\"")))?;
+    let names = stmt.query_map([], |row| row.get::<_, String>(1))?
+        .collect::<std::result::Result<Vec<_>, _>>()?;
+
+    let mut indexes = Vec::with_capacity(names.len());
+    for name in names {
+        let mut stmt = conn.prepare(&format!("PRAGMA index_info(\"{}\")", name.replace('"', "\"\"")))?;
+        let columns = stmt.query_map([], |row| Ok((row.get::<_, i64>(0)?, row.get::<_, Option<String>>(2)?)))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+
+        let mut columns: Vec<(i64, String)> = columns.into_iter()
+            .filter_map(|(seq, column)| column.map(|c| (seq, c)))
+            .collect();
+        columns.sort();
+        indexes.push(columns.into_iter().map(|(_, c)| c).collect());
+    }
+
+    Ok(indexes)
+}
+
+/// Resolve the columns a query uses to tables, looking up unqualified
+/// columns in the schema when the query reads several tables
+fn column_usage(conn: &Connection, sql: &str) -> Vec<(String, ColumnUsage)> {
+    let parsed = parse_column_usage(sql);
+    let tables: Vec<String> = parsed.tables.iter().map(|(_, table)| table.clone()).collect();
+
+    let mut usage: Vec<(String, ColumnUsage)> = Vec::new();
+    let mut schema: HashMap<String, Vec<String>> = HashMap::new();
+
+    for (qualifier, column, kind) in parsed.columns {
+        let table = match qualifier {
+            Some(q) => parsed.tables.iter()
+                .find(|(alias, table)| alias.eq_ignore_ascii_case(&q) || table.eq_ignore_ascii_case(&q))
+                .map(|(_, table)| table.clone()),
+            None if tables.len() == 1 => Some(tables[0].clone()),
+            None => tables.iter()
+                .find(|table| {
+                    schema.entry(table.to_string())
+                        .or_insert_with(|| table_columns(conn, table))
+                        .iter()
+                        .any(|c| c.eq_ignore_ascii_case(&column))
+                })
+                .cloned(),
+        };
+
+        let table = match table {
+            Some(table) => table,
+            None => continue,
+        };
+        let entry = match usage.iter().position(|(t, _)| *t == table) {
+            Some(i) => &mut usage[i].1,
+            None => {
+                usage.push((table, ColumnUsage::default()));
+                &mut usage.last_mut().unwrap().1
+            }
+        };
+        match kind {
+            UsageKind::Equality => push_unique(&mut entry.equality, &column),
+            UsageKind::Range => push_unique(&mut entry.range, &column),
+            UsageKind::OrderBy => push_unique(&mut entry.order_by, &column),
+        }
+    }
+
+    usage
+}
+
+/// Column names of `table`; empty when the table does not exist
+fn table_columns(conn: &Connection, table: &str) -> Vec<String> {
+    let sql = format!("PRAGMA table_info(\"{}\")", table.replace('"', "\"\""));
+    conn.prepare(&sql)
+        .and_then(|mut stmt| {
+            stmt.query_map([], |row| row.get::<_, String>(1))?
+                .collect::<std::result::Result<Vec<_>, _>>()
+        })
+        .unwrap_or_default()
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum UsageKind {
+    Equality,
+    Range,
+    OrderBy,
+}
+
+/// Tables and column references found in a query
+#[derive(Debug, Default)]
+struct ParsedUsage {
+    /// (alias, table) pairs; the alias is the table name when there is none
+    tables: Vec<(String, String)>,
+    /// (qualifier, column, kind) triples
+    columns: Vec<(Option<String>, String, UsageKind)>,
+}
+
+/// Find tables and the columns used in WHERE, JOIN ON and ORDER BY
+fn parse_column_usage(sql: &str) -> ParsedUsage {
+    #[derive(PartialEq)]
+    enum Clause { Other, From, Filter, OrderBy }
+
+    let tokens = tokenize(sql);
+    let mut parsed = ParsedUsage::default();
+    let mut clause = Clause::Other;
+    let mut i = 0;
+
+    while i < tokens.len() {
+        let token = &tokens[i];
+
+        if token.is_keyword("FROM") || token.is_keyword("JOIN") {
+            clause = Clause::From;
+            i += 1;
+            if let Some(name) = ident(tokens.get(i)) {
+                let mut alias = name.clone();
+                let mut next = i + 1;
+                if tokens.get(next).map_or(false, |t| t.is_keyword("AS")) {
+                    next += 1;
+                }
+                if let Some(a) = ident(tokens.get(next)).filter(|a| !is_clause_keyword(a)) {
+                    alias = a;
+                    next += 1;
+                }
+                parsed.tables.push((alias, name));
+                i = next;
+            }
+            continue;
+        }
+
+        if clause == Clause::From && token == &Token::Symbol(',') {
+            // Comma-separated FROM list
+            i += 1;
+            if let Some(name) = ident(tokens.get(i)) {
+                let alias = ident(tokens.get(i + 1)).filter(|a| !is_clause_keyword(a));
+                i += if alias.is_some() { 2 } else { 1 };
+                parsed.tables.push((alias.unwrap_or_else(|| name.clone()), name));
+            }
+            continue;
+        }
+
+        if token.is_keyword("WHERE") || token.is_keyword("ON") || token.is_keyword("HAVING") {
+            clause = Clause::Filter;
+        } else if token.is_keyword("ORDER") && tokens.get(i + 1).map_or(false, |t| t.is_keyword("BY")) {
+            clause = Clause::OrderBy;
+            i += 2;
+            continue;
+        } else if token.is_keyword("GROUP") || token.is_keyword("LIMIT") || token.is_keyword("UNION") {
+            clause = Clause::Other;
+        } else if clause != Clause::Other && clause != Clause::From {
+            if let Some((qualifier, column, next)) = column_ref(&tokens, i) {
+                let kind = if clause == Clause::OrderBy {
+                    Some(UsageKind::OrderBy)
+                } else {
+                    comparison(&tokens, next)
+                };
+                if let Some(kind) = kind {
+                    parsed.columns.push((qualifier, column, kind));
+                }
+                // In `a.x = b.y` the right-hand side is a join column too
+                if kind == Some(UsageKind::Equality) {
+                    let rhs = next + if tokens.get(next) == Some(&Token::Symbol('=')) { 1 } else { 0 };
+                    if let Some((q, c, after)) = column_ref(&tokens, rhs).filter(|(q, _, _)| q.is_some()) {
+                        parsed.columns.push((q, c, UsageKind::Equality));
+                        i = after;
+                        continue;
+                    }
+                }
+                i = next;
+                continue;
+            }
+        }
+
+        i += 1;
+    }
+
+    parsed
+}
+
+/// Read `column` or `qualifier.column` at `i`, returning the index after it
+fn column_ref(tokens: &[Token], i: usize) -> Option<(Option<String>, String, usize)> {
+    let first = ident(tokens.get(i)).filter(|w| !is_clause_keyword(w) && !is_operator_keyword(w))?;
+    // Function calls are not column references
+    if tokens.get(i + 1) == Some(&Token::Symbol('(')) {
+        return None;
+    }
+    if tokens.get(i + 1) == Some(&Token::Symbol('.')) {
+        let second = ident(tokens.get(i + 2))?;
+        return Some((Some(first), second, i + 3));
+    }
+    Some((None, first, i + 1))
+}
+
+/// Classify the comparison following a column reference
+///
+/// Negated comparisons (`!=`, `<>`, `NOT IN`, ...) cannot use an index and
+/// are ignored.
+fn comparison(tokens: &[Token], i: usize) -> Option<UsageKind> {
+    match tokens.get(i)? {
+        Token::Symbol('<') if tokens.get(i + 1) == Some(&Token::Symbol('>')) => None,
+        Token::Symbol('=') => Some(UsageKind::Equality),
+        Token::Symbol('<') | Token::Symbol('>') => Some(UsageKind::Range),
+        t if t.is_keyword("IN") || t.is_keyword("IS") => Some(UsageKind::Equality),
+        t if t.is_keyword("BETWEEN") || t.is_keyword("LIKE") => Some(UsageKind::Range),
+        _ => None,
+    }
+}
+
+/// Identifier text of a word or quoted identifier token
+fn ident(token: Option<&Token>) -> Option<String> {
+    match token? {
+        Token::Word(w) | Token::QuotedIdent(w) => Some(w.clone()),
+        _ => None,
+    }
+}
+
+/// Keywords that end a table reference or cannot be column names
+fn is_clause_keyword(word: &str) -> bool {
+    const KEYWORDS: [&str; 17] = [
+        "WHERE", "ON", "USING", "JOIN", "INNER", "LEFT", "RIGHT", "FULL", "CROSS", "OUTER",
+        "GROUP", "ORDER", "LIMIT", "HAVING", "UNION", "NATURAL", "SELECT",
+    ];
+    KEYWORDS.iter().any(|k| k.eq_ignore_ascii_case(word))
+}
+
+/// Boolean connectives and operators spelled as words
+fn is_operator_keyword(word: &str) -> bool {
+    ["AND", "OR", "NOT", "ASC", "DESC", "NULL", "IS", "IN", "LIKE", "BETWEEN", "EXISTS"]
+        .iter()
+        .any(|k| k.eq_ignore_ascii_case(word))
+}
+
+fn push_unique(columns: &mut Vec<String>, column: &str) {
+    if !columns.iter().any(|c| c.eq_ignore_ascii_case(column)) {
+        columns.push(column.to_string());
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::query::fingerprint::QueryStatsCollector;
+    use std::time::Duration;
+
+    #[test]
+    fn test_parse_column_usage() {
+        let parsed = parse_column_usage(
+            "SELECT o.id FROM orders o JOIN customers AS c ON o.customer_id = c.id \
+             WHERE o.status = 'open' AND o.created_at > 100 ORDER BY o.created_at DESC"
+        );
+        assert_eq!(parsed.tables, vec![
+            ("o".to_string(), "orders".to_string()),
+            ("c".to_string(), "customers".to_string()),
+        ]);
+        let columns: Vec<(&str, UsageKind)> = parsed.columns.iter().map(|(_, c, k)| (c.as_str(), *k)).collect();
+        assert_eq!(columns, vec![
+            ("customer_id", UsageKind::Equality),
+            ("id", UsageKind::Equality),
+            ("status", UsageKind::Equality),
+            ("created_at", UsageKind::Range),
+            ("created_at", UsageKind::OrderBy),
+        ]);
+
+        let parsed = parse_column_usage("SELECT * FROM orders WHERE status <> 'open' AND region != 'eu' AND id <= 5");
+        let columns: Vec<(&str, UsageKind)> = parsed.columns.iter().map(|(_, c, k)| (c.as_str(), *k)).collect();
+        assert_eq!(columns, vec![("id", UsageKind::Range)]);
+    }
+
+    #[test]
+    fn test_advise_indexes() {
+        let conn = Connection::open_in_memory().unwrap();
+        conn.execute_batch("
+            CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT, created_at INTEGER);
+            CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+            CREATE INDEX idx_users_email ON users (email);
+        ").unwrap();
+
+        let collector = QueryStatsCollector::default();
+        collector.record("SELECT * FROM orders WHERE status = 'open' AND created_at > 5", Duration::from_millis(300), 1, false);
+        collector.record("SELECT * FROM users WHERE email = 'a@b.c'", Duration::from_millis(300), 1, false);
+        collector.record("SELECT * FROM orders WHERE status = 'x'", Duration::from_millis(1), 1, false);
+
+        let suggestions = advise_indexes(&conn, &collector.snapshot(), 100.0).unwrap();
+        assert_eq!(suggestions.len(), 1);
+        assert_eq!(suggestions[0].columns, vec!["status", "created_at"]);
+        assert_eq!(
+            suggestions[0].create_sql,
+            "CREATE INDEX IF NOT EXISTS \"idx_orders_status_created_at\" ON \"orders\" (\"status\", \"created_at\")"
+        );
+    }
+}
@@ -135,6 +135,9 @@ impl ConnectionPool {
         // Set busy timeout
         conn.busy_timeout(Duration::from_secs(5))?;
         
+        // Keep as many compiled statements as the prepared statement cache
+        conn.set_prepared_statement_cache_capacity(super::STATEMENT_CACHE_CAPACITY);
+        
         *size += 1;
         
         Ok(PooledConn {
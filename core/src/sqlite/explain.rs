@@ -0,0 +1,327 @@
+use std::time::Instant;
+use rusqlite::{Connection, ToSql};
+use serde::{Serialize, Deserialize};
+use crate::Result;
+use crate::query::stats::{StatsCollector, DEFAULT_SELECTIVITY};
+
+/// A single node of a query plan tree
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PlanNode {
+    /// Node id reported by SQLite
+    pub id: i64,
+    /// Operation, e.g. SCAN, SEARCH, USE TEMP B-TREE, SUBQUERY
+    pub operation: String,
+    /// Raw detail text from EXPLAIN QUERY PLAN
+    pub detail: String,
+    /// Table accessed by this node, if any
+    pub table: Option<String>,
+    /// Index used by this node, if any
+    pub index: Option<String>,
+    /// Rows this node reads, estimated from table statistics
+    #[serde(default)]
+    pub estimated_rows: Option<u64>,
+    /// Estimated cost in rows visited, including index lookups
+    #[serde(default)]
+    pub cost: Option<f64>,
+    /// Child nodes
+    pub children: Vec<PlanNode>,
+}
+
+/// Structured query plan
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct QueryPlan {
+    /// Explained SQL statement
+    pub sql: String,
+    /// Engine that produced the plan
+    pub engine: String,
+    /// Top-level plan nodes
+    pub nodes: Vec<PlanNode>,
+    /// Whether any node uses an index or primary key lookup
+    pub uses_index: bool,
+    /// Tables read with a full scan
+    pub full_scans: Vec<String>,
+    /// Optimizations chosen by the planner, such as covering or automatic indexes
+    pub optimizations: Vec<String>,
+    /// Sum of the estimated node costs, when any node has an estimate
+    #[serde(default)]
+    pub estimated_cost: Option<f64>,
+    /// Rows returned when the query was executed (analyze mode only)
+    pub actual_rows: Option<usize>,
+    /// Execution time in milliseconds (analyze mode only)
+    pub elapsed_ms: Option<f64>,
+}
+
+/// Explain a query using `EXPLAIN QUERY PLAN` and return it as a tree
+///
+/// `params` are bound to the statement's placeholders. With `analyze` set,
+/// read-only statements are also executed to report the actual row count and
+/// execution time. Statements that modify the database are never executed.
+pub fn explain_query(conn: &Connection, sql: &str, params: &[&dyn ToSql], analyze: bool) -> Result<QueryPlan> {
+    let mut stmt = conn.prepare(&format!("EXPLAIN QUERY PLAN {}", sql))?;
+    let rows = stmt.query_map(params, |row| {
+        Ok((row.get::<_, i64>(0)?, row.get::<_, i64>(1)?, row.get::<_, String>(3)?))
+    })?;
+
+    let mut entries = Vec::new();
+    for row in rows {
+        entries.push(row?);
+    }
+
+    let nodes = build_tree(&entries, 0);
+
+    let mut plan = QueryPlan {
+        sql: sql.to_string(),
+        engine: "sqlite".to_string(),
+        nodes,
+        uses_index: false,
+        full_scans: Vec::new(),
+        optimizations: Vec::new(),
+        estimated_cost: None,
+        actual_rows: None,
+        elapsed_ms: None,
+    };
+
+    for (_, _, detail) in &entries {
+        summarize(detail, &mut plan);
+    }
+
+    if analyze {
+        let mut stmt = conn.prepare(sql)?;
+        if stmt.readonly() {
+            let start = Instant::now();
+            let mut rows = stmt.query(params)?;
+            let mut count = 0;
+            while rows.next()?.is_some() {
+                count += 1;
+            }
+            plan.actual_rows = Some(count);
+            plan.elapsed_ms = Some(start.elapsed().as_secs_f64() * 1000.0);
+        }
+    }
+
+    Ok(plan)
+}
+
+/// Attach row and cost estimates from `stats` to the SCAN and SEARCH nodes of `plan`
+///
+/// A full scan reads every row of its table. A search reads the rows matching
+/// its index constraints: one row for a primary key, 1/distinct of the table
+/// for an equality on a column with statistics, and a default share for
+/// other constraints, plus log2(rows) for the lookup. Nodes on tables without
+/// statistics get no estimate.
+pub fn annotate_estimates(plan: &mut QueryPlan, stats: &StatsCollector) {
+    let mut total = None;
+    for node in &mut plan.nodes {
+        annotate_node(node, stats, &mut total);
+    }
+    plan.estimated_cost = total;
+}
+
+fn annotate_node(node: &mut PlanNode, stats: &StatsCollector, total: &mut Option<f64>) {
+    for child in &mut node.children {
+        annotate_node(child, stats, total);
+    }
+
+    let table = match node.table.as_deref().and_then(|table| stats.table(table)) {
+        Some(table) => table,
+        None => return,
+    };
+    let rows = table.row_count as f64;
+    let (estimated, cost) = match node.operation.as_str() {
+        "SCAN" => (rows, rows),
+        "SEARCH" => {
+            let mut matched = rows;
+            for (column, equality) in search_constraints(&node.detail) {
+                matched *= match (column.as_str(), equality) {
+                    ("rowid", true) => 1.0 / rows.max(1.0),
+                    (column, true) => table.column(column)
+                        .and_then(|c| c.distinct_count)
+                        .filter(|d| *d >= 1)
+                        .map_or(DEFAULT_SELECTIVITY, |d| 1.0 / d as f64),
+                    _ => DEFAULT_SELECTIVITY,
+                };
+            }
+            let matched = if rows >= 1.0 { matched.max(1.0) } else { 0.0 };
+            (matched, (rows + 1.0).log2() + matched)
+        }
+        _ => return,
+    };
+
+    node.estimated_rows = Some(estimated.round() as u64);
+    node.cost = Some(cost);
+    *total = Some(total.unwrap_or(0.0) + cost);
+}
+
+/// Columns constrained by a SEARCH node, e.g. `(name=? AND age>?)`, and whether each is an equality
+fn search_constraints(detail: &str) -> Vec<(String, bool)> {
+    let inner = match (detail.rfind('('), detail.rfind(')')) {
+        (Some(start), Some(end)) if start < end => &detail[start + 1..end],
+        _ => return Vec::new(),
+    };
+    inner.split(" AND ")
+        .filter_map(|term| {
+            let term = term.trim();
+            let end = term.find(|c: char| matches!(c, '=' | '<' | '>'))?;
+            let equality = term[end..].starts_with("=?");
+            Some((term[..end].trim().to_string(), equality))
+        })
+        .collect()
+}
+
+/// Build the children of `parent` from (id, parent, detail) rows
+fn build_tree(entries: &[(i64, i64, String)], parent: i64) -> Vec<PlanNode> {
+    entries.iter()
+        .filter(|(id, p, _)| *p == parent && *id != parent)
+        .map(|(id, _, detail)| {
+            let (operation, table, index) = parse_detail(detail);
+            PlanNode {
+                id: *id,
+                operation,
+                detail: detail.clone(),
+                table,
+                index,
+                estimated_rows: None,
+                cost: None,
+                children: build_tree(entries, *id),
+            }
+        })
+        .collect()
+}
+
+/// Split a detail line into operation, table and index
+fn parse_detail(detail: &str) -> (String, Option<String>, Option<String>) {
+    let tokens: Vec<&str> = detail.split_whitespace().collect();
+
+    let operation = match tokens.first() {
+        Some(&"USE") => tokens.iter().take(3).cloned().collect::<Vec<_>>().join(" "),
+        Some(first) => first.to_string(),
+        None => String::new(),
+    };
+
+    let table = match tokens.first() {
+        Some(&"SCAN") | Some(&"SEARCH") => {
+            // Older SQLite versions print "SCAN TABLE name"
+            let idx = if tokens.get(1) == Some(&"TABLE") { 2 } else { 1 };
+            tokens.get(idx).map(|t| t.to_string())
+        }
+        _ => None,
+    };
+
+    let index = tokens.iter()
+        .position(|t| *t == "INDEX")
+        .and_then(|idx| tokens.get(idx + 1))
+        // Automatic indexes have no name, only the indexed columns
+        .filter(|t| !t.starts_with('('))
+        .map(|t| t.to_string());
+
+    (operation, table, index)
+}
+
+/// Record index usage, full scans and planner optimizations for one detail line
+fn summarize(detail: &str, plan: &mut QueryPlan) {
+    let (operation, table, _) = parse_detail(detail);
+    let table = table.unwrap_or_default();
+
+    if detail.contains(" USING ") && (detail.contains("INDEX") || detail.contains("PRIMARY KEY")) {
+        plan.uses_index = true;
+    }
+
+    if operation == "SCAN" && !detail.contains(" USING ") {
+        plan.full_scans.push(table.clone());
+    }
+
+    if detail.contains("USING AUTOMATIC") {
+        plan.optimizations.push(format!("automatic index on {}", table));
+    } else if detail.contains("USING COVERING INDEX") {
+        plan.optimizations.push(format!("covering index on {}", table));
+    } else if detail.contains("USING INTEGER PRIMARY KEY") {
+        plan.optimizations.push(format!("primary key lookup on {}", table));
+    }
+
+    if operation == "USE TEMP B-TREE" {
+        plan.optimizations.push(detail.to_lowercase());
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_explain_query() {
+        let conn = Connection::open_in_memory().unwrap();
+        conn.execute_batch("
+            CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);
+            CREATE INDEX idx_users_name ON users (name);
+            INSERT INTO users (name, age) VALUES ('alice', 30), ('bob', 25);
+        ").unwrap();
+
+        let plan = explain_query(&conn, "SELECT * FROM users WHERE name = 'alice'", &[], true).unwrap();
+        assert!(plan.uses_index);
+        assert_eq!(plan.nodes[0].table.as_deref(), Some("users"));
+        assert_eq!(plan.nodes[0].index.as_deref(), Some("idx_users_name"));
+        assert_eq!(plan.actual_rows, Some(1));
+
+        // Placeholders are bound to the given parameters
+        let name = "bob".to_string();
+        let plan = explain_query(&conn, "SELECT * FROM users WHERE name = ?1", &[&name], true).unwrap();
+        assert!(plan.uses_index);
+        assert_eq!(plan.actual_rows, Some(1));
+
+        let plan = explain_query(&conn, "SELECT * FROM users ORDER BY age", &[], false).unwrap();
+        assert_eq!(plan.full_scans, vec!["users".to_string()]);
+        assert!(plan.optimizations.iter().any(|o| o.contains("temp b-tree")));
+        assert_eq!(plan.actual_rows, None);
+
+        // Writes are explained but never executed
+        let plan = explain_query(&conn, "DELETE FROM users", &[], true).unwrap();
+        assert_eq!(plan.actual_rows, None);
+        let count: i64 = conn.query_row("SELECT COUNT(*) FROM users", [], |row| row.get(0)).unwrap();
+        assert_eq!(count, 2);
+    }
+
+    #[test]
+    fn test_plan_estimates_from_table_stats() {
+        use crate::query::EngineType;
+        use crate::query::stats::{ColumnStats, TableStats};
+
+        let conn = Connection::open_in_memory().unwrap();
+        conn.execute_batch("
+            CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);
+            CREATE INDEX idx_users_name ON users (name);
+        ").unwrap();
+        let stats = StatsCollector::default();
+        stats.set_table(TableStats {
+            table: "users".to_string(),
+            engine: EngineType::Sqlite,
+            row_count: 1000,
+            columns: vec![ColumnStats { name: "name".to_string(), distinct_count: Some(100), null_count: None }],
+            collected_at: std::time::SystemTime::now(),
+        });
+
+        let mut plan = explain_query(&conn, "SELECT * FROM users WHERE name = 'alice'", &[], false).unwrap();
+        annotate_estimates(&mut plan, &stats);
+        let search = &plan.nodes[0];
+        assert_eq!(search.operation, "SEARCH");
+        assert_eq!(search.estimated_rows, Some(10));
+        assert!((search.cost.unwrap() - (1001f64.log2() + 10.0)).abs() < 1e-9);
+        assert_eq!(plan.estimated_cost, search.cost);
+
+        let mut plan = explain_query(&conn, "SELECT * FROM users WHERE id = 7", &[], false).unwrap();
+        annotate_estimates(&mut plan, &stats);
+        assert_eq!(plan.nodes[0].estimated_rows, Some(1));
+
+        let mut plan = explain_query(&conn, "SELECT * FROM users ORDER BY age", &[], false).unwrap();
+        annotate_estimates(&mut plan, &stats);
+        let scan = plan.nodes.iter().find(|n| n.operation == "SCAN").unwrap();
+        assert_eq!((scan.estimated_rows, scan.cost), (Some(1000), Some(1000.0)));
+        let sort = plan.nodes.iter().find(|n| n.operation == "USE TEMP B-TREE").unwrap();
+        assert_eq!(sort.estimated_rows, None);
+
+        // Tables without statistics get no estimate
+        conn.execute_batch("CREATE TABLE orders (id INTEGER PRIMARY KEY)").unwrap();
+        let mut plan = explain_query(&conn, "SELECT * FROM orders", &[], false).unwrap();
+        annotate_estimates(&mut plan, &stats);
+        assert_eq!((plan.nodes[0].estimated_rows, plan.estimated_cost), (None, None));
+    }
+}
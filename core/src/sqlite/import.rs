@@ -0,0 +1,410 @@
+use rusqlite::Connection;
+use serde::{Serialize, Deserialize};
+use crate::{LumosError, Result};
+
+/// Schema alias used while the source database is attached
+const IMPORT_SCHEMA: &str = "lumos_import";
+
+/// Progress of an import, reported after each table is copied
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ImportProgress {
+    /// Table that was just imported
+    pub table: String,
+    /// Number of tables imported so far
+    pub tables_done: usize,
+    /// Total number of tables to import
+    pub tables_total: usize,
+    /// Rows copied for this table
+    pub rows: usize,
+}
+
+/// Result of importing a single table
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TableImport {
+    /// Table name
+    pub table: String,
+    /// Rows copied
+    pub rows: usize,
+    /// Number of indexes recreated
+    pub indexes: usize,
+}
+
+/// Summary of a completed import
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ImportReport {
+    /// Source database file
+    pub source: String,
+    /// Imported tables
+    pub tables: Vec<TableImport>,
+    /// Total rows copied across all tables
+    pub total_rows: usize,
+    /// Number of views recreated
+    #[serde(default)]
+    pub views: usize,
+    /// Number of triggers recreated
+    #[serde(default)]
+    pub triggers: usize,
+}
+
+/// A schema object read from the source database's `sqlite_master`
+struct SourceObject {
+    /// Object name
+    name: String,
+    /// Table the object belongs to, the object itself for tables and views
+    table: String,
+    /// CREATE statement
+    sql: String,
+}
+
+/// Imports tables from an existing SQLite database file
+pub struct SqliteImporter<'a> {
+    /// Target connection
+    conn: &'a Connection,
+    /// Drop existing tables with the same name instead of failing
+    replace_existing: bool,
+}
+
+impl<'a> SqliteImporter<'a> {
+    /// Create a new importer writing into the given connection
+    pub fn new(conn: &'a Connection) -> Self {
+        Self {
+            conn,
+            replace_existing: false,
+        }
+    }
+
+    /// Replace tables that already exist in the target database
+    pub fn replace_existing(mut self, replace: bool) -> Self {
+        self.replace_existing = replace;
+        self
+    }
+
+    /// Import all user tables with their indexes, views and triggers from the SQLite file at `source_path`
+    ///
+    /// Shadow tables of virtual tables (FTS5, R-tree, ...) are not copied;
+    /// recreating the virtual table creates them and copying its rows fills them.
+    /// Triggers are recreated after all rows are copied, so they do not fire
+    /// during the import.
+    pub fn import_file<F>(&self, source_path: &str, mut progress: F) -> Result<ImportReport>
+    where
+        F: FnMut(&ImportProgress),
+    {
+        if !std::path::Path::new(source_path).exists() {
+            return Err(LumosError::NotFound(format!("SQLite file not found: {}", source_path)));
+        }
+
+        self.conn.execute(&format!("ATTACH DATABASE ?1 AS {}", IMPORT_SCHEMA), [source_path])?;
+
+        let result = self.copy_tables(source_path, &mut progress);
+
+        // Always detach, even if the copy failed
+        if let Err(e) = self.conn.execute(&format!("DETACH DATABASE {}", IMPORT_SCHEMA), []) {
+            log::warn!("Failed to detach import source {}: {}", source_path, e);
+        }
+
+        result
+    }
+
+    /// Copy schema and data of every table inside a single transaction
+    fn copy_tables<F>(&self, source_path: &str, progress: &mut F) -> Result<ImportReport>
+    where
+        F: FnMut(&ImportProgress),
+    {
+        let shadow_tables = self.shadow_tables()?;
+        let tables: Vec<SourceObject> = self.source_objects("table")?
+            .into_iter()
+            .filter(|table| !shadow_tables.contains(&table.name))
+            .collect();
+        let indexes = self.source_objects("index")?;
+        let views = self.source_objects("view")?;
+        let triggers: Vec<SourceObject> = self.source_objects("trigger")?
+            .into_iter()
+            .filter(|trigger| !shadow_tables.contains(&trigger.table))
+            .collect();
+
+        self.conn.execute_batch("BEGIN IMMEDIATE")?;
+
+        let result = self.copy_objects(source_path, &tables, &indexes, &views, &triggers, progress);
+        let report = match result {
+            Ok(report) => report,
+            Err(e) => {
+                let _ = self.conn.execute_batch("ROLLBACK");
+                return Err(e);
+            }
+        };
+
+        self.conn.execute_batch("COMMIT")?;
+
+        log::info!(
+            "Imported {} tables ({} rows), {} views and {} triggers from {}",
+            report.tables.len(), report.total_rows, report.views, report.triggers, source_path
+        );
+        Ok(report)
+    }
+
+    /// Copy tables, then recreate views and triggers
+    fn copy_objects<F>(
+        &self,
+        source_path: &str,
+        tables: &[SourceObject],
+        indexes: &[SourceObject],
+        views: &[SourceObject],
+        triggers: &[SourceObject],
+        progress: &mut F,
+    ) -> Result<ImportReport>
+    where
+        F: FnMut(&ImportProgress),
+    {
+        let mut report = ImportReport {
+            source: source_path.to_string(),
+            tables: Vec::with_capacity(tables.len()),
+            total_rows: 0,
+            views: 0,
+            triggers: 0,
+        };
+
+        for (i, table) in tables.iter().enumerate() {
+            let table_import = self.copy_table(table, indexes)?;
+            report.total_rows += table_import.rows;
+            progress(&ImportProgress {
+                table: table.name.clone(),
+                tables_done: i + 1,
+                tables_total: tables.len(),
+                rows: table_import.rows,
+            });
+            report.tables.push(table_import);
+        }
+
+        for view in views {
+            self.create_object("view", view)?;
+            report.views += 1;
+        }
+        for trigger in triggers {
+            self.create_object("trigger", trigger)?;
+            report.triggers += 1;
+        }
+
+        Ok(report)
+    }
+
+    /// Create one table in the target database, copy its rows and recreate its indexes
+    ///
+    /// Only stored columns are copied: generated columns are computed by the
+    /// target table and the hidden columns of virtual tables cannot be inserted.
+    fn copy_table(&self, table: &SourceObject, indexes: &[SourceObject]) -> Result<TableImport> {
+        let quoted = quote_identifier(&table.name);
+
+        self.create_object("table", table)?;
+
+        let columns = self.source_columns(&table.name)?
+            .iter()
+            .map(|column| quote_identifier(column))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let rows = self.conn.execute(
+            &format!(
+                "INSERT INTO main.{} ({}) SELECT {} FROM {}.{}",
+                quoted, columns, columns, IMPORT_SCHEMA, quoted
+            ),
+            [],
+        )?;
+
+        let mut index_count = 0;
+        for index in indexes.iter().filter(|index| index.table == table.name) {
+            self.conn.execute(&index.sql, [])?;
+            index_count += 1;
+        }
+
+        log::debug!("Imported table {} ({} rows, {} indexes)", table.name, rows, index_count);
+
+        Ok(TableImport {
+            table: table.name.clone(),
+            rows,
+            indexes: index_count,
+        })
+    }
+
+    /// Run an object's CREATE statement in the target, replacing an existing object of the same name if allowed
+    fn create_object(&self, object_type: &str, object: &SourceObject) -> Result<()> {
+        let exists: bool = self.conn.query_row(
+            "SELECT COUNT(*) > 0 FROM main.sqlite_master WHERE type = ?1 AND name = ?2",
+            [object_type, object.name.as_str()],
+            |row| row.get(0),
+        )?;
+
+        if exists {
+            if !self.replace_existing {
+                let label = match object_type {
+                    "view" => "View",
+                    "trigger" => "Trigger",
+                    _ => "Table",
+                };
+                return Err(LumosError::InvalidArgument(format!("{} already exists: {}", label, object.name)));
+            }
+            self.conn.execute(
+                &format!("DROP {} main.{}", object_type.to_uppercase(), quote_identifier(&object.name)),
+                [],
+            )?;
+        }
+
+        // Unqualified CREATE statements from sqlite_master are created in main
+        self.conn.execute(&object.sql, [])?;
+        Ok(())
+    }
+
+    /// Columns of a source table that hold stored values, in declaration order
+    fn source_columns(&self, table: &str) -> Result<Vec<String>> {
+        let mut stmt = self.conn.prepare(&format!(
+            "PRAGMA {}.table_xinfo({})",
+            IMPORT_SCHEMA, quote_identifier(table)
+        ))?;
+        let rows = stmt.query_map([], |row| {
+            Ok((row.get::<_, String>("name")?, row.get::<_, i64>("hidden")?))
+        })?;
+
+        let mut columns = Vec::new();
+        for row in rows {
+            let (name, hidden) = row?;
+            if hidden == 0 {
+                columns.push(name);
+            }
+        }
+        Ok(columns)
+    }
+
+    /// Names of the shadow tables that back virtual tables in the source database
+    fn shadow_tables(&self) -> Result<Vec<String>> {
+        let mut stmt = self.conn.prepare(&format!("PRAGMA {}.table_list", IMPORT_SCHEMA))?;
+        let rows = stmt.query_map([], |row| {
+            Ok((row.get::<_, String>("name")?, row.get::<_, String>("type")?))
+        })?;
+
+        let mut tables = Vec::new();
+        for row in rows {
+            let (name, table_type) = row?;
+            if table_type == "shadow" {
+                tables.push(name);
+            }
+        }
+        Ok(tables)
+    }
+
+    /// List user objects of the given type in creation order
+    fn source_objects(&self, object_type: &str) -> Result<Vec<SourceObject>> {
+        let sql = format!(
+            "SELECT name, tbl_name, sql FROM {}.sqlite_master \
+             WHERE type = ?1 AND sql IS NOT NULL AND name NOT LIKE 'sqlite_%' \
+             ORDER BY rowid",
+            IMPORT_SCHEMA
+        );
+
+        let mut stmt = self.conn.prepare(&sql)?;
+        let rows = stmt.query_map([object_type], |row| {
+            Ok(SourceObject {
+                name: row.get(0)?,
+                table: row.get(1)?,
+                sql: row.get(2)?,
+            })
+        })?;
+
+        let mut objects = Vec::new();
+        for row in rows {
+            objects.push(row?);
+        }
+        Ok(objects)
+    }
+}
+
+/// Quote an identifier for use in generated SQL
+pub(crate) fn quote_identifier(name: &str) -> String {
+    format!("\"{}\"", name.replace('"', "\"\""))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_import_sqlite_file() {
+        let dir = tempdir().unwrap();
+        let source_path = dir.path().join("source.db");
+        let source_path = source_path.to_str().unwrap();
+
+        {
+            let source = Connection::open(source_path).unwrap();
+            source.execute_batch("
+                CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+                CREATE INDEX idx_users_name ON users (name);
+                INSERT INTO users (name) VALUES ('alice'), ('bob');
+            ").unwrap();
+        }
+
+        let target = Connection::open_in_memory().unwrap();
+        let mut updates = Vec::new();
+        let report = SqliteImporter::new(&target)
+            .import_file(source_path, |p| updates.push(p.clone()))
+            .unwrap();
+
+        assert_eq!(report.total_rows, 2);
+        assert_eq!(report.tables[0].indexes, 1);
+        assert_eq!(updates.len(), 1);
+
+        let count: i64 = target.query_row("SELECT COUNT(*) FROM users", [], |row| row.get(0)).unwrap();
+        assert_eq!(count, 2);
+
+        // A second import fails unless existing tables may be replaced
+        assert!(SqliteImporter::new(&target).import_file(source_path, |_| {}).is_err());
+        assert!(SqliteImporter::new(&target)
+            .replace_existing(true)
+            .import_file(source_path, |_| {})
+            .is_ok());
+    }
+
+    #[test]
+    fn test_import_generated_columns_virtual_tables_views_and_triggers() {
+        let dir = tempdir().unwrap();
+        let source_path = dir.path().join("source.db");
+        let source_path = source_path.to_str().unwrap();
+
+        {
+            let source = Connection::open(source_path).unwrap();
+            source.execute_batch("
+                CREATE TABLE items (id INTEGER PRIMARY KEY, price REAL, total REAL GENERATED ALWAYS AS (price * 2));
+                CREATE TABLE audit (item_id INTEGER);
+                CREATE VIRTUAL TABLE docs USING fts5(body);
+                CREATE VIEW cheap_items AS SELECT id FROM items WHERE price < 10;
+                CREATE TRIGGER items_audit AFTER INSERT ON items BEGIN
+                    INSERT INTO audit (item_id) VALUES (NEW.id);
+                END;
+                INSERT INTO items (price) VALUES (5), (20);
+                INSERT INTO docs (body) VALUES ('hello world');
+                DELETE FROM audit;
+            ").unwrap();
+        }
+
+        let target = Connection::open_in_memory().unwrap();
+        let report = SqliteImporter::new(&target).import_file(source_path, |_| {}).unwrap();
+
+        // Shadow tables of the FTS5 table are recreated with it rather than copied
+        let mut tables: Vec<&str> = report.tables.iter().map(|t| t.table.as_str()).collect();
+        tables.sort();
+        assert_eq!(tables, vec!["audit", "docs", "items"]);
+        assert_eq!((report.views, report.triggers), (1, 1));
+
+        // Generated columns are computed by the target table
+        let total: f64 = target.query_row("SELECT total FROM items WHERE price = 20", [], |row| row.get(0)).unwrap();
+        assert_eq!(total, 40.0);
+        let matches: i64 = target.query_row("SELECT COUNT(*) FROM docs WHERE docs MATCH 'hello'", [], |row| row.get(0)).unwrap();
+        assert_eq!(matches, 1);
+        let cheap: i64 = target.query_row("SELECT COUNT(*) FROM cheap_items", [], |row| row.get(0)).unwrap();
+        assert_eq!(cheap, 1);
+
+        // Triggers did not fire while rows were copied, but fire afterwards
+        let audited: i64 = target.query_row("SELECT COUNT(*) FROM audit", [], |row| row.get(0)).unwrap();
+        assert_eq!(audited, 0);
+        target.execute("INSERT INTO items (price) VALUES (1)", []).unwrap();
+        let audited: i64 = target.query_row("SELECT COUNT(*) FROM audit", [], |row| row.get(0)).unwrap();
+        assert_eq!(audited, 1);
+    }
+}
@@ -0,0 +1,193 @@
+use rusqlite::Connection;
+use rusqlite::types::ValueRef;
+use serde::{Serialize, Deserialize};
+use crate::Result;
+use super::import::{ImportReport, SqliteImporter};
+
+/// FNV-1a offset basis
+const FNV_OFFSET: u64 = 0xcbf2_9ce4_8422_2325;
+/// FNV-1a prime
+const FNV_PRIME: u64 = 0x0000_0100_0000_01b3;
+
+/// Row count and content checksum of a table
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TableChecksum {
+    /// Table name
+    pub table: String,
+    /// Number of rows
+    pub rows: u64,
+    /// Checksum of all rows, as 16 hex digits
+    pub checksum: String,
+}
+
+/// Comparison of one table between a source and a target database
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TableVerification {
+    /// Table name
+    pub table: String,
+    /// Source table, `None` if it only exists in the target
+    pub source: Option<TableChecksum>,
+    /// Target table, `None` if it is missing from the target
+    pub target: Option<TableChecksum>,
+    /// Whether row counts and checksums match
+    pub matches: bool,
+}
+
+/// Result of copying a database and verifying the copy
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct MigrationReport {
+    /// What was copied
+    pub import: ImportReport,
+    /// Per-table verification
+    pub tables: Vec<TableVerification>,
+    /// Whether every table matches
+    pub verified: bool,
+}
+
+/// Compute row counts and checksums of all user tables
+///
+/// Rows are hashed in the order of all their columns rather than by rowid, so
+/// the checksum only depends on table contents and is the same for a copy
+/// whose rows were inserted in a different order.
+pub fn table_checksums(conn: &Connection) -> Result<Vec<TableChecksum>> {
+    let mut stmt = conn.prepare(
+        "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name",
+    )?;
+    let tables = stmt
+        .query_map([], |row| row.get::<_, String>(0))?
+        .collect::<std::result::Result<Vec<_>, _>>()?;
+
+    tables.iter().map(|table| table_checksum(conn, table)).collect()
+}
+
+/// Compute the row count and checksum of one table
+pub fn table_checksum(conn: &Connection, table: &str) -> Result<TableChecksum> {
+    let quoted = format!("\"{}\"", table.replace('"', "\"\""));
+    let column_count = conn.prepare(&format!("SELECT * FROM {} LIMIT 0", quoted))?.column_count();
+    let order: Vec<String> = (1..=column_count).map(|i| i.to_string()).collect();
+
+    let mut stmt = conn.prepare(&format!("SELECT * FROM {} ORDER BY {}", quoted, order.join(", ")))?;
+    let mut rows = stmt.query([])?;
+
+    let mut hash = FNV_OFFSET;
+    let mut count = 0u64;
+    while let Some(row) = rows.next()? {
+        for i in 0..column_count {
+            hash = hash_value(hash, row.get_ref(i)?);
+        }
+        count += 1;
+    }
+
+    Ok(TableChecksum {
+        table: table.to_string(),
+        rows: count,
+        checksum: format!("{:016x}", hash),
+    })
+}
+
+/// Compare the tables of two databases
+pub fn verify_tables(source: &Connection, target: &Connection) -> Result<Vec<TableVerification>> {
+    let source_tables = table_checksums(source)?;
+    let mut target_tables = table_checksums(target)?;
+
+    let mut verifications = Vec::with_capacity(source_tables.len());
+    for source_table in source_tables {
+        let target_table = target_tables
+            .iter()
+            .position(|t| t.table == source_table.table)
+            .map(|i| target_tables.remove(i));
+        verifications.push(TableVerification {
+            table: source_table.table.clone(),
+            matches: target_table.as_ref() == Some(&source_table),
+            source: Some(source_table),
+            target: target_table,
+        });
+    }
+
+    // Tables only in the target are reported but do not fail verification
+    for target_table in target_tables {
+        verifications.push(TableVerification {
+            table: target_table.table.clone(),
+            source: None,
+            target: Some(target_table),
+            matches: true,
+        });
+    }
+
+    Ok(verifications)
+}
+
+/// Copy every table of the database at `source_path` into `target` and verify the copy
+pub fn migrate_database(source_path: &str, target: &Connection, replace_existing: bool) -> Result<MigrationReport> {
+    let import = SqliteImporter::new(target)
+        .replace_existing(replace_existing)
+        .import_file(source_path, |p| {
+            log::info!("Migrated table {} ({}/{}, {} rows)", p.table, p.tables_done, p.tables_total, p.rows);
+        })?;
+
+    let source = Connection::open(source_path)?;
+    let tables = verify_tables(&source, target)?;
+    let verified = tables.iter().all(|t| t.matches);
+
+    Ok(MigrationReport {
+        import,
+        tables,
+        verified,
+    })
+}
+
+/// Feed one value into an FNV-1a hash, prefixed with a type tag
+fn hash_value(hash: u64, value: ValueRef<'_>) -> u64 {
+    match value {
+        ValueRef::Null => fnv(hash, &[0]),
+        ValueRef::Integer(i) => fnv(fnv(hash, &[1]), &i.to_le_bytes()),
+        ValueRef::Real(f) => fnv(fnv(hash, &[2]), &f.to_bits().to_le_bytes()),
+        ValueRef::Text(bytes) => fnv(fnv(fnv(hash, &[3]), &(bytes.len() as u64).to_le_bytes()), bytes),
+        ValueRef::Blob(bytes) => fnv(fnv(fnv(hash, &[4]), &(bytes.len() as u64).to_le_bytes()), bytes),
+    }
+}
+
+fn fnv(mut hash: u64, bytes: &[u8]) -> u64 {
+    for byte in bytes {
+        hash ^= *byte as u64;
+        hash = hash.wrapping_mul(FNV_PRIME);
+    }
+    hash
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_migrate_and_verify() {
+        let dir = tempdir().unwrap();
+        let source_path = dir.path().join("old.db");
+        let source_path = source_path.to_str().unwrap();
+
+        {
+            let source = Connection::open(source_path).unwrap();
+            source.execute_batch("
+                CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL);
+                INSERT INTO users (name, score) VALUES ('alice', 1.5), ('bob', NULL);
+                CREATE TABLE tags (tag TEXT PRIMARY KEY) WITHOUT ROWID;
+                INSERT INTO tags VALUES ('b'), ('a');
+            ").unwrap();
+        }
+
+        let target = Connection::open_in_memory().unwrap();
+        let report = migrate_database(source_path, &target, false).unwrap();
+        assert!(report.verified);
+        assert_eq!(report.tables.len(), 2);
+        assert_eq!(report.tables[1].source.as_ref().unwrap().rows, 2);
+
+        // A diverging row is detected
+        target.execute("UPDATE users SET name = 'carol' WHERE name = 'bob'", []).unwrap();
+        let source = Connection::open(source_path).unwrap();
+        let tables = verify_tables(&source, &target).unwrap();
+        let users = tables.iter().find(|t| t.table == "users").unwrap();
+        assert!(!users.matches);
+        assert_eq!(users.source.as_ref().unwrap().rows, users.target.as_ref().unwrap().rows);
+    }
+}
@@ -1,12 +1,21 @@
 pub mod connection;
 pub mod transaction;
 pub mod schema;
+pub mod import;
+pub mod explain;
+pub mod advisor;
+pub mod migrate;
+pub mod snapshot;
+pub mod temporal;
 
 use std::sync::{Arc, Mutex};
 use rusqlite::{Connection, params};
 use crate::{LumosError, Result};
 use connection::RowData;
 
+/// Number of compiled statements each pooled connection keeps for `prepare` and `exec`
+pub const STATEMENT_CACHE_CAPACITY: usize = crate::query::prepared::DEFAULT_PREPARED_CACHE_SIZE;
+
 /// SQLite engine for Lumos-DB
 pub struct SqliteEngine {
     /// Path to the SQLite database file
@@ -84,6 +93,44 @@ impl SqliteEngine {
         Ok(rows_data)
     }
     
+    /// Execute a query and pass each row to `f` as it is read, returning the row count
+    ///
+    /// Unlike `query_all` the result is never held in memory, so large results can be
+    /// written out as they are produced. An error from `f` stops the query.
+    pub fn query_each<F>(&self, sql: &str, params: &[&dyn rusqlite::ToSql], mut f: F) -> Result<u64>
+    where
+        F: FnMut(RowData) -> Result<()>,
+    {
+        let conn = self.connection()?;
+        let mut stmt = conn.conn.prepare(sql)?;
+        let mut query_result = stmt.query(params)?;
+        let mut count = 0;
+        while let Some(row) = query_result.next()? {
+            f(RowData::from_row(row)?)?;
+            count += 1;
+        }
+        Ok(count)
+    }
+    
+    /// Compile a statement and keep it in the connection's statement cache, returning its parameter count
+    ///
+    /// Every pooled connection keeps up to `STATEMENT_CACHE_CAPACITY` compiled
+    /// statements keyed by SQL text, so `exec` with the same SQL skips parsing
+    /// and planning. A connection that has not run the statement yet compiles
+    /// it on first use.
+    pub fn prepare(&self, sql: &str) -> Result<usize> {
+        let conn = self.connection()?;
+        let stmt = conn.conn.prepare_cached(sql)?;
+        Ok(stmt.parameter_count())
+    }
+    
+    /// Execute a statement compiled by `prepare`, returning the number of rows affected
+    pub fn exec(&self, sql: &str, params: &[&dyn rusqlite::ToSql]) -> Result<usize> {
+        let conn = self.connection()?;
+        let mut stmt = conn.conn.prepare_cached(sql)?;
+        Ok(stmt.execute(params)?)
+    }
+    
     /// Create a table if it doesn't exist using a connection from the pool
     pub fn create_table(&self, table_name: &str, columns: &[(&str, &str)]) -> Result<()> {
         let columns_sql = columns
@@ -141,6 +188,59 @@ impl SqliteEngine {
         Ok(())
     }
     
+    /// Import all tables from another SQLite database file, reporting progress per table
+    pub fn import_sqlite_file<F>(&self, source_path: &str, replace_existing: bool, progress: F) -> Result<import::ImportReport>
+    where
+        F: FnMut(&import::ImportProgress),
+    {
+        let conn = self.connection()?;
+        import::SqliteImporter::new(&conn.conn)
+            .replace_existing(replace_existing)
+            .import_file(source_path, progress)
+    }
+    
+    /// Explain a query as a structured plan tree, optionally executing read-only queries
+    pub fn explain(&self, sql: &str, params: &[&dyn rusqlite::ToSql], analyze: bool) -> Result<explain::QueryPlan> {
+        let conn = self.connection()?;
+        explain::explain_query(&conn.conn, sql, params, analyze)
+    }
+    
+    /// Take a snapshot of the database into `store`
+    pub fn take_snapshot(&self, store: &snapshot::SnapshotStore) -> Result<snapshot::SnapshotInfo> {
+        let conn = self.connection()?;
+        store.take(&conn.conn)
+    }
+    
+    /// Enable row history for `table`
+    pub fn enable_history(&self, table: &str) -> Result<temporal::TemporalTable> {
+        let conn = self.connection()?;
+        temporal::enable_history(&conn.conn, table)
+    }
+    
+    /// Disable row history for `table`, optionally dropping the recorded history
+    pub fn disable_history(&self, table: &str, drop_history: bool) -> Result<()> {
+        let conn = self.connection()?;
+        temporal::disable_history(&conn.conn, table, drop_history)
+    }
+    
+    /// Rows of a versioned table as they were at `at`
+    pub fn table_as_of(&self, table: &str, at: &chrono::DateTime<chrono::Utc>) -> Result<Vec<RowData>> {
+        let conn = self.connection()?;
+        temporal::table_as_of(&conn.conn, table, at)
+    }
+    
+    /// Every version of one row of a versioned table, oldest first
+    pub fn row_history(&self, table: &str, row_id: i64) -> Result<Vec<RowData>> {
+        let conn = self.connection()?;
+        temporal::row_history(&conn.conn, table, row_id)
+    }
+    
+    /// Suggest indexes for the slow query shapes in `stats`
+    pub fn advise_indexes(&self, stats: &[crate::query::fingerprint::QueryShapeStats], slow_ms: f64) -> Result<Vec<advisor::IndexSuggestion>> {
+        let conn = self.connection()?;
+        advisor::advise_indexes(&conn.conn, stats, slow_ms)
+    }
+    
     /// Get the connection pool
     pub fn pool(&self) -> Arc<connection::ConnectionPool> {
         self.pool.clone()
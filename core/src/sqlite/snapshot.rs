@@ -0,0 +1,215 @@
+use std::fs;
+use std::path::{Path, PathBuf};
+use chrono::Utc;
+use rusqlite::{Connection, OpenFlags};
+use serde::{Serialize, Deserialize};
+use crate::{LumosError, Result};
+use super::connection::RowData;
+
+/// File name prefix of retained snapshots
+const SNAPSHOT_PREFIX: &str = "snapshot-";
+/// File name extension of retained snapshots
+const SNAPSHOT_EXTENSION: &str = "db";
+
+/// A retained point-in-time copy of a database
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SnapshotInfo {
+    /// Snapshot identifier, the file name without extension
+    pub id: String,
+    /// When the snapshot was taken, in milliseconds since the Unix epoch
+    pub taken_at_ms: i64,
+    /// Size of the snapshot file in bytes
+    pub size_bytes: u64,
+    /// Path of the snapshot file
+    pub path: PathBuf,
+}
+
+/// Directory of retained database snapshots
+///
+/// Snapshots are consistent copies written with `VACUUM INTO`, named after
+/// the time they were taken. Only the newest `retain` snapshots are kept.
+/// Queries "as of" a point in time run against the newest snapshot taken at
+/// or before it, opened read-only on a separate connection, so the live
+/// database is never touched and no restore is needed.
+pub struct SnapshotStore {
+    /// Directory holding the snapshot files
+    dir: PathBuf,
+    /// Number of snapshots to keep
+    retain: usize,
+}
+
+impl SnapshotStore {
+    /// Create a snapshot store in `dir` keeping the newest `retain` snapshots
+    pub fn new<P: AsRef<Path>>(dir: P, retain: usize) -> Self {
+        Self {
+            dir: dir.as_ref().to_path_buf(),
+            retain: retain.max(1),
+        }
+    }
+
+    /// Directory holding the snapshot files
+    pub fn dir(&self) -> &Path {
+        &self.dir
+    }
+
+    /// Take a snapshot of the database behind `conn` and prune old snapshots
+    pub fn take(&self, conn: &Connection) -> Result<SnapshotInfo> {
+        fs::create_dir_all(&self.dir)?;
+
+        // Two snapshots in the same millisecond get distinct names
+        let mut taken_at_ms = Utc::now().timestamp_millis();
+        while self.snapshot_path(taken_at_ms).exists() {
+            taken_at_ms += 1;
+        }
+        let path = self.snapshot_path(taken_at_ms);
+        let target = path.to_str()
+            .ok_or_else(|| LumosError::InvalidArgument(format!("Invalid snapshot path: {}", path.display())))?;
+        conn.execute("VACUUM INTO ?1", [target])?;
+
+        let info = SnapshotInfo {
+            id: snapshot_id(taken_at_ms),
+            taken_at_ms,
+            size_bytes: fs::metadata(&path)?.len(),
+            path,
+        };
+        log::info!("Took snapshot '{}' ({} bytes)", info.id, info.size_bytes);
+
+        self.prune()?;
+        Ok(info)
+    }
+
+    /// List retained snapshots, oldest first
+    pub fn list(&self) -> Result<Vec<SnapshotInfo>> {
+        if !self.dir.exists() {
+            return Ok(Vec::new());
+        }
+
+        let mut snapshots = Vec::new();
+        for entry in fs::read_dir(&self.dir)? {
+            let entry = entry?;
+            let path = entry.path();
+            if path.extension().and_then(|e| e.to_str()) != Some(SNAPSHOT_EXTENSION) {
+                continue;
+            }
+            let taken_at_ms = match path.file_stem()
+                .and_then(|s| s.to_str())
+                .and_then(|s| s.strip_prefix(SNAPSHOT_PREFIX))
+                .and_then(|s| s.parse::<i64>().ok())
+            {
+                Some(ms) => ms,
+                None => continue,
+            };
+            snapshots.push(SnapshotInfo {
+                id: snapshot_id(taken_at_ms),
+                taken_at_ms,
+                size_bytes: entry.metadata()?.len(),
+                path,
+            });
+        }
+
+        snapshots.sort_by_key(|s| s.taken_at_ms);
+        Ok(snapshots)
+    }
+
+    /// Find the newest snapshot taken at or before `as_of_ms`
+    pub fn resolve(&self, as_of_ms: i64) -> Result<SnapshotInfo> {
+        self.list()?
+            .into_iter()
+            .rev()
+            .find(|s| s.taken_at_ms <= as_of_ms)
+            .ok_or_else(|| LumosError::NotFound(format!("No snapshot retained at or before {}", as_of_ms)))
+    }
+
+    /// Open the snapshot for `as_of_ms` read-only
+    pub fn open_as_of(&self, as_of_ms: i64) -> Result<(SnapshotInfo, Connection)> {
+        let snapshot = self.resolve(as_of_ms)?;
+        let conn = Connection::open_with_flags(
+            &snapshot.path,
+            OpenFlags::SQLITE_OPEN_READ_ONLY | OpenFlags::SQLITE_OPEN_NO_MUTEX,
+        )?;
+        Ok((snapshot, conn))
+    }
+
+    /// Run a query against the database as it was at `as_of_ms`
+    ///
+    /// Returns the snapshot the query ran against together with the rows.
+    pub fn query_as_of(
+        &self,
+        as_of_ms: i64,
+        sql: &str,
+        params: &[&dyn rusqlite::ToSql],
+    ) -> Result<(SnapshotInfo, Vec<RowData>)> {
+        let (snapshot, conn) = self.open_as_of(as_of_ms)?;
+        log::debug!("Querying snapshot '{}' as of {}", snapshot.id, as_of_ms);
+
+        let mut stmt = conn.prepare(sql)?;
+        let mut rows = stmt.query(params)?;
+        let mut result = Vec::new();
+        while let Some(row) = rows.next()? {
+            result.push(RowData::from_row(row)?);
+        }
+        drop(rows);
+        drop(stmt);
+
+        Ok((snapshot, result))
+    }
+
+    /// Remove the oldest snapshots beyond the retention count
+    fn prune(&self) -> Result<()> {
+        let snapshots = self.list()?;
+        let excess = snapshots.len().saturating_sub(self.retain);
+        for snapshot in snapshots.into_iter().take(excess) {
+            fs::remove_file(&snapshot.path)?;
+            log::debug!("Removed expired snapshot '{}'", snapshot.id);
+        }
+        Ok(())
+    }
+
+    fn snapshot_path(&self, taken_at_ms: i64) -> PathBuf {
+        self.dir.join(format!("{}.{}", snapshot_id(taken_at_ms), SNAPSHOT_EXTENSION))
+    }
+}
+
+fn snapshot_id(taken_at_ms: i64) -> String {
+    format!("{}{}", SNAPSHOT_PREFIX, taken_at_ms)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_snapshot_query_as_of() {
+        let dir = tempdir().unwrap();
+        let conn = Connection::open(dir.path().join("live.db")).unwrap();
+        conn.execute_batch("
+            CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+            INSERT INTO items (name) VALUES ('a');
+        ").unwrap();
+
+        let store = SnapshotStore::new(dir.path().join("snapshots"), 2);
+        let first = store.take(&conn).unwrap();
+        conn.execute("INSERT INTO items (name) VALUES ('b')", []).unwrap();
+        let second = store.take(&conn).unwrap();
+        conn.execute("INSERT INTO items (name) VALUES ('c')", []).unwrap();
+        assert!(second.taken_at_ms > first.taken_at_ms);
+
+        // Each point in time sees the rows of the snapshot taken before it
+        let (snapshot, rows) = store.query_as_of(first.taken_at_ms, "SELECT COUNT(*) AS n FROM items", &[]).unwrap();
+        assert_eq!(snapshot.id, first.id);
+        assert_eq!(rows[0].values["n"], "1");
+        let (_, rows) = store.query_as_of(i64::MAX, "SELECT COUNT(*) AS n FROM items", &[]).unwrap();
+        assert_eq!(rows[0].values["n"], "2");
+        assert!(matches!(store.resolve(first.taken_at_ms - 1), Err(LumosError::NotFound(_))));
+
+        // Snapshots are read-only
+        assert!(store.query_as_of(i64::MAX, "DELETE FROM items", &[]).is_err());
+
+        // Only the newest two snapshots are retained
+        store.take(&conn).unwrap();
+        let ids: Vec<String> = store.list().unwrap().into_iter().map(|s| s.id).collect();
+        assert_eq!(ids.len(), 2);
+        assert!(!ids.contains(&first.id));
+    }
+}
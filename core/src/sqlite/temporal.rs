@@ -0,0 +1,283 @@
+use rusqlite::{Connection, params};
+use chrono::{DateTime, Utc};
+use serde::{Serialize, Deserialize};
+use crate::{LumosError, Result};
+use super::connection::RowData;
+
+/// Registry of tables with row history enabled
+const REGISTRY_TABLE: &str = "lumos_temporal_tables";
+/// Suffix of history table names
+const HISTORY_SUFFIX: &str = "_history";
+/// Current time as stored in validity columns, with millisecond precision
+const NOW_EXPR: &str = "strftime('%Y-%m-%dT%H:%M:%fZ', 'now')";
+
+/// A table with row history enabled
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TemporalTable {
+    /// Versioned table
+    pub table: String,
+    /// Table holding every version of its rows
+    pub history_table: String,
+    /// Columns recorded in the history
+    pub columns: Vec<String>,
+    /// When history was enabled
+    pub enabled_at: String,
+}
+
+/// Format a point in time the way validity columns store it
+pub fn format_timestamp(at: &DateTime<Utc>) -> String {
+    at.format("%Y-%m-%dT%H:%M:%S%.3fZ").to_string()
+}
+
+/// Name of the table holding the row history of `table`
+pub fn history_table_name(table: &str) -> String {
+    format!("{}{}", table, HISTORY_SUFFIX)
+}
+
+/// Enable row history for `table`
+///
+/// Creates `<table>_history` holding every version of every row with
+/// `_valid_from`/`_valid_to` timestamps, and triggers that close the current
+/// version on update or delete and record the new one. Existing rows are
+/// recorded as valid from now. Columns added to the table afterwards are not
+/// recorded until history is disabled and enabled again.
+pub fn enable_history(conn: &Connection, table: &str) -> Result<TemporalTable> {
+    if let Some(existing) = temporal_table(conn, table)? {
+        return Ok(existing);
+    }
+
+    let columns = table_columns(conn, table)?;
+    if columns.is_empty() {
+        return Err(LumosError::NotFound(format!("Table '{}' does not exist", table)));
+    }
+    // Versions are keyed by rowid
+    if conn.prepare(&format!("SELECT rowid FROM {} LIMIT 0", quote(table))).is_err() {
+        return Err(LumosError::InvalidArgument(format!("Table '{}' has no rowid and cannot be versioned", table)));
+    }
+
+    let history = history_table_name(table);
+    let column_list = columns.iter().map(|c| quote(c)).collect::<Vec<_>>().join(", ");
+    let new_values = columns.iter().map(|c| format!("NEW.{}", quote(c))).collect::<Vec<_>>().join(", ");
+
+    let tx = conn.unchecked_transaction()?;
+    tx.execute_batch(&format!(
+        "CREATE TABLE IF NOT EXISTS {registry} (
+            table_name TEXT PRIMARY KEY,
+            history_table TEXT NOT NULL,
+            columns TEXT NOT NULL,
+            enabled_at TEXT NOT NULL
+        );
+        CREATE TABLE IF NOT EXISTS {h} (
+            _row_id INTEGER NOT NULL,
+            {column_list},
+            _valid_from TEXT NOT NULL,
+            _valid_to TEXT
+        );
+        CREATE INDEX IF NOT EXISTS {h_idx} ON {h} (_row_id, _valid_to);
+        INSERT INTO {h} (_row_id, {column_list}, _valid_from)
+            SELECT rowid, {column_list}, {now} FROM {t};
+        CREATE TRIGGER {ins} AFTER INSERT ON {t} BEGIN
+            INSERT INTO {h} (_row_id, {column_list}, _valid_from) VALUES (NEW.rowid, {new_values}, {now});
+        END;
+        CREATE TRIGGER {upd} AFTER UPDATE ON {t} BEGIN
+            UPDATE {h} SET _valid_to = {now} WHERE _row_id = OLD.rowid AND _valid_to IS NULL;
+            INSERT INTO {h} (_row_id, {column_list}, _valid_from) VALUES (NEW.rowid, {new_values}, {now});
+        END;
+        CREATE TRIGGER {del} AFTER DELETE ON {t} BEGIN
+            UPDATE {h} SET _valid_to = {now} WHERE _row_id = OLD.rowid AND _valid_to IS NULL;
+        END;",
+        registry = REGISTRY_TABLE,
+        t = quote(table),
+        h = quote(&history),
+        h_idx = quote(&format!("{}_row_idx", history)),
+        ins = quote(&trigger_name(table, "insert")),
+        upd = quote(&trigger_name(table, "update")),
+        del = quote(&trigger_name(table, "delete")),
+        now = NOW_EXPR,
+    ))?;
+    let enabled_at: String = tx.query_row(&format!("SELECT {}", NOW_EXPR), [], |row| row.get(0))?;
+    tx.execute(
+        &format!("INSERT INTO {} (table_name, history_table, columns, enabled_at) VALUES (?1, ?2, ?3, ?4)", REGISTRY_TABLE),
+        params![table, history, serde_json::to_string(&columns).unwrap_or_default(), enabled_at],
+    )?;
+    tx.commit()?;
+
+    log::info!("Enabled row history for table '{}' in '{}'", table, history);
+    Ok(TemporalTable { table: table.to_string(), history_table: history, columns, enabled_at })
+}
+
+/// Disable row history for `table`, optionally dropping the recorded history
+pub fn disable_history(conn: &Connection, table: &str, drop_history: bool) -> Result<()> {
+    let temporal = temporal_table(conn, table)?
+        .ok_or_else(|| LumosError::NotFound(format!("Row history is not enabled for table '{}'", table)))?;
+
+    let tx = conn.unchecked_transaction()?;
+    for operation in ["insert", "update", "delete"] {
+        tx.execute_batch(&format!("DROP TRIGGER IF EXISTS {}", quote(&trigger_name(table, operation))))?;
+    }
+    if drop_history {
+        tx.execute_batch(&format!("DROP TABLE IF EXISTS {}", quote(&temporal.history_table)))?;
+    } else {
+        // Rows still open stop being tracked now
+        tx.execute_batch(&format!(
+            "UPDATE {} SET _valid_to = {} WHERE _valid_to IS NULL",
+            quote(&temporal.history_table), NOW_EXPR
+        ))?;
+    }
+    tx.execute(&format!("DELETE FROM {} WHERE table_name = ?1", REGISTRY_TABLE), [table])?;
+    tx.commit()?;
+
+    log::info!("Disabled row history for table '{}'", table);
+    Ok(())
+}
+
+/// List tables with row history enabled
+pub fn temporal_tables(conn: &Connection) -> Result<Vec<TemporalTable>> {
+    if !registry_exists(conn)? {
+        return Ok(Vec::new());
+    }
+    let mut stmt = conn.prepare(&format!(
+        "SELECT table_name, history_table, columns, enabled_at FROM {} ORDER BY table_name", REGISTRY_TABLE
+    ))?;
+    let tables = stmt
+        .query_map([], read_temporal_table)?
+        .collect::<std::result::Result<Vec<_>, _>>()?;
+    Ok(tables)
+}
+
+/// Look up the row history settings of `table`
+pub fn temporal_table(conn: &Connection, table: &str) -> Result<Option<TemporalTable>> {
+    if !registry_exists(conn)? {
+        return Ok(None);
+    }
+    let mut stmt = conn.prepare(&format!(
+        "SELECT table_name, history_table, columns, enabled_at FROM {} WHERE table_name = ?1", REGISTRY_TABLE
+    ))?;
+    let mut rows = stmt.query_map([table], read_temporal_table)?;
+    Ok(rows.next().transpose()?)
+}
+
+/// SQL selecting the rows of a versioned table as they were at the time bound to `?1`
+///
+/// The time is compared as text and must use the format of [`format_timestamp`].
+pub fn as_of_sql(temporal: &TemporalTable) -> String {
+    let columns = temporal.columns.iter().map(|c| quote(c)).collect::<Vec<_>>().join(", ");
+    format!(
+        "SELECT _row_id, {} FROM {} WHERE _valid_from <= ?1 AND (_valid_to IS NULL OR _valid_to > ?1) ORDER BY _row_id",
+        columns, quote(&temporal.history_table)
+    )
+}
+
+/// SQL selecting every version of the row whose rowid is bound to `?1`, oldest first
+pub fn row_history_sql(temporal: &TemporalTable) -> String {
+    format!(
+        "SELECT * FROM {} WHERE _row_id = ?1 ORDER BY _valid_from, rowid",
+        quote(&temporal.history_table)
+    )
+}
+
+/// Rows of a versioned table as they were at `at`
+pub fn table_as_of(conn: &Connection, table: &str, at: &DateTime<Utc>) -> Result<Vec<RowData>> {
+    let temporal = temporal_table(conn, table)?
+        .ok_or_else(|| LumosError::NotFound(format!("Row history is not enabled for table '{}'", table)))?;
+    query_rows(conn, &as_of_sql(&temporal), &format_timestamp(at))
+}
+
+/// Every version of one row of a versioned table, oldest first
+pub fn row_history(conn: &Connection, table: &str, row_id: i64) -> Result<Vec<RowData>> {
+    let temporal = temporal_table(conn, table)?
+        .ok_or_else(|| LumosError::NotFound(format!("Row history is not enabled for table '{}'", table)))?;
+    query_rows(conn, &row_history_sql(&temporal), &row_id)
+}
+
+fn query_rows(conn: &Connection, sql: &str, param: &dyn rusqlite::ToSql) -> Result<Vec<RowData>> {
+    let params: &[&dyn rusqlite::ToSql] = &[param];
+    let mut stmt = conn.prepare(sql)?;
+    let mut rows = stmt.query(params)?;
+    let mut result = Vec::new();
+    while let Some(row) = rows.next()? {
+        result.push(RowData::from_row(row)?);
+    }
+    Ok(result)
+}
+
+fn read_temporal_table(row: &rusqlite::Row) -> rusqlite::Result<TemporalTable> {
+    let columns: String = row.get(2)?;
+    Ok(TemporalTable {
+        table: row.get(0)?,
+        history_table: row.get(1)?,
+        columns: serde_json::from_str(&columns).unwrap_or_default(),
+        enabled_at: row.get(3)?,
+    })
+}
+
+fn registry_exists(conn: &Connection) -> Result<bool> {
+    let count: i64 = conn.query_row(
+        "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?1",
+        [REGISTRY_TABLE],
+        |row| row.get(0),
+    )?;
+    Ok(count > 0)
+}
+
+fn table_columns(conn: &Connection, table: &str) -> Result<Vec<String>> {
+    let mut stmt = conn.prepare("SELECT name FROM pragma_table_info(?1) ORDER BY cid")?;
+    let columns = stmt
+        .query_map([table], |row| row.get::<_, String>(0))?
+        .collect::<std::result::Result<Vec<_>, _>>()?;
+    Ok(columns)
+}
+
+fn trigger_name(table: &str, operation: &str) -> String {
+    format!("{}{}_{}", table, HISTORY_SUFFIX, operation)
+}
+
+fn quote(identifier: &str) -> String {
+    format!("\"{}\"", identifier.replace('"', "\"\""))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_row_history_and_as_of() {
+        let conn = Connection::open_in_memory().unwrap();
+        conn.execute_batch("
+            CREATE TABLE accounts (id INTEGER PRIMARY KEY, owner TEXT, balance INTEGER);
+            INSERT INTO accounts (owner, balance) VALUES ('alice', 100);
+        ").unwrap();
+
+        let temporal = enable_history(&conn, "accounts").unwrap();
+        assert_eq!(temporal.history_table, "accounts_history");
+        assert_eq!(temporal_tables(&conn).unwrap().len(), 1);
+
+        // Pretend the initial version was recorded a while ago
+        conn.execute("UPDATE accounts_history SET _valid_from = '2020-01-01T00:00:00.000Z'", []).unwrap();
+        let before_changes = "2020-06-01T00:00:00.000Z";
+
+        conn.execute("UPDATE accounts SET balance = 50 WHERE owner = 'alice'", []).unwrap();
+        conn.execute("INSERT INTO accounts (owner, balance) VALUES ('bob', 10)", []).unwrap();
+        conn.execute("DELETE FROM accounts WHERE owner = 'bob'", []).unwrap();
+
+        let history = row_history(&conn, "accounts", 1).unwrap();
+        assert_eq!(history.len(), 2);
+        assert_eq!(history[0].values["balance"], "100");
+        assert_ne!(history[0].values["_valid_to"], "NULL");
+        assert_eq!(history[1].values["_valid_to"], "NULL");
+
+        // State at a point in time before the changes
+        let rows = query_rows(&conn, &as_of_sql(&temporal), &before_changes).unwrap();
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0].values["balance"], "100");
+
+        // Current state: bob was deleted
+        let rows = table_as_of(&conn, "accounts", &Utc::now()).unwrap();
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0].values["balance"], "50");
+
+        disable_history(&conn, "accounts", true).unwrap();
+        assert!(temporal_table(&conn, "accounts").unwrap().is_none());
+        conn.execute("UPDATE accounts SET balance = 0", []).unwrap();
+    }
+}
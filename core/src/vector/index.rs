@@ -247,6 +247,15 @@ impl PartitionedVectorIndex {
         }
     }
     
+    /// Choose partition centroids from a sample of the vectors to be indexed
+    ///
+    /// Without training, centroids are derived from the first vector added,
+    /// which leaves most partitions empty on real data.
+    pub fn train(&mut self, sample_vectors: &[Vec<f32>]) {
+        let normalized: Vec<Vec<f32>> = sample_vectors.iter().map(|v| normalize(v)).collect();
+        self.initialize_centroids(&normalized);
+    }
+    
     /// Assign a vector to its nearest partition
     fn assign_partition(&self, vector: &[f32]) -> usize {
         if self.centroids.is_empty() {
@@ -153,6 +153,7 @@ fn test_query_execution() -> Result<()> {
         params: vec![],
         query_type,
         engine_type,
+        timeout: None,
     };
     
     executor.execute_query(&query)?;
@@ -174,6 +175,7 @@ fn test_query_execution() -> Result<()> {
         params,
         query_type,
         engine_type,
+        timeout: None,
     };
     
     executor.execute_query(&query)?;
@@ -190,6 +192,7 @@ fn test_query_execution() -> Result<()> {
         params,
         query_type: QueryType::Insert,
         engine_type: EngineType::SQLite,
+        timeout: None,
     };
     
     executor.execute_query(&query)?;
@@ -207,6 +210,7 @@ fn test_query_execution() -> Result<()> {
         params,
         query_type,
         engine_type,
+        timeout: None,
     };
     
     let result = executor.execute_query(&query)?;
@@ -238,6 +242,7 @@ fn test_query_execution() -> Result<()> {
         params: vec![],
         query_type,
         engine_type,
+        timeout: None,
     };
     
     let result = executor.execute_query(&query)?;
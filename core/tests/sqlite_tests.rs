@@ -258,3 +258,25 @@ fn test_schema_manager() -> Result<()> {
     
     Ok(())
 }
+
+/// Test compiling a statement once and executing it repeatedly
+#[test]
+fn test_sqlite_prepare_and_exec() -> Result<()> {
+    let dir = tempdir().expect("Failed to create temp dir");
+    let db_path = dir.path().join("prepared.db");
+    let engine = SqliteEngine::new(db_path.to_str().expect("Invalid path"));
+    engine.init()?;
+    engine.execute("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)", &[])?;
+    
+    let insert = "INSERT INTO items (name) VALUES (?1)";
+    assert_eq!(engine.prepare(insert)?, 1);
+    for name in ["a", "b", "c"] {
+        assert_eq!(engine.exec(insert, &[&name])?, 1);
+    }
+    assert_eq!(engine.query_all("SELECT * FROM items", &[])?.len(), 3);
+    
+    // Invalid SQL is rejected when it is prepared
+    assert!(engine.prepare("INSERT INTO missing (name) VALUES (?1)").is_err());
+    
+    Ok(())
+}
@@ -1,5 +1,5 @@
 use actix::prelude::*;
-use log::{info, error, debug};
+use log::{info, error, debug, warn};
 use std::collections::{HashMap, HashSet};
 use std::sync::{Arc, RwLock};
 use uuid::Uuid;
@@ -7,15 +7,28 @@ use chrono::{DateTime, Utc};
 
 use crate::config::ETLConfig;
 use crate::dag::DAGManager;
-use crate::types::{ExecutionStatus, JobStatus, JobStats, ETLError};
+use crate::types::{ExecutionStatus, JobStatus, JobStats, NodeStatus, ETLError};
 use crate::actors::job::JobActor;
 use crate::actors::messages::*;
+use crate::store::{ExecutionStore, ExecutionRecord, ExecutionLog};
+use crate::throttle::PressureThrottle;
+use crate::metrics::PipelineMetrics;
 
 /// DAG管理器Actor，负责调度和监控作业执行
 pub struct DAGManagerActor {
     dag_manager: Arc<RwLock<DAGManager>>,
     job_actors: HashMap<String, Addr<JobActor>>,
     current_config: Arc<RwLock<Option<ETLConfig>>>,
+    /// 当前部署的管道定义版本
+    current_version: Option<u32>,
+    /// 各执行使用的管道定义版本
+    execution_versions: HashMap<String, u32>,
+    /// 执行记录和日志的持久化存储
+    store: Option<Arc<ExecutionStore>>,
+    /// 交互查询承压时收紧的并行度限制
+    throttle: Option<Arc<PressureThrottle>>,
+    /// 各作业的运行指标
+    metrics: PipelineMetrics,
 }
 
 impl DAGManagerActor {
@@ -36,6 +49,11 @@ impl DAGManagerActor {
             dag_manager,
             job_actors: HashMap::new(),
             current_config: Arc::new(RwLock::new(Some(config))),
+            current_version: None,
+            execution_versions: HashMap::new(),
+            store: None,
+            throttle: None,
+            metrics: PipelineMetrics::new(),
         })
     }
     
@@ -45,21 +63,80 @@ impl DAGManagerActor {
             dag_manager: Arc::new(RwLock::new(DAGManager::empty())),
             job_actors: HashMap::new(),
             current_config: Arc::new(RwLock::new(None)),
+            current_version: None,
+            execution_versions: HashMap::new(),
+            store: None,
+            throttle: None,
+            metrics: PipelineMetrics::new(),
         })
     }
     
-    /// 设置新的配置
+    /// 将执行记录和日志保存到存储中
+    pub fn with_store(mut self, store: Arc<ExecutionStore>) -> Self {
+        self.store = Some(store);
+        self
+    }
+    
+    /// 交互查询承压时按`throttle`收紧同时运行的作业数
+    pub fn with_throttle(mut self, throttle: Arc<PressureThrottle>) -> Self {
+        self.dag_manager.write().unwrap().set_throttle(Some(throttle.clone()));
+        self.throttle = Some(throttle);
+        self
+    }
+    
+    /// 保存执行的当前状态
+    fn persist_execution(&self, execution_id: &str) {
+        let store = match &self.store {
+            Some(store) => store,
+            None => return,
+        };
+        let status = {
+            let manager = self.dag_manager.read().unwrap();
+            manager.get_execution_status(execution_id)
+        };
+        if let Some(status) = status {
+            let version = self.execution_versions.get(execution_id).copied();
+            if let Err(e) = store.save_execution(&status, version) {
+                error!("保存执行记录失败: {}", e);
+            }
+        }
+    }
+    
+    /// 记录执行日志
+    fn log_execution(&self, execution_id: &str, level: &str, message: &str) {
+        if let Some(store) = &self.store {
+            if let Err(e) = store.append_log(execution_id, level, message) {
+                error!("保存执行日志失败: {}", e);
+            }
+        }
+    }
+    
+    /// 设置新的配置，有未结束的执行时拒绝部署
     pub fn set_config(&mut self, config: ETLConfig) -> Result<(), ETLError> {
         // 验证配置
         if let Err(e) = config.validate() {
             return Err(ETLError::InvalidConfig(e));
         }
         
+        // 新的DAG管理器不包含旧的执行，替换后正在运行的作业无法再更新状态
+        let active: Vec<String> = self.dag_manager.read().unwrap()
+            .list_executions()
+            .into_iter()
+            .filter(|execution| crate::dag::is_active(&execution.status))
+            .map(|execution| execution.execution_id)
+            .collect();
+        if !active.is_empty() {
+            return Err(ETLError::ExecutionError(format!(
+                "有未结束的执行，请等待完成或取消后再部署: {}", active.join(", ")
+            )));
+        }
+        
         // 创建新的DAG管理器
-        let new_manager = match DAGManager::new(config.clone()) {
+        let mut new_manager = match DAGManager::new(config.clone()) {
             Ok(manager) => manager,
             Err(e) => return Err(ETLError::DAGError(e)),
         };
+        new_manager.set_throttle(self.throttle.clone());
         
         // 更新DAG管理器和配置
         {
@@ -82,6 +159,7 @@ impl DAGManagerActor {
         let job_id = msg.job_id.clone();
         
         info!("收到作业完成通知: {} - {}, 状态: {:?}", execution_id, job_id, msg.status);
+        self.metrics.job_finished(&job_id, &msg.stats);
         
         // 更新作业状态
         {
@@ -92,6 +170,11 @@ impl DAGManagerActor {
             }
         }
         
+        match &msg.stats.error {
+            Some(e) => self.log_execution(&execution_id, "error", &format!("作业{}: {:?}, {}", job_id, msg.status, e)),
+            None => self.log_execution(&execution_id, "info", &format!("作业{}: {:?}", job_id, msg.status)),
+        }
+        
         // 如果作业失败或取消，则处理依赖关系
         if msg.status == JobStatus::Failed || msg.status == JobStatus::Cancelled {
             let mut manager = self.dag_manager.write().unwrap();
@@ -101,6 +184,7 @@ impl DAGManagerActor {
         
         // 获取下一批可执行的作业
         self.schedule_ready_jobs(&execution_id, ctx);
+        self.persist_execution(&execution_id);
     }
     
     /// 调度准备好的作业
@@ -165,9 +249,17 @@ impl DAGManagerActor {
                 }
             };
             
+            // 有下游作业时保存输出，供下游作业读取
+            let publish_output = {
+                let manager = self.dag_manager.read().unwrap();
+                !manager.get_dependent_jobs(&job_id).is_empty()
+            };
+            
             // 发送执行消息
+            self.metrics.job_started(&job_id);
             job_actor.do_send(ExecuteJob {
                 execution_id: execution_id.to_string(),
+                publish_output,
             });
         }
     }
@@ -214,8 +306,19 @@ impl Handler<StartDAGExecution> for DAGManagerActor {
             }
         }
         
+        // 记录执行使用的管道定义版本
+        if let Some(version) = self.current_version {
+            self.execution_versions.insert(execution_id.clone(), version);
+        }
+        
+        self.log_execution(&execution_id, "info", &match self.current_version {
+            Some(version) => format!("开始执行，管道定义版本{}", version),
+            None => "开始执行".to_string(),
+        });
+        
         // 调度准备好的作业
         self.schedule_ready_jobs(&execution_id, ctx);
+        self.persist_execution(&execution_id);
         
         Box::pin(async move {
             Ok(())
@@ -243,6 +346,9 @@ impl Handler<CancelDAGExecution> for DAGManagerActor {
             return false;
         }
         
+        self.log_execution(&execution_id, "warn", "执行已取消");
+        self.persist_execution(&execution_id);
+        
         // 向所有正在运行的作业发送取消消息
         for (job_id, actor) in &self.job_actors {
             actor.do_send(CancelJob);
@@ -252,6 +358,53 @@ impl Handler<CancelDAGExecution> for DAGManagerActor {
     }
 }
 
+/// 处理暂停DAG执行消息
+impl Handler<PauseDAGExecution> for DAGManagerActor {
+    type Result = Result<(), String>;
+    
+    fn handle(&mut self, msg: PauseDAGExecution, _: &mut Context<Self>) -> Self::Result {
+        info!("暂停DAG执行: {}", msg.execution_id);
+        
+        self.dag_manager.write().unwrap().pause_execution(&msg.execution_id)?;
+        self.log_execution(&msg.execution_id, "info", "执行已暂停，运行中的作业将继续完成");
+        self.persist_execution(&msg.execution_id);
+        Ok(())
+    }
+}
+
+/// 处理恢复DAG执行消息
+impl Handler<ResumeDAGExecution> for DAGManagerActor {
+    type Result = Result<(), String>;
+    
+    fn handle(&mut self, msg: ResumeDAGExecution, ctx: &mut Context<Self>) -> Self::Result {
+        info!("恢复DAG执行: {}", msg.execution_id);
+        
+        self.dag_manager.write().unwrap().resume_execution(&msg.execution_id)?;
+        self.log_execution(&msg.execution_id, "info", "执行已恢复");
+        self.schedule_ready_jobs(&msg.execution_id, ctx);
+        self.persist_execution(&msg.execution_id);
+        Ok(())
+    }
+}
+
+/// 处理排空DAG执行消息
+impl Handler<DrainDAGExecution> for DAGManagerActor {
+    type Result = Result<(), String>;
+    
+    fn handle(&mut self, msg: DrainDAGExecution, _: &mut Context<Self>) -> Self::Result {
+        info!("排空DAG执行: {}", msg.execution_id);
+        
+        let drained = self.dag_manager.write().unwrap().drain_execution(&msg.execution_id)?;
+        self.log_execution(&msg.execution_id, "info", if drained {
+            "执行已排空"
+        } else {
+            "开始排空执行，等待运行中的作业完成"
+        });
+        self.persist_execution(&msg.execution_id);
+        Ok(())
+    }
+}
+
 /// 处理获取DAG执行状态消息
 impl Handler<GetDAGExecutionStatus> for DAGManagerActor {
     type Result = Option<ExecutionStatus>;
@@ -261,9 +414,21 @@ impl Handler<GetDAGExecutionStatus> for DAGManagerActor {
         
         debug!("获取DAG执行状态: {}", execution_id);
         
-        // 获取执行状态
-        let manager = self.dag_manager.read().unwrap();
-        manager.get_execution_status(&execution_id)
+        // 获取执行状态，内存中没有时读取服务重启前保存的记录
+        let status = {
+            let manager = self.dag_manager.read().unwrap();
+            manager.get_execution_status(&execution_id)
+        };
+        status.or_else(|| {
+            let store = self.store.as_ref()?;
+            match store.get_execution(&execution_id) {
+                Ok(record) => record.map(|r| r.execution),
+                Err(e) => {
+                    error!("读取执行记录失败: {}", e);
+                    None
+                }
+            }
+        })
     }
 }
 
@@ -287,4 +452,128 @@ impl Handler<JobCompleted> for DAGManagerActor {
     fn handle(&mut self, msg: JobCompleted, ctx: &mut Context<Self>) -> Self::Result {
         self.handle_job_completed(msg, ctx);
     }
-} 
\ No newline at end of file
+}
+
+/// 处理导出运行指标消息
+impl Handler<RenderMetrics> for DAGManagerActor {
+    type Result = String;
+    
+    fn handle(&mut self, _: RenderMetrics, _: &mut Context<Self>) -> Self::Result {
+        self.metrics.render_prometheus("lumos_dataflow")
+    }
+}
+
+/// 处理部署管道定义版本消息
+impl Handler<SetConfig> for DAGManagerActor {
+    type Result = Result<(), String>;
+    
+    fn handle(&mut self, msg: SetConfig, _: &mut Context<Self>) -> Self::Result {
+        info!("部署管道定义版本: {}", msg.version);
+        
+        self.set_config(msg.config).map_err(|e| e.to_string())?;
+        self.current_version = Some(msg.version);
+        Ok(())
+    }
+}
+
+/// 处理获取执行版本消息
+impl Handler<GetExecutionVersions> for DAGManagerActor {
+    type Result = MessageResult<GetExecutionVersions>;
+    
+    fn handle(&mut self, _: GetExecutionVersions, _: &mut Context<Self>) -> Self::Result {
+        MessageResult(self.execution_versions.clone())
+    }
+}
+
+
+/// 处理按条件列出执行记录消息
+impl Handler<ListExecutions> for DAGManagerActor {
+    type Result = Result<Vec<ExecutionRecord>, String>;
+    
+    fn handle(&mut self, msg: ListExecutions, _: &mut Context<Self>) -> Self::Result {
+        debug!("按条件列出DAG执行: {:?}", msg.filter);
+        
+        if let Some(store) = &self.store {
+            return store.list_executions(&msg.filter);
+        }
+        
+        let manager = self.dag_manager.read().unwrap();
+        let records = manager.list_executions().into_iter()
+            .map(|execution| ExecutionRecord {
+                config_version: self.execution_versions.get(&execution.execution_id).copied(),
+                updated_at: execution.end_time.unwrap_or(execution.start_time),
+                execution,
+            })
+            .collect();
+        Ok(msg.filter.apply(records))
+    }
+}
+
+/// 处理获取执行日志消息
+impl Handler<GetExecutionLogs> for DAGManagerActor {
+    type Result = Result<Vec<ExecutionLog>, String>;
+    
+    fn handle(&mut self, msg: GetExecutionLogs, _: &mut Context<Self>) -> Self::Result {
+        match &self.store {
+            Some(store) => store.logs(&msg.execution_id),
+            None => Err("未配置执行记录存储".to_string()),
+        }
+    }
+}
+
+/// 处理恢复未完成执行消息
+impl Handler<ResumeExecutions> for DAGManagerActor {
+    type Result = Result<usize, String>;
+    
+    fn handle(&mut self, _: ResumeExecutions, ctx: &mut Context<Self>) -> Self::Result {
+        let store = match &self.store {
+            Some(store) => store.clone(),
+            None => return Ok(0),
+        };
+        
+        let mut resumed = 0;
+        for record in store.incomplete_executions()? {
+            let execution_id = record.execution.execution_id.clone();
+            
+            // 执行使用的管道定义已不是当前版本时，作业配置可能已变化，不再继续
+            if record.config_version != self.current_version {
+                warn!("执行{}使用的管道定义版本{:?}不是当前版本，标记为失败", execution_id, record.config_version);
+                let mut execution = record.execution;
+                execution.status = "failed".to_string();
+                execution.end_time = Some(Utc::now());
+                store.save_execution(&execution, record.config_version)?;
+                self.log_execution(&execution_id, "error", "服务重启后管道定义版本已变化，无法继续执行");
+                continue;
+            }
+            
+            {
+                let mut manager = self.dag_manager.write().unwrap();
+                if let Err(e) = manager.restore_execution(record.execution) {
+                    error!("恢复DAG执行失败: {}", e);
+                    continue;
+                }
+            }
+            if let Some(version) = record.config_version {
+                self.execution_versions.insert(execution_id.clone(), version);
+            }
+            
+            info!("继续执行: {}", execution_id);
+            self.log_execution(&execution_id, "info", "服务重启后继续执行");
+            self.schedule_ready_jobs(&execution_id, ctx);
+            self.persist_execution(&execution_id);
+            resumed += 1;
+        }
+        
+        Ok(resumed)
+    }
+}
+
+/// 处理获取执行节点状态消息
+impl Handler<GetExecutionNodes> for DAGManagerActor {
+    type Result = Option<Vec<NodeStatus>>;
+    
+    fn handle(&mut self, msg: GetExecutionNodes, _: &mut Context<Self>) -> Self::Result {
+        let manager = self.dag_manager.read().unwrap();
+        manager.node_statuses(&msg.execution_id)
+    }
+}
@@ -5,7 +5,7 @@ use chrono::Utc;
 use log::{info, error, debug, warn};
 use tokio::time::timeout;
 
-use crate::types::{JobConfig, JobStatus, JobStats, ETLError};
+use crate::types::{JobConfig, JobStatus, JobStats, PipelineStats, ETLError};
 use crate::actors::pipeline::{PipelineActor, StartPipeline, GetPipelineStats, StopPipeline};
 use crate::actors::messages::*;
 
@@ -19,6 +19,8 @@ pub struct JobActor {
     execution_id: Option<String>,
     start_time: Option<Instant>,
     retry_count: u32,
+    /// 是否保存输出供下游作业读取
+    publish_output: bool,
 }
 
 impl JobActor {
@@ -39,6 +41,9 @@ impl JobActor {
             records_failed: 0,
             error: None,
             retry_count: 0,
+            extract_retries: 0,
+            load_retries: 0,
+            duplicates_skipped: 0,
         };
         
         Ok(Self {
@@ -50,6 +55,7 @@ impl JobActor {
             execution_id: None,
             start_time: None,
             retry_count: 0,
+            publish_output: false,
         })
     }
     
@@ -65,7 +71,9 @@ impl JobActor {
         
         // 创建管道Actor
         let pipeline = match PipelineActor::new(self.config.pipeline.clone()) {
-            Ok(actor) => actor.start(),
+            Ok(actor) => actor
+                .with_execution(&execution_id, &self.config.id, self.publish_output)
+                .start(),
             Err(e) => {
                 error!("创建管道Actor失败: {}", e);
                 self.stats.error = Some(format!("创建管道失败: {}", e));
@@ -106,6 +114,17 @@ impl JobActor {
                 Ok(r) => r,
                 Err(e) => {
                     error!("发送StartPipeline消息失败: {}", e);
+                    self.stats.error = Some(format!("发送StartPipeline消息失败: {}", e));
+                    self.finish(JobStatus::Failed);
+                    
+                    // 通知DAG管理器任务失败
+                    self.dag_manager.do_send(JobCompleted {
+                        execution_id,
+                        job_id: self.config.id.clone(),
+                        status: JobStatus::Failed,
+                        stats: self.stats.clone(),
+                    });
+                    
                     return Err(format!("发送StartPipeline消息失败: {}", e));
                 }
             },
@@ -156,6 +175,7 @@ impl JobActor {
                 // 重新执行任务
                 return self.execute_job(execution_id).await;
             }
+            self.finish(JobStatus::Failed);
             
             // 通知DAG管理器任务失败
             self.dag_manager.do_send(JobCompleted {
@@ -173,21 +193,9 @@ impl JobActor {
             Ok(stats) => stats,
             Err(e) => {
                 error!("获取管道统计信息失败: {}", e);
-                JobStats {
-                    job_id: self.config.id.clone(),
-                    start_time: self.stats.start_time,
-                    end_time: Some(Utc::now()),
-                    duration_ms: if let Some(start) = self.start_time {
-                        Some(start.elapsed().as_millis() as u64)
-                    } else {
-                        None
-                    },
-                    status: self.status,
-                    records_read: 0,
-                    records_written: 0,
-                    records_failed: 0,
-                    error: Some(format!("获取统计信息失败: {}", e)),
-                    retry_count: self.stats.retry_count,
+                PipelineStats {
+                    errors: vec![format!("获取统计信息失败: {}", e)],
+                    ..PipelineStats::default()
                 }
             }
         };
@@ -200,6 +208,9 @@ impl JobActor {
         self.stats.records_read = pipeline_stats.records_read;
         self.stats.records_written = pipeline_stats.records_written;
         self.stats.records_failed = pipeline_stats.records_failed;
+        self.stats.extract_retries = pipeline_stats.extract_retries;
+        self.stats.load_retries = pipeline_stats.load_retries;
+        self.stats.duplicates_skipped = pipeline_stats.duplicates_skipped;
         
         // 标记任务为成功完成
         self.status = JobStatus::Success;
@@ -217,6 +228,16 @@ impl JobActor {
         Ok(())
     }
     
+    /// 记录任务的结束状态、结束时间和耗时
+    fn finish(&mut self, status: JobStatus) {
+        self.status = status;
+        self.stats.status = status;
+        self.stats.end_time = Some(Utc::now());
+        if let Some(start) = self.start_time {
+            self.stats.duration_ms = Some(start.elapsed().as_millis() as u64);
+        }
+    }
+    
     /// 检查是否应该重试
     fn should_retry(&self) -> bool {
         self.config.retry.count > 0 && self.retry_count < self.config.retry.count
@@ -260,6 +281,7 @@ impl Handler<ExecuteJob> for JobActor {
     
     fn handle(&mut self, msg: ExecuteJob, _: &mut Context<Self>) -> Self::Result {
         let execution_id = msg.execution_id.clone();
+        self.publish_output = msg.publish_output;
         Box::pin(
             async move {
                 let execution_id_clone = execution_id.clone();
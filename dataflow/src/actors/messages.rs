@@ -1,6 +1,8 @@
 use actix::prelude::*;
 use std::collections::HashMap;
-use crate::types::{DataRecord, JobStatus, JobStats, PipelineStats, DAGExecutionStatus};
+use crate::config::ETLConfig;
+use crate::types::{DataRecord, JobStatus, JobStats, NodeStatus, PipelineStats, DAGExecutionStatus};
+use crate::store::{ExecutionFilter, ExecutionLog, ExecutionRecord};
 
 /// 启动DAG执行
 #[derive(Message)]
@@ -12,6 +14,27 @@ pub struct StartDAGExecution;
 #[rtype(result = "Result<(), String>")]
 pub struct CancelDAGExecution(pub String);
 
+/// 暂停DAG执行，不再调度新的作业
+#[derive(Message)]
+#[rtype(result = "Result<(), String>")]
+pub struct PauseDAGExecution {
+    pub execution_id: String,
+}
+
+/// 恢复暂停的DAG执行
+#[derive(Message)]
+#[rtype(result = "Result<(), String>")]
+pub struct ResumeDAGExecution {
+    pub execution_id: String,
+}
+
+/// 排空DAG执行，运行中的作业完成后停止
+#[derive(Message)]
+#[rtype(result = "Result<(), String>")]
+pub struct DrainDAGExecution {
+    pub execution_id: String,
+}
+
 /// 获取DAG执行状态
 #[derive(Message)]
 #[rtype(result = "Result<DAGExecutionStatus, String>")]
@@ -22,6 +45,50 @@ pub struct GetDAGExecutionStatus(pub String);
 #[rtype(result = "Result<Vec<DAGExecutionStatus>, String>")]
 pub struct ListDAGExecutions;
 
+/// 按过滤条件列出执行记录
+#[derive(Message)]
+#[rtype(result = "Result<Vec<ExecutionRecord>, String>")]
+pub struct ListExecutions {
+    pub filter: ExecutionFilter,
+}
+
+/// 恢复并继续服务停止时未完成的执行，返回恢复的执行数
+#[derive(Message)]
+#[rtype(result = "Result<usize, String>")]
+pub struct ResumeExecutions;
+
+/// 获取执行日志
+#[derive(Message)]
+#[rtype(result = "Result<Vec<ExecutionLog>, String>")]
+pub struct GetExecutionLogs {
+    pub execution_id: String,
+}
+
+/// 获取执行中各作业（DAG节点）的状态
+#[derive(Message)]
+#[rtype(result = "Option<Vec<NodeStatus>>")]
+pub struct GetExecutionNodes {
+    pub execution_id: String,
+}
+
+/// 部署管道定义的指定版本，之后启动的执行使用该版本
+#[derive(Message)]
+#[rtype(result = "Result<(), String>")]
+pub struct SetConfig {
+    pub config: ETLConfig,
+    pub version: u32,
+}
+
+/// 获取各执行使用的管道定义版本
+#[derive(Message)]
+#[rtype(result = "HashMap<String, u32>")]
+pub struct GetExecutionVersions;
+
+/// 以Prometheus文本格式导出各作业的运行指标
+#[derive(Message)]
+#[rtype(result = "String")]
+pub struct RenderMetrics;
+
 /// 任务完成通知
 #[derive(Message)]
 #[rtype(result = "()")]
@@ -37,6 +104,8 @@ pub struct JobCompleted {
 #[rtype(result = "()")]
 pub struct ExecuteJob {
     pub execution_id: String,
+    /// 是否保存作业的输出供下游作业读取
+    pub publish_output: bool,
 }
 
 /// 取消任务
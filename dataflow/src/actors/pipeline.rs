@@ -1,12 +1,17 @@
 use actix::prelude::*;
 use log::{info, error, debug, warn};
 use std::sync::{Arc, Mutex};
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
 use chrono::Utc;
 
-use crate::types::{PipelineConfig, DataRecord, JobStats, ETLError, JobStatus};
+use crate::types::{PipelineConfig, PipelineStats, DataRecord, JobStats, ETLError, JobStatus};
+use crate::retry::RetryPolicy;
+use crate::idempotency::{IdempotencyConfig, WrittenRecords};
 use crate::actors::messages::{ExtractData, TransformData, LoadData};
 
+/// 幂等加载时每批写入的记录数，未配置`batch_size`时使用
+const DEFAULT_IDEMPOTENT_BATCH_SIZE: usize = 1000;
+
 /// 开始数据管道执行
 #[derive(Message)]
 #[rtype(result = "Result<(), String>")]
@@ -19,7 +24,7 @@ pub struct StopPipeline;
 
 /// 获取管道统计信息
 #[derive(Message)]
-#[rtype(result = "JobStats")]
+#[rtype(result = "PipelineStats")]
 pub struct GetPipelineStats;
 
 /// 数据管道Actor，负责协调Extract、Transform、Load过程
@@ -34,6 +39,16 @@ pub struct PipelineActor {
     current_extractor: Option<String>,
     current_transformer: Option<String>,
     current_loader: Option<String>,
+    extract_retries: u32,
+    load_retries: u32,
+    /// 幂等写入时跳过的已写入记录数
+    duplicates_skipped: u64,
+    /// 所属的执行和作业，在DAG执行中运行时设置
+    execution: Option<(String, String)>,
+    /// 是否保存写入目标的记录供下游作业读取
+    publish_output: bool,
+    /// 运行标识，提取器和转换器按它登记加载成功后才提交的状态
+    run_id: String,
 }
 
 impl PipelineActor {
@@ -51,8 +66,13 @@ impl PipelineActor {
             records_failed: 0,
             error: None,
             retry_count: 0,
+            extract_retries: 0,
+            load_retries: 0,
+            duplicates_skipped: 0,
         };
         
+        let run_id = format!("{}-{}", config.id, uuid::Uuid::new_v4());
+        
         Ok(Self {
             config,
             stats,
@@ -64,6 +84,44 @@ impl PipelineActor {
             current_extractor: None,
             current_transformer: None,
             current_loader: None,
+            extract_retries: 0,
+            load_retries: 0,
+            duplicates_skipped: 0,
+            run_id,
+            execution: None,
+            publish_output: false,
+        })
+    }
+    
+    /// 设置所属的执行和作业，`publish_output`为true时保存输出供下游作业读取
+    pub fn with_execution(mut self, execution_id: &str, job_id: &str, publish_output: bool) -> Self {
+        self.execution = Some((execution_id.to_string(), job_id.to_string()));
+        self.publish_output = publish_output;
+        self
+    }
+    
+    /// 管道统计信息，包括各阶段的重试次数
+    pub fn pipeline_stats(&self) -> PipelineStats {
+        PipelineStats {
+            start_time: self.stats.start_time,
+            end_time: self.stats.end_time,
+            duration_ms: self.stats.duration_ms,
+            records_read: self.stats.records_read,
+            records_processed: self.stats.records_read.saturating_sub(self.stats.records_failed),
+            records_written: self.stats.records_written,
+            records_failed: self.stats.records_failed,
+            extract_retries: self.extract_retries,
+            load_retries: self.load_retries,
+            duplicates_skipped: self.duplicates_skipped,
+            errors: self.stats.error.iter().cloned().collect(),
+        }
+    }
+    
+    /// 管道的重试策略，未配置时只尝试一次
+    fn retry_policy(&self) -> RetryPolicy {
+        self.config.retry_policy.clone().unwrap_or(RetryPolicy {
+            max_attempts: 1,
+            ..RetryPolicy::default()
         })
     }
     
@@ -100,7 +158,11 @@ impl PipelineActor {
     /// 初始化转换器
     async fn create_transformers(&mut self) -> Result<(), String> {
         for transformer_config in &self.config.transformers {
-            let options = transformer_config.options.clone();
+            // 转换器按运行标识登记加载成功后才提交的状态，按作业ID区分状态
+            let mut options = transformer_config.options.clone();
+            options.insert(crate::commit::RUN_ID_OPTION.to_string(), serde_json::Value::String(self.run_id.clone()));
+            let job_id = self.execution.as_ref().map_or(&self.config.id, |(_, job_id)| job_id);
+            options.insert("job_id".to_string(), serde_json::Value::String(job_id.clone()));
             
             match crate::transformers::factory::create_transformer(&transformer_config.transformer_type, options) {
                 Ok(transformer) => {
@@ -182,6 +244,7 @@ impl PipelineActor {
             Err(e) => {
                 error!("数据提取失败: {}", e);
                 self.stats.error = Some(format!("数据提取失败: {}", e));
+                crate::commit::discard(&self.run_id);
                 return Err(e);
             }
         };
@@ -192,19 +255,32 @@ impl PipelineActor {
             Err(e) => {
                 error!("数据转换失败: {}", e);
                 self.stats.error = Some(format!("数据转换失败: {}", e));
+                crate::commit::discard(&self.run_id);
                 return Err(e);
             }
         };
         
+        // 有下游作业时保留写入目标的记录
+        let output = if self.publish_output { Some(transformed.clone()) } else { None };
+        
         // 加载数据
         match self.load_data(transformed).await {
             Ok(count) => {
                 self.stats.records_written += count;
                 info!("数据加载成功，写入{}条记录", count);
+                // 数据写入目标后才保存检查点、归档文件等，失败的运行会重新读取这些数据
+                let failed = crate::commit::commit(&self.run_id);
+                if failed > 0 {
+                    warn!("{}个提取或转换状态保存失败，下次运行可能重复读取部分记录", failed);
+                }
+                if let (Some((execution_id, job_id)), Some(output)) = (&self.execution, output) {
+                    crate::extractors::upstream::publish_output(execution_id, job_id, output);
+                }
             },
             Err(e) => {
                 error!("数据加载失败: {}", e);
                 self.stats.error = Some(format!("数据加载失败: {}", e));
+                crate::commit::discard(&self.run_id);
                 return Err(e);
             }
         }
@@ -226,26 +302,38 @@ impl PipelineActor {
         
         // 如果已经注册了提取器，则使用注册的提取器
         if let Some((name, extractor)) = self.extractors.iter().next() {
+            let extractor = extractor.clone();
             self.current_extractor = Some(name.clone());
             
-            // 构建提取参数
-            let options = self.config.extractor.options.clone();
+            // 构建提取参数，上游作业输出提取器需要知道所属的执行
+            let mut options = self.config.extractor.options.clone();
+            options.insert(crate::commit::RUN_ID_OPTION.to_string(), serde_json::Value::String(self.run_id.clone()));
+            if let Some((execution_id, _)) = &self.execution {
+                options.insert("execution_id".to_string(), serde_json::Value::String(execution_id.clone()));
+            }
             
-            // 调用提取器
-            match extractor.send(ExtractData { options }).await {
-                Ok(result) => match result {
-                    Ok(records) => {
-                        self.stats.records_read = records.len() as u64;
-                        Ok(records)
-                    },
-                    Err(e) => {
-                        error!("提取器执行失败: {}", e);
-                        Err(e)
+            // 调用提取器，暂时性错误按重试策略重试
+            let (result, retries) = self.retry_policy().run("数据提取", || {
+                let extractor = extractor.clone();
+                let options = options.clone();
+                async move {
+                    match extractor.send(ExtractData { options }).await {
+                        Ok(result) => result,
+                        Err(e) => Err(format!("发送ExtractData消息失败: {}", e)),
                     }
+                }
+            }).await;
+            self.extract_retries += retries;
+            self.stats.retry_count += retries;
+            
+            match result {
+                Ok(records) => {
+                    self.stats.records_read = records.len() as u64;
+                    Ok(records)
                 },
                 Err(e) => {
-                    error!("发送ExtractData消息失败: {}", e);
-                    Err(format!("发送ExtractData消息失败: {}", e))
+                    error!("提取器执行失败: {}", e);
+                    Err(e)
                 }
             }
         } else {
@@ -311,21 +399,13 @@ impl PipelineActor {
         
         // 如果已经注册了加载器，则使用注册的加载器
         if let Some((name, loader)) = self.loaders.iter().next() {
+            let loader = loader.clone();
             self.current_loader = Some(name.clone());
             
-            // 调用加载器
-            match loader.send(LoadData { records: data.clone() }).await {
-                Ok(result) => match result {
-                    Ok(count) => Ok(count),
-                    Err(e) => {
-                        error!("加载器执行失败: {}", e);
-                        Err(e)
-                    }
-                },
-                Err(e) => {
-                    error!("发送LoadData消息失败: {}", e);
-                    Err(format!("发送LoadData消息失败: {}", e))
-                }
+            // 启用幂等写入时跳过已写入目标的记录
+            match IdempotencyConfig::from_options(&self.config.loader.loader_type, &self.config.loader.options) {
+                Some(idempotency) => self.load_idempotent(loader, data, &idempotency).await,
+                None => self.load_batch(loader, data).await,
             }
         } else {
             // 如果没有注册加载器，则直接返回数据记录数
@@ -333,6 +413,57 @@ impl PipelineActor {
             Ok(data.len() as u64)
         }
     }
+    
+    /// 调用加载器写入一批记录，暂时性错误按重试策略重试整批记录
+    async fn load_batch(&mut self, loader: Addr<dyn Actor>, data: Vec<DataRecord>) -> Result<u64, String> {
+        let (result, retries) = self.retry_policy().run("数据加载", || {
+            let loader = loader.clone();
+            let records = data.clone();
+            async move {
+                match loader.send(LoadData { records }).await {
+                    Ok(result) => result,
+                    Err(e) => Err(format!("发送LoadData消息失败: {}", e)),
+                }
+            }
+        }).await;
+        self.load_retries += retries;
+        self.stats.retry_count += retries;
+        
+        result.map_err(|e| {
+            error!("加载器执行失败: {}", e);
+            e
+        })
+    }
+    
+    /// 幂等加载：跳过哈希已记录的记录，每批写入成功后记录其哈希，
+    /// 部分失败后重新运行时只写入尚未成功的批次
+    async fn load_idempotent(&mut self, loader: Addr<dyn Actor>, data: Vec<DataRecord>, idempotency: &IdempotencyConfig) -> Result<u64, String> {
+        let ledger = WrittenRecords::open(&idempotency.state_db)?;
+        let total = data.len();
+        let hashes: Vec<String> = data.iter().map(|r| idempotency.record_hash(r)).collect();
+        let written = ledger.written(&idempotency.sink_key, &hashes)?;
+        
+        // 内容相同的记录无法区分，同一次运行中也只写入一次
+        let mut seen = HashSet::new();
+        let (pending, pending_hashes): (Vec<DataRecord>, Vec<String>) = data.into_iter()
+            .zip(hashes)
+            .filter(|(_, hash)| !written.contains(hash) && seen.insert(hash.clone()))
+            .unzip();
+        
+        let skipped = (total - pending.len()) as u64;
+        self.duplicates_skipped += skipped;
+        if skipped > 0 {
+            info!("跳过{}条已写入目标的记录", skipped);
+        }
+        
+        let batch_size = self.config.batch_size.unwrap_or(DEFAULT_IDEMPOTENT_BATCH_SIZE).max(1);
+        let mut loaded = 0;
+        for (records, hashes) in pending.chunks(batch_size).zip(pending_hashes.chunks(batch_size)) {
+            loaded += self.load_batch(loader.clone(), records.to_vec()).await?;
+            ledger.mark_written(&idempotency.sink_key, hashes)?;
+        }
+        Ok(loaded)
+    }
 }
 
 impl Actor for PipelineActor {
@@ -367,14 +498,15 @@ impl Handler<StopPipeline> for PipelineActor {
         if self.running {
             info!("停止管道执行: {}", self.config.id);
             self.running = false;
+            crate::commit::discard(&self.run_id);
         }
     }
 }
 
 impl Handler<GetPipelineStats> for PipelineActor {
-    type Result = JobStats;
+    type Result = MessageResult<GetPipelineStats>;
     
     fn handle(&mut self, _: GetPipelineStats, _: &mut Context<Self>) -> Self::Result {
-        self.stats.clone()
+        MessageResult(self.pipeline_stats())
     }
 } 
\ No newline at end of file
@@ -1,3 +1,3 @@
 pub mod rest;
 
-pub use rest::start_api_server; 
\ No newline at end of file
+pub use rest::{start_api_server, restore_config_versions}; 
\ No newline at end of file
@@ -1,5 +1,5 @@
 use actix::Addr;
-use actix_web::{web, HttpResponse, Error, error::ErrorInternalServerError};
+use actix_web::{web, http::header, HttpRequest, HttpResponse, Error, error::ErrorInternalServerError};
 use serde::{Serialize, Deserialize};
 use std::sync::{Arc, RwLock};
 use log::{info, error};
@@ -7,8 +7,10 @@ use uuid::Uuid;
 
 use crate::actors::dag_manager::DAGManagerActor;
 use crate::actors::messages::*;
-use crate::config::ETLConfig;
+use crate::config::{ConfigFormat, ETLConfig};
 use crate::types::*;
+use crate::store::{ExecutionFilter, ExecutionStore};
+use crate::versions::{ConfigVersion, PipelineVersions};
 
 /// API响应包装
 #[derive(Serialize)]
@@ -94,26 +96,97 @@ pub async fn get_execution_status(
             ErrorInternalServerError(format!("发送GetDAGExecutionStatus消息失败: {}", e))
         })?;
     
+    let versions = execution_versions(&dag_manager).await?;
+    
     match result {
-        Some(status) => Ok(HttpResponse::Ok().json(ApiResponse::success(status))),
+        Some(status) => Ok(HttpResponse::Ok().json(ApiResponse::success(with_config_version(status, &versions)))),
         None => Ok(HttpResponse::NotFound().json(ApiResponse::<()>::error(&format!("找不到执行记录：{}", execution_id))))
     }
 }
 
-/// 列出所有执行
+/// 按状态、管道定义版本和开始时间列出执行
 pub async fn list_executions(
     dag_manager: web::Data<Addr<DAGManagerActor>>,
+    query: web::Query<ExecutionFilter>,
 ) -> Result<HttpResponse, Error> {
-    info!("列出所有DAG执行");
+    info!("列出DAG执行");
     
     // 向DAG管理器发送列表查询消息
-    let result = dag_manager.send(ListDAGExecutions).await
+    let result = dag_manager.send(ListExecutions { filter: query.into_inner() }).await
+        .map_err(|e| {
+            error!("发送ListExecutions消息失败: {}", e);
+            ErrorInternalServerError(format!("发送ListExecutions消息失败: {}", e))
+        })?;
+    
+    match result {
+        Ok(executions) => Ok(HttpResponse::Ok().json(ApiResponse::success(executions))),
+        Err(e) => {
+            error!("列出DAG执行失败: {}", e);
+            Ok(HttpResponse::InternalServerError().json(ApiResponse::<()>::error(&e)))
+        }
+    }
+}
+
+/// 获取执行中各作业（DAG节点）的状态
+pub async fn get_execution_nodes(
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+    path: web::Path<String>,
+) -> Result<HttpResponse, Error> {
+    let execution_id = path.into_inner();
+    
+    let result = dag_manager.send(GetExecutionNodes { execution_id: execution_id.clone() }).await
+        .map_err(|e| {
+            error!("发送GetExecutionNodes消息失败: {}", e);
+            ErrorInternalServerError(format!("发送GetExecutionNodes消息失败: {}", e))
+        })?;
+    
+    match result {
+        Some(nodes) => Ok(HttpResponse::Ok().json(ApiResponse::success(nodes))),
+        None => Ok(HttpResponse::NotFound().json(ApiResponse::<()>::error(&format!("找不到执行记录：{}", execution_id))))
+    }
+}
+
+/// 获取执行日志
+pub async fn get_execution_logs(
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+    path: web::Path<String>,
+) -> Result<HttpResponse, Error> {
+    let execution_id = path.into_inner();
+    
+    let result = dag_manager.send(GetExecutionLogs { execution_id }).await
         .map_err(|e| {
-            error!("发送ListDAGExecutions消息失败: {}", e);
-            ErrorInternalServerError(format!("发送ListDAGExecutions消息失败: {}", e))
+            error!("发送GetExecutionLogs消息失败: {}", e);
+            ErrorInternalServerError(format!("发送GetExecutionLogs消息失败: {}", e))
         })?;
     
-    Ok(HttpResponse::Ok().json(ApiResponse::success(result)))
+    match result {
+        Ok(logs) => Ok(HttpResponse::Ok().json(ApiResponse::success(logs))),
+        Err(e) => Ok(HttpResponse::NotImplemented().json(ApiResponse::<()>::error(&e)))
+    }
+}
+
+/// 获取各执行使用的管道定义版本
+async fn execution_versions(
+    dag_manager: &web::Data<Addr<DAGManagerActor>>,
+) -> Result<std::collections::HashMap<String, u32>, Error> {
+    dag_manager.send(GetExecutionVersions).await
+        .map_err(|e| {
+            error!("发送GetExecutionVersions消息失败: {}", e);
+            ErrorInternalServerError(format!("发送GetExecutionVersions消息失败: {}", e))
+        })
+}
+
+/// 在执行记录中加入所用的管道定义版本
+fn with_config_version<T: Serialize>(status: T, versions: &std::collections::HashMap<String, u32>) -> serde_json::Value {
+    let mut value = serde_json::to_value(status).unwrap_or(serde_json::Value::Null);
+    if let Some(record) = value.as_object_mut() {
+        let version = record.get("execution_id")
+            .and_then(|id| id.as_str())
+            .and_then(|id| versions.get(id))
+            .copied();
+        record.insert("config_version".to_string(), serde_json::json!(version));
+    }
+    value
 }
 
 /// 取消执行
@@ -138,6 +211,65 @@ pub async fn cancel_execution(
     }
 }
 
+/// 暂停执行
+pub async fn pause_execution(
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+    path: web::Path<String>,
+) -> Result<HttpResponse, Error> {
+    let execution_id = path.into_inner();
+    
+    info!("暂停DAG执行：{}", execution_id);
+    
+    let result = dag_manager.send(PauseDAGExecution { execution_id }).await
+        .map_err(|e| {
+            error!("发送PauseDAGExecution消息失败: {}", e);
+            ErrorInternalServerError(format!("发送PauseDAGExecution消息失败: {}", e))
+        })?;
+    Ok(execution_control_response(result))
+}
+
+/// 恢复暂停的执行
+pub async fn resume_execution(
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+    path: web::Path<String>,
+) -> Result<HttpResponse, Error> {
+    let execution_id = path.into_inner();
+    
+    info!("恢复DAG执行：{}", execution_id);
+    
+    let result = dag_manager.send(ResumeDAGExecution { execution_id }).await
+        .map_err(|e| {
+            error!("发送ResumeDAGExecution消息失败: {}", e);
+            ErrorInternalServerError(format!("发送ResumeDAGExecution消息失败: {}", e))
+        })?;
+    Ok(execution_control_response(result))
+}
+
+/// 排空执行，运行中的作业完成后停止
+pub async fn drain_execution(
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+    path: web::Path<String>,
+) -> Result<HttpResponse, Error> {
+    let execution_id = path.into_inner();
+    
+    info!("排空DAG执行：{}", execution_id);
+    
+    let result = dag_manager.send(DrainDAGExecution { execution_id }).await
+        .map_err(|e| {
+            error!("发送DrainDAGExecution消息失败: {}", e);
+            ErrorInternalServerError(format!("发送DrainDAGExecution消息失败: {}", e))
+        })?;
+    Ok(execution_control_response(result))
+}
+
+/// 暂停、恢复和排空的响应，执行不存在或状态不允许该操作时返回409
+fn execution_control_response(result: Result<(), String>) -> HttpResponse {
+    match result {
+        Ok(()) => HttpResponse::Ok().json(ApiResponse::<()>::success(())),
+        Err(e) => HttpResponse::Conflict().json(ApiResponse::<()>::error(&e)),
+    }
+}
+
 /// 获取任务状态
 pub async fn get_job_status(
     _dag_manager: web::Data<Addr<DAGManagerActor>>,
@@ -148,44 +280,251 @@ pub async fn get_job_status(
     Ok(HttpResponse::NotImplemented().json(ApiResponse::<()>::error("该接口尚未实现")))
 }
 
-/// 全局ETL配置
-static CONFIG: RwLock<Option<ETLConfig>> = RwLock::new(None);
+/// 管道定义的版本历史
+static CONFIG_VERSIONS: RwLock<PipelineVersions> = RwLock::new(PipelineVersions::new());
 
-/// 上传配置
+lazy_static::lazy_static! {
+    /// 串行化部署，保证部署时使用的版本号与部署成功后保存的版本号一致
+    static ref DEPLOY_LOCK: tokio::sync::Mutex<()> = tokio::sync::Mutex::new(());
+}
+
+/// 从存储恢复管道定义的版本历史，返回当前版本
+pub(crate) fn restore_config_versions(versions: Vec<ConfigVersion>) -> Option<ConfigVersion> {
+    let mut config_versions = CONFIG_VERSIONS.write().unwrap();
+    *config_versions = PipelineVersions::from_versions(versions);
+    config_versions.current().cloned()
+}
+
+/// 将新版本保存到存储中
+fn persist_version(store: &Option<web::Data<Arc<ExecutionStore>>>, version: u32) -> Result<(), String> {
+    let store = match store {
+        Some(store) => store,
+        None => return Ok(()),
+    };
+    let version = CONFIG_VERSIONS.read().unwrap().get(version).cloned();
+    match version {
+        Some(version) => store.save_version(&version),
+        None => Ok(()),
+    }
+}
+
+/// 上传配置响应
+#[derive(Serialize)]
+pub struct UploadConfigResponse {
+    pub version: u32,
+}
+
+/// 按Content-Type解析请求中的YAML或JSON管道定义
+fn parse_config_body(req: &HttpRequest, body: &[u8]) -> Result<ETLConfig, String> {
+    let content = std::str::from_utf8(body)
+        .map_err(|e| format!("配置不是有效的UTF-8文本: {}", e))?;
+    let content_type = req.headers().get(header::CONTENT_TYPE).and_then(|v| v.to_str().ok());
+    ETLConfig::parse(content, ConfigFormat::from_content_type(content_type))
+        .map_err(|e| format!("配置验证失败: {}", e))
+}
+
+/// 上传配置，部署成功后保存为新版本
+///
+/// 请求体为JSON，或Content-Type为`application/yaml`时为YAML
 pub async fn upload_config(
-    config: web::Json<ETLConfig>,
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+    store: Option<web::Data<Arc<ExecutionStore>>>,
+    req: HttpRequest,
+    body: web::Bytes,
 ) -> Result<HttpResponse, Error> {
-    info!("上传ETL配置：{}", config.name);
-    
-    // 验证配置
-    match config.validate() {
-        Ok(_) => {
-            // 保存配置
-            let mut config_writer = CONFIG.write().unwrap();
-            *config_writer = Some(config.into_inner());
-            
-            Ok(HttpResponse::Ok().json(ApiResponse::<()>::success(())))
-        },
+    // 解析并验证配置
+    let config = match parse_config_body(&req, &body) {
+        Ok(config) => config,
         Err(e) => {
-            error!("配置验证失败: {}", e);
-            Ok(HttpResponse::BadRequest().json(ApiResponse::<()>::error(&format!("配置验证失败: {}", e))))
+            error!("{}", e);
+            return Ok(HttpResponse::BadRequest().json(ApiResponse::<()>::error(&e)));
         }
+    };
+    info!("上传ETL配置：{}", config.name);
+    
+    let _deploying = DEPLOY_LOCK.lock().await;
+    let version = CONFIG_VERSIONS.read().unwrap().next_version();
+    if let Err(response) = deploy_config(&dag_manager, config.clone(), version).await? {
+        return Ok(response);
+    }
+    
+    CONFIG_VERSIONS.write().unwrap().record(config);
+    if let Err(e) = persist_version(&store, version) {
+        error!("保存配置版本{}失败: {}", version, e);
+        return Ok(HttpResponse::InternalServerError().json(ApiResponse::<()>::error(&e)));
+    }
+    
+    Ok(HttpResponse::Ok().json(ApiResponse::success(UploadConfigResponse { version })))
+}
+
+/// 验证配置响应
+#[derive(Serialize)]
+pub struct ValidateConfigResponse {
+    pub name: String,
+    /// 作业的执行顺序（按依赖关系排序）
+    pub execution_order: Vec<String>,
+    /// 可用的转换器类型
+    pub transformer_types: Vec<String>,
+}
+
+/// 验证配置但不部署
+pub async fn validate_config(
+    req: HttpRequest,
+    body: web::Bytes,
+) -> Result<HttpResponse, Error> {
+    let config = match parse_config_body(&req, &body) {
+        Ok(config) => config,
+        Err(e) => return Ok(HttpResponse::BadRequest().json(ApiResponse::<()>::error(&e))),
+    };
+    
+    match config.get_topological_order() {
+        Ok(execution_order) => Ok(HttpResponse::Ok().json(ApiResponse::success(ValidateConfigResponse {
+            name: config.name,
+            execution_order,
+            transformer_types: crate::transformers::factory::transformer_types(),
+        }))),
+        Err(e) => Ok(HttpResponse::BadRequest().json(ApiResponse::<()>::error(&format!("配置验证失败: {}", e)))),
     }
 }
 
+/// 获取配置查询参数
+#[derive(Deserialize)]
+pub struct ConfigQuery {
+    /// 版本号，未指定时返回当前版本
+    pub version: Option<u32>,
+}
+
 /// 获取配置
-pub async fn get_config() -> Result<HttpResponse, Error> {
+pub async fn get_config(
+    query: web::Query<ConfigQuery>,
+) -> Result<HttpResponse, Error> {
     info!("获取ETL配置");
     
-    let config_reader = CONFIG.read().unwrap();
+    let versions = CONFIG_VERSIONS.read().unwrap();
+    let version = match query.version {
+        Some(version) => versions.get(version),
+        None => versions.current(),
+    };
     
-    match &*config_reader {
-        Some(config) => Ok(HttpResponse::Ok().json(ApiResponse::success(config))),
+    match version {
+        Some(version) => Ok(HttpResponse::Ok().json(ApiResponse::success(&version.config))),
         None => Ok(HttpResponse::NotFound().json(ApiResponse::<()>::error("未找到配置")))
     }
 }
 
+/// 列出配置的所有版本
+pub async fn list_config_versions() -> Result<HttpResponse, Error> {
+    let versions = CONFIG_VERSIONS.read().unwrap().list();
+    Ok(HttpResponse::Ok().json(ApiResponse::success(versions)))
+}
+
+/// 回滚请求
+#[derive(Deserialize, Default)]
+pub struct RollbackRequest {
+    /// 回滚后立即使用该版本启动执行
+    #[serde(default)]
+    pub run: bool,
+    /// 可选：执行ID，如果不提供则自动生成
+    pub execution_id: Option<String>,
+}
+
+/// 回滚响应
+#[derive(Serialize)]
+pub struct RollbackResponse {
+    pub version: u32,
+    pub rollback_of: u32,
+    pub execution_id: Option<String>,
+}
+
+/// 部署旧版本，成功后将其恢复为新的当前版本，可选立即重新执行
+pub async fn rollback_config(
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+    store: Option<web::Data<Arc<ExecutionStore>>>,
+    path: web::Path<u32>,
+    req: Option<web::Json<RollbackRequest>>,
+) -> Result<HttpResponse, Error> {
+    let target = path.into_inner();
+    let req = req.map(|r| r.into_inner()).unwrap_or_default();
+    
+    info!("回滚ETL配置到版本：{}", target);
+    
+    let _deploying = DEPLOY_LOCK.lock().await;
+    let (version, config) = {
+        let versions = CONFIG_VERSIONS.read().unwrap();
+        match versions.get(target) {
+            Some(restored) => (versions.next_version(), restored.config.clone()),
+            None => return Ok(HttpResponse::NotFound().json(ApiResponse::<()>::error(&format!("找不到管道版本: {}", target)))),
+        }
+    };
+    
+    if let Err(response) = deploy_config(&dag_manager, config, version).await? {
+        return Ok(response);
+    }
+    
+    if let Err(e) = CONFIG_VERSIONS.write().unwrap().rollback(target) {
+        return Ok(HttpResponse::NotFound().json(ApiResponse::<()>::error(&e)));
+    }
+    if let Err(e) = persist_version(&store, version) {
+        error!("保存配置版本{}失败: {}", version, e);
+        return Ok(HttpResponse::InternalServerError().json(ApiResponse::<()>::error(&e)));
+    }
+    
+    let execution_id = if req.run {
+        let execution_id = req.execution_id.unwrap_or_else(|| Uuid::new_v4().to_string());
+        let result = dag_manager.send(StartDAGExecution { execution_id: execution_id.clone() }).await
+            .map_err(|e| {
+                error!("发送StartDAGExecution消息失败: {}", e);
+                ErrorInternalServerError(format!("发送StartDAGExecution消息失败: {}", e))
+            })?;
+        if let Err(e) = result {
+            error!("启动DAG执行失败: {}", e);
+            return Ok(HttpResponse::InternalServerError().json(ApiResponse::<()>::error(&e)));
+        }
+        Some(execution_id)
+    } else {
+        None
+    };
+    
+    Ok(HttpResponse::Ok().json(ApiResponse::success(RollbackResponse {
+        version,
+        rollback_of: target,
+        execution_id,
+    })))
+}
+
+/// 将配置部署到DAG管理器，失败时（通常是有未结束的执行）返回冲突响应
+async fn deploy_config(
+    dag_manager: &web::Data<Addr<DAGManagerActor>>,
+    config: ETLConfig,
+    version: u32,
+) -> Result<Result<(), HttpResponse>, Error> {
+    let result = dag_manager.send(SetConfig { config, version }).await
+        .map_err(|e| {
+            error!("发送SetConfig消息失败: {}", e);
+            ErrorInternalServerError(format!("发送SetConfig消息失败: {}", e))
+        })?;
+    
+    Ok(result.map_err(|e| {
+        error!("部署配置版本{}失败: {}", version, e);
+        HttpResponse::Conflict().json(ApiResponse::<()>::error(&format!("部署配置失败: {}", e)))
+    }))
+}
+
 /// 健康检查
 pub async fn health_check() -> Result<HttpResponse, Error> {
     Ok(HttpResponse::Ok().json(ApiResponse::success("ETL服务正常运行")))
-} 
\ No newline at end of file
+} 
+/// 以Prometheus文本格式导出各作业的运行指标
+pub async fn metrics(
+    dag_manager: web::Data<Addr<DAGManagerActor>>,
+) -> Result<HttpResponse, Error> {
+    let body = dag_manager.send(RenderMetrics).await
+        .map_err(|e| {
+            error!("发送RenderMetrics消息失败: {}", e);
+            ErrorInternalServerError(format!("发送RenderMetrics消息失败: {}", e))
+        })?;
+    
+    Ok(HttpResponse::Ok()
+        .content_type("text/plain; version=0.0.4")
+        .body(body))
+}
@@ -7,10 +7,18 @@ use log::info;
 use std::sync::Arc;
 
 use crate::actors::dag_manager::DAGManagerActor;
+use crate::store::ExecutionStore;
+use crate::versions::ConfigVersion;
+
+/// 从存储恢复管道定义的版本历史，返回当前版本
+pub fn restore_config_versions(store: &ExecutionStore) -> Result<Option<ConfigVersion>, String> {
+    Ok(handlers::restore_config_versions(store.load_versions()?))
+}
 
 /// 启动API服务器
 pub async fn start_api_server(
     dag_manager: Addr<DAGManagerActor>,
+    store: Option<Arc<ExecutionStore>>,
     host: &str, 
     port: u16
 ) -> std::io::Result<()> {
@@ -20,6 +28,11 @@ pub async fn start_api_server(
     HttpServer::new(move || {
         App::new()
             .app_data(Data::new(dag_manager.clone()))
+            .configure(|cfg| {
+                if let Some(store) = &store {
+                    cfg.app_data(Data::new(store.clone()));
+                }
+            })
             .configure(routes::configure)
     })
     .bind((host, port))?
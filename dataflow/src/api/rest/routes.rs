@@ -10,8 +10,13 @@ pub fn configure(cfg: &mut ServiceConfig) {
                 web::scope("/execution")
                     .route("", web::post().to(handlers::start_execution))
                     .route("/{id}", web::get().to(handlers::get_execution_status))
+                    .route("/{id}/logs", web::get().to(handlers::get_execution_logs))
+                    .route("/{id}/nodes", web::get().to(handlers::get_execution_nodes))
                     .route("", web::get().to(handlers::list_executions))
                     .route("/{id}", web::delete().to(handlers::cancel_execution))
+                    .route("/{id}/pause", web::post().to(handlers::pause_execution))
+                    .route("/{id}/resume", web::post().to(handlers::resume_execution))
+                    .route("/{id}/drain", web::post().to(handlers::drain_execution))
             )
             // 任务相关
             .service(
@@ -23,8 +28,13 @@ pub fn configure(cfg: &mut ServiceConfig) {
                 web::scope("/config")
                     .route("", web::post().to(handlers::upload_config))
                     .route("", web::get().to(handlers::get_config))
+                    .route("/validate", web::post().to(handlers::validate_config))
+                    .route("/versions", web::get().to(handlers::list_config_versions))
+                    .route("/rollback/{version}", web::post().to(handlers::rollback_config))
             )
             // 健康检查
             .route("/health", web::get().to(handlers::health_check))
+            // Prometheus指标
+            .route("/metrics", web::get().to(handlers::metrics))
     );
 } 
\ No newline at end of file
@@ -0,0 +1,73 @@
+//! 加载成功后提交的提取和转换状态
+//!
+//! 提取器的检查点、文件归档和转换器的已见键如果在读取时立即保存，加载失败后
+//! 重新运行管道会跳过这些记录。管道运行时把运行标识（`run_id`选项）传给
+//! 提取器和转换器，它们通过`defer`登记需要保存的状态，管道在数据加载成功后
+//! 调用`commit`执行这些操作，失败时调用`discard`丢弃。
+
+use std::collections::HashMap;
+use std::sync::Mutex;
+use log::{debug, error};
+use serde_json::Value;
+
+/// 管道传给提取器和转换器的运行标识选项
+pub const RUN_ID_OPTION: &str = "run_id";
+
+/// 加载成功后执行的操作
+type CommitAction = Box<dyn FnOnce() -> Result<(), String> + Send>;
+
+lazy_static::lazy_static! {
+    /// 按运行标识登记的待提交操作
+    static ref PENDING: Mutex<HashMap<String, Vec<(String, CommitAction)>>> = Mutex::new(HashMap::new());
+}
+
+/// 选项中的运行标识，不在管道中运行时为None
+pub fn run_id(options: &HashMap<String, Value>) -> Option<String> {
+    options.get(RUN_ID_OPTION).and_then(|v| v.as_str()).map(|s| s.to_string())
+}
+
+/// 登记加载成功后执行的操作，`description`用于日志
+///
+/// 没有运行标识时没有加载阶段可以等待，操作立即执行。
+pub fn defer<F>(run_id: Option<&str>, description: &str, action: F) -> Result<(), String>
+where
+    F: FnOnce() -> Result<(), String> + Send + 'static,
+{
+    match run_id {
+        Some(run_id) => {
+            PENDING.lock().unwrap()
+                .entry(run_id.to_string())
+                .or_default()
+                .push((description.to_string(), Box::new(action)));
+            Ok(())
+        },
+        None => action(),
+    }
+}
+
+/// 加载成功后按登记顺序执行运行的操作，返回失败的操作数
+///
+/// 一个操作失败不影响其他操作，失败原因记录在日志中。
+pub fn commit(run_id: &str) -> usize {
+    let actions = PENDING.lock().unwrap().remove(run_id).unwrap_or_default();
+    let mut failed = 0;
+    for (description, action) in actions {
+        match action() {
+            Ok(()) => debug!("已提交: {}", description),
+            Err(e) => {
+                error!("提交失败: {}: {}", description, e);
+                failed += 1;
+            },
+        }
+    }
+    failed
+}
+
+/// 丢弃运行登记的操作，下次运行会重新读取这些数据
+pub fn discard(run_id: &str) {
+    if let Some(actions) = PENDING.lock().unwrap().remove(run_id) {
+        if !actions.is_empty() {
+            debug!("丢弃{}个未提交的操作: {}", actions.len(), run_id);
+        }
+    }
+}
@@ -25,6 +25,9 @@ pub struct DAGConfig {
     pub execution_order: Vec<String>,
     /// 定义失败策略
     pub on_failure: FailurePolicy,
+    /// 同时运行的作业数上限，未设置时依赖满足的作业全部并行运行
+    #[serde(default)]
+    pub max_parallel: Option<usize>,
 }
 
 /// 失败策略
@@ -38,8 +41,33 @@ pub enum FailurePolicy {
     Retry,
 }
 
+/// 管道定义的文件格式
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ConfigFormat {
+    Yaml,
+    Json,
+}
+
+impl ConfigFormat {
+    /// 按文件扩展名判断格式，`.json`为JSON，其他为YAML
+    pub fn from_path(path: &Path) -> Self {
+        match path.extension().and_then(|e| e.to_str()) {
+            Some(ext) if ext.eq_ignore_ascii_case("json") => ConfigFormat::Json,
+            _ => ConfigFormat::Yaml,
+        }
+    }
+
+    /// 按HTTP的Content-Type判断格式，未指定时为JSON
+    pub fn from_content_type(content_type: Option<&str>) -> Self {
+        match content_type {
+            Some(content_type) if content_type.contains("yaml") || content_type.contains("yml") => ConfigFormat::Yaml,
+            _ => ConfigFormat::Json,
+        }
+    }
+}
+
 impl ETLConfig {
-    /// 从文件加载配置
+    /// 从文件加载配置，按扩展名解析YAML或JSON
     pub fn from_file<P: AsRef<Path>>(path: P) -> Result<Self, ETLError> {
         let path = path.as_ref();
         let mut file = File::open(path)
@@ -49,7 +77,15 @@ impl ETLConfig {
         file.read_to_string(&mut content)
             .map_err(|e| ETLError::ConfigError(format!("读取配置文件失败: {}", e)))?;
         
-        Self::from_yaml(&content)
+        Self::parse(&content, ConfigFormat::from_path(path))
+    }
+    
+    /// 按格式解析配置
+    pub fn parse(content: &str, format: ConfigFormat) -> Result<Self, ETLError> {
+        match format {
+            ConfigFormat::Yaml => Self::from_yaml(content),
+            ConfigFormat::Json => Self::from_json(content),
+        }
     }
     
     /// 从YAML字符串解析配置
@@ -63,6 +99,17 @@ impl ETLConfig {
         Ok(config)
     }
     
+    /// 从JSON字符串解析配置
+    pub fn from_json(content: &str) -> Result<Self, ETLError> {
+        let config: Self = serde_json::from_str(content)
+            .map_err(|e| ETLError::ConfigError(format!("解析JSON配置失败: {}", e)))?;
+            
+        // 验证配置有效性
+        config.validate()?;
+        
+        Ok(config)
+    }
+    
     /// 验证DAG，确保没有循环依赖等问题
     pub fn validate(&self) -> Result<(), ETLError> {
         // 检查任务ID的唯一性
@@ -84,6 +131,22 @@ impl ETLConfig {
             }
         }
         
+        // 检查各作业的组件类型和定时表达式
+        for (job_id, job) in &self.jobs {
+            validate_job(job).map_err(|e| ETLError::ConfigError(format!("任务 {}: {}", job_id, e)))?;
+        }
+        
+        // 读取上游作业输出的作业必须依赖这些作业
+        for (job_id, job) in &self.jobs {
+            for upstream in crate::extractors::upstream::upstream_jobs(&job.pipeline) {
+                if !job.depends_on.contains(&upstream) {
+                    return Err(ETLError::DependencyError(
+                        format!("任务 {} 读取任务 {} 的输出，但没有依赖该任务", job_id, upstream)
+                    ));
+                }
+            }
+        }
+        
         // 检查循环依赖
         if let Err(cycle) = self.detect_cycles() {
             return Err(ETLError::DependencyError(
@@ -202,4 +265,55 @@ impl ETLConfig {
         
         Ok(())
     }
-} 
\ No newline at end of file
+} 
+
+/// 检查作业的数据源、转换器和目标类型都已支持，组件配置和定时表达式有效
+fn validate_job(job: &JobConfig) -> Result<(), String> {
+    let pipeline = &job.pipeline;
+    if !crate::extractors::factory::is_supported(&pipeline.source.type_name) {
+        return Err(format!("未知的数据源类型: {}", pipeline.source.type_name));
+    }
+    validate_options("数据源", &pipeline.source.config)?;
+    
+    for (index, transformer) in pipeline.transformers.iter().enumerate() {
+        if !crate::transformers::factory::is_registered(&transformer.type_name) {
+            return Err(format!(
+                "第{}个转换器的类型未注册: {}（可用类型: {}）",
+                index + 1, transformer.type_name,
+                crate::transformers::factory::transformer_types().join(", ")
+            ));
+        }
+        validate_options(&format!("第{}个转换器", index + 1), &transformer.config)?;
+    }
+    
+    if !crate::loaders::factory::is_supported(&pipeline.sink.type_name) {
+        return Err(format!("未知的目标类型: {}", pipeline.sink.type_name));
+    }
+    validate_options("目标", &pipeline.sink.config)?;
+    
+    if let Some(cron) = &job.cron {
+        validate_cron(cron)?;
+    }
+    Ok(())
+}
+
+/// 组件配置必须是对象（或省略）
+fn validate_options(component: &str, config: &serde_json::Value) -> Result<(), String> {
+    match config {
+        serde_json::Value::Object(_) | serde_json::Value::Null => Ok(()),
+        other => Err(format!("{}的配置必须是对象: {}", component, other)),
+    }
+}
+
+/// 检查定时表达式的格式：5个字段（分 时 日 月 周），或带秒的6个字段
+fn validate_cron(cron: &str) -> Result<(), String> {
+    let fields: Vec<&str> = cron.split_whitespace().collect();
+    if fields.len() != 5 && fields.len() != 6 {
+        return Err(format!("定时表达式应有5或6个字段: {}", cron));
+    }
+    let valid = |c: char| c.is_ascii_alphanumeric() || matches!(c, '*' | '/' | ',' | '-' | '?' | '#');
+    match fields.iter().find(|field| !field.chars().all(valid)) {
+        Some(field) => Err(format!("定时表达式的字段无效: {}", field)),
+        None => Ok(()),
+    }
+}
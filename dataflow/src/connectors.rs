@@ -0,0 +1,198 @@
+//! 外部数据库连接管理
+//!
+//! 按驱动和DSN共享连接池，同一个数据库的多个管道和多次执行复用连接。
+//! PostgreSQL和MySQL通过sqlx连接（需要`sql`特性），SQLite使用rusqlite。
+//! 各数据库的参数占位符和标识符引用方式由[`Driver`]提供。
+
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use log::info;
+
+lazy_static::lazy_static! {
+    static ref POOLS: Mutex<HashMap<String, Arc<ConnectionPool>>> = Mutex::new(HashMap::new());
+}
+
+/// 默认的连接池大小
+const DEFAULT_MAX_CONNECTIONS: u32 = 5;
+
+/// 数据库驱动
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Driver {
+    Postgres,
+    MySql,
+    Sqlite,
+}
+
+impl Driver {
+    /// 按名称解析驱动
+    pub fn from_name(name: &str) -> Result<Self, String> {
+        match name.to_lowercase().as_str() {
+            "postgres" | "postgresql" | "pg" => Ok(Driver::Postgres),
+            "mysql" | "mariadb" => Ok(Driver::MySql),
+            "sqlite" | "sqlite3" => Ok(Driver::Sqlite),
+            other => Err(format!("不支持的数据库驱动: {}", other)),
+        }
+    }
+
+    /// 按DSN的协议推断驱动，支持`jdbc:`前缀
+    pub fn from_dsn(dsn: &str) -> Result<Self, String> {
+        let dsn = dsn.strip_prefix("jdbc:").unwrap_or(dsn);
+        match dsn.split_once(':') {
+            Some((scheme, _)) => Self::from_name(scheme),
+            None => Err(format!("无法从DSN推断数据库驱动: {}", redact_dsn(dsn))),
+        }
+    }
+
+    /// 第`index`个（从1开始）参数的占位符
+    pub fn placeholder(&self, index: usize) -> String {
+        match self {
+            Driver::Postgres => format!("${}", index),
+            Driver::MySql => "?".to_string(),
+            Driver::Sqlite => format!("?{}", index),
+        }
+    }
+
+    /// 引用标识符，`schema.table`形式的各部分分别引用
+    pub fn quote_identifier(&self, identifier: &str) -> String {
+        identifier.split('.')
+            .map(|part| match self {
+                Driver::MySql => format!("`{}`", part.replace('`', "``")),
+                _ => format!("\"{}\"", part.replace('"', "\"\"")),
+            })
+            .collect::<Vec<_>>()
+            .join(".")
+    }
+
+    /// 插入冲突时更新`columns`的子句，`key_columns`为冲突判断的列
+    pub fn upsert_clause(&self, key_columns: &[String], columns: &[String]) -> String {
+        let updates: Vec<&String> = columns.iter().filter(|c| !key_columns.contains(c)).collect();
+        match self {
+            Driver::MySql => {
+                let assignments: Vec<String> = updates.iter()
+                    .map(|c| format!("{0} = VALUES({0})", self.quote_identifier(c)))
+                    .collect();
+                if assignments.is_empty() {
+                    // MySQL没有DO NOTHING，用无副作用的赋值代替
+                    let key = self.quote_identifier(&key_columns[0]);
+                    format!(" ON DUPLICATE KEY UPDATE {0} = {0}", key)
+                } else {
+                    format!(" ON DUPLICATE KEY UPDATE {}", assignments.join(", "))
+                }
+            },
+            Driver::Postgres | Driver::Sqlite => {
+                let keys: Vec<String> = key_columns.iter().map(|c| self.quote_identifier(c)).collect();
+                if updates.is_empty() {
+                    format!(" ON CONFLICT ({}) DO NOTHING", keys.join(", "))
+                } else {
+                    let assignments: Vec<String> = updates.iter()
+                        .map(|c| format!("{0} = excluded.{0}", self.quote_identifier(c)))
+                        .collect();
+                    format!(" ON CONFLICT ({}) DO UPDATE SET {}", keys.join(", "), assignments.join(", "))
+                }
+            },
+        }
+    }
+
+    /// 用于日志的驱动名称
+    pub fn name(&self) -> &'static str {
+        match self {
+            Driver::Postgres => "PostgreSQL",
+            Driver::MySql => "MySQL",
+            Driver::Sqlite => "SQLite",
+        }
+    }
+}
+
+/// 共享的数据库连接池
+pub enum ConnectionPool {
+    #[cfg(feature = "sql")]
+    Postgres(sqlx::PgPool),
+    #[cfg(feature = "sql")]
+    MySql(sqlx::MySqlPool),
+    /// SQLite写入是串行的，一个连接即可
+    Sqlite(Mutex<rusqlite::Connection>),
+}
+
+impl ConnectionPool {
+    /// 连接池对应的驱动
+    pub fn driver(&self) -> Driver {
+        match self {
+            #[cfg(feature = "sql")]
+            ConnectionPool::Postgres(_) => Driver::Postgres,
+            #[cfg(feature = "sql")]
+            ConnectionPool::MySql(_) => Driver::MySql,
+            ConnectionPool::Sqlite(_) => Driver::Sqlite,
+        }
+    }
+}
+
+/// 获取驱动和DSN对应的连接池，首次使用时创建
+pub async fn pool(driver: Driver, dsn: &str, max_connections: Option<u32>) -> Result<Arc<ConnectionPool>, String> {
+    let dsn = dsn.strip_prefix("jdbc:").unwrap_or(dsn);
+    let key = format!("{:?}:{}", driver, dsn);
+    if let Some(pool) = POOLS.lock().unwrap().get(&key) {
+        return Ok(pool.clone());
+    }
+
+    let max_connections = max_connections.unwrap_or(DEFAULT_MAX_CONNECTIONS);
+    let pool = Arc::new(connect(driver, dsn, max_connections).await?);
+    info!("已连接到{}数据库: {}", driver.name(), redact_dsn(dsn));
+
+    // 并发创建时保留先放入的连接池
+    let mut pools = POOLS.lock().unwrap();
+    Ok(pools.entry(key).or_insert(pool).clone())
+}
+
+/// 关闭所有连接池
+pub fn close_all() {
+    POOLS.lock().unwrap().clear();
+}
+
+async fn connect(driver: Driver, dsn: &str, max_connections: u32) -> Result<ConnectionPool, String> {
+    match driver {
+        #[cfg(feature = "sql")]
+        Driver::Postgres => sqlx::postgres::PgPoolOptions::new()
+            .max_connections(max_connections)
+            .connect(dsn)
+            .await
+            .map(ConnectionPool::Postgres)
+            .map_err(|e| format!("连接PostgreSQL失败: {}", e)),
+        #[cfg(feature = "sql")]
+        Driver::MySql => sqlx::mysql::MySqlPoolOptions::new()
+            .max_connections(max_connections)
+            .connect(dsn)
+            .await
+            .map(ConnectionPool::MySql)
+            .map_err(|e| format!("连接MySQL失败: {}", e)),
+        #[cfg(not(feature = "sql"))]
+        Driver::Postgres | Driver::MySql => {
+            let _ = max_connections;
+            Err(format!("连接{}需要启用sql特性", driver.name()))
+        },
+        Driver::Sqlite => {
+            let path = dsn.strip_prefix("sqlite://")
+                .or_else(|| dsn.strip_prefix("sqlite:"))
+                .unwrap_or(dsn);
+            let conn = rusqlite::Connection::open(path)
+                .map_err(|e| format!("打开SQLite数据库失败: {}", e))?;
+            conn.busy_timeout(std::time::Duration::from_secs(5))
+                .map_err(|e| format!("设置SQLite超时失败: {}", e))?;
+            Ok(ConnectionPool::Sqlite(Mutex::new(conn)))
+        },
+    }
+}
+
+/// 隐藏DSN中的密码，用于日志和错误信息
+pub fn redact_dsn(dsn: &str) -> String {
+    let (scheme, rest) = match dsn.split_once("://") {
+        Some(parts) => parts,
+        None => return dsn.to_string(),
+    };
+    match rest.split_once('@') {
+        Some((credentials, host)) => match credentials.split_once(':') {
+            Some((user, _)) => format!("{}://{}:***@{}", scheme, user, host),
+            None => dsn.to_string(),
+        },
+        None => dsn.to_string(),
+    }
+}
@@ -3,13 +3,18 @@ use std::sync::{Arc, Mutex};
 use uuid::Uuid;
 use chrono::{DateTime, Utc};
 use log::{info, error, debug, warn};
-use crate::types::{JobConfig, JobStatus, DAGExecutionStatus, JobStats, ETLError};
-use crate::config::ETLConfig;
+use crate::types::{JobConfig, JobStatus, DAGExecutionStatus, ExecutionStatus, NodeStatus, JobStats, ETLError};
+use crate::config::{ETLConfig, FailurePolicy};
+use crate::throttle::PressureThrottle;
 
 /// DAG管理器，负责管理作业执行和状态
 pub struct DAGManager {
     config: Option<ETLConfig>,
     executions: HashMap<String, ExecutionStatus>,
+    /// 交互查询承压时收紧的并行度限制
+    throttle: Option<Arc<PressureThrottle>>,
+    /// 排空过程中服务停止时被中断的作业，恢复后重新运行
+    interrupted: HashMap<String, Vec<String>>,
 }
 
 impl DAGManager {
@@ -23,6 +28,8 @@ impl DAGManager {
         Ok(Self {
             config: Some(config),
             executions: HashMap::new(),
+            throttle: None,
+            interrupted: HashMap::new(),
         })
     }
     
@@ -31,9 +38,16 @@ impl DAGManager {
         Self {
             config: None,
             executions: HashMap::new(),
+            throttle: None,
+            interrupted: HashMap::new(),
         }
     }
     
+    /// 设置交互查询承压时的并行度限制，与配置的上限取较小值
+    pub fn set_throttle(&mut self, throttle: Option<Arc<PressureThrottle>>) {
+        self.throttle = throttle;
+    }
+    
     /// 启动一个新的DAG执行
     pub fn start_execution(&mut self, execution_id: &str) -> Result<(), String> {
         // 验证是否有配置
@@ -75,6 +89,31 @@ impl DAGManager {
         Ok(())
     }
     
+    /// 恢复服务停止时未完成的执行
+    ///
+    /// 运行中的作业没有完成记录，恢复为Pending后重新调度，已完成的作业保持不变。
+    /// 排空中的执行只重新运行被中断的作业，它们结束后执行停止，其余作业取消。
+    pub fn restore_execution(&mut self, mut execution: ExecutionStatus) -> Result<(), String> {
+        if self.executions.contains_key(&execution.execution_id) {
+            return Err(format!("执行ID已存在: {}", execution.execution_id));
+        }
+        
+        let mut interrupted = Vec::new();
+        for (job_id, (status, _)) in execution.job_statuses.iter_mut() {
+            if *status == JobStatus::Running {
+                *status = JobStatus::Pending;
+                interrupted.push(job_id.clone());
+            }
+        }
+        if execution.status == "draining" && !interrupted.is_empty() {
+            self.interrupted.insert(execution.execution_id.clone(), interrupted);
+        }
+        
+        info!("已恢复DAG执行: {}", execution.execution_id);
+        self.executions.insert(execution.execution_id.clone(), execution);
+        Ok(())
+    }
+    
     /// 获取准备好执行的作业
     pub fn get_ready_jobs(&mut self, execution_id: &str) -> Vec<String> {
         // 检查执行ID是否存在
@@ -83,6 +122,18 @@ impl DAGManager {
             return Vec::new();
         }
         
+        // 排空中恢复的执行只重新运行被中断的作业
+        if self.executions[execution_id].status == "draining" {
+            let jobs = self.interrupted.remove(execution_id).unwrap_or_default();
+            let execution = self.executions.get_mut(execution_id).unwrap();
+            for job_id in &jobs {
+                if let Some((status, _)) = execution.job_statuses.get_mut(job_id) {
+                    *status = JobStatus::Running;
+                }
+            }
+            return jobs;
+        }
+        
         // 获取当前执行记录
         let execution = self.executions.get(execution_id).unwrap();
         
@@ -100,11 +151,30 @@ impl DAGManager {
             }
         };
         
-        // 检查哪些作业可以执行
+        // 检查哪些作业可以执行，不超过同时运行的作业数上限
+        let running = execution.job_statuses.values()
+            .filter(|(status, _)| *status == JobStatus::Running)
+            .count();
+        let max_parallel = match (config.dag.max_parallel, self.throttle.as_ref().and_then(|t| t.limit())) {
+            (Some(configured), Some(throttled)) => Some(configured.min(throttled)),
+            (configured, throttled) => configured.or(throttled),
+        };
+        let mut capacity = max_parallel
+            .map_or(usize::MAX, |max| max.saturating_sub(running));
         let mut ready_jobs = Vec::new();
         
-        for job in &config.jobs {
-            let job_id = &job.id;
+        // 按执行顺序调度，未列出的作业按ID排序
+        let mut job_ids: Vec<&String> = config.jobs.keys().collect();
+        job_ids.sort_by_key(|id| (
+            config.dag.execution_order.iter().position(|o| o == *id).unwrap_or(usize::MAX),
+            (*id).clone(),
+        ));
+        
+        for job_id in job_ids {
+            if capacity == 0 {
+                break;
+            }
+            let job = &config.jobs[job_id];
             
             // 跳过不是Pending状态的作业
             if let Some((status, _)) = execution.job_statuses.get(job_id) {
@@ -119,8 +189,8 @@ impl DAGManager {
             // 检查依赖是否已完成
             let mut dependencies_met = true;
             
-            if let Some(deps) = config.dag.as_ref().and_then(|dag| dag.dependencies.get(job_id)) {
-                for dep_id in deps {
+            {
+                for dep_id in &job.depends_on {
                     if let Some((status, _)) = execution.job_statuses.get(dep_id) {
                         // 只有依赖作业成功完成，才能继续
                         if *status != JobStatus::Success {
@@ -145,6 +215,7 @@ impl DAGManager {
                 }
                 
                 ready_jobs.push(job_id.clone());
+                capacity -= 1;
             }
         }
         
@@ -197,6 +268,24 @@ impl DAGManager {
         // 获取当前执行记录
         let execution = self.executions.get_mut(execution_id).unwrap();
         
+        // 排空的执行在运行中的作业全部结束后停止，未开始的作业不再运行
+        if execution.status == "draining" {
+            if execution.job_statuses.values().any(|(status, _)| *status == JobStatus::Running) {
+                return Ok(false);
+            }
+            for (status, _) in execution.job_statuses.values_mut() {
+                if *status == JobStatus::Pending {
+                    *status = JobStatus::Cancelled;
+                }
+            }
+            execution.status = "drained".to_string();
+            execution.end_time = Some(Utc::now());
+            
+            info!("DAG执行已排空: {}", execution_id);
+            crate::extractors::upstream::clear_outputs(execution_id, execution.job_statuses.keys());
+            return Ok(true);
+        }
+        
         // 如果执行已经不是运行状态，则直接返回
         if execution.status != "running" {
             return Ok(true);
@@ -227,6 +316,7 @@ impl DAGManager {
             }
             
             info!("DAG执行已完成: {} - 状态: {}", execution_id, execution.status);
+            crate::extractors::upstream::clear_outputs(execution_id, execution.job_statuses.keys());
             return Ok(true);
         }
         
@@ -253,14 +343,15 @@ impl DAGManager {
         // 获取当前执行记录
         let execution = self.executions.get_mut(execution_id).unwrap();
         
-        // 如果执行已经不是运行状态，则直接返回
-        if execution.status != "running" {
+        // 只有未结束的执行可以取消
+        if !is_active(&execution.status) {
             return Err(format!("执行已经是{}状态，无法取消", execution.status));
         }
         
         // 更新执行状态
         execution.status = "cancelled".to_string();
         execution.end_time = Some(Utc::now());
+        self.interrupted.remove(execution_id);
         
         // 将所有Pending或Running状态的作业更新为Cancelled
         for (status, _) in execution.job_statuses.values_mut() {
@@ -269,19 +360,65 @@ impl DAGManager {
             }
         }
         
+        crate::extractors::upstream::clear_outputs(execution_id, execution.job_statuses.keys());
         info!("已取消DAG执行: {}", execution_id);
         Ok(())
     }
     
-    /// 获取作业依赖
-    pub fn get_job_dependencies(&self, job_id: &str) -> Option<Vec<String>> {
-        if let Some(config) = &self.config {
-            if let Some(dag) = &config.dag {
-                return dag.dependencies.get(job_id).cloned();
-            }
+    /// 暂停执行
+    ///
+    /// 不再调度新的作业，正在运行的作业继续完成；已完成作业的状态和输出保留，
+    /// 恢复后从未开始的作业继续。
+    pub fn pause_execution(&mut self, execution_id: &str) -> Result<(), String> {
+        let execution = self.executions.get_mut(execution_id)
+            .ok_or_else(|| format!("执行ID不存在: {}", execution_id))?;
+        
+        if execution.status != "running" {
+            return Err(format!("执行是{}状态，无法暂停", execution.status));
+        }
+        
+        execution.status = "paused".to_string();
+        info!("已暂停DAG执行: {}", execution_id);
+        Ok(())
+    }
+    
+    /// 恢复暂停的执行，之后需要重新调度准备好的作业
+    pub fn resume_execution(&mut self, execution_id: &str) -> Result<(), String> {
+        let execution = self.executions.get_mut(execution_id)
+            .ok_or_else(|| format!("执行ID不存在: {}", execution_id))?;
+        
+        if execution.status != "paused" {
+            return Err(format!("执行是{}状态，无法恢复", execution.status));
+        }
+        
+        execution.status = "running".to_string();
+        info!("已恢复DAG执行: {}", execution_id);
+        Ok(())
+    }
+    
+    /// 排空执行
+    ///
+    /// 不再调度新的作业，等正在运行的作业完成提取和加载后停止执行，状态为
+    /// drained，未开始的作业标记为取消。与取消不同，运行中的作业不会被中断。
+    /// 返回执行是否已经停止。
+    pub fn drain_execution(&mut self, execution_id: &str) -> Result<bool, String> {
+        let execution = self.executions.get_mut(execution_id)
+            .ok_or_else(|| format!("执行ID不存在: {}", execution_id))?;
+        
+        if execution.status != "running" && execution.status != "paused" {
+            return Err(format!("执行是{}状态，无法排空", execution.status));
         }
         
-        None
+        execution.status = "draining".to_string();
+        info!("开始排空DAG执行: {}", execution_id);
+        self.check_dag_completion(execution_id)
+    }
+    
+    /// 获取作业依赖
+    pub fn get_job_dependencies(&self, job_id: &str) -> Option<Vec<String>> {
+        self.config.as_ref()
+            .and_then(|config| config.jobs.get(job_id))
+            .map(|job| job.depends_on.clone())
     }
     
     /// 获取依赖于指定作业的作业列表
@@ -289,18 +426,33 @@ impl DAGManager {
         let mut dependent_jobs = Vec::new();
         
         if let Some(config) = &self.config {
-            if let Some(dag) = &config.dag {
-                for (dependent, dependencies) in &dag.dependencies {
-                    if dependencies.contains(&job_id.to_string()) {
-                        dependent_jobs.push(dependent.clone());
-                    }
+            for (dependent, job) in &config.jobs {
+                if job.depends_on.iter().any(|dep| dep == job_id) {
+                    dependent_jobs.push(dependent.clone());
                 }
             }
         }
         
+        dependent_jobs.sort();
         dependent_jobs
     }
     
+    /// 执行中各作业（DAG节点）的状态、依赖和统计信息
+    pub fn node_statuses(&self, execution_id: &str) -> Option<Vec<NodeStatus>> {
+        let execution = self.executions.get(execution_id)?;
+        let mut nodes: Vec<NodeStatus> = execution.job_statuses.iter()
+            .map(|(job_id, (status, stats))| NodeStatus {
+                job_id: job_id.clone(),
+                status: *status,
+                depends_on: self.get_job_dependencies(job_id).unwrap_or_default(),
+                dependents: self.get_dependent_jobs(job_id),
+                stats: stats.clone(),
+            })
+            .collect();
+        nodes.sort_by(|a, b| a.job_id.cmp(&b.job_id));
+        Some(nodes)
+    }
+    
     /// 处理作业执行结果，根据失败策略调整后续作业状态
     pub fn handle_job_result(&mut self, execution_id: &str, job_id: &str, status: &JobStatus) -> Result<(), String> {
         // 检查执行ID是否存在
@@ -308,32 +460,27 @@ impl DAGManager {
             return Err(format!("执行ID不存在: {}", execution_id));
         }
         
-        // 获取配置
-        let config = match &self.config {
-            Some(cfg) => cfg,
+        // 获取失败策略
+        let stop_all = match &self.config {
+            Some(cfg) => matches!(cfg.dag.on_failure, FailurePolicy::Fail),
             None => return Err("没有可用的DAG配置".to_string()),
         };
         
-        // 根据失败策略处理
-        if *status == JobStatus::Failed {
-            if let Some(dag) = &config.dag {
-                match dag.failure_policy.as_str() {
-                    "fail" => {
-                        // 标记所有依赖的作业为Skipped
-                        self.skip_dependent_jobs(execution_id, job_id)?;
-                    }
-                    "continue" => {
-                        // 继续执行其他没有依赖的作业
-                        debug!("作业失败，但继续执行其他作业: {} - {}", execution_id, job_id);
-                    }
-                    "retry" => {
-                        // 重试逻辑应该在作业Actor中处理
-                        debug!("作业失败，可能会根据配置重试: {} - {}", execution_id, job_id);
-                    }
-                    _ => {
-                        warn!("未知的失败策略: {}", dag.failure_policy);
-                    }
+        // 失败或取消的作业的下游作业无法运行，标记为Skipped；失败策略为fail时
+        // 不再启动其他等待中的作业，已在运行的作业继续完成
+        if *status == JobStatus::Failed || *status == JobStatus::Cancelled {
+            self.skip_dependent_jobs(execution_id, job_id)?;
+            
+            if stop_all {
+                let pending: Vec<String> = self.executions[execution_id].job_statuses.iter()
+                    .filter(|(_, (status, _))| *status == JobStatus::Pending)
+                    .map(|(id, _)| id.clone())
+                    .collect();
+                for pending_id in pending {
+                    self.update_job_status(execution_id, &pending_id, JobStatus::Skipped, None)?;
                 }
+            } else {
+                debug!("作业失败，继续执行不依赖它的作业: {} - {}", execution_id, job_id);
             }
         }
         
@@ -345,8 +492,14 @@ impl DAGManager {
         // 获取所有依赖于该作业的作业
         let dependent_jobs = self.get_dependent_jobs(job_id);
         
-        // 将这些作业标记为Skipped
+        // 将等待中的作业标记为Skipped
         for dep_job_id in dependent_jobs {
+            let pending = self.executions.get(execution_id)
+                .and_then(|e| e.job_statuses.get(&dep_job_id))
+                .map_or(false, |(status, _)| *status == JobStatus::Pending);
+            if !pending {
+                continue;
+            }
             self.update_job_status(execution_id, &dep_job_id, JobStatus::Skipped, None)?;
             
             // 递归处理依赖于这个作业的其他作业
@@ -355,4 +508,147 @@ impl DAGManager {
         
         Ok(())
     }
-} 
\ No newline at end of file
+}
+
+/// 执行是否尚未结束：运行中、暂停或正在排空
+pub fn is_active(status: &str) -> bool {
+    matches!(status, "running" | "paused" | "draining")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    /// extract -> load，report不依赖其他作业
+    fn manager() -> DAGManager {
+        let job = |id: &str, depends_on: &[&str]| json!({
+            "id": id,
+            "name": id,
+            "pipeline": {
+                "source": {"type_name": "memory", "config": {}},
+                "transformers": [],
+                "sink": {"type_name": "memory", "config": {}},
+            },
+            "depends_on": depends_on,
+            "retry": {"count": 0, "delay": 0},
+            "enabled": true,
+        });
+        let config: ETLConfig = serde_json::from_value(json!({
+            "version": "1.0",
+            "name": "test",
+            "jobs": {
+                "extract": job("extract", &[]),
+                "load": job("load", &["extract"]),
+                "report": job("report", &[]),
+            },
+            "dag": {
+                "name": "test",
+                "execution_order": ["extract", "report", "load"],
+                "on_failure": "continue",
+            },
+        })).unwrap();
+        DAGManager::new(config).unwrap()
+    }
+
+    fn job_status(manager: &DAGManager, execution_id: &str, job_id: &str) -> JobStatus {
+        manager.get_execution_status(execution_id).unwrap().job_statuses[job_id].0
+    }
+
+    fn sorted(mut jobs: Vec<String>) -> Vec<String> {
+        jobs.sort();
+        jobs
+    }
+
+    #[test]
+    fn test_pause_and_resume() {
+        let mut manager = manager();
+        manager.start_execution("e").unwrap();
+        assert_eq!(manager.get_ready_jobs("e"), vec!["extract", "report"]);
+
+        // 暂停后不再调度新的作业，运行中的作业继续完成
+        manager.pause_execution("e").unwrap();
+        assert!(manager.pause_execution("e").is_err());
+        manager.update_job_status("e", "extract", JobStatus::Success, None).unwrap();
+        assert!(manager.get_ready_jobs("e").is_empty());
+        assert_eq!(job_status(&manager, "e", "load"), JobStatus::Pending);
+
+        manager.resume_execution("e").unwrap();
+        assert!(manager.resume_execution("e").is_err());
+        assert_eq!(manager.get_ready_jobs("e"), vec!["load"]);
+
+        manager.update_job_status("e", "report", JobStatus::Success, None).unwrap();
+        manager.update_job_status("e", "load", JobStatus::Success, None).unwrap();
+        assert!(manager.check_dag_completion("e").unwrap());
+        assert_eq!(manager.get_execution_status("e").unwrap().status, "success");
+        assert!(manager.pause_execution("e").is_err());
+    }
+
+    #[test]
+    fn test_drain_waits_for_running_jobs() {
+        let mut manager = manager();
+        manager.start_execution("e").unwrap();
+        manager.get_ready_jobs("e");
+        manager.update_job_status("e", "extract", JobStatus::Success, None).unwrap();
+
+        // report仍在运行，排空等它完成；load不再调度
+        assert!(!manager.drain_execution("e").unwrap());
+        assert!(manager.get_ready_jobs("e").is_empty());
+        assert!(!manager.check_dag_completion("e").unwrap());
+
+        manager.update_job_status("e", "report", JobStatus::Success, None).unwrap();
+        assert!(manager.check_dag_completion("e").unwrap());
+        let execution = manager.get_execution_status("e").unwrap();
+        assert_eq!(execution.status, "drained");
+        assert!(execution.end_time.is_some());
+        assert_eq!(job_status(&manager, "e", "load"), JobStatus::Cancelled);
+        assert!(manager.drain_execution("e").is_err());
+        assert!(manager.cancel_execution("e").is_err());
+
+        // 暂停且没有运行中作业的执行立即停止
+        manager.start_execution("paused").unwrap();
+        manager.pause_execution("paused").unwrap();
+        assert!(manager.drain_execution("paused").unwrap());
+        assert_eq!(job_status(&manager, "paused", "extract"), JobStatus::Cancelled);
+    }
+
+    #[test]
+    fn test_restore_draining_execution() {
+        let mut manager = manager();
+        manager.start_execution("e").unwrap();
+        manager.get_ready_jobs("e");
+        assert!(!manager.drain_execution("e").unwrap());
+        let saved = manager.get_execution_status("e").unwrap();
+        assert!(manager.restore_execution(saved.clone()).is_err());
+
+        // 服务重启后只重新运行被中断的作业
+        let mut restored = self::manager();
+        restored.restore_execution(saved).unwrap();
+        assert_eq!(job_status(&restored, "e", "extract"), JobStatus::Pending);
+        assert_eq!(sorted(restored.get_ready_jobs("e")), vec!["extract", "report"]);
+        assert!(restored.get_ready_jobs("e").is_empty());
+        assert_eq!(job_status(&restored, "e", "report"), JobStatus::Running);
+
+        restored.update_job_status("e", "extract", JobStatus::Success, None).unwrap();
+        assert!(!restored.check_dag_completion("e").unwrap());
+        restored.update_job_status("e", "report", JobStatus::Success, None).unwrap();
+        assert!(restored.check_dag_completion("e").unwrap());
+        assert_eq!(restored.get_execution_status("e").unwrap().status, "drained");
+        assert_eq!(job_status(&restored, "e", "load"), JobStatus::Cancelled);
+    }
+
+    #[test]
+    fn test_restore_running_execution() {
+        let mut manager = manager();
+        manager.start_execution("e").unwrap();
+        manager.get_ready_jobs("e");
+        manager.update_job_status("e", "extract", JobStatus::Success, None).unwrap();
+        let saved = manager.get_execution_status("e").unwrap();
+
+        // 运行中的作业重新调度，已完成的作业不再运行，之后按依赖继续
+        let mut restored = self::manager();
+        restored.restore_execution(saved).unwrap();
+        assert_eq!(restored.get_ready_jobs("e"), vec!["report", "load"]);
+        assert_eq!(job_status(&restored, "e", "extract"), JobStatus::Success);
+    }
+}
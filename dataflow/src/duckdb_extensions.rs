@@ -0,0 +1,55 @@
+//! DuckDB扩展的允许列表
+//!
+//! 提取器和向量存储打开的DuckDB连接只能安装和加载允许列表中的扩展，
+//! 并关闭DuckDB对已知扩展的自动安装和自动加载，避免查询时从网络下载未审核的扩展。
+
+use std::collections::HashSet;
+use std::sync::RwLock;
+use duckdb::Connection;
+use log::debug;
+
+/// 未配置时允许的扩展
+pub const DEFAULT_ALLOWLIST: &[&str] = &["httpfs", "parquet", "json", "spatial"];
+
+lazy_static::lazy_static! {
+    static ref ALLOWLIST: RwLock<HashSet<String>> = RwLock::new(
+        DEFAULT_ALLOWLIST.iter().map(|name| name.to_string()).collect()
+    );
+}
+
+/// 替换允许列表，扩展名不区分大小写
+pub fn set_allowlist<I, S>(names: I)
+where
+    I: IntoIterator<Item = S>,
+    S: AsRef<str>,
+{
+    *ALLOWLIST.write().unwrap() = names.into_iter()
+        .map(|name| name.as_ref().trim().to_lowercase())
+        .filter(|name| !name.is_empty())
+        .collect();
+}
+
+/// 扩展是否在允许列表中
+pub fn is_allowed(name: &str) -> bool {
+    ALLOWLIST.read().unwrap().contains(&name.to_lowercase())
+}
+
+/// 关闭已知扩展的自动安装和自动加载，新打开的连接应先调用
+pub fn disable_autoloading(conn: &Connection) -> Result<(), String> {
+    conn.execute_batch("SET autoinstall_known_extensions=false; SET autoload_known_extensions=false;")
+        .map_err(|e| format!("关闭DuckDB扩展自动加载失败: {}", e))
+}
+
+/// 安装并加载允许列表中的扩展，不在列表中的扩展返回错误
+pub fn enable(conn: &Connection, name: &str) -> Result<(), String> {
+    if !is_allowed(name) {
+        return Err(format!("DuckDB扩展{}不在允许列表中", name));
+    }
+    // 允许列表中的名称只包含扩展名，这里再校验一次避免拼接出其他语句
+    if !name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+        return Err(format!("无效的DuckDB扩展名: {}", name));
+    }
+    debug!("加载DuckDB扩展: {}", name);
+    conn.execute_batch(&format!("INSTALL {name}; LOAD {name};", name = name))
+        .map_err(|e| format!("加载DuckDB扩展{}失败: {}", name, e))
+}
@@ -0,0 +1,41 @@
+//! 文件字符集处理
+//!
+//! 导出的文件经常不是干净的UTF-8：Excel导出的UTF-16、带BOM的UTF-8、
+//! 西欧系统的Latin-1、中文系统的GBK等。读取文件时先按BOM识别编码并去掉BOM，
+//! 没有BOM时使用`encoding`选项指定的编码（默认UTF-8），统一转换为UTF-8文本。
+
+use std::collections::HashMap;
+use std::path::Path;
+use encoding_rs::{Encoding, UTF_8};
+use serde_json::Value;
+
+/// 按`encoding`选项读取文本文件，返回去掉BOM的UTF-8文本
+///
+/// `encoding`支持WHATWG编码标签，如`utf-8`、`utf-16le`、`utf-16be`、
+/// `latin1`（按windows-1252解码）、`gbk`、`gb18030`、`big5`、`shift_jis`。
+/// 文件带有BOM时以BOM为准。无法按编码解码的内容会导致读取失败，而不是
+/// 产生乱码记录。
+pub fn read_text(path: &Path, options: &HashMap<String, Value>) -> Result<String, String> {
+    let encoding = encoding_option(options)?;
+    let bytes = std::fs::read(path)
+        .map_err(|e| format!("读取文件失败: {}: {}", path.display(), e))?;
+    decode(&bytes, encoding).map_err(|e| format!("{}: {}", e, path.display()))
+}
+
+/// 解析`encoding`选项，未指定时为UTF-8
+pub fn encoding_option(options: &HashMap<String, Value>) -> Result<&'static Encoding, String> {
+    match options.get("encoding").and_then(|v| v.as_str()) {
+        Some(label) => Encoding::for_label(label.trim().as_bytes())
+            .ok_or_else(|| format!("不支持的字符编码: {}", label)),
+        None => Ok(UTF_8),
+    }
+}
+
+/// 将字节解码为UTF-8文本，BOM优先于指定的编码
+pub fn decode(bytes: &[u8], encoding: &'static Encoding) -> Result<String, String> {
+    let (text, actual, had_errors) = encoding.decode(bytes);
+    if had_errors {
+        return Err(format!("文件内容不是有效的{}编码，请通过encoding指定正确的编码", actual.name()));
+    }
+    Ok(text.into_owned())
+}
@@ -1,13 +1,16 @@
 use actix::prelude::*;
 use std::collections::HashMap;
-use std::fs::File;
+use std::io::Cursor;
 use std::path::Path;
 use chrono::Utc;
-use log::{info, error, debug};
+use log::{info, warn, debug};
 use csv::ReaderBuilder;
+use serde_json::Value;
 
 use crate::types::{DataRecord};
 use crate::actors::messages::ExtractData;
+use crate::extractors::charset;
+use crate::extractors::csv_schema::CsvSchema;
 
 /// CSV文件提取器
 pub struct CsvExtractor {
@@ -21,7 +24,7 @@ impl CsvExtractor {
     }
     
     /// 从CSV文件读取数据
-    fn read_csv_file(&self, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+    pub(crate) fn read_csv_file(&self, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
         // 获取文件路径
         let file_path = match options.get("file_path") {
             Some(serde_json::Value::String(path)) => path,
@@ -45,15 +48,15 @@ impl CsvExtractor {
             _ => true,  // 默认有表头
         };
         
-        // 打开CSV文件
-        let file = File::open(file_path)
-            .map_err(|e| format!("无法打开CSV文件: {}", e))?;
+        // 按文件编码读取，去掉BOM后统一为UTF-8
+        let content = charset::read_text(Path::new(file_path), options)
+            .map_err(|e| format!("无法读取CSV文件: {}", e))?;
         
         // 创建CSV读取器
         let mut reader = ReaderBuilder::new()
             .delimiter(delimiter as u8)
             .has_headers(has_header)
-            .from_reader(file);
+            .from_reader(Cursor::new(content));
         
         let headers = if has_header {
             reader.headers()
@@ -70,25 +73,79 @@ impl CsvExtractor {
                 .collect::<Vec<String>>()
         };
         
+        // 列类型定义，未定义时所有字段作为字符串读取
+        let schema = CsvSchema::from_options(options)?;
+        
+        // 严格模式下被拒绝的行写入error_path，未指定时拒绝任一行即提取失败
+        let mut error_writer = match options.get("error_path").and_then(|v| v.as_str()) {
+            Some(path) if schema.as_ref().map_or(false, |s| s.strict) => {
+                let mut writer = csv::WriterBuilder::new()
+                    .delimiter(delimiter as u8)
+                    .from_path(path)
+                    .map_err(|e| format!("无法创建错误文件: {}", e))?;
+                let mut header = headers.clone();
+                header.push("_error".to_string());
+                writer.write_record(&header)
+                    .map_err(|e| format!("写入错误文件失败: {}", e))?;
+                Some(writer)
+            },
+            _ => None,
+        };
+        
         // 读取所有记录
+        let source = format!("csv:{}", Path::new(file_path).file_name().unwrap_or_default().to_string_lossy());
         let mut records = Vec::new();
+        let mut rejected = 0;
         
-        for result in reader.records() {
+        for (index, result) in reader.records().enumerate() {
             let record = result.map_err(|e| format!("读取CSV记录失败: {}", e))?;
             
-            let mut data_record = DataRecord::new();
-            data_record.source = format!("csv:{}", Path::new(file_path).file_name().unwrap_or_default().to_string_lossy());
-            data_record.timestamp = Utc::now();
+            // 将CSV记录转换为DataRecord，按列类型转换字段
+            let mut data = HashMap::with_capacity(headers.len());
+            let mut errors = Vec::new();
+            for (field_name, field) in headers.iter().zip(record.iter()) {
+                let value = match &schema {
+                    Some(schema) => schema.coerce(field_name, field).unwrap_or_else(|e| {
+                        errors.push(e);
+                        Value::Null
+                    }),
+                    None => Value::String(field.to_string()),
+                };
+                data.insert(field_name.clone(), value);
+            }
             
-            // 将CSV记录转换为DataRecord
-            for (i, field) in record.iter().enumerate() {
-                if i < headers.len() {
-                    let field_name = &headers[i];
-                    data_record.set_field(field_name.clone(), field.to_string());
+            let mut metadata = HashMap::new();
+            if !errors.is_empty() {
+                if schema.as_ref().map_or(false, |s| s.strict) {
+                    rejected += 1;
+                    match &mut error_writer {
+                        Some(writer) => {
+                            let mut row: Vec<String> = record.iter().map(|f| f.to_string()).collect();
+                            row.push(errors.join("; "));
+                            writer.write_record(&row)
+                                .map_err(|e| format!("写入错误文件失败: {}", e))?;
+                        },
+                        None => return Err(format!("第{}条记录无法解析: {}", index + 1, errors.join("; "))),
+                    }
+                    continue;
                 }
+                // 宽松模式下无法解析的字段为空值，错误记录在元数据中
+                metadata.insert("coercion_errors".to_string(), Value::from(errors));
             }
             
-            records.push(data_record);
+            records.push(DataRecord {
+                data,
+                metadata,
+                source: source.clone(),
+                timestamp: Utc::now(),
+            });
+        }
+        
+        if let Some(mut writer) = error_writer {
+            writer.flush().map_err(|e| format!("写入错误文件失败: {}", e))?;
+        }
+        if rejected > 0 {
+            warn!("{}条记录无法解析，已写入错误文件", rejected);
         }
         
         info!("从CSV文件读取了{}条记录: {}", records.len(), file_path);
@@ -0,0 +1,275 @@
+//! CSV列类型定义和按列的类型转换
+//!
+//! 未定义列类型时CSV字段都作为字符串读取。通过`schema`为列指定类型后，
+//! 每个单元格按该列的类型、日期格式、小数分隔符等解析，同一列的值类型一致。
+
+use std::collections::HashMap;
+use chrono::{DateTime, NaiveDate, NaiveDateTime, TimeZone, Utc};
+use serde::Deserialize;
+use serde_json::Value;
+
+/// 默认视为空值的单元格内容
+const DEFAULT_NULL_VALUES: &[&str] = &["", "NULL", "null", "NA", "N/A"];
+
+/// 列类型
+#[derive(Debug, Clone, Copy, PartialEq, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ColumnType {
+    String,
+    Integer,
+    Float,
+    /// 保留精度的小数，输出为规范化的字符串
+    Decimal,
+    Boolean,
+    /// 输出为`YYYY-MM-DD`
+    Date,
+    /// 输出为RFC3339，没有时区的时间视为UTC
+    Datetime,
+}
+
+/// 单列的类型定义
+#[derive(Debug, Clone, Deserialize)]
+pub struct ColumnSchema {
+    #[serde(rename = "type")]
+    pub column_type: ColumnType,
+    /// 日期或时间格式（chrono格式，如`%d.%m.%Y`）
+    #[serde(default)]
+    pub format: Option<String>,
+    /// 小数分隔符，默认使用全局设置
+    #[serde(default)]
+    pub decimal_separator: Option<char>,
+    /// 千位分隔符，解析前去掉
+    #[serde(default)]
+    pub thousands_separator: Option<char>,
+    /// 是否允许空值
+    #[serde(default = "default_nullable")]
+    pub nullable: bool,
+}
+
+fn default_nullable() -> bool {
+    true
+}
+
+/// CSV读取的列类型定义
+#[derive(Debug, Clone)]
+pub struct CsvSchema {
+    columns: HashMap<String, ColumnSchema>,
+    null_values: Vec<String>,
+    decimal_separator: char,
+    /// 严格模式下无法解析的行被拒绝，宽松模式下无法解析的单元格置为空值
+    pub strict: bool,
+}
+
+impl CsvSchema {
+    /// 从提取器选项读取列类型定义，未指定`schema`时返回None
+    ///
+    /// 支持的选项：`schema`（列名到类型定义的映射）、`null_values`、
+    /// `decimal_separator`（默认`.`）和`mode`（strict或lenient，默认lenient）。
+    pub fn from_options(options: &HashMap<String, Value>) -> Result<Option<Self>, String> {
+        let columns: HashMap<String, ColumnSchema> = match options.get("schema") {
+            Some(schema) => serde_json::from_value(schema.clone())
+                .map_err(|e| format!("无效的列类型定义: {}", e))?,
+            None => return Ok(None),
+        };
+
+        let null_values = match options.get("null_values") {
+            Some(Value::Array(values)) => values.iter()
+                .filter_map(|v| v.as_str().map(|s| s.to_string()))
+                .collect(),
+            _ => DEFAULT_NULL_VALUES.iter().map(|s| s.to_string()).collect(),
+        };
+
+        let decimal_separator = match options.get("decimal_separator").and_then(|v| v.as_str()) {
+            Some(separator) if separator.chars().count() == 1 => separator.chars().next().unwrap(),
+            Some(separator) => return Err(format!("小数分隔符必须是单个字符: {}", separator)),
+            None => '.',
+        };
+
+        let strict = match options.get("mode").and_then(|v| v.as_str()).unwrap_or("lenient") {
+            "strict" => true,
+            "lenient" => false,
+            other => return Err(format!("不支持的解析模式: {}", other)),
+        };
+
+        Ok(Some(Self { columns, null_values, decimal_separator, strict }))
+    }
+
+    /// 按列类型转换单元格，未定义类型的列保留为字符串
+    pub fn coerce(&self, column: &str, raw: &str) -> Result<Value, String> {
+        let schema = match self.columns.get(column) {
+            Some(schema) => schema,
+            None => return Ok(Value::String(raw.to_string())),
+        };
+
+        let trimmed = raw.trim();
+        if self.null_values.iter().any(|n| n == trimmed) {
+            if !schema.nullable {
+                return Err(format!("列{}不允许空值", column));
+            }
+            return Ok(Value::Null);
+        }
+
+        let decimal_separator = schema.decimal_separator.unwrap_or(self.decimal_separator);
+        let invalid = |kind: &str| format!("列{}的值无法解析为{}: {}", column, kind, raw);
+
+        match schema.column_type {
+            ColumnType::String => Ok(Value::String(raw.to_string())),
+            ColumnType::Integer => {
+                let number = normalize_number(trimmed, decimal_separator, schema.thousands_separator);
+                number.parse::<i64>()
+                    .map(Value::from)
+                    .map_err(|_| invalid("整数"))
+            },
+            ColumnType::Float => {
+                let number = normalize_number(trimmed, decimal_separator, schema.thousands_separator);
+                number.parse::<f64>().ok()
+                    .and_then(serde_json::Number::from_f64)
+                    .map(Value::Number)
+                    .ok_or_else(|| invalid("浮点数"))
+            },
+            ColumnType::Decimal => {
+                let number = normalize_number(trimmed, decimal_separator, schema.thousands_separator);
+                if is_decimal(&number) {
+                    Ok(Value::String(number))
+                } else {
+                    Err(invalid("小数"))
+                }
+            },
+            ColumnType::Boolean => match trimmed.to_lowercase().as_str() {
+                "true" | "t" | "yes" | "y" | "1" => Ok(Value::Bool(true)),
+                "false" | "f" | "no" | "n" | "0" => Ok(Value::Bool(false)),
+                _ => Err(invalid("布尔值")),
+            },
+            ColumnType::Date => {
+                let format = schema.format.as_deref().unwrap_or("%Y-%m-%d");
+                NaiveDate::parse_from_str(trimmed, format)
+                    .map(|date| Value::String(date.format("%Y-%m-%d").to_string()))
+                    .map_err(|_| invalid(&format!("日期（{}）", format)))
+            },
+            ColumnType::Datetime => parse_datetime(trimmed, schema.format.as_deref())
+                .map(|time| Value::String(time.to_rfc3339()))
+                .ok_or_else(|| invalid("时间")),
+        }
+    }
+}
+
+/// 去掉千位分隔符并把小数分隔符统一为`.`
+fn normalize_number(raw: &str, decimal_separator: char, thousands_separator: Option<char>) -> String {
+    raw.chars()
+        .filter(|c| Some(*c) != thousands_separator && !c.is_whitespace())
+        .map(|c| if c == decimal_separator { '.' } else { c })
+        .collect()
+}
+
+/// 是否为`-123.45`形式的小数
+fn is_decimal(value: &str) -> bool {
+    let digits = value.strip_prefix('-').or_else(|| value.strip_prefix('+')).unwrap_or(value);
+    let mut parts = digits.splitn(2, '.');
+    let integer = parts.next().unwrap_or("");
+    let fraction = parts.next().unwrap_or("");
+    !(integer.is_empty() && fraction.is_empty())
+        && integer.chars().all(|c| c.is_ascii_digit())
+        && fraction.chars().all(|c| c.is_ascii_digit())
+}
+
+/// 按格式解析时间，未指定格式时接受RFC3339和`YYYY-MM-DD HH:MM:SS`
+fn parse_datetime(value: &str, format: Option<&str>) -> Option<DateTime<Utc>> {
+    match format {
+        Some(format) => DateTime::parse_from_str(value, format)
+            .map(|t| t.with_timezone(&Utc))
+            .ok()
+            .or_else(|| NaiveDateTime::parse_from_str(value, format).ok().map(|t| Utc.from_utc_datetime(&t))),
+        None => DateTime::parse_from_rfc3339(value)
+            .map(|t| t.with_timezone(&Utc))
+            .ok()
+            .or_else(|| NaiveDateTime::parse_from_str(value, "%Y-%m-%d %H:%M:%S").ok().map(|t| Utc.from_utc_datetime(&t))),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn schema(options: Value) -> CsvSchema {
+        let options: HashMap<String, Value> = serde_json::from_value(options).unwrap();
+        CsvSchema::from_options(&options).unwrap().unwrap()
+    }
+
+    #[test]
+    fn test_from_options() {
+        assert!(CsvSchema::from_options(&HashMap::new()).unwrap().is_none());
+        assert!(!schema(json!({"schema": {}})).strict);
+        assert!(schema(json!({"schema": {}, "mode": "strict"})).strict);
+
+        let invalid = |options: Value| {
+            let options: HashMap<String, Value> = serde_json::from_value(options).unwrap();
+            CsvSchema::from_options(&options).is_err()
+        };
+        assert!(invalid(json!({"schema": {}, "mode": "loose"})));
+        assert!(invalid(json!({"schema": {}, "decimal_separator": ",."})));
+        assert!(invalid(json!({"schema": {"id": {"type": "uuid"}}})));
+    }
+
+    #[test]
+    fn test_coerce_numbers() {
+        let schema = schema(json!({
+            "decimal_separator": ",",
+            "schema": {
+                "id": {"type": "integer"},
+                "price": {"type": "float", "thousands_separator": "."},
+                "amount": {"type": "decimal", "decimal_separator": "."},
+            }
+        }));
+        assert_eq!(schema.coerce("id", " 42 ").unwrap(), json!(42));
+        assert!(schema.coerce("id", "4,2").is_err());
+        assert_eq!(schema.coerce("price", "1.234,5").unwrap(), json!(1234.5));
+        assert!(schema.coerce("price", "abc").is_err());
+        assert_eq!(schema.coerce("amount", "-0012.340").unwrap(), json!("-0012.340"));
+        assert!(schema.coerce("amount", "1e5").is_err());
+        assert!(schema.coerce("amount", ".").is_err());
+
+        // 未定义类型的列保留为原样的字符串
+        assert_eq!(schema.coerce("name", " 42 ").unwrap(), json!(" 42 "));
+    }
+
+    #[test]
+    fn test_coerce_booleans_and_nulls() {
+        let schema = schema(json!({
+            "null_values": ["-"],
+            "schema": {
+                "active": {"type": "boolean"},
+                "required": {"type": "integer", "nullable": false},
+            }
+        }));
+        assert_eq!(schema.coerce("active", "Yes").unwrap(), json!(true));
+        assert_eq!(schema.coerce("active", "0").unwrap(), json!(false));
+        assert!(schema.coerce("active", "maybe").is_err());
+        assert_eq!(schema.coerce("active", " - ").unwrap(), Value::Null);
+        assert!(schema.coerce("required", "-").is_err());
+
+        // 指定null_values后默认的空值不再视为空值
+        assert!(schema.coerce("active", "NULL").is_err());
+    }
+
+    #[test]
+    fn test_coerce_dates() {
+        let schema = schema(json!({
+            "schema": {
+                "day": {"type": "date", "format": "%d.%m.%Y"},
+                "iso_day": {"type": "date"},
+                "at": {"type": "datetime"},
+                "local_at": {"type": "datetime", "format": "%d/%m/%Y %H:%M"},
+            }
+        }));
+        assert_eq!(schema.coerce("day", "31.12.2023").unwrap(), json!("2023-12-31"));
+        assert!(schema.coerce("day", "2023-12-31").is_err());
+        assert_eq!(schema.coerce("iso_day", "2023-02-01").unwrap(), json!("2023-02-01"));
+        assert!(schema.coerce("iso_day", "2023-02-30").is_err());
+
+        assert_eq!(schema.coerce("at", "2023-01-02T03:04:05+02:00").unwrap(), json!("2023-01-02T01:04:05+00:00"));
+        assert_eq!(schema.coerce("at", "2023-01-02 03:04:05").unwrap(), json!("2023-01-02T03:04:05+00:00"));
+        assert_eq!(schema.coerce("local_at", "02/01/2023 03:04").unwrap(), json!("2023-01-02T03:04:00+00:00"));
+        assert!(schema.coerce("at", "yesterday").is_err());
+    }
+}
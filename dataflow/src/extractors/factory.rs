@@ -6,6 +6,14 @@ use crate::types::ETLError;
 use crate::extractors::csv::CsvExtractor;
 use crate::extractors::memory::MemoryExtractor;
 use crate::extractors::jdbc::JdbcExtractor;
+use crate::extractors::http::HttpExtractor;
+use crate::extractors::sqlite::SqliteExtractor;
+use crate::extractors::watch::WatchExtractor;
+use crate::extractors::upstream::UpstreamExtractor;
+#[cfg(feature = "duckdb")]
+use crate::extractors::parquet::ParquetExtractor;
+#[cfg(feature = "duckdb")]
+use crate::extractors::s3::S3Extractor;
 
 /// 创建提取器
 pub fn create_extractor(extractor_type: &str, options: HashMap<String, serde_json::Value>) -> Result<Addr<dyn Actor>, ETLError> {
@@ -25,10 +33,52 @@ pub fn create_extractor(extractor_type: &str, options: HashMap<String, serde_jso
             let extractor = JdbcExtractor::new(options);
             Ok(extractor.start())
         },
+        "sqlite" => {
+            // 创建SQLite提取器
+            let extractor = SqliteExtractor::new(options);
+            Ok(extractor.start())
+        },
+        "upstream" => {
+            // 创建上游作业输出提取器
+            let extractor = UpstreamExtractor::new(options);
+            Ok(extractor.start())
+        },
+        "watch" => {
+            // 创建目录监听提取器
+            let extractor = WatchExtractor::new(options);
+            Ok(extractor.start())
+        },
+        "http" => {
+            // 创建HTTP API提取器
+            let extractor = HttpExtractor::new(options);
+            Ok(extractor.start())
+        },
+        #[cfg(feature = "duckdb")]
+        "parquet" => {
+            // 创建Parquet提取器
+            let extractor = ParquetExtractor::new(options);
+            Ok(extractor.start())
+        },
+        #[cfg(feature = "duckdb")]
+        "s3" => {
+            // 创建S3提取器
+            let extractor = S3Extractor::new(options);
+            Ok(extractor.start())
+        },
         // 可以添加更多提取器类型
         _ => {
             error!("未知的提取器类型: {}", extractor_type);
             Err(ETLError::ConfigError(format!("未知的提取器类型: {}", extractor_type)))
         }
     }
-} 
\ No newline at end of file
+}
+
+/// 是否支持该类型的提取器
+pub fn is_supported(extractor_type: &str) -> bool {
+    match extractor_type {
+        "csv" | "memory" | "jdbc" | "sqlite" | "upstream" | "watch" | "http" => true,
+        #[cfg(feature = "duckdb")]
+        "parquet" | "s3" => true,
+        _ => false,
+    }
+}
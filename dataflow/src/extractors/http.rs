@@ -0,0 +1,343 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use std::time::{Duration, Instant};
+use chrono::Utc;
+use log::{info, debug, warn};
+use serde_json::Value;
+
+use crate::retry::{transient, ErrorKind};
+use crate::types::DataRecord;
+use crate::actors::messages::ExtractData;
+
+/// 默认最大重试次数
+const DEFAULT_MAX_RETRIES: u64 = 3;
+/// 默认首次重试等待时间（毫秒）
+const DEFAULT_RETRY_BACKOFF_MS: u64 = 500;
+/// 默认最长重试等待时间（毫秒），也限制服务端Retry-After指定的等待
+const DEFAULT_MAX_BACKOFF_MS: u64 = 60_000;
+/// 默认最多请求的页数
+const DEFAULT_MAX_PAGES: u64 = 1000;
+
+/// 分页方式
+#[derive(Debug, Clone, PartialEq)]
+enum Pagination {
+    /// 只请求一次
+    None,
+    /// 按页码翻页，返回空页时结束
+    Page,
+    /// 按响应中的游标翻页，游标为空时结束
+    Cursor,
+}
+
+/// HTTP/REST API提取器
+///
+/// 从分页的REST API读取记录，支持按页码或游标翻页。`records_path`是
+/// JSONPath表达式（如`$.data[*]`），指定响应中记录所在的位置，未指定时
+/// 响应本身为数组则逐项作为记录，否则整个响应作为一条记录。请求按
+/// `rate_limit_per_sec`限速，网络错误、429和5xx响应按指数退避重试。
+pub struct HttpExtractor {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl HttpExtractor {
+    /// 创建新的HTTP提取器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 逐页请求API并提取记录
+    async fn extract_pages(&self, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        let url = match options.get("url") {
+            Some(Value::String(url)) if !url.is_empty() => url.clone(),
+            _ => return Err("未指定API地址".to_string()),
+        };
+
+        let pagination = match options.get("pagination").and_then(|v| v.as_str()).unwrap_or("none") {
+            "none" => Pagination::None,
+            "page" => Pagination::Page,
+            "cursor" => Pagination::Cursor,
+            other => return Err(format!("不支持的分页方式: {}", other)),
+        };
+        let records_path = options.get("records_path").and_then(|v| v.as_str());
+        let max_pages = options.get("max_pages").and_then(|v| v.as_u64()).unwrap_or(DEFAULT_MAX_PAGES);
+
+        // 两次请求之间的最小间隔
+        let min_interval = options.get("rate_limit_per_sec")
+            .and_then(|v| v.as_f64())
+            .filter(|rate| *rate > 0.0)
+            .map(|rate| Duration::from_secs_f64(1.0 / rate));
+
+        let client = reqwest::Client::builder()
+            .timeout(Duration::from_secs(options.get("timeout_secs").and_then(|v| v.as_u64()).unwrap_or(30)))
+            .build()
+            .map_err(|e| format!("创建HTTP客户端失败: {}", e))?;
+
+        let mut query: Vec<(String, String)> = match options.get("query") {
+            Some(Value::Object(params)) => params.iter()
+                .map(|(k, v)| (k.clone(), json_to_param(v)))
+                .collect(),
+            _ => Vec::new(),
+        };
+        if let Some(size) = options.get("page_size") {
+            let size_param = options.get("page_size_param").and_then(|v| v.as_str()).unwrap_or("page_size");
+            query.push((size_param.to_string(), json_to_param(size)));
+        }
+
+        let page_param = options.get("page_param").and_then(|v| v.as_str()).unwrap_or("page");
+        let cursor_param = options.get("cursor_param").and_then(|v| v.as_str()).unwrap_or("cursor");
+        let cursor_path = options.get("cursor_path").and_then(|v| v.as_str());
+        if pagination == Pagination::Cursor && cursor_path.is_none() {
+            return Err("游标分页需要指定cursor_path".to_string());
+        }
+
+        let mut page = options.get("start_page").and_then(|v| v.as_u64()).unwrap_or(1);
+        let mut cursor: Option<String> = None;
+        let mut last_request: Option<Instant> = None;
+        let mut records = Vec::new();
+
+        for page_index in 0..max_pages {
+            // 限速
+            if let (Some(interval), Some(last)) = (min_interval, last_request) {
+                let elapsed = last.elapsed();
+                if elapsed < interval {
+                    tokio::time::sleep(interval - elapsed).await;
+                }
+            }
+            last_request = Some(Instant::now());
+
+            let mut params = query.clone();
+            match pagination {
+                Pagination::Page => params.push((page_param.to_string(), page.to_string())),
+                Pagination::Cursor => {
+                    if let Some(cursor) = &cursor {
+                        params.push((cursor_param.to_string(), cursor.clone()));
+                    }
+                },
+                Pagination::None => {},
+            }
+
+            let body = self.fetch(&client, &url, &params, options).await?;
+            let items: Vec<Value> = match records_path {
+                Some(path) => select_path(&body, path)?.into_iter().cloned().collect(),
+                None => match &body {
+                    Value::Array(items) => items.clone(),
+                    other => vec![other.clone()],
+                },
+            };
+            debug!("第{}页返回{}条记录: {}", page_index + 1, items.len(), url);
+
+            let item_count = items.len();
+            for item in items {
+                records.push(to_record(item, &url, page_index + 1));
+            }
+
+            match pagination {
+                Pagination::None => break,
+                Pagination::Page => {
+                    if item_count == 0 {
+                        break;
+                    }
+                    page += 1;
+                },
+                Pagination::Cursor => {
+                    let next = select_path(&body, cursor_path.unwrap_or("$"))?
+                        .into_iter()
+                        .next()
+                        .map(json_to_param)
+                        .filter(|c| !c.is_empty());
+                    match next {
+                        Some(next) if Some(&next) != cursor.as_ref() => cursor = Some(next),
+                        _ => break,
+                    }
+                },
+            }
+
+            if page_index + 1 == max_pages {
+                warn!("已达到最大页数{}，停止翻页: {}", max_pages, url);
+            }
+        }
+
+        info!("从API读取了{}条记录: {}", records.len(), url);
+        Ok(records)
+    }
+
+    /// 请求一页数据，失败时按指数退避重试
+    async fn fetch(
+        &self,
+        client: &reqwest::Client,
+        url: &str,
+        params: &[(String, String)],
+        options: &HashMap<String, serde_json::Value>,
+    ) -> Result<Value, String> {
+        let max_retries = options.get("max_retries").and_then(|v| v.as_u64()).unwrap_or(DEFAULT_MAX_RETRIES);
+        let max_backoff = Duration::from_millis(
+            options.get("max_backoff_ms").and_then(|v| v.as_u64()).unwrap_or(DEFAULT_MAX_BACKOFF_MS)
+        );
+        let mut backoff = Duration::from_millis(
+            options.get("retry_backoff_ms").and_then(|v| v.as_u64()).unwrap_or(DEFAULT_RETRY_BACKOFF_MS)
+        ).min(max_backoff);
+
+        let mut attempt = 0;
+        loop {
+            let mut request = client.get(url).query(params);
+            if let Some(Value::Object(headers)) = options.get("headers") {
+                for (name, value) in headers {
+                    request = request.header(name.as_str(), json_to_param(value));
+                }
+            }
+            if let Some(token) = options.get("bearer_token").and_then(|v| v.as_str()) {
+                request = request.bearer_auth(token);
+            }
+
+            let (error, retry_after) = match request.send().await {
+                Ok(response) if response.status().is_success() => {
+                    return response.json::<Value>().await
+                        .map_err(|e| format!("解析API响应失败: {}", e));
+                },
+                Ok(response) => {
+                    let status = response.status();
+                    if !(status.is_server_error() || status == reqwest::StatusCode::TOO_MANY_REQUESTS) {
+                        return Err(format!("API请求失败: {} {}", status, url));
+                    }
+                    // 服务端通过Retry-After指定等待秒数时按其等待
+                    let retry_after = response.headers()
+                        .get(reqwest::header::RETRY_AFTER)
+                        .and_then(|v| v.to_str().ok())
+                        .and_then(|v| v.parse::<u64>().ok())
+                        .map(Duration::from_secs);
+                    (transient(ErrorKind::from_status(status.as_u16()), format!("API请求失败: {} {}", status, url)), retry_after)
+                },
+                Err(e) => (transient(ErrorKind::from_reqwest(&e), format!("API请求失败: {}", e)), None),
+            };
+
+            if attempt >= max_retries {
+                return Err(error);
+            }
+            let wait = retry_after.map_or(backoff, |wait| wait.min(max_backoff));
+            warn!("{}，{}毫秒后第{}次重试", error, wait.as_millis(), attempt + 1);
+            tokio::time::sleep(wait).await;
+            backoff = backoff.saturating_mul(2).min(max_backoff);
+            attempt += 1;
+        }
+    }
+}
+
+/// 按JSONPath选取值
+///
+/// 支持`$`、`.field`、`['field']`、`[n]`和`[*]`（或`.*`），足以定位常见
+/// API响应中的记录数组和游标。
+pub(crate) fn select_path<'a>(value: &'a Value, path: &str) -> Result<Vec<&'a Value>, String> {
+    let rest = path.trim().strip_prefix('$').ok_or_else(|| format!("JSONPath必须以$开头: {}", path))?;
+    let mut current = vec![value];
+    let mut chars = rest.chars().peekable();
+
+    while let Some(c) = chars.next() {
+        let step = match c {
+            '.' => {
+                let mut name = String::new();
+                while let Some(&next) = chars.peek() {
+                    if next == '.' || next == '[' {
+                        break;
+                    }
+                    name.push(next);
+                    chars.next();
+                }
+                if name.is_empty() {
+                    return Err(format!("无效的JSONPath: {}", path));
+                }
+                name
+            },
+            '[' => {
+                let mut inner = String::new();
+                loop {
+                    match chars.next() {
+                        Some(']') => break,
+                        Some(next) => inner.push(next),
+                        None => return Err(format!("无效的JSONPath: {}", path)),
+                    }
+                }
+                inner.trim().to_string()
+            },
+            _ => return Err(format!("无效的JSONPath: {}", path)),
+        };
+
+        let mut next = Vec::new();
+        for node in current {
+            if step == "*" {
+                match node {
+                    Value::Array(items) => next.extend(items.iter()),
+                    Value::Object(fields) => next.extend(fields.values()),
+                    _ => {},
+                }
+            } else if let Ok(index) = step.parse::<usize>() {
+                if let Some(item) = node.as_array().and_then(|items| items.get(index)) {
+                    next.push(item);
+                }
+            } else {
+                let key = step.trim_matches(|c| c == '\'' || c == '"');
+                if let Some(field) = node.get(key) {
+                    next.push(field);
+                }
+            }
+        }
+        current = next;
+    }
+
+    Ok(current)
+}
+
+/// 将JSON值转换为请求参数
+fn json_to_param(value: &Value) -> String {
+    match value {
+        Value::String(s) => s.clone(),
+        Value::Null => String::new(),
+        other => other.to_string(),
+    }
+}
+
+/// 将API返回的一项转换为记录，非对象值放在`value`字段中
+fn to_record(item: Value, url: &str, page: u64) -> DataRecord {
+    let data = match item {
+        Value::Object(fields) => fields.into_iter().collect(),
+        other => {
+            let mut data = HashMap::new();
+            data.insert("value".to_string(), other);
+            data
+        },
+    };
+
+    let mut metadata = HashMap::new();
+    metadata.insert("page".to_string(), Value::from(page));
+
+    DataRecord {
+        data,
+        metadata,
+        source: url.to_string(),
+        timestamp: Utc::now(),
+    }
+}
+
+impl Actor for HttpExtractor {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("HTTP提取器已启动");
+    }
+}
+
+impl Handler<ExtractData> for HttpExtractor {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: ExtractData, _: &mut Context<Self>) -> Self::Result {
+        // 合并默认配置和提供的选项
+        let mut options = self.config.clone();
+        for (k, v) in msg.options {
+            options.insert(k, v);
+        }
+
+        Box::pin(async move {
+            let extractor = HttpExtractor::new(options.clone());
+            extractor.extract_pages(&options).await
+        })
+    }
+}
@@ -1,9 +1,27 @@
+pub mod charset;
 pub mod csv;
+pub mod csv_schema;
 pub mod memory;
 pub mod jdbc;
+pub mod http;
+pub mod sqlite;
+pub mod watch;
+pub mod upstream;
+#[cfg(feature = "duckdb")]
+pub mod parquet;
+#[cfg(feature = "duckdb")]
+pub mod s3;
 pub mod factory;
 
 pub use factory::create_extractor;
 pub use csv::CsvExtractor;
 pub use memory::MemoryExtractor;
-pub use jdbc::JdbcExtractor; 
\ No newline at end of file
+pub use jdbc::JdbcExtractor;
+pub use http::HttpExtractor;
+pub use sqlite::SqliteExtractor;
+pub use watch::WatchExtractor;
+pub use upstream::UpstreamExtractor;
+#[cfg(feature = "duckdb")]
+pub use parquet::ParquetExtractor;
+#[cfg(feature = "duckdb")]
+pub use s3::S3Extractor; 
\ No newline at end of file
@@ -0,0 +1,202 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use std::path::Path;
+use chrono::Utc;
+use log::{info, debug};
+use duckdb::Connection;
+use duckdb::types::Value as DuckValue;
+
+use crate::types::DataRecord;
+use crate::actors::messages::ExtractData;
+
+/// Parquet列信息
+#[derive(Debug, Clone)]
+pub struct ParquetColumn {
+    /// 列名
+    pub name: String,
+    /// DuckDB推断出的列类型
+    pub column_type: String,
+}
+
+/// Parquet文件提取器
+///
+/// 借助DuckDB的`read_parquet`读取文件，`file_path`可以是通配符以读取多个
+/// 文件。指定`columns`时只读取这些列，列裁剪会下推到Parquet读取，未选中
+/// 的列不会被解码。
+pub struct ParquetExtractor {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl ParquetExtractor {
+    /// 创建新的Parquet提取器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 从Parquet文件读取数据
+    pub(crate) fn read_parquet_file(&self, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        // 获取文件路径
+        let file_path = match options.get("file_path") {
+            Some(serde_json::Value::String(path)) => path,
+            _ => return Err("未指定Parquet文件路径".to_string()),
+        };
+
+        // 需要读取的列，未指定时读取全部列
+        let projection: Option<Vec<String>> = match options.get("columns") {
+            Some(serde_json::Value::Array(columns)) => {
+                let names: Vec<String> = columns.iter()
+                    .filter_map(|c| c.as_str().map(|s| s.to_string()))
+                    .collect();
+                if names.is_empty() { None } else { Some(names) }
+            },
+            _ => None,
+        };
+
+        // 最多读取的行数
+        let limit = options.get("limit").and_then(|v| v.as_u64());
+
+        let conn = Connection::open_in_memory()
+            .map_err(|e| format!("无法创建DuckDB连接: {}", e))?;
+
+        let relation = format!("read_parquet({})", quote_literal(file_path));
+        let source = format!("parquet:{}", Path::new(file_path).file_name().unwrap_or_default().to_string_lossy());
+        let records = read_relation(&conn, &relation, projection.as_deref(), limit, &source)?;
+
+        info!("从Parquet文件读取了{}条记录: {}", records.len(), file_path);
+        Ok(records)
+    }
+}
+
+/// 推断Parquet文件的列名和类型
+pub fn parquet_schema(conn: &Connection, file_path: &str) -> Result<Vec<ParquetColumn>, String> {
+    describe_relation(conn, &format!("read_parquet({})", quote_literal(file_path)))
+}
+
+/// 推断DuckDB关系（表函数或表）的列名和类型
+pub(crate) fn describe_relation(conn: &Connection, relation: &str) -> Result<Vec<ParquetColumn>, String> {
+    let sql = format!("DESCRIBE SELECT * FROM {}", relation);
+    let mut stmt = conn.prepare(&sql)
+        .map_err(|e| format!("读取文件结构失败: {}", e))?;
+    let columns = stmt
+        .query_map([], |row| {
+            Ok(ParquetColumn {
+                name: row.get(0)?,
+                column_type: row.get(1)?,
+            })
+        })
+        .map_err(|e| format!("读取文件结构失败: {}", e))?
+        .collect::<Result<Vec<_>, _>>()
+        .map_err(|e| format!("读取文件结构失败: {}", e))?;
+    Ok(columns)
+}
+
+/// 读取DuckDB关系中的记录，`projection`指定的列下推到读取
+pub(crate) fn read_relation(
+    conn: &Connection,
+    relation: &str,
+    projection: Option<&[String]>,
+    limit: Option<u64>,
+    source: &str,
+) -> Result<Vec<DataRecord>, String> {
+    let schema = describe_relation(conn, relation)?;
+    let columns: Vec<ParquetColumn> = match projection {
+        Some(names) => names.iter()
+            .map(|name| {
+                schema.iter()
+                    .find(|c| &c.name == name)
+                    .cloned()
+                    .ok_or_else(|| format!("文件中不存在列: {}", name))
+            })
+            .collect::<Result<_, _>>()?,
+        None => schema,
+    };
+
+    let select_list: Vec<String> = columns.iter().map(select_expr).collect();
+    let mut sql = format!("SELECT {} FROM {}", select_list.join(", "), relation);
+    if let Some(limit) = limit {
+        sql.push_str(&format!(" LIMIT {}", limit));
+    }
+    debug!("读取文件: {}", sql);
+
+    let mut stmt = conn.prepare(&sql)
+        .map_err(|e| format!("读取文件失败: {}", e))?;
+    let mut rows = stmt.query([])
+        .map_err(|e| format!("读取文件失败: {}", e))?;
+
+    let mut records = Vec::new();
+    while let Some(row) = rows.next().map_err(|e| format!("读取记录失败: {}", e))? {
+        let mut data = HashMap::with_capacity(columns.len());
+        for (i, column) in columns.iter().enumerate() {
+            let value: DuckValue = row.get(i)
+                .map_err(|e| format!("读取列 {} 失败: {}", column.name, e))?;
+            data.insert(column.name.clone(), to_json_value(value));
+        }
+
+        records.push(DataRecord {
+            data,
+            metadata: HashMap::new(),
+            source: source.to_string(),
+            timestamp: Utc::now(),
+        });
+    }
+    Ok(records)
+}
+
+/// 列的查询表达式，无法直接映射为JSON的类型（时间、小数、嵌套类型等）转为文本
+fn select_expr(column: &ParquetColumn) -> String {
+    let ident = format!("\"{}\"", column.name.replace('"', "\"\""));
+    match column.column_type.as_str() {
+        "BOOLEAN" | "TINYINT" | "SMALLINT" | "INTEGER" | "BIGINT"
+        | "UTINYINT" | "USMALLINT" | "UINTEGER" | "FLOAT" | "DOUBLE" | "VARCHAR" => ident,
+        _ => format!("CAST({} AS VARCHAR) AS {}", ident, ident),
+    }
+}
+
+/// SQL字符串字面量
+pub(crate) fn quote_literal(value: &str) -> String {
+    format!("'{}'", value.replace('\'', "''"))
+}
+
+/// 将DuckDB值转换为JSON值
+fn to_json_value(value: DuckValue) -> serde_json::Value {
+    match value {
+        DuckValue::Null => serde_json::Value::Null,
+        DuckValue::Boolean(b) => serde_json::Value::Bool(b),
+        DuckValue::TinyInt(i) => i.into(),
+        DuckValue::SmallInt(i) => i.into(),
+        DuckValue::Int(i) => i.into(),
+        DuckValue::BigInt(i) => i.into(),
+        DuckValue::UTinyInt(i) => i.into(),
+        DuckValue::USmallInt(i) => i.into(),
+        DuckValue::UInt(i) => i.into(),
+        DuckValue::Float(f) => serde_json::Number::from_f64(f as f64).map_or(serde_json::Value::Null, serde_json::Value::Number),
+        DuckValue::Double(f) => serde_json::Number::from_f64(f).map_or(serde_json::Value::Null, serde_json::Value::Number),
+        DuckValue::Text(s) => serde_json::Value::String(s),
+        other => serde_json::Value::String(format!("{:?}", other)),
+    }
+}
+
+impl Actor for ParquetExtractor {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("Parquet提取器已启动");
+    }
+}
+
+impl Handler<ExtractData> for ParquetExtractor {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: ExtractData, _: &mut Context<Self>) -> Self::Result {
+        // 合并默认配置和提供的选项
+        let mut options = self.config.clone();
+        for (k, v) in msg.options {
+            options.insert(k, v);
+        }
+
+        Box::pin(async move {
+            let extractor = ParquetExtractor::new(options.clone());
+            extractor.read_parquet_file(&options)
+        })
+    }
+}
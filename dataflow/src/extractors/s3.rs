@@ -0,0 +1,150 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use log::{info, debug};
+use duckdb::Connection;
+
+use crate::duckdb_extensions;
+use crate::types::DataRecord;
+use crate::actors::messages::ExtractData;
+use crate::extractors::parquet::{quote_literal, read_relation};
+
+/// S3对象提取器
+///
+/// 借助DuckDB的httpfs扩展列出`s3://bucket/prefix*`下的对象并直接流式读取，
+/// 支持CSV、JSON和Parquet，不需要先下载到本地。凭据和区域在管道配置中
+/// 指定，未指定时使用DuckDB的默认凭据链。`endpoint`可指向MinIO等兼容S3
+/// 的对象存储。
+pub struct S3Extractor {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl S3Extractor {
+    /// 创建新的S3提取器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 读取前缀下所有对象中的记录
+    fn read_objects(&self, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        let base_url = s3_url(options)?;
+        let format = s3_format(options)?;
+
+        // 需要读取的列，未指定时读取全部列
+        let projection: Option<Vec<String>> = match options.get("columns") {
+            Some(serde_json::Value::Array(columns)) => {
+                let names: Vec<String> = columns.iter()
+                    .filter_map(|c| c.as_str().map(|s| s.to_string()))
+                    .collect();
+                if names.is_empty() { None } else { Some(names) }
+            },
+            _ => None,
+        };
+
+        // 最多读取的行数
+        let limit = options.get("limit").and_then(|v| v.as_u64());
+
+        let conn = Connection::open_in_memory()
+            .map_err(|e| format!("无法创建DuckDB连接: {}", e))?;
+        configure_s3(&conn, options)?;
+
+        // 列出前缀下的对象
+        let pattern = format!("{}*", base_url);
+        let mut stmt = conn.prepare(&format!("SELECT file FROM glob({})", quote_literal(&pattern)))
+            .map_err(|e| format!("列出S3对象失败: {}", e))?;
+        let objects = stmt
+            .query_map([], |row| row.get::<_, String>(0))
+            .map_err(|e| format!("列出S3对象失败: {}", e))?
+            .collect::<Result<Vec<_>, _>>()
+            .map_err(|e| format!("列出S3对象失败: {}", e))?;
+        if objects.is_empty() {
+            info!("S3前缀下没有对象: {}", pattern);
+            return Ok(Vec::new());
+        }
+        debug!("读取{}个S3对象: {:?}", objects.len(), objects);
+
+        let list: Vec<String> = objects.iter().map(|o| quote_literal(o)).collect();
+        let relation = match format.as_str() {
+            "csv" => format!("read_csv_auto([{}], header = true)", list.join(", ")),
+            "json" => format!("read_json_auto([{}])", list.join(", ")),
+            _ => format!("read_parquet([{}])", list.join(", ")),
+        };
+
+        let records = read_relation(&conn, &relation, projection.as_deref(), limit, &base_url)?;
+        info!("从{}个S3对象读取了{}条记录: {}", objects.len(), records.len(), base_url);
+        Ok(records)
+    }
+}
+
+/// 由`bucket`和`prefix`组成的S3地址
+pub(crate) fn s3_url(options: &HashMap<String, serde_json::Value>) -> Result<String, String> {
+    let bucket = match options.get("bucket") {
+        Some(serde_json::Value::String(bucket)) if !bucket.is_empty() => bucket,
+        _ => return Err("未指定S3存储桶".to_string()),
+    };
+    let prefix = options.get("prefix").and_then(|v| v.as_str()).unwrap_or("");
+    Ok(format!("s3://{}/{}", bucket, prefix.trim_start_matches('/')))
+}
+
+/// 对象格式：csv、json或parquet，默认parquet
+pub(crate) fn s3_format(options: &HashMap<String, serde_json::Value>) -> Result<String, String> {
+    let format = options.get("format")
+        .and_then(|v| v.as_str())
+        .unwrap_or("parquet")
+        .to_lowercase();
+    match format.as_str() {
+        "csv" | "json" | "parquet" => Ok(format),
+        _ => Err(format!("不支持的对象格式: {}", format)),
+    }
+}
+
+/// 按扩展允许列表加载httpfs扩展并设置区域、凭据和端点
+pub(crate) fn configure_s3(conn: &Connection, options: &HashMap<String, serde_json::Value>) -> Result<(), String> {
+    duckdb_extensions::disable_autoloading(conn)?;
+    duckdb_extensions::enable(conn, "httpfs")?;
+
+    let settings = [
+        ("region", "s3_region"),
+        ("access_key_id", "s3_access_key_id"),
+        ("secret_access_key", "s3_secret_access_key"),
+        ("session_token", "s3_session_token"),
+        ("endpoint", "s3_endpoint"),
+        ("url_style", "s3_url_style"),
+    ];
+    for (option, setting) in settings {
+        if let Some(value) = options.get(option).and_then(|v| v.as_str()) {
+            conn.execute_batch(&format!("SET {} = {}", setting, quote_literal(value)))
+                .map_err(|e| format!("设置{}失败: {}", setting, e))?;
+        }
+    }
+    if let Some(use_ssl) = options.get("use_ssl").and_then(|v| v.as_bool()) {
+        conn.execute_batch(&format!("SET s3_use_ssl = {}", use_ssl))
+            .map_err(|e| format!("设置s3_use_ssl失败: {}", e))?;
+    }
+
+    Ok(())
+}
+
+impl Actor for S3Extractor {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("S3提取器已启动");
+    }
+}
+
+impl Handler<ExtractData> for S3Extractor {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: ExtractData, _: &mut Context<Self>) -> Self::Result {
+        // 合并默认配置和提供的选项
+        let mut options = self.config.clone();
+        for (k, v) in msg.options {
+            options.insert(k, v);
+        }
+
+        Box::pin(async move {
+            let extractor = S3Extractor::new(options.clone());
+            extractor.read_objects(&options)
+        })
+    }
+}
@@ -0,0 +1,282 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use std::path::Path;
+use chrono::Utc;
+use log::{info, debug};
+use rusqlite::{Connection, OpenFlags};
+use rusqlite::types::Value as SqlValue;
+use serde_json::Value;
+
+use crate::commit;
+use crate::retry::{transient, ErrorKind};
+use crate::types::DataRecord;
+use crate::actors::messages::ExtractData;
+
+/// 提取模式
+#[derive(Debug, Clone, PartialEq)]
+enum ExtractMode {
+    /// 每次读取全部结果
+    Full,
+    /// 只读取水位列大于上次检查点的行
+    Incremental,
+    /// 读取行历史表中上次检查点之后的变更
+    Changes,
+}
+
+/// SQLite数据库提取器
+///
+/// `mode`为`full`时每次执行完整查询；为`incremental`时按`watermark_column`
+/// （如`updated_at`或自增`id`）只读取上次运行之后新增或修改的行；为
+/// `changes`时读取`table`的行历史表（见lumos-core的行历史功能），每条变更
+/// 带有`_operation`（insert、update、delete）。后两种模式的检查点保存在
+/// `checkpoint_path`指定的文件中，在管道中运行时数据加载成功后才更新。
+/// 指定`limit`时，后两种模式会读完与最后一行水位值相同的行，避免下次从
+/// 检查点之后读取时漏掉同一水位值的其余行。
+pub struct SqliteExtractor {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl SqliteExtractor {
+    /// 创建新的SQLite提取器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 从SQLite数据库读取数据
+    fn extract_rows(&self, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        let db_path = match options.get("db_path") {
+            Some(Value::String(path)) => path,
+            _ => return Err("未指定SQLite数据库路径".to_string()),
+        };
+
+        let mode = match options.get("mode").and_then(|v| v.as_str()).unwrap_or("full") {
+            "full" => ExtractMode::Full,
+            "incremental" => ExtractMode::Incremental,
+            "changes" => ExtractMode::Changes,
+            other => return Err(format!("不支持的提取模式: {}", other)),
+        };
+
+        let table = options.get("table").and_then(|v| v.as_str());
+        let source_query = match (options.get("query").and_then(|v| v.as_str()), table) {
+            (Some(query), _) => query.to_string(),
+            (None, Some(table)) => format!("SELECT * FROM {}", quote(table)),
+            (None, None) => return Err("未指定查询或表名".to_string()),
+        };
+
+        let checkpoint_path = options.get("checkpoint_path").and_then(|v| v.as_str());
+        if mode != ExtractMode::Full && checkpoint_path.is_none() {
+            return Err("增量提取需要指定checkpoint_path".to_string());
+        }
+        let checkpoint = match checkpoint_path {
+            Some(path) => load_checkpoint(path)?,
+            None => None,
+        };
+
+        let conn = Connection::open_with_flags(db_path, OpenFlags::SQLITE_OPEN_READ_ONLY)
+            .map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("无法打开SQLite数据库: {}", e)))?;
+
+        // 查询、绑定的检查点以及用作新检查点的列
+        let (sql, watermark_column) = match mode {
+            ExtractMode::Full => (source_query, None),
+            ExtractMode::Incremental => {
+                let column = match options.get("watermark_column").and_then(|v| v.as_str()) {
+                    Some(column) => column,
+                    None => return Err("增量提取需要指定watermark_column".to_string()),
+                };
+                let filter = if checkpoint.is_some() {
+                    format!(" WHERE {} > ?1", quote(column))
+                } else {
+                    String::new()
+                };
+                let sql = format!("SELECT * FROM ({}){} ORDER BY {}", source_query, filter, quote(column));
+                (sql, Some(column.to_string()))
+            },
+            ExtractMode::Changes => {
+                let table = match table {
+                    Some(table) => table,
+                    None => return Err("变更捕获需要指定table".to_string()),
+                };
+                (changes_sql(table), Some("_changed_at".to_string()))
+            },
+        };
+
+        // 按水位读取时检查点是严格大于，LIMIT截断的同值行下次不会再读到，
+        // 因此读满limit行后继续读取与最后一行水位值相同的行
+        let limit = options.get("limit").and_then(|v| v.as_u64()).map(|l| l as usize);
+        let mut sql = sql;
+        if let (Some(limit), None) = (limit, &watermark_column) {
+            sql.push_str(&format!(" LIMIT {}", limit));
+        }
+        debug!("读取SQLite数据: {}", sql);
+
+        let mut stmt = conn.prepare(&sql)
+            .map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("准备查询失败: {}", e)))?;
+        let columns: Vec<String> = stmt.column_names().iter().map(|c| c.to_string()).collect();
+
+        let bound: Vec<SqlValue> = match (&mode, &checkpoint) {
+            (ExtractMode::Incremental, Some(checkpoint)) => vec![to_sql_value(checkpoint)],
+            (ExtractMode::Changes, Some(checkpoint)) => vec![to_sql_value(checkpoint)],
+            (ExtractMode::Changes, None) => vec![SqlValue::Text(String::new())],
+            _ => Vec::new(),
+        };
+        let mut rows = stmt.query(rusqlite::params_from_iter(bound.iter()))
+            .map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("执行查询失败: {}", e)))?;
+
+        let source = format!("sqlite:{}", Path::new(db_path).file_name().unwrap_or_default().to_string_lossy());
+        let mut records = Vec::new();
+        let mut watermark = checkpoint.clone();
+        while let Some(row) = rows.next().map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("读取记录失败: {}", e)))? {
+            let mut data = HashMap::with_capacity(columns.len());
+            for (i, column) in columns.iter().enumerate() {
+                let value: SqlValue = row.get(i)
+                    .map_err(|e| format!("读取列 {} 失败: {}", column, e))?;
+                data.insert(column.clone(), to_json_value(value));
+            }
+
+            if let Some(column) = &watermark_column {
+                let value = data.get(column).filter(|v| !v.is_null());
+                if limit.map_or(false, |limit| records.len() >= limit) && value != watermark.as_ref() {
+                    break;
+                }
+                if let Some(value) = value {
+                    watermark = Some(value.clone());
+                }
+            }
+
+            let mut metadata = HashMap::new();
+            if mode == ExtractMode::Changes {
+                if let Some(operation) = data.get("_operation") {
+                    metadata.insert("operation".to_string(), operation.clone());
+                }
+            }
+
+            records.push(DataRecord {
+                data,
+                metadata,
+                source: source.clone(),
+                timestamp: Utc::now(),
+            });
+        }
+
+        // 结果按水位列排序，最后一行的值即为新的检查点，加载成功后保存
+        if let (Some(path), Some(watermark)) = (checkpoint_path, watermark) {
+            if Some(&watermark) != checkpoint.as_ref() {
+                let description = format!("更新检查点: {} = {}", path, watermark);
+                let path = path.to_string();
+                commit::defer(commit::run_id(options).as_deref(), &description, move || {
+                    save_checkpoint(&path, &watermark)
+                })?;
+            }
+        }
+
+        info!("从SQLite数据库读取了{}条记录: {}", records.len(), db_path);
+        Ok(records)
+    }
+}
+
+/// 读取行历史表中检查点之后的变更，按变更时间排序
+///
+/// 新版本的`_valid_from`晚于检查点时为insert或update（存在紧接其前的版本时
+/// 为update）；版本在检查点之后关闭且没有后继版本时为delete。
+fn changes_sql(table: &str) -> String {
+    let history = quote(&format!("{}_history", table));
+    format!(
+        "SELECT * FROM (
+            SELECT h.*, CASE WHEN EXISTS (
+                SELECT 1 FROM {h} p WHERE p._row_id = h._row_id AND p._valid_to = h._valid_from
+            ) THEN 'update' ELSE 'insert' END AS _operation, h._valid_from AS _changed_at
+            FROM {h} h WHERE h._valid_from > ?1
+            UNION ALL
+            SELECT h.*, 'delete' AS _operation, h._valid_to AS _changed_at
+            FROM {h} h WHERE h._valid_to > ?1 AND NOT EXISTS (
+                SELECT 1 FROM {h} n WHERE n._row_id = h._row_id AND n._valid_from = h._valid_to
+            )
+        ) ORDER BY _changed_at",
+        h = history
+    )
+}
+
+/// 读取检查点文件，不存在时返回None
+fn load_checkpoint(path: &str) -> Result<Option<Value>, String> {
+    if !Path::new(path).exists() {
+        return Ok(None);
+    }
+    let content = std::fs::read_to_string(path)
+        .map_err(|e| format!("读取检查点失败: {}", e))?;
+    let checkpoint: Value = serde_json::from_str(&content)
+        .map_err(|e| format!("解析检查点失败: {}", e))?;
+    Ok(checkpoint.get("watermark").cloned().filter(|v| !v.is_null()))
+}
+
+/// 写入检查点文件，先写临时文件再重命名，避免中断时留下不完整的检查点
+fn save_checkpoint(path: &str, watermark: &Value) -> Result<(), String> {
+    if let Some(parent) = Path::new(path).parent() {
+        if !parent.as_os_str().is_empty() && !parent.exists() {
+            std::fs::create_dir_all(parent)
+                .map_err(|e| format!("创建目录失败: {}", e))?;
+        }
+    }
+    let content = serde_json::json!({
+        "watermark": watermark,
+        "updated_at": Utc::now().to_rfc3339(),
+    });
+    let temp_path = format!("{}.tmp", path);
+    std::fs::write(&temp_path, content.to_string())
+        .map_err(|e| format!("写入检查点失败: {}", e))?;
+    std::fs::rename(&temp_path, path)
+        .map_err(|e| format!("写入检查点失败: {}", e))
+}
+
+fn quote(identifier: &str) -> String {
+    format!("\"{}\"", identifier.replace('"', "\"\""))
+}
+
+/// 将检查点转换为绑定参数
+fn to_sql_value(value: &Value) -> SqlValue {
+    match value {
+        Value::Null => SqlValue::Null,
+        Value::Bool(b) => SqlValue::Integer(*b as i64),
+        Value::Number(n) => match n.as_i64() {
+            Some(i) => SqlValue::Integer(i),
+            None => SqlValue::Real(n.as_f64().unwrap_or_default()),
+        },
+        Value::String(s) => SqlValue::Text(s.clone()),
+        other => SqlValue::Text(other.to_string()),
+    }
+}
+
+/// 将SQLite值转换为JSON值
+fn to_json_value(value: SqlValue) -> Value {
+    match value {
+        SqlValue::Null => Value::Null,
+        SqlValue::Integer(i) => i.into(),
+        SqlValue::Real(f) => serde_json::Number::from_f64(f).map_or(Value::Null, Value::Number),
+        SqlValue::Text(s) => Value::String(s),
+        SqlValue::Blob(b) => Value::String(format!("<BLOB: {} bytes>", b.len())),
+    }
+}
+
+impl Actor for SqliteExtractor {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("SQLite提取器已启动");
+    }
+}
+
+impl Handler<ExtractData> for SqliteExtractor {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: ExtractData, _: &mut Context<Self>) -> Self::Result {
+        // 合并默认配置和提供的选项
+        let mut options = self.config.clone();
+        for (k, v) in msg.options {
+            options.insert(k, v);
+        }
+
+        Box::pin(async move {
+            let extractor = SqliteExtractor::new(options.clone());
+            extractor.extract_rows(&options)
+        })
+    }
+}
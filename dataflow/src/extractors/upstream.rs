@@ -0,0 +1,114 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use log::{info, debug};
+use serde_json::Value;
+
+use crate::types::{DataRecord, PipelineConfig};
+use crate::actors::messages::ExtractData;
+use crate::extractors::memory::MemoryExtractor;
+
+/// 上游作业输出提取器
+///
+/// 读取同一次执行中上游作业（`job`或`jobs`）写入目标的记录，使作业的输出
+/// 可以作为下游作业的输入。上游作业必须在`depends_on`中声明，输出在执行
+/// 结束后释放。
+pub struct UpstreamExtractor {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl UpstreamExtractor {
+    /// 创建新的上游作业输出提取器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 读取上游作业的输出
+    fn read_outputs(&self, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        let execution_id = match options.get("execution_id") {
+            Some(Value::String(id)) => id,
+            _ => return Err("上游作业输出只能在DAG执行中读取".to_string()),
+        };
+
+        let jobs = job_names(options);
+        if jobs.is_empty() {
+            return Err("未指定上游作业".to_string());
+        }
+
+        let mut records = Vec::new();
+        for job in jobs {
+            let mut output = MemoryExtractor::get_data(&output_key(execution_id, &job))
+                .ok_or_else(|| format!("上游作业没有输出: {}", job))?;
+            for record in &mut output {
+                record.metadata.insert("upstream_job".to_string(), Value::String(job.clone()));
+            }
+            debug!("读取上游作业{}的{}条记录", job, output.len());
+            records.extend(output);
+        }
+
+        info!("从上游作业读取了{}条记录", records.len());
+        Ok(records)
+    }
+}
+
+/// 作业输出在内存存储中的键
+pub fn output_key(execution_id: &str, job_id: &str) -> String {
+    format!("execution:{}:{}", execution_id, job_id)
+}
+
+/// 保存作业写入目标的记录，供下游作业读取
+pub fn publish_output(execution_id: &str, job_id: &str, records: Vec<DataRecord>) {
+    MemoryExtractor::set_data(&output_key(execution_id, job_id), records);
+}
+
+/// 释放执行中各作业的输出
+pub fn clear_outputs<'a>(execution_id: &str, job_ids: impl IntoIterator<Item = &'a String>) {
+    for job_id in job_ids {
+        MemoryExtractor::clear_data(&output_key(execution_id, job_id));
+    }
+}
+
+/// 管道读取的上游作业，管道的数据源不是上游作业输出时为空
+pub fn upstream_jobs(pipeline: &PipelineConfig) -> Vec<String> {
+    if pipeline.source.type_name != "upstream" {
+        return Vec::new();
+    }
+    match &pipeline.source.config {
+        Value::Object(map) => job_names(&map.clone().into_iter().collect()),
+        _ => Vec::new(),
+    }
+}
+
+fn job_names(options: &HashMap<String, serde_json::Value>) -> Vec<String> {
+    match (options.get("jobs"), options.get("job")) {
+        (Some(Value::Array(jobs)), _) => jobs.iter()
+            .filter_map(|j| j.as_str().map(|s| s.to_string()))
+            .collect(),
+        (_, Some(Value::String(job))) => vec![job.clone()],
+        _ => Vec::new(),
+    }
+}
+
+impl Actor for UpstreamExtractor {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("上游作业输出提取器已启动");
+    }
+}
+
+impl Handler<ExtractData> for UpstreamExtractor {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: ExtractData, _: &mut Context<Self>) -> Self::Result {
+        // 合并默认配置和提供的选项
+        let mut options = self.config.clone();
+        for (k, v) in msg.options {
+            options.insert(k, v);
+        }
+
+        Box::pin(async move {
+            let extractor = UpstreamExtractor::new(options.clone());
+            extractor.read_outputs(&options)
+        })
+    }
+}
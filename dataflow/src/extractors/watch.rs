@@ -0,0 +1,306 @@
+use actix::prelude::*;
+use std::collections::{BTreeSet, HashMap};
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, SystemTime};
+use chrono::Utc;
+use log::{info, debug, warn, error};
+use notify::{Event, EventKind, RecommendedWatcher, RecursiveMode, Watcher};
+use serde_json::Value;
+
+use crate::types::DataRecord;
+use crate::actors::messages::ExtractData;
+use crate::commit;
+use crate::extractors::charset;
+use crate::extractors::csv::CsvExtractor;
+#[cfg(feature = "duckdb")]
+use crate::extractors::parquet::ParquetExtractor;
+
+/// 正在写入的文件常用的后缀，这些文件在重命名为最终文件名之前不会被处理
+const PARTIAL_SUFFIXES: &[&str] = &[".tmp", ".part", ".partial", ".crdownload", ".filepart"];
+
+/// 文件最近一次被观察到的大小和修改时间
+type Observed = HashMap<PathBuf, (u64, SystemTime)>;
+
+lazy_static::lazy_static! {
+    /// 按目录保存的尚未写入完成的文件，管道每次运行都会创建新的提取器，
+    /// 观察结果需要在多次运行之间保留
+    static ref OBSERVED: Mutex<HashMap<PathBuf, Observed>> = Mutex::new(HashMap::new());
+}
+
+/// 目录监听提取器
+///
+/// 监听`directory`中新出现的文件，每次提取读取已写入完成的文件，按
+/// `format`（csv、json、parquet，默认根据扩展名判断）解析。解析成功的文件
+/// 在数据加载成功后移动到`archive_dir`，加载失败时留在目录中由下次运行
+/// 重新读取；解析失败的文件移动到`error_dir`并在旁边写入`<文件名>.error`
+/// 说明原因。
+///
+/// 为避免读取到写了一半的文件：隐藏文件和`.tmp`、`.part`等后缀的文件会被
+/// 忽略；最后修改时间早于`settle_ms`毫秒（默认1000），且与之前运行观察到
+/// 的大小和修改时间相同时才会被处理。文件系统事件只用于尽快发现新文件，
+/// 每次提取还会重新扫描目录，因此丢失的事件不会导致文件被遗漏。
+pub struct WatchExtractor {
+    config: HashMap<String, serde_json::Value>,
+    /// 文件系统事件报告的待处理文件
+    pending: Arc<Mutex<BTreeSet<PathBuf>>>,
+    watcher: Option<RecommendedWatcher>,
+}
+
+impl WatchExtractor {
+    /// 创建新的目录监听提取器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self {
+            config,
+            pending: Arc::new(Mutex::new(BTreeSet::new())),
+            watcher: None,
+        }
+    }
+
+    /// 开始监听目录中的文件事件
+    fn watch(&mut self) -> Result<(), String> {
+        let directory = directory(&self.config)?;
+        let pending = self.pending.clone();
+        let mut watcher = notify::recommended_watcher(move |event: notify::Result<Event>| {
+            match event {
+                Ok(event) if matches!(event.kind, EventKind::Create(_) | EventKind::Modify(_)) => {
+                    let mut pending = pending.lock().unwrap();
+                    pending.extend(event.paths);
+                },
+                Ok(_) => {},
+                Err(e) => warn!("监听目录失败: {}", e),
+            }
+        }).map_err(|e| format!("创建目录监听失败: {}", e))?;
+        watcher.watch(&directory, RecursiveMode::NonRecursive)
+            .map_err(|e| format!("监听目录失败: {}: {}", directory.display(), e))?;
+        self.watcher = Some(watcher);
+        info!("开始监听目录: {}", directory.display());
+        Ok(())
+    }
+}
+
+/// 读取目录中已写入完成的文件
+fn extract_files(
+    options: &HashMap<String, serde_json::Value>,
+    pending: &Mutex<BTreeSet<PathBuf>>,
+) -> Result<Vec<DataRecord>, String> {
+    let directory = directory(options)?;
+    let archive_dir = options.get("archive_dir").and_then(|v| v.as_str())
+        .map(PathBuf::from)
+        .unwrap_or_else(|| directory.join("archive"));
+    let error_dir = options.get("error_dir").and_then(|v| v.as_str())
+        .map(PathBuf::from)
+        .unwrap_or_else(|| directory.join("error"));
+    let settle = Duration::from_millis(options.get("settle_ms").and_then(|v| v.as_u64()).unwrap_or(1000));
+    let max_files = options.get("max_files").and_then(|v| v.as_u64()).map(|n| n as usize);
+    let extension = options.get("extension").and_then(|v| v.as_str())
+        .map(|e| e.trim_start_matches('.').to_lowercase());
+
+    // 事件报告的文件和重新扫描发现的文件，统一为绝对路径以便去重
+    let directory = std::fs::canonicalize(&directory)
+        .map_err(|e| format!("读取目录失败: {}: {}", directory.display(), e))?;
+    let mut candidates: BTreeSet<PathBuf> = std::mem::take(&mut *pending.lock().unwrap())
+        .into_iter()
+        .filter_map(|path| std::fs::canonicalize(path).ok())
+        .collect();
+    let entries = std::fs::read_dir(&directory)
+        .map_err(|e| format!("读取目录失败: {}: {}", directory.display(), e))?;
+    for entry in entries.flatten() {
+        candidates.insert(entry.path());
+    }
+
+    let mut all_observed = OBSERVED.lock().unwrap();
+    let observed = all_observed.entry(directory.clone()).or_default();
+    let mut ready = Vec::new();
+    for path in candidates {
+        if path.parent() != Some(directory.as_path()) || !is_candidate(&path, extension.as_deref()) {
+            continue;
+        }
+        let metadata = match std::fs::metadata(&path) {
+            Ok(metadata) if metadata.is_file() => metadata,
+            _ => {
+                observed.remove(&path);
+                continue;
+            },
+        };
+        let size = metadata.len();
+        let modified = metadata.modified().unwrap_or_else(|_| SystemTime::now());
+        let settled = modified.elapsed().map_or(false, |age| age >= settle);
+
+        // 一段时间内没有修改，且与之前观察到的大小相同，才认为写入完成
+        let unchanged = observed.get(&path)
+            .map_or(true, |(last_size, last_modified)| *last_size == size && *last_modified == modified);
+        if settled && unchanged {
+            observed.remove(&path);
+            ready.push(path);
+        } else {
+            observed.insert(path.clone(), (size, modified));
+            debug!("文件尚未写入完成: {}", path.display());
+        }
+    }
+    drop(all_observed);
+
+    if let Some(max_files) = max_files {
+        ready.truncate(max_files);
+    }
+
+    let run_id = commit::run_id(options);
+    let mut records = Vec::new();
+    for path in ready {
+        match parse_file(&path, options) {
+            Ok(file_records) => {
+                info!("从文件读取了{}条记录: {}", file_records.len(), path.display());
+                records.extend(file_records);
+                // 加载成功后再归档，失败时文件留在目录中重新读取
+                let description = format!("归档文件 {}", path.display());
+                let archive_dir = archive_dir.clone();
+                if let Err(e) = commit::defer(run_id.as_deref(), &description, move || {
+                    move_file(&path, &archive_dir).map(|_| ())
+                }) {
+                    error!("归档文件失败: {}", e);
+                }
+            },
+            Err(e) => {
+                error!("解析文件失败: {}: {}", path.display(), e);
+                match move_file(&path, &error_dir) {
+                    Ok(moved) => {
+                        let mut note = moved.into_os_string();
+                        note.push(".error");
+                        if let Err(e) = std::fs::write(&note, &e) {
+                            error!("写入错误说明失败: {}", e);
+                        }
+                    },
+                    Err(e) => error!("移动失败文件失败: {}", e),
+                }
+            },
+        }
+    }
+
+    Ok(records)
+}
+
+/// 按格式解析单个文件
+fn parse_file(path: &Path, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+    let format = match options.get("format").and_then(|v| v.as_str()) {
+        Some(format) if format != "auto" => format.to_lowercase(),
+        _ => path.extension()
+            .map(|e| e.to_string_lossy().to_lowercase())
+            .unwrap_or_default(),
+    };
+
+    let mut file_options = options.clone();
+    file_options.insert("file_path".to_string(), Value::String(path.to_string_lossy().to_string()));
+
+    let mut records = match format.as_str() {
+        "csv" | "tsv" => CsvExtractor::new(file_options.clone()).read_csv_file(&file_options)?,
+        "json" | "jsonl" | "ndjson" => parse_json(path, options)?,
+        #[cfg(feature = "duckdb")]
+        "parquet" => ParquetExtractor::new(file_options.clone()).read_parquet_file(&file_options)?,
+        other => return Err(format!("不支持的文件格式: {}", other)),
+    };
+
+    let file_name = path.file_name().unwrap_or_default().to_string_lossy().to_string();
+    for record in &mut records {
+        record.metadata.insert("file".to_string(), Value::String(file_name.clone()));
+    }
+    Ok(records)
+}
+
+/// 解析JSON数组或每行一个JSON对象的文件
+fn parse_json(path: &Path, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+    let content = charset::read_text(path, options)?;
+
+    let values: Vec<Value> = if content.trim_start().starts_with('[') {
+        serde_json::from_str(&content).map_err(|e| format!("解析JSON失败: {}", e))?
+    } else {
+        content.lines()
+            .enumerate()
+            .filter(|(_, line)| !line.trim().is_empty())
+            .map(|(i, line)| serde_json::from_str(line).map_err(|e| format!("第{}行解析JSON失败: {}", i + 1, e)))
+            .collect::<Result<_, _>>()?
+    };
+
+    let source = format!("watch:{}", path.file_name().unwrap_or_default().to_string_lossy());
+    values.into_iter()
+        .map(|value| match value {
+            Value::Object(map) => Ok(DataRecord {
+                data: map.into_iter().collect(),
+                metadata: HashMap::new(),
+                source: source.clone(),
+                timestamp: Utc::now(),
+            }),
+            other => Err(format!("记录必须是JSON对象: {}", other)),
+        })
+        .collect()
+}
+
+/// 将文件移动到目标目录，返回新路径；目标已存在同名文件时加上时间戳
+fn move_file(path: &Path, target_dir: &Path) -> Result<PathBuf, String> {
+    std::fs::create_dir_all(target_dir)
+        .map_err(|e| format!("创建目录失败: {}: {}", target_dir.display(), e))?;
+    let file_name = path.file_name().unwrap_or_default().to_string_lossy().to_string();
+    let mut target = target_dir.join(&file_name);
+    if target.exists() {
+        target = target_dir.join(format!("{}.{}", Utc::now().format("%Y%m%d%H%M%S%3f"), file_name));
+    }
+
+    // 跨文件系统时无法重命名，改为复制后删除
+    if std::fs::rename(path, &target).is_err() {
+        std::fs::copy(path, &target)
+            .map_err(|e| format!("移动文件失败: {}: {}", path.display(), e))?;
+        std::fs::remove_file(path)
+            .map_err(|e| format!("删除文件失败: {}: {}", path.display(), e))?;
+    }
+    Ok(target)
+}
+
+/// 是否为需要处理的文件（不是隐藏文件或临时文件，扩展名符合要求）
+fn is_candidate(path: &Path, extension: Option<&str>) -> bool {
+    let name = match path.file_name() {
+        Some(name) => name.to_string_lossy().to_lowercase(),
+        None => return false,
+    };
+    if name.starts_with('.') || PARTIAL_SUFFIXES.iter().any(|suffix| name.ends_with(suffix)) {
+        return false;
+    }
+    match extension {
+        Some(extension) => path.extension().map_or(false, |e| e.to_string_lossy().to_lowercase() == extension),
+        None => true,
+    }
+}
+
+fn directory(options: &HashMap<String, serde_json::Value>) -> Result<PathBuf, String> {
+    match options.get("directory") {
+        Some(Value::String(directory)) => Ok(PathBuf::from(directory)),
+        _ => Err("未指定监听目录".to_string()),
+    }
+}
+
+impl Actor for WatchExtractor {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("目录监听提取器已启动");
+        // 监听失败时仍可通过每次提取时的扫描发现文件
+        if let Err(e) = self.watch() {
+            warn!("{}，改为每次提取时扫描目录", e);
+        }
+    }
+}
+
+impl Handler<ExtractData> for WatchExtractor {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: ExtractData, _: &mut Context<Self>) -> Self::Result {
+        // 合并默认配置和提供的选项
+        let mut options = self.config.clone();
+        for (k, v) in msg.options {
+            options.insert(k, v);
+        }
+        let pending = self.pending.clone();
+
+        Box::pin(async move {
+            extract_files(&options, &pending)
+        })
+    }
+}
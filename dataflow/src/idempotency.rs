@@ -0,0 +1,225 @@
+//! 幂等写入
+//!
+//! 目标没有可用于upsert的键时，部分失败后重新运行管道会重复写入已经写入的
+//! 记录。启用幂等写入后，管道为每条记录计算内容哈希，已写入的哈希按目标
+//! 保存在SQLite状态表中，之后的运行跳过这些记录。
+//!
+//! 目标配置中的选项：
+//!
+//! - `idempotent`：是否启用，默认false
+//! - `idempotency_db`：状态数据库路径，默认`sink_state.db`
+//! - `idempotency_key`：目标的标识，写入同一目标的管道应使用相同的标识，
+//!   默认由目标类型和配置计算
+//! - `hash_fields`：参与哈希计算的字段，默认所有字段
+
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::path::Path;
+use std::sync::Mutex;
+use chrono::{SecondsFormat, Utc};
+use rusqlite::{params, Connection};
+use serde_json::Value;
+use sha2::{Digest, Sha256};
+
+use crate::types::DataRecord;
+
+/// 默认的状态数据库路径
+const DEFAULT_STATE_DB: &str = "sink_state.db";
+
+const SCHEMA: &str = "
+    CREATE TABLE IF NOT EXISTS written_records (
+        sink TEXT NOT NULL,
+        hash TEXT NOT NULL,
+        written_at TEXT NOT NULL,
+        PRIMARY KEY (sink, hash)
+    ) WITHOUT ROWID;
+";
+
+/// 目标的幂等写入设置
+#[derive(Debug, Clone)]
+pub struct IdempotencyConfig {
+    /// 状态数据库路径
+    pub state_db: String,
+    /// 目标的标识
+    pub sink_key: String,
+    /// 参与哈希计算的字段，为空时使用所有字段
+    pub hash_fields: Vec<String>,
+}
+
+impl IdempotencyConfig {
+    /// 从目标选项读取幂等写入设置，未启用时返回None
+    pub fn from_options(sink_type: &str, options: &HashMap<String, Value>) -> Option<Self> {
+        if !options.get("idempotent").and_then(|v| v.as_bool()).unwrap_or(false) {
+            return None;
+        }
+
+        let state_db = options.get("idempotency_db").and_then(|v| v.as_str())
+            .unwrap_or(DEFAULT_STATE_DB)
+            .to_string();
+        let sink_key = match options.get("idempotency_key").and_then(|v| v.as_str()) {
+            Some(key) => key.to_string(),
+            None => default_sink_key(sink_type, options),
+        };
+        let hash_fields = match options.get("hash_fields") {
+            Some(Value::Array(fields)) => fields.iter()
+                .filter_map(|f| f.as_str().map(|s| s.to_string()))
+                .collect(),
+            _ => Vec::new(),
+        };
+
+        Some(Self { state_db, sink_key, hash_fields })
+    }
+
+    /// 记录内容的哈希，与字段顺序无关
+    pub fn record_hash(&self, record: &DataRecord) -> String {
+        let fields: BTreeMap<&String, &Value> = record.data.iter()
+            .filter(|(name, _)| self.hash_fields.is_empty() || self.hash_fields.contains(name))
+            .collect();
+        let content = serde_json::to_vec(&fields).unwrap_or_default();
+        hex::encode(Sha256::digest(&content))
+    }
+}
+
+/// 由目标类型和不含幂等设置的配置计算目标标识
+fn default_sink_key(sink_type: &str, options: &HashMap<String, Value>) -> String {
+    let config: BTreeMap<&String, &Value> = options.iter()
+        .filter(|(name, _)| !matches!(name.as_str(), "idempotent" | "idempotency_db" | "idempotency_key" | "hash_fields"))
+        .collect();
+    let content = serde_json::to_vec(&config).unwrap_or_default();
+    format!("{}:{}", sink_type, &hex::encode(Sha256::digest(&content))[..16])
+}
+
+/// 已写入记录的状态表
+pub struct WrittenRecords {
+    conn: Mutex<Connection>,
+}
+
+impl WrittenRecords {
+    /// 打开状态数据库，不存在时创建
+    pub fn open<P: AsRef<Path>>(path: P) -> Result<Self, String> {
+        let conn = Connection::open(path.as_ref())
+            .map_err(|e| format!("打开幂等状态数据库失败: {}: {}", path.as_ref().display(), e))?;
+        Self::init(conn)
+    }
+
+    /// 内存中的状态表，用于测试
+    pub fn in_memory() -> Result<Self, String> {
+        let conn = Connection::open_in_memory()
+            .map_err(|e| format!("打开幂等状态数据库失败: {}", e))?;
+        Self::init(conn)
+    }
+
+    fn init(conn: Connection) -> Result<Self, String> {
+        conn.execute_batch(SCHEMA)
+            .map_err(|e| format!("初始化幂等状态表失败: {}", e))?;
+        Ok(Self { conn: Mutex::new(conn) })
+    }
+
+    /// 返回哈希中已经写入目标的部分
+    pub fn written(&self, sink: &str, hashes: &[String]) -> Result<HashSet<String>, String> {
+        let conn = self.conn.lock().unwrap();
+        let mut stmt = conn.prepare_cached("SELECT 1 FROM written_records WHERE sink = ?1 AND hash = ?2")
+            .map_err(|e| format!("查询幂等状态失败: {}", e))?;
+        let mut written = HashSet::new();
+        for hash in hashes {
+            let exists = stmt.exists(params![sink, hash])
+                .map_err(|e| format!("查询幂等状态失败: {}", e))?;
+            if exists {
+                written.insert(hash.clone());
+            }
+        }
+        Ok(written)
+    }
+
+    /// 标记哈希已写入目标
+    pub fn mark_written(&self, sink: &str, hashes: &[String]) -> Result<(), String> {
+        let mut conn = self.conn.lock().unwrap();
+        let tx = conn.transaction()
+            .map_err(|e| format!("保存幂等状态失败: {}", e))?;
+        {
+            let now = Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true);
+            let mut stmt = tx.prepare_cached("INSERT OR IGNORE INTO written_records (sink, hash, written_at) VALUES (?1, ?2, ?3)")
+                .map_err(|e| format!("保存幂等状态失败: {}", e))?;
+            for hash in hashes {
+                stmt.execute(params![sink, hash, now])
+                    .map_err(|e| format!("保存幂等状态失败: {}", e))?;
+            }
+        }
+        tx.commit().map_err(|e| format!("保存幂等状态失败: {}", e))
+    }
+
+    /// 清除目标的状态，之后的运行会重新写入所有记录
+    pub fn reset(&self, sink: &str) -> Result<usize, String> {
+        let conn = self.conn.lock().unwrap();
+        conn.execute("DELETE FROM written_records WHERE sink = ?1", params![sink])
+            .map_err(|e| format!("清除幂等状态失败: {}", e))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn record(fields: &[(&str, Value)]) -> DataRecord {
+        DataRecord {
+            data: fields.iter().map(|(k, v)| (k.to_string(), v.clone())).collect(),
+            metadata: HashMap::new(),
+            source: "test".to_string(),
+            timestamp: Utc::now(),
+        }
+    }
+
+    fn options(value: Value) -> HashMap<String, Value> {
+        serde_json::from_value(value).unwrap()
+    }
+
+    #[test]
+    fn test_config_from_options() {
+        assert!(IdempotencyConfig::from_options("csv", &options(json!({"path": "a.csv"}))).is_none());
+
+        let config = IdempotencyConfig::from_options("csv", &options(json!({"path": "a.csv", "idempotent": true}))).unwrap();
+        assert_eq!(config.state_db, DEFAULT_STATE_DB);
+        assert!(config.sink_key.starts_with("csv:"));
+
+        // 默认标识只由目标配置决定，与幂等设置无关
+        let other = IdempotencyConfig::from_options("csv", &options(json!({
+            "path": "a.csv", "idempotent": true, "idempotency_db": "other.db", "hash_fields": ["id"]
+        }))).unwrap();
+        assert_eq!(other.sink_key, config.sink_key);
+        assert_eq!(other.hash_fields, vec!["id".to_string()]);
+        let different = IdempotencyConfig::from_options("csv", &options(json!({"path": "b.csv", "idempotent": true}))).unwrap();
+        assert_ne!(different.sink_key, config.sink_key);
+
+        let explicit = IdempotencyConfig::from_options("csv", &options(json!({"idempotent": true, "idempotency_key": "orders"}))).unwrap();
+        assert_eq!(explicit.sink_key, "orders");
+    }
+
+    #[test]
+    fn test_record_hash() {
+        let config = IdempotencyConfig::from_options("csv", &options(json!({"idempotent": true}))).unwrap();
+        let a = record(&[("id", json!(1)), ("name", json!("a"))]);
+        let b = record(&[("name", json!("a")), ("id", json!(1))]);
+        let c = record(&[("id", json!(1)), ("name", json!("b"))]);
+        assert_eq!(config.record_hash(&a), config.record_hash(&b));
+        assert_ne!(config.record_hash(&a), config.record_hash(&c));
+
+        let by_id = IdempotencyConfig { hash_fields: vec!["id".to_string()], ..config };
+        assert_eq!(by_id.record_hash(&a), by_id.record_hash(&c));
+    }
+
+    #[test]
+    fn test_written_records() {
+        let state = WrittenRecords::in_memory().unwrap();
+        let hashes = vec!["h1".to_string(), "h2".to_string()];
+        assert!(state.written("orders", &hashes).unwrap().is_empty());
+
+        state.mark_written("orders", &hashes[..1]).unwrap();
+        // 重复标记不会失败
+        state.mark_written("orders", &hashes[..1]).unwrap();
+        assert_eq!(state.written("orders", &hashes).unwrap(), HashSet::from(["h1".to_string()]));
+        assert!(state.written("users", &hashes).unwrap().is_empty());
+
+        assert_eq!(state.reset("orders").unwrap(), 1);
+        assert!(state.written("orders", &hashes).unwrap().is_empty());
+    }
+}
@@ -6,6 +6,18 @@ pub mod loaders;
 pub mod transformers;
 pub mod types;
 pub mod plugin;
+pub mod connectors;
+pub mod retry;
+pub mod idempotency;
+pub mod commit;
+pub mod store;
+pub mod testkit;
+pub mod versions;
+pub mod throttle;
+pub mod metrics;
+
+#[cfg(feature = "duckdb")]
+pub mod duckdb_extensions;
 
 #[cfg(feature = "vector-store")]
 pub mod vector_store;
@@ -0,0 +1,181 @@
+use actix::prelude::*;
+use std::collections::{BTreeMap, HashMap};
+use log::{info, debug};
+use duckdb::{Connection, params_from_iter};
+use duckdb::types::Value as DuckValue;
+
+use crate::types::DataRecord;
+use crate::actors::messages::LoadData;
+
+/// 默认每追加多少行刷新一次
+const DEFAULT_FLUSH_SIZE: usize = 100_000;
+
+/// DuckDB批量加载器
+///
+/// 通过DuckDB的Appender按列式批量写入，而不是逐行执行INSERT，适合大批量
+/// ETL任务。目标表不存在时根据记录推断列类型创建；已存在时按表的列顺序
+/// 写入，记录中缺少的列写入NULL，多余的字段被忽略。
+pub struct DuckDbLoader {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl DuckDbLoader {
+    /// 创建新的DuckDB加载器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 将数据批量写入DuckDB表
+    fn append_records(&self, records: Vec<DataRecord>, options: &HashMap<String, serde_json::Value>) -> Result<usize, String> {
+        // 获取数据库路径
+        let db_path = match options.get("db_path") {
+            Some(serde_json::Value::String(path)) => path.clone(),
+            _ => return Err("未指定DuckDB数据库路径".to_string()),
+        };
+
+        // 获取表名
+        let table_name = match options.get("table_name") {
+            Some(serde_json::Value::String(name)) => name.clone(),
+            _ => return Err("未指定目标表名".to_string()),
+        };
+        if table_name.is_empty() || !table_name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+            return Err(format!("无效的表名: {}", table_name));
+        }
+
+        // 每追加多少行刷新一次
+        let flush_size = match options.get("flush_size") {
+            Some(serde_json::Value::Number(n)) => n.as_u64().map(|n| n as usize).filter(|n| *n > 0).unwrap_or(DEFAULT_FLUSH_SIZE),
+            _ => DEFAULT_FLUSH_SIZE,
+        };
+
+        // 表不存在时是否自动创建
+        let create_table = match options.get("create_table") {
+            Some(serde_json::Value::Bool(create)) => *create,
+            _ => true,
+        };
+
+        if records.is_empty() {
+            info!("没有记录需要写入");
+            return Ok(0);
+        }
+
+        let conn = Connection::open(&db_path)
+            .map_err(|e| format!("无法连接到DuckDB数据库 {}: {}", db_path, e))?;
+
+        let mut columns = table_columns(&conn, &table_name)?;
+        if columns.is_empty() {
+            if !create_table {
+                return Err(format!("目标表不存在: {}", table_name));
+            }
+            let column_types = infer_column_types(&records);
+            let definitions: Vec<String> = column_types.iter()
+                .map(|(name, sql_type)| format!("\"{}\" {}", name.replace('"', "\"\""), sql_type))
+                .collect();
+            conn.execute_batch(&format!("CREATE TABLE IF NOT EXISTS {} ({})", table_name, definitions.join(", ")))
+                .map_err(|e| format!("创建表 {} 失败: {}", table_name, e))?;
+            columns = column_types.into_keys().collect();
+        }
+
+        let mut appender = conn.appender(&table_name)
+            .map_err(|e| format!("创建Appender失败: {}", e))?;
+
+        let mut count = 0;
+        for record in &records {
+            let row = columns.iter().map(|column| to_duck_value(record.data.get(column)));
+            appender.append_row(params_from_iter(row))
+                .map_err(|e| format!("写入第{}条记录失败: {}", count + 1, e))?;
+
+            count += 1;
+            if count % flush_size == 0 {
+                appender.flush()
+                    .map_err(|e| format!("刷新Appender失败: {}", e))?;
+                debug!("已向DuckDB表 {} 写入{}条记录", table_name, count);
+            }
+        }
+
+        appender.flush()
+            .map_err(|e| format!("刷新Appender失败: {}", e))?;
+
+        info!("成功写入{}条记录到DuckDB表: {}", count, table_name);
+        Ok(count)
+    }
+}
+
+/// 按定义顺序获取表的列名，表不存在时返回空列表
+fn table_columns(conn: &Connection, table_name: &str) -> Result<Vec<String>, String> {
+    let mut stmt = conn
+        .prepare("SELECT column_name FROM information_schema.columns WHERE table_name = ? ORDER BY ordinal_position")
+        .map_err(|e| format!("读取表结构失败: {}", e))?;
+    let columns = stmt
+        .query_map([table_name], |row| row.get::<_, String>(0))
+        .map_err(|e| format!("读取表结构失败: {}", e))?
+        .collect::<Result<Vec<_>, _>>()
+        .map_err(|e| format!("读取表结构失败: {}", e))?;
+    Ok(columns)
+}
+
+/// 根据记录推断各字段的列类型，有小数的数值列为DOUBLE，无法确定时为VARCHAR
+pub(crate) fn infer_column_types(records: &[DataRecord]) -> BTreeMap<String, &'static str> {
+    let mut types: BTreeMap<String, &'static str> = BTreeMap::new();
+    for record in records {
+        for (name, value) in &record.data {
+            let inferred = match value {
+                serde_json::Value::Null => None,
+                serde_json::Value::Bool(_) => Some("BOOLEAN"),
+                serde_json::Value::Number(n) if n.is_i64() || n.is_u64() => Some("BIGINT"),
+                serde_json::Value::Number(_) => Some("DOUBLE"),
+                _ => Some("VARCHAR"),
+            };
+            let current = types.entry(name.clone()).or_insert("NULL");
+            *current = match (*current, inferred) {
+                (current, None) => current,
+                ("NULL", Some(t)) => t,
+                (current, Some(t)) if current == t => current,
+                ("BIGINT", Some("DOUBLE")) | ("DOUBLE", Some("BIGINT")) => "DOUBLE",
+                _ => "VARCHAR",
+            };
+        }
+    }
+    for sql_type in types.values_mut() {
+        if *sql_type == "NULL" {
+            *sql_type = "VARCHAR";
+        }
+    }
+    types
+}
+
+/// 将JSON值转换为DuckDB值，数组和对象以JSON文本写入
+pub(crate) fn to_duck_value(value: Option<&serde_json::Value>) -> DuckValue {
+    match value {
+        None | Some(serde_json::Value::Null) => DuckValue::Null,
+        Some(serde_json::Value::Bool(b)) => DuckValue::Boolean(*b),
+        Some(serde_json::Value::Number(n)) => match n.as_i64() {
+            Some(i) => DuckValue::BigInt(i),
+            None => DuckValue::Double(n.as_f64().unwrap_or(f64::NAN)),
+        },
+        Some(serde_json::Value::String(s)) => DuckValue::Text(s.clone()),
+        Some(other) => DuckValue::Text(other.to_string()),
+    }
+}
+
+impl Actor for DuckDbLoader {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("DuckDB加载器已启动");
+    }
+}
+
+impl Handler<LoadData> for DuckDbLoader {
+    type Result = ResponseFuture<Result<usize, String>>;
+
+    fn handle(&mut self, msg: LoadData, _: &mut Context<Self>) -> Self::Result {
+        let records = msg.records;
+        let options = self.config.clone();
+
+        Box::pin(async move {
+            let loader = DuckDbLoader::new(options.clone());
+            loader.append_records(records, &options)
+        })
+    }
+}
@@ -6,6 +6,12 @@ use crate::types::ETLError;
 use crate::loaders::csv::CsvLoader;
 use crate::loaders::memory::MemoryLoader;
 use crate::loaders::jdbc::JdbcLoader;
+#[cfg(feature = "duckdb")]
+use crate::loaders::duckdb::DuckDbLoader;
+#[cfg(feature = "duckdb")]
+use crate::loaders::parquet::ParquetLoader;
+#[cfg(feature = "duckdb")]
+use crate::loaders::s3::S3Loader;
 
 /// 创建加载器
 pub fn create_loader(loader_type: &str, options: HashMap<String, serde_json::Value>) -> Result<Addr<dyn Actor>, ETLError> {
@@ -25,10 +31,38 @@ pub fn create_loader(loader_type: &str, options: HashMap<String, serde_json::Val
             let loader = JdbcLoader::new(options);
             Ok(loader.start())
         },
+        #[cfg(feature = "duckdb")]
+        "duckdb" => {
+            // 创建DuckDB批量加载器
+            let loader = DuckDbLoader::new(options);
+            Ok(loader.start())
+        },
+        #[cfg(feature = "duckdb")]
+        "parquet" => {
+            // 创建Parquet加载器
+            let loader = ParquetLoader::new(options);
+            Ok(loader.start())
+        },
+        #[cfg(feature = "duckdb")]
+        "s3" => {
+            // 创建S3加载器
+            let loader = S3Loader::new(options);
+            Ok(loader.start())
+        },
         // 可以添加更多加载器类型
         _ => {
             error!("未知的加载器类型: {}", loader_type);
             Err(ETLError::ConfigError(format!("未知的加载器类型: {}", loader_type)))
         }
     }
-} 
\ No newline at end of file
+}
+
+/// 是否支持该类型的加载器
+pub fn is_supported(loader_type: &str) -> bool {
+    match loader_type {
+        "csv" | "memory" | "jdbc" => true,
+        #[cfg(feature = "duckdb")]
+        "duckdb" | "parquet" | "s3" => true,
+        _ => false,
+    }
+}
@@ -1,12 +1,28 @@
 use actix::prelude::*;
-use std::collections::HashMap;
-use log::{info, warn, error};
+use std::collections::{BTreeSet, HashMap};
+use log::{info, debug};
+use rusqlite::types::Value as SqlValue;
 use serde_json::Value;
 
 use crate::types::{DataRecord, ETLError};
 use crate::actors::messages::LoadData;
+use crate::connectors::{self, ConnectionPool, Driver};
+use crate::retry::{self, transient, ErrorKind};
+
+/// 加载模式
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum LoadMode {
+    Insert,
+    Update,
+    Upsert,
+}
 
 /// JDBC 加载器
+///
+/// 目标数据库由`driver`（postgres、mysql、sqlite）和`dsn`指定，未指定
+/// `driver`时按DSN的协议推断，兼容旧的`connection_string`参数。连接池由
+/// [`connectors`](crate::connectors)按DSN共享，`max_connections`设置连接池
+/// 大小。每批（`batch_size`，默认500）记录在一个事务中写入。
 pub struct JdbcLoader {
     /// 配置选项
     config: HashMap<String, Value>,
@@ -21,87 +37,233 @@ impl JdbcLoader {
     /// 加载数据到数据库
     async fn load_to_database(&self, records: Vec<DataRecord>) -> Result<usize, ETLError> {
         // 获取连接配置
-        let connection_string = self.config.get("connection_string")
+        let dsn = self.config.get("dsn")
+            .or_else(|| self.config.get("connection_string"))
             .and_then(|v| v.as_str())
-            .ok_or_else(|| ETLError::ConfigError("JDBC加载器需要'connection_string'参数".to_string()))?;
+            .ok_or_else(|| ETLError::ConfigError("JDBC加载器需要'dsn'参数".to_string()))?;
         
         let table_name = self.config.get("table_name")
             .and_then(|v| v.as_str())
             .ok_or_else(|| ETLError::ConfigError("JDBC加载器需要'table_name'参数".to_string()))?;
         
         // 确定数据库类型
-        let db_type = if connection_string.contains("mysql") {
-            "MySQL"
-        } else if connection_string.contains("postgresql") {
-            "PostgreSQL"
-        } else if connection_string.contains("sqlserver") {
-            "MSSQL"
-        } else if connection_string.contains("oracle") {
-            "Oracle"
-        } else {
-            "Unknown"
+        let driver = match self.config.get("driver").and_then(|v| v.as_str()) {
+            Some(name) => Driver::from_name(name),
+            None => Driver::from_dsn(dsn),
+        }.map_err(ETLError::ConfigError)?;
+
+        let load_mode = match self.config.get("load_mode").and_then(|v| v.as_str()).unwrap_or("insert") {
+            "insert" => LoadMode::Insert,
+            "update" => LoadMode::Update,
+            "upsert" => LoadMode::Upsert,
+            other => return Err(ETLError::ConfigError(format!("未知的加载模式: {}", other))),
         };
 
-        info!("正在连接到 {} 数据库: {}", db_type, connection_string);
-        info!("准备加载 {} 条记录到表 '{}'", records.len(), table_name);
+        // 更新和插入冲突判断使用的键列
+        let key_columns: Vec<String> = match (self.config.get("key_columns"), self.config.get("key_column")) {
+            (Some(Value::Array(keys)), _) => keys.iter().filter_map(|k| k.as_str().map(|s| s.to_string())).collect(),
+            (_, Some(Value::String(key))) => vec![key.clone()],
+            _ => vec!["id".to_string()],
+        };
+        if key_columns.is_empty() {
+            return Err(ETLError::ConfigError("key_columns不能为空".to_string()));
+        }
 
-        // 在实际实现中，这里应该连接到数据库并执行插入/更新操作
-        // 此处仅作为示例，显示如何处理记录
-        
-        let load_mode = self.config.get("load_mode")
-            .and_then(|v| v.as_str())
-            .unwrap_or("insert");
-
-        match load_mode {
-            "insert" => {
-                info!("使用 INSERT 模式加载数据");
-                // 模拟构建INSERT语句
-                for (i, record) in records.iter().enumerate().take(3) {
-                    if i == 0 {
-                        info!("示例INSERT: 插入记录 {:?}", record);
-                    }
-                }
+        if records.is_empty() {
+            return Ok(0);
+        }
+
+        // 写入的列，未指定时使用记录中出现的所有字段
+        let columns: Vec<String> = match self.config.get("columns") {
+            Some(Value::Array(columns)) => columns.iter().filter_map(|c| c.as_str().map(|s| s.to_string())).collect(),
+            _ => {
+                let names: BTreeSet<&String> = records.iter().flat_map(|r| r.data.keys()).collect();
+                names.into_iter().cloned().collect()
             },
-            "update" => {
-                info!("使用 UPDATE 模式加载数据");
-                // 模拟构建UPDATE语句
-                let key_column = self.config.get("key_column")
-                    .and_then(|v| v.as_str())
-                    .unwrap_or("id");
-                
-                info!("使用 '{}' 列作为更新键", key_column);
-                
-                for (i, record) in records.iter().enumerate().take(3) {
-                    if i == 0 {
-                        info!("示例UPDATE: 更新记录 {:?}", record);
-                    }
+        };
+        if load_mode != LoadMode::Insert {
+            for key in &key_columns {
+                if !columns.contains(key) {
+                    return Err(ETLError::ConfigError(format!("记录中没有键列: {}", key)));
                 }
+            }
+        }
+
+        let max_connections = self.config.get("max_connections").and_then(|v| v.as_u64()).map(|n| n as u32);
+        let pool = connectors::pool(driver, dsn, max_connections).await
+            .map_err(ETLError::SinkError)?;
+
+        let column_types = column_types(&pool, table_name).await
+            .map_err(ETLError::SinkError)?;
+        let (sql, params) = build_statement(driver, table_name, &columns, &key_columns, load_mode, &column_types)?;
+        debug!("{}写入语句: {}", driver.name(), sql);
+
+        let batch_size = self.config.get("batch_size").and_then(|v| v.as_u64()).unwrap_or(500).max(1) as usize;
+
+        // 之前的批次已经提交时失败不能整体重试，否则会重复写入这些批次
+        let mut loaded = 0;
+        for batch in records.chunks(batch_size) {
+            execute_batch(&pool, &sql, &params, batch).await
+                .map_err(|e| match loaded {
+                    0 => ETLError::SinkError(e),
+                    _ => ETLError::SinkError(format!("已写入{}条记录后失败: {}", loaded, retry::permanent(&e))),
+                })?;
+            loaded += batch.len();
+        }
+
+        info!("成功加载 {} 条记录到 {} 数据库表 '{}' ({})", loaded, driver.name(), table_name, connectors::redact_dsn(dsn));
+        Ok(loaded)
+    }
+}
+
+/// 构建写入语句，返回语句和各参数对应的字段
+///
+/// `column_types`中有类型的列，占位符带`::类型`转换（PostgreSQL的参数都按文本绑定）
+fn build_statement(
+    driver: Driver,
+    table: &str,
+    columns: &[String],
+    key_columns: &[String],
+    mode: LoadMode,
+    column_types: &HashMap<String, String>,
+) -> Result<(String, Vec<String>), ETLError> {
+    let table = driver.quote_identifier(table);
+    let placeholder = |index: usize, column: &String| match column_types.get(column) {
+        Some(data_type) => format!("{}::{}", driver.placeholder(index), data_type),
+        None => driver.placeholder(index),
+    };
+    match mode {
+        LoadMode::Insert | LoadMode::Upsert => {
+            let names: Vec<String> = columns.iter().map(|c| driver.quote_identifier(c)).collect();
+            let placeholders: Vec<String> = columns.iter().enumerate().map(|(i, c)| placeholder(i + 1, c)).collect();
+            let mut sql = format!("INSERT INTO {} ({}) VALUES ({})", table, names.join(", "), placeholders.join(", "));
+            if mode == LoadMode::Upsert {
+                sql.push_str(&driver.upsert_clause(key_columns, columns));
+            }
+            Ok((sql, columns.to_vec()))
+        },
+        LoadMode::Update => {
+            let mut params: Vec<String> = columns.iter().filter(|c| !key_columns.contains(c)).cloned().collect();
+            if params.is_empty() {
+                return Err(ETLError::ConfigError("更新模式需要至少一个非键列".to_string()));
+            }
+            let assignments: Vec<String> = params.iter().enumerate()
+                .map(|(i, c)| format!("{} = {}", driver.quote_identifier(c), placeholder(i + 1, c)))
+                .collect();
+            let conditions: Vec<String> = key_columns.iter().enumerate()
+                .map(|(i, c)| format!("{} = {}", driver.quote_identifier(c), placeholder(params.len() + i + 1, c)))
+                .collect();
+            let sql = format!("UPDATE {} SET {} WHERE {}", table, assignments.join(", "), conditions.join(" AND "));
+            params.extend(key_columns.iter().cloned());
+            Ok((sql, params))
+        },
+    }
+}
+
+/// 目标表各列的类型，只有PostgreSQL需要
+///
+/// PostgreSQL不会把文本参数隐式转换为整数、时间等类型，按列类型显式转换
+async fn column_types(pool: &ConnectionPool, table: &str) -> Result<HashMap<String, String>, String> {
+    match pool {
+        #[cfg(feature = "sql")]
+        ConnectionPool::Postgres(pool) => {
+            let rows: Vec<(String, String)> = sqlx::query_as(
+                "SELECT attname::text, format_type(atttypid, atttypmod) FROM pg_attribute \
+                 WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped"
+            )
+                .bind(Driver::Postgres.quote_identifier(table))
+                .fetch_all(pool)
+                .await
+                .map_err(|e| format!("读取表{}的列类型失败: {}", table, e))?;
+            Ok(rows.into_iter().collect())
+        },
+        _ => Ok(HashMap::new()),
+    }
+}
+
+/// 为sqlx查询绑定JSON值
+#[cfg(feature = "sql")]
+macro_rules! bind_json {
+    ($query:expr, $value:expr) => {
+        match $value {
+            None | Some(Value::Null) => $query.bind(Option::<String>::None),
+            Some(Value::Bool(b)) => $query.bind(*b),
+            Some(Value::Number(n)) => match n.as_i64() {
+                Some(i) => $query.bind(i),
+                None => $query.bind(n.as_f64()),
             },
-            "upsert" => {
-                info!("使用 UPSERT 模式加载数据");
-                // 模拟构建UPSERT语句
-                let key_column = self.config.get("key_column")
-                    .and_then(|v| v.as_str())
-                    .unwrap_or("id");
-                
-                info!("使用 '{}' 列作为更新键", key_column);
-                
-                for (i, record) in records.iter().enumerate().take(3) {
-                    if i == 0 {
-                        info!("示例UPSERT: 插入/更新记录 {:?}", record);
-                    }
+            Some(Value::String(s)) => $query.bind(s.clone()),
+            Some(other) => $query.bind(other.to_string()),
+        }
+    };
+}
+
+/// 在一个事务中写入一批记录
+async fn execute_batch(pool: &ConnectionPool, sql: &str, params: &[String], batch: &[DataRecord]) -> Result<(), String> {
+    match pool {
+        #[cfg(feature = "sql")]
+        ConnectionPool::Postgres(pool) => {
+            let mut tx = pool.begin().await.map_err(|e| transient(ErrorKind::from_sqlx(&e), format!("开始事务失败: {}", e)))?;
+            for record in batch {
+                let mut query = sqlx::query(sql);
+                for column in params {
+                    query = query.bind(to_text(record.data.get(column)));
                 }
-            },
-            _ => {
-                warn!("未知的加载模式 '{}', 默认使用 INSERT", load_mode);
-                // 默认INSERT处理
+                query.execute(&mut *tx).await.map_err(|e| transient(ErrorKind::from_sqlx(&e), format!("写入记录失败: {}", e)))?;
             }
-        }
+            tx.commit().await.map_err(|e| transient(ErrorKind::from_sqlx(&e), format!("提交事务失败: {}", e)))
+        },
+        #[cfg(feature = "sql")]
+        ConnectionPool::MySql(pool) => {
+            let mut tx = pool.begin().await.map_err(|e| transient(ErrorKind::from_sqlx(&e), format!("开始事务失败: {}", e)))?;
+            for record in batch {
+                let mut query = sqlx::query(sql);
+                for column in params {
+                    query = bind_json!(query, record.data.get(column));
+                }
+                query.execute(&mut *tx).await.map_err(|e| transient(ErrorKind::from_sqlx(&e), format!("写入记录失败: {}", e)))?;
+            }
+            tx.commit().await.map_err(|e| transient(ErrorKind::from_sqlx(&e), format!("提交事务失败: {}", e)))
+        },
+        ConnectionPool::Sqlite(conn) => {
+            let mut conn = conn.lock().unwrap();
+            let tx = conn.transaction().map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("开始事务失败: {}", e)))?;
+            {
+                let mut stmt = tx.prepare_cached(sql).map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("准备语句失败: {}", e)))?;
+                for record in batch {
+                    let values: Vec<SqlValue> = params.iter()
+                        .map(|column| to_sqlite_value(record.data.get(column)))
+                        .collect();
+                    stmt.execute(rusqlite::params_from_iter(values.iter()))
+                        .map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("写入记录失败: {}", e)))?;
+                }
+            }
+            tx.commit().map_err(|e| transient(ErrorKind::from_sqlite(&e), format!("提交事务失败: {}", e)))
+        },
+    }
+}
 
-        // 模拟成功加载数据
-        info!("成功加载 {} 条记录到 {} 数据库表 '{}'", records.len(), db_type, table_name);
-        
-        Ok(records.len())
+/// 将JSON值转换为文本参数，由语句中的类型转换还原为列类型
+#[cfg(feature = "sql")]
+fn to_text(value: Option<&Value>) -> Option<String> {
+    match value {
+        None | Some(Value::Null) => None,
+        Some(Value::String(s)) => Some(s.clone()),
+        Some(other) => Some(other.to_string()),
+    }
+}
+
+/// 将JSON值转换为SQLite值
+fn to_sqlite_value(value: Option<&Value>) -> SqlValue {
+    match value {
+        None | Some(Value::Null) => SqlValue::Null,
+        Some(Value::Bool(b)) => SqlValue::Integer(*b as i64),
+        Some(Value::Number(n)) => match n.as_i64() {
+            Some(i) => SqlValue::Integer(i),
+            None => SqlValue::Real(n.as_f64().unwrap_or_default()),
+        },
+        Some(Value::String(s)) => SqlValue::Text(s.clone()),
+        Some(other) => SqlValue::Text(other.to_string()),
     }
 }
 
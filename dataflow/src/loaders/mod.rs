@@ -2,7 +2,19 @@ pub mod csv;
 pub mod memory;
 pub mod factory;
 pub mod jdbc;
+#[cfg(feature = "duckdb")]
+pub mod duckdb;
+#[cfg(feature = "duckdb")]
+pub mod parquet;
+#[cfg(feature = "duckdb")]
+pub mod s3;
 
 pub use csv::CsvLoader;
 pub use memory::MemoryLoader;
-pub use jdbc::JdbcLoader; 
\ No newline at end of file
+pub use jdbc::JdbcLoader;
+#[cfg(feature = "duckdb")]
+pub use self::duckdb::DuckDbLoader;
+#[cfg(feature = "duckdb")]
+pub use parquet::ParquetLoader;
+#[cfg(feature = "duckdb")]
+pub use s3::S3Loader; 
\ No newline at end of file
@@ -0,0 +1,125 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use std::path::Path;
+use log::{info, debug};
+use duckdb::{Connection, params_from_iter};
+
+use crate::types::DataRecord;
+use crate::actors::messages::LoadData;
+use crate::loaders::duckdb::{infer_column_types, to_duck_value};
+
+/// 默认行组大小，与DuckDB一致
+const DEFAULT_ROW_GROUP_SIZE: usize = 122_880;
+
+/// Parquet文件加载器
+///
+/// 记录先按推断出的列类型追加到内存中的DuckDB表，再用`COPY`写为Parquet
+/// 文件，每`row_group_size`行组成一个行组。
+pub struct ParquetLoader {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl ParquetLoader {
+    /// 创建新的Parquet加载器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 将数据写入Parquet文件
+    fn write_parquet_file(&self, records: Vec<DataRecord>, options: &HashMap<String, serde_json::Value>) -> Result<usize, String> {
+        // 获取文件路径
+        let file_path = match options.get("file_path") {
+            Some(serde_json::Value::String(path)) => path,
+            _ => return Err("未指定Parquet文件路径".to_string()),
+        };
+
+        // 行组大小
+        let row_group_size = match options.get("row_group_size") {
+            Some(serde_json::Value::Number(n)) => n.as_u64().map(|n| n as usize).filter(|n| *n > 0).unwrap_or(DEFAULT_ROW_GROUP_SIZE),
+            _ => DEFAULT_ROW_GROUP_SIZE,
+        };
+
+        // 压缩算法
+        let compression = match options.get("compression") {
+            Some(serde_json::Value::String(codec)) => codec.to_lowercase(),
+            _ => "snappy".to_string(),
+        };
+        if !matches!(compression.as_str(), "uncompressed" | "snappy" | "gzip" | "zstd") {
+            return Err(format!("不支持的压缩算法: {}", compression));
+        }
+
+        if records.is_empty() {
+            info!("没有记录需要写入");
+            return Ok(0);
+        }
+
+        // 创建目录（如果不存在）
+        if let Some(parent) = Path::new(file_path).parent() {
+            if !parent.as_os_str().is_empty() && !parent.exists() {
+                std::fs::create_dir_all(parent)
+                    .map_err(|e| format!("创建目录失败: {}", e))?;
+            }
+        }
+
+        let conn = Connection::open_in_memory()
+            .map_err(|e| format!("无法创建DuckDB连接: {}", e))?;
+
+        stage_records(&conn, "parquet_export", &records)?;
+
+        let sql = format!(
+            "COPY parquet_export TO '{}' (FORMAT PARQUET, ROW_GROUP_SIZE {}, COMPRESSION {})",
+            file_path.replace('\'', "''"), row_group_size, compression
+        );
+        debug!("写入Parquet: {}", sql);
+        conn.execute_batch(&sql)
+            .map_err(|e| format!("写入Parquet文件失败: {}", e))?;
+
+        info!("成功写入{}条记录到Parquet文件: {}", records.len(), file_path);
+        Ok(records.len())
+    }
+}
+
+/// 按推断出的列类型创建表，并用Appender写入记录，返回列名
+pub(crate) fn stage_records(conn: &Connection, table: &str, records: &[DataRecord]) -> Result<Vec<String>, String> {
+    let column_types = infer_column_types(records);
+    let definitions: Vec<String> = column_types.iter()
+        .map(|(name, sql_type)| format!("\"{}\" {}", name.replace('"', "\"\""), sql_type))
+        .collect();
+    conn.execute_batch(&format!("CREATE TABLE {} ({})", table, definitions.join(", ")))
+        .map_err(|e| format!("创建临时表失败: {}", e))?;
+
+    let columns: Vec<String> = column_types.into_keys().collect();
+    let mut appender = conn.appender(table)
+        .map_err(|e| format!("创建Appender失败: {}", e))?;
+    for record in records {
+        let row = columns.iter().map(|column| to_duck_value(record.data.get(column)));
+        appender.append_row(params_from_iter(row))
+            .map_err(|e| format!("写入记录失败: {}", e))?;
+    }
+    appender.flush()
+        .map_err(|e| format!("刷新Appender失败: {}", e))?;
+
+    Ok(columns)
+}
+
+impl Actor for ParquetLoader {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("Parquet加载器已启动");
+    }
+}
+
+impl Handler<LoadData> for ParquetLoader {
+    type Result = ResponseFuture<Result<usize, String>>;
+
+    fn handle(&mut self, msg: LoadData, _: &mut Context<Self>) -> Self::Result {
+        let records = msg.records;
+        let options = self.config.clone();
+
+        Box::pin(async move {
+            let loader = ParquetLoader::new(options.clone());
+            loader.write_parquet_file(records, &options)
+        })
+    }
+}
@@ -0,0 +1,102 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use log::{info, debug};
+use duckdb::Connection;
+use uuid::Uuid;
+
+use crate::types::DataRecord;
+use crate::actors::messages::LoadData;
+use crate::extractors::parquet::quote_literal;
+use crate::extractors::s3::{configure_s3, s3_format, s3_url};
+use crate::loaders::parquet::stage_records;
+
+/// S3对象加载器
+///
+/// 将记录写为`s3://bucket/prefix`下的CSV、JSON或Parquet文件。指定
+/// `partition_by`时按这些字段写成Hive风格的分区目录（如`day=2024-01-01/`），
+/// 否则每批记录写为一个新文件。凭据配置与`S3Extractor`相同。
+pub struct S3Loader {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl S3Loader {
+    /// 创建新的S3加载器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 将数据写入S3
+    fn write_objects(&self, records: Vec<DataRecord>, options: &HashMap<String, serde_json::Value>) -> Result<usize, String> {
+        let base_url = s3_url(options)?;
+        let format = s3_format(options)?;
+
+        // 分区字段
+        let partition_by: Vec<String> = match options.get("partition_by") {
+            Some(serde_json::Value::Array(fields)) => fields.iter()
+                .filter_map(|f| f.as_str().map(|s| s.to_string()))
+                .collect(),
+            Some(serde_json::Value::String(field)) => vec![field.clone()],
+            _ => Vec::new(),
+        };
+
+        if records.is_empty() {
+            info!("没有记录需要写入");
+            return Ok(0);
+        }
+
+        let conn = Connection::open_in_memory()
+            .map_err(|e| format!("无法创建DuckDB连接: {}", e))?;
+        configure_s3(&conn, options)?;
+
+        let columns = stage_records(&conn, "s3_export", &records)?;
+        if let Some(missing) = partition_by.iter().find(|field| !columns.contains(field)) {
+            return Err(format!("分区字段不存在: {}", missing));
+        }
+
+        let format_options = match format.as_str() {
+            "csv" => "FORMAT CSV, HEADER".to_string(),
+            "json" => "FORMAT JSON".to_string(),
+            _ => "FORMAT PARQUET".to_string(),
+        };
+        let sql = if partition_by.is_empty() {
+            let target = format!("{}/part-{}.{}", base_url.trim_end_matches('/'), Uuid::new_v4(), format);
+            format!("COPY s3_export TO {} ({})", quote_literal(&target), format_options)
+        } else {
+            let fields: Vec<String> = partition_by.iter()
+                .map(|f| format!("\"{}\"", f.replace('"', "\"\"")))
+                .collect();
+            format!(
+                "COPY s3_export TO {} ({}, PARTITION_BY ({}), OVERWRITE_OR_IGNORE)",
+                quote_literal(base_url.trim_end_matches('/')), format_options, fields.join(", ")
+            )
+        };
+        debug!("写入S3: {}", sql);
+        conn.execute_batch(&sql)
+            .map_err(|e| format!("写入S3失败: {}", e))?;
+
+        info!("成功写入{}条记录到S3: {}", records.len(), base_url);
+        Ok(records.len())
+    }
+}
+
+impl Actor for S3Loader {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("S3加载器已启动");
+    }
+}
+
+impl Handler<LoadData> for S3Loader {
+    type Result = ResponseFuture<Result<usize, String>>;
+
+    fn handle(&mut self, msg: LoadData, _: &mut Context<Self>) -> Self::Result {
+        let records = msg.records;
+        let options = self.config.clone();
+
+        Box::pin(async move {
+            let loader = S3Loader::new(options.clone());
+            loader.write_objects(records, &options)
+        })
+    }
+}
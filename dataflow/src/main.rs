@@ -2,14 +2,16 @@ use actix::Actor;
 use log::{info, error, warn};
 use env_logger::Env;
 use std::path::Path;
-use std::fs::File;
-use std::io::Read;
 use std::process;
+use std::sync::Arc;
 use structopt::StructOpt;
 
 use lumos_dataflow::config::ETLConfig;
 use lumos_dataflow::actors::dag_manager::DAGManagerActor;
-use lumos_dataflow::api::start_api_server;
+use lumos_dataflow::actors::messages::{SetConfig, ResumeExecutions};
+use lumos_dataflow::api::{start_api_server, restore_config_versions};
+use lumos_dataflow::store::ExecutionStore;
+use lumos_dataflow::throttle::PressureThrottle;
 
 /// ETL数据流程引擎
 #[derive(StructOpt, Debug)]
@@ -26,6 +28,43 @@ struct Cli {
     /// 服务端口，默认为8085
     #[structopt(short, long, default_value = "8085")]
     port: u16,
+    
+    /// 保存管道定义和执行记录的SQLite数据库路径，未指定时只保存在内存中
+    #[structopt(long, parse(from_os_str))]
+    state_db: Option<std::path::PathBuf>,
+    
+    /// 最多保留的已结束执行数（含执行日志），未指定时不限制
+    #[structopt(long)]
+    retain_executions: Option<usize>,
+    
+    /// 已结束执行的保留天数，未指定时不限制
+    #[structopt(long)]
+    retain_days: Option<i64>,
+    
+    /// 清理过期执行记录的间隔（秒）
+    #[structopt(long, default_value = "3600")]
+    retention_interval_secs: u64,
+    
+    /// lumosdb服务的健康检查地址，例如http://127.0.0.1:8080/api/health；
+    /// 设置后在交互查询承压时自动降低作业并行度
+    #[structopt(long)]
+    pressure_health_url: Option<String>,
+    
+    /// 读取健康检查使用的lumosdb API密钥，lumosdb启用认证时必须设置
+    #[structopt(long)]
+    pressure_api_key: Option<String>,
+    
+    /// 首次承压时从该并行度的一半开始限制
+    #[structopt(long, default_value = "8")]
+    pressure_max_parallel: usize,
+    
+    /// 读取健康检查的间隔（秒）
+    #[structopt(long, default_value = "5")]
+    pressure_interval_secs: u64,
+    
+    /// 允许安装和加载的DuckDB扩展，逗号分隔，默认为httpfs,parquet,json,spatial
+    #[structopt(long, use_delimiter = true)]
+    duckdb_extension_allowlist: Option<Vec<String>>,
 }
 
 #[actix_web::main]
@@ -38,8 +77,15 @@ async fn main() -> std::io::Result<()> {
     
     info!("启动 Lumos Dataflow 引擎...");
     
+    // 设置DuckDB扩展允许列表
+    #[cfg(feature = "duckdb")]
+    if let Some(names) = &args.duckdb_extension_allowlist {
+        lumos_dataflow::duckdb_extensions::set_allowlist(names);
+        info!("允许的DuckDB扩展: {}", names.join(","));
+    }
+    
     // 加载配置文件
-    let config = if let Some(config_path) = args.config {
+    let config = if let Some(config_path) = &args.config {
         info!("从配置文件加载DAG配置: {:?}", config_path);
         
         match load_config_from_file(&config_path) {
@@ -54,10 +100,74 @@ async fn main() -> std::io::Result<()> {
         None
     };
     
+    // 打开执行记录存储
+    let store = match &args.state_db {
+        Some(path) => match ExecutionStore::open(path) {
+            Ok(store) => {
+                info!("执行记录保存在: {:?}", path);
+                Some(Arc::new(store))
+            },
+            Err(e) => {
+                error!("打开执行记录存储失败: {}", e);
+                process::exit(1);
+            }
+        },
+        None => None,
+    };
+    
+    // 按保留策略定期清理已结束的执行记录和日志
+    if let Some(store) = &store {
+        if args.retain_executions.is_some() || args.retain_days.is_some() {
+            let store = store.clone();
+            let max_entries = args.retain_executions;
+            let max_age = args.retain_days.map(chrono::Duration::days);
+            let interval = std::time::Duration::from_secs(args.retention_interval_secs.max(1));
+            std::thread::spawn(move || loop {
+                match store.prune(max_entries, max_age) {
+                    Ok(count) if count > 0 => info!("已按保留策略清理{}个执行记录", count),
+                    Ok(_) => {},
+                    Err(e) => error!("{}", e),
+                }
+                std::thread::sleep(interval);
+            });
+        }
+    }
+    
+    // 恢复管道定义的版本历史
+    let restored_version = match &store {
+        Some(store) => match restore_config_versions(store) {
+            Ok(version) => version,
+            Err(e) => {
+                error!("恢复管道定义版本失败: {}", e);
+                process::exit(1);
+            }
+        },
+        None => None,
+    };
+    
+    // 交互查询承压时降低作业并行度
+    let throttle = args.pressure_health_url.clone().map(|url| {
+        info!("根据{}的交互查询压力调整作业并行度", url);
+        let throttle = Arc::new(PressureThrottle::new(args.pressure_max_parallel));
+        let interval = std::time::Duration::from_secs(args.pressure_interval_secs.max(1));
+        actix_web::rt::spawn(throttle.clone().watch(url, args.pressure_api_key.clone(), interval));
+        throttle
+    });
+    
     // 创建DAG管理器Actor
+    let with_store = |actor: DAGManagerActor| {
+        let actor = match &store {
+            Some(store) => actor.with_store(store.clone()),
+            None => actor,
+        };
+        match &throttle {
+            Some(throttle) => actor.with_throttle(throttle.clone()),
+            None => actor,
+        }
+    };
     let dag_manager = if let Some(cfg) = config {
         match DAGManagerActor::new(cfg) {
-            Ok(actor) => actor.start(),
+            Ok(actor) => with_store(actor).start(),
             Err(e) => {
                 error!("创建DAG管理器失败: {}", e);
                 process::exit(1);
@@ -66,7 +176,7 @@ async fn main() -> std::io::Result<()> {
     } else {
         // 创建一个空的DAG管理器，等待通过API配置
         match DAGManagerActor::with_empty_config() {
-            Ok(actor) => actor.start(),
+            Ok(actor) => with_store(actor).start(),
             Err(e) => {
                 error!("创建DAG管理器失败: {}", e);
                 process::exit(1);
@@ -74,25 +184,30 @@ async fn main() -> std::io::Result<()> {
         }
     };
     
+    // 未指定配置文件时部署上次的当前版本
+    if let Some(version) = restored_version.filter(|_| args.config.is_none()) {
+        info!("部署已保存的管道定义版本: {}", version.version);
+        match dag_manager.send(SetConfig { config: version.config, version: version.version }).await {
+            Ok(Ok(())) => {},
+            Ok(Err(e)) => error!("部署管道定义版本{}失败: {}", version.version, e),
+            Err(e) => error!("发送SetConfig消息失败: {}", e),
+        }
+    }
+    
+    // 继续服务停止时未完成的执行
+    match dag_manager.send(ResumeExecutions).await {
+        Ok(Ok(count)) if count > 0 => info!("已继续{}个未完成的执行", count),
+        Ok(Ok(_)) => {},
+        Ok(Err(e)) => error!("恢复未完成的执行失败: {}", e),
+        Err(e) => error!("发送ResumeExecutions消息失败: {}", e),
+    }
+    
     // 启动API服务器
     info!("启动API服务器 - {}:{}", args.host, args.port);
-    start_api_server(dag_manager, &args.host, args.port).await
+    start_api_server(dag_manager, store, &args.host, args.port).await
 }
 
-/// 从文件加载ETL配置
+/// 从文件加载ETL配置，按扩展名解析YAML或JSON
 fn load_config_from_file<P: AsRef<Path>>(path: P) -> Result<ETLConfig, String> {
-    // 打开文件
-    let mut file = match File::open(&path) {
-        Ok(file) => file,
-        Err(e) => return Err(format!("无法打开配置文件: {}", e)),
-    };
-    
-    // 读取文件内容
-    let mut contents = String::new();
-    if let Err(e) = file.read_to_string(&mut contents) {
-        return Err(format!("读取配置文件失败: {}", e));
-    }
-    
-    // 解析YAML
-    ETLConfig::from_yaml(&contents)
-} 
\ No newline at end of file
+    ETLConfig::from_file(path).map_err(|e| e.to_string())
+}
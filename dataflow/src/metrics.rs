@@ -0,0 +1,206 @@
+use std::collections::BTreeMap;
+use std::fmt::Write;
+use chrono::Utc;
+
+use crate::types::{JobStats, JobStatus};
+
+/// 作业耗时直方图的桶上限（秒）
+const DURATION_BUCKETS: [f64; 10] = [0.1, 0.5, 1.0, 5.0, 10.0, 30.0, 60.0, 300.0, 900.0, 3600.0];
+
+/// 单调递增的计数器
+#[derive(Debug, Default, Clone, Copy)]
+pub struct Counter(u64);
+
+impl Counter {
+    pub fn inc_by(&mut self, value: u64) {
+        self.0 += value;
+    }
+
+    pub fn get(&self) -> u64 {
+        self.0
+    }
+}
+
+/// 可增可减的瞬时值
+#[derive(Debug, Default, Clone, Copy)]
+pub struct Gauge(f64);
+
+impl Gauge {
+    pub fn set(&mut self, value: f64) {
+        self.0 = value;
+    }
+
+    pub fn add(&mut self, value: f64) {
+        self.0 += value;
+    }
+
+    pub fn get(&self) -> f64 {
+        self.0
+    }
+}
+
+/// 固定桶的直方图
+#[derive(Debug, Clone)]
+pub struct Histogram {
+    /// 各桶的累计计数，与`DURATION_BUCKETS`一一对应
+    buckets: [u64; DURATION_BUCKETS.len()],
+    sum: f64,
+    count: u64,
+}
+
+impl Default for Histogram {
+    fn default() -> Self {
+        Self { buckets: [0; DURATION_BUCKETS.len()], sum: 0.0, count: 0 }
+    }
+}
+
+impl Histogram {
+    pub fn observe(&mut self, value: f64) {
+        for (bucket, bound) in self.buckets.iter_mut().zip(DURATION_BUCKETS) {
+            if value <= bound {
+                *bucket += 1;
+            }
+        }
+        self.sum += value;
+        self.count += 1;
+    }
+}
+
+/// 一个作业管道的指标
+#[derive(Debug, Default, Clone)]
+pub struct JobMetrics {
+    /// 按结束状态统计的运行次数
+    pub runs: BTreeMap<&'static str, Counter>,
+    pub records_read: Counter,
+    pub records_written: Counter,
+    pub records_failed: Counter,
+    pub retries: Counter,
+    /// 管道内提取和加载阶段的重试次数
+    pub stage_retries: Counter,
+    /// 正在运行的实例数
+    pub running: Gauge,
+    /// 最近一次成功结束的Unix时间（秒）
+    pub last_success: Gauge,
+    pub duration: Histogram,
+}
+
+/// 各作业管道的结构化指标，以Prometheus文本格式导出
+///
+/// 只保存计数和固定桶的直方图，内存占用与作业数成正比，不随运行次数增长；
+/// 错误详情记录在执行日志中。
+#[derive(Debug, Default)]
+pub struct PipelineMetrics {
+    jobs: BTreeMap<String, JobMetrics>,
+}
+
+impl PipelineMetrics {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// 记录作业开始运行
+    pub fn job_started(&mut self, job_id: &str) {
+        self.job(job_id).running.add(1.0);
+    }
+
+    /// 记录作业结束
+    pub fn job_finished(&mut self, job_id: &str, stats: &JobStats) {
+        let job = self.job(job_id);
+        if job.running.get() >= 1.0 {
+            job.running.add(-1.0);
+        }
+        job.runs.entry(status_label(&stats.status)).or_default().inc_by(1);
+        job.records_read.inc_by(stats.records_read);
+        job.records_written.inc_by(stats.records_written);
+        job.records_failed.inc_by(stats.records_failed);
+        job.retries.inc_by(stats.retry_count as u64);
+        job.stage_retries.inc_by(stats.extract_retries as u64 + stats.load_retries as u64);
+        if let Some(ms) = stats.duration_ms {
+            job.duration.observe(ms as f64 / 1000.0);
+        }
+        if stats.status == JobStatus::Success {
+            job.last_success.set(Utc::now().timestamp() as f64);
+        }
+    }
+
+    /// 获取作业的指标
+    pub fn get(&self, job_id: &str) -> Option<&JobMetrics> {
+        self.jobs.get(job_id)
+    }
+
+    /// 以Prometheus文本格式输出，指标名以`prefix`开头
+    pub fn render_prometheus(&self, prefix: &str) -> String {
+        let mut out = String::new();
+
+        let _ = writeln!(out, "# HELP {}_job_runs_total Finished job runs per status", prefix);
+        let _ = writeln!(out, "# TYPE {}_job_runs_total counter", prefix);
+        for (job_id, job) in &self.jobs {
+            for (status, count) in &job.runs {
+                let _ = writeln!(out, "{}_job_runs_total{{job=\"{}\",status=\"{}\"}} {}", prefix, escape_label(job_id), status, count.get());
+            }
+        }
+
+        let counters: [(&str, &str, fn(&JobMetrics) -> u64); 5] = [
+            ("records_read_total", "Records read from the source", |j| j.records_read.get()),
+            ("records_written_total", "Records written to the sink", |j| j.records_written.get()),
+            ("records_failed_total", "Records that failed to process", |j| j.records_failed.get()),
+            ("retries_total", "Job run retries after a failed attempt", |j| j.retries.get()),
+            ("stage_retries_total", "Extract and load retries inside pipeline runs", |j| j.stage_retries.get()),
+        ];
+        for (name, help, value) in counters {
+            let _ = writeln!(out, "# HELP {}_job_{} {}", prefix, name, help);
+            let _ = writeln!(out, "# TYPE {}_job_{} counter", prefix, name);
+            for (job_id, job) in &self.jobs {
+                let _ = writeln!(out, "{}_job_{}{{job=\"{}\"}} {}", prefix, name, escape_label(job_id), value(job));
+            }
+        }
+
+        let gauges: [(&str, &str, fn(&JobMetrics) -> f64); 2] = [
+            ("running", "Job runs in progress", |j| j.running.get()),
+            ("last_success_timestamp_seconds", "Unix time of the last successful run", |j| j.last_success.get()),
+        ];
+        for (name, help, value) in gauges {
+            let _ = writeln!(out, "# HELP {}_job_{} {}", prefix, name, help);
+            let _ = writeln!(out, "# TYPE {}_job_{} gauge", prefix, name);
+            for (job_id, job) in &self.jobs {
+                let _ = writeln!(out, "{}_job_{}{{job=\"{}\"}} {}", prefix, name, escape_label(job_id), value(job));
+            }
+        }
+
+        let _ = writeln!(out, "# HELP {}_job_duration_seconds Job run duration", prefix);
+        let _ = writeln!(out, "# TYPE {}_job_duration_seconds histogram", prefix);
+        for (job_id, job) in &self.jobs {
+            let label = escape_label(job_id);
+            for (count, bound) in job.duration.buckets.iter().zip(DURATION_BUCKETS) {
+                let _ = writeln!(out, "{}_job_duration_seconds_bucket{{job=\"{}\",le=\"{}\"}} {}", prefix, label, bound, count);
+            }
+            let _ = writeln!(out, "{}_job_duration_seconds_bucket{{job=\"{}\",le=\"+Inf\"}} {}", prefix, label, job.duration.count);
+            let _ = writeln!(out, "{}_job_duration_seconds_sum{{job=\"{}\"}} {}", prefix, label, job.duration.sum);
+            let _ = writeln!(out, "{}_job_duration_seconds_count{{job=\"{}\"}} {}", prefix, label, job.duration.count);
+        }
+
+        out
+    }
+
+    fn job(&mut self, job_id: &str) -> &mut JobMetrics {
+        if !self.jobs.contains_key(job_id) {
+            self.jobs.insert(job_id.to_string(), JobMetrics::default());
+        }
+        self.jobs.get_mut(job_id).unwrap()
+    }
+}
+
+fn status_label(status: &JobStatus) -> &'static str {
+    match status {
+        JobStatus::Pending => "pending",
+        JobStatus::Running => "running",
+        JobStatus::Success => "success",
+        JobStatus::Failed => "failed",
+        JobStatus::Skipped => "skipped",
+        JobStatus::Cancelled => "cancelled",
+    }
+}
+
+fn escape_label(value: &str) -> String {
+    value.replace('\\', "\\\\").replace('"', "\\\"").replace('\n', "\\n")
+}
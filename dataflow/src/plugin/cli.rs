@@ -41,7 +41,7 @@ fn list_plugins(matches: &ArgMatches) -> Result<(), String> {
         }
         
         for (name, plugin) in plugins {
-            let metadata = plugin.instance.metadata();
+            let metadata = &plugin.metadata;
             println!("- {} v{} ({})", name, metadata.version, metadata.description);
         }
         
@@ -86,8 +86,8 @@ fn show_plugin_info(matches: &ArgMatches) -> Result<(), String> {
     with_plugin_manager(|manager| {
         match manager.get_plugin(name) {
             Some(plugin) => {
-                let metadata = plugin.instance.metadata();
-                let plugin_type = plugin.instance.get_type();
+                let metadata = &plugin.metadata;
+                let plugin_type = plugin.plugin_type;
                 
                 println!("插件信息:");
                 println!("  名称: {}", metadata.name);
@@ -20,18 +20,18 @@ pub fn create_plugin_extractor(extractor_type: &str, options: HashMap<String, Va
     // 先检查是否有匹配的插件
     let plugin_result = with_plugin_manager(|manager| {
         for plugin in manager.get_plugins_by_type(PluginType::Extractor) {
-            let metadata = plugin.instance.metadata();
+            let metadata = &plugin.metadata;
             let plugin_id = format!("plugin:{}", metadata.name);
             
             if extractor_type == plugin_id || extractor_type == metadata.name {
                 debug!("找到匹配的提取器插件：{}", metadata.name);
                 
                 // 处理配置
-                let processed_options = match plugin.instance.process_config(options.clone()) {
+                let processed_options = match plugin.process_config(options.clone()) {
                     Ok(opts) => opts,
                     Err(e) => {
-                        error!("插件配置处理失败：{}", e);
-                        return Err(ETLError::ConfigError(format!("插件配置处理失败：{}", e)));
+                        error!("{}", e);
+                        return Err(ETLError::ConfigError(e.to_string()));
                     }
                 };
                 
@@ -64,18 +64,18 @@ pub fn create_plugin_transformer(transformer_type: &str, options: HashMap<String
     // 先检查是否有匹配的插件
     let plugin_result = with_plugin_manager(|manager| {
         for plugin in manager.get_plugins_by_type(PluginType::Transformer) {
-            let metadata = plugin.instance.metadata();
+            let metadata = &plugin.metadata;
             let plugin_id = format!("plugin:{}", metadata.name);
             
             if transformer_type == plugin_id || transformer_type == metadata.name {
                 debug!("找到匹配的转换器插件：{}", metadata.name);
                 
                 // 处理配置
-                let processed_options = match plugin.instance.process_config(options.clone()) {
+                let processed_options = match plugin.process_config(options.clone()) {
                     Ok(opts) => opts,
                     Err(e) => {
-                        error!("插件配置处理失败：{}", e);
-                        return Err(ETLError::ConfigError(format!("插件配置处理失败：{}", e)));
+                        error!("{}", e);
+                        return Err(ETLError::ConfigError(e.to_string()));
                     }
                 };
                 
@@ -108,18 +108,18 @@ pub fn create_plugin_loader(loader_type: &str, options: HashMap<String, Value>)
     // 先检查是否有匹配的插件
     let plugin_result = with_plugin_manager(|manager| {
         for plugin in manager.get_plugins_by_type(PluginType::Loader) {
-            let metadata = plugin.instance.metadata();
+            let metadata = &plugin.metadata;
             let plugin_id = format!("plugin:{}", metadata.name);
             
             if loader_type == plugin_id || loader_type == metadata.name {
                 debug!("找到匹配的加载器插件：{}", metadata.name);
                 
                 // 处理配置
-                let processed_options = match plugin.instance.process_config(options.clone()) {
+                let processed_options = match plugin.process_config(options.clone()) {
                     Ok(opts) => opts,
                     Err(e) => {
-                        error!("插件配置处理失败：{}", e);
-                        return Err(ETLError::ConfigError(format!("插件配置处理失败：{}", e)));
+                        error!("{}", e);
+                        return Err(ETLError::ConfigError(e.to_string()));
                     }
                 };
                 
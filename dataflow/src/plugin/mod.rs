@@ -14,6 +14,7 @@ use serde_json::Value;
 use actix::prelude::*;
 use lazy_static::lazy_static;
 use std::ffi::OsStr;
+use std::panic::{self, AssertUnwindSafe};
 use anyhow::{anyhow, Result};
 use wasmtime::*;
 
@@ -46,6 +47,36 @@ pub enum PluginError {
     
     #[error("插件已存在：{0}")]
     PluginAlreadyExists(String),
+    
+    #[error("插件发生panic：{0}")]
+    Panicked(String),
+    
+    #[error("插件初始化失败：{0}")]
+    InitError(String),
+    
+    #[error("插件配置处理失败：{0}")]
+    ConfigError(String),
+}
+
+/// 当前框架版本，用于检查插件兼容性
+pub const FRAMEWORK_VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// 调用插件代码并捕获其中的panic，避免插件错误导致宿主进程崩溃
+pub fn catch_plugin_panic<F, R>(name: &str, f: F) -> Result<R, PluginError>
+where
+    F: FnOnce() -> R,
+{
+    panic::catch_unwind(AssertUnwindSafe(f)).map_err(|payload| {
+        let message = if let Some(s) = payload.downcast_ref::<&str>() {
+            s.to_string()
+        } else if let Some(s) = payload.downcast_ref::<String>() {
+            s.clone()
+        } else {
+            "unknown panic".to_string()
+        };
+        error!("插件 {} 发生panic：{}", name, message);
+        PluginError::Panicked(format!("{}: {}", name, message))
+    })
 }
 
 /// 插件类型枚举
@@ -93,11 +124,26 @@ pub trait Plugin: Send {
 pub type PluginCreateFn = unsafe fn() -> *mut dyn Plugin;
 
 /// 已加载的插件
+///
+/// 元数据和类型在加载时读取一次；调用插件代码的方法都捕获panic。
+/// 字段按释放顺序排列，插件实例在插件库之前释放。
 pub struct LoadedPlugin {
-    /// 插件库
-    pub library: Library,
     /// 插件实例
     pub instance: Box<dyn Plugin>,
+    /// 插件元数据
+    pub metadata: PluginMetadata,
+    /// 插件类型
+    pub plugin_type: PluginType,
+    /// 插件库
+    pub library: Library,
+}
+
+impl LoadedPlugin {
+    /// 由插件处理配置参数
+    pub fn process_config(&self, config: HashMap<String, Value>) -> Result<HashMap<String, Value>, PluginError> {
+        catch_plugin_panic(&self.metadata.name, || self.instance.process_config(config))?
+            .map_err(PluginError::ConfigError)
+    }
 }
 
 /// 插件管理器
@@ -154,39 +200,79 @@ impl PluginManager {
             }
         };
         
-        // 创建插件实例
-        let instance = unsafe { create_fn() };
+        // 创建插件实例，插件代码中的panic不会传播到宿主
+        let path_name = path.display().to_string();
+        let mut instance = catch_plugin_panic(&path_name, || unsafe { create_fn() })?;
         
-        // 获取插件元数据
-        let metadata = instance.metadata();
+        // 检查失败时释放实例，其Drop同样捕获panic
+        let (metadata, plugin_type) = match self.check_and_init(&mut instance, &path_name) {
+            Ok(checked) => checked,
+            Err(e) => {
+                if let Err(drop_error) = catch_plugin_panic(&path_name, || drop(instance)) {
+                    warn!("插件释放错误：{}", drop_error);
+                }
+                return Err(e);
+            }
+        };
         let name = metadata.name.clone();
         
-        // 检查是否已存在同名插件
-        if self.plugins.contains_key(&name) {
-            return Err(PluginError::PluginAlreadyExists(name));
-        }
-        
         // 添加到已加载插件
         info!("成功加载插件：{} v{}", metadata.name, metadata.version);
         self.plugins.insert(name.clone(), LoadedPlugin {
-            library,
             instance,
+            metadata,
+            plugin_type,
+            library,
         });
         
         Ok(())
     }
     
+    /// 读取插件元数据，检查名称和框架版本后初始化插件
+    fn check_and_init(&self, instance: &mut Box<dyn Plugin>, path_name: &str) -> Result<(PluginMetadata, PluginType), PluginError> {
+        let metadata = catch_plugin_panic(path_name, || instance.metadata())?;
+        let plugin_type = catch_plugin_panic(path_name, || instance.get_type())?;
+        let name = metadata.name.clone();
+        
+        // 检查是否已存在同名插件
+        if self.plugins.contains_key(&name) {
+            return Err(PluginError::PluginAlreadyExists(name));
+        }
+        
+        // 检查插件要求的框架版本
+        if let Err(e) = metadata.check_compatibility(FRAMEWORK_VERSION) {
+            warn!("插件 {} 版本不兼容：{}", name, e);
+            return Err(PluginError::VersionMismatch(
+                metadata.min_framework_version.clone(),
+                FRAMEWORK_VERSION.to_string(),
+            ));
+        }
+        
+        // 初始化插件
+        catch_plugin_panic(&name, || instance.init())?
+            .map_err(PluginError::InitError)?;
+        
+        Ok((metadata, plugin_type))
+    }
+    
     /// 卸载插件
     pub fn unload_plugin(&mut self, name: &str) -> Result<(), PluginError> {
         debug!("卸载插件：{}", name);
         
         if let Some(mut plugin) = self.plugins.remove(name) {
-            if let Err(e) = plugin.instance.shutdown() {
-                warn!("插件卸载错误：{}", e);
+            match catch_plugin_panic(name, || plugin.instance.shutdown()) {
+                Ok(Ok(())) => {}
                 // 不返回错误，因为即使shutdown失败，我们也需要卸载它
+                Ok(Err(e)) => warn!("插件卸载错误：{}", e),
+                Err(e) => warn!("插件卸载错误：{}", e),
             }
             
-            // 插件会在LoadedPlugin被丢弃时自动关闭库文件
+            // 先释放插件实例（其Drop也是插件代码），再关闭库文件
+            let LoadedPlugin { instance, library, .. } = plugin;
+            if let Err(e) = catch_plugin_panic(name, || drop(instance)) {
+                warn!("插件卸载错误：{}", e);
+            }
+            drop(library);
             info!("成功卸载插件：{}", name);
             Ok(())
         } else {
@@ -249,7 +335,7 @@ impl PluginManager {
     pub fn get_plugins_by_type(&self, plugin_type: PluginType) -> HashMap<String, &LoadedPlugin> {
         self.plugins.iter()
             .filter(|(_, plugin)| {
-                let p_type = plugin.instance.get_type();
+                let p_type = plugin.plugin_type;
                 p_type == plugin_type || p_type == PluginType::All || plugin_type == PluginType::All
             })
             .map(|(k, v)| (k.clone(), v))
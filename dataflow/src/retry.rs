@@ -0,0 +1,285 @@
+//! 管道提取和加载阶段的重试策略
+//!
+//! 数据库锁、连接中断、超时等暂时性错误按指数退避重试，其他错误直接失败。
+//! 错误是否暂时由产生错误的提取器或加载器判断：它们按错误码、HTTP状态码
+//! 或错误类型用[`transient`]给错误消息加上种类标记，重试策略只看标记，
+//! 不按消息内容猜测。
+
+use std::fmt::Display;
+use std::future::Future;
+use std::time::Duration;
+use log::warn;
+use serde::{Deserialize, Serialize};
+
+/// 错误消息中种类标记的前缀，完整形式为`[transient:种类]`
+const TRANSIENT_TAG: &str = "[transient:";
+
+/// 暂时性错误的种类
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ErrorKind {
+    /// 数据库忙或被锁定
+    Busy,
+    /// 连接失败或中断
+    Connection,
+    /// 请求或获取连接超时
+    Timeout,
+    /// 被限流（HTTP 429）
+    RateLimited,
+    /// 服务暂时不可用（HTTP 502、503、504）
+    Unavailable,
+}
+
+impl ErrorKind {
+    const ALL: [ErrorKind; 5] = [
+        ErrorKind::Busy,
+        ErrorKind::Connection,
+        ErrorKind::Timeout,
+        ErrorKind::RateLimited,
+        ErrorKind::Unavailable,
+    ];
+
+    /// 配置和错误标记中使用的名称
+    pub fn name(&self) -> &'static str {
+        match self {
+            ErrorKind::Busy => "busy",
+            ErrorKind::Connection => "connection",
+            ErrorKind::Timeout => "timeout",
+            ErrorKind::RateLimited => "rate_limited",
+            ErrorKind::Unavailable => "unavailable",
+        }
+    }
+
+    /// HTTP状态码对应的暂时性错误种类
+    pub fn from_status(status: u16) -> Option<Self> {
+        match status {
+            408 => Some(ErrorKind::Timeout),
+            429 => Some(ErrorKind::RateLimited),
+            502 | 503 | 504 => Some(ErrorKind::Unavailable),
+            _ => None,
+        }
+    }
+
+    /// SQLite错误对应的暂时性错误种类
+    pub fn from_sqlite(error: &rusqlite::Error) -> Option<Self> {
+        match error.sqlite_error_code() {
+            Some(rusqlite::ErrorCode::DatabaseBusy) | Some(rusqlite::ErrorCode::DatabaseLocked) => Some(ErrorKind::Busy),
+            _ => None,
+        }
+    }
+
+    /// HTTP客户端错误对应的暂时性错误种类
+    pub fn from_reqwest(error: &reqwest::Error) -> Option<Self> {
+        if error.is_timeout() {
+            Some(ErrorKind::Timeout)
+        } else if error.is_connect() {
+            Some(ErrorKind::Connection)
+        } else {
+            error.status().and_then(|status| Self::from_status(status.as_u16()))
+        }
+    }
+
+    /// sqlx错误对应的暂时性错误种类
+    #[cfg(feature = "sql")]
+    pub fn from_sqlx(error: &sqlx::Error) -> Option<Self> {
+        match error {
+            sqlx::Error::Io(_) | sqlx::Error::PoolClosed | sqlx::Error::WorkerCrashed => Some(ErrorKind::Connection),
+            sqlx::Error::PoolTimedOut => Some(ErrorKind::Timeout),
+            _ => None,
+        }
+    }
+
+    /// 从错误消息中的种类标记解析
+    pub fn of(error: &str) -> Option<Self> {
+        let start = error.find(TRANSIENT_TAG)? + TRANSIENT_TAG.len();
+        let name = &error[start..start + error[start..].find(']')?];
+        Self::ALL.iter().copied().find(|kind| kind.name() == name)
+    }
+}
+
+/// 给错误消息加上暂时性错误种类标记，`kind`为空时原样返回
+pub fn transient(kind: Option<ErrorKind>, message: impl Display) -> String {
+    match kind {
+        Some(kind) => format!("{}{}] {}", TRANSIENT_TAG, kind.name(), message),
+        None => message.to_string(),
+    }
+}
+
+/// 去掉错误消息中的种类标记，用于已经无法安全重试的错误（如部分写入后失败）
+pub fn permanent(error: &str) -> String {
+    match ErrorKind::of(error) {
+        Some(kind) => error.replacen(&format!("{}{}] ", TRANSIENT_TAG, kind.name()), "", 1),
+        None => error.to_string(),
+    }
+}
+
+/// 重试策略
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RetryPolicy {
+    /// 最多尝试次数（包括第一次）
+    #[serde(default = "default_max_attempts")]
+    pub max_attempts: u32,
+    /// 第一次重试前的等待时间（毫秒）
+    #[serde(default = "default_initial_backoff_ms")]
+    pub initial_backoff_ms: u64,
+    /// 最长等待时间（毫秒）
+    #[serde(default = "default_max_backoff_ms")]
+    pub max_backoff_ms: u64,
+    /// 每次重试后等待时间的倍数
+    #[serde(default = "default_backoff_factor")]
+    pub backoff_factor: f64,
+    /// 重试的暂时性错误种类（busy、connection、timeout、rate_limited、unavailable），
+    /// 为空时重试所有种类
+    #[serde(default)]
+    pub retryable_errors: Vec<String>,
+}
+
+fn default_max_attempts() -> u32 {
+    3
+}
+
+fn default_initial_backoff_ms() -> u64 {
+    200
+}
+
+fn default_max_backoff_ms() -> u64 {
+    10_000
+}
+
+fn default_backoff_factor() -> f64 {
+    2.0
+}
+
+impl Default for RetryPolicy {
+    fn default() -> Self {
+        Self {
+            max_attempts: default_max_attempts(),
+            initial_backoff_ms: default_initial_backoff_ms(),
+            max_backoff_ms: default_max_backoff_ms(),
+            backoff_factor: default_backoff_factor(),
+            retryable_errors: Vec::new(),
+        }
+    }
+}
+
+impl RetryPolicy {
+    /// 错误是否为策略允许重试的暂时性错误
+    pub fn is_retryable(&self, error: &str) -> bool {
+        match ErrorKind::of(error) {
+            Some(kind) => self.retryable_errors.is_empty()
+                || self.retryable_errors.iter().any(|name| name.eq_ignore_ascii_case(kind.name())),
+            None => false,
+        }
+    }
+
+    /// 第`retry`次重试（从1开始）前的等待时间
+    pub fn backoff(&self, retry: u32) -> Duration {
+        let factor = self.backoff_factor.max(1.0).powi(retry.saturating_sub(1) as i32);
+        let delay = (self.initial_backoff_ms as f64 * factor).min(self.max_backoff_ms as f64);
+        Duration::from_millis(delay as u64)
+    }
+
+    /// 执行`operation`，暂时性错误时按策略重试，返回结果和重试次数
+    pub async fn run<T, F, Fut>(&self, name: &str, mut operation: F) -> (Result<T, String>, u32)
+    where
+        F: FnMut() -> Fut,
+        Fut: Future<Output = Result<T, String>>,
+    {
+        let mut retries = 0;
+        loop {
+            match operation().await {
+                Ok(value) => return (Ok(value), retries),
+                Err(e) if retries + 1 < self.max_attempts && self.is_retryable(&e) => {
+                    retries += 1;
+                    let delay = self.backoff(retries);
+                    warn!("{}失败，{}毫秒后第{}次重试: {}", name, delay.as_millis(), retries, e);
+                    tokio::time::sleep(delay).await;
+                },
+                Err(e) => return (Err(e), retries),
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_backoff_grows_and_caps() {
+        let policy = RetryPolicy::default();
+        assert_eq!(policy.backoff(1), Duration::from_millis(200));
+        assert_eq!(policy.backoff(2), Duration::from_millis(400));
+        assert_eq!(policy.backoff(3), Duration::from_millis(800));
+        assert_eq!(policy.backoff(7), Duration::from_millis(10_000));
+        assert_eq!(policy.backoff(100), Duration::from_millis(10_000));
+
+        // 小于1的倍数按1处理，等待时间不会缩短
+        let policy = RetryPolicy { backoff_factor: 0.5, ..RetryPolicy::default() };
+        assert_eq!(policy.backoff(5), Duration::from_millis(200));
+    }
+
+    #[test]
+    fn test_error_kind_tag() {
+        let error = transient(Some(ErrorKind::RateLimited), "HTTP 429");
+        assert_eq!(error, "[transient:rate_limited] HTTP 429");
+        assert_eq!(ErrorKind::of(&error), Some(ErrorKind::RateLimited));
+        assert_eq!(ErrorKind::of(&format!("加载失败: {}", error)), Some(ErrorKind::RateLimited));
+        assert_eq!(transient(None, "HTTP 400"), "HTTP 400");
+
+        // 只认标记，不按消息内容猜测
+        assert_eq!(ErrorKind::of("database is locked, timeout"), None);
+        assert_eq!(ErrorKind::of("[transient:unknown] x"), None);
+        assert_eq!(ErrorKind::of("[transient:busy"), None);
+
+        assert_eq!(permanent(&error), "HTTP 429");
+        assert_eq!(ErrorKind::of(&permanent(&error)), None);
+        assert_eq!(permanent("HTTP 400"), "HTTP 400");
+    }
+
+    #[test]
+    fn test_retryable_errors_filter() {
+        let busy = transient(Some(ErrorKind::Busy), "database is locked");
+        let timeout = transient(Some(ErrorKind::Timeout), "timed out");
+
+        let policy = RetryPolicy::default();
+        assert!(policy.is_retryable(&busy));
+        assert!(policy.is_retryable(&timeout));
+        assert!(!policy.is_retryable("no such table: t"));
+
+        let policy = RetryPolicy { retryable_errors: vec!["BUSY".to_string()], ..RetryPolicy::default() };
+        assert!(policy.is_retryable(&busy));
+        assert!(!policy.is_retryable(&timeout));
+    }
+
+    #[tokio::test]
+    async fn test_run_stops_at_max_attempts() {
+        let policy = RetryPolicy { initial_backoff_ms: 1, max_backoff_ms: 1, ..RetryPolicy::default() };
+
+        let mut calls = 0;
+        let (result, retries) = policy.run("提取", || {
+            calls += 1;
+            async { Err::<(), _>(transient(Some(ErrorKind::Connection), "reset")) }
+        }).await;
+        assert!(result.is_err());
+        assert_eq!((calls, retries), (3, 2));
+
+        // 非暂时性错误不重试
+        let mut calls = 0;
+        let (result, retries) = policy.run("加载", || {
+            calls += 1;
+            async { Err::<(), _>("constraint failed".to_string()) }
+        }).await;
+        assert!(result.is_err());
+        assert_eq!((calls, retries), (1, 0));
+
+        let mut calls = 0;
+        let (result, retries) = policy.run("加载", || {
+            calls += 1;
+            let attempt = calls;
+            async move {
+                if attempt < 2 { Err(transient(Some(ErrorKind::Busy), "locked")) } else { Ok(attempt) }
+            }
+        }).await;
+        assert_eq!((result, retries), (Ok(2), 1));
+    }
+}
@@ -0,0 +1,349 @@
+//! 管道定义和执行记录的持久化
+//!
+//! 管道定义的各个版本、执行记录（状态和各作业的统计信息）以及执行日志保存在
+//! SQLite数据库中，服务重启后据此恢复版本历史并继续未完成的执行。
+
+use std::path::Path;
+use std::sync::Mutex;
+use chrono::{DateTime, SecondsFormat, Utc};
+use rusqlite::{params, Connection, OptionalExtension};
+use rusqlite::types::Value as SqlValue;
+use serde::{Deserialize, Serialize};
+
+use crate::types::ExecutionStatus;
+use crate::versions::ConfigVersion;
+
+const SCHEMA: &str = "
+    CREATE TABLE IF NOT EXISTS pipeline_versions (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        config TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        rollback_of INTEGER
+    );
+    CREATE TABLE IF NOT EXISTS executions (
+        execution_id TEXT PRIMARY KEY,
+        status TEXT NOT NULL,
+        config_version INTEGER,
+        start_time TEXT NOT NULL,
+        end_time TEXT,
+        updated_at TEXT NOT NULL,
+        record TEXT NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS executions_status ON executions(status, start_time);
+    CREATE TABLE IF NOT EXISTS execution_logs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        execution_id TEXT NOT NULL,
+        level TEXT NOT NULL,
+        message TEXT NOT NULL,
+        logged_at TEXT NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS execution_logs_execution ON execution_logs(execution_id, id);
+";
+
+/// 保存的执行记录
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExecutionRecord {
+    #[serde(flatten)]
+    pub execution: ExecutionStatus,
+    /// 执行使用的管道定义版本
+    pub config_version: Option<u32>,
+    /// 最后一次更新时间
+    pub updated_at: DateTime<Utc>,
+}
+
+/// 执行日志
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExecutionLog {
+    pub level: String,
+    pub message: String,
+    pub logged_at: DateTime<Utc>,
+}
+
+/// 列出执行记录的过滤条件
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ExecutionFilter {
+    /// 执行状态：running、paused、draining、success、failed、cancelled或drained
+    pub status: Option<String>,
+    /// 管道定义版本
+    pub config_version: Option<u32>,
+    /// 只包括此时间之后开始的执行
+    pub since: Option<DateTime<Utc>>,
+    /// 只包括此时间之前开始的执行
+    pub until: Option<DateTime<Utc>>,
+    /// 最多返回的记录数
+    pub limit: Option<usize>,
+    /// 跳过的记录数
+    pub offset: Option<usize>,
+}
+
+impl ExecutionFilter {
+    /// 执行记录是否满足过滤条件（不考虑分页）
+    pub fn matches(&self, record: &ExecutionRecord) -> bool {
+        self.status.as_ref().map_or(true, |s| s == &record.execution.status)
+            && self.config_version.map_or(true, |v| Some(v) == record.config_version)
+            && self.since.map_or(true, |t| record.execution.start_time >= t)
+            && self.until.map_or(true, |t| record.execution.start_time < t)
+    }
+
+    /// 在内存中过滤执行记录，按开始时间倒序排列并分页
+    pub fn apply(&self, records: Vec<ExecutionRecord>) -> Vec<ExecutionRecord> {
+        let mut records: Vec<ExecutionRecord> = records.into_iter()
+            .filter(|r| self.matches(r))
+            .collect();
+        records.sort_by(|a, b| b.execution.start_time.cmp(&a.execution.start_time));
+        records.into_iter()
+            .skip(self.offset.unwrap_or(0))
+            .take(self.limit.unwrap_or(usize::MAX))
+            .collect()
+    }
+}
+
+/// 基于SQLite的管道定义和执行记录存储
+pub struct ExecutionStore {
+    conn: Mutex<Connection>,
+}
+
+impl ExecutionStore {
+    /// 打开或创建存储数据库
+    pub fn open<P: AsRef<Path>>(path: P) -> Result<Self, String> {
+        let conn = Connection::open(path.as_ref())
+            .map_err(|e| format!("无法打开执行记录数据库: {}", e))?;
+        Self::init(conn)
+    }
+
+    /// 创建内存中的存储，用于未配置数据库路径的情况
+    pub fn in_memory() -> Result<Self, String> {
+        let conn = Connection::open_in_memory()
+            .map_err(|e| format!("无法创建执行记录数据库: {}", e))?;
+        Self::init(conn)
+    }
+
+    fn init(conn: Connection) -> Result<Self, String> {
+        conn.execute_batch("PRAGMA journal_mode=WAL;")
+            .map_err(|e| format!("设置数据库模式失败: {}", e))?;
+        conn.execute_batch(SCHEMA)
+            .map_err(|e| format!("创建执行记录表失败: {}", e))?;
+        Ok(Self { conn: Mutex::new(conn) })
+    }
+
+    /// 保存管道定义版本
+    pub fn save_version(&self, version: &ConfigVersion) -> Result<(), String> {
+        let config = serde_json::to_string(&version.config)
+            .map_err(|e| format!("序列化管道定义失败: {}", e))?;
+        let conn = self.conn.lock().unwrap();
+        conn.execute(
+            "INSERT OR REPLACE INTO pipeline_versions (version, name, config, created_at, rollback_of)
+             VALUES (?1, ?2, ?3, ?4, ?5)",
+            params![version.version, version.config.name, config, timestamp(&version.created_at), version.rollback_of],
+        ).map_err(|e| format!("保存管道定义版本失败: {}", e))?;
+        Ok(())
+    }
+
+    /// 按版本号升序读取所有管道定义版本
+    pub fn load_versions(&self) -> Result<Vec<ConfigVersion>, String> {
+        let conn = self.conn.lock().unwrap();
+        let mut stmt = conn.prepare(
+            "SELECT version, config, created_at, rollback_of FROM pipeline_versions ORDER BY version",
+        ).map_err(|e| format!("读取管道定义版本失败: {}", e))?;
+        let rows = stmt.query_map([], |row| {
+            Ok((row.get::<_, u32>(0)?, row.get::<_, String>(1)?, row.get::<_, String>(2)?, row.get::<_, Option<u32>>(3)?))
+        }).map_err(|e| format!("读取管道定义版本失败: {}", e))?;
+
+        let mut versions = Vec::new();
+        for row in rows {
+            let (version, config, created_at, rollback_of) = row
+                .map_err(|e| format!("读取管道定义版本失败: {}", e))?;
+            versions.push(ConfigVersion {
+                version,
+                config: serde_json::from_str(&config)
+                    .map_err(|e| format!("解析管道定义版本{}失败: {}", version, e))?,
+                created_at: parse_timestamp(&created_at)?,
+                rollback_of,
+            });
+        }
+        Ok(versions)
+    }
+
+    /// 保存或更新执行记录
+    pub fn save_execution(&self, execution: &ExecutionStatus, config_version: Option<u32>) -> Result<(), String> {
+        let record = serde_json::to_string(execution)
+            .map_err(|e| format!("序列化执行记录失败: {}", e))?;
+        let conn = self.conn.lock().unwrap();
+        conn.execute(
+            "INSERT OR REPLACE INTO executions
+             (execution_id, status, config_version, start_time, end_time, updated_at, record)
+             VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)",
+            params![
+                execution.execution_id,
+                execution.status,
+                config_version,
+                timestamp(&execution.start_time),
+                execution.end_time.as_ref().map(timestamp),
+                timestamp(&Utc::now()),
+                record,
+            ],
+        ).map_err(|e| format!("保存执行记录失败: {}", e))?;
+        Ok(())
+    }
+
+    /// 获取执行记录
+    pub fn get_execution(&self, execution_id: &str) -> Result<Option<ExecutionRecord>, String> {
+        let conn = self.conn.lock().unwrap();
+        let row = conn.query_row(
+            "SELECT record, config_version, updated_at FROM executions WHERE execution_id = ?1",
+            params![execution_id],
+            |row| Ok((row.get::<_, String>(0)?, row.get::<_, Option<u32>>(1)?, row.get::<_, String>(2)?)),
+        ).optional().map_err(|e| format!("读取执行记录失败: {}", e))?;
+
+        row.map(|(record, config_version, updated_at)| to_record(&record, config_version, &updated_at))
+            .transpose()
+    }
+
+    /// 按过滤条件列出执行记录，按开始时间倒序排列
+    pub fn list_executions(&self, filter: &ExecutionFilter) -> Result<Vec<ExecutionRecord>, String> {
+        let mut conditions = Vec::new();
+        let mut bound = Vec::new();
+        if let Some(status) = &filter.status {
+            bound.push(SqlValue::Text(status.clone()));
+            conditions.push(format!("status = ?{}", bound.len()));
+        }
+        if let Some(version) = filter.config_version {
+            bound.push(SqlValue::Integer(version as i64));
+            conditions.push(format!("config_version = ?{}", bound.len()));
+        }
+        if let Some(since) = &filter.since {
+            bound.push(SqlValue::Text(timestamp(since)));
+            conditions.push(format!("start_time >= ?{}", bound.len()));
+        }
+        if let Some(until) = &filter.until {
+            bound.push(SqlValue::Text(timestamp(until)));
+            conditions.push(format!("start_time < ?{}", bound.len()));
+        }
+
+        let mut sql = "SELECT record, config_version, updated_at FROM executions".to_string();
+        if !conditions.is_empty() {
+            sql.push_str(" WHERE ");
+            sql.push_str(&conditions.join(" AND "));
+        }
+        sql.push_str(" ORDER BY start_time DESC");
+        sql.push_str(&format!(
+            " LIMIT {} OFFSET {}",
+            filter.limit.map_or(-1, |l| l as i64),
+            filter.offset.unwrap_or(0)
+        ));
+
+        let conn = self.conn.lock().unwrap();
+        let mut stmt = conn.prepare(&sql)
+            .map_err(|e| format!("读取执行记录失败: {}", e))?;
+        let rows = stmt.query_map(rusqlite::params_from_iter(bound.iter()), |row| {
+            Ok((row.get::<_, String>(0)?, row.get::<_, Option<u32>>(1)?, row.get::<_, String>(2)?))
+        }).map_err(|e| format!("读取执行记录失败: {}", e))?;
+
+        let mut records = Vec::new();
+        for row in rows {
+            let (record, config_version, updated_at) = row
+                .map_err(|e| format!("读取执行记录失败: {}", e))?;
+            records.push(to_record(&record, config_version, &updated_at)?);
+        }
+        Ok(records)
+    }
+
+    /// 列出服务停止时尚未结束的执行，包括暂停和正在排空的执行
+    pub fn incomplete_executions(&self) -> Result<Vec<ExecutionRecord>, String> {
+        let mut records = Vec::new();
+        for status in ["running", "paused", "draining"] {
+            records.extend(self.list_executions(&ExecutionFilter {
+                status: Some(status.to_string()),
+                ..ExecutionFilter::default()
+            })?);
+        }
+        Ok(records)
+    }
+
+    /// 追加执行日志
+    pub fn append_log(&self, execution_id: &str, level: &str, message: &str) -> Result<(), String> {
+        let conn = self.conn.lock().unwrap();
+        conn.execute(
+            "INSERT INTO execution_logs (execution_id, level, message, logged_at) VALUES (?1, ?2, ?3, ?4)",
+            params![execution_id, level, message, timestamp(&Utc::now())],
+        ).map_err(|e| format!("保存执行日志失败: {}", e))?;
+        Ok(())
+    }
+
+    /// 删除已结束的旧执行记录及其日志，返回删除的执行数
+    ///
+    /// 只保留最近开始的`max_entries`个已结束执行，并删除开始时间早于
+    /// `max_age`的执行；未结束的执行不受影响，以便重启后继续。
+    pub fn prune(&self, max_entries: Option<usize>, max_age: Option<chrono::Duration>) -> Result<usize, String> {
+        let mut conn = self.conn.lock().unwrap();
+        let tx = conn.transaction()
+            .map_err(|e| format!("清理执行记录失败: {}", e))?;
+        tx.execute_batch("CREATE TEMP TABLE IF NOT EXISTS pruned_executions (execution_id TEXT PRIMARY KEY); DELETE FROM pruned_executions;")
+            .map_err(|e| format!("清理执行记录失败: {}", e))?;
+        if let Some(max_age) = max_age {
+            tx.execute(
+                "INSERT OR IGNORE INTO pruned_executions
+                 SELECT execution_id FROM executions WHERE status NOT IN ('running', 'paused', 'draining') AND start_time < ?1",
+                params![timestamp(&(Utc::now() - max_age))],
+            ).map_err(|e| format!("清理执行记录失败: {}", e))?;
+        }
+        if let Some(max_entries) = max_entries {
+            tx.execute(
+                "INSERT OR IGNORE INTO pruned_executions
+                 SELECT execution_id FROM executions WHERE status NOT IN ('running', 'paused', 'draining')
+                 ORDER BY start_time DESC LIMIT -1 OFFSET ?1",
+                params![max_entries as i64],
+            ).map_err(|e| format!("清理执行记录失败: {}", e))?;
+        }
+        tx.execute("DELETE FROM execution_logs WHERE execution_id IN (SELECT execution_id FROM pruned_executions)", [])
+            .map_err(|e| format!("清理执行日志失败: {}", e))?;
+        let pruned = tx.execute("DELETE FROM executions WHERE execution_id IN (SELECT execution_id FROM pruned_executions)", [])
+            .map_err(|e| format!("清理执行记录失败: {}", e))?;
+        tx.commit().map_err(|e| format!("清理执行记录失败: {}", e))?;
+        Ok(pruned)
+    }
+
+    /// 按写入顺序读取执行日志
+    pub fn logs(&self, execution_id: &str) -> Result<Vec<ExecutionLog>, String> {
+        let conn = self.conn.lock().unwrap();
+        let mut stmt = conn.prepare(
+            "SELECT level, message, logged_at FROM execution_logs WHERE execution_id = ?1 ORDER BY id",
+        ).map_err(|e| format!("读取执行日志失败: {}", e))?;
+        let rows = stmt.query_map(params![execution_id], |row| {
+            Ok((row.get::<_, String>(0)?, row.get::<_, String>(1)?, row.get::<_, String>(2)?))
+        }).map_err(|e| format!("读取执行日志失败: {}", e))?;
+
+        let mut logs = Vec::new();
+        for row in rows {
+            let (level, message, logged_at) = row
+                .map_err(|e| format!("读取执行日志失败: {}", e))?;
+            logs.push(ExecutionLog {
+                level,
+                message,
+                logged_at: parse_timestamp(&logged_at)?,
+            });
+        }
+        Ok(logs)
+    }
+}
+
+/// 统一的时间格式，保证按字符串比较与按时间比较一致
+fn timestamp(time: &DateTime<Utc>) -> String {
+    time.to_rfc3339_opts(SecondsFormat::Millis, true)
+}
+
+fn parse_timestamp(value: &str) -> Result<DateTime<Utc>, String> {
+    DateTime::parse_from_rfc3339(value)
+        .map(|t| t.with_timezone(&Utc))
+        .map_err(|e| format!("无效的时间: {}: {}", value, e))
+}
+
+fn to_record(record: &str, config_version: Option<u32>, updated_at: &str) -> Result<ExecutionRecord, String> {
+    Ok(ExecutionRecord {
+        execution: serde_json::from_str(record)
+            .map_err(|e| format!("解析执行记录失败: {}", e))?,
+        config_version,
+        updated_at: parse_timestamp(updated_at)?,
+    })
+}
@@ -0,0 +1,235 @@
+//! 管道单元测试工具
+//!
+//! 不需要部署服务或连接真实的数据源和目标：用固定的测试记录代替提取阶段，
+//! 依次运行管道中配置的转换器，记录每个转换器的输入和输出，并把本应写入
+//! 目标的记录收集起来，供测试断言。
+//!
+//! ```ignore
+//! let config = ETLConfig::from_file("pipelines/orders.yaml")?;
+//! let run = PipelineTest::from_config(&config, "clean_orders")?
+//!     .with_fixture_file("tests/fixtures/orders.jsonl")?
+//!     .run()
+//!     .await?;
+//!
+//! run.assert_sink_count(2);
+//! run.assert_step_count("filter", 2);
+//! run.assert_sink_contains("status", json!("paid"));
+//! ```
+
+use std::collections::HashMap;
+use std::path::Path;
+use chrono::Utc;
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::actors::messages::TransformData;
+use crate::config::ETLConfig;
+use crate::types::{DataRecord, PipelineConfig, TransformerConfig};
+
+/// 管道测试
+pub struct PipelineTest {
+    transformers: Vec<TransformerConfig>,
+    fixtures: Vec<DataRecord>,
+}
+
+/// 单个转换器的运行结果
+#[derive(Debug, Clone, Serialize)]
+pub struct StepResult {
+    /// 转换器在管道中的位置，从0开始
+    pub index: usize,
+    /// 转换器类型
+    pub type_name: String,
+    /// 输入记录数
+    pub input_count: usize,
+    /// 输出记录
+    pub output: Vec<DataRecord>,
+    /// 转换失败时的错误
+    pub error: Option<String>,
+}
+
+/// 管道测试的运行结果
+#[derive(Debug, Clone, Serialize)]
+pub struct TestRun {
+    /// 各转换器的运行结果，转换失败时之后的转换器不再运行
+    pub steps: Vec<StepResult>,
+    /// 写入目标的记录
+    pub sink_output: Vec<DataRecord>,
+}
+
+impl PipelineTest {
+    /// 测试管道定义
+    pub fn new(pipeline: &PipelineConfig) -> Self {
+        Self {
+            transformers: pipeline.transformers.clone(),
+            fixtures: Vec::new(),
+        }
+    }
+
+    /// 测试ETL配置中的一个作业
+    pub fn from_config(config: &ETLConfig, job_id: &str) -> Result<Self, String> {
+        config.jobs.get(job_id)
+            .map(|job| Self::new(&job.pipeline))
+            .ok_or_else(|| format!("找不到作业配置: {}", job_id))
+    }
+
+    /// 添加测试记录
+    pub fn with_records(mut self, records: Vec<DataRecord>) -> Self {
+        self.fixtures.extend(records);
+        self
+    }
+
+    /// 添加JSON对象形式的测试记录
+    pub fn with_events(mut self, events: Vec<Value>) -> Result<Self, String> {
+        for event in events {
+            self.fixtures.push(fixture_record(event)?);
+        }
+        Ok(self)
+    }
+
+    /// 从文件读取测试记录，支持JSON数组和每行一个JSON对象两种格式
+    pub fn with_fixture_file<P: AsRef<Path>>(self, path: P) -> Result<Self, String> {
+        let path = path.as_ref();
+        let content = std::fs::read_to_string(path)
+            .map_err(|e| format!("读取测试数据失败: {}: {}", path.display(), e))?;
+
+        let events = if content.trim_start().starts_with('[') {
+            serde_json::from_str::<Vec<Value>>(&content)
+                .map_err(|e| format!("解析测试数据失败: {}: {}", path.display(), e))?
+        } else {
+            content.lines()
+                .enumerate()
+                .filter(|(_, line)| !line.trim().is_empty())
+                .map(|(i, line)| serde_json::from_str(line)
+                    .map_err(|e| format!("解析测试数据失败: {}第{}行: {}", path.display(), i + 1, e)))
+                .collect::<Result<Vec<Value>, String>>()?
+        };
+        self.with_events(events)
+    }
+
+    /// 依次运行转换器，收集每个转换器的结果和写入目标的记录
+    pub async fn run(self) -> Result<TestRun, String> {
+        let mut steps = Vec::with_capacity(self.transformers.len());
+        let mut records = self.fixtures;
+
+        for (index, transformer_config) in self.transformers.iter().enumerate() {
+            let options = match &transformer_config.config {
+                Value::Object(map) => map.clone().into_iter().collect(),
+                Value::Null => HashMap::new(),
+                other => return Err(format!("转换器{}的配置不是对象: {}", index, other)),
+            };
+            let transformer = crate::transformers::factory::create_transformer(&transformer_config.type_name, options)
+                .map_err(|e| format!("创建转换器失败: {}", e))?;
+
+            let input_count = records.len();
+            let result = match transformer.send(TransformData { records: records.clone() }).await {
+                Ok(result) => result,
+                Err(e) => Err(format!("发送TransformData消息失败: {}", e)),
+            };
+
+            match result {
+                Ok(output) => {
+                    records = output.clone();
+                    steps.push(StepResult {
+                        index,
+                        type_name: transformer_config.type_name.clone(),
+                        input_count,
+                        output,
+                        error: None,
+                    });
+                },
+                Err(e) => {
+                    steps.push(StepResult {
+                        index,
+                        type_name: transformer_config.type_name.clone(),
+                        input_count,
+                        output: Vec::new(),
+                        error: Some(e),
+                    });
+                    return Ok(TestRun { steps, sink_output: Vec::new() });
+                },
+            }
+        }
+
+        Ok(TestRun { steps, sink_output: records })
+    }
+}
+
+impl TestRun {
+    /// 按类型查找第一个转换器的结果，也可以使用位置（如"0"）
+    pub fn step(&self, name: &str) -> Option<&StepResult> {
+        match name.parse::<usize>() {
+            Ok(index) => self.steps.get(index),
+            Err(_) => self.steps.iter().find(|s| s.type_name == name),
+        }
+    }
+
+    /// 所有转换器都运行成功
+    pub fn succeeded(&self) -> bool {
+        self.steps.iter().all(|s| s.error.is_none())
+    }
+
+    /// 写入目标的记录数据
+    pub fn sink_data(&self) -> Vec<&HashMap<String, Value>> {
+        self.sink_output.iter().map(|r| &r.data).collect()
+    }
+
+    /// 断言所有转换器都运行成功
+    pub fn assert_succeeded(&self) {
+        if let Some(step) = self.steps.iter().find(|s| s.error.is_some()) {
+            panic!("转换器{}（{}）失败: {}", step.index, step.type_name, step.error.as_ref().unwrap());
+        }
+    }
+
+    /// 断言写入目标的记录数
+    pub fn assert_sink_count(&self, expected: usize) {
+        self.assert_succeeded();
+        assert_eq!(self.sink_output.len(), expected, "写入目标的记录数不符");
+    }
+
+    /// 断言转换器的输出记录数
+    pub fn assert_step_count(&self, name: &str, expected: usize) {
+        let step = self.step(name).unwrap_or_else(|| panic!("找不到转换器: {}", name));
+        assert!(step.error.is_none(), "转换器{}失败: {}", name, step.error.as_ref().unwrap());
+        assert_eq!(step.output.len(), expected, "转换器{}的输出记录数不符", name);
+    }
+
+    /// 断言转换器失败，且错误信息包含指定内容
+    pub fn assert_step_failed(&self, name: &str, message: &str) {
+        let step = self.step(name).unwrap_or_else(|| panic!("找不到转换器: {}", name));
+        match &step.error {
+            Some(e) => assert!(e.contains(message), "转换器{}的错误信息不符: {}", name, e),
+            None => panic!("转换器{}没有失败", name),
+        }
+    }
+
+    /// 断言至少有一条写入目标的记录的字段等于指定值
+    pub fn assert_sink_contains(&self, field: &str, value: Value) {
+        self.assert_succeeded();
+        assert!(
+            self.sink_output.iter().any(|r| r.data.get(field) == Some(&value)),
+            "写入目标的记录中没有{} = {}", field, value
+        );
+    }
+
+    /// 断言写入目标的记录数据与期望完全一致（按顺序比较）
+    pub fn assert_sink_eq(&self, expected: Vec<Value>) {
+        self.assert_succeeded();
+        let actual: Vec<Value> = self.sink_output.iter()
+            .map(|r| Value::Object(r.data.clone().into_iter().collect()))
+            .collect();
+        assert_eq!(actual, expected, "写入目标的记录不符");
+    }
+}
+
+/// 将JSON对象转换为测试记录
+fn fixture_record(event: Value) -> Result<DataRecord, String> {
+    match event {
+        Value::Object(map) => Ok(DataRecord {
+            data: map.into_iter().collect(),
+            metadata: HashMap::new(),
+            source: "fixture".to_string(),
+            timestamp: Utc::now(),
+        }),
+        other => Err(format!("测试记录必须是JSON对象: {}", other)),
+    }
+}
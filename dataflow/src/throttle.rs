@@ -0,0 +1,104 @@
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Arc;
+use std::time::Duration;
+use log::{debug, info, warn};
+
+/// 表示不限制的并行度
+const UNLIMITED: usize = usize::MAX;
+
+/// 连续多少次观测没有压力后恢复一级并行度
+const RESTORE_AFTER: usize = 3;
+
+/// 交互查询压力下的后台作业并行度限制
+///
+/// 定期读取lumosdb服务的健康检查：有查询在准入队列中排队、内存紧张或错误
+/// 预算严重消耗时，视为交互查询的延迟正在变差，把同时运行的作业数减半，
+/// 最少保留1个；压力消失后逐级加倍，直到恢复为不限制。限制只影响之后调度
+/// 的作业，正在运行的作业不会被中断。
+pub struct PressureThrottle {
+    /// 不限制时开始减半的并行度
+    ceiling: usize,
+    limit: AtomicUsize,
+    calm: AtomicUsize,
+}
+
+impl PressureThrottle {
+    /// 创建并行度限制，首次出现压力时从`ceiling`的一半开始限制
+    pub fn new(ceiling: usize) -> Self {
+        Self {
+            ceiling: ceiling.max(1),
+            limit: AtomicUsize::new(UNLIMITED),
+            calm: AtomicUsize::new(0),
+        }
+    }
+
+    /// 当前的并行度上限，None表示不限制
+    pub fn limit(&self) -> Option<usize> {
+        Some(self.limit.load(Ordering::SeqCst)).filter(|limit| *limit != UNLIMITED)
+    }
+
+    /// 根据一次压力观测调整并行度
+    pub fn observe(&self, pressure: bool) {
+        let current = self.limit.load(Ordering::SeqCst);
+        if pressure {
+            self.calm.store(0, Ordering::SeqCst);
+            let next = if current == UNLIMITED { self.ceiling / 2 } else { current / 2 }.max(1);
+            if next != current {
+                info!("交互查询压力升高，后台作业并行度降为{}", next);
+                self.limit.store(next, Ordering::SeqCst);
+            }
+        } else if current != UNLIMITED && self.calm.fetch_add(1, Ordering::SeqCst) + 1 >= RESTORE_AFTER {
+            self.calm.store(0, Ordering::SeqCst);
+            let next = current.saturating_mul(2);
+            if next >= self.ceiling {
+                info!("交互查询压力已消失，后台作业恢复为不限制并行度");
+                self.limit.store(UNLIMITED, Ordering::SeqCst);
+            } else {
+                info!("交互查询压力降低，后台作业并行度恢复为{}", next);
+                self.limit.store(next, Ordering::SeqCst);
+            }
+        }
+    }
+
+    /// 按间隔读取健康检查并调整并行度，无法获取健康检查时保持不变
+    ///
+    /// lumosdb启用API密钥认证时健康检查也需要密钥，`api_key`通过`X-API-Key`
+    /// 请求头发送；响应不是2xx时视为未知，不调整并行度。
+    pub async fn watch(self: Arc<Self>, health_url: String, api_key: Option<String>, interval: Duration) {
+        let client = reqwest::Client::builder()
+            .timeout(interval.max(Duration::from_secs(1)))
+            .build()
+            .unwrap_or_default();
+        loop {
+            tokio::time::sleep(interval).await;
+            let mut request = client.get(&health_url);
+            if let Some(api_key) = &api_key {
+                request = request.header("X-API-Key", api_key);
+            }
+            let health = match request.send().await {
+                Ok(response) if response.status().is_success() => response.json::<serde_json::Value>().await,
+                Ok(response) => {
+                    warn!("健康检查返回{}，本次不调整并行度", response.status());
+                    continue;
+                },
+                Err(e) => {
+                    debug!("读取健康检查失败: {}", e);
+                    continue;
+                }
+            };
+            match health {
+                Ok(health) => self.observe(under_pressure(&health)),
+                Err(e) => warn!("健康检查响应无法解析: {}", e),
+            }
+        }
+    }
+}
+
+/// 从lumosdb健康检查判断交互查询是否承压
+pub fn under_pressure(health: &serde_json::Value) -> bool {
+    let data = &health["data"];
+    let queued = data["admission"]["queued"].as_u64().unwrap_or(0) > 0;
+    let memory = matches!(data["degradation"]["memory"].as_str(), Some("high") | Some("critical"));
+    let errors = data["error_budget"]["level"].as_str() == Some("critical");
+    queued || memory || errors
+}
@@ -0,0 +1,258 @@
+use actix::prelude::*;
+use std::cmp::Ordering;
+use std::collections::HashMap;
+use chrono::Utc;
+use log::{info, debug};
+use serde::Deserialize;
+use serde_json::Value;
+
+use crate::types::DataRecord;
+use crate::actors::messages::TransformData;
+
+/// 聚合函数
+#[derive(Debug, Clone, Copy, PartialEq, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum AggregateFunction {
+    Sum,
+    Count,
+    Min,
+    Max,
+    Avg,
+}
+
+impl AggregateFunction {
+    fn name(&self) -> &'static str {
+        match self {
+            AggregateFunction::Sum => "sum",
+            AggregateFunction::Count => "count",
+            AggregateFunction::Min => "min",
+            AggregateFunction::Max => "max",
+            AggregateFunction::Avg => "avg",
+        }
+    }
+}
+
+/// 单个聚合的定义
+#[derive(Debug, Clone, Deserialize)]
+pub struct Aggregation {
+    /// 聚合的字段，count可以省略，表示统计记录数
+    #[serde(default)]
+    pub field: Option<String>,
+    pub function: AggregateFunction,
+    /// 输出字段名，默认为`<函数>_<字段>`
+    #[serde(default, rename = "as")]
+    pub alias: Option<String>,
+}
+
+impl Aggregation {
+    fn output_name(&self) -> String {
+        match (&self.alias, &self.field) {
+            (Some(alias), _) => alias.clone(),
+            (None, Some(field)) => format!("{}_{}", self.function.name(), field),
+            (None, None) => self.function.name().to_string(),
+        }
+    }
+}
+
+/// 单个分组中一个聚合的累计值
+#[derive(Debug, Clone, Default)]
+struct Accumulator {
+    count: u64,
+    sum: f64,
+    // 所有值都是整数时保留整数的和
+    int_sum: Option<i64>,
+    min: Option<Value>,
+    max: Option<Value>,
+}
+
+impl Accumulator {
+    fn new() -> Self {
+        Self { int_sum: Some(0), ..Self::default() }
+    }
+
+    fn add(&mut self, value: &Value, function: AggregateFunction) -> Result<(), String> {
+        if value.is_null() {
+            return Ok(());
+        }
+        self.count += 1;
+        match function {
+            AggregateFunction::Count => {},
+            AggregateFunction::Sum | AggregateFunction::Avg => {
+                let number = as_number(value).ok_or_else(|| format!("无法对非数值求和: {}", value))?;
+                self.sum += number;
+                self.int_sum = match (self.int_sum, value.as_i64()) {
+                    (Some(sum), Some(v)) => sum.checked_add(v),
+                    _ => None,
+                };
+            },
+            AggregateFunction::Min => {
+                if self.min.as_ref().map_or(true, |min| compare(value, min) == Ordering::Less) {
+                    self.min = Some(value.clone());
+                }
+            },
+            AggregateFunction::Max => {
+                if self.max.as_ref().map_or(true, |max| compare(value, max) == Ordering::Greater) {
+                    self.max = Some(value.clone());
+                }
+            },
+        }
+        Ok(())
+    }
+
+    fn result(&self, function: AggregateFunction) -> Value {
+        match function {
+            AggregateFunction::Count => Value::from(self.count),
+            AggregateFunction::Sum => match self.int_sum {
+                Some(sum) => Value::from(sum),
+                None => float(self.sum),
+            },
+            AggregateFunction::Avg if self.count == 0 => Value::Null,
+            AggregateFunction::Avg => float(self.sum / self.count as f64),
+            AggregateFunction::Min => self.min.clone().unwrap_or(Value::Null),
+            AggregateFunction::Max => self.max.clone().unwrap_or(Value::Null),
+        }
+    }
+}
+
+/// 聚合转换器，按分组字段汇总记录
+///
+/// `group_by`指定分组字段（未指定时所有记录为一组），`aggregations`为聚合
+/// 列表，每项包括`field`、`function`（sum、count、min、max、avg）和可选的
+/// 输出字段名`as`。每个分组输出一条记录，包含分组字段和各聚合的结果，分组
+/// 按首次出现的顺序输出。空值不参与聚合；字符串形式的数字（如CSV中读取的
+/// 字段）按数值计算。
+pub struct AggregateTransformer {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl AggregateTransformer {
+    /// 创建新的聚合转换器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 按分组聚合记录
+    fn aggregate_records(&self, records: Vec<DataRecord>, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        let group_by: Vec<String> = match options.get("group_by") {
+            Some(Value::String(field)) => vec![field.clone()],
+            Some(Value::Array(fields)) => fields.iter()
+                .map(|f| f.as_str().map(|s| s.to_string()).ok_or_else(|| format!("无效的分组字段: {}", f)))
+                .collect::<Result<_, _>>()?,
+            Some(other) => return Err(format!("无效的分组字段: {}", other)),
+            None => Vec::new(),
+        };
+
+        let aggregations: Vec<Aggregation> = match options.get("aggregations") {
+            Some(value) => serde_json::from_value(value.clone())
+                .map_err(|e| format!("无效的聚合定义: {}", e))?,
+            None => return Err("未指定聚合".to_string()),
+        };
+        if aggregations.is_empty() {
+            return Err("未指定聚合".to_string());
+        }
+        for aggregation in &aggregations {
+            if aggregation.field.is_none() && aggregation.function != AggregateFunction::Count {
+                return Err(format!("{}聚合需要指定字段", aggregation.function.name()));
+            }
+        }
+
+        info!("按{:?}聚合{}条记录", group_by, records.len());
+
+        // 分组按首次出现的顺序保存
+        let mut index: HashMap<String, usize> = HashMap::new();
+        let mut groups: Vec<(Vec<Value>, u64, Vec<Accumulator>)> = Vec::new();
+        for record in &records {
+            let key_values: Vec<Value> = group_by.iter()
+                .map(|field| record.data.get(field).cloned().unwrap_or(Value::Null))
+                .collect();
+            let key = serde_json::to_string(&key_values).unwrap_or_default();
+            let position = *index.entry(key).or_insert_with(|| {
+                groups.push((key_values, 0, vec![Accumulator::new(); aggregations.len()]));
+                groups.len() - 1
+            });
+
+            let (_, size, accumulators) = &mut groups[position];
+            *size += 1;
+            for (aggregation, accumulator) in aggregations.iter().zip(accumulators.iter_mut()) {
+                // 没有字段的count统计记录数
+                let value = match &aggregation.field {
+                    Some(field) => record.data.get(field).unwrap_or(&Value::Null),
+                    None => &Value::Bool(true),
+                };
+                accumulator.add(value, aggregation.function)
+                    .map_err(|e| format!("聚合字段{}失败: {}", aggregation.output_name(), e))?;
+            }
+        }
+
+        let now = Utc::now();
+        let output: Vec<DataRecord> = groups.into_iter()
+            .map(|(key_values, size, accumulators)| {
+                let mut data: HashMap<String, Value> = group_by.iter().cloned().zip(key_values).collect();
+                for (aggregation, accumulator) in aggregations.iter().zip(&accumulators) {
+                    data.insert(aggregation.output_name(), accumulator.result(aggregation.function));
+                }
+                let mut metadata = HashMap::new();
+                metadata.insert("group_size".to_string(), Value::from(size));
+                DataRecord {
+                    data,
+                    metadata,
+                    source: "aggregate".to_string(),
+                    timestamp: now,
+                }
+            })
+            .collect();
+
+        info!("聚合后得到{}个分组", output.len());
+        Ok(output)
+    }
+}
+
+/// 数值或可以解析为数值的字符串
+fn as_number(value: &Value) -> Option<f64> {
+    match value {
+        Value::Number(n) => n.as_f64(),
+        Value::String(s) => s.trim().parse().ok(),
+        _ => None,
+    }
+}
+
+/// 比较两个值，都是数值时按数值比较，否则按字符串比较
+fn compare(a: &Value, b: &Value) -> Ordering {
+    match (as_number(a), as_number(b)) {
+        (Some(a), Some(b)) => a.partial_cmp(&b).unwrap_or(Ordering::Equal),
+        _ => value_text(a).cmp(&value_text(b)),
+    }
+}
+
+fn value_text(value: &Value) -> String {
+    match value {
+        Value::String(s) => s.clone(),
+        other => other.to_string(),
+    }
+}
+
+fn float(value: f64) -> Value {
+    serde_json::Number::from_f64(value).map(Value::Number).unwrap_or(Value::Null)
+}
+
+impl Actor for AggregateTransformer {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("聚合转换器已启动");
+    }
+}
+
+impl Handler<TransformData> for AggregateTransformer {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: TransformData, _: &mut Context<Self>) -> Self::Result {
+        let records = msg.records;
+        let options = self.config.clone();
+
+        Box::pin(async move {
+            let transformer = AggregateTransformer::new(options.clone());
+            transformer.aggregate_records(records, &options)
+        })
+    }
+}
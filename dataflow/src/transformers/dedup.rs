@@ -0,0 +1,421 @@
+use actix::prelude::*;
+use std::collections::hash_map::DefaultHasher;
+use std::collections::{HashMap, HashSet};
+use std::hash::{Hash, Hasher};
+use std::sync::{Arc, Mutex};
+use chrono::{SecondsFormat, Utc};
+use log::{info, debug, warn};
+use rusqlite::{params, Connection};
+use serde_json::Value;
+
+use crate::commit;
+use crate::types::DataRecord;
+use crate::actors::messages::TransformData;
+
+/// 布隆过滤器默认的预计记录数
+const DEFAULT_EXPECTED_ITEMS: usize = 1_000_000;
+
+/// 布隆过滤器默认的误判率
+const DEFAULT_FALSE_POSITIVE_RATE: f64 = 0.01;
+
+lazy_static::lazy_static! {
+    /// 内存中的已见集合，按`state_key`共享，同一进程中管道的多次运行使用同一个集合
+    static ref MEMORY_STATES: Mutex<HashMap<String, Arc<Mutex<BloomFilter>>>> = Mutex::new(HashMap::new());
+}
+
+/// 已见键集合的存储方式
+enum SeenSet {
+    /// 内存中的布隆过滤器，占用固定内存，有一定误判率（新记录可能被误判为重复）
+    Memory(Arc<Mutex<BloomFilter>>),
+    /// SQLite中的键集合，没有误判，服务重启后仍然有效
+    Sqlite { conn: Connection, namespace: String },
+}
+
+impl SeenSet {
+    fn open(options: &HashMap<String, Value>) -> Result<Self, String> {
+        // 默认按作业区分集合，不同管道的相同键互不影响
+        let state_key = options.get("state_key")
+            .or_else(|| options.get("job_id"))
+            .and_then(|v| v.as_str())
+            .unwrap_or("default")
+            .to_string();
+
+        match options.get("state").and_then(|v| v.as_str()).unwrap_or("memory") {
+            "memory" => {
+                let expected = options.get("expected_items").and_then(|v| v.as_u64())
+                    .map(|n| n as usize)
+                    .unwrap_or(DEFAULT_EXPECTED_ITEMS);
+                let rate = options.get("false_positive_rate").and_then(|v| v.as_f64())
+                    .unwrap_or(DEFAULT_FALSE_POSITIVE_RATE);
+                if !(rate > 0.0 && rate < 1.0) {
+                    return Err(format!("误判率必须在0和1之间: {}", rate));
+                }
+                let filter = MEMORY_STATES.lock().unwrap()
+                    .entry(state_key)
+                    .or_insert_with(|| Arc::new(Mutex::new(BloomFilter::new(expected, rate))))
+                    .clone();
+                Ok(SeenSet::Memory(filter))
+            },
+            "sqlite" => {
+                let path = options.get("state_db").and_then(|v| v.as_str())
+                    .ok_or_else(|| "SQLite去重状态需要指定state_db".to_string())?;
+                let conn = Connection::open(path)
+                    .map_err(|e| format!("打开去重状态数据库失败: {}: {}", path, e))?;
+                conn.execute_batch("
+                    CREATE TABLE IF NOT EXISTS seen_keys (
+                        namespace TEXT NOT NULL,
+                        key TEXT NOT NULL,
+                        seen_at TEXT NOT NULL,
+                        PRIMARY KEY (namespace, key)
+                    ) WITHOUT ROWID;
+                ").map_err(|e| format!("初始化去重状态表失败: {}", e))?;
+                Ok(SeenSet::Sqlite { conn, namespace: state_key })
+            },
+            other => Err(format!("不支持的去重状态类型: {}", other)),
+        }
+    }
+
+    /// 过滤出首次出现的记录，不修改集合；返回的键需要在加载成功后通过`insert`加入
+    fn retain_unseen(&self, keyed: Vec<(String, DataRecord)>) -> Result<(Vec<DataRecord>, Vec<String>), String> {
+        let mut unseen = Vec::new();
+        let mut keys = Vec::new();
+        match self {
+            SeenSet::Memory(filter) => {
+                let filter = filter.lock().unwrap();
+                for (key, record) in keyed {
+                    if !filter.contains(&key) {
+                        unseen.push(record);
+                        keys.push(key);
+                    }
+                }
+            },
+            SeenSet::Sqlite { conn, namespace } => {
+                let mut stmt = conn.prepare_cached("SELECT 1 FROM seen_keys WHERE namespace = ?1 AND key = ?2")
+                    .map_err(|e| format!("读取去重状态失败: {}", e))?;
+                for (key, record) in keyed {
+                    let seen = stmt.exists(params![namespace, key])
+                        .map_err(|e| format!("读取去重状态失败: {}", e))?;
+                    if !seen {
+                        unseen.push(record);
+                        keys.push(key);
+                    }
+                }
+            },
+        }
+        Ok((unseen, keys))
+    }
+
+    /// 把键加入集合
+    fn insert(&mut self, keys: &[String]) -> Result<(), String> {
+        match self {
+            SeenSet::Memory(filter) => {
+                let mut filter = filter.lock().unwrap();
+                for key in keys {
+                    filter.insert(key);
+                }
+                Ok(())
+            },
+            SeenSet::Sqlite { conn, namespace } => {
+                let tx = conn.transaction()
+                    .map_err(|e| format!("更新去重状态失败: {}", e))?;
+                {
+                    let now = Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true);
+                    let mut stmt = tx.prepare_cached("INSERT OR IGNORE INTO seen_keys (namespace, key, seen_at) VALUES (?1, ?2, ?3)")
+                        .map_err(|e| format!("更新去重状态失败: {}", e))?;
+                    for key in keys {
+                        stmt.execute(params![namespace, key, now])
+                            .map_err(|e| format!("更新去重状态失败: {}", e))?;
+                    }
+                }
+                tx.commit().map_err(|e| format!("更新去重状态失败: {}", e))
+            },
+        }
+    }
+}
+
+/// 布隆过滤器
+struct BloomFilter {
+    bits: Vec<u64>,
+    bit_count: u64,
+    hashes: u32,
+}
+
+impl BloomFilter {
+    /// 按预计记录数和误判率计算位数和哈希函数个数
+    fn new(expected_items: usize, false_positive_rate: f64) -> Self {
+        let n = expected_items.max(1) as f64;
+        let ln2 = std::f64::consts::LN_2;
+        let bit_count = (-(n * false_positive_rate.ln()) / (ln2 * ln2)).ceil().max(64.0) as u64;
+        let hashes = ((bit_count as f64 / n) * ln2).round().clamp(1.0, 16.0) as u32;
+        Self {
+            bits: vec![0; ((bit_count + 63) / 64) as usize],
+            bit_count,
+            hashes,
+        }
+    }
+
+    /// 加入键
+    fn insert(&mut self, key: &str) {
+        for (word, mask) in self.positions(key) {
+            self.bits[word] |= mask;
+        }
+    }
+
+    /// 键是否可能在集合中，不在集合中的键一定返回false
+    fn contains(&self, key: &str) -> bool {
+        self.positions(key).all(|(word, mask)| self.bits[word] & mask != 0)
+    }
+
+    /// 键对应的各个位所在的字和掩码
+    fn positions(&self, key: &str) -> impl Iterator<Item = (usize, u64)> {
+        let (h1, h2) = hash_pair(key);
+        let bit_count = self.bit_count;
+        (0..self.hashes as u64).map(move |i| {
+            let bit = h1.wrapping_add(i.wrapping_mul(h2)) % bit_count;
+            ((bit / 64) as usize, 1u64 << (bit % 64))
+        })
+    }
+}
+
+/// 用两个独立的哈希值模拟多个哈希函数
+fn hash_pair(key: &str) -> (u64, u64) {
+    let mut first = DefaultHasher::new();
+    key.hash(&mut first);
+    let mut second = DefaultHasher::new();
+    (key, 0x9e37_79b9_7f4a_7c15u64).hash(&mut second);
+    (first.finish(), second.finish() | 1)
+}
+
+/// 去重转换器，丢弃键已经见过的记录
+///
+/// `key`为去重键：字段名、字段名数组，或`{customer_id}:{order_id}`形式的
+/// 模板。已见过的键保存在`state`指定的集合中：`memory`（默认）为内存中的
+/// 布隆过滤器，同一进程中管道的多次运行共享，可通过`expected_items`和
+/// `false_positive_rate`调整大小；`sqlite`将键保存在`state_db`中，服务
+/// 重启后仍然有效。`state_key`区分不同管道的集合，默认为作业ID。
+///
+/// 在管道中运行时，记录的键在数据加载成功后才加入集合，加载失败后重新运行
+/// 仍会输出这些记录。缺少去重字段（或字段为null）的记录无法判断是否重复，
+/// 原样输出，不参与去重。
+pub struct DedupTransformer {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl DedupTransformer {
+    /// 创建新的去重转换器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 丢弃已见过的记录
+    fn dedup_records(&self, records: Vec<DataRecord>, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        let key = KeyExpression::parse(options.get("key"))?;
+        let mut seen = SeenSet::open(options)?;
+
+        let total = records.len();
+        // 同一批中重复的记录只保留第一条，缺少去重字段的记录原样输出
+        let mut batch_keys = HashSet::new();
+        let mut unkeyed = Vec::new();
+        let mut keyed = Vec::new();
+        for record in records {
+            match key.evaluate(&record) {
+                Some(key) => {
+                    if batch_keys.insert(key.clone()) {
+                        keyed.push((key, record));
+                    }
+                },
+                None => unkeyed.push(record),
+            }
+        }
+
+        let (mut unseen, keys) = seen.retain_unseen(keyed)?;
+        if !unkeyed.is_empty() {
+            warn!("{}条记录缺少去重字段，未参与去重", unkeyed.len());
+        }
+        unseen.extend(unkeyed);
+        info!("去重后保留{}条记录，丢弃{}条重复记录", unseen.len(), total - unseen.len());
+
+        // 数据加载成功后才记住这些键
+        if !keys.is_empty() {
+            let description = format!("记录{}个去重键", keys.len());
+            commit::defer(commit::run_id(options).as_deref(), &description, move || seen.insert(&keys))?;
+        }
+        Ok(unseen)
+    }
+}
+
+/// 去重键表达式
+enum KeyExpression {
+    Fields(Vec<String>),
+    /// 模板和其中引用的字段
+    Template(String, Vec<String>),
+}
+
+impl KeyExpression {
+    fn parse(value: Option<&Value>) -> Result<Self, String> {
+        match value {
+            Some(Value::String(template)) if template.contains('{') => {
+                let fields = template.split('{')
+                    .skip(1)
+                    .filter_map(|part| part.split_once('}').map(|(field, _)| field.to_string()))
+                    .collect();
+                Ok(KeyExpression::Template(template.clone(), fields))
+            },
+            Some(Value::String(field)) => Ok(KeyExpression::Fields(vec![field.clone()])),
+            Some(Value::Array(fields)) if !fields.is_empty() => fields.iter()
+                .map(|f| f.as_str().map(|s| s.to_string()).ok_or_else(|| format!("无效的去重字段: {}", f)))
+                .collect::<Result<_, _>>()
+                .map(KeyExpression::Fields),
+            Some(other) => Err(format!("无效的去重键: {}", other)),
+            None => Err("未指定去重键".to_string()),
+        }
+    }
+
+    /// 计算记录的去重键，缺少字段或字段为null时返回None
+    fn evaluate(&self, record: &DataRecord) -> Option<String> {
+        let value = |field: &String| record.data.get(field).filter(|v| !v.is_null());
+        match self {
+            KeyExpression::Fields(fields) => {
+                let values = fields.iter().map(value).collect::<Option<Vec<&Value>>>()?;
+                serde_json::to_string(&values).ok()
+            },
+            KeyExpression::Template(template, fields) => {
+                let mut key = template.clone();
+                for field in fields {
+                    let text = match value(field)? {
+                        Value::String(s) => s.clone(),
+                        other => other.to_string(),
+                    };
+                    key = key.replace(&format!("{{{}}}", field), &text);
+                }
+                Some(key)
+            },
+        }
+    }
+}
+
+impl Actor for DedupTransformer {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("去重转换器已启动");
+    }
+}
+
+impl Handler<TransformData> for DedupTransformer {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: TransformData, _: &mut Context<Self>) -> Self::Result {
+        let records = msg.records;
+        let options = self.config.clone();
+
+        Box::pin(async move {
+            let transformer = DedupTransformer::new(options.clone());
+            transformer.dedup_records(records, &options)
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn record(data: Value) -> DataRecord {
+        DataRecord {
+            data: serde_json::from_value(data).unwrap(),
+            metadata: HashMap::new(),
+            source: "test".to_string(),
+            timestamp: Utc::now(),
+        }
+    }
+
+    fn options(value: Value) -> HashMap<String, Value> {
+        serde_json::from_value(value).unwrap()
+    }
+
+    fn ids(records: &[DataRecord]) -> Vec<Value> {
+        records.iter().map(|r| r.data.get("id").cloned().unwrap_or(Value::Null)).collect()
+    }
+
+    #[test]
+    fn test_key_expressions() {
+        let order = record(json!({"customer_id": 7, "order_id": "a-1", "note": null}));
+
+        let template = KeyExpression::parse(Some(&json!("{customer_id}:{order_id}"))).unwrap();
+        assert_eq!(template.evaluate(&order).as_deref(), Some("7:a-1"));
+        let fields = KeyExpression::parse(Some(&json!(["customer_id", "order_id"]))).unwrap();
+        assert_eq!(fields.evaluate(&order).as_deref(), Some("[7,\"a-1\"]"));
+
+        // 缺少字段或字段为null时没有键
+        assert!(KeyExpression::parse(Some(&json!("note"))).unwrap().evaluate(&order).is_none());
+        assert!(KeyExpression::parse(Some(&json!("{missing}"))).unwrap().evaluate(&order).is_none());
+
+        assert!(KeyExpression::parse(None).is_err());
+        assert!(KeyExpression::parse(Some(&json!([]))).is_err());
+        assert!(KeyExpression::parse(Some(&json!([1]))).is_err());
+    }
+
+    #[test]
+    fn test_bloom_filter() {
+        let mut filter = BloomFilter::new(1000, 0.01);
+        for i in 0..1000 {
+            filter.insert(&i.to_string());
+        }
+        assert!((0..1000).all(|i| filter.contains(&i.to_string())));
+        let false_positives = (1000..11000).filter(|i| filter.contains(&i.to_string())).count();
+        assert!(false_positives < 300, "{}", false_positives);
+    }
+
+    #[test]
+    fn test_keys_recorded_after_commit() {
+        let transformer = DedupTransformer::new(HashMap::new());
+        let options = options(json!({"key": "id", "state_key": "test_keys_recorded_after_commit", "run_id": "dedup-test-run"}));
+        let batch = || vec![
+            record(json!({"id": 1})),
+            record(json!({"id": 1})),
+            record(json!({"id": 2})),
+            record(json!({"name": "no id"})),
+        ];
+
+        // 同一批中的重复记录只保留第一条，缺少键的记录原样输出
+        assert_eq!(ids(&transformer.dedup_records(batch(), &options).unwrap()), vec![json!(1), json!(2), Value::Null]);
+
+        // 加载失败时键不会被记住
+        commit::discard("dedup-test-run");
+        assert_eq!(transformer.dedup_records(batch(), &options).unwrap().len(), 3);
+
+        assert_eq!(commit::commit("dedup-test-run"), 0);
+        assert_eq!(ids(&transformer.dedup_records(vec![record(json!({"id": 2})), record(json!({"id": 3}))], &options).unwrap()), vec![json!(3)]);
+        commit::discard("dedup-test-run");
+
+        // 不同的集合互不影响
+        let other = HashMap::from([
+            ("key".to_string(), json!("id")),
+            ("state_key".to_string(), json!("test_keys_recorded_after_commit_other")),
+        ]);
+        assert_eq!(transformer.dedup_records(batch(), &other).unwrap().len(), 3);
+    }
+
+    #[test]
+    fn test_sqlite_state() {
+        let path = std::env::temp_dir().join(format!("lumos_dedup_{}.db", std::process::id()));
+        let _ = std::fs::remove_file(&path);
+        let transformer = DedupTransformer::new(HashMap::new());
+        let options = options(json!({"key": "id", "state": "sqlite", "state_db": path.to_str().unwrap(), "job_id": "orders"}));
+
+        // 没有运行标识时立即记住键
+        assert_eq!(transformer.dedup_records(vec![record(json!({"id": 1}))], &options).unwrap().len(), 1);
+        assert!(transformer.dedup_records(vec![record(json!({"id": 1}))], &options).unwrap().is_empty());
+
+        let mut users = options.clone();
+        users.insert("job_id".to_string(), json!("users"));
+        assert_eq!(transformer.dedup_records(vec![record(json!({"id": 1}))], &users).unwrap().len(), 1);
+
+        let mut missing = options.clone();
+        missing.remove("state_db");
+        assert!(transformer.dedup_records(vec![record(json!({"id": 1}))], &missing).is_err());
+
+        let _ = std::fs::remove_file(&path);
+    }
+}
@@ -1,28 +1,65 @@
 use actix::prelude::*;
 use std::collections::HashMap;
-use log::{error};
+use std::sync::RwLock;
+use log::{error, info};
 
 use crate::types::ETLError;
+use crate::transformers::aggregate::AggregateTransformer;
+use crate::transformers::dedup::DedupTransformer;
 use crate::transformers::filter::FilterTransformer;
 use crate::transformers::map::MapTransformer;
+use crate::transformers::pii::PiiTransformer;
+use crate::transformers::wasm::WasmTransformer;
+
+/// 根据选项创建转换器Actor
+pub type TransformerConstructor = fn(HashMap<String, serde_json::Value>) -> Addr<dyn Actor>;
+
+lazy_static::lazy_static! {
+    /// 转换器类型注册表，管道定义中的`type_name`按此查找转换器
+    static ref REGISTRY: RwLock<HashMap<String, TransformerConstructor>> = {
+        let mut registry: HashMap<String, TransformerConstructor> = HashMap::new();
+        // 过滤转换器
+        registry.insert("filter".to_string(), |options| FilterTransformer::new(options).start());
+        // 映射转换器
+        registry.insert("map".to_string(), |options| MapTransformer::new(options).start());
+        // 聚合转换器
+        registry.insert("aggregate".to_string(), |options| AggregateTransformer::new(options).start());
+        // 去重转换器
+        registry.insert("dedup".to_string(), |options| DedupTransformer::new(options).start());
+        // 敏感数据脱敏转换器
+        registry.insert("pii".to_string(), |options| PiiTransformer::new(options).start());
+        // WASM沙箱转换器
+        registry.insert("wasm".to_string(), |options| WasmTransformer::new(options).start());
+        RwLock::new(registry)
+    };
+}
+
+/// 注册转换器类型，同名类型会被替换
+pub fn register_transformer(transformer_type: &str, constructor: TransformerConstructor) {
+    info!("注册转换器类型: {}", transformer_type);
+    REGISTRY.write().unwrap().insert(transformer_type.to_string(), constructor);
+}
+
+/// 转换器类型是否已注册
+pub fn is_registered(transformer_type: &str) -> bool {
+    REGISTRY.read().unwrap().contains_key(transformer_type)
+}
+
+/// 已注册的转换器类型
+pub fn transformer_types() -> Vec<String> {
+    let mut types: Vec<String> = REGISTRY.read().unwrap().keys().cloned().collect();
+    types.sort();
+    types
+}
 
 /// 创建转换器
 pub fn create_transformer(transformer_type: &str, options: HashMap<String, serde_json::Value>) -> Result<Addr<dyn Actor>, ETLError> {
-    match transformer_type {
-        "filter" => {
-            // 创建过滤转换器
-            let transformer = FilterTransformer::new(options);
-            Ok(transformer.start())
-        },
-        "map" => {
-            // 创建映射转换器
-            let transformer = MapTransformer::new(options);
-            Ok(transformer.start())
-        },
-        // 可以添加更多转换器类型
-        _ => {
+    let constructor = REGISTRY.read().unwrap().get(transformer_type).copied();
+    match constructor {
+        Some(constructor) => Ok(constructor(options)),
+        None => {
             error!("未知的转换器类型: {}", transformer_type);
             Err(ETLError::ConfigError(format!("未知的转换器类型: {}", transformer_type)))
         }
     }
-} 
\ No newline at end of file
+}
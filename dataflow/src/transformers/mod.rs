@@ -1,7 +1,15 @@
+pub mod aggregate;
+pub mod dedup;
 pub mod filter;
 pub mod map;
+pub mod pii;
+pub mod wasm;
 pub mod factory;
 
 pub use factory::create_transformer;
+pub use aggregate::AggregateTransformer;
+pub use dedup::DedupTransformer;
 pub use filter::FilterTransformer;
-pub use map::MapTransformer; 
\ No newline at end of file
+pub use map::MapTransformer;
+pub use pii::PiiTransformer;
+pub use wasm::WasmTransformer; 
\ No newline at end of file
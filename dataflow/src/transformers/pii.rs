@@ -0,0 +1,413 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use chrono::{SecondsFormat, Utc};
+use log::{info, debug};
+use rusqlite::{params, Connection};
+use serde::Deserialize;
+use serde_json::Value;
+use sha2::{Digest, Sha256};
+
+use crate::types::DataRecord;
+use crate::actors::messages::TransformData;
+
+/// 令牌默认前缀
+const DEFAULT_TOKEN_PREFIX: &str = "tok_";
+
+/// 令牌中哈希的十六进制长度
+const TOKEN_HEX_LENGTH: usize = 16;
+
+/// 脱敏方式
+#[derive(Debug, Clone, Copy, PartialEq, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum PiiMethod {
+    /// 部分遮盖，保留开头和结尾的字符
+    Mask,
+    /// 加盐哈希
+    Hash,
+    /// 替换为确定性的令牌，可选保存令牌和原值的对应关系
+    Tokenize,
+    /// 生成格式相同的假数据
+    Fake,
+}
+
+/// 单条脱敏规则
+#[derive(Debug, Clone, Deserialize)]
+pub struct PiiRule {
+    /// 规则作用的字段
+    #[serde(default)]
+    pub fields: Vec<String>,
+    /// 单个字段，与`fields`合并
+    #[serde(default)]
+    pub field: Option<String>,
+    pub method: PiiMethod,
+    /// mask：保留开头的字母和数字个数
+    #[serde(default)]
+    pub keep_start: usize,
+    /// mask：保留结尾的字母和数字个数
+    #[serde(default = "default_keep_end")]
+    pub keep_end: usize,
+    /// mask：遮盖字符
+    #[serde(default = "default_mask_char")]
+    pub mask_char: char,
+    /// hash：截取的十六进制长度，默认完整的64位
+    #[serde(default)]
+    pub length: Option<usize>,
+    /// tokenize：令牌前缀
+    #[serde(default)]
+    pub prefix: Option<String>,
+}
+
+fn default_keep_end() -> usize {
+    4
+}
+
+fn default_mask_char() -> char {
+    '*'
+}
+
+impl PiiRule {
+    fn target_fields(&self) -> Vec<String> {
+        let mut fields = self.fields.clone();
+        fields.extend(self.field.clone());
+        fields
+    }
+}
+
+/// 令牌和原值的对应关系，保存在SQLite中供有权限的系统还原
+struct TokenVault {
+    conn: Connection,
+}
+
+impl TokenVault {
+    fn open(path: &str) -> Result<Self, String> {
+        let conn = Connection::open(path)
+            .map_err(|e| format!("打开令牌库失败: {}: {}", path, e))?;
+        conn.execute_batch("
+            CREATE TABLE IF NOT EXISTS pii_tokens (
+                token TEXT PRIMARY KEY,
+                value TEXT NOT NULL,
+                created_at TEXT NOT NULL
+            ) WITHOUT ROWID;
+        ").map_err(|e| format!("初始化令牌库失败: {}", e))?;
+        Ok(Self { conn })
+    }
+
+    fn store(&mut self, tokens: &[(String, String)]) -> Result<(), String> {
+        let tx = self.conn.transaction()
+            .map_err(|e| format!("保存令牌失败: {}", e))?;
+        {
+            let now = Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true);
+            let mut stmt = tx.prepare_cached("INSERT OR IGNORE INTO pii_tokens (token, value, created_at) VALUES (?1, ?2, ?3)")
+                .map_err(|e| format!("保存令牌失败: {}", e))?;
+            for (token, value) in tokens {
+                stmt.execute(params![token, value, now])
+                    .map_err(|e| format!("保存令牌失败: {}", e))?;
+            }
+        }
+        tx.commit().map_err(|e| format!("保存令牌失败: {}", e))
+    }
+}
+
+/// 敏感数据脱敏转换器
+///
+/// `rules`为规则列表，每条规则对`fields`（或`field`）使用一种`method`：
+/// `mask`保留开头`keep_start`个和结尾`keep_end`个字母或数字，其余替换为
+/// `mask_char`，分隔符保持不变；`hash`输出加盐的SHA-256，可用`length`截取；
+/// `tokenize`输出`prefix`加哈希的令牌，配置`token_db`时把令牌和原值保存到
+/// SQLite供还原；`fake`把数字、大写和小写字母分别替换为随机的同类字符，
+/// 生成格式相同的假数据，数值字段只替换尾数中的数字，结果仍是数值。
+///
+/// `hash`、`tokenize`和`fake`使用`salt`（或环境变量`salt_env`）作为密钥，
+/// 相同的值总是得到相同的结果，脱敏后的字段仍然可以用于关联。空值和缺失的
+/// 字段保持不变。
+pub struct PiiTransformer {
+    config: HashMap<String, serde_json::Value>,
+}
+
+impl PiiTransformer {
+    /// 创建新的脱敏转换器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self { config }
+    }
+
+    /// 按规则脱敏记录中的字段
+    fn mask_records(&self, mut records: Vec<DataRecord>, options: &HashMap<String, serde_json::Value>) -> Result<Vec<DataRecord>, String> {
+        let rules: Vec<PiiRule> = match options.get("rules") {
+            Some(value) => serde_json::from_value(value.clone())
+                .map_err(|e| format!("无效的脱敏规则: {}", e))?,
+            None => return Err("未指定脱敏规则".to_string()),
+        };
+        if rules.is_empty() {
+            return Err("未指定脱敏规则".to_string());
+        }
+
+        let salt = match (options.get("salt").and_then(|v| v.as_str()), options.get("salt_env").and_then(|v| v.as_str())) {
+            (Some(salt), _) => Some(salt.to_string()),
+            (None, Some(name)) => Some(std::env::var(name)
+                .map_err(|_| format!("环境变量{}未设置", name))?),
+            (None, None) => None,
+        };
+        for rule in &rules {
+            if rule.target_fields().is_empty() {
+                return Err(format!("{:?}规则未指定字段", rule.method));
+            }
+            if rule.method != PiiMethod::Mask && salt.as_deref().map_or(true, str::is_empty) {
+                return Err(format!("{:?}规则需要指定salt或salt_env", rule.method));
+            }
+        }
+        let salt = salt.unwrap_or_default();
+
+        let mut vault = match options.get("token_db").and_then(|v| v.as_str()) {
+            Some(path) => Some(TokenVault::open(path)?),
+            None => None,
+        };
+        let mut tokens = Vec::new();
+
+        for record in &mut records {
+            for rule in &rules {
+                for field in rule.target_fields() {
+                    let value = match record.data.get(&field) {
+                        Some(value) if !value.is_null() => value,
+                        _ => continue,
+                    };
+                    let text = match value {
+                        Value::String(s) => s.clone(),
+                        other => other.to_string(),
+                    };
+                    let masked = match rule.method {
+                        PiiMethod::Mask => Value::String(mask(&text, rule.keep_start, rule.keep_end, rule.mask_char)),
+                        PiiMethod::Hash => {
+                            let digest = keyed_hash(&salt, &text);
+                            Value::String(digest[..rule.length.unwrap_or(digest.len()).min(digest.len())].to_string())
+                        },
+                        PiiMethod::Tokenize => {
+                            let prefix = rule.prefix.as_deref().unwrap_or(DEFAULT_TOKEN_PREFIX);
+                            let token = format!("{}{}", prefix, &keyed_hash(&salt, &text)[..TOKEN_HEX_LENGTH]);
+                            if vault.is_some() {
+                                tokens.push((token.clone(), text));
+                            }
+                            Value::String(token)
+                        },
+                        PiiMethod::Fake => match value {
+                            // 数值字段生成的假数据仍然是数值
+                            Value::Number(_) => {
+                                let fake = fake_number(&salt, &text);
+                                serde_json::from_str(&fake).unwrap_or(Value::String(fake))
+                            },
+                            _ => Value::String(fake(&salt, &text)),
+                        },
+                    };
+                    record.data.insert(field, masked);
+                }
+            }
+        }
+
+        if let Some(vault) = vault.as_mut() {
+            vault.store(&tokens)?;
+        }
+
+        info!("已按{}条规则脱敏{}条记录", rules.len(), records.len());
+        Ok(records)
+    }
+}
+
+/// 加盐的SHA-256十六进制摘要
+fn keyed_hash(salt: &str, text: &str) -> String {
+    let mut hasher = Sha256::new();
+    hasher.update(salt.as_bytes());
+    hasher.update([0u8]);
+    hasher.update(text.as_bytes());
+    hex::encode(hasher.finalize())
+}
+
+/// 保留开头和结尾的字母或数字，遮盖其余的字母和数字
+fn mask(text: &str, keep_start: usize, keep_end: usize, mask_char: char) -> String {
+    let total = text.chars().filter(|c| c.is_alphanumeric()).count();
+    // 可见部分不能覆盖整个值
+    let (keep_start, keep_end) = if keep_start + keep_end >= total {
+        (0, 0)
+    } else {
+        (keep_start, keep_end)
+    };
+
+    let mut position = 0;
+    text.chars()
+        .map(|c| {
+            if !c.is_alphanumeric() {
+                return c;
+            }
+            position += 1;
+            if position <= keep_start || position > total - keep_end {
+                c
+            } else {
+                mask_char
+            }
+        })
+        .collect()
+}
+
+/// 按原值的格式生成假数据，相同的原值得到相同的结果
+fn fake(salt: &str, text: &str) -> String {
+    let mut stream = KeyStream::new(salt, text);
+    let mut first_digit = true;
+    text.chars()
+        .map(|c| {
+            if c.is_ascii_digit() {
+                // 第一个数字不为0，数值不会变成前导零
+                let digit = if first_digit && c != '0' {
+                    b'1' + stream.next() % 9
+                } else {
+                    b'0' + stream.next() % 10
+                };
+                first_digit = false;
+                digit as char
+            } else if c.is_ascii_lowercase() {
+                (b'a' + stream.next() % 26) as char
+            } else if c.is_ascii_uppercase() {
+                (b'A' + stream.next() % 26) as char
+            } else {
+                c
+            }
+        })
+        .collect()
+}
+
+/// 数值的假数据，只替换尾数中的数字，保留指数使结果仍是有效且不溢出的数值
+fn fake_number(salt: &str, text: &str) -> String {
+    match text.find(|c| c == 'e' || c == 'E') {
+        Some(idx) => format!("{}{}", fake(salt, &text[..idx]), &text[idx..]),
+        None => fake(salt, text),
+    }
+}
+
+/// 由盐和原值派生的确定性字节流
+struct KeyStream {
+    seed: Vec<u8>,
+    counter: u64,
+    block: Vec<u8>,
+    offset: usize,
+}
+
+impl KeyStream {
+    fn new(salt: &str, text: &str) -> Self {
+        Self {
+            seed: hex::decode(keyed_hash(salt, text)).unwrap_or_default(),
+            counter: 0,
+            block: Vec::new(),
+            offset: 0,
+        }
+    }
+
+    fn next(&mut self) -> u8 {
+        if self.offset == self.block.len() {
+            let mut hasher = Sha256::new();
+            hasher.update(&self.seed);
+            hasher.update(self.counter.to_le_bytes());
+            self.block = hasher.finalize().to_vec();
+            self.counter += 1;
+            self.offset = 0;
+        }
+        self.offset += 1;
+        self.block[self.offset - 1]
+    }
+}
+
+impl Actor for PiiTransformer {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("脱敏转换器已启动");
+    }
+}
+
+impl Handler<TransformData> for PiiTransformer {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: TransformData, _: &mut Context<Self>) -> Self::Result {
+        let records = msg.records;
+        let options = self.config.clone();
+
+        Box::pin(async move {
+            let transformer = PiiTransformer::new(options.clone());
+            transformer.mask_records(records, &options)
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn record(field: &str, value: Value) -> DataRecord {
+        DataRecord {
+            data: HashMap::from([(field.to_string(), value)]),
+            metadata: HashMap::new(),
+            source: "test".to_string(),
+            timestamp: Utc::now(),
+        }
+    }
+
+    fn options(value: Value) -> HashMap<String, Value> {
+        serde_json::from_value(value).unwrap()
+    }
+
+    #[test]
+    fn test_mask_boundaries() {
+        assert_eq!(mask("4111-1111-1111-1234", 0, 4, '*'), "****-****-****-1234");
+        assert_eq!(mask("4111-1111-1111-1234", 4, 4, '#'), "4111-####-####-1234");
+        assert_eq!(mask("abcd", 1, 2, '*'), "a*cd");
+
+        // 可见部分覆盖整个值时全部遮盖
+        assert_eq!(mask("abcd", 2, 2, '*'), "****");
+        assert_eq!(mask("abc", 0, 4, '*'), "***");
+        assert_eq!(mask("a-b", 1, 1, '*'), "*-*");
+        assert_eq!(mask("--", 0, 4, '*'), "--");
+        assert_eq!(mask("", 0, 4, '*'), "");
+
+        // 按字符而不是字节计数
+        assert_eq!(mask("张三丰@例子", 1, 1, '*'), "张**@*子");
+    }
+
+    #[test]
+    fn test_fake_keeps_format() {
+        let phone = fake("salt", "+1 (555) 010-9999");
+        assert_eq!(phone.len(), "+1 (555) 010-9999".len());
+        assert_eq!(phone.replace(|c: char| c.is_ascii_digit(), "0"), "+0 (000) 000-0000");
+        assert_eq!(phone, fake("salt", "+1 (555) 010-9999"));
+        assert_ne!(phone, fake("pepper", "+1 (555) 010-9999"));
+
+        let name = fake("salt", "Ada Lovelace");
+        assert!(name.chars().zip("Ada Lovelace".chars()).all(|(f, c)| {
+            (f.is_ascii_uppercase() && c.is_ascii_uppercase())
+                || (f.is_ascii_lowercase() && c.is_ascii_lowercase())
+                || f == c
+        }));
+        assert!(!fake("salt", "12345").starts_with('0'));
+    }
+
+    #[test]
+    fn test_fake_keeps_numbers_numeric() {
+        let transformer = PiiTransformer::new(HashMap::new());
+        let options = options(json!({"salt": "s", "rules": [{"field": "n", "method": "fake"}]}));
+        for value in [json!(12345), json!(-42), json!(3.25), json!(0.5), json!(1.5e300), json!(u64::MAX)] {
+            let records = transformer.mask_records(vec![record("n", value.clone())], &options).unwrap();
+            assert!(records[0].data["n"].is_number(), "{} -> {}", value, records[0].data["n"]);
+        }
+
+        let records = transformer.mask_records(vec![record("n", json!("12345"))], &options).unwrap();
+        assert!(records[0].data["n"].is_string());
+    }
+
+    #[test]
+    fn test_rules_require_salt() {
+        let transformer = PiiTransformer::new(HashMap::new());
+        let hash = options(json!({"rules": [{"field": "email", "method": "hash"}]}));
+        assert!(transformer.mask_records(vec![record("email", json!("a@b.c"))], &hash).is_err());
+
+        let mask = options(json!({"rules": [{"field": "email", "method": "mask", "keep_end": 1}]}));
+        let records = transformer.mask_records(vec![record("email", json!("ab@cd.e")), record("email", Value::Null)], &mask).unwrap();
+        assert_eq!(records[0].data["email"], "**@**.e");
+        assert!(records[1].data["email"].is_null());
+    }
+}
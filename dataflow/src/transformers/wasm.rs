@@ -0,0 +1,167 @@
+use actix::prelude::*;
+use std::collections::HashMap;
+use log::{info, debug};
+use wasmtime::{Config, Engine, Linker, Memory, Module, Store, StoreLimits, StoreLimitsBuilder, TypedFunc};
+
+use crate::types::DataRecord;
+use crate::actors::messages::TransformData;
+
+/// 每批记录默认可用的燃料（指令预算）
+const DEFAULT_FUEL: u64 = 1_000_000_000;
+
+/// 默认的WASM线性内存上限（MB）
+const DEFAULT_MEMORY_LIMIT_MB: u64 = 64;
+
+/// 沙箱中每个实例的宿主状态
+struct SandboxState {
+    limits: StoreLimits,
+}
+
+/// WASM转换器，在沙箱中运行用户提供的WebAssembly模块
+///
+/// 模块需导出`memory`、`allocate(len: i32) -> i32`和`transform(ptr: i32) -> i32`。
+/// 输入和输出都是以4字节小端长度为前缀的JSON记录数组，与WASM插件的约定一致。
+/// 不向模块提供任何宿主函数，每批记录使用新的实例，并限制燃料和内存。
+pub struct WasmTransformer {
+    config: HashMap<String, serde_json::Value>,
+    /// 已编译的模块，首次使用时加载
+    compiled: Option<(Engine, Module)>,
+}
+
+impl WasmTransformer {
+    /// 创建新的WASM转换器
+    pub fn new(config: HashMap<String, serde_json::Value>) -> Self {
+        Self {
+            config,
+            compiled: None,
+        }
+    }
+
+    /// 编译配置中指定的模块
+    fn compile(&mut self) -> Result<(Engine, Module), String> {
+        if let Some(compiled) = &self.compiled {
+            return Ok(compiled.clone());
+        }
+
+        let path = match self.config.get("module") {
+            Some(serde_json::Value::String(path)) => path.clone(),
+            _ => return Err("未指定WASM模块路径".to_string()),
+        };
+
+        let mut wasm_config = Config::new();
+        wasm_config.consume_fuel(true);
+        let engine = Engine::new(&wasm_config)
+            .map_err(|e| format!("无法创建WASM引擎: {}", e))?;
+        let module = Module::from_file(&engine, &path)
+            .map_err(|e| format!("无法加载WASM模块 {}: {}", path, e))?;
+
+        info!("已加载WASM转换模块: {}", path);
+        self.compiled = Some((engine.clone(), module.clone()));
+        Ok((engine, module))
+    }
+
+    /// 在新的沙箱实例中转换一批记录
+    fn transform_records(&mut self, records: Vec<DataRecord>) -> Result<Vec<DataRecord>, String> {
+        let (engine, module) = self.compile()?;
+
+        let fuel = self.config.get("fuel")
+            .and_then(|v| v.as_u64())
+            .unwrap_or(DEFAULT_FUEL);
+        let memory_limit_mb = self.config.get("memory_limit_mb")
+            .and_then(|v| v.as_u64())
+            .unwrap_or(DEFAULT_MEMORY_LIMIT_MB);
+
+        let limits = StoreLimitsBuilder::new()
+            .memory_size((memory_limit_mb * 1024 * 1024) as usize)
+            .instances(1)
+            .build();
+        let mut store = Store::new(&engine, SandboxState { limits });
+        store.limiter(|state| &mut state.limits);
+        store.set_fuel(fuel).map_err(|e| format!("无法设置WASM燃料: {}", e))?;
+
+        // 不链接任何宿主函数，模块无法访问文件、网络等资源
+        let linker = Linker::new(&engine);
+        let instance = linker.instantiate(&mut store, &module)
+            .map_err(|e| format!("WASM模块实例化失败: {}", e))?;
+
+        let memory = instance.get_memory(&mut store, "memory")
+            .ok_or_else(|| "WASM模块未导出memory".to_string())?;
+        let allocate: TypedFunc<i32, i32> = instance.get_typed_func(&mut store, "allocate")
+            .map_err(|e| format!("WASM模块未导出allocate: {}", e))?;
+        let transform: TypedFunc<i32, i32> = instance.get_typed_func(&mut store, "transform")
+            .map_err(|e| format!("WASM模块未导出transform: {}", e))?;
+
+        let input = serde_json::to_vec(&records)
+            .map_err(|e| format!("记录序列化失败: {}", e))?;
+        let input_ptr = write_buffer(&mut store, &memory, &allocate, &input)?;
+
+        let output_ptr = transform.call(&mut store, input_ptr)
+            .map_err(|e| format!("WASM转换执行失败: {}", e))?;
+        let output = read_buffer(&store, &memory, output_ptr)?;
+
+        let transformed: Vec<DataRecord> = serde_json::from_slice(&output)
+            .map_err(|e| format!("WASM转换输出无效: {}", e))?;
+
+        debug!(
+            "WASM转换完成: 输入{}条，输出{}条，剩余燃料{}",
+            records.len(), transformed.len(), store.get_fuel().unwrap_or(0)
+        );
+        Ok(transformed)
+    }
+}
+
+/// 在模块内存中分配空间并写入带长度前缀的数据
+fn write_buffer(
+    store: &mut Store<SandboxState>,
+    memory: &Memory,
+    allocate: &TypedFunc<i32, i32>,
+    data: &[u8],
+) -> Result<i32, String> {
+    let len = i32::try_from(data.len()).map_err(|_| "输入数据过大".to_string())?;
+    let size = len.checked_add(4).ok_or_else(|| "输入数据过大".to_string())?;
+    let ptr = allocate.call(&mut *store, size)
+        .map_err(|e| format!("WASM内存分配失败: {}", e))?;
+
+    let offset = ptr as u32 as usize;
+    memory.write(&mut *store, offset, &len.to_le_bytes())
+        .and_then(|_| memory.write(&mut *store, offset + 4, data))
+        .map_err(|_| "WASM模块返回的内存地址越界".to_string())?;
+
+    Ok(ptr)
+}
+
+/// 从模块内存读取带长度前缀的数据，越界访问返回错误
+fn read_buffer(store: &Store<SandboxState>, memory: &Memory, ptr: i32) -> Result<Vec<u8>, String> {
+    let offset = ptr as u32 as usize;
+
+    let mut len_bytes = [0u8; 4];
+    memory.read(store, offset, &mut len_bytes)
+        .map_err(|_| "WASM模块返回的内存地址越界".to_string())?;
+    let len = u32::from_le_bytes(len_bytes) as usize;
+    if offset.checked_add(4).and_then(|end| end.checked_add(len)).map_or(true, |end| end > memory.data_size(store)) {
+        return Err("WASM模块返回的数据越界".to_string());
+    }
+
+    let mut data = vec![0u8; len];
+    memory.read(store, offset + 4, &mut data)
+        .map_err(|_| "WASM模块返回的数据越界".to_string())?;
+
+    Ok(data)
+}
+
+impl Actor for WasmTransformer {
+    type Context = Context<Self>;
+
+    fn started(&mut self, _: &mut Self::Context) {
+        debug!("WASM转换器已启动");
+    }
+}
+
+impl Handler<TransformData> for WasmTransformer {
+    type Result = ResponseFuture<Result<Vec<DataRecord>, String>>;
+
+    fn handle(&mut self, msg: TransformData, _: &mut Context<Self>) -> Self::Result {
+        let result = self.transform_records(msg.records);
+        Box::pin(async move { result })
+    }
+}
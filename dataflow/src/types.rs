@@ -55,6 +55,9 @@ pub struct PipelineConfig {
     pub sink: SinkConfig,
     pub batch_size: Option<usize>,
     pub parallelism: Option<usize>,
+    /// 提取和加载失败时的重试策略，未配置时不重试
+    #[serde(default)]
+    pub retry_policy: Option<crate::retry::RetryPolicy>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -88,6 +91,15 @@ pub struct JobStats {
     pub records_failed: u64,
     pub error: Option<String>,
     pub retry_count: u32,
+    /// 管道提取阶段的重试次数
+    #[serde(default)]
+    pub extract_retries: u32,
+    /// 管道加载阶段的重试次数
+    #[serde(default)]
+    pub load_retries: u32,
+    /// 幂等写入时跳过的已写入记录数
+    #[serde(default)]
+    pub duplicates_skipped: u64,
 }
 
 /// DAG执行状态
@@ -102,6 +114,34 @@ pub struct DAGExecutionStatus {
     pub job_stats: HashMap<String, JobStats>,
 }
 
+/// DAG执行记录
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExecutionStatus {
+    pub execution_id: String,
+    pub start_time: DateTime<Utc>,
+    pub end_time: Option<DateTime<Utc>>,
+    /// running、paused、draining、success、failed、cancelled或drained
+    pub status: String,
+    /// 各作业的状态和统计信息
+    pub job_statuses: HashMap<String, (JobStatus, Option<JobStats>)>,
+    pub total_jobs: usize,
+    pub completed_jobs: usize,
+    pub failed_jobs: usize,
+    pub skipped_jobs: usize,
+}
+
+/// 执行中单个作业（DAG节点）的状态
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct NodeStatus {
+    pub job_id: String,
+    pub status: JobStatus,
+    /// 该作业依赖的作业
+    pub depends_on: Vec<String>,
+    /// 依赖该作业的作业
+    pub dependents: Vec<String>,
+    pub stats: Option<JobStats>,
+}
+
 /// 数据源接口
 #[async_trait]
 pub trait DataSource: Send + Sync {
@@ -163,6 +203,15 @@ pub struct PipelineStats {
     pub records_processed: u64,
     pub records_written: u64,
     pub records_failed: u64,
+    /// 提取阶段的重试次数
+    #[serde(default)]
+    pub extract_retries: u32,
+    /// 加载阶段的重试次数
+    #[serde(default)]
+    pub load_retries: u32,
+    /// 幂等写入时跳过的已写入记录数
+    #[serde(default)]
+    pub duplicates_skipped: u64,
     pub errors: Vec<String>,
 }
 
@@ -10,6 +10,7 @@ use serde_json::Value;
 use log::{debug, info, warn, error};
 use duckdb::{Connection, params, OptionalExt, TransactionBehavior};
 
+use crate::duckdb_extensions;
 use super::{VectorStore, VectorStoreConfig, VectorSearchResult, DistanceMetric, IndexType};
 
 /// DuckDB向量存储实现
@@ -62,15 +63,17 @@ impl DuckDBVectorStore {
         Ok(())
     }
     
-    /// 加载DuckDB扩展
+    /// 加载允许列表中的DuckDB扩展，未允许的扩展跳过
     fn load_extensions(&self, conn: &Connection) -> Result<()> {
-        // 加载向量扩展
-        conn.execute("INSTALL vector", params![])?;
-        conn.execute("LOAD vector", params![])?;
+        duckdb_extensions::disable_autoloading(conn).map_err(|e| anyhow!(e))?;
         
-        // 其他可能需要的扩展
-        conn.execute("INSTALL spatial", params![])?;
-        conn.execute("LOAD spatial", params![])?;
+        for name in ["vector", "spatial"] {
+            if !duckdb_extensions::is_allowed(name) {
+                warn!("DuckDB扩展{}不在允许列表中，跳过加载", name);
+                continue;
+            }
+            duckdb_extensions::enable(conn, name).map_err(|e| anyhow!(e))?;
+        }
         
         Ok(())
     }
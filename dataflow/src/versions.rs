@@ -0,0 +1,106 @@
+//! 管道定义版本管理
+//!
+//! 每次更新管道定义都保存为一个新版本，回滚时把旧版本复制为新的当前版本，
+//! 历史版本不会被修改或删除。
+
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+
+use crate::config::ETLConfig;
+
+/// 管道定义的一个版本
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ConfigVersion {
+    /// 版本号，从1开始递增
+    pub version: u32,
+    /// 管道定义
+    pub config: ETLConfig,
+    /// 保存时间
+    pub created_at: DateTime<Utc>,
+    /// 由回滚产生时为被恢复的版本号
+    pub rollback_of: Option<u32>,
+}
+
+/// 版本摘要，不含管道定义本身
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ConfigVersionSummary {
+    pub version: u32,
+    pub name: String,
+    pub created_at: DateTime<Utc>,
+    pub rollback_of: Option<u32>,
+    /// 是否为当前版本
+    pub current: bool,
+}
+
+/// 管道定义的版本历史
+#[derive(Debug, Default)]
+pub struct PipelineVersions {
+    versions: Vec<ConfigVersion>,
+}
+
+impl PipelineVersions {
+    /// 创建空的版本历史
+    pub const fn new() -> Self {
+        Self { versions: Vec::new() }
+    }
+
+    /// 从已保存的版本恢复版本历史
+    pub fn from_versions(mut versions: Vec<ConfigVersion>) -> Self {
+        versions.sort_by_key(|v| v.version);
+        Self { versions }
+    }
+    
+    /// 保存新版本并返回版本号
+    pub fn record(&mut self, config: ETLConfig) -> u32 {
+        self.push(config, None)
+    }
+
+    /// 将`version`复制为新的当前版本
+    pub fn rollback(&mut self, version: u32) -> Result<&ConfigVersion, String> {
+        let config = self.get(version)
+            .map(|v| v.config.clone())
+            .ok_or_else(|| format!("找不到管道版本: {}", version))?;
+        self.push(config, Some(version));
+        Ok(self.versions.last().unwrap())
+    }
+
+    /// 下一个保存的版本的版本号
+    pub fn next_version(&self) -> u32 {
+        self.current().map_or(1, |v| v.version + 1)
+    }
+
+    /// 当前版本
+    pub fn current(&self) -> Option<&ConfigVersion> {
+        self.versions.last()
+    }
+
+    /// 获取指定版本
+    pub fn get(&self, version: u32) -> Option<&ConfigVersion> {
+        self.versions.iter().find(|v| v.version == version)
+    }
+
+    /// 按版本号升序列出所有版本
+    pub fn list(&self) -> Vec<ConfigVersionSummary> {
+        let current = self.current().map(|v| v.version);
+        self.versions.iter()
+            .map(|v| ConfigVersionSummary {
+                version: v.version,
+                name: v.config.name.clone(),
+                created_at: v.created_at,
+                rollback_of: v.rollback_of,
+                current: Some(v.version) == current,
+            })
+            .collect()
+    }
+
+    fn push(&mut self, config: ETLConfig, rollback_of: Option<u32>) -> u32 {
+        let version = self.next_version();
+        self.versions.push(ConfigVersion {
+            version,
+            config,
+            created_at: Utc::now(),
+            rollback_of,
+        });
+        version
+    }
+}
@@ -0,0 +1,51 @@
+use std::path::PathBuf;
+use serde_json::json;
+use lumos_dataflow::config::ETLConfig;
+use lumos_dataflow::testkit::PipelineTest;
+
+fn fixture(name: &str) -> PathBuf {
+    PathBuf::from(env!("CARGO_MANIFEST_DIR")).join("tests/fixtures").join(name)
+}
+
+#[actix_rt::test]
+async fn test_orders_pipeline_with_fixtures() {
+    let config = ETLConfig::from_file(fixture("orders.yaml")).unwrap();
+    let run = PipelineTest::from_config(&config, "clean_orders").unwrap()
+        .with_fixture_file(fixture("orders.jsonl")).unwrap()
+        .run()
+        .await
+        .unwrap();
+
+    assert_eq!(run.steps.len(), 3);
+    assert_eq!(run.step("filter").unwrap().input_count, 4);
+    run.assert_step_count("filter", 3);
+    run.assert_step_count("dedup", 2);
+    run.assert_step_count("2", 2);
+    run.assert_sink_count(2);
+    run.assert_sink_contains("order_id", json!(3));
+    run.assert_sink_eq(vec![
+        json!({"order_id": 1, "status": "paid", "card": "****-****-****-1234", "amount": 20}),
+        json!({"order_id": 3, "status": "paid", "card": "****-****-***0-009", "amount": 12}),
+    ]);
+}
+
+#[actix_rt::test]
+async fn test_failed_step_stops_pipeline() {
+    let config = ETLConfig::from_file(fixture("orders.yaml")).unwrap();
+    let mut pipeline = config.jobs["clean_orders"].pipeline.clone();
+    pipeline.transformers[0].config = json!({"condition": "status paid"});
+
+    let run = PipelineTest::new(&pipeline)
+        .with_events(vec![json!({"order_id": 1, "status": "paid"})]).unwrap()
+        .run()
+        .await
+        .unwrap();
+
+    assert!(!run.succeeded());
+    assert_eq!(run.steps.len(), 1);
+    run.assert_step_failed("filter", "无效的过滤条件格式");
+    assert!(run.sink_output.is_empty());
+
+    assert!(PipelineTest::from_config(&config, "missing").is_err());
+    assert!(PipelineTest::new(&pipeline).with_events(vec![json!([1, 2])]).is_err());
+}
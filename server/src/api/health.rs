@@ -1,13 +1,33 @@
 use actix_web::{web, HttpResponse, Responder};
 use chrono::Utc;
 use serde::Serialize;
+use std::sync::Arc;
 use crate::models::response::ApiResponse;
+use crate::utils::memory_budget::{MemoryBudget, MemoryBudgetStatus};
+use crate::utils::disk_guard::{DiskGuard, DiskSpaceStatus};
+use crate::utils::degradation::{DegradationController, DegradationStatus};
+use crate::utils::admission::{AdmissionController, AdmissionStatus};
+use crate::utils::warmup::Warmup;
+use crate::utils::integrity::IntegrityReport;
+use crate::utils::error_budget::{ErrorBudgetStatus, ErrorBudgetTracker};
 
 #[derive(Serialize)]
 pub struct HealthInfo {
     status: String,
     version: String,
     timestamp: i64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    memory: Option<MemoryBudgetStatus>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    disk: Option<DiskSpaceStatus>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    degradation: Option<DegradationStatus>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    admission: Option<AdmissionStatus>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    integrity: Option<IntegrityReport>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error_budget: Option<ErrorBudgetStatus>,
 }
 
 // 配置健康检查API路由
@@ -16,12 +36,48 @@ pub fn configure(cfg: &mut web::ServiceConfig) {
 }
 
 // 健康检查处理程序
-async fn health_check() -> impl Responder {
+pub async fn health_check(
+    memory_budget: Option<web::Data<Arc<MemoryBudget>>>,
+    disk_guard: Option<web::Data<Arc<DiskGuard>>>,
+    degradation: Option<web::Data<Arc<DegradationController>>>,
+    admission: Option<web::Data<Arc<AdmissionController>>>,
+    integrity: Option<web::Data<Arc<IntegrityReport>>>,
+    error_budget: Option<web::Data<Arc<ErrorBudgetTracker>>>,
+) -> impl Responder {
+    let integrity = integrity.map(|report| report.get_ref().as_ref().clone());
+    // 启动检查发现无法修复的问题时标记为降级
+    let status = if integrity.as_ref().map_or(false, |report| report.has_failures()) { "degraded" } else { "ok" };
     let health_info = HealthInfo {
-        status: "ok".to_string(),
+        status: status.to_string(),
         version: env!("CARGO_PKG_VERSION").to_string(),
         timestamp: Utc::now().timestamp(),
+        memory: memory_budget.map(|budget| budget.status()),
+        disk: disk_guard.and_then(|guard| guard.status().ok()),
+        degradation: degradation.map(|controller| controller.status()),
+        admission: admission.map(|controller| controller.status()),
+        integrity,
+        error_budget: error_budget.map(|tracker| tracker.status()),
     };
     
     HttpResponse::Ok().json(ApiResponse::success(health_info))
+}
+
+// 就绪探针，冷启动预热完成前返回503
+pub async fn readiness(
+    warmup: Option<web::Data<Arc<Warmup>>>,
+) -> impl Responder {
+    let warmup = match warmup {
+        Some(warmup) => warmup,
+        None => return HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({ "ready": true }))),
+    };
+    
+    let body = serde_json::json!({
+        "ready": warmup.is_ready(),
+        "warmup": warmup.status(),
+    });
+    if warmup.is_ready() {
+        HttpResponse::Ok().json(ApiResponse::success(body))
+    } else {
+        HttpResponse::ServiceUnavailable().json(ApiResponse::success(body))
+    }
 } 
\ No newline at end of file
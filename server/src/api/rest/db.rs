@@ -1,15 +1,22 @@
-use actix_web::{web, HttpResponse, Responder};
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
 use std::sync::Arc;
 
 use crate::db::executor::DbExecutor;
 use crate::models::db::{QueryRequest, QueryResponse, ExecuteRequest, ExecuteResponse};
 use crate::models::response::ApiResponse;
 use crate::utils::error::AppError;
+use crate::middleware::read_only::{access_mode, is_write_sql, read_only_error, AccessMode};
 
 async fn query(
+    http_req: HttpRequest,
     db: web::Data<Arc<DbExecutor>>,
     req: web::Json<QueryRequest>,
-) -> Result<impl Responder, AppError> {
+) -> Result<HttpResponse, AppError> {
+    // 只读模式下不允许通过查询端点执行写语句
+    if access_mode(&http_req) == AccessMode::ReadOnly && is_write_sql(&req.sql) {
+        return Ok(read_only_error());
+    }
+    
     // 执行查询
     let rows = db.execute_query(&req.sql, &req.params)?;
     
@@ -0,0 +1,62 @@
+use std::sync::Arc;
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
+
+use crate::middleware::auth::{key_label, require_admin};
+use crate::models::response::{ApiResponse, ApiError};
+use crate::utils::runtime_config::{RuntimeConfig, SettingsPatch};
+
+// 配置运行时配置管理路由，仅管理员密钥可用
+pub fn configure(cfg: &mut web::ServiceConfig) {
+    cfg.service(
+        web::scope("/admin/config")
+            .route("", web::get().to(get_config))
+            .route("", web::patch().to(update_config))
+            .route("/changes", web::get().to(list_changes))
+    );
+}
+
+// 获取当前的运行时配置
+async fn get_config(
+    req: HttpRequest,
+    config: web::Data<Arc<RuntimeConfig>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Changing server configuration") {
+        return response;
+    }
+    HttpResponse::Ok().json(ApiResponse::success(config.settings()))
+}
+
+// 修改运行时配置，立即生效
+async fn update_config(
+    req: HttpRequest,
+    config: web::Data<Arc<RuntimeConfig>>,
+    patch: web::Json<SettingsPatch>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Changing server configuration") {
+        return response;
+    }
+
+    match config.apply(&patch, &key_label(&req)) {
+        Ok(changes) => HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+            "settings": config.settings(),
+            "changes": changes,
+        }))),
+        Err(e) => HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+            ApiError::new("INVALID_CONFIG", &e)
+        )),
+    }
+}
+
+// 最近的配置变更记录
+async fn list_changes(
+    req: HttpRequest,
+    config: web::Data<Arc<RuntimeConfig>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Changing server configuration") {
+        return response;
+    }
+    HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+        "changes": config.changes()
+    })))
+}
+
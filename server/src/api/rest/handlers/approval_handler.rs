@@ -0,0 +1,149 @@
+use std::sync::Arc;
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
+
+use crate::db::DbExecutor;
+use crate::db::approvals::{ApprovalError, ApprovalQueue, Operation};
+use crate::db::vector_executor::{VectorExecutor, VectorExecutorExtension};
+use crate::middleware::auth::{key_label, key_role, require_admin, KeyRole};
+use crate::models::response::{ApiResponse, ApiError};
+
+// 配置破坏性操作审批路由，仅管理员密钥可用
+pub fn configure(cfg: &mut web::ServiceConfig) {
+    cfg.service(
+        web::scope("/approvals")
+            .route("", web::get().to(list_operations))
+            .route("/{id}", web::get().to(get_operation))
+            .route("/{id}/approve", web::post().to(approve_operation))
+            .route("/{id}/reject", web::post().to(reject_operation))
+    );
+}
+
+/// 审批模式下非管理员密钥提交的破坏性操作加入审批队列
+///
+/// 返回`Err`时操作已加入队列，处理程序直接返回该响应（202 Accepted）。
+/// 未启用审批模式、使用管理员密钥或操作不是破坏性操作时返回`Ok`。
+pub fn require_approval(
+    req: &HttpRequest,
+    approvals: &Option<web::Data<Arc<ApprovalQueue>>>,
+    operation: Operation,
+) -> Result<(), HttpResponse> {
+    let approvals = match approvals {
+        Some(approvals) => approvals,
+        None => return Ok(()),
+    };
+    if key_role(req) == KeyRole::Admin || !operation.is_destructive() {
+        return Ok(());
+    }
+    let pending = approvals.submit(operation, &key_label(req));
+    Err(HttpResponse::Accepted().json(ApiResponse::success(pending)))
+}
+
+// 列出待审批和已处理的操作
+async fn list_operations(
+    req: HttpRequest,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Reviewing pending operations") {
+        return response;
+    }
+    match approvals {
+        Some(approvals) => HttpResponse::Ok().json(ApiResponse::success(approvals.list())),
+        None => approvals_disabled(),
+    }
+}
+
+// 获取操作的审批状态
+async fn get_operation(
+    req: HttpRequest,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Reviewing pending operations") {
+        return response;
+    }
+    let approvals = match approvals {
+        Some(approvals) => approvals,
+        None => return approvals_disabled(),
+    };
+    let id = path.into_inner();
+    match approvals.get(&id) {
+        Some(pending) => HttpResponse::Ok().json(ApiResponse::success(pending)),
+        None => approval_error(ApprovalError::NotFound(id)),
+    }
+}
+
+// 批准并执行操作
+async fn approve_operation(
+    req: HttpRequest,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Approving pending operations") {
+        return response;
+    }
+    let approvals = match approvals {
+        Some(approvals) => approvals,
+        None => return approvals_disabled(),
+    };
+    let id = path.into_inner();
+    let pending = match approvals.approve(&id, &key_label(&req)) {
+        Ok(pending) => pending,
+        Err(e) => return approval_error(e),
+    };
+
+    let result = match &pending.operation {
+        Operation::Sql { sql, params } => {
+            db_executor.execute(sql, params).map(|_| ()).map_err(|e| e.to_string())
+        },
+        Operation::DeleteCollection { name } => vector_executor.delete_collection(name.clone()).await,
+        Operation::DropHistory { table } => {
+            db_executor.disable_history(table, true).map_err(|e| e.to_string())
+        },
+    };
+    if let Err(e) = &result {
+        log::error!("Approved operation {} failed: {}", id, e);
+    }
+
+    match approvals.finish(&id, result) {
+        Some(pending) => HttpResponse::Ok().json(ApiResponse::success(pending)),
+        None => approval_error(ApprovalError::NotFound(id)),
+    }
+}
+
+// 拒绝操作，操作不会执行
+async fn reject_operation(
+    req: HttpRequest,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Rejecting pending operations") {
+        return response;
+    }
+    let approvals = match approvals {
+        Some(approvals) => approvals,
+        None => return approvals_disabled(),
+    };
+    match approvals.reject(&path.into_inner(), &key_label(&req)) {
+        Ok(pending) => HttpResponse::Ok().json(ApiResponse::success(pending)),
+        Err(e) => approval_error(e),
+    }
+}
+
+fn approvals_disabled() -> HttpResponse {
+    HttpResponse::NotFound().json(ApiResponse::<()>::error(
+        ApiError::new("APPROVALS_DISABLED", "Approval mode is not enabled, set LUMOS_APPROVAL_MODE to enable it")
+    ))
+}
+
+fn approval_error(e: ApprovalError) -> HttpResponse {
+    match e {
+        ApprovalError::NotFound(_) => HttpResponse::NotFound().json(ApiResponse::<()>::error(
+            ApiError::new("OPERATION_NOT_FOUND", &e.to_string())
+        )),
+        ApprovalError::NotPending(_) => HttpResponse::Conflict().json(ApiResponse::<()>::error(
+            ApiError::new("OPERATION_NOT_PENDING", &e.to_string())
+        )),
+    }
+}
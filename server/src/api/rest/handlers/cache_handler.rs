@@ -1,33 +1,101 @@
 use std::sync::Arc;
-use actix_web::{web, HttpResponse, Responder};
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
 use log::info;
 
 use crate::db::cached_executor::CachedDbExecutor;
-use crate::models::response::ApiResponse;
+use crate::cache::NamedQuery;
+use crate::middleware::auth::require_admin;
+use crate::models::response::{ApiResponse, ApiError};
+
+// 配置查询结果缓存路由
+pub fn configure(cfg: &mut web::ServiceConfig) {
+    cfg.service(
+        web::scope("/cache")
+            .route("", web::delete().to(clear_all_cache))
+            .route("/status", web::get().to(get_cache_status))
+            .route("/tables/{table}", web::delete().to(invalidate_table_cache))
+            .route("/named", web::get().to(list_named_queries))
+            .route("/named", web::post().to(register_named_query))
+            .route("/keys/{key}", web::get().to(get_cache_key))
+    );
+}
 
 /// 清空所有缓存
 pub async fn clear_all_cache(
-    _cached_executor: web::Data<Arc<CachedDbExecutor>>,
+    cached_executor: web::Data<Arc<CachedDbExecutor>>,
 ) -> impl Responder {
-    info!("Cache functionality not implemented");
+    cached_executor.clear_cache();
+    info!("Query cache cleared");
     HttpResponse::Ok().json(ApiResponse::<()>::success(()))
 }
 
 /// 清理特定表相关的缓存
 pub async fn invalidate_table_cache(
-    _cached_executor: web::Data<Arc<CachedDbExecutor>>,
+    cached_executor: web::Data<Arc<CachedDbExecutor>>,
     path: web::Path<String>,
 ) -> impl Responder {
     let table_name = path.into_inner();
-    info!("Cache invalidation for table {} not implemented", table_name);
+    let removed = cached_executor.invalidate_table(&table_name);
+    info!("Invalidated {} cached queries for table {}", removed, table_name);
     
-    HttpResponse::Ok().json(ApiResponse::<()>::success(()))
+    HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+        "table": table_name,
+        "invalidated": removed,
+    })))
 }
 
 /// 获取缓存状态信息
 pub async fn get_cache_status(
-    _cached_executor: web::Data<Arc<CachedDbExecutor>>,
+    cached_executor: web::Data<Arc<CachedDbExecutor>>,
+) -> impl Responder {
+    HttpResponse::Ok().json(ApiResponse::success(cached_executor.cache_status()))
+}
+
+/// 注册命名查询
+pub async fn register_named_query(
+    req: HttpRequest,
+    cached_executor: web::Data<Arc<CachedDbExecutor>>,
+    query: web::Json<NamedQuery>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Registering named queries") {
+        return response;
+    }
+    let query = query.into_inner();
+    let name = query.name.clone();
+    match cached_executor.register_named_query(query) {
+        Ok(()) => {
+            info!("Registered named query {}", name);
+            HttpResponse::Ok().json(ApiResponse::success(cached_executor.named_queries()))
+        },
+        Err(e) => HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+            ApiError::new("INVALID_NAMED_QUERY", &e.to_string())
+        )),
+    }
+}
+
+/// 列出命名查询
+pub async fn list_named_queries(
+    cached_executor: web::Data<Arc<CachedDbExecutor>>,
 ) -> impl Responder {
-    // 由于当前没有直接提供获取缓存统计信息的方法，这里返回未实现
-    HttpResponse::NotImplemented().json(ApiResponse::<()>::error("Cache functionality not implemented"))
-} 
\ No newline at end of file
+    HttpResponse::Ok().json(ApiResponse::success(cached_executor.named_queries()))
+}
+
+/// 按缓存键读取，未命中时执行绑定的命名查询
+pub async fn get_cache_key(
+    cached_executor: web::Data<Arc<CachedDbExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let key = path.into_inner();
+    match cached_executor.get_by_key(&key) {
+        Ok(Some(rows)) => {
+            let rows: Vec<_> = rows.into_iter().map(|row| row.values).collect();
+            HttpResponse::Ok().json(ApiResponse::success(rows))
+        },
+        Ok(None) => HttpResponse::NotFound().json(ApiResponse::<()>::error(
+            ApiError::new("NO_NAMED_QUERY", &format!("No named query matches cache key '{}'", key))
+        )),
+        Err(e) => HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+            ApiError::new("QUERY_ERROR", &e.to_string())
+        )),
+    }
+}
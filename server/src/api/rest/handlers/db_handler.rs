@@ -1,23 +1,43 @@
+use std::hash::{Hash, Hasher};
+use std::path::{Path, PathBuf};
 use std::sync::Arc;
-use actix_web::{web, HttpResponse, Responder, post, get, delete, put};
+use actix_web::{web, http::header, HttpRequest, HttpResponse, Responder, post, get, delete, put};
 use log::error;
 use serde::{Deserialize, Serialize};
 use once_cell::sync::Lazy;
-use crate::db::{DbExecutor, DbExecutorExtension};
+use crate::db::{CachedDbExecutor, DbExecutor, DbExecutorExtension};
 use crate::models::response::{ApiResponse, ApiError};
+use crate::middleware::read_only::{access_mode, is_write_sql, read_only_error, AccessMode};
+use crate::middleware::binding::BindingPolicy;
+use crate::middleware::auth::{key_label, require_admin};
+use crate::db::approvals::{ApprovalQueue, Operation};
+use super::approval_handler::require_approval;
 use crate::utils::perf_monitor::PerfMonitor;
+use crate::utils::degradation::DegradationController;
+use crate::utils::admission::{AdmissionController, AdmissionPermit};
+use crate::utils::anomaly::AnomalyDetector;
+use crate::utils::runtime_config::{RuntimeConfig, DEFAULT_SLOW_QUERY_MS};
 use crate::models::db::{ColumnInfo as ModelColumnInfo};
+use lumos_core::LumosError;
+use lumos_core::sqlite::snapshot::SnapshotStore;
 
 // 查询请求
 #[derive(Debug, Deserialize)]
 pub struct QueryRequest {
     pub sql: String,
+    #[serde(default)]
+    pub params: Vec<String>,
+    /// RFC 3339时间，指定时在该时刻之前最近的快照上执行查询
+    #[serde(default)]
+    pub as_of: Option<String>,
 }
 
 // 执行SQL请求
 #[derive(Debug, Deserialize)]
 pub struct ExecuteRequest {
     pub sql: String,
+    #[serde(default)]
+    pub params: Vec<String>,
 }
 
 // 创建表请求
@@ -26,6 +46,18 @@ pub struct CreateTableRequest {
     pub sql: String, // CREATE TABLE SQL语句
 }
 
+/// 允许导入的数据库文件目录，设置后导入路径必须位于该目录内
+#[derive(Debug, Clone)]
+pub struct ImportDir(pub PathBuf);
+
+// 导入SQLite文件请求
+#[derive(Debug, Deserialize)]
+pub struct ImportRequest {
+    pub path: String,
+    #[serde(default)]
+    pub replace_existing: bool,
+}
+
 // 表信息响应
 #[derive(Debug, Serialize)]
 pub struct TableInfo {
@@ -52,20 +84,79 @@ pub fn configure(cfg: &mut web::ServiceConfig) {
             .route("/query", web::post().to(query))
             .route("/execute", web::post().to(execute_sql))
             .route("/tables", web::get().to(get_tables))
+            .route("/catalog", web::get().to(schema_catalog))
             .route("/tables/{table_name}", web::get().to(get_table_info))
             .route("/tables", web::post().to(create_table))
             .route("/tables/{table_name}", web::delete().to(drop_table))
+            .route("/tables/{table_name}/history", web::get().to(table_history))
+            .route("/tables/{table_name}/history", web::post().to(enable_history))
+            .route("/tables/{table_name}/history", web::delete().to(disable_history))
+            .route("/import", web::post().to(import_sqlite))
+            .route("/stats", web::get().to(query_stats))
+            .route("/advisor", web::get().to(index_advisor))
+            .route("/anomalies", web::get().to(workload_anomalies))
+            .route("/snapshots", web::get().to(list_snapshots))
+            .route("/snapshots", web::post().to(take_snapshot))
     );
 }
 
 async fn query(
+    req: HttpRequest,
     db_executor: web::Data<Arc<DbExecutor>>,
+    cached_executor: Option<web::Data<Arc<CachedDbExecutor>>>,
+    degradation: Option<web::Data<Arc<DegradationController>>>,
+    admission: Option<web::Data<Arc<AdmissionController>>>,
+    binding: Option<web::Data<BindingPolicy>>,
+    anomalies: Option<web::Data<Arc<AnomalyDetector>>>,
+    snapshots: Option<web::Data<Arc<SnapshotStore>>>,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
     query_req: web::Json<QueryRequest>,
 ) -> impl Responder {
+    // 只读模式下不允许通过查询端点执行写语句
+    if access_mode(&req) == AccessMode::ReadOnly && is_write_sql(&query_req.sql) {
+        return read_only_error();
+    }
+    
+    let operation = Operation::Sql { sql: query_req.sql.clone(), params: query_req.params.clone() };
+    if let Err(response) = require_approval(&req, &approvals, operation) {
+        return response;
+    }
+    
+    // 严格参数绑定模式下拒绝内联字符串字面量
+    if let Some(policy) = &binding {
+        if let Err(response) = policy.check(&req, &query_req.sql, &db_executor) {
+            return response;
+        }
+    }
+    
+    // 并发查询过多时排队或拒绝
+    let _permit = match admit(&admission).await {
+        Ok(permit) => permit,
+        Err(response) => return response,
+    };
+    
     // 启动性能监控
     let _timer = QUERY_MONITOR.start();
     
-    match db_executor.query(query_req.sql.clone()) {
+    // 内存即将耗尽时限制返回的行数
+    let sql = match &degradation {
+        Some(controller) => controller.limit_query(&query_req.sql).into_owned(),
+        None => query_req.sql.clone(),
+    };
+    
+    // 按时间点查询时在保留的快照上执行
+    if let Some(as_of) = &query_req.as_of {
+        return query_snapshot(&req, &db_executor, &snapshots, &anomalies, as_of, &sql, &query_req.params);
+    }
+    
+    // 启用查询结果缓存时读查询使用缓存，写语句由缓存执行器转发并使缓存失效
+    let result = match cached_executor.as_ref().filter(|cached| cached.serves_queries()) {
+        Some(cached) => cached.query_with_params(&sql, &query_req.params).map_err(|e| e.to_string()),
+        None => db_executor.query_with_params(sql, &query_req.params),
+    };
+    record_workload(&anomalies, &req, result.as_ref().map_or(0, |rows| rows.len() as u64), result.is_err());
+    
+    match result {
         Ok(rows) => {
             HttpResponse::Ok().json(ApiResponse::success(rows))
         },
@@ -79,13 +170,37 @@ async fn query(
 }
 
 async fn execute_sql(
+    req: HttpRequest,
     db_executor: web::Data<Arc<DbExecutor>>,
+    admission: Option<web::Data<Arc<AdmissionController>>>,
+    binding: Option<web::Data<BindingPolicy>>,
+    anomalies: Option<web::Data<Arc<AnomalyDetector>>>,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
     execute_req: web::Json<ExecuteRequest>,
 ) -> impl Responder {
+    if let Some(policy) = &binding {
+        if let Err(response) = policy.check(&req, &execute_req.sql, &db_executor) {
+            return response;
+        }
+    }
+    
+    let operation = Operation::Sql { sql: execute_req.sql.clone(), params: execute_req.params.clone() };
+    if let Err(response) = require_approval(&req, &approvals, operation) {
+        return response;
+    }
+    
+    let _permit = match admit(&admission).await {
+        Ok(permit) => permit,
+        Err(response) => return response,
+    };
+    
     // 启动性能监控
     let _timer = EXECUTE_MONITOR.start();
     
-    match db_executor.execute_sql(execute_req.sql.clone()) {
+    let result = db_executor.execute_sql_with_params(execute_req.sql.clone(), &execute_req.params);
+    record_workload(&anomalies, &req, result.as_ref().map_or(0, |rows| *rows as u64), result.is_err());
+    
+    match result {
         Ok(affected_rows) => {
             HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
                 "affected_rows": affected_rows
@@ -100,6 +215,201 @@ async fn execute_sql(
     }
 }
 
+// 在`as_of`时刻之前最近的快照上执行查询
+fn query_snapshot(
+    req: &HttpRequest,
+    db_executor: &DbExecutor,
+    snapshots: &Option<web::Data<Arc<SnapshotStore>>>,
+    anomalies: &Option<web::Data<Arc<AnomalyDetector>>>,
+    as_of: &str,
+    sql: &str,
+    params: &[String],
+) -> HttpResponse {
+    let store = match snapshots {
+        Some(store) => store,
+        None => return snapshots_disabled(),
+    };
+    
+    let as_of_ms = match chrono::DateTime::parse_from_rfc3339(as_of) {
+        Ok(time) => time.timestamp_millis(),
+        Err(e) => {
+            return HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+                ApiError::new("INVALID_AS_OF", &format!("Invalid as_of time '{}': {}", as_of, e))
+            ));
+        }
+    };
+    
+    let result = db_executor.query_as_of(store, as_of_ms, sql, params);
+    record_workload(anomalies, req, result.as_ref().map_or(0, |(_, rows)| rows.len() as u64), result.is_err());
+    
+    match result {
+        Ok((snapshot, rows)) => {
+            HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+                "as_of": as_of,
+                "snapshot": snapshot,
+                "rows": rows,
+            })))
+        },
+        Err(LumosError::NotFound(msg)) => {
+            HttpResponse::NotFound().json(ApiResponse::<()>::error(
+                ApiError::new("SNAPSHOT_NOT_FOUND", &msg)
+            ))
+        },
+        Err(e) => {
+            log::error!("Snapshot query error: {}", e);
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+                ApiError::new("DATABASE_QUERY_ERROR", &format!("Query failed: {}", e))
+            ))
+        }
+    }
+}
+
+fn snapshots_disabled() -> HttpResponse {
+    HttpResponse::NotFound().json(ApiResponse::<()>::error(
+        ApiError::new("SNAPSHOTS_DISABLED", "Database snapshots are not enabled")
+    ))
+}
+
+// 申请查询执行名额，未配置准入控制时直接放行
+async fn admit(
+    admission: &Option<web::Data<Arc<AdmissionController>>>,
+) -> Result<Option<AdmissionPermit>, HttpResponse> {
+    let controller = match admission {
+        Some(controller) => controller,
+        None => return Ok(None),
+    };
+    
+    controller.admit().await.map(Some).map_err(|e| {
+        HttpResponse::ServiceUnavailable().json(ApiResponse::<()>::error(
+            ApiError::new("TOO_MANY_QUERIES", &e.to_string())
+        ))
+    })
+}
+
+// 记录请求所用密钥的负载，未启用异常检测时忽略
+fn record_workload(
+    anomalies: &Option<web::Data<Arc<AnomalyDetector>>>,
+    req: &HttpRequest,
+    rows: u64,
+    failed: bool,
+) {
+    if let Some(detector) = anomalies {
+        detector.record(&key_label(req), rows, failed);
+    }
+}
+
+// 负载异常告警和各密钥的基线
+async fn workload_anomalies(
+    anomalies: Option<web::Data<Arc<AnomalyDetector>>>,
+) -> impl Responder {
+    let detector = match anomalies {
+        Some(detector) => detector,
+        None => {
+            return HttpResponse::NotFound().json(ApiResponse::<()>::error(
+                ApiError::new("ANOMALY_DETECTION_DISABLED", "Workload anomaly detection is not enabled")
+            ));
+        }
+    };
+    
+    detector.check();
+    HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+        "sensitivity": detector.sensitivity(),
+        "alerts": detector.alerts(),
+        "keys": detector.status(),
+    })))
+}
+
+// 列出保留的数据库快照
+async fn list_snapshots(
+    snapshots: Option<web::Data<Arc<SnapshotStore>>>,
+) -> impl Responder {
+    let store = match snapshots {
+        Some(store) => store,
+        None => return snapshots_disabled(),
+    };
+    
+    match store.list() {
+        Ok(list) => {
+            HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+                "snapshots": list
+            })))
+        },
+        Err(e) => {
+            log::error!("Error listing snapshots: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("SNAPSHOT_ERROR", &format!("Failed to list snapshots: {}", e))
+            ))
+        }
+    }
+}
+
+// 立即保存一份数据库快照（需要管理员密钥）
+async fn take_snapshot(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    snapshots: Option<web::Data<Arc<SnapshotStore>>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Taking snapshots") {
+        return response;
+    }
+    
+    let store = match snapshots {
+        Some(store) => store,
+        None => return snapshots_disabled(),
+    };
+    
+    match db_executor.take_snapshot(&store) {
+        Ok(snapshot) => HttpResponse::Created().json(ApiResponse::success(snapshot)),
+        Err(e) => {
+            log::error!("Error taking snapshot: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("SNAPSHOT_ERROR", &format!("Failed to take snapshot: {}", e))
+            ))
+        }
+    }
+}
+
+// 按查询指纹汇总的执行统计
+async fn query_stats(
+    db_executor: web::Data<Arc<DbExecutor>>,
+) -> impl Responder {
+    HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+        "queries": db_executor.query_stats()
+    })))
+}
+
+// 索引建议查询参数
+#[derive(Debug, Deserialize)]
+pub struct AdvisorParams {
+    /// 平均耗时不低于该值（毫秒）的查询视为慢查询，默认使用运行时配置的阈值
+    #[serde(default)]
+    pub slow_ms: Option<f64>,
+}
+
+// 根据慢查询统计给出索引建议
+async fn index_advisor(
+    db_executor: web::Data<Arc<DbExecutor>>,
+    params: web::Query<AdvisorParams>,
+    runtime_config: Option<web::Data<Arc<RuntimeConfig>>>,
+) -> impl Responder {
+    let slow_ms = params.slow_ms.unwrap_or_else(|| {
+        runtime_config.map_or(DEFAULT_SLOW_QUERY_MS, |config| config.slow_query_ms())
+    });
+    match db_executor.index_suggestions(slow_ms) {
+        Ok(suggestions) => {
+            HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+                "suggestions": suggestions
+            })))
+        },
+        Err(e) => {
+            log::error!("Index advisor error: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("ADVISOR_ERROR", &format!("Failed to analyze slow queries: {}", e))
+            ))
+        }
+    }
+}
+
 async fn get_tables(
     db_executor: web::Data<Arc<DbExecutor>>,
 ) -> impl Responder {
@@ -118,6 +428,48 @@ async fn get_tables(
     }
 }
 
+// 用于自动补全的表、列、索引、函数和命名查询
+//
+// ETag由结构版本和命名查询组成，客户端带If-None-Match轮询时目录未变化返回304。
+async fn schema_catalog(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    cached_executor: Option<web::Data<Arc<CachedDbExecutor>>>,
+) -> impl Responder {
+    let catalog = match db_executor.schema_catalog() {
+        Ok(catalog) => catalog,
+        Err(e) => {
+            log::error!("Error reading schema catalog: {}", e);
+            return HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("DATABASE_ERROR", &format!("Failed to read schema catalog: {}", e))
+            ));
+        }
+    };
+    let named_queries = cached_executor.map(|c| c.named_queries()).unwrap_or_default();
+
+    let mut hasher = std::collections::hash_map::DefaultHasher::new();
+    for query in &named_queries {
+        (&query.name, &query.key_pattern, &query.sql, &query.params).hash(&mut hasher);
+    }
+    let etag = format!("\"{}-{:x}\"", catalog.version, hasher.finish());
+    let unchanged = req.headers().get(header::IF_NONE_MATCH)
+        .and_then(|v| v.to_str().ok())
+        .map_or(false, |v| v.split(',').any(|tag| tag.trim() == etag));
+    if unchanged {
+        return HttpResponse::NotModified().insert_header((header::ETAG, etag)).finish();
+    }
+
+    HttpResponse::Ok()
+        .insert_header((header::ETAG, etag))
+        .json(ApiResponse::success(serde_json::json!({
+            "version": catalog.version,
+            "tables": catalog.tables,
+            "indexes": catalog.indexes,
+            "functions": catalog.functions,
+            "named_queries": named_queries,
+        })))
+}
+
 async fn get_table_info(
     db_executor: web::Data<Arc<DbExecutor>>,
     path: web::Path<String>,
@@ -161,12 +513,19 @@ async fn create_table(
 }
 
 async fn drop_table(
+    req: HttpRequest,
     db_executor: web::Data<Arc<DbExecutor>>,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
     path: web::Path<String>,
 ) -> impl Responder {
     let table_name = path.into_inner();
     let drop_sql = format!("DROP TABLE IF EXISTS {}", table_name);
     
+    let operation = Operation::Sql { sql: drop_sql.clone(), params: Vec::new() };
+    if let Err(response) = require_approval(&req, &approvals, operation) {
+        return response;
+    }
+    
     match db_executor.execute_sql(drop_sql) {
         Ok(_) => {
             HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
@@ -181,3 +540,154 @@ async fn drop_table(
         }
     }
 }
+
+// 行历史查询参数
+#[derive(Debug, Deserialize)]
+pub struct HistoryParams {
+    /// RFC 3339时间，返回表在该时刻的状态
+    pub at: Option<String>,
+    /// 返回该行的所有历史版本
+    pub row_id: Option<i64>,
+}
+
+// 停用行历史参数
+#[derive(Debug, Deserialize)]
+pub struct DisableHistoryParams {
+    /// 同时删除历史表
+    #[serde(default)]
+    pub drop: bool,
+}
+
+// 为表启用行历史记录（需要管理员密钥）
+async fn enable_history(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Enabling row history") {
+        return response;
+    }
+    
+    let table_name = path.into_inner();
+    
+    match db_executor.enable_history(&table_name) {
+        Ok(temporal) => HttpResponse::Ok().json(ApiResponse::success(temporal)),
+        Err(e) => history_error(&table_name, e),
+    }
+}
+
+// 停用表的行历史记录（需要管理员密钥），删除历史表需要审批
+async fn disable_history(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
+    path: web::Path<String>,
+    params: web::Query<DisableHistoryParams>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Disabling row history") {
+        return response;
+    }
+    
+    let table_name = path.into_inner();
+    
+    if params.drop {
+        let operation = Operation::DropHistory { table: table_name.clone() };
+        if let Err(response) = require_approval(&req, &approvals, operation) {
+            return response;
+        }
+    }
+    
+    match db_executor.disable_history(&table_name, params.drop) {
+        Ok(()) => {
+            HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+                "message": format!("Row history disabled for table '{}'", table_name)
+            })))
+        },
+        Err(e) => history_error(&table_name, e),
+    }
+}
+
+// 查询表在某一时刻的状态或某一行的历史版本
+async fn table_history(
+    db_executor: web::Data<Arc<DbExecutor>>,
+    path: web::Path<String>,
+    params: web::Query<HistoryParams>,
+) -> impl Responder {
+    let table_name = path.into_inner();
+    
+    let result = match (&params.at, params.row_id) {
+        (_, Some(row_id)) => db_executor.row_history(&table_name, row_id),
+        (Some(at), None) => match chrono::DateTime::parse_from_rfc3339(at) {
+            Ok(at) => db_executor.table_as_of(&table_name, &at.with_timezone(&chrono::Utc)),
+            Err(e) => {
+                return HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+                    ApiError::new("INVALID_AS_OF", &format!("Invalid time '{}': {}", at, e))
+                ));
+            }
+        },
+        (None, None) => db_executor.table_as_of(&table_name, &chrono::Utc::now()),
+    };
+    
+    match result {
+        Ok(rows) => HttpResponse::Ok().json(ApiResponse::success(rows)),
+        Err(e) => history_error(&table_name, e),
+    }
+}
+
+fn history_error(table_name: &str, e: LumosError) -> HttpResponse {
+    match e {
+        LumosError::NotFound(msg) => {
+            HttpResponse::NotFound().json(ApiResponse::<()>::error(
+                ApiError::new("HISTORY_NOT_FOUND", &msg)
+            ))
+        },
+        e => {
+            log::error!("Row history error for table {}: {}", table_name, e);
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+                ApiError::new("HISTORY_ERROR", &format!("Row history operation failed: {}", e))
+            ))
+        }
+    }
+}
+
+/// 导入SQLite数据库文件中的表、索引、视图和触发器（需要管理员密钥）
+///
+/// 暂不支持pg_dump输出：目前没有Postgres转储解析和方言转换，只接受SQLite文件
+async fn import_sqlite(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    import_dir: Option<web::Data<ImportDir>>,
+    import_req: web::Json<ImportRequest>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Importing database files") {
+        return response;
+    }
+    
+    if let Some(dir) = &import_dir {
+        if !is_within(&dir.0, Path::new(&import_req.path)) {
+            return HttpResponse::Forbidden().json(ApiResponse::<()>::error(
+                ApiError::new("IMPORT_PATH_FORBIDDEN", &format!("Import path must be inside {}", dir.0.display()))
+            ));
+        }
+    }
+    
+    match db_executor.import_sqlite(&import_req.path, import_req.replace_existing) {
+        Ok(report) => {
+            HttpResponse::Ok().json(ApiResponse::success(report))
+        },
+        Err(e) => {
+            log::error!("Error importing SQLite file {}: {}", import_req.path, e);
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+                ApiError::new("IMPORT_ERROR", &format!("Failed to import SQLite file: {}", e))
+            ))
+        }
+    }
+}
+
+/// 解析符号链接和`..`后，判断`path`是否位于`dir`内
+fn is_within(dir: &Path, path: &Path) -> bool {
+    match (dir.canonicalize(), path.canonicalize()) {
+        (Ok(dir), Ok(path)) => path.starts_with(dir),
+        _ => false,
+    }
+}
@@ -0,0 +1,98 @@
+use std::sync::{Arc, Mutex};
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
+use lumos_core::LumosError;
+use lumos_core::duckdb::DuckDbEngine;
+use lumos_core::duckdb::extensions::{ExtensionInfo, ExtensionPolicy};
+
+use crate::middleware::auth::require_admin;
+use crate::models::response::{ApiResponse, ApiError};
+
+// 配置DuckDB扩展管理路由，仅管理员密钥可用
+pub fn configure(cfg: &mut web::ServiceConfig) {
+    cfg.service(
+        web::scope("/admin/extensions")
+            .route("", web::get().to(list_extensions))
+            .route("/{name}", web::post().to(enable_extension))
+    );
+}
+
+// 列出DuckDB扩展及其是否在允许列表中
+async fn list_extensions(
+    req: HttpRequest,
+    duckdb: web::Data<Arc<Mutex<DuckDbEngine>>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Managing DuckDB extensions") {
+        return response;
+    }
+
+    let duckdb = duckdb.get_ref().clone();
+    match web::block(move || with_engine(&duckdb, |engine, policy| engine.list_extensions(policy))).await {
+        Ok(Ok(extensions)) => {
+            HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+                "extensions": extensions
+            })))
+        },
+        Ok(Err(e)) => extension_error(e),
+        Err(e) => extension_error(LumosError::Internal(e.to_string())),
+    }
+}
+
+// 安装扩展（如需要）、校验并加载到共享的DuckDB引擎
+async fn enable_extension(
+    req: HttpRequest,
+    duckdb: web::Data<Arc<Mutex<DuckDbEngine>>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Managing DuckDB extensions") {
+        return response;
+    }
+
+    let name = path.into_inner();
+    let duckdb = duckdb.get_ref().clone();
+    match web::block(move || with_engine(&duckdb, |engine, policy| engine.enable_extension(policy, &name))).await {
+        Ok(Ok(extension)) => HttpResponse::Ok().json(ApiResponse::success(extension)),
+        Ok(Err(e)) => extension_error(e),
+        Err(e) => extension_error(LumosError::Internal(e.to_string())),
+    }
+}
+
+/// 安装并加载启动时配置的扩展，返回成功启用的扩展
+pub fn enable_configured_extensions(duckdb: &Mutex<DuckDbEngine>, names: &[String]) -> Vec<ExtensionInfo> {
+    let mut enabled = Vec::new();
+    for name in names {
+        match with_engine(duckdb, |engine, policy| engine.enable_extension(policy, name)) {
+            Ok(extension) => enabled.push(extension),
+            Err(e) => log::error!("Failed to enable DuckDB extension '{}': {}", name, e),
+        }
+    }
+    enabled
+}
+
+// 使用共享引擎及其允许列表；扩展文件安装在主机的扩展目录中，加载只对该引擎生效
+fn with_engine<T>(
+    duckdb: &Mutex<DuckDbEngine>,
+    f: impl FnOnce(&DuckDbEngine, &ExtensionPolicy) -> Result<T, LumosError>,
+) -> Result<T, LumosError> {
+    let engine = duckdb.lock().unwrap();
+    let policy = engine.extension_policy()
+        .cloned()
+        .ok_or_else(|| LumosError::Internal("DuckDB engine has no extension policy".to_string()))?;
+    f(&engine, &policy)
+}
+
+fn extension_error(e: LumosError) -> HttpResponse {
+    match e {
+        LumosError::NotFound(msg) => {
+            HttpResponse::NotFound().json(ApiResponse::<()>::error(ApiError::new("EXTENSION_NOT_FOUND", &msg)))
+        },
+        LumosError::InvalidArgument(msg) => {
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(ApiError::new("EXTENSION_REJECTED", &msg)))
+        },
+        e => {
+            log::error!("DuckDB extension error: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("EXTENSION_ERROR", &format!("Extension operation failed: {}", e))
+            ))
+        }
+    }
+}
@@ -0,0 +1,208 @@
+use std::sync::Arc;
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
+use futures_util::stream;
+use serde::Deserialize;
+use tokio::io::AsyncReadExt;
+
+use crate::db::DbExecutor;
+use crate::db::query_jobs::{JobError, QueryJob, QueryJobManager};
+use crate::middleware::auth::{key_label, key_role, KeyRole};
+use crate::middleware::binding::BindingPolicy;
+use crate::middleware::read_only::is_write_sql;
+use crate::models::response::{ApiResponse, ApiError};
+
+/// 默认每页的结果行数
+const DEFAULT_PAGE_SIZE: usize = 1000;
+
+/// 每页最多的结果行数
+const MAX_PAGE_SIZE: usize = 10_000;
+
+/// 流式下载时每次读取的字节数
+const STREAM_CHUNK_BYTES: usize = 64 * 1024;
+
+// 提交查询任务请求
+#[derive(Debug, Deserialize)]
+pub struct SubmitJobRequest {
+    pub sql: String,
+    #[serde(default)]
+    pub params: Vec<String>,
+    /// 任务结束时POST任务状态的地址
+    pub callback_url: String,
+}
+
+// 分页读取结果参数
+#[derive(Debug, Deserialize)]
+pub struct ResultPageParams {
+    #[serde(default)]
+    pub offset: usize,
+    #[serde(default)]
+    pub limit: Option<usize>,
+}
+
+// 配置查询任务路由，需要在`/db`作用域之前注册
+pub fn configure(cfg: &mut web::ServiceConfig) {
+    cfg.service(
+        web::scope("/db/jobs")
+            .route("", web::post().to(submit_job))
+            .route("/{id}", web::get().to(get_job))
+            .route("/{id}/result", web::get().to(result_page))
+            .route("/{id}/result/stream", web::get().to(result_stream))
+    );
+}
+
+// 提交长时间运行的查询，立即返回任务ID
+async fn submit_job(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    jobs: Option<web::Data<Arc<QueryJobManager>>>,
+    binding: Option<web::Data<BindingPolicy>>,
+    job_req: web::Json<SubmitJobRequest>,
+) -> impl Responder {
+    let jobs = match jobs {
+        Some(jobs) => jobs,
+        None => return jobs_disabled(),
+    };
+    // 任务只用于报表等读查询
+    if is_write_sql(&job_req.sql) {
+        return HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+            ApiError::new("WRITE_NOT_ALLOWED", "Query jobs only run read queries")
+        ));
+    }
+    if let Some(policy) = &binding {
+        if let Err(response) = policy.check(&req, &job_req.sql, &db_executor) {
+            return response;
+        }
+    }
+
+    // 检查回调地址会同步解析域名，不能阻塞工作线程
+    let job_req = job_req.into_inner();
+    let jobs = jobs.get_ref().clone();
+    let executor = db_executor.get_ref().clone();
+    let owner = key_label(&req);
+    match web::block(move || jobs.submit(executor, job_req.sql, job_req.params, job_req.callback_url, owner)).await {
+        Ok(Ok(job)) => HttpResponse::Accepted().json(ApiResponse::success(job)),
+        Ok(Err(e)) => job_error(e),
+        Err(e) => job_error(JobError::Io(std::io::Error::new(std::io::ErrorKind::Other, e.to_string()))),
+    }
+}
+
+// 查询任务状态
+async fn get_job(
+    req: HttpRequest,
+    jobs: Option<web::Data<Arc<QueryJobManager>>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let jobs = match jobs {
+        Some(jobs) => jobs,
+        None => return jobs_disabled(),
+    };
+    match visible_job(&req, &jobs, &path.into_inner()) {
+        Ok(job) => HttpResponse::Ok().json(ApiResponse::success(job)),
+        Err(response) => response,
+    }
+}
+
+// 分页读取任务结果
+async fn result_page(
+    req: HttpRequest,
+    jobs: Option<web::Data<Arc<QueryJobManager>>>,
+    path: web::Path<String>,
+    params: web::Query<ResultPageParams>,
+) -> impl Responder {
+    let jobs = match jobs {
+        Some(jobs) => jobs.get_ref().clone(),
+        None => return jobs_disabled(),
+    };
+    let id = path.into_inner();
+    let offset = params.offset;
+    let limit = params.limit.unwrap_or(DEFAULT_PAGE_SIZE).clamp(1, MAX_PAGE_SIZE);
+
+    let total = match visible_job(&req, &jobs, &id) {
+        Ok(job) => job.rows.unwrap_or(0) as usize,
+        Err(response) => return response,
+    };
+    match web::block(move || jobs.read_page(&id, offset, limit)).await {
+        Ok(Ok(rows)) => {
+            let next_offset = Some(offset + rows.len()).filter(|next| *next < total);
+            HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+                "rows": rows,
+                "offset": offset,
+                "total": total,
+                "next_offset": next_offset,
+            })))
+        },
+        Ok(Err(e)) => job_error(e),
+        Err(e) => job_error(JobError::Io(std::io::Error::new(std::io::ErrorKind::Other, e.to_string()))),
+    }
+}
+
+// 以JSON Lines流式下载全部结果
+async fn result_stream(
+    req: HttpRequest,
+    jobs: Option<web::Data<Arc<QueryJobManager>>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let jobs = match jobs {
+        Some(jobs) => jobs,
+        None => return jobs_disabled(),
+    };
+    let id = path.into_inner();
+    if let Err(response) = visible_job(&req, &jobs, &id) {
+        return response;
+    }
+    let file = match jobs.result_path(&id) {
+        Ok(path) => match tokio::fs::File::open(path).await {
+            Ok(file) => file,
+            Err(e) => return job_error(e.into()),
+        },
+        Err(e) => return job_error(e),
+    };
+
+    let body = stream::unfold(file, |mut file| async move {
+        let mut buf = vec![0; STREAM_CHUNK_BYTES];
+        match file.read(&mut buf).await {
+            Ok(0) => None,
+            Ok(n) => {
+                buf.truncate(n);
+                Some((Ok::<_, actix_web::Error>(web::Bytes::from(buf)), file))
+            },
+            Err(e) => Some((Err(e.into()), file)),
+        }
+    });
+    HttpResponse::Ok()
+        .content_type("application/x-ndjson")
+        .streaming(body)
+}
+
+// 获取请求的密钥可见的任务，其他密钥提交的任务返回404
+fn visible_job(req: &HttpRequest, jobs: &QueryJobManager, id: &str) -> Result<QueryJob, HttpResponse> {
+    jobs.get_for(id, &key_label(req), key_role(req) == KeyRole::Admin)
+        .ok_or_else(|| job_error(JobError::NotFound(id.to_string())))
+}
+
+fn jobs_disabled() -> HttpResponse {
+    HttpResponse::NotFound().json(ApiResponse::<()>::error(
+        ApiError::new("QUERY_JOBS_DISABLED", "Query jobs are not enabled")
+    ))
+}
+
+fn job_error(e: JobError) -> HttpResponse {
+    match e {
+        JobError::InvalidCallback(_) | JobError::PrivateCallback(_) => {
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(ApiError::new("INVALID_CALLBACK", &e.to_string())))
+        },
+        JobError::TooManyJobs(_) => {
+            HttpResponse::ServiceUnavailable().json(ApiResponse::<()>::error(ApiError::new("TOO_MANY_JOBS", &e.to_string())))
+        },
+        JobError::NotFound(_) => {
+            HttpResponse::NotFound().json(ApiResponse::<()>::error(ApiError::new("JOB_NOT_FOUND", &e.to_string())))
+        },
+        JobError::NoResult(_, _) => {
+            HttpResponse::Conflict().json(ApiResponse::<()>::error(ApiError::new("JOB_RESULT_UNAVAILABLE", &e.to_string())))
+        },
+        JobError::Io(_) => {
+            log::error!("Query job error: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(ApiError::new("JOB_ERROR", &e.to_string())))
+        },
+    }
+}
@@ -0,0 +1,24 @@
+use std::sync::Arc;
+use actix_web::{web, HttpResponse, Responder};
+
+use crate::cache::CacheJanitor;
+use crate::db::vector_executor::VectorExecutor;
+
+/// Prometheus文本格式的内容类型
+const PROMETHEUS_CONTENT_TYPE: &str = "text/plain; version=0.0.4; charset=utf-8";
+
+/// 以Prometheus文本格式输出指标
+pub async fn prometheus_metrics(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+    cache_janitor: Option<web::Data<Arc<CacheJanitor>>>,
+) -> impl Responder {
+    let executor = vector_executor.get_ref();
+    let mut body = executor.access_stats().render_prometheus("lumos_vector");
+    body.push_str(&executor.replication().render_prometheus("lumos_vector"));
+    if let Some(janitor) = &cache_janitor {
+        body.push_str(&janitor.render_prometheus("lumos_cache"));
+    }
+    HttpResponse::Ok()
+        .content_type(PROMETHEUS_CONTENT_TYPE)
+        .body(body)
+}
@@ -1,7 +1,15 @@
 pub mod db_handler;
 pub mod vector_handlers;
 pub mod cache_handler;
+pub mod query_handler;
+pub mod extension_handler;
+pub mod metrics_handler;
+pub mod admin_handler;
+pub mod job_handler;
+pub mod pinned_handler;
+pub mod approval_handler;
 
 pub use db_handler::*;
 pub use vector_handlers::*;
-pub use cache_handler::*; 
\ No newline at end of file
+pub use cache_handler::*;
+pub use query_handler::*; 
\ No newline at end of file
@@ -0,0 +1,109 @@
+use std::sync::Arc;
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
+use serde::Deserialize;
+use lumos_core::LumosError;
+
+use crate::db::pinned::PinnedTables;
+use crate::middleware::auth::require_admin;
+use crate::models::response::{ApiResponse, ApiError};
+
+// 读取固定表的分析查询请求
+#[derive(Debug, Deserialize)]
+pub struct PinnedQueryRequest {
+    pub sql: String,
+}
+
+// 配置固定表路由，作用域嵌套在`/db`之下，需要在数据库路由之前注册
+pub fn configure(cfg: &mut web::ServiceConfig) {
+    cfg.service(
+        web::scope("/db/pinned")
+            .route("", web::get().to(list_pinned))
+            .route("/query", web::post().to(query_pinned))
+            .route("/tables/{table}", web::post().to(pin_table))
+            .route("/tables/{table}", web::delete().to(unpin_table))
+    );
+}
+
+// 列出固定表及其副本状态
+async fn list_pinned(pinned: web::Data<Arc<PinnedTables>>) -> impl Responder {
+    HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+        "tables": pinned.list()
+    })))
+}
+
+// 固定表并加载副本，已固定的表重新加载（需要管理员密钥）
+async fn pin_table(
+    req: HttpRequest,
+    pinned: web::Data<Arc<PinnedTables>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Pinning tables") {
+        return response;
+    }
+
+    let table = path.into_inner();
+    let pinned = pinned.get_ref().clone();
+    match web::block(move || pinned.pin(&table)).await {
+        Ok(Ok(info)) => HttpResponse::Ok().json(ApiResponse::success(info)),
+        Ok(Err(e)) => pinned_error(e),
+        Err(e) => pinned_error(LumosError::Internal(e.to_string())),
+    }
+}
+
+// 取消固定并删除副本（需要管理员密钥）
+async fn unpin_table(
+    req: HttpRequest,
+    pinned: web::Data<Arc<PinnedTables>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Pinning tables") {
+        return response;
+    }
+
+    let table = path.into_inner();
+    let pinned = pinned.get_ref().clone();
+    match web::block(move || pinned.unpin(&table).map(|_| table)).await {
+        Ok(Ok(table)) => HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+            "table": table,
+            "unpinned": true
+        }))),
+        Ok(Err(e)) => pinned_error(e),
+        Err(e) => pinned_error(LumosError::Internal(e.to_string())),
+    }
+}
+
+// 在DuckDB上执行读取固定表的SELECT
+async fn query_pinned(
+    pinned: web::Data<Arc<PinnedTables>>,
+    query_req: web::Json<PinnedQueryRequest>,
+) -> impl Responder {
+    let sql = query_req.into_inner().sql;
+    let pinned = pinned.get_ref().clone();
+    let started = std::time::Instant::now();
+    match web::block(move || pinned.query(&sql)).await {
+        Ok(Ok(result)) => HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+            "columns": result.columns,
+            "rows": result.rows,
+            "execution_time_ms": started.elapsed().as_millis() as u64
+        }))),
+        Ok(Err(e)) => pinned_error(e),
+        Err(e) => pinned_error(LumosError::Internal(e.to_string())),
+    }
+}
+
+fn pinned_error(e: LumosError) -> HttpResponse {
+    match e {
+        LumosError::NotFound(msg) => {
+            HttpResponse::NotFound().json(ApiResponse::<()>::error(ApiError::new("PINNED_TABLE_NOT_FOUND", &msg)))
+        },
+        LumosError::InvalidArgument(msg) => {
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(ApiError::new("PINNED_TABLE_REJECTED", &msg)))
+        },
+        e => {
+            log::error!("Pinned table error: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("PINNED_TABLE_ERROR", &format!("Pinned table operation failed: {}", e))
+            ))
+        }
+    }
+}
@@ -0,0 +1,115 @@
+use std::sync::Arc;
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
+use log::error;
+use serde::Deserialize;
+use crate::db::DbExecutor;
+use crate::middleware::auth::require_admin;
+use crate::models::response::{ApiResponse, ApiError};
+
+// 执行计划请求
+#[derive(Debug, Deserialize)]
+pub struct ExplainRequest {
+    pub sql: String,
+    // 绑定到查询占位符的参数
+    #[serde(default)]
+    pub params: Vec<String>,
+    // 是否执行只读查询以获取实际行数和耗时，需要管理员密钥
+    #[serde(default)]
+    pub analyze: bool,
+}
+
+// 审计记录查询参数
+#[derive(Debug, Deserialize)]
+pub struct AuditQuery {
+    #[serde(default = "default_audit_limit")]
+    pub limit: usize,
+}
+
+fn default_audit_limit() -> usize {
+    100
+}
+
+// 配置查询处理程序路由
+pub fn configure(cfg: &mut web::ServiceConfig) {
+    cfg.service(
+        web::scope("/query")
+            .route("/explain", web::post().to(explain_query))
+            .route("/audit", web::get().to(query_audit))
+            .route("/lineage/{table}", web::get().to(column_lineage))
+    );
+}
+
+/// 获取查询的结构化执行计划
+///
+/// `analyze`会实际执行查询，不经过查询端点的超时和准入控制，因此仅管理员密钥可用。
+async fn explain_query(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    explain_req: web::Json<ExplainRequest>,
+) -> impl Responder {
+    if explain_req.analyze {
+        if let Err(response) = require_admin(&req, "Running EXPLAIN ANALYZE") {
+            return response;
+        }
+    }
+
+    match db_executor.explain(&explain_req.sql, &explain_req.params, explain_req.analyze) {
+        Ok(plan) => HttpResponse::Ok().json(ApiResponse::success(plan)),
+        Err(e) => {
+            error!("Explain query error: {}", e);
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+                ApiError::new("EXPLAIN_ERROR", &format!("Failed to explain query: {}", e))
+            ))
+        }
+    }
+}
+
+/// 最近的查询审计抽样记录，包含完整参数，仅管理员密钥可用
+async fn query_audit(
+    req: HttpRequest,
+    db_executor: web::Data<Arc<DbExecutor>>,
+    query: web::Query<AuditQuery>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Reading the query audit") {
+        return response;
+    }
+
+    let auditor = match db_executor.auditor() {
+        Some(auditor) => auditor,
+        None => return HttpResponse::NotFound().json(ApiResponse::<()>::error(
+            ApiError::new("AUDIT_DISABLED", "Query audit sampling is not enabled")
+        )),
+    };
+    match auditor.recent(query.limit.min(1000)) {
+        Ok(entries) => HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+            "sample_rate": auditor.sample_rate(),
+            "entries": entries,
+        }))),
+        Err(e) => {
+            error!("Query audit error: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("AUDIT_ERROR", &e)
+            ))
+        }
+    }
+}
+
+/// 表的列级血缘，来自执行过的INSERT ... SELECT和CREATE TABLE AS语句
+async fn column_lineage(
+    db_executor: web::Data<Arc<DbExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let table = path.into_inner();
+    match db_executor.column_lineage(&table) {
+        Ok(edges) => HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+            "table": table,
+            "lineage": edges,
+        }))),
+        Err(e) => {
+            error!("Column lineage error: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("LINEAGE_ERROR", &e.to_string())
+            ))
+        }
+    }
+}
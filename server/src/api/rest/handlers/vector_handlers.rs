@@ -1,19 +1,30 @@
-use std::sync::Arc;
-use actix_web::{web, HttpResponse, Responder};
+use std::sync::{Arc, Mutex};
+use actix_web::{web, HttpRequest, HttpResponse, Responder};
+use futures_util::stream;
 use log::error;
+use tokio::io::AsyncReadExt;
+use lumos_core::duckdb::DuckDbEngine;
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 use once_cell::sync::Lazy;
 use crate::db::vector_executor::{VectorExecutor, VectorExecutorExtension};
+use crate::db::replication::ReplicationRole;
+use crate::middleware::auth::require_admin;
+use crate::db::approvals::{ApprovalQueue, Operation};
+use super::approval_handler::require_approval;
 use crate::models::response::{ApiResponse, ApiError};
 use crate::utils::perf_monitor::PerfMonitor;
 
 use crate::models::vector::{
-    CreateCollectionRequest, AddEmbeddingsRequest, SearchRequest,
+    CreateCollectionRequest, AddEmbeddingsRequest, AddEmbeddingsChunkRequest, SearchRequest,
+    ReplicationApplyRequest,
     CreateCollectionResponse, AddEmbeddingsResponse, SearchResponse,
     ListCollectionsResponse, Collection, Embedding, SearchResult
 };
 
+/// 流式导出时每次读取的字节数
+const EXPORT_CHUNK_BYTES: usize = 64 * 1024;
+
 /// 性能监控器
 static SEARCH_MONITOR: Lazy<PerfMonitor> = Lazy::new(|| {
     PerfMonitor::new("vector_search")
@@ -32,7 +43,19 @@ pub fn configure(cfg: &mut web::ServiceConfig) {
             .route("/collections/{name}", web::get().to(get_collection))
             .route("/collections/{name}", web::delete().to(delete_collection))
             .route("/collections/{name}/embeddings", web::post().to(add_embeddings))
+            .route("/collections/{name}/embeddings/chunks", web::post().to(add_embeddings_chunk))
+            .route("/uploads/{upload_id}", web::get().to(upload_status))
+            .route("/uploads/{upload_id}", web::delete().to(finish_upload))
             .route("/collections/{name}/search", web::post().to(search_similar))
+            .route("/collections/{name}/export", web::get().to(export_collection))
+            .route("/collections/{name}/warm", web::post().to(warm_collection))
+            .route("/collections/{name}/stats", web::get().to(collection_stats))
+            .route("/stats", web::get().to(access_stats))
+            .route("/replication/status", web::get().to(replication_status))
+            .route("/replication/apply", web::post().to(apply_replication))
+            .route("/replication/promote", web::post().to(promote_replica))
+            .route("/replication/demote", web::post().to(demote_primary))
+            .route("/collections/{name}/index/status", web::get().to(index_build_status))
             .route("/collections/{name}/index/{index_type}", web::post().to(create_index))
             .route("/collections/{name}/index", web::delete().to(delete_index))
     );
@@ -164,11 +187,17 @@ pub async fn get_collection(
 }
 
 pub async fn delete_collection(
+    req: HttpRequest,
     vector_executor: web::Data<Arc<VectorExecutor>>,
+    approvals: Option<web::Data<Arc<ApprovalQueue>>>,
     path: web::Path<String>,
 ) -> impl Responder {
     let name = path.into_inner();
     
+    if let Err(response) = require_approval(&req, &approvals, Operation::DeleteCollection { name: name.clone() }) {
+        return response;
+    }
+    
     match vector_executor.delete_collection(name.clone()).await {
         Ok(_) => {
             HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
@@ -219,6 +248,67 @@ pub async fn add_embeddings(
     }
 }
 
+/// 分块添加嵌入向量，每个分块单独确认
+pub async fn add_embeddings_chunk(
+    path: web::Path<String>,
+    chunk_req: web::Json<AddEmbeddingsChunkRequest>,
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+) -> impl Responder {
+    let collection_name = path.into_inner();
+    let chunk = chunk_req.into_inner();
+    
+    match vector_executor.get_ref().add_embeddings_chunk(
+        &collection_name,
+        &chunk.upload_id,
+        chunk.chunk_index,
+        chunk.ids,
+        chunk.embeddings,
+        chunk.metadata,
+    ) {
+        Ok(ack) => HttpResponse::Ok().json(ApiResponse::success(ack)),
+        Err(e) => {
+            error!("Error adding embeddings chunk: {}", e);
+            HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+                ApiError::new("CHUNK_ERROR", &format!("Failed to add chunk: {}", e))
+            ))
+        }
+    }
+}
+
+/// 查询分块上传状态，用于中断后续传
+pub async fn upload_status(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let upload_id = path.into_inner();
+    
+    match vector_executor.get_ref().upload_status(&upload_id) {
+        Some(status) => HttpResponse::Ok().json(ApiResponse::success(status)),
+        None => HttpResponse::NotFound().json(ApiResponse::<()>::error(
+            ApiError::new("UPLOAD_NOT_FOUND", &format!("Upload '{}' not found or expired", upload_id))
+        )),
+    }
+}
+
+/// 结束分块上传
+pub async fn finish_upload(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let upload_id = path.into_inner();
+    
+    if vector_executor.get_ref().finish_upload(&upload_id) {
+        HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+            "upload_id": upload_id,
+            "finished": true,
+        })))
+    } else {
+        HttpResponse::NotFound().json(ApiResponse::<()>::error(
+            ApiError::new("UPLOAD_NOT_FOUND", &format!("Upload '{}' not found or expired", upload_id))
+        ))
+    }
+}
+
 /// 在集合中搜索相似向量
 pub async fn search_similar(
     path: web::Path<String>,
@@ -227,12 +317,12 @@ pub async fn search_similar(
 ) -> impl Responder {
     let collection_name = path.into_inner();
     
-    // We only need 3 arguments for search_similar according to the implementation
-    match vector_executor.search_similar(
-        collection_name.clone(),
+    match vector_executor.get_ref().search(
+        &collection_name,
         search_req.vector.clone(),
-        search_req.top_k
-    ).await {
+        search_req.top_k,
+        search_req.explain
+    ) {
         Ok(results) => {
             let search_results = results.into_iter().map(|result| {
                 SearchResult {
@@ -251,6 +341,7 @@ pub async fn search_similar(
                             HashMap::new()
                         }
                     }),
+                    explanation: result.explanation,
                 }
             }).collect::<Vec<_>>();
             
@@ -316,4 +407,205 @@ pub async fn delete_index(
             ))
         }
     }
-} 
\ No newline at end of file
+}
+
+/// 获取后台索引构建进度
+pub async fn index_build_status(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let name = path.into_inner();
+
+    match vector_executor.get_ref().index_build_status(&name) {
+        Some(status) => HttpResponse::Ok().json(ApiResponse::success(status)),
+        None => HttpResponse::NotFound().json(ApiResponse::<()>::error(
+            ApiError::new("INDEX_BUILD_NOT_FOUND", &format!("No index build for collection '{}'", name))
+        )),
+    }
+}
+
+/// 导出格式查询参数
+#[derive(Debug, Deserialize)]
+pub struct ExportParams {
+    /// `jsonl`（默认）或`parquet`
+    pub format: Option<String>,
+}
+
+/// 导出向量集合为JSONL或Parquet
+///
+/// 先写入临时文件再流式返回，响应不会把整个集合缓存在内存中。
+pub async fn export_collection(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+    duckdb: web::Data<Arc<Mutex<DuckDbEngine>>>,
+    path: web::Path<String>,
+    params: web::Query<ExportParams>,
+) -> impl Responder {
+    let name = path.into_inner();
+    let (extension, content_type) = match params.format.as_deref().unwrap_or("jsonl") {
+        "jsonl" => ("jsonl", "application/x-ndjson"),
+        "parquet" => ("parquet", "application/vnd.apache.parquet"),
+        other => return HttpResponse::BadRequest().json(ApiResponse::<()>::error(
+            ApiError::new("INVALID_EXPORT_FORMAT", &format!("Unsupported export format '{}', expected jsonl or parquet", other))
+        )),
+    };
+
+    let file_path = std::env::temp_dir().join(format!("lumos_export_{}.{}", uuid::Uuid::new_v4(), extension));
+    let executor = vector_executor.get_ref().clone();
+    let export_name = name.clone();
+    let export_path = file_path.clone();
+    let result = web::block(move || match extension {
+        "parquet" => executor.export_parquet(&export_name, &duckdb.lock().unwrap(), &export_path),
+        _ => {
+            let file = std::fs::File::create(&export_path)
+                .map_err(|e| format!("Failed to create {}: {}", export_path.display(), e))?;
+            executor.export_jsonl(&export_name, std::io::BufWriter::new(file), |done, total| {
+                log::debug!("Exporting collection '{}': {}/{}", export_name, done, total);
+            })
+        },
+    }).await.unwrap_or_else(|e| Err(e.to_string()));
+
+    // 打开后即删除临时文件，读取完成后由系统回收
+    let file = match result {
+        Ok(_) => tokio::fs::File::open(&file_path).await.map_err(|e| e.to_string()),
+        Err(e) => Err(e),
+    };
+    if let Err(e) = std::fs::remove_file(&file_path) {
+        if e.kind() != std::io::ErrorKind::NotFound {
+            log::warn!("Failed to remove export file {}: {}", file_path.display(), e);
+        }
+    }
+    let file = match file {
+        Ok(file) => file,
+        Err(e) => {
+            error!("Error exporting collection: {}", e);
+            return HttpResponse::NotFound().json(ApiResponse::<()>::error(
+                ApiError::new("EXPORT_ERROR", &format!("Failed to export collection: {}", e))
+            ));
+        }
+    };
+
+    let body = stream::unfold(file, |mut file| async move {
+        let mut buf = vec![0; EXPORT_CHUNK_BYTES];
+        match file.read(&mut buf).await {
+            Ok(0) => None,
+            Ok(n) => {
+                buf.truncate(n);
+                Some((Ok::<_, actix_web::Error>(web::Bytes::from(buf)), file))
+            },
+            Err(e) => Some((Err(e.into()), file)),
+        }
+    });
+    HttpResponse::Ok()
+        .content_type(content_type)
+        .insert_header((
+            "Content-Disposition",
+            format!("attachment; filename=\"{}.{}\"", name, extension),
+        ))
+        .streaming(body)
+}
+
+/// 预先将集合加载到内存，避免首次搜索时从磁盘加载
+pub async fn warm_collection(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+    path: web::Path<String>,
+) -> impl Responder {
+    let name = path.into_inner();
+
+    match vector_executor.get_ref().warm_collection(&name) {
+        Ok(loaded) => HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+            "collection": name,
+            "loaded": loaded,
+        }))),
+        Err(e) => {
+            error!("Error warming collection: {}", e);
+            HttpResponse::NotFound().json(ApiResponse::<()>::error(
+                ApiError::new("WARM_ERROR", &format!("Failed to load collection: {}", e))
+            ))
+        }
+    }
+}
+
+/// 所有集合的访问统计
+pub async fn access_stats(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+) -> impl Responder {
+    HttpResponse::Ok().json(ApiResponse::success(vector_executor.get_ref().access_stats().snapshot()))
+}
+
+/// 单个集合的访问统计
+pub async fn collection_stats(
+    path: web::Path<String>,
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+) -> impl Responder {
+    let name = path.into_inner();
+    match vector_executor.get_ref().access_stats().collection(&name) {
+        Some(stats) => HttpResponse::Ok().json(ApiResponse::success(stats)),
+        None => HttpResponse::NotFound().json(ApiResponse::<()>::error(
+            ApiError::new("STATS_NOT_FOUND", &format!("No access recorded for collection '{}'", name))
+        )),
+    }
+}
+
+/// 复制状态，包括待推送的条目数和复制延迟
+pub async fn replication_status(
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+) -> impl Responder {
+    HttpResponse::Ok().json(ApiResponse::success(vector_executor.get_ref().replication().status()))
+}
+
+/// 应用主实例推送的变更
+pub async fn apply_replication(
+    req: HttpRequest,
+    apply_req: web::Json<ReplicationApplyRequest>,
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Replication") {
+        return response;
+    }
+    let executor = vector_executor.get_ref();
+    if !executor.replication().is_replica() {
+        return HttpResponse::Conflict().json(ApiResponse::<()>::error(
+            ApiError::new("NOT_A_REPLICA", "This instance is the primary and does not accept replicated writes")
+        ));
+    }
+    
+    match executor.apply_replicated(apply_req.into_inner().entries) {
+        Ok(result) => HttpResponse::Ok().json(ApiResponse::success(result)),
+        Err(e) => {
+            error!("Error applying replicated embeddings: {}", e);
+            HttpResponse::InternalServerError().json(ApiResponse::<()>::error(
+                ApiError::new("REPLICATION_ERROR", &format!("Failed to apply replicated embeddings: {}", e))
+            ))
+        }
+    }
+}
+
+/// 手动故障转移：将副本提升为主实例
+pub async fn promote_replica(
+    req: HttpRequest,
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Replication") {
+        return response;
+    }
+    let previous = vector_executor.get_ref().replication().set_role(ReplicationRole::Primary);
+    HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+        "previous_role": previous,
+        "role": ReplicationRole::Primary,
+    })))
+}
+
+/// 将原主实例降级为副本，避免故障转移后两边同时接受写入
+pub async fn demote_primary(
+    req: HttpRequest,
+    vector_executor: web::Data<Arc<VectorExecutor>>,
+) -> impl Responder {
+    if let Err(response) = require_admin(&req, "Replication") {
+        return response;
+    }
+    let previous = vector_executor.get_ref().replication().set_role(ReplicationRole::Replica);
+    HttpResponse::Ok().json(ApiResponse::success(serde_json::json!({
+        "previous_role": previous,
+        "role": ReplicationRole::Replica,
+    })))
+}
@@ -9,8 +9,19 @@ pub fn configure_routes(cfg: &mut web::ServiceConfig) {
     // 配置通用API前缀
     cfg.service(
         web::scope("/api")
+            .route("/health", web::get().to(crate::api::health::health_check))
+            .route("/ready", web::get().to(crate::api::health::readiness))
+            .route("/metrics", web::get().to(handlers::metrics_handler::prometheus_metrics))
+            // 查询任务和固定表的作用域嵌套在`/db`之下，需要先注册
+            .configure(handlers::job_handler::configure)
+            .configure(handlers::pinned_handler::configure)
             .configure(handlers::db_handler::configure)
+            .configure(handlers::cache_handler::configure)
             .configure(handlers::vector_handlers::configure)
+            .configure(handlers::query_handler::configure)
+            .configure(handlers::extension_handler::configure)
+            .configure(handlers::admin_handler::configure)
+            .configure(handlers::approval_handler::configure)
     );
 }
 
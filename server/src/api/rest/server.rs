@@ -1,21 +1,75 @@
-use std::sync::Arc;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
 use actix_web::{web, App, HttpServer, middleware};
 use actix_cors::Cors;
 use tracing_actix_web::TracingLogger;
 use log::{info, error};
 
-use crate::db::{DbExecutor, vector_executor::VectorExecutor};
+use lumos_core::duckdb::DuckDbEngine;
+use lumos_core::duckdb::extensions::ExtensionPolicy;
+use lumos_core::sqlite::snapshot::SnapshotStore;
+
+use crate::cache::{CacheJanitor, CleanupReport};
+use crate::db::{CachedDbExecutor, DbExecutor, vector_executor::VectorExecutor};
+use crate::db::replication::{self, ReplicationLog, ReplicationRole};
+use crate::db::query_jobs::QueryJobManager;
+use crate::db::pinned::PinnedTables;
+use crate::db::approvals::ApprovalQueue;
 use crate::config::ServerConfig;
+use crate::utils::memory_budget::MemoryBudget;
+use crate::utils::disk_guard::DiskGuard;
+use crate::utils::degradation::{DegradationController, DegradationPolicy};
+use crate::utils::admission::AdmissionController;
+use crate::utils::anomaly::AnomalyDetector;
+use crate::utils::warmup::{self, Warmup, WarmupPlan};
+use crate::utils::query_audit::QueryAuditor;
+use crate::utils::integrity::IntegrityReport;
+use crate::utils::retention::RetentionEnforcer;
+use crate::utils::runtime_config::RuntimeConfig;
+use crate::utils::error_budget::ErrorBudgetTracker;
+use crate::api::rest::handlers::ImportDir;
+use crate::middleware::binding::BindingPolicy;
 use crate::middleware::auth::AuthMiddleware;
+use crate::middleware::read_only::ReadOnlyGuard;
+use crate::middleware::disk_guard::DiskSpaceGuard;
+use crate::middleware::error_budget::ErrorBudgetRecorder;
 
 // 运行服务器
 pub async fn run_server(config: ServerConfig) -> std::io::Result<()> {
+    // 应用密钥和只读密钥需要同时配置管理员密钥，否则无法管理服务器
+    if config.api_key.is_none() && !(config.app_api_keys.is_empty() && config.read_only_api_keys.is_empty()) {
+        error!("Application or read-only API keys are configured without LUMOS_API_KEY");
+        return Err(std::io::Error::new(
+            std::io::ErrorKind::InvalidInput,
+            "application and read-only API keys require an admin key, set LUMOS_API_KEY",
+        ));
+    }
+    
+    // 查询审计抽样
+    let auditor = match config.audit_sample_rate {
+        Some(rate) => match QueryAuditor::open(&config.audit_db_path, rate) {
+            Ok(auditor) => {
+                info!("Auditing {}% of queries with full parameters in {}", rate * 100.0, config.audit_db_path);
+                Some(Arc::new(auditor))
+            },
+            Err(e) => {
+                error!("Failed to open query audit store: {}", e);
+                return Err(std::io::Error::new(std::io::ErrorKind::Other, e));
+            }
+        },
+        None => None,
+    };
+    
     // 创建数据库执行器
     let db_path = config.db_path.clone();
     let db_executor = match DbExecutor::new(&db_path) {
         Ok(executor) => {
             info!("Successfully initialized database at {}", db_path);
-            executor
+            match auditor {
+                Some(auditor) => executor.with_auditor(auditor),
+                None => executor,
+            }
         },
         Err(e) => {
             error!("Failed to initialize database at {}: {}", db_path, e);
@@ -23,12 +77,32 @@ pub async fn run_server(config: ServerConfig) -> std::io::Result<()> {
         }
     };
     
+    // 向量复制的变更队列和实例角色
+    let replication_role = match ReplicationRole::parse(&config.replication_role) {
+        Some(role) => role,
+        None => {
+            error!("Invalid replication role: {}", config.replication_role);
+            return Err(std::io::Error::new(std::io::ErrorKind::InvalidInput, format!("invalid replication role '{}'", config.replication_role)));
+        }
+    };
+    let replication_log = Arc::new(ReplicationLog::new(
+        replication_role,
+        config.replication_target.clone(),
+        config.replication_collections.clone(),
+    ));
+    
     // 创建向量数据库执行器
     let vector_db_path = config.vector_db_path.clone();
     let vector_executor = match VectorExecutor::new(&vector_db_path) {
-        Ok(executor) => {
+        Ok(mut executor) => {
             info!("Successfully initialized vector database at {}", vector_db_path);
-            executor
+            if let Some(cap_mb) = config.vector_collection_cap_mb {
+                executor = executor.with_collection_memory_cap(cap_mb * 1024 * 1024);
+            }
+            if let Some(cap_mb) = config.vector_resident_cap_mb {
+                executor = executor.with_resident_memory_cap(cap_mb * 1024 * 1024);
+            }
+            executor.with_replication(replication_log.clone())
         },
         Err(e) => {
             error!("Failed to initialize vector database at {}: {}", vector_db_path, e);
@@ -36,9 +110,241 @@ pub async fn run_server(config: ServerConfig) -> std::io::Result<()> {
         }
     };
     
-    // 共享执行器
+    // 创建共享内存预算
+    let memory_budget = config.max_memory_percent.and_then(|percent| {
+        let budget = MemoryBudget::from_percent(percent);
+        match &budget {
+            Some(budget) => info!("Memory budget set to {} bytes ({}% of system memory)", budget.limit(), percent),
+            None => error!("Failed to determine system memory, memory budget disabled"),
+        }
+        budget.map(Arc::new)
+    });
+    
+    // 创建磁盘空间保护
+    let disk_guard = config.disk_reserve_mb.map(|reserve_mb| {
+        info!("Refusing writes when free disk space for {} drops below {} MB", db_path, reserve_mb);
+        Arc::new(DiskGuard::new(&db_path, reserve_mb * 1024 * 1024))
+    });
+    
+    // 根据内存预算和磁盘空间在资源紧张时降级
+    let policy = DegradationPolicy::default()
+        .with_sample_rows(Some(config.degraded_row_limit).filter(|rows| *rows > 0));
+    let mut degradation = DegradationController::new(policy);
+    if let Some(budget) = &memory_budget {
+        degradation = degradation.with_memory_budget(budget.clone());
+    }
+    if let Some(guard) = &disk_guard {
+        degradation = degradation.with_disk_guard(guard.clone());
+    }
+    let degradation = Arc::new(degradation);
+    
+    // 限制同时执行的查询数
+    let admission = config.max_concurrent_queries.map(|limit| {
+        info!("Limiting concurrent queries to {} ({} may wait in queue)", limit, config.query_queue_size);
+        Arc::new(AdmissionController::new(limit, config.query_queue_size))
+    });
+    
+    // 降级阈值、慢查询阈值和准入限制可通过管理接口在运行时调整
+    let runtime_config = Arc::new(RuntimeConfig::new(degradation.clone(), admission.clone()));
+    
+    // 按密钥检测异常负载
+    let anomaly_detector = config.anomaly_sensitivity.map(|sensitivity| {
+        info!("Detecting workload anomalies above {} standard deviations", sensitivity);
+        Arc::new(
+            AnomalyDetector::new(sensitivity)
+                .with_window(Duration::from_secs(config.anomaly_window_secs))
+                .with_max_alerts(config.retention.usage.max_entries.unwrap_or(usize::MAX))
+        )
+    });
+    
+    // 共享执行器，查询结果和常驻向量集合从内存预算中申请内存
+    let (db_executor, vector_executor) = match &memory_budget {
+        Some(budget) => (
+            db_executor.with_memory_budget(budget.clone()),
+            vector_executor.with_memory_budget(budget.clone()),
+        ),
+        None => (db_executor, vector_executor),
+    };
     let db_executor = Arc::new(db_executor);
     let vector_executor = Arc::new(vector_executor);
+    if let Some(budget) = &memory_budget {
+        let vectors = Arc::downgrade(&vector_executor);
+        budget.register_reclaimer(crate::db::vector_executor::MEMORY_SUBSYSTEM, move |bytes| {
+            vectors.upgrade().map_or(0, |vectors| vectors.reclaim_memory(bytes))
+        });
+    }
+    
+    // 查询结果缓存与其他处理程序共享执行器，任何写操作都会使相关表的缓存失效
+    let mut cached_executor = CachedDbExecutor::from_executor(db_executor.clone())
+        .with_degradation(degradation.clone())
+        .with_serve_queries(config.query_cache_ttl_secs.is_some());
+    if let Some(ttl) = config.query_cache_ttl_secs {
+        cached_executor = cached_executor.with_cache_ttl(Duration::from_secs(ttl));
+        info!("Query result cache enabled with a {}s TTL", ttl);
+    }
+    if let Some(budget) = &memory_budget {
+        cached_executor = cached_executor.with_memory_budget(budget.clone());
+    }
+    let cached_executor = Arc::new(cached_executor);
+    cached_executor.invalidate_on_write();
+    // 过期的查询结果只在读取时删除，后台定期清理未再读取的结果；句柄随应用数据保留到服务停止
+    let cache_janitor = {
+        let cached = Arc::downgrade(&cached_executor);
+        Arc::new(CacheJanitor::spawn("query_cache", Duration::from_secs(config.cache_cleanup_interval_secs), move || {
+            cached.upgrade().map_or_else(CleanupReport::default, |cached| cached.cleanup_expired())
+        }))
+    };
+    if let Some(budget) = &memory_budget {
+        let cached = Arc::downgrade(&cached_executor);
+        budget.register_reclaimer("query_cache", move |bytes| {
+            cached.upgrade().map_or(0, |cached| cached.shrink_cache(bytes))
+        });
+    }
+    
+    // 启动时检查内部表和向量集合文件，尽量自动修复，结果通过健康检查返回
+    let integrity = {
+        let started = std::time::Instant::now();
+        let mut findings = db_executor.verify_integrity();
+        findings.extend(vector_executor.verify_spilled_collections());
+        if let Some(auditor) = db_executor.auditor() {
+            findings.push(auditor.verify());
+        }
+        Arc::new(IntegrityReport::new(findings, started.elapsed()))
+    };
+    if integrity.has_failures() {
+        error!("Startup integrity check found problems that could not be repaired, see /api/health");
+    }
+    
+    // 把选定集合的写入推送到副本
+    replication::spawn_pusher(
+        replication_log.clone(),
+        config.replication_api_key.clone(),
+        Duration::from_millis(config.replication_interval_ms),
+    );
+    if replication_role == ReplicationRole::Replica {
+        info!("Vector store is running as a replica and rejects client writes until promoted");
+    }
+    
+    // 冷启动预热，完成前就绪探针返回未就绪
+    let mut priming_queries = Vec::new();
+    if let Some(path) = &config.warmup_queries_file {
+        match std::fs::read_to_string(path) {
+            Ok(content) => priming_queries = content
+                .lines()
+                .map(|line| line.trim())
+                .filter(|line| !line.is_empty() && !line.starts_with("--"))
+                .map(|line| line.to_string())
+                .collect(),
+            Err(e) => error!("Failed to read warmup queries from {}: {}", path, e),
+        }
+    }
+    let warmup = Arc::new(Warmup::new(WarmupPlan {
+        priming_queries,
+        hot_query_snapshot: config.hot_query_snapshot.as_ref().map(PathBuf::from),
+        collections: config.warmup_collections.clone(),
+    }));
+    if !warmup.is_ready() {
+        let warmup = warmup.clone();
+        let db_executor = db_executor.clone();
+        let vector_executor = vector_executor.clone();
+        std::thread::spawn(move || {
+            warmup.run(
+                |sql| db_executor.query(sql).map(|_| ()).map_err(|e| e.to_string()),
+                |name| vector_executor.warm_collection(name).map(|_| ()),
+            );
+        });
+    }
+    
+    // 按路由和子系统统计错误率，错误预算的消耗通过健康检查返回
+    let error_budget = Arc::new(ErrorBudgetTracker::new(config.slo_target));
+    
+    // 保留数据库快照以支持按时间点查询
+    let snapshots = config.snapshot_dir.as_ref().map(|dir| {
+        info!("Retaining the newest {} database snapshots in {}", config.snapshot_retention, dir);
+        Arc::new(SnapshotStore::new(dir, config.snapshot_retention))
+    });
+    if let (Some(store), Some(interval)) = (&snapshots, config.snapshot_interval_secs) {
+        let store = store.clone();
+        let db_executor = db_executor.clone();
+        let error_budget = error_budget.clone();
+        std::thread::spawn(move || loop {
+            std::thread::sleep(Duration::from_secs(interval));
+            let result = db_executor.take_snapshot(&store);
+            error_budget.record_subsystem("snapshots", result.is_err());
+            if let Err(e) = result {
+                error!("Failed to take database snapshot: {}", e);
+            }
+        });
+    }
+    
+    // 长时间运行的查询异步执行，完成后回调通知
+    let query_jobs = match &config.query_job_dir {
+        Some(dir) => match QueryJobManager::new(dir) {
+            Ok(manager) => {
+                info!("Saving query job results in {} for {} seconds", dir, config.query_job_retention_secs);
+                let manager = manager
+                    .with_retention(Duration::from_secs(config.query_job_retention_secs))
+                    .with_private_callbacks(config.query_job_private_callbacks);
+                Some(Arc::new(match &config.public_url {
+                    Some(url) => manager.with_base_url(url.clone()),
+                    None => manager,
+                }))
+            },
+            Err(e) => {
+                error!("Failed to create query job directory {}: {}", dir, e);
+                return Err(e);
+            }
+        },
+        None => None,
+    };
+    
+    // 审批模式下非管理员密钥的破坏性操作需要管理员批准
+    let approvals = if config.approval_mode {
+        info!("Destructive operations from non-admin keys require admin approval");
+        Some(Arc::new(ApprovalQueue::new()))
+    } else {
+        None
+    };
+    
+    // 按保留策略定期清理审计记录、查询统计和使用历史
+    let mut retention = RetentionEnforcer::new()
+        .with_target("slow_queries", config.retention.slow_queries, db_executor.stats_collector());
+    if let Some(auditor) = db_executor.auditor() {
+        retention = retention.with_target("audit", config.retention.audit, auditor.clone());
+    }
+    if let Some(detector) = &anomaly_detector {
+        retention = retention.with_target("usage", config.retention.usage, detector.clone());
+    }
+    retention.spawn(config.retention.interval);
+    
+    // 共享的DuckDB引擎只能安装和加载允许列表中的扩展，启动时加载配置的扩展
+    let extension_policy = match ExtensionPolicy::from_entries(&config.duckdb_extension_allowlist) {
+        Ok(policy) => policy,
+        Err(e) => {
+            error!("Invalid DuckDB extension allowlist: {}", e);
+            return Err(std::io::Error::new(std::io::ErrorKind::InvalidInput, e.to_string()));
+        }
+    };
+    let mut duckdb = DuckDbEngine::new(":memory:").with_extension_policy(extension_policy);
+    if let Err(e) = duckdb.init() {
+        error!("Failed to initialize DuckDB engine: {}", e);
+        return Err(std::io::Error::new(std::io::ErrorKind::Other, e.to_string()));
+    }
+    let duckdb = Arc::new(Mutex::new(duckdb));
+    if !config.duckdb_extensions.is_empty() {
+        let enabled = super::handlers::extension_handler::enable_configured_extensions(&duckdb, &config.duckdb_extensions);
+        info!("Enabled {} of {} configured DuckDB extensions", enabled.len(), config.duckdb_extensions.len());
+    }
+    
+    // 固定表复制到共享的DuckDB引擎，通过执行器的写操作使副本过期
+    let pinned = Arc::new(PinnedTables::new(db_executor.clone(), duckdb.clone(), config.pinned_max_rows));
+    pinned.invalidate_on_write();
+    for table in &config.pinned_tables {
+        match pinned.pin(table) {
+            Ok(info) => info!("Pinned table '{}' in memory ({} rows)", info.table, info.rows),
+            Err(e) => error!("Failed to pin table '{}': {}", table, e),
+        }
+    }
     
     // 确定服务器地址
     let host = config.host.clone();
@@ -46,6 +352,12 @@ pub async fn run_server(config: ServerConfig) -> std::io::Result<()> {
     let bind_address = format!("{}:{}", host, port);
     
     info!("Starting Lumos-DB server on {}", bind_address);
+    if config.read_only {
+        info!("Server is running in read-only mode");
+    }
+    
+    let hot_query_snapshot = config.hot_query_snapshot.clone();
+    let stats_executor = db_executor.clone();
     
     // 创建并启动HTTP服务器
     HttpServer::new(move || {
@@ -63,16 +375,70 @@ pub async fn run_server(config: ServerConfig) -> std::io::Result<()> {
             .wrap(middleware::Logger::default())
             .wrap(middleware::Compress::default())
             .wrap(cors)
-            .wrap(AuthMiddleware::new(config.api_key.clone()))
+            .wrap(ErrorBudgetRecorder::new(error_budget.clone()))
+            .wrap(DiskSpaceGuard::new(disk_guard.clone()))
+            .wrap(ReadOnlyGuard)
+            .wrap(
+                AuthMiddleware::new(config.api_key.clone())
+                    .with_app_keys(config.app_api_keys.clone())
+                    .with_read_only_keys(config.read_only_api_keys.clone())
+                    .with_read_only(config.read_only)
+            )
             
             // 应用数据
             .app_data(web::Data::new(db_executor.clone()))
+            .app_data(web::Data::new(cached_executor.clone()))
             .app_data(web::Data::new(vector_executor.clone()))
+            .app_data(web::Data::new(degradation.clone()))
+            .app_data(web::Data::new(warmup.clone()))
+            .app_data(web::Data::new(integrity.clone()))
+            .app_data(web::Data::new(duckdb.clone()))
+            .app_data(web::Data::new(pinned.clone()))
+            .app_data(web::Data::new(runtime_config.clone()))
+            .app_data(web::Data::new(error_budget.clone()))
+            .app_data(web::Data::new(cache_janitor.clone()))
+            .app_data(web::Data::new(BindingPolicy::new(config.strict_parameter_binding)))
+            .configure(|cfg| {
+                if let Some(budget) = &memory_budget {
+                    cfg.app_data(web::Data::new(budget.clone()));
+                }
+                if let Some(guard) = &disk_guard {
+                    cfg.app_data(web::Data::new(guard.clone()));
+                }
+                if let Some(admission) = &admission {
+                    cfg.app_data(web::Data::new(admission.clone()));
+                }
+                if let Some(detector) = &anomaly_detector {
+                    cfg.app_data(web::Data::new(detector.clone()));
+                }
+                if let Some(store) = &snapshots {
+                    cfg.app_data(web::Data::new(store.clone()));
+                }
+                if let Some(jobs) = &query_jobs {
+                    cfg.app_data(web::Data::new(jobs.clone()));
+                }
+                if let Some(approvals) = &approvals {
+                    cfg.app_data(web::Data::new(approvals.clone()));
+                }
+                if let Some(dir) = &config.import_dir {
+                    cfg.app_data(web::Data::new(ImportDir(PathBuf::from(dir))));
+                }
+            })
             
             // 配置路由
             .configure(super::configure_routes)
     })
     .bind(bind_address)?
     .run()
-    .await
+    .await?;
+    
+    // 保存热点查询，供下次启动预热
+    if let Some(path) = &hot_query_snapshot {
+        match warmup::save_hot_queries(Path::new(path), &stats_executor.query_stats(), warmup::DEFAULT_HOT_QUERIES) {
+            Ok(count) => info!("Saved {} hot queries to {}", count, path),
+            Err(e) => error!("Failed to save hot queries to {}: {}", path, e),
+        }
+    }
+    
+    Ok(())
 } 
\ No newline at end of file
@@ -0,0 +1,91 @@
+//! 蓝绿迁移工具：将旧实例的数据和管道定义复制到新实例，校验后生成切换报告
+//!
+//! 两个实例都应已停止。用法：
+//!
+//! ```text
+//! lumos_migrate --from-db old/lumos.db --to-db new/lumos.db \
+//!     [--from-vectors old/lumos_vector.db --to-vectors new/lumos_vector.db] \
+//!     [--from-pipelines old/pipelines --to-pipelines new/pipelines] \
+//!     [--replace] [--report cutover.json]
+//! ```
+//!
+//! 报告以JSON写入`--report`指定的文件，Markdown摘要打印到标准输出。
+//! 有任何表、集合或管道定义校验不一致时以状态码2退出。
+
+use std::collections::HashMap;
+use std::path::PathBuf;
+use std::process;
+
+use lumos_core::sqlite::migrate::migrate_database;
+use lumos_server::utils::migration::{migrate_collections, migrate_pipelines, CutoverReport};
+
+fn main() {
+    env_logger::init();
+
+    let args = match parse_args() {
+        Ok(args) => args,
+        Err(e) => {
+            eprintln!("{}", e);
+            process::exit(1);
+        }
+    };
+
+    if let Err(e) = run(&args) {
+        eprintln!("Migration failed: {}", e);
+        process::exit(1);
+    }
+}
+
+fn run(args: &HashMap<String, String>) -> Result<(), String> {
+    let from_db = args.get("from-db").ok_or("--from-db is required")?;
+    let to_db = args.get("to-db").ok_or("--to-db is required")?;
+
+    let target = rusqlite::Connection::open(to_db)
+        .map_err(|e| format!("Failed to open {}: {}", to_db, e))?;
+    let database = migrate_database(from_db, &target, args.contains_key("replace"))
+        .map_err(|e| e.to_string())?;
+
+    // 向量库路径对应的集合目录与VectorExecutor一致
+    let collections = match (args.get("from-vectors"), args.get("to-vectors")) {
+        (Some(from), Some(to)) => migrate_collections(
+            &PathBuf::from(format!("{}.collections", from)),
+            &PathBuf::from(format!("{}.collections", to)),
+        )?,
+        (None, None) => Vec::new(),
+        _ => return Err("--from-vectors and --to-vectors must be given together".to_string()),
+    };
+
+    let pipelines = match (args.get("from-pipelines"), args.get("to-pipelines")) {
+        (Some(from), Some(to)) => migrate_pipelines(&PathBuf::from(from), &PathBuf::from(to))?,
+        (None, None) => Vec::new(),
+        _ => return Err("--from-pipelines and --to-pipelines must be given together".to_string()),
+    };
+
+    let report = CutoverReport::new(database, collections, pipelines);
+    if let Some(path) = args.get("report") {
+        let json = serde_json::to_string_pretty(&report).map_err(|e| e.to_string())?;
+        std::fs::write(path, json).map_err(|e| format!("Failed to write {}: {}", path, e))?;
+    }
+    println!("{}", report.to_markdown());
+
+    if !report.ready_for_cutover() {
+        process::exit(2);
+    }
+    Ok(())
+}
+
+/// 解析`--name value`形式的参数，`--replace`不带值
+fn parse_args() -> Result<HashMap<String, String>, String> {
+    let mut args = HashMap::new();
+    let mut iter = std::env::args().skip(1);
+    while let Some(arg) = iter.next() {
+        let name = arg.strip_prefix("--").ok_or_else(|| format!("Unexpected argument: {}", arg))?;
+        if name == "replace" {
+            args.insert(name.to_string(), String::new());
+            continue;
+        }
+        let value = iter.next().ok_or_else(|| format!("Missing value for --{}", name))?;
+        args.insert(name.to_string(), value);
+    }
+    Ok(args)
+}
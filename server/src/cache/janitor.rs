@@ -0,0 +1,187 @@
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Condvar, Mutex};
+use std::thread::{self, JoinHandle};
+use std::time::Duration;
+use log::{debug, info};
+use serde::Serialize;
+
+/// 一次清理操作的结果
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct CleanupReport {
+    /// 清理的缓存项数量
+    pub items: usize,
+    /// 回收的字节数（仅在配置了大小计算函数时有效）
+    pub bytes: usize,
+}
+
+/// 后台清理统计信息
+#[derive(Debug, Default)]
+pub struct JanitorStats {
+    runs: AtomicU64,
+    reclaimed_items: AtomicU64,
+    reclaimed_bytes: AtomicU64,
+}
+
+/// 后台清理统计快照
+#[derive(Debug, Clone, Serialize)]
+pub struct JanitorStatsSnapshot {
+    pub runs: u64,
+    pub reclaimed_items: u64,
+    pub reclaimed_bytes: u64,
+}
+
+impl JanitorStats {
+    fn record(&self, report: CleanupReport) {
+        self.runs.fetch_add(1, Ordering::Relaxed);
+        self.reclaimed_items.fetch_add(report.items as u64, Ordering::Relaxed);
+        self.reclaimed_bytes.fetch_add(report.bytes as u64, Ordering::Relaxed);
+    }
+
+    /// 获取统计快照
+    pub fn snapshot(&self) -> JanitorStatsSnapshot {
+        JanitorStatsSnapshot {
+            runs: self.runs.load(Ordering::Relaxed),
+            reclaimed_items: self.reclaimed_items.load(Ordering::Relaxed),
+            reclaimed_bytes: self.reclaimed_bytes.load(Ordering::Relaxed),
+        }
+    }
+}
+
+/// 缓存后台清理器，定期清除过期缓存项
+///
+/// 清理器在独立线程中运行，句柄被丢弃时自动停止。
+pub struct CacheJanitor {
+    name: String,
+    shutdown: Arc<(Mutex<bool>, Condvar)>,
+    handle: Option<JoinHandle<()>>,
+    stats: Arc<JanitorStats>,
+}
+
+impl CacheJanitor {
+    /// 启动后台清理器，每隔`interval`调用一次`sweep`
+    pub fn spawn<F>(name: &str, interval: Duration, sweep: F) -> Self
+    where
+        F: Fn() -> CleanupReport + Send + 'static,
+    {
+        let shutdown = Arc::new((Mutex::new(false), Condvar::new()));
+        let stats = Arc::new(JanitorStats::default());
+
+        let thread_shutdown = shutdown.clone();
+        let thread_stats = stats.clone();
+        let thread_name = name.to_string();
+
+        let handle = thread::Builder::new()
+            .name(format!("cache-janitor-{}", name))
+            .spawn(move || {
+                let (lock, cvar) = &*thread_shutdown;
+                let mut stopped = lock.lock().unwrap();
+
+                loop {
+                    let (guard, _) = cvar.wait_timeout(stopped, interval).unwrap();
+                    stopped = guard;
+                    if *stopped {
+                        break;
+                    }
+
+                    let report = sweep();
+                    thread_stats.record(report);
+                    if report.items > 0 {
+                        debug!(
+                            "Cache janitor '{}' reclaimed {} items ({} bytes)",
+                            thread_name, report.items, report.bytes
+                        );
+                    }
+                }
+
+                info!("Cache janitor '{}' stopped", thread_name);
+            })
+            .expect("failed to spawn cache janitor thread");
+
+        Self {
+            name: name.to_string(),
+            shutdown,
+            handle: Some(handle),
+            stats,
+        }
+    }
+
+    /// 获取清理统计信息
+    pub fn stats(&self) -> JanitorStatsSnapshot {
+        self.stats.snapshot()
+    }
+
+    /// 以Prometheus文本格式输出清理统计，指标名称以`prefix`开头，按缓存名称区分
+    pub fn render_prometheus(&self, prefix: &str) -> String {
+        let stats = self.stats();
+        format!(
+            "# HELP {p}_janitor_runs_total Background cleanup runs\n\
+             # TYPE {p}_janitor_runs_total counter\n\
+             {p}_janitor_runs_total{{cache=\"{c}\"}} {}\n\
+             # HELP {p}_janitor_reclaimed_items_total Expired entries removed by the background cleanup\n\
+             # TYPE {p}_janitor_reclaimed_items_total counter\n\
+             {p}_janitor_reclaimed_items_total{{cache=\"{c}\"}} {}\n\
+             # HELP {p}_janitor_reclaimed_bytes_total Bytes reclaimed by the background cleanup\n\
+             # TYPE {p}_janitor_reclaimed_bytes_total counter\n\
+             {p}_janitor_reclaimed_bytes_total{{cache=\"{c}\"}} {}\n",
+            stats.runs,
+            stats.reclaimed_items,
+            stats.reclaimed_bytes,
+            p = prefix,
+            c = self.name,
+        )
+    }
+
+    /// 停止清理器并等待线程退出
+    pub fn stop(&mut self) {
+        {
+            let (lock, cvar) = &*self.shutdown;
+            *lock.lock().unwrap() = true;
+            cvar.notify_all();
+        }
+
+        if let Some(handle) = self.handle.take() {
+            let _ = handle.join();
+        }
+    }
+}
+
+impl Drop for CacheJanitor {
+    fn drop(&mut self) {
+        self.stop();
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::cache::MemoryCache;
+
+    #[test]
+    fn test_janitor_prunes_expired_items() {
+        let cache = Arc::new(
+            MemoryCache::<String, String>::new()
+                .with_ttl(Duration::from_millis(10))
+                .with_size_fn(|v| v.len())
+        );
+        cache.set("a".to_string(), "12345".to_string());
+        cache.set("b".to_string(), "123".to_string());
+
+        let sweep_cache = cache.clone();
+        let mut janitor = CacheJanitor::spawn("test", Duration::from_millis(20), move || {
+            sweep_cache.cleanup_report()
+        });
+
+        thread::sleep(Duration::from_millis(100));
+        janitor.stop();
+
+        let stats = janitor.stats();
+        assert!(stats.runs > 0);
+        assert_eq!(stats.reclaimed_items, 2);
+        assert_eq!(stats.reclaimed_bytes, 8);
+        assert!(cache.is_empty());
+
+        let text = janitor.render_prometheus("lumos_cache");
+        assert!(text.contains("lumos_cache_janitor_reclaimed_items_total{cache=\"test\"} 2\n"));
+        assert!(text.contains("lumos_cache_janitor_reclaimed_bytes_total{cache=\"test\"} 8\n"));
+    }
+}
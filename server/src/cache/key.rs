@@ -0,0 +1,132 @@
+use sha2::{Digest, Sha256};
+
+// 参数类型标记，保证不同类型的相同文本不会产生相同的键
+const TAG_NULL: u8 = 0;
+const TAG_TEXT: u8 = 1;
+const TAG_INTEGER: u8 = 2;
+const TAG_REAL: u8 = 3;
+
+/// 查询缓存键构造器
+///
+/// 对规范化后的SQL和带类型的参数计算SHA-256。每个部分都带长度前缀，
+/// 因此参数中包含分隔符（如`:`）时也不会与其他参数组合冲突。
+pub struct QueryKeyBuilder {
+    hasher: Sha256,
+}
+
+impl QueryKeyBuilder {
+    /// 以SQL语句创建构造器，SQL会先被规范化
+    pub fn new(sql: &str) -> Self {
+        let mut builder = Self {
+            hasher: Sha256::new(),
+        };
+        builder.write_bytes(normalize_sql(sql).as_bytes());
+        builder
+    }
+
+    /// 添加文本参数
+    pub fn text(mut self, value: &str) -> Self {
+        self.hasher.update([TAG_TEXT]);
+        self.write_bytes(value.as_bytes());
+        self
+    }
+
+    /// 添加整数参数
+    pub fn integer(mut self, value: i64) -> Self {
+        self.hasher.update([TAG_INTEGER]);
+        self.hasher.update(value.to_be_bytes());
+        self
+    }
+
+    /// 添加浮点数参数
+    pub fn real(mut self, value: f64) -> Self {
+        self.hasher.update([TAG_REAL]);
+        self.hasher.update(value.to_bits().to_be_bytes());
+        self
+    }
+
+    /// 添加NULL参数
+    pub fn null(mut self) -> Self {
+        self.hasher.update([TAG_NULL]);
+        self
+    }
+
+    /// 生成十六进制表示的缓存键
+    pub fn build(self) -> String {
+        hex::encode(self.hasher.finalize())
+    }
+
+    // 写入带长度前缀的字节
+    fn write_bytes(&mut self, bytes: &[u8]) {
+        self.hasher.update((bytes.len() as u64).to_be_bytes());
+        self.hasher.update(bytes);
+    }
+}
+
+/// 为文本参数的查询构造缓存键
+pub fn query_key(sql: &str, params: &[String]) -> String {
+    params.iter()
+        .fold(QueryKeyBuilder::new(sql), |builder, param| builder.text(param))
+        .build()
+}
+
+/// 规范化SQL：合并字符串和标识符之外的空白，去掉末尾的分号
+///
+/// 不改变大小写，因为标识符和字符串字面量可能区分大小写。
+pub fn normalize_sql(sql: &str) -> String {
+    let mut normalized = String::with_capacity(sql.len());
+    let mut quote: Option<char> = None;
+    let mut pending_space = false;
+
+    for c in sql.trim().trim_end_matches(';').trim_end().chars() {
+        match quote {
+            Some(q) => {
+                normalized.push(c);
+                if c == q {
+                    quote = None;
+                }
+            }
+            None if c.is_whitespace() => pending_space = true,
+            None => {
+                if pending_space && !normalized.is_empty() {
+                    normalized.push(' ');
+                }
+                pending_space = false;
+                if c == '\'' || c == '"' || c == '`' {
+                    quote = Some(c);
+                }
+                normalized.push(c);
+            }
+        }
+    }
+
+    normalized
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_normalize_sql() {
+        assert_eq!(
+            normalize_sql("  SELECT *\n\tFROM users   WHERE name = 'a  b' ; "),
+            "SELECT * FROM users WHERE name = 'a  b'"
+        );
+    }
+
+    #[test]
+    fn test_query_key() {
+        let key = query_key("SELECT * FROM t WHERE a = ? AND b = ?", &["x:y".to_string(), "z".to_string()]);
+        assert_eq!(key, query_key("SELECT *  FROM t WHERE a = ? AND b = ?;", &["x:y".to_string(), "z".to_string()]));
+
+        // 参数边界不同的键不应相同
+        assert_ne!(key, query_key("SELECT * FROM t WHERE a = ? AND b = ?", &["x".to_string(), "y:z".to_string()]));
+
+        // 相同文本的不同类型不应相同
+        assert_ne!(
+            QueryKeyBuilder::new("SELECT ?").text("1").build(),
+            QueryKeyBuilder::new("SELECT ?").integer(1).build()
+        );
+    }
+}
@@ -5,6 +5,8 @@ use std::time::{Duration, Instant};
 use log::{debug, info};
 use std::collections::VecDeque;
 
+use super::janitor::CleanupReport;
+
 // LRU缓存实现
 pub struct LruCache<K, V>
 where
@@ -16,6 +18,7 @@ where
     ttl: Option<Duration>,
     expiry_times: Arc<RwLock<HashMap<K, Instant>>>,
     capacity: usize,
+    size_fn: Option<fn(&V) -> usize>,
 }
 
 impl<K, V> LruCache<K, V>
@@ -31,6 +34,7 @@ where
             ttl: None,
             expiry_times: Arc::new(RwLock::new(HashMap::with_capacity(capacity))),
             capacity,
+            size_fn: None,
         }
     }
     
@@ -40,6 +44,12 @@ where
         self
     }
     
+    // 设置缓存项大小计算函数，用于统计回收的字节数
+    pub fn with_size_fn(mut self, size_fn: fn(&V) -> usize) -> Self {
+        self.size_fn = Some(size_fn);
+        self
+    }
+    
     // 获取缓存项，更新LRU队列
     pub fn get(&self, key: &K) -> Option<V> {
         // 检查是否过期
@@ -137,6 +147,27 @@ where
         removed
     }
     
+    // 删除所有满足条件的缓存项，返回删除的数量
+    pub fn remove_where(&self, predicate: &dyn Fn(&K) -> bool) -> usize {
+        let keys_to_remove: Vec<K> = {
+            let cache = self.cache.read().unwrap();
+            cache.keys().filter(|key| predicate(key)).cloned().collect()
+        };
+        
+        let mut removed = 0;
+        for key in &keys_to_remove {
+            if self.remove(key) {
+                removed += 1;
+            }
+        }
+        
+        if removed > 0 {
+            debug!("Removed {} LRU cache items by predicate", removed);
+        }
+        
+        removed
+    }
+    
     // 清空缓存
     pub fn clear(&self) {
         {
@@ -169,9 +200,16 @@ where
     
     // 清理过期的缓存项
     pub fn cleanup(&self) -> usize {
+        self.cleanup_report().items
+    }
+    
+    // 清理过期的缓存项，返回清理数量和回收的字节数
+    pub fn cleanup_report(&self) -> CleanupReport {
+        let mut report = CleanupReport::default();
+        
         // 如果没有TTL，直接返回
         if self.ttl.is_none() {
-            return 0;
+            return report;
         }
         
         let now = Instant::now();
@@ -187,17 +225,28 @@ where
             }
         }
         
+        // 计算回收的字节数
+        if let Some(size_fn) = self.size_fn {
+            let cache = self.cache.read().unwrap();
+            for key in &keys_to_remove {
+                if let Some(value) = cache.get(key) {
+                    report.bytes += size_fn(value);
+                }
+            }
+        }
+        
         // 移除过期项
         for key in &keys_to_remove {
-            self.remove(key);
+            if self.remove(key) {
+                report.items += 1;
+            }
         }
         
-        let count = keys_to_remove.len();
-        if count > 0 {
-            debug!("Cleaned up {} expired LRU cache items", count);
+        if report.items > 0 {
+            debug!("Cleaned up {} expired LRU cache items ({} bytes)", report.items, report.bytes);
         }
         
-        count
+        report
     }
     
     // 检查key是否过期
@@ -1,14 +1,19 @@
 use std::collections::HashMap;
 use std::hash::Hash;
+use std::sync::atomic::{AtomicUsize, Ordering};
 use std::sync::{Arc, RwLock};
 use std::time::{Duration, Instant};
 use log::{debug, info};
 
+use super::janitor::CleanupReport;
+use crate::utils::memory_budget::MemoryBudget;
+
 // 缓存项结构
 struct CacheItem<V> {
     value: V,
     expiry: Option<Instant>,
     last_accessed: Instant,
+    size: usize,
 }
 
 // 内存缓存实现
@@ -20,6 +25,13 @@ where
     cache: Arc<RwLock<HashMap<K, CacheItem<V>>>>,
     ttl: Option<Duration>,
     max_size: Option<usize>,
+    max_bytes: Option<usize>,
+    max_entry_bytes: Option<usize>,
+    size_fn: Option<fn(&V) -> usize>,
+    // 当前缓存项的总字节数，仅在持有写锁时修改
+    used_bytes: Arc<AtomicUsize>,
+    // 共享内存预算及本缓存的子系统名称
+    budget: Option<(Arc<MemoryBudget>, String)>,
 }
 
 impl<K, V> MemoryCache<K, V> 
@@ -33,6 +45,11 @@ where
             cache: Arc::new(RwLock::new(HashMap::new())),
             ttl: None,
             max_size: None,
+            max_bytes: None,
+            max_entry_bytes: None,
+            size_fn: None,
+            used_bytes: Arc::new(AtomicUsize::new(0)),
+            budget: None,
         }
     }
     
@@ -48,6 +65,30 @@ where
         self
     }
     
+    // 设置缓存最大字节数，需配合大小计算函数使用
+    pub fn with_max_bytes(mut self, bytes: usize) -> Self {
+        self.max_bytes = Some(bytes);
+        self
+    }
+    
+    // 设置单个缓存项的最大字节数，超过的值不会被缓存
+    pub fn with_max_entry_bytes(mut self, bytes: usize) -> Self {
+        self.max_entry_bytes = Some(bytes);
+        self
+    }
+    
+    // 从共享内存预算申请缓存项占用的内存，预算不足时淘汰本缓存的旧项
+    pub fn with_memory_budget(mut self, budget: Arc<MemoryBudget>, subsystem: &str) -> Self {
+        self.budget = Some((budget, subsystem.to_string()));
+        self
+    }
+    
+    // 设置缓存项大小计算函数，用于字节数限制和统计回收的字节数
+    pub fn with_size_fn(mut self, size_fn: fn(&V) -> usize) -> Self {
+        self.size_fn = Some(size_fn);
+        self
+    }
+    
     // 获取缓存项，更新最后访问时间
     pub fn get(&self, key: &K) -> Option<V> {
         let mut cache = self.cache.write().unwrap();
@@ -57,7 +98,7 @@ where
             if let Some(expiry) = item.expiry {
                 if Instant::now() > expiry {
                     // 项已过期，删除
-                    cache.remove(key);
+                    self.remove_item(&mut cache, key);
                     debug!("Cache item expired: {:?}", key);
                     return None;
                 }
@@ -71,38 +112,94 @@ where
         None
     }
     
-    // 设置缓存项
-    pub fn set(&self, key: K, value: V) {
+    // 设置缓存项，超过单项字节上限的值不会被缓存，返回是否已缓存
+    pub fn set(&self, key: K, value: V) -> bool {
+        let size = self.size_fn.map_or(0, |size_fn| size_fn(&value));
         let mut cache = self.cache.write().unwrap();
         
+        // 替换旧值时先移除，避免重复计算字节数
+        self.remove_item(&mut cache, &key);
+        
+        if let Some(max_entry_bytes) = self.max_entry_bytes {
+            if size > max_entry_bytes {
+                debug!("Rejected cache item {:?}: {} bytes exceeds entry limit of {}", key, size, max_entry_bytes);
+                return false;
+            }
+        }
+        
         // 检查是否达到最大容量
         if let Some(max_size) = self.max_size {
-            if cache.len() >= max_size && !cache.contains_key(&key) {
+            if cache.len() >= max_size {
                 // 达到最大容量，需要移除最旧的项
                 self.evict_oldest(&mut cache);
             }
         }
         
+        // 检查是否超过最大字节数
+        if let Some(max_bytes) = self.max_bytes {
+            while self.bytes() + size > max_bytes && !cache.is_empty() {
+                self.evict_oldest(&mut cache);
+            }
+        }
+        
+        // 从共享内存预算申请，持有写锁时不触发其他子系统的回收
+        if let Some((budget, subsystem)) = &self.budget {
+            while !budget.try_reserve(subsystem, size) {
+                if cache.is_empty() {
+                    debug!("Rejected cache item {:?}: memory budget exhausted", key);
+                    return false;
+                }
+                self.evict_oldest(&mut cache);
+            }
+        }
+        
         let now = Instant::now();
         let expiry = self.ttl.map(|ttl| now + ttl);
         
+        self.used_bytes.fetch_add(size, Ordering::Relaxed);
         cache.insert(key, CacheItem {
             value,
             expiry,
             last_accessed: now,
+            size,
         });
+        
+        true
     }
     
     // 删除缓存项
     pub fn remove(&self, key: &K) -> bool {
         let mut cache = self.cache.write().unwrap();
-        cache.remove(key).is_some()
+        self.remove_item(&mut cache, key)
+    }
+    
+    // 删除所有满足条件的缓存项，返回删除的数量
+    pub fn remove_where(&self, predicate: &dyn Fn(&K) -> bool) -> usize {
+        let mut cache = self.cache.write().unwrap();
+        let before_count = cache.len();
+        let mut freed = 0;
+        cache.retain(|key, item| {
+            if predicate(key) {
+                freed += item.size;
+                return false;
+            }
+            true
+        });
+        self.release_bytes(freed);
+        
+        let removed = before_count - cache.len();
+        if removed > 0 {
+            debug!("Removed {} cache items by predicate", removed);
+        }
+        
+        removed
     }
     
     // 清空缓存
     pub fn clear(&self) {
         let mut cache = self.cache.write().unwrap();
         cache.clear();
+        self.release_bytes(self.bytes());
         info!("Cache cleared");
     }
     
@@ -112,6 +209,11 @@ where
         cache.len()
     }
     
+    // 获取缓存项的总字节数（仅在配置了大小计算函数时有效）
+    pub fn bytes(&self) -> usize {
+        self.used_bytes.load(Ordering::Relaxed)
+    }
+    
     // 缓存是否为空
     pub fn is_empty(&self) -> bool {
         let cache = self.cache.read().unwrap();
@@ -120,25 +222,46 @@ where
     
     // 清理过期的缓存项
     pub fn cleanup(&self) -> usize {
+        self.cleanup_report().items
+    }
+    
+    // 清理过期的缓存项，返回清理数量和回收的字节数
+    pub fn cleanup_report(&self) -> CleanupReport {
         let mut cache = self.cache.write().unwrap();
         let now = Instant::now();
         
-        let before_count = cache.len();
+        let mut report = CleanupReport::default();
         
         // 移除所有过期项
         cache.retain(|_, item| {
             if let Some(expiry) = item.expiry {
-                return now <= expiry;
+                if now > expiry {
+                    report.items += 1;
+                    report.bytes += item.size;
+                    return false;
+                }
             }
             true
         });
+        self.release_bytes(report.bytes);
         
-        let removed = before_count - cache.len();
-        if removed > 0 {
-            debug!("Cleaned up {} expired cache items", removed);
+        if report.items > 0 {
+            debug!("Cleaned up {} expired cache items ({} bytes)", report.items, report.bytes);
         }
         
-        removed
+        report
+    }
+    
+    // 淘汰最旧的缓存项直到释放指定字节数，返回实际释放的字节数，可用作内存预算的回收函数
+    pub fn shrink(&self, bytes: usize) -> usize {
+        let mut cache = self.cache.write().unwrap();
+        let before = self.bytes();
+        
+        while before - self.bytes() < bytes && !cache.is_empty() {
+            self.evict_oldest(&mut cache);
+        }
+        
+        before - self.bytes()
     }
     
     // 移除最旧的缓存项
@@ -153,8 +276,86 @@ where
             .map(|(k, _)| k.clone());
         
         if let Some(key) = oldest_key {
-            cache.remove(&key);
+            self.remove_item(cache, &key);
             debug!("Evicted oldest cache item: {:?}", key);
         }
     }
-} 
\ No newline at end of file
+    
+    // 移除缓存项并扣除其字节数
+    fn remove_item(&self, cache: &mut HashMap<K, CacheItem<V>>, key: &K) -> bool {
+        match cache.remove(key) {
+            Some(item) => {
+                self.release_bytes(item.size);
+                true
+            }
+            None => false,
+        }
+    }
+    
+    // 扣除缓存的字节数并归还给内存预算，仅在持有写锁时调用
+    fn release_bytes(&self, bytes: usize) {
+        self.used_bytes.fetch_sub(bytes, Ordering::Relaxed);
+        if let Some((budget, subsystem)) = &self.budget {
+            budget.release(subsystem, bytes);
+        }
+    }
+} 
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_byte_limits() {
+        let cache = MemoryCache::<String, String>::new()
+            .with_size_fn(|v| v.len())
+            .with_max_bytes(10)
+            .with_max_entry_bytes(6);
+
+        assert!(cache.set("a".to_string(), "1234".to_string()));
+        assert!(cache.set("b".to_string(), "1234".to_string()));
+        assert_eq!(cache.bytes(), 8);
+
+        // 超过单项上限的值被拒绝
+        assert!(!cache.set("c".to_string(), "1234567".to_string()));
+        assert_eq!(cache.len(), 2);
+
+        // 超过总字节数时淘汰最旧的项
+        assert!(cache.set("d".to_string(), "123456".to_string()));
+        assert_eq!(cache.get(&"a".to_string()), None);
+        assert_eq!(cache.bytes(), 10);
+
+        // 替换值时重新计算字节数
+        cache.set("d".to_string(), "1".to_string());
+        assert_eq!(cache.bytes(), 5);
+
+        cache.clear();
+        assert_eq!(cache.bytes(), 0);
+    }
+
+    #[test]
+    fn test_memory_budget() {
+        let budget = Arc::new(MemoryBudget::new(10));
+        let cache = MemoryCache::<String, String>::new()
+            .with_size_fn(|v| v.len())
+            .with_memory_budget(budget.clone(), "cache");
+
+        assert!(cache.set("a".to_string(), "1234".to_string()));
+        assert!(cache.set("b".to_string(), "1234".to_string()));
+        assert_eq!(budget.used(), 8);
+
+        // 预算不足时淘汰本缓存最旧的项
+        assert!(cache.set("c".to_string(), "1234".to_string()));
+        assert_eq!(cache.get(&"a".to_string()), None);
+        assert_eq!(budget.used(), 8);
+
+        // 其他子系统占用预算后无法缓存
+        assert!(budget.try_reserve("query", 2));
+        assert!(!cache.set("d".to_string(), "123456789".to_string()));
+        assert!(cache.is_empty());
+
+        assert!(cache.set("e".to_string(), "12".to_string()));
+        assert_eq!(cache.shrink(1), 2);
+        assert_eq!(budget.used(), 2);
+    }
+}
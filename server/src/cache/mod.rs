@@ -1,11 +1,19 @@
 mod memory_cache;
 mod lru_cache;
+mod janitor;
+mod singleflight;
+mod key;
+mod named_query;
 
 use std::hash::Hash;
 use std::time::Duration;
 
 pub use memory_cache::MemoryCache;
 pub use lru_cache::LruCache;
+pub use janitor::{CacheJanitor, CleanupReport, JanitorStatsSnapshot};
+pub use singleflight::SingleFlight;
+pub use key::{QueryKeyBuilder, query_key, normalize_sql};
+pub use named_query::{NamedQuery, NamedQueries, KeyPattern};
 
 /// 缓存特性，定义通用缓存操作
 pub trait Cache<K, V>: Send + Sync + 'static
@@ -22,6 +30,25 @@ where
     /// 从缓存中移除值
     fn remove(&self, key: &K) -> bool;
     
+    /// 移除所有满足条件的键，返回移除的数量
+    fn remove_where(&self, predicate: &dyn Fn(&K) -> bool) -> usize;
+    
+    /// 移除所有以指定前缀开头的键，返回移除的数量
+    fn remove_by_prefix(&self, prefix: &str) -> usize
+    where
+        K: AsRef<str>,
+    {
+        self.remove_where(&|key: &K| key.as_ref().starts_with(prefix))
+    }
+    
+    /// 使命名空间下的所有缓存项失效，键需由`namespaced_key`生成
+    fn invalidate_namespace(&self, namespace: &str) -> usize
+    where
+        K: AsRef<str>,
+    {
+        self.remove_by_prefix(&namespace_prefix(namespace))
+    }
+    
     /// 清空缓存
     fn clear(&self);
     
@@ -33,6 +60,40 @@ where
     
     /// 清理过期缓存项，返回清理的数量
     fn cleanup(&self) -> usize;
+    
+    /// 清理过期缓存项，返回清理数量和回收的字节数
+    fn cleanup_report(&self) -> CleanupReport {
+        CleanupReport {
+            items: self.cleanup(),
+            bytes: 0,
+        }
+    }
+}
+
+/// 命名空间与键之间的分隔符
+pub const NAMESPACE_SEPARATOR: char = ':';
+
+/// 命名空间中分隔符和转义字符本身之前加上的转义字符
+pub const NAMESPACE_ESCAPE: char = '\\';
+
+/// 构造带命名空间的缓存键，例如表名作为命名空间
+///
+/// 命名空间中的分隔符会被转义，因此命名空间`a`不会覆盖`a:b`中的键。
+pub fn namespaced_key(namespace: &str, key: &str) -> String {
+    format!("{}{}", namespace_prefix(namespace), key)
+}
+
+/// 命名空间对应的键前缀：转义后的命名空间加上分隔符
+fn namespace_prefix(namespace: &str) -> String {
+    let mut prefix = String::with_capacity(namespace.len() + 1);
+    for c in namespace.chars() {
+        if c == NAMESPACE_SEPARATOR || c == NAMESPACE_ESCAPE {
+            prefix.push(NAMESPACE_ESCAPE);
+        }
+        prefix.push(c);
+    }
+    prefix.push(NAMESPACE_SEPARATOR);
+    prefix
 }
 
 /// 为MemoryCache实现Cache特性
@@ -46,13 +107,17 @@ where
     }
     
     fn set(&self, key: K, value: V) {
-        self.set(key, value)
+        self.set(key, value);
     }
     
     fn remove(&self, key: &K) -> bool {
         self.remove(key)
     }
     
+    fn remove_where(&self, predicate: &dyn Fn(&K) -> bool) -> usize {
+        self.remove_where(predicate)
+    }
+    
     fn clear(&self) {
         self.clear()
     }
@@ -68,6 +133,10 @@ where
     fn cleanup(&self) -> usize {
         self.cleanup()
     }
+    
+    fn cleanup_report(&self) -> CleanupReport {
+        self.cleanup_report()
+    }
 }
 
 /// 为LruCache实现Cache特性
@@ -88,6 +157,10 @@ where
         self.remove(key)
     }
     
+    fn remove_where(&self, predicate: &dyn Fn(&K) -> bool) -> usize {
+        self.remove_where(predicate)
+    }
+    
     fn clear(&self) {
         self.clear()
     }
@@ -103,6 +176,10 @@ where
     fn cleanup(&self) -> usize {
         self.cleanup()
     }
+    
+    fn cleanup_report(&self) -> CleanupReport {
+        self.cleanup_report()
+    }
 }
 
 /// 缓存工厂，用于创建不同类型的缓存
@@ -142,4 +219,29 @@ impl CacheFactory {
         
         Box::new(cache)
     }
-} 
\ No newline at end of file
+
+} 
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_namespace_isolation() {
+        let cache = MemoryCache::<String, i32>::new();
+        cache.set(namespaced_key("a", "1"), 1);
+        cache.set(namespaced_key("a", "2"), 2);
+        cache.set(namespaced_key("a:b", "1"), 3);
+        cache.set(namespaced_key("a\\", "1"), 4);
+        cache.set("a-plain".to_string(), 5);
+
+        assert_eq!(namespaced_key("a:b", "1"), "a\\:b:1");
+        assert_eq!(Cache::invalidate_namespace(&cache, "a"), 2);
+        assert_eq!(cache.len(), 3);
+        assert_eq!(cache.get(&namespaced_key("a:b", "1")), Some(3));
+
+        assert_eq!(Cache::invalidate_namespace(&cache, "a:b"), 1);
+        assert_eq!(Cache::invalidate_namespace(&cache, "a\\"), 1);
+        assert_eq!(cache.get(&"a-plain".to_string()), Some(5));
+    }
+}
@@ -0,0 +1,184 @@
+use std::collections::HashMap;
+use serde::{Deserialize, Serialize};
+
+/// 绑定到缓存键模式的命名查询
+///
+/// 键模式由字面量和`{name}`形式的占位符组成，例如`user:{id}`。读取匹配的
+/// 键时，占位符的值按`params`的顺序绑定到SQL的`?`参数；未指定`params`时按
+/// 占位符在模式中出现的顺序绑定。
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct NamedQuery {
+    pub name: String,
+    pub key_pattern: String,
+    pub sql: String,
+    #[serde(default)]
+    pub params: Vec<String>,
+}
+
+/// 键模式中的片段
+#[derive(Debug, Clone, PartialEq)]
+enum Segment {
+    Literal(String),
+    Placeholder(String),
+}
+
+/// 解析后的键模式
+#[derive(Debug, Clone)]
+pub struct KeyPattern {
+    segments: Vec<Segment>,
+}
+
+impl KeyPattern {
+    /// 解析键模式，相邻的占位符之间必须有字面量分隔
+    pub fn parse(pattern: &str) -> Result<Self, String> {
+        let mut segments = Vec::new();
+        let mut rest = pattern;
+        while !rest.is_empty() {
+            match rest.find('{') {
+                Some(0) => {
+                    let end = rest.find('}')
+                        .ok_or_else(|| format!("Unclosed placeholder in key pattern '{}'", pattern))?;
+                    let name = rest[1..end].trim();
+                    if name.is_empty() {
+                        return Err(format!("Empty placeholder in key pattern '{}'", pattern));
+                    }
+                    if matches!(segments.last(), Some(Segment::Placeholder(_))) {
+                        return Err(format!("Adjacent placeholders in key pattern '{}' are ambiguous", pattern));
+                    }
+                    segments.push(Segment::Placeholder(name.to_string()));
+                    rest = &rest[end + 1..];
+                },
+                Some(start) => {
+                    segments.push(Segment::Literal(rest[..start].to_string()));
+                    rest = &rest[start..];
+                },
+                None => {
+                    segments.push(Segment::Literal(rest.to_string()));
+                    rest = "";
+                },
+            }
+        }
+        Ok(Self { segments })
+    }
+
+    /// 占位符名称，按出现顺序
+    pub fn placeholders(&self) -> Vec<String> {
+        self.segments.iter()
+            .filter_map(|s| match s {
+                Segment::Placeholder(name) => Some(name.clone()),
+                Segment::Literal(_) => None,
+            })
+            .collect()
+    }
+
+    /// 匹配缓存键，返回占位符的值；占位符匹配到下一个字面量为止，不能为空
+    pub fn matches(&self, key: &str) -> Option<HashMap<String, String>> {
+        let mut values = HashMap::new();
+        let mut rest = key;
+        let mut segments = self.segments.iter().peekable();
+        while let Some(segment) = segments.next() {
+            match segment {
+                Segment::Literal(literal) => rest = rest.strip_prefix(literal.as_str())?,
+                Segment::Placeholder(name) => {
+                    let end = match segments.peek() {
+                        Some(Segment::Literal(next)) => rest.find(next.as_str())?,
+                        _ => rest.len(),
+                    };
+                    if end == 0 {
+                        return None;
+                    }
+                    values.insert(name.clone(), rest[..end].to_string());
+                    rest = &rest[end..];
+                },
+            }
+        }
+        if rest.is_empty() { Some(values) } else { None }
+    }
+}
+
+/// 已注册的命名查询，按注册顺序匹配缓存键
+#[derive(Default)]
+pub struct NamedQueries {
+    queries: Vec<(KeyPattern, NamedQuery)>,
+}
+
+impl NamedQueries {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// 注册命名查询，同名的查询会被替换
+    pub fn register(&mut self, query: NamedQuery) -> Result<(), String> {
+        let pattern = KeyPattern::parse(&query.key_pattern)?;
+        let placeholders = pattern.placeholders();
+        if let Some(param) = query.params.iter().find(|p| !placeholders.contains(p)) {
+            return Err(format!("Parameter '{}' of query '{}' is not a placeholder in '{}'", param, query.name, query.key_pattern));
+        }
+        self.queries.retain(|(_, q)| q.name != query.name);
+        self.queries.push((pattern, query));
+        Ok(())
+    }
+
+    /// 移除命名查询
+    pub fn remove(&mut self, name: &str) -> bool {
+        let before = self.queries.len();
+        self.queries.retain(|(_, q)| q.name != name);
+        self.queries.len() != before
+    }
+
+    /// 已注册的命名查询
+    pub fn list(&self) -> Vec<NamedQuery> {
+        self.queries.iter().map(|(_, q)| q.clone()).collect()
+    }
+
+    /// 查找匹配缓存键的查询，返回查询和绑定的参数
+    pub fn resolve(&self, key: &str) -> Option<(NamedQuery, Vec<String>)> {
+        self.queries.iter().find_map(|(pattern, query)| {
+            let values = pattern.matches(key)?;
+            let names = if query.params.is_empty() { pattern.placeholders() } else { query.params.clone() };
+            let params = names.iter().map(|name| values[name].clone()).collect();
+            Some((query.clone(), params))
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_key_pattern() {
+        let pattern = KeyPattern::parse("order:{customer}:{id}").unwrap();
+        let values = pattern.matches("order:acme:42").unwrap();
+        assert_eq!((values["customer"].as_str(), values["id"].as_str()), ("acme", "42"));
+        assert!(pattern.matches("order:acme").is_none());
+        assert!(pattern.matches("order::42").is_none());
+        assert!(pattern.matches("user:acme:42").is_none());
+
+        assert!(KeyPattern::parse("user:{id").is_err());
+        assert!(KeyPattern::parse("user:{a}{b}").is_err());
+    }
+
+    #[test]
+    fn test_resolve_named_query() {
+        let mut queries = NamedQueries::new();
+        queries.register(NamedQuery {
+            name: "order".to_string(),
+            key_pattern: "order:{customer}:{id}".to_string(),
+            sql: "SELECT * FROM orders WHERE id = ? AND customer = ?".to_string(),
+            params: vec!["id".to_string(), "customer".to_string()],
+        }).unwrap();
+
+        let (query, params) = queries.resolve("order:acme:42").unwrap();
+        assert_eq!(query.name, "order");
+        assert_eq!(params, vec!["42".to_string(), "acme".to_string()]);
+        assert!(queries.resolve("user:1").is_none());
+
+        assert!(queries.register(NamedQuery {
+            name: "bad".to_string(),
+            key_pattern: "user:{id}".to_string(),
+            sql: "SELECT 1".to_string(),
+            params: vec!["name".to_string()],
+        }).is_err());
+    }
+}
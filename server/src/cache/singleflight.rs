@@ -0,0 +1,119 @@
+use std::collections::HashMap;
+use std::hash::Hash;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Condvar, Mutex};
+use log::debug;
+
+// 进行中调用的状态
+enum CallState<T> {
+    Pending,
+    Done(T),
+    // 执行者未产出结果（例如发生panic），等待者需要自行执行
+    Abandoned,
+}
+
+// 一次进行中的调用
+struct Call<T> {
+    state: Mutex<CallState<T>>,
+    done: Condvar,
+}
+
+/// 请求合并器：同一个键上的并发调用只执行一次，其余调用等待并共享结果
+pub struct SingleFlight<K, T>
+where
+    K: Eq + Hash + Clone + std::fmt::Debug,
+    T: Clone,
+{
+    calls: Mutex<HashMap<K, Arc<Call<T>>>>,
+    coalesced: AtomicU64,
+}
+
+impl<K, T> SingleFlight<K, T>
+where
+    K: Eq + Hash + Clone + std::fmt::Debug,
+    T: Clone,
+{
+    /// 创建新的请求合并器
+    pub fn new() -> Self {
+        Self {
+            calls: Mutex::new(HashMap::new()),
+            coalesced: AtomicU64::new(0),
+        }
+    }
+
+    /// 执行`f`，如果同一键已有调用在进行中，则等待并返回其结果
+    pub fn run<F>(&self, key: &K, f: F) -> T
+    where
+        F: FnOnce() -> T,
+    {
+        let (call, is_leader) = {
+            let mut calls = self.calls.lock().unwrap();
+            match calls.get(key) {
+                Some(call) => (call.clone(), false),
+                None => {
+                    let call = Arc::new(Call {
+                        state: Mutex::new(CallState::Pending),
+                        done: Condvar::new(),
+                    });
+                    calls.insert(key.clone(), call.clone());
+                    (call, true)
+                }
+            }
+        };
+
+        if !is_leader {
+            self.coalesced.fetch_add(1, Ordering::Relaxed);
+            debug!("Coalesced call for key: {:?}", key);
+
+            let mut state = call.state.lock().unwrap();
+            loop {
+                match &*state {
+                    CallState::Pending => state = call.done.wait(state).unwrap(),
+                    CallState::Done(value) => return value.clone(),
+                    CallState::Abandoned => break,
+                }
+            }
+            drop(state);
+            return f();
+        }
+
+        // 执行者退出时（包括panic）移除调用并唤醒等待者
+        let _guard = LeaderGuard { flight: self, key, call: &call };
+
+        let value = f();
+        *call.state.lock().unwrap() = CallState::Done(value.clone());
+        value
+    }
+
+    /// 获取被合并（未实际执行）的调用次数
+    pub fn coalesced_count(&self) -> u64 {
+        self.coalesced.load(Ordering::Relaxed)
+    }
+}
+
+// 执行者守卫
+struct LeaderGuard<'a, K, T>
+where
+    K: Eq + Hash + Clone + std::fmt::Debug,
+    T: Clone,
+{
+    flight: &'a SingleFlight<K, T>,
+    key: &'a K,
+    call: &'a Arc<Call<T>>,
+}
+
+impl<'a, K, T> Drop for LeaderGuard<'a, K, T>
+where
+    K: Eq + Hash + Clone + std::fmt::Debug,
+    T: Clone,
+{
+    fn drop(&mut self) {
+        self.flight.calls.lock().unwrap().remove(self.key);
+
+        let mut state = self.call.state.lock().unwrap_or_else(|e| e.into_inner());
+        if let CallState::Pending = *state {
+            *state = CallState::Abandoned;
+        }
+        self.call.done.notify_all();
+    }
+}
@@ -1,5 +1,9 @@
 use std::env;
-use log::info;
+use log::{info, error};
+use lumos_core::duckdb::extensions::DEFAULT_ALLOWED_EXTENSIONS;
+use lumos_core::query::pinned::DEFAULT_MAX_PINNED_ROWS;
+use crate::utils::retention::RetentionConfig;
+use crate::utils::error_budget::DEFAULT_TARGET as DEFAULT_SLO_TARGET;
 
 /// 服务器配置
 #[derive(Debug, Clone)]
@@ -14,8 +18,89 @@ pub struct ServerConfig {
     pub vector_db_path: String,
     /// API密钥
     pub api_key: Option<String>,
+    /// 应用API密钥，可以读写但不具有管理员权限
+    pub app_api_keys: Vec<String>,
+    /// 只读API密钥，只能执行读操作
+    pub read_only_api_keys: Vec<String>,
+    /// 实例级只读模式
+    pub read_only: bool,
     /// 日志级别
     pub log_level: String,
+    /// 内存预算占系统内存的百分比，查询结果、结果缓存和常驻向量集合共用，未设置时不限制
+    pub max_memory_percent: Option<u8>,
+    /// 数据库所在磁盘的保留空间（MB），剩余空间低于该值时拒绝写入，未设置时不检查
+    pub disk_reserve_mb: Option<u64>,
+    /// 内存即将耗尽时查询最多返回的行数，0表示不限制
+    pub degraded_row_limit: usize,
+    /// 单个向量集合的内存上限（MB），未设置时不限制
+    pub vector_collection_cap_mb: Option<usize>,
+    /// 常驻内存的向量集合总上限（MB），超过时卸载最久未使用的集合，未设置时不限制
+    pub vector_resident_cap_mb: Option<usize>,
+    /// 同时执行的查询上限，未设置时不限制
+    pub max_concurrent_queries: Option<usize>,
+    /// 超过并发上限时最多排队的查询数
+    pub query_queue_size: usize,
+    /// 严格参数绑定模式，拒绝非管理员密钥提交的内联字符串字面量，包括不对应
+    /// 表或列、会被SQLite当作字符串的双引号名称
+    pub strict_parameter_binding: bool,
+    /// 审批模式，非管理员密钥提交的破坏性操作需要管理员批准后执行
+    pub approval_mode: bool,
+    /// 负载异常检测灵敏度（标准差倍数），未设置时不检测
+    pub anomaly_sensitivity: Option<f64>,
+    /// 负载异常检测的统计窗口（秒）
+    pub anomaly_window_secs: u64,
+    /// 预热查询文件，每行一条查询
+    pub warmup_queries_file: Option<String>,
+    /// 热点查询快照文件，启动时预热，停止时更新
+    pub hot_query_snapshot: Option<String>,
+    /// 启动时预先加载的向量集合
+    pub warmup_collections: Vec<String>,
+    /// 数据库快照目录，设置后支持按时间点（AS OF）查询
+    pub snapshot_dir: Option<String>,
+    /// 保留的快照数量
+    pub snapshot_retention: usize,
+    /// 自动快照间隔（秒），未设置时只能通过API手动快照
+    pub snapshot_interval_secs: Option<u64>,
+    /// 允许安装和加载的DuckDB扩展，`name=sha256`形式时校验扩展文件
+    pub duckdb_extension_allowlist: Vec<String>,
+    /// 启动时安装的DuckDB扩展
+    pub duckdb_extensions: Vec<String>,
+    /// 向量复制的目标实例地址，未设置时不推送
+    pub replication_target: Option<String>,
+    /// 推送到目标实例时使用的API密钥，需要是目标实例的管理员密钥
+    pub replication_api_key: Option<String>,
+    /// 复制的向量集合，`*`表示所有集合
+    pub replication_collections: Vec<String>,
+    /// 推送变更的间隔（毫秒）
+    pub replication_interval_ms: u64,
+    /// 实例启动时的复制角色，`primary`或`replica`
+    pub replication_role: String,
+    /// 查询审计抽样比例（0到1），未设置时不抽样
+    pub audit_sample_rate: Option<f64>,
+    /// 查询审计库路径
+    pub audit_db_path: String,
+    /// 审计记录、查询统计和使用历史的保留策略
+    pub retention: RetentionConfig,
+    /// 计算错误预算使用的可用性目标（0到1）
+    pub slo_target: f64,
+    /// 异步查询任务结果的保存目录，未设置时不启用异步查询
+    pub query_job_dir: Option<String>,
+    /// 异步查询任务结果的保留时间（秒）
+    pub query_job_retention_secs: u64,
+    /// 是否允许异步查询任务回调内网、回环和链路本地地址
+    pub query_job_private_callbacks: bool,
+    /// 服务的外部访问地址，用于回调中的结果地址
+    pub public_url: Option<String>,
+    /// 查询结果缓存时间（秒），设置后`/db/query`的读查询使用结果缓存，未设置时不缓存
+    pub query_cache_ttl_secs: Option<u64>,
+    /// 后台清理过期查询结果的间隔（秒）
+    pub cache_cleanup_interval_secs: u64,
+    /// 允许导入的数据库文件目录，设置后`/db/import`只接受该目录内的文件
+    pub import_dir: Option<String>,
+    /// 启动时固定在内存中的表，分析查询读取其DuckDB副本
+    pub pinned_tables: Vec<String>,
+    /// 固定表的最大行数
+    pub pinned_max_rows: usize,
 }
 
 impl Default for ServerConfig {
@@ -26,7 +111,47 @@ impl Default for ServerConfig {
             db_path: "lumos.db".to_string(),
             vector_db_path: "lumos_vector.db".to_string(),
             api_key: None,
+            app_api_keys: Vec::new(),
+            read_only_api_keys: Vec::new(),
+            read_only: false,
             log_level: "info".to_string(),
+            max_memory_percent: None,
+            disk_reserve_mb: None,
+            degraded_row_limit: 10_000,
+            vector_collection_cap_mb: None,
+            vector_resident_cap_mb: None,
+            max_concurrent_queries: None,
+            query_queue_size: 100,
+            strict_parameter_binding: false,
+            approval_mode: false,
+            anomaly_sensitivity: None,
+            anomaly_window_secs: 60,
+            warmup_queries_file: None,
+            hot_query_snapshot: None,
+            warmup_collections: Vec::new(),
+            snapshot_dir: None,
+            snapshot_retention: 24,
+            snapshot_interval_secs: None,
+            duckdb_extension_allowlist: DEFAULT_ALLOWED_EXTENSIONS.iter().map(|e| e.to_string()).collect(),
+            duckdb_extensions: Vec::new(),
+            replication_target: None,
+            replication_api_key: None,
+            replication_collections: Vec::new(),
+            replication_interval_ms: 1000,
+            replication_role: "primary".to_string(),
+            audit_sample_rate: None,
+            audit_db_path: "lumos_audit.db".to_string(),
+            retention: RetentionConfig::default(),
+            slo_target: DEFAULT_SLO_TARGET,
+            query_job_dir: None,
+            query_job_retention_secs: 86_400,
+            query_job_private_callbacks: false,
+            public_url: None,
+            query_cache_ttl_secs: None,
+            cache_cleanup_interval_secs: 60,
+            import_dir: None,
+            pinned_tables: Vec::new(),
+            pinned_max_rows: DEFAULT_MAX_PINNED_ROWS,
         }
     }
 }
@@ -47,7 +172,181 @@ impl ServerConfig {
         let vector_db_path = env::var("LUMOS_VECTOR_DB_PATH").unwrap_or_else(|_| "lumos_vector.db".to_string());
         
         let api_key = env::var("LUMOS_API_KEY").ok();
+        let app_api_keys = env::var("LUMOS_APP_API_KEYS")
+            .map(|keys| {
+                keys.split(',')
+                    .map(|k| k.trim().to_string())
+                    .filter(|k| !k.is_empty())
+                    .collect()
+            })
+            .unwrap_or_default();
+        let read_only_api_keys = env::var("LUMOS_READ_ONLY_API_KEYS")
+            .map(|keys| {
+                keys.split(',')
+                    .map(|k| k.trim().to_string())
+                    .filter(|k| !k.is_empty())
+                    .collect()
+            })
+            .unwrap_or_default();
+        let read_only = env::var("LUMOS_READ_ONLY")
+            .map(|v| v == "1" || v.eq_ignore_ascii_case("true"))
+            .unwrap_or(false);
         let log_level = env::var("LUMOS_LOG_LEVEL").unwrap_or_else(|_| "info".to_string());
+        let max_memory_percent = env::var("LUMOS_MAX_MEMORY_PERCENT")
+            .ok()
+            .and_then(|p| p.parse::<u8>().ok())
+            .filter(|p| *p > 0 && *p <= 100);
+        let disk_reserve_mb = env::var("LUMOS_DISK_RESERVE_MB")
+            .ok()
+            .and_then(|mb| mb.parse::<u64>().ok());
+        let degraded_row_limit = env::var("LUMOS_DEGRADED_ROW_LIMIT")
+            .ok()
+            .and_then(|rows| rows.parse::<usize>().ok())
+            .unwrap_or(10_000);
+        let vector_collection_cap_mb = env::var("LUMOS_VECTOR_COLLECTION_CAP_MB")
+            .ok()
+            .and_then(|mb| mb.parse::<usize>().ok());
+        let vector_resident_cap_mb = env::var("LUMOS_VECTOR_RESIDENT_CAP_MB")
+            .ok()
+            .and_then(|mb| mb.parse::<usize>().ok());
+        let max_concurrent_queries = env::var("LUMOS_MAX_CONCURRENT_QUERIES")
+            .ok()
+            .and_then(|n| n.parse::<usize>().ok())
+            .filter(|n| *n > 0);
+        let query_queue_size = env::var("LUMOS_QUERY_QUEUE_SIZE")
+            .ok()
+            .and_then(|n| n.parse::<usize>().ok())
+            .unwrap_or(100);
+        let strict_parameter_binding = env::var("LUMOS_STRICT_PARAMETER_BINDING")
+            .map(|v| v == "1" || v.eq_ignore_ascii_case("true"))
+            .unwrap_or(false);
+        let approval_mode = env::var("LUMOS_APPROVAL_MODE")
+            .map(|v| v == "1" || v.eq_ignore_ascii_case("true"))
+            .unwrap_or(false);
+        let anomaly_sensitivity = env::var("LUMOS_ANOMALY_SENSITIVITY")
+            .ok()
+            .and_then(|s| s.parse::<f64>().ok())
+            .filter(|s| *s > 0.0);
+        let anomaly_window_secs = env::var("LUMOS_ANOMALY_WINDOW_SECS")
+            .ok()
+            .and_then(|s| s.parse::<u64>().ok())
+            .filter(|s| *s > 0)
+            .unwrap_or(60);
+        let warmup_queries_file = env::var("LUMOS_WARMUP_QUERIES_FILE").ok();
+        let hot_query_snapshot = env::var("LUMOS_HOT_QUERY_SNAPSHOT").ok();
+        let warmup_collections = env::var("LUMOS_WARMUP_COLLECTIONS")
+            .map(|names| {
+                names.split(',')
+                    .map(|n| n.trim().to_string())
+                    .filter(|n| !n.is_empty())
+                    .collect()
+            })
+            .unwrap_or_default();
+        let snapshot_dir = env::var("LUMOS_SNAPSHOT_DIR").ok();
+        let snapshot_retention = env::var("LUMOS_SNAPSHOT_RETENTION")
+            .ok()
+            .and_then(|n| n.parse::<usize>().ok())
+            .filter(|n| *n > 0)
+            .unwrap_or(24);
+        let snapshot_interval_secs = env::var("LUMOS_SNAPSHOT_INTERVAL_SECS")
+            .ok()
+            .and_then(|s| s.parse::<u64>().ok())
+            .filter(|s| *s > 0);
+        let duckdb_extension_allowlist = env::var("LUMOS_DUCKDB_EXTENSION_ALLOWLIST")
+            .map(|entries| {
+                entries.split(',')
+                    .map(|e| e.trim().to_string())
+                    .filter(|e| !e.is_empty())
+                    .collect()
+            })
+            .unwrap_or_else(|_| DEFAULT_ALLOWED_EXTENSIONS.iter().map(|e| e.to_string()).collect());
+        let duckdb_extensions = env::var("LUMOS_DUCKDB_EXTENSIONS")
+            .map(|names| {
+                names.split(',')
+                    .map(|n| n.trim().to_string())
+                    .filter(|n| !n.is_empty())
+                    .collect()
+            })
+            .unwrap_or_default();
+        let replication_target = env::var("LUMOS_REPLICATION_TARGET").ok();
+        let replication_api_key = env::var("LUMOS_REPLICATION_API_KEY").ok();
+        let replication_collections = env::var("LUMOS_REPLICATION_COLLECTIONS")
+            .map(|names| {
+                names.split(',')
+                    .map(|n| n.trim().to_string())
+                    .filter(|n| !n.is_empty())
+                    .collect()
+            })
+            .unwrap_or_else(|_| vec!["*".to_string()]);
+        let replication_interval_ms = env::var("LUMOS_REPLICATION_INTERVAL_MS")
+            .ok()
+            .and_then(|ms| ms.parse::<u64>().ok())
+            .filter(|ms| *ms > 0)
+            .unwrap_or(1000);
+        let replication_role = env::var("LUMOS_REPLICATION_ROLE").unwrap_or_else(|_| "primary".to_string());
+        let audit_sample_rate = env::var("LUMOS_AUDIT_SAMPLE_RATE")
+            .ok()
+            .and_then(|rate| rate.parse::<f64>().ok())
+            .filter(|rate| *rate > 0.0 && *rate <= 1.0);
+        let audit_db_path = env::var("LUMOS_AUDIT_DB_PATH").unwrap_or_else(|_| "lumos_audit.db".to_string());
+        let mut retention = RetentionConfig::default();
+        // 旧的审计记录数设置，LUMOS_RETENTION_AUDIT中的entries优先
+        if let Some(entries) = env::var("LUMOS_AUDIT_MAX_ENTRIES").ok().and_then(|s| s.parse::<usize>().ok()) {
+            retention.audit.max_entries = Some(entries);
+        }
+        for (name, rule) in [
+            ("LUMOS_RETENTION_AUDIT", &mut retention.audit),
+            ("LUMOS_RETENTION_SLOW_QUERIES", &mut retention.slow_queries),
+            ("LUMOS_RETENTION_USAGE", &mut retention.usage),
+        ] {
+            if let Ok(spec) = env::var(name) {
+                match rule.apply(&spec) {
+                    Ok(parsed) => *rule = parsed,
+                    Err(e) => error!("Ignoring {}: {}", name, e),
+                }
+            }
+        }
+        if let Some(secs) = env::var("LUMOS_RETENTION_INTERVAL_SECS").ok().and_then(|s| s.parse::<u64>().ok()).filter(|s| *s > 0) {
+            retention.interval = std::time::Duration::from_secs(secs);
+        }
+        let slo_target = env::var("LUMOS_SLO_TARGET")
+            .ok()
+            .and_then(|t| t.parse::<f64>().ok())
+            .filter(|t| *t > 0.0 && *t < 1.0)
+            .unwrap_or(DEFAULT_SLO_TARGET);
+        let query_job_dir = env::var("LUMOS_QUERY_JOB_DIR").ok();
+        let query_job_retention_secs = env::var("LUMOS_QUERY_JOB_RETENTION_SECS")
+            .ok()
+            .and_then(|s| s.parse::<u64>().ok())
+            .filter(|s| *s > 0)
+            .unwrap_or(86_400);
+        let query_job_private_callbacks = env::var("LUMOS_QUERY_JOB_PRIVATE_CALLBACKS")
+            .map(|v| v == "1" || v.eq_ignore_ascii_case("true"))
+            .unwrap_or(false);
+        let public_url = env::var("LUMOS_PUBLIC_URL").ok();
+        let query_cache_ttl_secs = env::var("LUMOS_QUERY_CACHE_TTL_SECS")
+            .ok()
+            .and_then(|s| s.parse::<u64>().ok())
+            .filter(|s| *s > 0);
+        let cache_cleanup_interval_secs = env::var("LUMOS_CACHE_CLEANUP_INTERVAL_SECS")
+            .ok()
+            .and_then(|s| s.parse::<u64>().ok())
+            .filter(|s| *s > 0)
+            .unwrap_or(60);
+        let import_dir = env::var("LUMOS_IMPORT_DIR").ok();
+        let pinned_tables = env::var("LUMOS_PINNED_TABLES")
+            .map(|names| {
+                names.split(',')
+                    .map(|n| n.trim().to_string())
+                    .filter(|n| !n.is_empty())
+                    .collect()
+            })
+            .unwrap_or_default();
+        let pinned_max_rows = env::var("LUMOS_PINNED_MAX_ROWS")
+            .ok()
+            .and_then(|n| n.parse::<usize>().ok())
+            .filter(|n| *n > 0)
+            .unwrap_or(DEFAULT_MAX_PINNED_ROWS);
         
         info!("Loaded configuration from environment");
         
@@ -57,7 +356,47 @@ impl ServerConfig {
             db_path,
             vector_db_path,
             api_key,
+            app_api_keys,
+            read_only_api_keys,
+            read_only,
             log_level,
+            max_memory_percent,
+            disk_reserve_mb,
+            degraded_row_limit,
+            vector_collection_cap_mb,
+            vector_resident_cap_mb,
+            max_concurrent_queries,
+            query_queue_size,
+            strict_parameter_binding,
+            approval_mode,
+            anomaly_sensitivity,
+            anomaly_window_secs,
+            warmup_queries_file,
+            hot_query_snapshot,
+            warmup_collections,
+            snapshot_dir,
+            snapshot_retention,
+            snapshot_interval_secs,
+            duckdb_extension_allowlist,
+            duckdb_extensions,
+            replication_target,
+            replication_api_key,
+            replication_collections,
+            replication_interval_ms,
+            replication_role,
+            audit_sample_rate,
+            audit_db_path,
+            retention,
+            slo_target,
+            query_job_dir,
+            query_job_retention_secs,
+            query_job_private_callbacks,
+            public_url,
+            query_cache_ttl_secs,
+            cache_cleanup_interval_secs,
+            import_dir,
+            pinned_tables,
+            pinned_max_rows,
         }
     }
     
@@ -79,6 +418,219 @@ impl ServerConfig {
         self
     }
     
+    /// 设置应用API密钥
+    pub fn with_app_api_keys(mut self, keys: Vec<String>) -> Self {
+        self.app_api_keys = keys;
+        self
+    }
+    
+    /// 设置严格参数绑定模式
+    pub fn with_strict_parameter_binding(mut self, strict: bool) -> Self {
+        self.strict_parameter_binding = strict;
+        self
+    }
+    
+    /// 设置审批模式
+    pub fn with_approval_mode(mut self, enabled: bool) -> Self {
+        self.approval_mode = enabled;
+        self
+    }
+    
+    /// 启用负载异常检测，偏离基线超过`sensitivity`个标准差时告警
+    pub fn with_anomaly_sensitivity(mut self, sensitivity: f64) -> Self {
+        self.anomaly_sensitivity = Some(sensitivity);
+        self
+    }
+    
+    /// 设置负载异常检测的统计窗口（秒）
+    pub fn with_anomaly_window_secs(mut self, secs: u64) -> Self {
+        self.anomaly_window_secs = secs;
+        self
+    }
+    
+    /// 设置预热查询文件
+    pub fn with_warmup_queries_file(mut self, path: impl Into<String>) -> Self {
+        self.warmup_queries_file = Some(path.into());
+        self
+    }
+    
+    /// 设置热点查询快照文件
+    pub fn with_hot_query_snapshot(mut self, path: impl Into<String>) -> Self {
+        self.hot_query_snapshot = Some(path.into());
+        self
+    }
+    
+    /// 设置启动时预先加载的向量集合
+    pub fn with_warmup_collections(mut self, names: Vec<String>) -> Self {
+        self.warmup_collections = names;
+        self
+    }
+    
+    /// 设置数据库快照目录
+    pub fn with_snapshot_dir(mut self, dir: impl Into<String>) -> Self {
+        self.snapshot_dir = Some(dir.into());
+        self
+    }
+    
+    /// 设置保留的快照数量
+    pub fn with_snapshot_retention(mut self, retain: usize) -> Self {
+        self.snapshot_retention = retain;
+        self
+    }
+    
+    /// 设置自动快照间隔（秒）
+    pub fn with_snapshot_interval_secs(mut self, secs: u64) -> Self {
+        self.snapshot_interval_secs = Some(secs);
+        self
+    }
+    
+    /// 设置允许的DuckDB扩展
+    pub fn with_duckdb_extension_allowlist(mut self, entries: Vec<String>) -> Self {
+        self.duckdb_extension_allowlist = entries;
+        self
+    }
+    
+    /// 设置启动时安装的DuckDB扩展
+    pub fn with_duckdb_extensions(mut self, names: Vec<String>) -> Self {
+        self.duckdb_extensions = names;
+        self
+    }
+    
+    /// 设置向量复制的目标实例和复制的集合
+    pub fn with_replication_target(mut self, target: impl Into<String>, collections: Vec<String>) -> Self {
+        self.replication_target = Some(target.into());
+        self.replication_collections = collections;
+        self
+    }
+    
+    /// 设置推送到目标实例时使用的API密钥
+    pub fn with_replication_api_key(mut self, key: impl Into<String>) -> Self {
+        self.replication_api_key = Some(key.into());
+        self
+    }
+    
+    /// 设置推送变更的间隔（毫秒）
+    pub fn with_replication_interval_ms(mut self, ms: u64) -> Self {
+        self.replication_interval_ms = ms;
+        self
+    }
+    
+    /// 设置实例启动时的复制角色
+    pub fn with_replication_role(mut self, role: impl Into<String>) -> Self {
+        self.replication_role = role.into();
+        self
+    }
+    
+    /// 设置查询审计抽样比例和审计库路径
+    pub fn with_query_audit(mut self, sample_rate: f64, db_path: impl Into<String>) -> Self {
+        self.audit_sample_rate = Some(sample_rate);
+        self.audit_db_path = db_path.into();
+        self
+    }
+    
+    /// 设置审计记录、查询统计和使用历史的保留策略
+    pub fn with_retention(mut self, retention: RetentionConfig) -> Self {
+        self.retention = retention;
+        self
+    }
+    
+    /// 设置计算错误预算使用的可用性目标
+    pub fn with_slo_target(mut self, target: f64) -> Self {
+        self.slo_target = target;
+        self
+    }
+    
+    /// 启用异步查询任务，结果保存在`dir`中
+    pub fn with_query_jobs(mut self, dir: impl Into<String>, retention_secs: u64) -> Self {
+        self.query_job_dir = Some(dir.into());
+        self.query_job_retention_secs = retention_secs;
+        self
+    }
+    
+    /// 允许异步查询任务回调内网、回环和链路本地地址
+    pub fn with_query_job_private_callbacks(mut self, allow: bool) -> Self {
+        self.query_job_private_callbacks = allow;
+        self
+    }
+    
+    /// 设置服务的外部访问地址
+    pub fn with_public_url(mut self, url: impl Into<String>) -> Self {
+        self.public_url = Some(url.into());
+        self
+    }
+    
+    /// 设置查询结果缓存时间，`/db/query`的读查询使用结果缓存
+    pub fn with_query_cache_ttl_secs(mut self, ttl_secs: u64) -> Self {
+        self.query_cache_ttl_secs = Some(ttl_secs);
+        self
+    }
+    
+    /// 设置后台清理过期查询结果的间隔
+    pub fn with_cache_cleanup_interval_secs(mut self, interval_secs: u64) -> Self {
+        self.cache_cleanup_interval_secs = interval_secs.max(1);
+        self
+    }
+    
+    /// 限制数据库文件导入只能读取`dir`内的文件
+    pub fn with_import_dir(mut self, dir: impl Into<String>) -> Self {
+        self.import_dir = Some(dir.into());
+        self
+    }
+    
+    /// 设置启动时固定在内存中的表
+    pub fn with_pinned_tables(mut self, tables: Vec<String>) -> Self {
+        self.pinned_tables = tables;
+        self
+    }
+    
+    /// 设置固定表的最大行数
+    pub fn with_pinned_max_rows(mut self, rows: usize) -> Self {
+        self.pinned_max_rows = rows;
+        self
+    }
+    
+    /// 设置只读API密钥
+    pub fn with_read_only_api_keys(mut self, keys: Vec<String>) -> Self {
+        self.read_only_api_keys = keys;
+        self
+    }
+    
+    /// 设置实例级只读模式
+    pub fn with_read_only(mut self, read_only: bool) -> Self {
+        self.read_only = read_only;
+        self
+    }
+    
+    /// 设置内存预算占系统内存的百分比
+    pub fn with_max_memory_percent(mut self, percent: u8) -> Self {
+        self.max_memory_percent = Some(percent);
+        self
+    }
+    
+    /// 设置磁盘保留空间（MB）
+    pub fn with_disk_reserve_mb(mut self, reserve_mb: u64) -> Self {
+        self.disk_reserve_mb = Some(reserve_mb);
+        self
+    }
+    
+    /// 设置内存即将耗尽时查询最多返回的行数，0表示不限制
+    pub fn with_degraded_row_limit(mut self, rows: usize) -> Self {
+        self.degraded_row_limit = rows;
+        self
+    }
+    
+    /// 设置同时执行的查询上限
+    pub fn with_max_concurrent_queries(mut self, limit: usize) -> Self {
+        self.max_concurrent_queries = Some(limit);
+        self
+    }
+    
+    /// 设置超过并发上限时最多排队的查询数
+    pub fn with_query_queue_size(mut self, size: usize) -> Self {
+        self.query_queue_size = size;
+        self
+    }
+    
     /// 设置主机地址
     pub fn with_host(mut self, host: impl Into<String>) -> Self {
         self.host = host.into();
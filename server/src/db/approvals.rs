@@ -0,0 +1,284 @@
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::Duration;
+use chrono::{DateTime, SecondsFormat, Utc};
+use log::info;
+use serde::Serialize;
+
+use lumos_core::query::lexer::{tokenize, Token};
+
+/// 已处理的操作默认保留时间
+pub const DEFAULT_RETENTION: Duration = Duration::from_secs(7 * 24 * 3600);
+
+/// 待审批的破坏性操作
+#[derive(Debug, Clone, Serialize)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum Operation {
+    /// DROP、TRUNCATE或不带WHERE的DELETE语句
+    Sql { sql: String, params: Vec<String> },
+    /// 删除向量集合
+    DeleteCollection { name: String },
+    /// 停用表的行历史记录并删除历史表
+    DropHistory { table: String },
+}
+
+impl Operation {
+    /// 操作是否需要管理员审批
+    pub fn is_destructive(&self) -> bool {
+        match self {
+            Operation::Sql { sql, .. } => is_destructive_sql(sql),
+            Operation::DeleteCollection { .. } | Operation::DropHistory { .. } => true,
+        }
+    }
+}
+
+/// 审批状态
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ApprovalStatus {
+    Pending,
+    /// 已批准，正在执行
+    Approved,
+    Executed,
+    /// 已批准但执行失败
+    Failed,
+    Rejected,
+}
+
+/// 审批错误
+#[derive(Debug, thiserror::Error)]
+pub enum ApprovalError {
+    #[error("Pending operation '{0}' not found")]
+    NotFound(String),
+    #[error("Operation '{0}' is no longer pending")]
+    NotPending(String),
+}
+
+/// 等待管理员审批的操作
+#[derive(Debug, Clone, Serialize)]
+pub struct PendingOperation {
+    pub id: String,
+    pub operation: Operation,
+    pub status: ApprovalStatus,
+    /// 提交操作的密钥标识
+    pub submitted_by: String,
+    pub submitted_at: String,
+    /// 批准或拒绝操作的密钥标识
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub decided_by: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub decided_at: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    #[serde(skip)]
+    decided: Option<DateTime<Utc>>,
+}
+
+/// 破坏性操作的审批队列
+///
+/// 启用审批模式后，非管理员密钥提交的DROP、TRUNCATE、不带WHERE的DELETE、
+/// 向量集合删除和历史表删除不会立即执行，而是加入队列，由管理员通过API批准或拒绝。批准时
+/// 先把状态改为`approved`，同一操作不会被执行两次。队列只保存在内存中，重启后
+/// 未处理的操作需要重新提交；已处理的操作在保留时间后删除。
+pub struct ApprovalQueue {
+    retention: Duration,
+    operations: Mutex<HashMap<String, PendingOperation>>,
+}
+
+impl ApprovalQueue {
+    /// 创建审批队列
+    pub fn new() -> Self {
+        Self {
+            retention: DEFAULT_RETENTION,
+            operations: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// 设置已处理操作的保留时间
+    pub fn with_retention(mut self, retention: Duration) -> Self {
+        self.retention = retention;
+        self
+    }
+
+    /// 提交待审批的操作
+    pub fn submit(&self, operation: Operation, submitted_by: &str) -> PendingOperation {
+        self.prune();
+
+        let pending = PendingOperation {
+            id: uuid::Uuid::new_v4().to_string(),
+            operation,
+            status: ApprovalStatus::Pending,
+            submitted_by: submitted_by.to_string(),
+            submitted_at: Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true),
+            decided_by: None,
+            decided_at: None,
+            error: None,
+            decided: None,
+        };
+        self.operations.lock().unwrap().insert(pending.id.clone(), pending.clone());
+        info!("Operation {} from {} is waiting for admin approval", pending.id, submitted_by);
+        pending
+    }
+
+    /// 按提交时间列出所有操作
+    pub fn list(&self) -> Vec<PendingOperation> {
+        let mut operations: Vec<_> = self.operations.lock().unwrap().values().cloned().collect();
+        operations.sort_by(|a, b| a.submitted_at.cmp(&b.submitted_at));
+        operations
+    }
+
+    /// 获取操作
+    pub fn get(&self, id: &str) -> Option<PendingOperation> {
+        self.operations.lock().unwrap().get(id).cloned()
+    }
+
+    /// 批准操作，返回需要执行的操作，执行后调用`finish`
+    pub fn approve(&self, id: &str, decided_by: &str) -> Result<PendingOperation, ApprovalError> {
+        self.decide(id, decided_by, ApprovalStatus::Approved)
+    }
+
+    /// 拒绝操作
+    pub fn reject(&self, id: &str, decided_by: &str) -> Result<PendingOperation, ApprovalError> {
+        self.decide(id, decided_by, ApprovalStatus::Rejected)
+    }
+
+    /// 记录已批准操作的执行结果
+    pub fn finish(&self, id: &str, result: Result<(), String>) -> Option<PendingOperation> {
+        let mut operations = self.operations.lock().unwrap();
+        let pending = operations.get_mut(id)?;
+        match result {
+            Ok(()) => pending.status = ApprovalStatus::Executed,
+            Err(e) => {
+                pending.status = ApprovalStatus::Failed;
+                pending.error = Some(e);
+            }
+        }
+        Some(pending.clone())
+    }
+
+    fn decide(&self, id: &str, decided_by: &str, status: ApprovalStatus) -> Result<PendingOperation, ApprovalError> {
+        let mut operations = self.operations.lock().unwrap();
+        let pending = operations.get_mut(id).ok_or_else(|| ApprovalError::NotFound(id.to_string()))?;
+        if pending.status != ApprovalStatus::Pending {
+            return Err(ApprovalError::NotPending(id.to_string()));
+        }
+
+        let decided = Utc::now();
+        pending.status = status;
+        pending.decided_by = Some(decided_by.to_string());
+        pending.decided_at = Some(decided.to_rfc3339_opts(SecondsFormat::Millis, true));
+        pending.decided = Some(decided);
+        info!("Operation {} was {} by {}", id, if status == ApprovalStatus::Rejected { "rejected" } else { "approved" }, decided_by);
+        Ok(pending.clone())
+    }
+
+    // 删除超过保留时间的已处理操作，待审批的操作一直保留
+    fn prune(&self) {
+        let retention = chrono::Duration::from_std(self.retention).unwrap_or_else(|_| chrono::Duration::max_value());
+        let cutoff = Utc::now() - retention;
+        self.operations.lock().unwrap().retain(|_, pending| match pending.decided {
+            Some(decided) => decided > cutoff,
+            None => true,
+        });
+    }
+}
+
+impl Default for ApprovalQueue {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// 判断SQL是否包含需要审批的破坏性语句
+///
+/// DROP、TRUNCATE、ALTER ... DROP和不带WHERE的DELETE（包括`WITH ... DELETE`）
+/// 视为破坏性语句。多条语句中任意一条是破坏性语句时整体需要审批。
+pub fn is_destructive_sql(sql: &str) -> bool {
+    let tokens = tokenize(sql);
+    tokens
+        .split(|token| *token == Token::Symbol(';'))
+        .any(is_destructive_statement)
+}
+
+fn is_destructive_statement(tokens: &[Token]) -> bool {
+    let first = match tokens.first() {
+        Some(first) => first,
+        None => return false,
+    };
+    if first.is_keyword("DROP") || first.is_keyword("TRUNCATE") {
+        return true;
+    }
+    if first.is_keyword("ALTER") {
+        return tokens.iter().any(|token| token.is_keyword("DROP"));
+    }
+
+    // 只看括号外的关键字，子查询中的WHERE不限制DELETE的范围
+    let mut depth = 0usize;
+    let mut deleting = false;
+    for token in tokens {
+        match token {
+            Token::Symbol('(') => depth += 1,
+            Token::Symbol(')') => depth = depth.saturating_sub(1),
+            _ if depth > 0 => {}
+            _ if token.is_keyword("DELETE") => deleting = true,
+            _ if deleting && token.is_keyword("WHERE") => return false,
+            _ => {}
+        }
+    }
+    deleting
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_destructive_statements() {
+        assert!(is_destructive_sql("DROP TABLE users"));
+        assert!(is_destructive_sql("drop index idx_users_email"));
+        assert!(is_destructive_sql("TRUNCATE TABLE users"));
+        assert!(is_destructive_sql("ALTER TABLE users DROP COLUMN email"));
+        assert!(is_destructive_sql("DELETE FROM users"));
+        assert!(is_destructive_sql("DELETE FROM users -- WHERE id = 1"));
+        assert!(is_destructive_sql("WITH old AS (SELECT id FROM users WHERE id < 10) DELETE FROM users"));
+        assert!(is_destructive_sql("SELECT 1; DROP TABLE users"));
+    }
+
+    #[test]
+    fn test_non_destructive_statements() {
+        assert!(!is_destructive_sql("DELETE FROM users WHERE id = ?"));
+        assert!(!is_destructive_sql("SELECT * FROM users WHERE note = 'DROP TABLE users'"));
+        assert!(!is_destructive_sql("UPDATE users SET name = 'x' WHERE id = 1"));
+        assert!(!is_destructive_sql("ALTER TABLE users ADD COLUMN email TEXT"));
+        assert!(!is_destructive_sql("SELECT \"delete\" FROM audit"));
+        assert!(!is_destructive_sql(""));
+    }
+
+    #[test]
+    fn test_approval_runs_once() {
+        let queue = ApprovalQueue::new();
+        let pending = queue.submit(Operation::DeleteCollection { name: "docs".to_string() }, "app-key");
+        assert_eq!(pending.status, ApprovalStatus::Pending);
+
+        let approved = queue.approve(&pending.id, "admin").unwrap();
+        assert_eq!(approved.status, ApprovalStatus::Approved);
+        assert!(matches!(queue.approve(&pending.id, "admin"), Err(ApprovalError::NotPending(_))));
+        assert!(matches!(queue.reject(&pending.id, "admin"), Err(ApprovalError::NotPending(_))));
+
+        let finished = queue.finish(&pending.id, Err("no such collection".to_string())).unwrap();
+        assert_eq!(finished.status, ApprovalStatus::Failed);
+        assert_eq!(finished.error.as_deref(), Some("no such collection"));
+    }
+
+    #[test]
+    fn test_history_drop_is_destructive() {
+        assert!(Operation::DropHistory { table: "users".to_string() }.is_destructive());
+        assert!(Operation::DeleteCollection { name: "docs".to_string() }.is_destructive());
+    }
+
+    #[test]
+    fn test_reject_unknown_operation() {
+        let queue = ApprovalQueue::new();
+        assert!(matches!(queue.reject("missing", "admin"), Err(ApprovalError::NotFound(_))));
+    }
+}
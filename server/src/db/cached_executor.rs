@@ -1,45 +1,432 @@
+use std::collections::{HashMap, HashSet};
 use std::path::Path;
-use std::sync::Arc;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex, RwLock};
+use std::time::Duration;
 use lumos_core::LumosError;
+use lumos_core::query::parser::QueryParser;
 use lumos_core::sqlite::connection::RowData;
+use log::{debug, warn};
+use serde::Serialize;
 
-use crate::db::executor::DbExecutor;
+use crate::cache::{Cache, CleanupReport, MemoryCache, SingleFlight, NamedQuery, NamedQueries, namespaced_key, query_key};
+use crate::db::executor::{rows_to_json, DbExecutor};
 use crate::models::db::{TableInfo, ColumnInfo};
+use crate::utils::memory_budget::MemoryBudget;
+use crate::utils::degradation::DegradationController;
+
+/// 默认查询结果缓存时间
+const DEFAULT_CACHE_TTL: Duration = Duration::from_secs(60);
+
+/// 默认最多缓存的查询结果数
+const DEFAULT_CACHE_SIZE: usize = 1000;
+
+/// 默认查询结果缓存的最大字节数
+const DEFAULT_CACHE_MAX_BYTES: usize = 64 * 1024 * 1024;
+
+/// 默认单个查询结果的最大字节数，更大的结果不缓存
+const DEFAULT_CACHE_MAX_ENTRY_BYTES: usize = 8 * 1024 * 1024;
+
+/// 查询结果缓存在内存预算中的子系统名称
+const CACHE_SUBSYSTEM: &str = "query_cache";
+
+/// 无法确定引用表的查询在索引中的表名，任何写操作都会使其失效
+const UNKNOWN_TABLES: &str = "*";
+
+/// 查询结果，在缓存和并发等待者之间共享
+type SharedRows = Arc<Vec<RowData>>;
+
+/// 缓存状态信息
+#[derive(Debug, Clone, Serialize)]
+pub struct CacheStatus {
+    /// 缓存的查询结果数
+    pub entries: usize,
+    /// 缓存的查询结果估算字节数
+    pub bytes: usize,
+    /// 因合并而未实际执行的查询次数
+    pub coalesced_queries: u64,
+}
 
 /// 带缓存的数据库执行器，为查询提供缓存支持
-/// 注意：当前为简化实现，缓存功能暂未实现
+///
+/// 同一查询在缓存未命中时的并发请求会被合并，只执行一次。注册命名查询后
+/// 可以按缓存键读取（read-through），未命中时自动执行键模式绑定的查询并
+/// 填充缓存。
 pub struct CachedDbExecutor {
     /// 底层数据库执行器
     executor: Arc<DbExecutor>,
+    /// 查询结果缓存
+    cache: MemoryCache<String, SharedRows>,
+    /// 缓存未命中时的请求合并
+    in_flight: SingleFlight<String, Result<SharedRows, Arc<LumosError>>>,
+    /// 表名到引用该表的缓存键的索引
+    table_keys: Mutex<HashMap<String, HashSet<String>>>,
+    /// 写操作计数，用于丢弃写操作之前开始的查询结果
+    generation: AtomicU64,
+    /// 资源紧张时的降级控制
+    degradation: Option<Arc<DegradationController>>,
+    /// 绑定到缓存键模式的命名查询
+    named_queries: RwLock<NamedQueries>,
+    /// 查询端点的读查询是否使用结果缓存
+    serve_queries: bool,
 }
 
 impl CachedDbExecutor {
     /// 创建新的缓存数据库执行器
+    ///
+    /// 写操作使缓存失效需要再调用`invalidate_on_write`。
     pub fn new<P: AsRef<Path>>(path: P) -> Result<Self, LumosError> {
-        let executor = Arc::new(DbExecutor::new(path)?);
-        
-        Ok(Self {
+        Ok(Self::from_executor(Arc::new(DbExecutor::new(path)?)))
+    }
+
+    /// 在已有的数据库执行器上创建缓存执行器，与其他使用者共享同一个连接
+    ///
+    /// 写操作（包括通过本执行器执行的）由`invalidate_on_write`注册的回调使
+    /// 缓存失效，不注册时缓存只按过期时间失效。
+    pub fn from_executor(executor: Arc<DbExecutor>) -> Self {
+        Self {
             executor,
-        })
+            cache: MemoryCache::new()
+                .with_ttl(DEFAULT_CACHE_TTL)
+                .with_max_size(DEFAULT_CACHE_SIZE)
+                .with_size_fn(rows_size)
+                .with_max_bytes(DEFAULT_CACHE_MAX_BYTES)
+                .with_max_entry_bytes(DEFAULT_CACHE_MAX_ENTRY_BYTES),
+            in_flight: SingleFlight::new(),
+            table_keys: Mutex::new(HashMap::new()),
+            generation: AtomicU64::new(0),
+            degradation: None,
+            named_queries: RwLock::new(NamedQueries::new()),
+            serve_queries: false,
+        }
+    }
+
+    /// 底层数据库执行器的写操作成功后使相关表的缓存失效
+    pub fn invalidate_on_write(self: &Arc<Self>) {
+        let cached = Arc::downgrade(self);
+        self.executor.on_write(move |sql| {
+            if let Some(cached) = cached.upgrade() {
+                cached.invalidate_for_write(sql);
+            }
+        });
+    }
+
+    /// 设置查询结果缓存时间
+    pub fn with_cache_ttl(mut self, ttl: Duration) -> Self {
+        self.cache = self.cache.with_ttl(ttl);
+        self
+    }
+
+    /// 设置查询结果缓存的字节数限制
+    pub fn with_cache_bytes(mut self, max_bytes: usize, max_entry_bytes: usize) -> Self {
+        self.cache = self.cache
+            .with_max_bytes(max_bytes)
+            .with_max_entry_bytes(max_entry_bytes);
+        self
+    }
+
+    /// 从共享内存预算申请查询结果缓存占用的内存
+    ///
+    /// 其他子系统需要内存时，可将`shrink_cache`注册为预算的回收函数。
+    pub fn with_memory_budget(mut self, budget: Arc<MemoryBudget>) -> Self {
+        self.cache = self.cache.with_memory_budget(budget, CACHE_SUBSYSTEM);
+        self
+    }
+
+    /// 设置查询端点的读查询是否使用结果缓存
+    pub fn with_serve_queries(mut self, serve_queries: bool) -> Self {
+        self.serve_queries = serve_queries;
+        self
     }
-    
+
+    /// 查询端点的读查询是否使用结果缓存
+    pub fn serves_queries(&self) -> bool {
+        self.serve_queries
+    }
+
+    /// 资源紧张时按降级策略停止缓存查询结果
+    pub fn with_degradation(mut self, degradation: Arc<DegradationController>) -> Self {
+        self.degradation = Some(degradation);
+        self
+    }
+
+    /// 清除过期的查询结果，由后台清理器定期调用
+    pub fn cleanup_expired(&self) -> CleanupReport {
+        self.cache.cleanup_report()
+    }
+
+    /// 淘汰最旧的查询结果直到释放指定字节数，返回实际释放的字节数
+    pub fn shrink_cache(&self, bytes: usize) -> usize {
+        self.cache.shrink(bytes)
+    }
+
     /// 执行查询并返回结果
     pub fn execute_query(&self, sql: &str, params: &[String]) -> Result<Vec<RowData>, LumosError> {
-        self.executor.execute_query(sql, params)
+        // 写语句不缓存，相关表的缓存由写操作回调失效
+        if QueryParser::new().is_write_query(sql).unwrap_or(true) {
+            return self.executor.execute_query(sql, params);
+        }
+
+        let key = cache_key(sql, params);
+        if let Some(rows) = self.cache.get(&key) {
+            return Ok(rows.as_ref().clone());
+        }
+
+        let result = self.in_flight.run(&key, || {
+            // 等待期间其他调用可能已经填充了缓存
+            if let Some(rows) = self.cache.get(&key) {
+                return Ok(rows);
+            }
+
+            let generation = self.generation.load(Ordering::SeqCst);
+            let rows = Arc::new(self.executor.execute_query(sql, params).map_err(Arc::new)?);
+            self.store(sql, &key, rows.clone(), generation);
+            Ok(rows)
+        });
+
+        match result {
+            Ok(rows) => Ok(rows.as_ref().clone()),
+            Err(e) => Err(clone_error(&e)),
+        }
+    }
+
+    /// 执行查询并返回JSON结果（用于REST API）
+    pub fn query_with_params(&self, sql: &str, params: &[String]) -> Result<Vec<serde_json::Value>, LumosError> {
+        self.execute_query(sql, params).map(rows_to_json)
+    }
+
+    /// 注册命名查询，匹配其键模式的缓存键由该查询填充；只接受读查询
+    pub fn register_named_query(&self, query: NamedQuery) -> Result<(), LumosError> {
+        if QueryParser::new().is_write_query(&query.sql).unwrap_or(true) {
+            return Err(LumosError::InvalidArgument(format!("Named query '{}' must be a read query", query.name)));
+        }
+        self.named_queries.write().unwrap()
+            .register(query)
+            .map_err(LumosError::InvalidArgument)
+    }
+
+    /// 移除命名查询
+    pub fn remove_named_query(&self, name: &str) -> bool {
+        self.named_queries.write().unwrap().remove(name)
+    }
+
+    /// 已注册的命名查询
+    pub fn named_queries(&self) -> Vec<NamedQuery> {
+        self.named_queries.read().unwrap().list()
     }
-    
+
+    /// 按缓存键读取，未命中时执行键模式绑定的命名查询并填充缓存
+    ///
+    /// 没有匹配键的命名查询时返回`None`。结果与普通查询共用缓存、请求合并和
+    /// 写操作失效机制，因此表被修改后下次读取会重新执行查询。
+    pub fn get_by_key(&self, key: &str) -> Result<Option<Vec<RowData>>, LumosError> {
+        let resolved = self.named_queries.read().unwrap().resolve(key);
+        match resolved {
+            Some((query, params)) => {
+                debug!("Reading cache key '{}' through named query '{}'", key, query.name);
+                self.execute_query(&query.sql, &params).map(Some)
+            },
+            None => Ok(None),
+        }
+    }
+
     /// 执行SQL语句并返回影响的行数
     pub fn execute(&self, sql: &str, params: &[String]) -> Result<usize, LumosError> {
         self.executor.execute(sql, params)
     }
-    
+
     /// 获取所有表名
     pub fn list_tables(&self) -> Result<Vec<TableInfo>, LumosError> {
         self.executor.list_tables()
     }
-    
+
     /// 获取表结构
     pub fn get_table_schema(&self, table_name: &str) -> Result<Vec<ColumnInfo>, LumosError> {
         self.executor.get_table_schema(table_name)
     }
-} 
\ No newline at end of file
+
+    /// 清空所有缓存的查询结果
+    pub fn clear_cache(&self) {
+        let mut table_keys = self.table_keys.lock().unwrap();
+        self.generation.fetch_add(1, Ordering::SeqCst);
+        table_keys.clear();
+        self.cache.clear();
+    }
+
+    /// 使引用指定表的缓存结果失效，返回移除的缓存项数量
+    pub fn invalidate_table(&self, table: &str) -> usize {
+        self.invalidate_tables(&[table.to_lowercase()])
+    }
+
+    /// 写操作后使其修改的表的缓存失效，无法确定目标表时清空全部缓存
+    ///
+    /// 触发器写入的表（例如行历史表）和引用被修改的表的视图一并失效。
+    fn invalidate_for_write(&self, sql: &str) {
+        let mut tables = QueryParser::new().extract_write_tables(sql);
+        if tables.is_empty() {
+            debug!("Clearing query cache after write with unknown target: {}", sql);
+            self.clear_cache();
+            return;
+        }
+        match self.executor.write_dependents(&tables) {
+            Ok(dependents) => tables.extend(dependents),
+            Err(e) => {
+                warn!("Clearing query cache, failed to resolve triggers and views of {:?}: {}", tables, e);
+                self.clear_cache();
+                return;
+            }
+        }
+        self.invalidate_tables(&tables);
+    }
+
+    /// 移除引用这些表（以及引用表未知）的缓存项
+    ///
+    /// 只引用一张表的结果按表的命名空间整体移除，引用多张表或引用表未知的
+    /// 结果通过`table_keys`索引移除。
+    fn invalidate_tables(&self, tables: &[String]) -> usize {
+        let mut table_keys = self.table_keys.lock().unwrap();
+        self.generation.fetch_add(1, Ordering::SeqCst);
+
+        let mut removed = 0;
+        for table in tables {
+            removed += self.cache.invalidate_namespace(table);
+        }
+        for table in tables.iter().map(String::as_str).chain(std::iter::once(UNKNOWN_TABLES)) {
+            if let Some(keys) = table_keys.remove(table) {
+                for key in keys {
+                    if self.cache.remove(&key) {
+                        removed += 1;
+                    }
+                }
+            }
+        }
+
+        if removed > 0 {
+            debug!("Invalidated {} cached queries for tables {:?}", removed, tables);
+        }
+        removed
+    }
+
+    /// 缓存查询结果并记录其引用的表
+    ///
+    /// 如果查询执行期间发生了写操作，结果可能已过期，不再缓存。
+    fn store(&self, sql: &str, key: &str, rows: SharedRows, generation: u64) {
+        if self.degradation.as_ref().map_or(false, |d| !d.caching_enabled()) {
+            debug!("Skipping result cache under memory pressure");
+            return;
+        }
+
+        let mut table_keys = self.table_keys.lock().unwrap();
+        if self.generation.load(Ordering::SeqCst) != generation {
+            return;
+        }
+
+        if !self.cache.set(key.to_string(), rows) {
+            return;
+        }
+
+        let mut tables = QueryParser::new().extract_table_names(sql);
+        // 只引用一张表的结果位于该表的命名空间中，不需要索引
+        if tables.len() == 1 {
+            return;
+        }
+        if tables.is_empty() {
+            tables.push(UNKNOWN_TABLES.to_string());
+        }
+        for table in tables {
+            table_keys.entry(table).or_default().insert(key.to_string());
+        }
+    }
+
+    /// 获取缓存状态
+    pub fn cache_status(&self) -> CacheStatus {
+        CacheStatus {
+            entries: self.cache.len(),
+            bytes: self.cache.bytes(),
+            coalesced_queries: self.in_flight.coalesced_count(),
+        }
+    }
+}
+
+/// 查询结果的缓存键，只引用一张表的查询以表名为命名空间，可以按表整体失效
+fn cache_key(sql: &str, params: &[String]) -> String {
+    let key = query_key(sql, params);
+    match QueryParser::new().extract_table_names(sql).as_slice() {
+        [table] => namespaced_key(table, &key),
+        _ => key,
+    }
+}
+
+/// 估算查询结果占用的字节数
+fn rows_size(rows: &SharedRows) -> usize {
+    rows.iter()
+        .map(|row| {
+            row.values.iter()
+                .map(|(column, value)| column.len() + value.len())
+                .sum::<usize>()
+        })
+        .sum()
+}
+
+/// 复制共享的错误，返回给每个等待者
+fn clone_error(err: &LumosError) -> LumosError {
+    match err {
+        LumosError::Sqlite(msg) => LumosError::Sqlite(msg.clone()),
+        LumosError::DuckDb(msg) => LumosError::DuckDb(msg.clone()),
+        LumosError::Query(msg) => LumosError::Query(msg.clone()),
+        LumosError::Sync(msg) => LumosError::Sync(msg.clone()),
+        LumosError::Vector(msg) => LumosError::Vector(msg.clone()),
+        LumosError::Io(e) => LumosError::Io(std::io::Error::new(e.kind(), e.to_string())),
+        LumosError::InvalidArgument(msg) => LumosError::InvalidArgument(msg.clone()),
+        LumosError::Internal(msg) => LumosError::Internal(msg.clone()),
+        LumosError::NotFound(msg) => LumosError::NotFound(msg.clone()),
+        LumosError::Timeout(msg) => LumosError::Timeout(msg.clone()),
+        LumosError::Other(msg) => LumosError::Other(msg.clone()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_invalidate_table_by_namespace() {
+        let dir = tempfile::tempdir().unwrap();
+        let executor = CachedDbExecutor::new(dir.path().join("cached.db")).unwrap();
+        executor.execute("CREATE TABLE a (id INTEGER)", &[]).unwrap();
+        executor.execute("CREATE TABLE b (id INTEGER)", &[]).unwrap();
+
+        // 单表查询位于表的命名空间中，不进入索引
+        executor.execute_query("SELECT id FROM a", &[]).unwrap();
+        executor.execute_query("SELECT id FROM b", &[]).unwrap();
+        executor.execute_query("SELECT a.id FROM a JOIN b ON a.id = b.id", &[]).unwrap();
+        assert_eq!(executor.cache_status().entries, 3);
+        assert!(executor.cache.get(&namespaced_key("a", &query_key("SELECT id FROM a", &[]))).is_some());
+        assert_eq!(executor.table_keys.lock().unwrap().get("b").map(|keys| keys.len()), Some(1));
+
+        // 单表结果按命名空间移除，多表结果通过索引移除，其他表的结果保留
+        assert_eq!(executor.invalidate_table("A"), 2);
+        assert_eq!(executor.cache_status().entries, 1);
+        assert!(executor.cache.get(&cache_key("SELECT id FROM b", &[])).is_some());
+    }
+
+    #[test]
+    fn test_write_invalidates_trigger_tables_and_views() {
+        let dir = tempfile::tempdir().unwrap();
+        let executor = Arc::new(CachedDbExecutor::new(dir.path().join("cached.db")).unwrap());
+        executor.invalidate_on_write();
+        executor.execute("CREATE TABLE t (id INTEGER, name TEXT)", &[]).unwrap();
+        executor.execute("CREATE VIEW t_names AS SELECT name FROM t", &[]).unwrap();
+        executor.executor.enable_history("t").unwrap();
+
+        executor.execute_query("SELECT name FROM t_history", &[]).unwrap();
+        executor.execute_query("SELECT name FROM t_names", &[]).unwrap();
+        assert_eq!(executor.cache_status().entries, 2);
+
+        // 写入t时历史表由触发器写入，视图的结果也随之改变
+        executor.execute("INSERT INTO t (id, name) VALUES (1, 'a')", &[]).unwrap();
+        assert_eq!(executor.cache_status().entries, 0);
+        assert_eq!(executor.execute_query("SELECT name FROM t_history", &[]).unwrap().len(), 1);
+        assert_eq!(executor.execute_query("SELECT name FROM t_names", &[]).unwrap().len(), 1);
+    }
+}
@@ -0,0 +1,176 @@
+use serde::Serialize;
+use lumos_core::LumosError;
+use lumos_core::sqlite::SqliteEngine;
+
+/// 数据库结构目录，用于编辑器和REPL的自动补全
+///
+/// `version`取自SQLite的`schema_version`，任何连接执行DDL（包括导入和
+/// 历史表）都会使其递增，客户端可以据此判断目录是否需要重新获取。内部表
+/// （`sqlite_`和`_lumos_`前缀）不包含在目录中。
+#[derive(Debug, Clone, Serialize)]
+pub struct SchemaCatalog {
+    pub version: i64,
+    pub tables: Vec<CatalogTable>,
+    pub indexes: Vec<CatalogIndex>,
+    pub functions: Vec<CatalogFunction>,
+}
+
+/// 表或视图
+#[derive(Debug, Clone, Serialize)]
+pub struct CatalogTable {
+    pub name: String,
+    /// table或view
+    pub kind: String,
+    pub columns: Vec<CatalogColumn>,
+}
+
+/// 表或视图的列
+#[derive(Debug, Clone, Serialize)]
+pub struct CatalogColumn {
+    pub name: String,
+    pub data_type: String,
+    pub nullable: bool,
+    pub primary_key: bool,
+}
+
+/// 索引
+#[derive(Debug, Clone, Serialize)]
+pub struct CatalogIndex {
+    pub name: String,
+    pub table: String,
+    /// 索引列，表达式索引的表达式列不包含在内
+    pub columns: Vec<String>,
+    pub unique: bool,
+}
+
+/// 可在SQL中调用的函数
+#[derive(Debug, Clone, Serialize)]
+pub struct CatalogFunction {
+    pub name: String,
+    /// 参数个数，-1表示可变参数
+    pub args: i64,
+}
+
+/// 当前的结构版本
+pub fn schema_version(engine: &SqliteEngine) -> Result<i64, LumosError> {
+    let rows = engine.query_all("PRAGMA schema_version", &[])?;
+    Ok(rows.first()
+        .and_then(|row| row.get("schema_version"))
+        .and_then(|v| v.parse().ok())
+        .unwrap_or(0))
+}
+
+/// 读取表、列、索引和函数
+pub fn load_catalog(engine: &SqliteEngine) -> Result<SchemaCatalog, LumosError> {
+    let version = schema_version(engine)?;
+
+    let mut tables: Vec<CatalogTable> = Vec::new();
+    let rows = engine.query_all(
+        "SELECT m.name AS table_name, m.type AS kind, p.name AS column_name, p.type AS data_type,
+                p.\"notnull\" AS not_null, p.pk AS pk
+         FROM sqlite_master m JOIN pragma_table_info(m.name) p
+         WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite\\_%' ESCAPE '\\'
+           AND m.name NOT LIKE '\\_lumos\\_%' ESCAPE '\\'
+         ORDER BY m.name, p.cid",
+        &[],
+    )?;
+    for row in rows {
+        let table = field(&row, "table_name");
+        if tables.last().map_or(true, |t| t.name != table) {
+            tables.push(CatalogTable { name: table, kind: field(&row, "kind"), columns: Vec::new() });
+        }
+        if let Some(current) = tables.last_mut() {
+            current.columns.push(CatalogColumn {
+                name: field(&row, "column_name"),
+                data_type: field(&row, "data_type"),
+                nullable: field(&row, "not_null") == "0",
+                primary_key: field(&row, "pk") != "0",
+            });
+        }
+    }
+
+    let mut indexes: Vec<CatalogIndex> = Vec::new();
+    let rows = engine.query_all(
+        "SELECT m.name AS index_name, m.tbl_name AS table_name, l.\"unique\" AS is_unique, i.name AS column_name
+         FROM sqlite_master m
+         JOIN pragma_index_list(m.tbl_name) l ON l.name = m.name
+         JOIN pragma_index_info(m.name) i
+         WHERE m.type = 'index' AND m.tbl_name NOT LIKE 'sqlite\\_%' ESCAPE '\\'
+           AND m.tbl_name NOT LIKE '\\_lumos\\_%' ESCAPE '\\'
+         ORDER BY m.name, i.seqno",
+        &[],
+    )?;
+    for row in rows {
+        let name = field(&row, "index_name");
+        if indexes.last().map_or(true, |i| i.name != name) {
+            indexes.push(CatalogIndex {
+                name,
+                table: field(&row, "table_name"),
+                columns: Vec::new(),
+                unique: field(&row, "is_unique") == "1",
+            });
+        }
+        let column = field(&row, "column_name");
+        if column.is_empty() || column == "NULL" {
+            continue;
+        }
+        if let Some(current) = indexes.last_mut() {
+            current.columns.push(column);
+        }
+    }
+
+    Ok(SchemaCatalog { version, tables, indexes, functions: load_functions(engine) })
+}
+
+/// 读取内置和扩展注册的函数，SQLite未启用`function_list`时返回空列表
+fn load_functions(engine: &SqliteEngine) -> Vec<CatalogFunction> {
+    let rows = match engine.query_all("SELECT DISTINCT name, narg FROM pragma_function_list ORDER BY name, narg", &[]) {
+        Ok(rows) => rows,
+        Err(e) => {
+            log::debug!("Function list unavailable: {}", e);
+            return Vec::new();
+        }
+    };
+    rows.iter()
+        .map(|row| CatalogFunction {
+            name: field(row, "name"),
+            args: field(row, "narg").parse().unwrap_or(-1),
+        })
+        .collect()
+}
+
+fn field(row: &lumos_core::sqlite::connection::RowData, column: &str) -> String {
+    row.get(column).cloned().unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_load_catalog() {
+        let path = std::env::temp_dir().join(format!("lumos_catalog_{}.db", std::process::id()));
+        let _ = std::fs::remove_file(&path);
+        let engine = SqliteEngine::new(path.to_str().unwrap());
+        engine.init().unwrap();
+        engine.execute("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL, name TEXT)", &[]).unwrap();
+        engine.execute("CREATE UNIQUE INDEX idx_users_email ON users (email)", &[]).unwrap();
+        let before = schema_version(&engine).unwrap();
+        engine.execute("CREATE VIEW named_users AS SELECT id, name FROM users WHERE name IS NOT NULL", &[]).unwrap();
+
+        let catalog = load_catalog(&engine).unwrap();
+        assert!(catalog.version > before);
+        let names: Vec<_> = catalog.tables.iter().map(|t| (t.name.as_str(), t.kind.as_str())).collect();
+        assert_eq!(names, vec![("named_users", "view"), ("users", "table")]);
+        let users = &catalog.tables[1];
+        assert_eq!(users.columns.len(), 3);
+        assert!(users.columns[0].primary_key);
+        assert!(!users.columns[1].nullable);
+        assert_eq!(users.columns[2].data_type, "TEXT");
+
+        let index = catalog.indexes.iter().find(|i| i.name == "idx_users_email").unwrap();
+        assert_eq!((index.table.as_str(), index.columns.clone(), index.unique), ("users", vec!["email".to_string()], true));
+
+        let _ = std::fs::remove_file(&path);
+    }
+}
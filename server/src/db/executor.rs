@@ -1,14 +1,53 @@
+use std::collections::{HashMap, HashSet};
 use std::path::Path;
 use std::sync::{Arc, Mutex, RwLock};
+use std::time::Instant;
 use lumos_core::{LumosError};
 use lumos_core::sqlite::connection::RowData;
+use lumos_core::sqlite::import::ImportReport;
+use lumos_core::sqlite::explain::{self, QueryPlan};
+use lumos_core::sqlite::advisor::IndexSuggestion;
+use lumos_core::sqlite::snapshot::{SnapshotInfo, SnapshotStore};
+use lumos_core::sqlite::temporal::{self, TemporalTable};
+use lumos_core::query::fingerprint::{QueryShapeStats, QueryStatsCollector};
+use lumos_core::query::stats::StatsCollector;
+use lumos_core::query::parser::QueryParser;
+use lumos_core::query::pinned::{self, PinnedTable};
+use lumos_core::duckdb::DuckDbEngine;
 use crate::models::db::{TableInfo, ColumnInfo};
+use crate::utils::query_audit::QueryAuditor;
+use crate::utils::integrity::IntegrityFinding;
+use crate::utils::memory_budget::MemoryBudget;
+use super::lineage::{self, LineageEdge};
+use super::integrity;
+use super::catalog::{self, SchemaCatalog};
+
+/// 查询结果在内存预算中的子系统名
+pub const MEMORY_SUBSYSTEM: &str = "query_results";
+
+/// 读取查询结果时每累计这么多字节向内存预算追加申请一次
+const RESULT_LEASE_STEP: u64 = 64 * 1024;
+
+/// 写操作成功后的回调，参数是执行的SQL
+pub type WriteListener = Arc<dyn Fn(&str) + Send + Sync>;
 
 /// 数据库执行器，负责执行SQL语句和查询
 pub struct DbExecutor {
     /// 数据库文件路径
     pub path: String,
     engine: Arc<Mutex<lumos_core::sqlite::SqliteEngine>>,
+    /// 按查询指纹汇总的执行统计
+    stats: Arc<QueryStatsCollector>,
+    /// 表和列的统计信息，用于估算执行计划的行数和代价
+    table_stats: StatsCollector,
+    /// 查询审计抽样
+    auditor: Option<Arc<QueryAuditor>>,
+    /// 最近一次读取的结构目录，结构版本变化后重新读取
+    catalog: Mutex<Option<Arc<SchemaCatalog>>>,
+    /// 写操作成功后通知的回调，例如使查询结果缓存失效
+    write_listeners: RwLock<Vec<WriteListener>>,
+    /// 进程级内存预算，读取查询结果时从中申请内存
+    memory_budget: Option<Arc<MemoryBudget>>,
 }
 
 impl DbExecutor {
@@ -20,24 +59,219 @@ impl DbExecutor {
         
         Ok(Self { 
             path: path_str,
-            engine: Arc::new(Mutex::new(db)) 
+            engine: Arc::new(Mutex::new(db)),
+            stats: Arc::new(QueryStatsCollector::default()),
+            table_stats: StatsCollector::default(),
+            auditor: None,
+            catalog: Mutex::new(None),
+            write_listeners: RwLock::new(Vec::new()),
+            memory_budget: None,
         })
     }
     
+    /// 按比例抽样记录查询及其完整参数
+    pub fn with_auditor(mut self, auditor: Arc<QueryAuditor>) -> Self {
+        self.auditor = Some(auditor);
+        self
+    }
+    
+    /// 读取查询结果时从内存预算申请内存，结果超出预算时中止查询
+    pub fn with_memory_budget(mut self, budget: Arc<MemoryBudget>) -> Self {
+        self.memory_budget = Some(budget);
+        self
+    }
+    
+    /// 查询审计抽样
+    pub fn auditor(&self) -> Option<&Arc<QueryAuditor>> {
+        self.auditor.as_ref()
+    }
+    
+    /// 注册写操作成功后的回调，回调在释放数据库锁之后调用
+    pub fn on_write<F>(&self, listener: F)
+    where
+        F: Fn(&str) + Send + Sync + 'static,
+    {
+        self.write_listeners.write().unwrap().push(Arc::new(listener));
+    }
+    
+    /// 通知写操作回调
+    fn notify_write(&self, sql: &str) {
+        let listeners = self.write_listeners.read().unwrap().clone();
+        for listener in listeners {
+            listener(sql);
+        }
+    }
+    
     /// 执行查询并返回结果
     pub fn execute_query(&self, sql: &str, params: &[String]) -> Result<Vec<RowData>, LumosError> {
         let engine = self.engine.lock().unwrap();
         let param_refs: Vec<&dyn rusqlite::ToSql> = params.iter().map(|p| p as &dyn rusqlite::ToSql).collect();
-        engine.query_all(sql, &param_refs)
+        let start = Instant::now();
+        let result = match &self.memory_budget {
+            Some(budget) => query_within_budget(&engine, budget, sql, &param_refs),
+            None => engine.query_all(sql, &param_refs),
+        };
+        self.stats.record(sql, start.elapsed(), result.as_ref().map_or(0, |rows| rows.len() as u64), result.is_err());
+        if let Some(auditor) = self.auditor.as_ref().filter(|a| a.should_sample()) {
+            let (rows, bytes, error) = match &result {
+                Ok(rows) => (rows.len() as u64, rows_bytes(rows), None),
+                Err(e) => (0, 0, Some(e.to_string())),
+            };
+            auditor.record(sql, params, start.elapsed(), rows, bytes, error);
+        }
+        drop(engine);
+        // 查询端点也可以执行写语句，只在有回调时解析SQL
+        if result.is_ok() && !self.write_listeners.read().unwrap().is_empty()
+            && QueryParser::new().is_write_query(sql).unwrap_or(true)
+        {
+            self.notify_write(sql);
+        }
+        result
+    }
+    
+    /// 执行查询并逐行把JSON结果交给`f`，不在内存中保留结果，返回行数
+    ///
+    /// 用于结果可能很大的查询任务，`f`返回错误时停止查询。
+    pub fn query_each<F>(&self, sql: &str, params: &[String], mut f: F) -> Result<u64, LumosError>
+    where
+        F: FnMut(serde_json::Value) -> Result<(), LumosError>,
+    {
+        let engine = self.engine.lock().unwrap();
+        let param_refs: Vec<&dyn rusqlite::ToSql> = params.iter().map(|p| p as &dyn rusqlite::ToSql).collect();
+        let start = Instant::now();
+        let mut bytes = 0;
+        let result = engine.query_each(sql, &param_refs, |row| {
+            bytes += rows_bytes(std::slice::from_ref(&row));
+            f(row_to_json(row))
+        });
+        self.stats.record(sql, start.elapsed(), *result.as_ref().unwrap_or(&0), result.is_err());
+        if let Some(auditor) = self.auditor.as_ref().filter(|a| a.should_sample()) {
+            let error = result.as_ref().err().map(|e| e.to_string());
+            auditor.record(sql, params, start.elapsed(), *result.as_ref().unwrap_or(&0), bytes, error);
+        }
+        drop(engine);
+        if result.is_ok() && !self.write_listeners.read().unwrap().is_empty()
+            && QueryParser::new().is_write_query(sql).unwrap_or(true)
+        {
+            self.notify_write(sql);
+        }
+        result
     }
     
     /// 执行SQL语句并返回影响的行数
     pub fn execute(&self, sql: &str, params: &[String]) -> Result<usize, LumosError> {
         let engine = self.engine.lock().unwrap();
         let param_refs: Vec<&dyn rusqlite::ToSql> = params.iter().map(|p| p as &dyn rusqlite::ToSql).collect();
-        engine.execute(sql, &param_refs)
+        let start = Instant::now();
+        let result = engine.execute(sql, &param_refs);
+        self.stats.record(sql, start.elapsed(), *result.as_ref().unwrap_or(&0) as u64, result.is_err());
+        if let Some(auditor) = self.auditor.as_ref().filter(|a| a.should_sample()) {
+            let error = result.as_ref().err().map(|e| e.to_string());
+            auditor.record(sql, params, start.elapsed(), *result.as_ref().unwrap_or(&0) as u64, 0, error);
+        }
+        // 记录INSERT ... SELECT和CREATE TABLE AS的列级血缘
+        if result.is_ok() {
+            if let Err(e) = lineage::record_lineage(&engine, sql) {
+                log::warn!("Failed to record column lineage: {}", e);
+            }
+        }
+        drop(engine);
+        if result.is_ok() {
+            self.notify_write(sql);
+        }
+        result
     }
     
+    /// 与表相关的列级血缘
+    pub fn column_lineage(&self, table: &str) -> Result<Vec<LineageEdge>, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        lineage::table_lineage(&engine, table)
+    }
+    
+    /// 检查数据库文件和内部表，可以自动修复的问题会被修复
+    pub fn verify_integrity(&self) -> Vec<IntegrityFinding> {
+        let engine = self.engine.lock().unwrap();
+        integrity::check_database(&engine)
+    }
+    
+    /// 获取按查询指纹汇总的执行统计，按总耗时降序
+    pub fn query_stats(&self) -> Vec<QueryShapeStats> {
+        self.stats.snapshot()
+    }
+    
+    /// 查询指纹统计的收集器，用于按保留策略清理
+    pub fn stats_collector(&self) -> Arc<QueryStatsCollector> {
+        self.stats.clone()
+    }
+    
+    /// 根据平均耗时不低于`slow_ms`的查询给出索引建议
+    pub fn index_suggestions(&self, slow_ms: f64) -> Result<Vec<IndexSuggestion>, LumosError> {
+        let stats = self.stats.snapshot();
+        let engine = self.engine.lock().unwrap();
+        engine.advise_indexes(&stats, slow_ms)
+    }
+    
+    /// 用于自动补全的结构目录
+    ///
+    /// 每次调用只检查结构版本，版本未变化时返回缓存的目录。
+    pub fn schema_catalog(&self) -> Result<Arc<SchemaCatalog>, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        let mut cached = self.catalog.lock().unwrap();
+        let version = catalog::schema_version(&engine)?;
+        if let Some(current) = cached.as_ref().filter(|c| c.version == version) {
+            return Ok(current.clone());
+        }
+        let fresh = Arc::new(catalog::load_catalog(&engine)?);
+        *cached = Some(fresh.clone());
+        Ok(fresh)
+    }
+
+    /// 写入`tables`时间接改变的表和视图
+    ///
+    /// 包括这些表上的触发器写入的表（例如行历史表）和引用这些表的视图，按
+    /// 触发器和视图的定义逐层展开。返回小写的名称，不包含`tables`本身。
+    pub fn write_dependents(&self, tables: &[String]) -> Result<Vec<String>, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        let rows = engine.query_all(
+            "SELECT type, name, tbl_name, sql FROM sqlite_master
+             WHERE type IN ('trigger', 'view') AND sql IS NOT NULL",
+            &[],
+        )?;
+        drop(engine);
+
+        // 表到写入它时会随之改变的表或视图
+        let parser = QueryParser::new();
+        let mut edges: HashMap<String, HashSet<String>> = HashMap::new();
+        for row in &rows {
+            let field = |name: &str| row.get(name).cloned().unwrap_or_default();
+            let sql = field("sql");
+            if field("type") == "trigger" {
+                edges.entry(field("tbl_name").to_lowercase())
+                    .or_default()
+                    .extend(trigger_write_tables(&parser, &sql));
+            } else {
+                let view = field("name").to_lowercase();
+                for table in parser.extract_table_names(&sql) {
+                    edges.entry(table).or_default().insert(view.clone());
+                }
+            }
+        }
+
+        let mut seen: HashSet<String> = tables.iter().map(|t| t.to_lowercase()).collect();
+        let mut pending: Vec<String> = seen.iter().cloned().collect();
+        let mut dependents = Vec::new();
+        while let Some(table) = pending.pop() {
+            for dependent in edges.get(&table).into_iter().flatten() {
+                if seen.insert(dependent.clone()) {
+                    dependents.push(dependent.clone());
+                    pending.push(dependent.clone());
+                }
+            }
+        }
+        dependents.sort();
+        Ok(dependents)
+    }
+
     /// 获取所有表名
     pub fn list_tables(&self) -> Result<Vec<TableInfo>, LumosError> {
         let engine = self.engine.lock().unwrap();
@@ -87,20 +321,112 @@ impl DbExecutor {
         Ok(columns)
     }
 
+    /// 从SQLite数据库文件导入所有表
+    ///
+    /// 导入提交后按导入的表通知写操作回调，使这些表的缓存结果和固定表副本过期。
+    pub fn import_sqlite(&self, source_path: &str, replace_existing: bool) -> Result<ImportReport, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        let report = engine.import_sqlite_file(source_path, replace_existing, |progress| {
+            log::info!(
+                "Import progress: {}/{} tables, {} rows copied into '{}'",
+                progress.tables_done, progress.tables_total, progress.rows, progress.table
+            );
+        })?;
+        drop(engine);
+        for table in &report.tables {
+            self.notify_write(&format!("INSERT INTO {}", quote_identifier(&table.table)));
+        }
+        Ok(report)
+    }
+
+    /// 将固定表复制到DuckDB临时表，返回行数和列名
+    pub fn load_pinned_table(
+        &self,
+        duckdb: &DuckDbEngine,
+        table: &PinnedTable,
+        max_rows: usize,
+    ) -> Result<(usize, Vec<String>), LumosError> {
+        let engine = self.engine.lock().unwrap();
+        pinned::load(&engine, duckdb, table, max_rows)
+    }
+
+    /// 获取查询的结构化执行计划，`analyze`为true时执行只读查询以获取实际行数和耗时
+    ///
+    /// `params`绑定到查询的占位符。扫描和查找节点附带按表统计信息估算的行数和
+    /// 代价，统计信息超过收集间隔时先重新收集。
+    pub fn explain(&self, sql: &str, params: &[String], analyze: bool) -> Result<QueryPlan, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        if let Err(e) = self.table_stats.collect_sqlite_if_due(&engine) {
+            log::warn!("Failed to collect table statistics for EXPLAIN: {}", e);
+        }
+        let param_refs: Vec<&dyn rusqlite::ToSql> = params.iter().map(|p| p as &dyn rusqlite::ToSql).collect();
+        let mut plan = engine.explain(sql, &param_refs, analyze)?;
+        explain::annotate_estimates(&mut plan, &self.table_stats);
+        Ok(plan)
+    }
+
     /// 执行SQL查询并返回JSON结果（用于REST API）
     pub fn query(&self, sql: &str) -> Result<Vec<serde_json::Value>, LumosError> {
-        let rows = self.execute_query(sql, &[])?;
-        
-        let mut result = Vec::new();
-        for row in rows {
-            let mut obj = serde_json::Map::new();
-            for (key, value) in &row.values {
-                obj.insert(key.clone(), serde_json::Value::String(value.clone()));
-            }
-            result.push(serde_json::Value::Object(obj));
-        }
-        
-        Ok(result)
+        self.query_with_params(sql, &[])
+    }
+    
+    /// 使用绑定参数执行SQL查询并返回JSON结果（用于REST API）
+    pub fn query_with_params(&self, sql: &str, params: &[String]) -> Result<Vec<serde_json::Value>, LumosError> {
+        let rows = self.execute_query(sql, params)?;
+        Ok(rows_to_json(rows))
+    }
+    
+    /// 将数据库快照保存到`store`
+    pub fn take_snapshot(&self, store: &SnapshotStore) -> Result<SnapshotInfo, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        engine.take_snapshot(store)
+    }
+    
+    /// 在`as_of_ms`时刻之前最近的快照上执行只读查询，返回所用快照和JSON结果
+    pub fn query_as_of(
+        &self,
+        store: &SnapshotStore,
+        as_of_ms: i64,
+        sql: &str,
+        params: &[String],
+    ) -> Result<(SnapshotInfo, Vec<serde_json::Value>), LumosError> {
+        let param_refs: Vec<&dyn rusqlite::ToSql> = params.iter().map(|p| p as &dyn rusqlite::ToSql).collect();
+        let start = Instant::now();
+        let result = store.query_as_of(as_of_ms, sql, &param_refs);
+        self.stats.record(sql, start.elapsed(), result.as_ref().map_or(0, |(_, rows)| rows.len() as u64), result.is_err());
+        result.map(|(snapshot, rows)| (snapshot, rows_to_json(rows)))
+    }
+    
+    /// 为表启用行历史记录，历史表被创建和填充后通知写操作回调
+    pub fn enable_history(&self, table: &str) -> Result<TemporalTable, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        let history = engine.enable_history(table)?;
+        drop(engine);
+        self.notify_write(&format!("INSERT INTO {}", quote_identifier(&history.history_table)));
+        Ok(history)
+    }
+    
+    /// 停用表的行历史记录，`drop_history`为true时同时删除历史表
+    ///
+    /// 历史表中仍有效的版本被关闭或历史表被删除后通知写操作回调。
+    pub fn disable_history(&self, table: &str, drop_history: bool) -> Result<(), LumosError> {
+        let engine = self.engine.lock().unwrap();
+        engine.disable_history(table, drop_history)?;
+        drop(engine);
+        self.notify_write(&format!("UPDATE {}", quote_identifier(&temporal::history_table_name(table))));
+        Ok(())
+    }
+    
+    /// 获取表在`at`时刻的状态（JSON结果）
+    pub fn table_as_of(&self, table: &str, at: &chrono::DateTime<chrono::Utc>) -> Result<Vec<serde_json::Value>, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        engine.table_as_of(table, at).map(rows_to_json)
+    }
+    
+    /// 获取一行的所有历史版本（JSON结果）
+    pub fn row_history(&self, table: &str, row_id: i64) -> Result<Vec<serde_json::Value>, LumosError> {
+        let engine = self.engine.lock().unwrap();
+        engine.row_history(table, row_id).map(rows_to_json)
     }
     
     /// 执行SQL命令并返回受影响的行数（用于REST API）
@@ -119,4 +445,72 @@ impl DbExecutor {
     pub fn get_table_info(&self, table_name: &str) -> Result<Vec<ColumnInfo>, LumosError> {
         self.get_table_schema(table_name)
     }
-} 
\ No newline at end of file
+}
+
+/// 读取全部结果行，读取期间按结果大小从内存预算申请内存，预算不足时中止
+///
+/// 申请的内存在返回时归还，预算只限制结果构建期间的峰值。
+fn query_within_budget(
+    engine: &lumos_core::sqlite::SqliteEngine,
+    budget: &Arc<MemoryBudget>,
+    sql: &str,
+    params: &[&dyn rusqlite::ToSql],
+) -> Result<Vec<RowData>, LumosError> {
+    let mut lease = budget.lease(MEMORY_SUBSYSTEM, 0)
+        .ok_or_else(|| LumosError::Query("Memory budget exhausted".to_string()))?;
+    let mut rows = Vec::new();
+    let mut pending = 0;
+    engine.query_each(sql, params, |row| {
+        pending += rows_bytes(std::slice::from_ref(&row));
+        if pending >= RESULT_LEASE_STEP {
+            if !lease.grow(pending as usize) {
+                return Err(LumosError::Query(format!(
+                    "Query result exceeds the memory budget after {} rows",
+                    rows.len()
+                )));
+            }
+            pending = 0;
+        }
+        rows.push(row);
+        Ok(())
+    })?;
+    Ok(rows)
+}
+
+/// 估算查询结果的字节数
+fn rows_bytes(rows: &[RowData]) -> u64 {
+    rows.iter()
+        .flat_map(|row| row.values.iter())
+        .map(|(column, value)| (column.len() + value.len()) as u64)
+        .sum()
+}
+
+/// 为通知写操作回调生成的SQL引用标识符
+fn quote_identifier(name: &str) -> String {
+    format!("\"{}\"", name.replace('"', "\"\""))
+}
+
+/// 触发器体内的语句写入的表
+fn trigger_write_tables(parser: &QueryParser, sql: &str) -> Vec<String> {
+    let upper = sql.to_ascii_uppercase();
+    let body = match upper.find("BEGIN") {
+        Some(idx) => &sql[idx + "BEGIN".len()..],
+        None => return Vec::new(),
+    };
+    body.split(';')
+        .flat_map(|statement| parser.extract_write_tables(statement))
+        .collect()
+}
+
+// 将查询结果行转换为JSON对象
+pub(crate) fn rows_to_json(rows: Vec<RowData>) -> Vec<serde_json::Value> {
+    rows.into_iter().map(row_to_json).collect()
+}
+
+fn row_to_json(row: RowData) -> serde_json::Value {
+    let obj: serde_json::Map<String, serde_json::Value> = row.values
+        .into_iter()
+        .map(|(key, value)| (key, serde_json::Value::String(value)))
+        .collect();
+    serde_json::Value::Object(obj)
+}
@@ -0,0 +1,105 @@
+use lumos_core::sqlite::SqliteEngine;
+use crate::utils::integrity::IntegrityFinding;
+use super::lineage;
+
+/// 行历史的登记表，由核心库的时态表功能维护
+const TEMPORAL_REGISTRY: &str = "lumos_temporal_tables";
+
+/// 启动时检查数据库文件、内部表和行历史登记
+pub fn check_database(engine: &SqliteEngine) -> Vec<IntegrityFinding> {
+    let mut findings = vec![quick_check(engine)];
+    findings.push(lineage::verify(engine));
+    findings.extend(check_temporal_registry(engine));
+    findings
+}
+
+/// SQLite页和索引的快速一致性检查，损坏的数据库文件无法自动修复
+fn quick_check(engine: &SqliteEngine) -> IntegrityFinding {
+    const CHECK: &str = "database";
+    match engine.query_all("PRAGMA quick_check", &[]) {
+        Ok(rows) => {
+            let problems: Vec<String> = rows.iter()
+                .filter_map(|row| row.values.values().next().cloned())
+                .filter(|message| message != "ok")
+                .collect();
+            if problems.is_empty() {
+                IntegrityFinding::ok(CHECK)
+            } else {
+                IntegrityFinding::failed(CHECK, format!("quick_check reported {} problems, restore from a snapshot: {}", problems.len(), problems.join("; ")))
+            }
+        },
+        Err(e) => IntegrityFinding::failed(CHECK, format!("quick_check failed: {}", e)),
+    }
+}
+
+/// 检查启用了行历史的表，登记的表或历史表已不存在时修正登记
+fn check_temporal_registry(engine: &SqliteEngine) -> Vec<IntegrityFinding> {
+    let exists = |name: &str| engine
+        .query_all("SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?1", rusqlite::params![name])
+        .map(|rows| !rows.is_empty());
+
+    match exists(TEMPORAL_REGISTRY) {
+        Ok(true) => {},
+        Ok(false) => return Vec::new(),
+        Err(e) => return vec![IntegrityFinding::failed("temporal_registry", e.to_string())],
+    }
+    let rows = match engine.query_all(&format!("SELECT table_name, history_table FROM {}", TEMPORAL_REGISTRY), &[]) {
+        Ok(rows) => rows,
+        Err(e) => return vec![IntegrityFinding::failed("temporal_registry", format!("failed to read registry: {}", e))],
+    };
+
+    rows.iter()
+        .filter_map(|row| Some((row.get("table_name")?.clone(), row.get("history_table")?.clone())))
+        .map(|(table, history)| {
+            let check = format!("row_history:{}", table);
+            match (exists(&table), exists(&history)) {
+                (Ok(true), Ok(true)) => IntegrityFinding::ok(check),
+                // 表已被删除，历史表保留以便查询
+                (Ok(false), Ok(_)) => match engine.disable_history(&table, false) {
+                    Ok(()) => IntegrityFinding::repaired(check, format!("table '{}' no longer exists, row history disabled", table)),
+                    Err(e) => IntegrityFinding::failed(check, format!("table '{}' no longer exists and disabling row history failed: {}", table, e)),
+                },
+                // 历史表丢失时从当前数据重新开始记录
+                (Ok(true), Ok(false)) => match engine.disable_history(&table, true).and_then(|_| engine.enable_history(&table)) {
+                    Ok(_) => IntegrityFinding::repaired(check, format!("history table '{}' was missing, row history restarted from the current rows", history)),
+                    Err(e) => IntegrityFinding::failed(check, format!("history table '{}' is missing and re-enabling row history failed: {}", history, e)),
+                },
+                (Err(e), _) | (_, Err(e)) => IntegrityFinding::failed(check, e.to_string()),
+            }
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::integrity::CheckStatus;
+
+    #[test]
+    fn test_check_database_repairs_row_history() {
+        let path = std::env::temp_dir().join(format!("lumos_integrity_{}.db", std::process::id()));
+        let _ = std::fs::remove_file(&path);
+        let mut engine = SqliteEngine::new(path.to_str().unwrap());
+        engine.init().unwrap();
+        engine.execute("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)", &[]).unwrap();
+        engine.execute("INSERT INTO users (name) VALUES ('alice')", &[]).unwrap();
+        engine.enable_history("users").unwrap();
+
+        let findings = check_database(&engine);
+        assert!(findings.iter().all(|f| f.status == CheckStatus::Ok), "{:?}", findings);
+
+        engine.execute("DROP TABLE users_history", &[]).unwrap();
+        let findings = check_database(&engine);
+        let finding = findings.iter().find(|f| f.check == "row_history:users").unwrap();
+        assert_eq!(finding.status, CheckStatus::Repaired);
+        assert_eq!(engine.query_all("SELECT * FROM users_history", &[]).unwrap().len(), 1);
+
+        engine.execute("CREATE TABLE _lumos_column_lineage (target TEXT)", &[]).unwrap();
+        let findings = check_database(&engine);
+        assert!(findings.iter().any(|f| f.check == "internal_table:_lumos_column_lineage" && f.status == CheckStatus::Repaired));
+        assert!(check_database(&engine).iter().all(|f| f.status == CheckStatus::Ok));
+
+        drop(engine);
+        let _ = std::fs::remove_file(&path);
+    }
+}
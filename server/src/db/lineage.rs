@@ -0,0 +1,135 @@
+use chrono::{SecondsFormat, Utc};
+use serde::Serialize;
+use lumos_core::LumosError;
+use lumos_core::sqlite::SqliteEngine;
+use lumos_core::query::lineage::{extract_column_lineage, ColumnLineage};
+use crate::utils::integrity::IntegrityFinding;
+
+/// 列级血缘表，与数据表保存在同一个数据库中
+const SCHEMA: &str = "
+    CREATE TABLE IF NOT EXISTS _lumos_column_lineage (
+        target_table TEXT NOT NULL,
+        target_column TEXT NOT NULL,
+        source_table TEXT NOT NULL,
+        source_column TEXT NOT NULL,
+        kind TEXT NOT NULL,
+        expression TEXT NOT NULL,
+        recorded_at TEXT NOT NULL,
+        PRIMARY KEY (target_table, target_column, source_table, source_column)
+    )
+";
+
+/// 血缘表的列，启动检查时用于发现损坏或旧版本的表结构
+const COLUMNS: [&str; 7] = ["target_table", "target_column", "source_table", "source_column", "kind", "expression", "recorded_at"];
+
+/// 一条列级血缘：源列流入目标列
+#[derive(Debug, Clone, Serialize)]
+pub struct LineageEdge {
+    pub target_table: String,
+    pub target_column: String,
+    /// 源表，无法确定时为空
+    pub source_table: Option<String>,
+    pub source_column: String,
+    /// direct表示直接复制，expression表示由表达式计算
+    pub kind: String,
+    pub expression: String,
+    pub recorded_at: String,
+}
+
+/// 记录INSERT ... SELECT和CREATE TABLE AS语句的列级血缘，返回记录的边数
+///
+/// 需要在语句成功执行后调用。INSERT未指定列时按位置从目标表结构中确定列名；
+/// 同一目标列可以有多个来源，重复执行只更新记录时间和表达式。
+pub fn record_lineage(engine: &SqliteEngine, sql: &str) -> Result<usize, LumosError> {
+    let lineage = match extract_column_lineage(sql) {
+        Some(lineage) if !lineage.is_empty() => lineage,
+        _ => return Ok(0),
+    };
+
+    engine.execute(SCHEMA, &[])?;
+    let target_columns = resolve_target_columns(engine, &lineage)?;
+    let now = Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true);
+
+    let mut recorded = 0;
+    for (column, target_column) in lineage.iter().zip(target_columns) {
+        let target_column = match target_column {
+            Some(name) => name,
+            None => continue,
+        };
+        let kind = serde_json::to_value(column.kind).ok()
+            .and_then(|v| v.as_str().map(str::to_string))
+            .unwrap_or_default();
+        for source in &column.sources {
+            let source_table = source.table.clone().unwrap_or_default();
+            engine.execute(
+                "INSERT OR REPLACE INTO _lumos_column_lineage \
+                 (target_table, target_column, source_table, source_column, kind, expression, recorded_at) \
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)",
+                rusqlite::params![column.target_table, target_column, source_table, source.column, kind, column.expression, now],
+            )?;
+            recorded += 1;
+        }
+    }
+    Ok(recorded)
+}
+
+/// 目标列名，INSERT未指定列时按位置查表结构
+fn resolve_target_columns(engine: &SqliteEngine, lineage: &[ColumnLineage]) -> Result<Vec<Option<String>>, LumosError> {
+    if lineage.iter().all(|c| c.target_column.is_some()) {
+        return Ok(lineage.iter().map(|c| c.target_column.clone()).collect());
+    }
+    let rows = engine.query_all(
+        "SELECT name FROM pragma_table_info(?1) ORDER BY cid",
+        rusqlite::params![lineage[0].target_table],
+    )?;
+    let schema: Vec<String> = rows.iter().filter_map(|row| row.get("name").cloned()).collect();
+    Ok(lineage.iter()
+        .map(|c| c.target_column.clone().or_else(|| schema.get(c.position).map(|n| n.to_lowercase())))
+        .collect())
+}
+
+/// 与表相关的血缘，包括流入该表和从该表流出的列
+pub fn table_lineage(engine: &SqliteEngine, table: &str) -> Result<Vec<LineageEdge>, LumosError> {
+    engine.execute(SCHEMA, &[])?;
+    let table = table.to_lowercase();
+    let rows = engine.query_all(
+        "SELECT target_table, target_column, source_table, source_column, kind, expression, recorded_at \
+         FROM _lumos_column_lineage WHERE target_table = ?1 OR source_table = ?1 \
+         ORDER BY target_table, target_column, source_table, source_column",
+        rusqlite::params![table],
+    )?;
+    Ok(rows.into_iter()
+        .map(|row| {
+            let get = |name: &str| row.get(name).cloned().unwrap_or_default();
+            LineageEdge {
+                target_table: get("target_table"),
+                target_column: get("target_column"),
+                source_table: Some(get("source_table")).filter(|t| !t.is_empty()),
+                source_column: get("source_column"),
+                kind: get("kind"),
+                expression: get("expression"),
+                recorded_at: get("recorded_at"),
+            }
+        })
+        .collect())
+}
+
+/// 检查血缘表结构，表结构不一致时重建
+///
+/// 血缘由之后执行的语句重新记录，重建会丢失已有的记录。
+pub fn verify(engine: &SqliteEngine) -> IntegrityFinding {
+    const CHECK: &str = "internal_table:_lumos_column_lineage";
+    let columns = match engine.query_all("SELECT name FROM pragma_table_info('_lumos_column_lineage') ORDER BY cid", &[]) {
+        Ok(rows) => rows.iter().filter_map(|row| row.get("name").cloned()).collect::<Vec<_>>(),
+        Err(e) => return IntegrityFinding::failed(CHECK, format!("failed to read table schema: {}", e)),
+    };
+    if columns.is_empty() || columns == COLUMNS {
+        return IntegrityFinding::ok(CHECK);
+    }
+    let rebuilt = engine.execute("DROP TABLE _lumos_column_lineage", &[])
+        .and_then(|_| engine.execute(SCHEMA, &[]));
+    match rebuilt {
+        Ok(_) => IntegrityFinding::repaired(CHECK, format!("unexpected columns [{}], table rebuilt and previous lineage discarded", columns.join(", "))),
+        Err(e) => IntegrityFinding::failed(CHECK, format!("unexpected columns [{}] and rebuild failed: {}", columns.join(", "), e)),
+    }
+}
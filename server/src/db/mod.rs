@@ -1,6 +1,13 @@
 pub mod executor;
 pub mod vector_executor;
+pub mod replication;
+pub mod lineage;
+pub mod integrity;
 pub mod cached_executor;
+pub mod query_jobs;
+pub mod catalog;
+pub mod pinned;
+pub mod approvals;
 
 pub use executor::DbExecutor;
 pub use vector_executor::VectorExecutor;
@@ -20,6 +27,8 @@ pub mod extensions {
     pub trait DbExecutorExtension {
         fn query(&self, sql: String) -> Result<Vec<serde_json::Value>, String>;
         fn execute_sql(&self, sql: String) -> Result<usize, String>;
+        fn query_with_params(&self, sql: String, params: &[String]) -> Result<Vec<serde_json::Value>, String>;
+        fn execute_sql_with_params(&self, sql: String, params: &[String]) -> Result<usize, String>;
         fn get_tables(&self) -> Result<Vec<String>, String>;
         fn get_table_info(&self, table_name: &str) -> Result<Vec<ColumnInfo>, String>;
     }
@@ -34,6 +43,14 @@ pub mod extensions {
             self.get_ref().execute_sql(&sql).map_err(|e| e.to_string())
         }
         
+        fn query_with_params(&self, sql: String, params: &[String]) -> Result<Vec<serde_json::Value>, String> {
+            self.get_ref().query_with_params(&sql, params).map_err(|e| e.to_string())
+        }
+        
+        fn execute_sql_with_params(&self, sql: String, params: &[String]) -> Result<usize, String> {
+            self.get_ref().execute(&sql, params).map_err(|e| e.to_string())
+        }
+        
         fn get_tables(&self) -> Result<Vec<String>, String> {
             self.get_ref().get_tables().map_err(|e| e.to_string())
         }
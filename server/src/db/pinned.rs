@@ -0,0 +1,123 @@
+use std::sync::{Arc, Mutex};
+use serde::Serialize;
+use lumos_core::LumosError;
+use lumos_core::duckdb::DuckDbEngine;
+use lumos_core::query::{Query, QueryResult, QueryType};
+use lumos_core::query::executor::execute_on_duckdb;
+use lumos_core::query::parser::QueryParser;
+use lumos_core::query::pinned::{PinnedTable, PinnedTableManager};
+use super::DbExecutor;
+
+/// 固定表的状态
+#[derive(Debug, Clone, Serialize)]
+pub struct PinnedTableInfo {
+    pub table: String,
+    /// 副本的行数
+    pub rows: usize,
+    pub columns: Vec<String>,
+    /// 距上次加载的秒数，尚未加载时为空
+    pub loaded_secs_ago: Option<u64>,
+    /// 上次加载后表是否被写入过
+    pub stale: bool,
+}
+
+impl From<PinnedTable> for PinnedTableInfo {
+    fn from(pinned: PinnedTable) -> Self {
+        Self {
+            loaded_secs_ago: pinned.loaded_at.map(|at| at.elapsed().as_secs()),
+            stale: pinned.stale,
+            table: pinned.table,
+            rows: pinned.rows,
+            columns: pinned.columns,
+        }
+    }
+}
+
+/// 固定在内存中的SQLite表
+///
+/// 表被复制到共享DuckDB引擎的临时表中，分析查询读取列式副本。通过
+/// `DbExecutor`的写操作（需要先调用`invalidate_on_write`）使副本过期，
+/// 下次读取该表的分析查询前重新加载。
+pub struct PinnedTables {
+    manager: PinnedTableManager,
+    executor: Arc<DbExecutor>,
+    duckdb: Arc<Mutex<DuckDbEngine>>,
+}
+
+impl PinnedTables {
+    /// 创建固定表管理，单个表最多`max_rows`行
+    pub fn new(executor: Arc<DbExecutor>, duckdb: Arc<Mutex<DuckDbEngine>>, max_rows: usize) -> Self {
+        Self {
+            manager: PinnedTableManager::new(max_rows),
+            executor,
+            duckdb,
+        }
+    }
+
+    /// 数据库执行器的写操作成功后使被写入的表的副本过期
+    pub fn invalidate_on_write(self: &Arc<Self>) {
+        let pinned = Arc::downgrade(self);
+        self.executor.on_write(move |sql| {
+            if let Some(pinned) = pinned.upgrade() {
+                let tables = QueryParser::new().extract_write_tables(sql);
+                if !tables.is_empty() {
+                    pinned.manager.note_write(&tables);
+                }
+            }
+        });
+    }
+
+    /// 固定表并加载副本，已固定的表重新加载
+    pub fn pin(&self, table: &str) -> Result<PinnedTableInfo, LumosError> {
+        let existed = self.manager.get(table).is_some();
+        let entry = self.manager.register(table)?;
+        if let Err(e) = self.load(&entry) {
+            if !existed {
+                self.manager.unregister(table);
+            }
+            return Err(e);
+        }
+        Ok(self.manager.get(table).unwrap_or(entry).into())
+    }
+
+    /// 取消固定并删除副本
+    pub fn unpin(&self, table: &str) -> Result<(), LumosError> {
+        let entry = self.manager.unregister(table)
+            .ok_or_else(|| LumosError::NotFound(format!("Pinned table '{}' not found", table)))?;
+        self.duckdb.lock().unwrap().execute(&format!("DROP TABLE IF EXISTS {}", entry.cache_table))?;
+        Ok(())
+    }
+
+    /// 列出固定表
+    pub fn list(&self) -> Vec<PinnedTableInfo> {
+        self.manager.list().into_iter().map(PinnedTableInfo::from).collect()
+    }
+
+    /// 在DuckDB上执行读取固定表的SELECT，过期的副本先重新加载
+    pub fn query(&self, sql: &str) -> Result<QueryResult, LumosError> {
+        if QueryParser::new().parse_query_type(sql)? != QueryType::Select {
+            return Err(LumosError::InvalidArgument("Only SELECT queries can read pinned tables".to_string()));
+        }
+        let referenced = self.manager.referenced(sql);
+        if referenced.is_empty() {
+            return Err(LumosError::InvalidArgument("Query does not read any pinned table".to_string()));
+        }
+        for table in referenced.iter().filter(|t| !t.is_fresh()) {
+            self.load(table)?;
+        }
+
+        let rewritten = self.manager.rewrite(sql)
+            .ok_or_else(|| LumosError::Internal("Pinned tables were written while reloading, retry the query".to_string()))?;
+        let mut query = Query::new(&rewritten);
+        query.query_type = QueryType::Select;
+        let engine = self.duckdb.lock().unwrap();
+        execute_on_duckdb(&engine, &query)
+    }
+
+    fn load(&self, entry: &PinnedTable) -> Result<(), LumosError> {
+        let engine = self.duckdb.lock().unwrap();
+        let (rows, columns) = self.executor.load_pinned_table(&engine, entry, self.manager.max_rows())?;
+        self.manager.mark_loaded(&entry.table, rows, columns);
+        Ok(())
+    }
+}
@@ -0,0 +1,434 @@
+use std::collections::HashMap;
+use std::fs::{self, File};
+use std::io::{BufRead, BufReader, BufWriter, Write};
+use std::net::{IpAddr, SocketAddr, ToSocketAddrs};
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::Duration;
+use chrono::{DateTime, SecondsFormat, Utc};
+use log::{error, info, warn};
+use serde::Serialize;
+
+use lumos_core::LumosError;
+
+use super::DbExecutor;
+
+/// 默认的查询结果保留时间
+pub const DEFAULT_RETENTION: Duration = Duration::from_secs(24 * 3600);
+
+/// 默认最多同时执行的查询任务数
+pub const DEFAULT_MAX_RUNNING: usize = 4;
+
+/// 回调最多尝试的次数，失败后按1、2、4秒退避
+const CALLBACK_ATTEMPTS: u32 = 4;
+
+/// 回调请求超时
+const CALLBACK_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// 查询任务状态
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum JobStatus {
+    Running,
+    Succeeded,
+    Failed,
+}
+
+/// 回调通知的投递状态
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum CallbackStatus {
+    /// 任务尚未结束
+    Pending,
+    Delivered,
+    /// 所有尝试都失败，结果仍可通过任务ID读取
+    Failed,
+}
+
+/// 查询任务错误
+#[derive(Debug, thiserror::Error)]
+pub enum JobError {
+    #[error("Invalid callback URL '{0}', expected an http or https URL")]
+    InvalidCallback(String),
+    #[error("Callback URL '{0}' resolves to a private, loopback or link-local address")]
+    PrivateCallback(String),
+    #[error("Too many query jobs: {0} are already running")]
+    TooManyJobs(usize),
+    #[error("Query job '{0}' not found")]
+    NotFound(String),
+    #[error("Query job '{0}' has no result: {1}")]
+    NoResult(String, String),
+    #[error("Failed to read query job result: {0}")]
+    Io(#[from] std::io::Error),
+}
+
+/// 异步执行的查询任务
+#[derive(Debug, Clone, Serialize)]
+pub struct QueryJob {
+    pub id: String,
+    pub status: JobStatus,
+    pub sql: String,
+    pub callback_url: String,
+    pub callback: CallbackStatus,
+    pub submitted_at: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub finished_at: Option<String>,
+    /// 结果行数，任务成功后设置
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub rows: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    /// 分页读取结果的地址
+    pub result_url: String,
+    #[serde(skip)]
+    finished: Option<DateTime<Utc>>,
+    /// 提交任务的密钥标识，只有该密钥和管理员可以读取任务
+    #[serde(skip)]
+    owner: String,
+}
+
+impl QueryJob {
+    /// 任务是否对使用`label`密钥的请求可见，管理员可以读取所有任务
+    pub fn visible_to(&self, label: &str, admin: bool) -> bool {
+        admin || self.owner == label
+    }
+}
+
+/// 长时间运行的查询任务，结果写入文件并在完成后回调通知
+///
+/// 提交后立即返回任务ID，查询在后台线程中执行。结果边读取边按行写成JSON
+/// Lines文件，可以分页读取或整体流式下载；任务结束时向回调地址POST任务状态
+/// 和结果地址。回调地址默认不能解析到内网、回环或链路本地地址，投递时不跟随
+/// 重定向。结束的任务和结果文件在保留时间后删除。
+pub struct QueryJobManager {
+    dir: PathBuf,
+    /// 结果地址的前缀，例如`https://db.example.com`，为空时返回相对路径
+    base_url: String,
+    retention: Duration,
+    max_running: usize,
+    /// 是否允许回调内网、回环和链路本地地址
+    allow_private_callbacks: bool,
+    jobs: Mutex<HashMap<String, QueryJob>>,
+}
+
+impl QueryJobManager {
+    /// 创建任务管理器，结果文件保存在`dir`中
+    pub fn new(dir: impl Into<PathBuf>) -> Result<Self, std::io::Error> {
+        let dir = dir.into();
+        fs::create_dir_all(&dir)?;
+        Ok(Self {
+            dir,
+            base_url: String::new(),
+            retention: DEFAULT_RETENTION,
+            max_running: DEFAULT_MAX_RUNNING,
+            allow_private_callbacks: false,
+            jobs: Mutex::new(HashMap::new()),
+        })
+    }
+
+    /// 设置结果地址的前缀
+    pub fn with_base_url(mut self, base_url: impl Into<String>) -> Self {
+        self.base_url = base_url.into().trim_end_matches('/').to_string();
+        self
+    }
+
+    /// 设置结束的任务和结果保留的时间
+    pub fn with_retention(mut self, retention: Duration) -> Self {
+        self.retention = retention;
+        self
+    }
+
+    /// 设置最多同时执行的任务数
+    pub fn with_max_running(mut self, max_running: usize) -> Self {
+        self.max_running = max_running.max(1);
+        self
+    }
+
+    /// 允许回调内网、回环和链路本地地址，用于回调服务与数据库部署在同一内网的场景
+    pub fn with_private_callbacks(mut self, allow: bool) -> Self {
+        self.allow_private_callbacks = allow;
+        self
+    }
+
+    /// 提交查询任务，在后台线程中执行，`owner`是提交请求的密钥标识
+    ///
+    /// 检查回调地址时会同步解析域名，在异步上下文中应通过`web::block`调用。
+    pub fn submit(
+        self: &Arc<Self>,
+        executor: Arc<DbExecutor>,
+        sql: String,
+        params: Vec<String>,
+        callback_url: String,
+        owner: String,
+    ) -> Result<QueryJob, JobError> {
+        resolve_callback(&callback_url, self.allow_private_callbacks)?;
+        self.prune();
+
+        let id = uuid::Uuid::new_v4().to_string();
+        let job = QueryJob {
+            status: JobStatus::Running,
+            sql: sql.clone(),
+            callback_url,
+            callback: CallbackStatus::Pending,
+            submitted_at: Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true),
+            finished_at: None,
+            rows: None,
+            error: None,
+            result_url: format!("{}/api/db/jobs/{}/result", self.base_url, id),
+            finished: None,
+            owner,
+            id: id.clone(),
+        };
+        {
+            let mut jobs = self.jobs.lock().unwrap();
+            let running = jobs.values().filter(|j| j.status == JobStatus::Running).count();
+            if running >= self.max_running {
+                return Err(JobError::TooManyJobs(running));
+            }
+            jobs.insert(id.clone(), job.clone());
+        }
+
+        let manager = self.clone();
+        thread::spawn(move || manager.run(&id, &executor, &sql, &params));
+        info!("Submitted query job {}", job.id);
+        Ok(job)
+    }
+
+    /// 获取任务状态
+    pub fn get(&self, id: &str) -> Option<QueryJob> {
+        self.jobs.lock().unwrap().get(id).cloned()
+    }
+
+    /// 获取使用`label`密钥的请求可见的任务，其他密钥的任务视为不存在
+    pub fn get_for(&self, id: &str, label: &str, admin: bool) -> Option<QueryJob> {
+        self.get(id).filter(|job| job.visible_to(label, admin))
+    }
+
+    /// 从`offset`开始读取最多`limit`行结果
+    pub fn read_page(&self, id: &str, offset: usize, limit: usize) -> Result<Vec<serde_json::Value>, JobError> {
+        let file = File::open(self.result_path(id)?)?;
+        BufReader::new(file)
+            .lines()
+            .skip(offset)
+            .take(limit)
+            .map(|line| {
+                let line = line?;
+                serde_json::from_str(&line).map_err(|e| JobError::Io(e.into()))
+            })
+            .collect()
+    }
+
+    /// 成功任务的结果文件，每行一个JSON对象
+    pub fn result_path(&self, id: &str) -> Result<PathBuf, JobError> {
+        let job = self.get(id).ok_or_else(|| JobError::NotFound(id.to_string()))?;
+        match job.status {
+            JobStatus::Succeeded => Ok(self.file_path(id)),
+            JobStatus::Running => Err(JobError::NoResult(id.to_string(), "the query is still running".to_string())),
+            JobStatus::Failed => Err(JobError::NoResult(id.to_string(), job.error.unwrap_or_default())),
+        }
+    }
+
+    fn run(&self, id: &str, executor: &DbExecutor, sql: &str, params: &[String]) {
+        let path = self.file_path(id);
+        let result = write_rows(&path, executor, sql, params).map_err(|e| e.to_string());
+
+        let job = {
+            let mut jobs = self.jobs.lock().unwrap();
+            let job = match jobs.get_mut(id) {
+                Some(job) => job,
+                None => return,
+            };
+            let now = Utc::now();
+            job.finished = Some(now);
+            job.finished_at = Some(now.to_rfc3339_opts(SecondsFormat::Millis, true));
+            match &result {
+                Ok(rows) => {
+                    job.status = JobStatus::Succeeded;
+                    job.rows = Some(*rows);
+                },
+                Err(e) => {
+                    job.status = JobStatus::Failed;
+                    job.error = Some(e.clone());
+                    let _ = fs::remove_file(&path);
+                },
+            }
+            job.clone()
+        };
+        match &result {
+            Ok(rows) => info!("Query job {} finished with {} rows", id, rows),
+            Err(e) => warn!("Query job {} failed: {}", id, e),
+        }
+
+        let delivered = deliver_callback(&job, self.allow_private_callbacks);
+        if let Some(job) = self.jobs.lock().unwrap().get_mut(id) {
+            job.callback = if delivered { CallbackStatus::Delivered } else { CallbackStatus::Failed };
+        }
+    }
+
+    /// 删除超过保留时间的任务和结果文件
+    fn prune(&self) {
+        let cutoff = match chrono::Duration::from_std(self.retention).ok().and_then(|r| Utc::now().checked_sub_signed(r)) {
+            Some(cutoff) => cutoff,
+            None => return,
+        };
+        let mut jobs = self.jobs.lock().unwrap();
+        let expired: Vec<String> = jobs.values()
+            .filter(|job| job.finished.map_or(false, |finished| finished < cutoff))
+            .map(|job| job.id.clone())
+            .collect();
+        for id in expired {
+            jobs.remove(&id);
+            let _ = fs::remove_file(self.file_path(&id));
+        }
+    }
+
+    fn file_path(&self, id: &str) -> PathBuf {
+        self.dir.join(format!("{}.jsonl", id))
+    }
+}
+
+/// 执行查询并逐行写入结果文件，返回行数
+fn write_rows(path: &Path, executor: &DbExecutor, sql: &str, params: &[String]) -> Result<u64, LumosError> {
+    let mut writer = BufWriter::new(File::create(path)?);
+    let rows = executor.query_each(sql, params, |row| {
+        serde_json::to_writer(&mut writer, &row).map_err(std::io::Error::from)?;
+        writer.write_all(b"\n")?;
+        Ok(())
+    })?;
+    writer.flush()?;
+    Ok(rows)
+}
+
+/// 解析回调地址的主机，除非`allow_private`否则拒绝内网、回环和链路本地地址
+fn resolve_callback(callback_url: &str, allow_private: bool) -> Result<(String, Vec<SocketAddr>), JobError> {
+    let invalid = || JobError::InvalidCallback(callback_url.to_string());
+    let url = reqwest::Url::parse(callback_url).map_err(|_| invalid())?;
+    if !matches!(url.scheme(), "http" | "https") {
+        return Err(invalid());
+    }
+    let host = url.host_str().ok_or_else(invalid)?.to_string();
+    let port = url.port_or_known_default().ok_or_else(invalid)?;
+    let addrs: Vec<SocketAddr> = (host.trim_start_matches('[').trim_end_matches(']'), port)
+        .to_socket_addrs()
+        .map_err(|_| invalid())?
+        .collect();
+    if addrs.is_empty() {
+        return Err(invalid());
+    }
+    if !allow_private && addrs.iter().any(|addr| is_private(addr.ip())) {
+        return Err(JobError::PrivateCallback(callback_url.to_string()));
+    }
+    Ok((host, addrs))
+}
+
+/// 内网、回环、链路本地和未指定地址
+fn is_private(ip: IpAddr) -> bool {
+    match ip {
+        IpAddr::V4(ip) => {
+            let octets = ip.octets();
+            ip.is_private() || ip.is_loopback() || ip.is_link_local() || ip.is_unspecified()
+                || ip.is_broadcast()
+                // 运营商级NAT地址100.64.0.0/10
+                || (octets[0] == 100 && (octets[1] & 0xc0) == 64)
+        },
+        IpAddr::V6(ip) => {
+            if let Some(ip) = ip.to_ipv4_mapped() {
+                return is_private(IpAddr::V4(ip));
+            }
+            let first = ip.segments()[0];
+            ip.is_loopback() || ip.is_unspecified()
+                // 唯一本地地址fc00::/7和链路本地地址fe80::/10
+                || (first & 0xfe00) == 0xfc00
+                || (first & 0xffc0) == 0xfe80
+        },
+    }
+}
+
+/// 向回调地址POST任务状态，失败时退避重试
+///
+/// 投递前重新解析并检查地址，请求固定使用检查过的地址，不跟随重定向。
+fn deliver_callback(job: &QueryJob, allow_private: bool) -> bool {
+    let (host, addrs) = match resolve_callback(&job.callback_url, allow_private) {
+        Ok(resolved) => resolved,
+        Err(e) => {
+            error!("Not delivering callback for query job {}: {}", job.id, e);
+            return false;
+        }
+    };
+    let client = match reqwest::blocking::Client::builder()
+        .timeout(CALLBACK_TIMEOUT)
+        .redirect(reqwest::redirect::Policy::none())
+        .resolve(&host, addrs[0])
+        .build()
+    {
+        Ok(client) => client,
+        Err(e) => {
+            error!("Failed to create callback client: {}", e);
+            return false;
+        }
+    };
+
+    for attempt in 0..CALLBACK_ATTEMPTS {
+        if attempt > 0 {
+            thread::sleep(Duration::from_secs(1 << (attempt - 1)));
+        }
+        match client.post(&job.callback_url).json(job).send() {
+            Ok(response) if response.status().is_success() => return true,
+            Ok(response) => warn!("Callback for query job {} returned {}", job.id, response.status()),
+            Err(e) => warn!("Callback for query job {} failed: {}", job.id, e),
+        }
+    }
+    error!("Giving up on callback for query job {} after {} attempts", job.id, CALLBACK_ATTEMPTS);
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_query_job_result_pages() {
+        let base = std::env::temp_dir().join(format!("lumos_jobs_{}", std::process::id()));
+        let _ = fs::remove_dir_all(&base);
+        let executor = Arc::new(DbExecutor::new(base.with_extension("db")).unwrap());
+        executor.execute("CREATE TABLE t (id INTEGER)", &[]).unwrap();
+        for i in 0..5 {
+            executor.execute("INSERT INTO t (id) VALUES (?1)", &[i.to_string()]).unwrap();
+        }
+
+        let manager = Arc::new(QueryJobManager::new(&base).unwrap().with_max_running(1));
+        assert!(matches!(
+            manager.submit(executor.clone(), "SELECT 1".into(), vec![], "ftp://example.com".into(), "app-0".into()),
+            Err(JobError::InvalidCallback(_))
+        ));
+        for url in ["http://127.0.0.1:9/done", "http://10.0.0.1/done", "http://169.254.169.254/latest", "http://[::1]/done"] {
+            assert!(matches!(
+                manager.submit(executor.clone(), "SELECT 1".into(), vec![], url.into(), "app-0".into()),
+                Err(JobError::PrivateCallback(_))
+            ), "{}", url);
+        }
+        let manager = Arc::new(QueryJobManager::new(&base).unwrap().with_max_running(1).with_private_callbacks(true));
+
+        // 回调地址不可达，结果仍然可以读取
+        let job = manager.submit(executor.clone(), "SELECT id FROM t ORDER BY id".into(), vec![], "http://127.0.0.1:9/done".into(), "app-0".into()).unwrap();
+        assert_eq!(job.result_url, format!("/api/db/jobs/{}/result", job.id));
+        while manager.get(&job.id).unwrap().status == JobStatus::Running {
+            thread::sleep(Duration::from_millis(5));
+        }
+        let finished = manager.get(&job.id).unwrap();
+        assert_eq!((finished.status, finished.rows), (JobStatus::Succeeded, Some(5)));
+        let page = manager.read_page(&job.id, 3, 10).unwrap();
+        assert_eq!(page.len(), 2);
+        assert_eq!(page[0]["id"], "3");
+
+        // 其他密钥读取不到任务，包括只读密钥；提交者和管理员可以读取
+        assert!(manager.get_for(&job.id, "app-1", false).is_none());
+        assert!(manager.get_for(&job.id, "read-only-0", false).is_none());
+        assert!(manager.get_for(&job.id, "app-0", false).is_some());
+        assert!(manager.get_for(&job.id, "admin", true).is_some());
+
+        let _ = fs::remove_dir_all(&base);
+        let _ = fs::remove_file(base.with_extension("db"));
+    }
+}
@@ -0,0 +1,482 @@
+//! 向量集合的异步复制
+//!
+//! 主实例把选定集合的写入记录到变更队列，后台线程按批推送到远程实例的
+//! `/api/vector/replication/apply`。副本按每个向量的更新时间做最后写入获胜
+//! （LWW）合并，重复推送或乱序到达的批次不会覆盖更新的数据。主实例和副本上
+//! 重复添加同一ID都会替换原有向量。副本逐条应用变更，无法应用的条目（例如维度
+//! 不一致）被拒绝并返回给主实例，主实例记录后确认，不会反复重试同一批。副本
+//! 拒绝客户端写入，主实例故障时可以通过`/api/vector/replication/promote`手动提升。
+//!
+//! 删除集合也会记录为变更，副本按序号顺序应用，删除副本上的同名集合。单个
+//! 向量没有删除接口，因此不会产生删除变更。只复制向量集合：本实例没有记忆
+//! 命名空间，需要复制的记忆数据应存放在向量集合中。
+//!
+//! 变更队列只保存在内存中：主实例重启时未推送的变更会丢失，`dropped`不会
+//! 反映这部分变更，重启后需要对副本重新全量同步复制的集合。
+
+use std::collections::VecDeque;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+use chrono::{DateTime, Utc};
+use log::{info, warn, error};
+use serde::{Serialize, Deserialize};
+
+/// 每次推送的最大条目数
+const PUSH_BATCH_SIZE: usize = 500;
+
+/// 变更队列默认最多保留的条目数，超过后丢弃最早的条目
+pub const DEFAULT_MAX_PENDING: usize = 100_000;
+
+/// 实例在复制中的角色
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum ReplicationRole {
+    /// 接受客户端写入，并把变更推送到副本
+    Primary,
+    /// 只接受复制写入，提升后成为主实例
+    Replica,
+}
+
+impl ReplicationRole {
+    /// 解析角色名称
+    pub fn parse(value: &str) -> Option<Self> {
+        match value.trim().to_lowercase().as_str() {
+            "primary" => Some(ReplicationRole::Primary),
+            "replica" => Some(ReplicationRole::Replica),
+            _ => None,
+        }
+    }
+}
+
+/// 变更的种类
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum ReplicationOp {
+    /// 添加或替换单个向量
+    Upsert,
+    /// 删除整个集合，`id`和`vector`为空
+    DeleteCollection,
+}
+
+impl Default for ReplicationOp {
+    fn default() -> Self {
+        ReplicationOp::Upsert
+    }
+}
+
+/// 单个向量或集合的变更
+#[derive(Clone, Debug, Serialize, Deserialize)]
+pub struct ReplicationEntry {
+    /// 主实例分配的序号，单调递增
+    pub seq: u64,
+    #[serde(default)]
+    pub op: ReplicationOp,
+    pub collection: String,
+    pub dimension: usize,
+    pub id: String,
+    pub vector: Vec<f32>,
+    #[serde(default)]
+    pub metadata: Option<serde_json::Value>,
+    /// 写入时间（毫秒时间戳），用于最后写入获胜
+    pub updated_at: i64,
+}
+
+/// 副本应用一批变更的结果
+#[derive(Clone, Debug, Default, Serialize, Deserialize)]
+pub struct ApplyResult {
+    /// 写入的条目数
+    pub applied: usize,
+    /// 因副本上的数据更新而跳过的条目数
+    pub skipped: usize,
+    /// 无法应用而被拒绝的条目数，主实例确认后不再重试
+    #[serde(default)]
+    pub rejected: usize,
+    /// 被拒绝条目的序号和原因
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub errors: Vec<String>,
+}
+
+/// 复制状态
+#[derive(Clone, Debug, Serialize)]
+pub struct ReplicationStatus {
+    pub role: ReplicationRole,
+    /// 推送目标，未配置时不推送
+    pub target: Option<String>,
+    /// 复制的集合，`*`表示所有集合
+    pub collections: Vec<String>,
+    /// 等待推送的条目数
+    pub pending: usize,
+    /// 最后一个已确认的序号
+    pub acked_seq: u64,
+    /// 最早一条未确认变更的等待时间（毫秒），队列为空时为0
+    pub lag_ms: i64,
+    /// 因队列已满丢弃的条目数，不为0时副本需要重新全量同步
+    pub dropped: u64,
+    pub last_success: Option<DateTime<Utc>>,
+    pub last_error: Option<String>,
+}
+
+#[derive(Default)]
+struct LogState {
+    queue: VecDeque<ReplicationEntry>,
+    next_seq: u64,
+    acked_seq: u64,
+    dropped: u64,
+    last_success: Option<DateTime<Utc>>,
+    last_error: Option<String>,
+}
+
+/// 复制变更队列和实例角色
+pub struct ReplicationLog {
+    role: Mutex<ReplicationRole>,
+    target: Option<String>,
+    collections: Vec<String>,
+    max_pending: usize,
+    state: Mutex<LogState>,
+}
+
+impl Default for ReplicationLog {
+    fn default() -> Self {
+        Self::new(ReplicationRole::Primary, None, Vec::new())
+    }
+}
+
+impl ReplicationLog {
+    /// 创建变更队列，`collections`为空时不记录变更
+    pub fn new(role: ReplicationRole, target: Option<String>, collections: Vec<String>) -> Self {
+        Self {
+            role: Mutex::new(role),
+            target,
+            collections,
+            max_pending: DEFAULT_MAX_PENDING,
+            state: Mutex::new(LogState { next_seq: 1, ..LogState::default() }),
+        }
+    }
+
+    /// 设置变更队列最多保留的条目数
+    pub fn with_max_pending(mut self, max_pending: usize) -> Self {
+        self.max_pending = max_pending.max(1);
+        self
+    }
+
+    /// 当前角色
+    pub fn role(&self) -> ReplicationRole {
+        *self.role.lock().unwrap()
+    }
+
+    /// 是否为副本
+    pub fn is_replica(&self) -> bool {
+        self.role() == ReplicationRole::Replica
+    }
+
+    /// 切换角色，返回之前的角色
+    pub fn set_role(&self, role: ReplicationRole) -> ReplicationRole {
+        let previous = std::mem::replace(&mut *self.role.lock().unwrap(), role);
+        if previous != role {
+            info!("Replication role changed from {:?} to {:?}", previous, role);
+        }
+        previous
+    }
+
+    /// 集合的写入是否需要复制
+    pub fn replicates(&self, collection: &str) -> bool {
+        self.target.is_some()
+            && self.collections.iter().any(|c| c == "*" || c == collection)
+    }
+
+    /// 记录主实例上的写入
+    pub fn record(
+        &self,
+        collection: &str,
+        dimension: usize,
+        ids: &[String],
+        embeddings: &[Vec<f32>],
+        metadata: &[Option<serde_json::Value>],
+        updated_at: i64,
+    ) {
+        if self.is_replica() || !self.replicates(collection) {
+            return;
+        }
+
+        let mut state = self.state.lock().unwrap();
+        for (i, (id, vector)) in ids.iter().zip(embeddings).enumerate() {
+            let seq = state.next_seq;
+            state.next_seq += 1;
+            state.queue.push_back(ReplicationEntry {
+                seq,
+                op: ReplicationOp::Upsert,
+                collection: collection.to_string(),
+                dimension,
+                id: id.clone(),
+                vector: vector.clone(),
+                metadata: metadata.get(i).cloned().flatten(),
+                updated_at,
+            });
+        }
+        self.trim(&mut state);
+    }
+
+    /// 记录主实例上删除集合
+    pub fn record_delete(&self, collection: &str, deleted_at: i64) {
+        if self.is_replica() || !self.replicates(collection) {
+            return;
+        }
+
+        let mut state = self.state.lock().unwrap();
+        let seq = state.next_seq;
+        state.next_seq += 1;
+        state.queue.push_back(ReplicationEntry {
+            seq,
+            op: ReplicationOp::DeleteCollection,
+            collection: collection.to_string(),
+            dimension: 0,
+            id: String::new(),
+            vector: Vec::new(),
+            metadata: None,
+            updated_at: deleted_at,
+        });
+        self.trim(&mut state);
+    }
+
+    /// 队列超过上限时丢弃最早的条目
+    fn trim(&self, state: &mut LogState) {
+        let overflow = state.queue.len().saturating_sub(self.max_pending);
+        if overflow > 0 {
+            state.queue.drain(..overflow);
+            state.dropped += overflow as u64;
+            warn!("Replication queue full, dropped {} oldest entries; replica needs a full resync", overflow);
+        }
+    }
+
+    /// 取出下一批待推送的条目，推送成功前不从队列中移除
+    pub fn next_batch(&self, limit: usize) -> Vec<ReplicationEntry> {
+        let state = self.state.lock().unwrap();
+        state.queue.iter().take(limit).cloned().collect()
+    }
+
+    /// 确认序号及之前的条目已经推送
+    pub fn ack(&self, seq: u64) {
+        let mut state = self.state.lock().unwrap();
+        while state.queue.front().map_or(false, |entry| entry.seq <= seq) {
+            state.queue.pop_front();
+        }
+        state.acked_seq = state.acked_seq.max(seq);
+        state.last_success = Some(Utc::now());
+        state.last_error = None;
+    }
+
+    /// 记录推送失败
+    pub fn fail(&self, message: String) {
+        self.state.lock().unwrap().last_error = Some(message);
+    }
+
+    /// 复制状态
+    pub fn status(&self) -> ReplicationStatus {
+        let state = self.state.lock().unwrap();
+        let lag_ms = state.queue.front()
+            .map_or(0, |entry| (Utc::now().timestamp_millis() - entry.updated_at).max(0));
+        ReplicationStatus {
+            role: self.role(),
+            target: self.target.clone(),
+            collections: self.collections.clone(),
+            pending: state.queue.len(),
+            acked_seq: state.acked_seq,
+            lag_ms,
+            dropped: state.dropped,
+            last_success: state.last_success,
+            last_error: state.last_error.clone(),
+        }
+    }
+
+    /// Prometheus格式的复制指标
+    pub fn render_prometheus(&self, prefix: &str) -> String {
+        let status = self.status();
+        format!(
+            "# HELP {p}_replication_pending Entries waiting to be pushed to the replica\n\
+             # TYPE {p}_replication_pending gauge\n\
+             {p}_replication_pending {}\n\
+             # HELP {p}_replication_lag_seconds Age of the oldest unacknowledged entry\n\
+             # TYPE {p}_replication_lag_seconds gauge\n\
+             {p}_replication_lag_seconds {}\n\
+             # HELP {p}_replication_dropped_total Entries dropped because the queue was full\n\
+             # TYPE {p}_replication_dropped_total counter\n\
+             {p}_replication_dropped_total {}\n",
+            status.pending,
+            status.lag_ms as f64 / 1000.0,
+            status.dropped,
+            p = prefix,
+        )
+    }
+}
+
+/// 在后台线程中按间隔把变更推送到目标实例
+pub fn spawn_pusher(log: Arc<ReplicationLog>, api_key: Option<String>, interval: Duration) {
+    let target = match &log.target {
+        Some(target) => target.trim_end_matches('/').to_string(),
+        None => return,
+    };
+    let url = format!("{}/api/vector/replication/apply", target);
+    info!("Replicating vector collections {:?} to {}", log.collections, target);
+
+    std::thread::spawn(move || {
+        let client = match reqwest::blocking::Client::builder().timeout(Duration::from_secs(30)).build() {
+            Ok(client) => client,
+            Err(e) => {
+                error!("Failed to create replication client: {}", e);
+                return;
+            }
+        };
+
+        loop {
+            std::thread::sleep(interval);
+            if log.is_replica() {
+                continue;
+            }
+            // 一次把积压的变更推送完，失败时等下一个间隔重试
+            loop {
+                let batch = log.next_batch(PUSH_BATCH_SIZE);
+                let last_seq = match batch.last() {
+                    Some(entry) => entry.seq,
+                    None => break,
+                };
+                match push_batch(&client, &url, api_key.as_deref(), &batch) {
+                    Ok(result) => {
+                        if result.rejected > 0 {
+                            warn!(
+                                "Replica rejected {} of {} entries, they will not be retried: {}",
+                                result.rejected, batch.len(), result.errors.join("; ")
+                            );
+                        }
+                        log.ack(last_seq);
+                    },
+                    Err(e) => {
+                        error!("Failed to push {} replication entries to {}: {}", batch.len(), target, e);
+                        log.fail(e);
+                        break;
+                    }
+                }
+            }
+        }
+    });
+}
+
+fn push_batch(client: &reqwest::blocking::Client, url: &str, api_key: Option<&str>, batch: &[ReplicationEntry]) -> Result<ApplyResult, String> {
+    let mut request = client.post(url).json(&serde_json::json!({ "entries": batch }));
+    if let Some(key) = api_key {
+        request = request.header("X-API-Key", key);
+    }
+    let response = request.send().map_err(|e| e.to_string())?;
+    if !response.status().is_success() {
+        let status = response.status();
+        let body = response.text().unwrap_or_default();
+        return Err(format!("replica responded with {}: {}", status, body));
+    }
+    let body: serde_json::Value = response.json().map_err(|e| e.to_string())?;
+    serde_json::from_value(body["data"].clone()).map_err(|e| format!("unexpected replica response: {}", e))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_replication_log_queue() {
+        let log = ReplicationLog::new(ReplicationRole::Primary, Some("http://replica:8080".into()), vec!["docs".into()])
+            .with_max_pending(3);
+        let ids = vec!["a".to_string(), "b".to_string()];
+        let vectors = vec![vec![1.0, 0.0], vec![0.0, 1.0]];
+
+        log.record("docs", 2, &ids, &vectors, &[], 1);
+        log.record("other", 2, &ids, &vectors, &[], 1);
+        assert_eq!(log.status().pending, 2);
+
+        log.record("docs", 2, &ids, &vectors, &[], 2);
+        let status = log.status();
+        assert_eq!((status.pending, status.dropped), (3, 1));
+
+        let batch = log.next_batch(2);
+        assert_eq!(batch.iter().map(|e| e.seq).collect::<Vec<_>>(), vec![2, 3]);
+        log.ack(3);
+        assert_eq!((log.status().pending, log.status().acked_seq), (1, 3));
+
+        // 副本不记录变更
+        log.set_role(ReplicationRole::Replica);
+        log.record("docs", 2, &ids, &vectors, &[], 3);
+        log.record_delete("docs", 3);
+        assert_eq!(log.status().pending, 1);
+
+        log.set_role(ReplicationRole::Primary);
+        log.record_delete("docs", 4);
+        log.record_delete("other", 4);
+        let batch = log.next_batch(10);
+        assert_eq!(batch.len(), 2);
+        assert_eq!((batch[1].op, batch[1].seq), (ReplicationOp::DeleteCollection, 5));
+    }
+
+    fn entry(seq: u64, id: &str, value: f32, updated_at: i64) -> ReplicationEntry {
+        ReplicationEntry {
+            seq,
+            op: ReplicationOp::Upsert,
+            collection: "docs".to_string(),
+            dimension: 2,
+            id: id.to_string(),
+            vector: vec![value, 0.0],
+            metadata: Some(serde_json::json!({ "v": value })),
+            updated_at,
+        }
+    }
+
+    #[test]
+    fn test_apply_last_write_wins() {
+        let base = std::env::temp_dir().join(format!("lumos_replication_{}.db", std::process::id()));
+        let replica = crate::db::VectorExecutor::new(&base).unwrap()
+            .with_replication(Arc::new(ReplicationLog::new(ReplicationRole::Replica, None, Vec::new())));
+
+        let result = replica.apply_replicated(vec![entry(1, "a", 1.0, 10), entry(2, "b", 2.0, 10)]).unwrap();
+        assert_eq!((result.applied, result.skipped), (2, 0));
+
+        // 乱序到达的旧变更被跳过，新的变更覆盖原有向量
+        let result = replica.apply_replicated(vec![entry(3, "a", 3.0, 20), entry(1, "a", 1.0, 10)]).unwrap();
+        assert_eq!((result.applied, result.skipped), (1, 1));
+
+        let collection = replica.list_collections().unwrap().pop().unwrap();
+        assert_eq!(collection.count, 2);
+        assert_eq!(collection.embeddings[0], vec![3.0, 0.0]);
+        assert_eq!(collection.metadata["a"], serde_json::json!({ "v": 3.0 }));
+
+        // 维度不一致的条目被拒绝，同一批的其他条目照常应用
+        let mut poison = entry(4, "c", 4.0, 30);
+        poison.vector.push(0.0);
+        let result = replica.apply_replicated(vec![poison, entry(5, "d", 5.0, 30)]).unwrap();
+        assert_eq!((result.applied, result.rejected), (1, 1));
+
+        // 副本拒绝客户端写入，提升后恢复；重复添加同一ID替换原有向量
+        assert!(replica.add_embeddings("docs", vec!["c".into()], vec![vec![0.0, 1.0]], None).is_err());
+        assert!(replica.delete_collection("docs").is_err());
+        replica.replication().set_role(ReplicationRole::Primary);
+        assert!(replica.add_embeddings("docs", vec!["a".into()], vec![vec![0.0, 1.0]], None).is_ok());
+        let collection = replica.list_collections().unwrap().pop().unwrap();
+        assert_eq!(collection.count, 3);
+        assert_eq!(collection.embeddings[0], vec![0.0, 1.0]);
+        assert!(!collection.metadata.contains_key("a"));
+
+        // 删除集合按序号顺序应用，之后的变更重新创建集合
+        let delete = ReplicationEntry {
+            op: ReplicationOp::DeleteCollection,
+            dimension: 0,
+            id: String::new(),
+            vector: Vec::new(),
+            metadata: None,
+            ..entry(6, "", 0.0, 40)
+        };
+        let result = replica.apply_replicated(vec![delete.clone(), entry(7, "e", 1.0, 50)]).unwrap();
+        assert_eq!((result.applied, result.skipped), (2, 0));
+        let collection = replica.list_collections().unwrap().pop().unwrap();
+        assert_eq!(collection.ids, vec!["e".to_string()]);
+        replica.delete_collection("docs").unwrap();
+        let result = replica.apply_replicated(vec![delete]).unwrap();
+        assert_eq!((result.applied, result.skipped), (0, 1));
+
+        let _ = std::fs::remove_dir_all(format!("{}.collections", base.display()));
+    }
+}
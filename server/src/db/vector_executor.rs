@@ -1,21 +1,135 @@
 use std::collections::HashMap;
-use std::path::Path;
+use std::fs;
+use std::io::Write;
+use std::path::{Path, PathBuf};
 use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::Instant;
 use ndarray::{Array1, Array2};
 use serde::{Serialize, Deserialize};
-use log::{info, error, debug};
+use log::{info, warn, error, debug};
 
 use lumos_core::LumosError;
 use lumos_core::duckdb::DuckDbEngine;
-use lumos_core::vector::{Embedding as LumosEmbedding, VectorStore, distance::DistanceMetric, index::{VectorIndex, IndexType}};
+use lumos_core::vector::{Embedding as LumosEmbedding, VectorStore, distance::DistanceMetric, index::{VectorIndex, IndexType, PartitionedVectorIndex}};
 
 use crate::models::vector::{Embedding, SearchResult as ModelSearchResult};
+use crate::utils::access_stats::AccessStats;
+use crate::utils::integrity::IntegrityFinding;
+use crate::utils::memory_budget::MemoryBudget;
+use super::replication::{ReplicationLog, ReplicationEntry, ReplicationOp, ApplyResult};
+
+/// 导出时报告进度的间隔（向量数）
+const EXPORT_PROGRESS_INTERVAL: usize = 1000;
+
+/// 估算内存占用时每条元数据的字节数
+const METADATA_ENTRY_BYTES: usize = 64;
+
+/// 常驻集合在内存预算中的子系统名
+pub const MEMORY_SUBSYSTEM: &str = "vector_collections";
+
+/// 构建索引时更新进度的间隔（向量数）
+const INDEX_PROGRESS_INTERVAL: usize = 1000;
+
+/// 分块上传在最后一个分块后保留的时间，过期后无法续传
+const UPLOAD_TTL: std::time::Duration = std::time::Duration::from_secs(3600);
+
+/// 客户端生成的上传ID的最大长度
+const MAX_UPLOAD_ID_LEN: usize = 128;
+
+/// 训练分区中心时最多使用的样本数
+const INDEX_TRAINING_SAMPLE: usize = 10_000;
+
+/// 索引构建状态
+#[derive(Clone, Debug, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "snake_case")]
+pub enum IndexBuildState {
+    Building,
+    Ready,
+    Failed,
+}
+
+/// 索引构建任务的进度
+#[derive(Clone, Debug, Serialize, Deserialize)]
+pub struct IndexBuildStatus {
+    pub collection: String,
+    pub index_type: String,
+    pub state: IndexBuildState,
+    pub processed: usize,
+    pub total: usize,
+    pub elapsed_ms: u64,
+    pub error: Option<String>,
+    /// 构建开始后集合被写入过，索引不包含这些写入，搜索回退为精确搜索，
+    /// 需要重新创建索引
+    #[serde(default)]
+    pub stale: bool,
+}
+
+/// 分块上传的确认信息
+#[derive(Clone, Debug, Serialize, Deserialize)]
+pub struct ChunkAck {
+    pub upload_id: String,
+    pub collection: String,
+    /// 本次确认的分块序号
+    pub chunk_index: usize,
+    /// 本次分块添加的向量数，重复发送的分块为0
+    pub added: usize,
+    /// 下一个应发送的分块序号，续传时从这里开始
+    pub next_chunk: usize,
+    /// 整个上传已添加的向量数
+    pub total_added: usize,
+}
+
+/// 进行中的分块上传
+struct ChunkedUpload {
+    collection: String,
+    next_chunk: usize,
+    total_added: usize,
+    updated: Instant,
+    // 正在写入分块，写入时不持有上传锁
+    writing: bool,
+}
+
+/// 构建完成的近似索引
+struct BuiltIndex {
+    index: PartitionedVectorIndex,
+    // 向量ID在集合中的位置
+    positions: HashMap<String, usize>,
+    // 构建时集合中的向量数，集合变化后索引不再使用
+    count: usize,
+}
 
 /// 向量执行器结构
+///
+/// 配置常驻内存上限后，最久未使用的集合会被写入磁盘并从内存中卸载，
+/// 下次访问时自动加载。配置内存预算后，常驻集合占用的内存从预算中申请，
+/// 预算不足时先卸载最久未使用的集合，仍不足时拒绝写入或加载。
 pub struct VectorExecutor {
     base_path: String,
     // 内存中向量存储，实际项目中可能需要持久化
     collections: Arc<Mutex<HashMap<String, VectorCollection>>>,
+    // 已卸载到磁盘的集合，只保留不含向量的摘要；仅在持有collections锁时访问
+    unloaded: Mutex<HashMap<String, VectorCollection>>,
+    // 集合最后访问时间，用于选择卸载的集合；仅在持有collections锁时访问
+    last_access: Mutex<HashMap<String, Instant>>,
+    // 单个集合的内存上限（字节）
+    max_collection_bytes: Option<usize>,
+    // 所有常驻集合的内存上限（字节）
+    max_resident_bytes: Option<usize>,
+    // 进程级内存预算
+    memory_budget: Option<Arc<MemoryBudget>>,
+    // 已从内存预算申请的字节数；仅在持有collections锁时访问
+    budget_reserved: Mutex<usize>,
+    // 已构建的近似索引，构建完成后整体替换
+    indexes: Arc<Mutex<HashMap<String, Arc<BuiltIndex>>>>,
+    // 后台索引构建任务
+    index_builds: Arc<Mutex<HashMap<String, IndexBuildStatus>>>,
+    // 进行中的分块上传
+    uploads: Mutex<HashMap<String, ChunkedUpload>>,
+    // 按集合的访问统计
+    access_stats: AccessStats,
+    // 复制变更队列和实例角色
+    replication: Arc<ReplicationLog>,
 }
 
 /// 向量集合结构
@@ -29,6 +143,9 @@ pub struct VectorCollection {
     pub metadata: HashMap<String, serde_json::Value>,
     pub indexed: bool,
     pub index_type: Option<String>,
+    /// 每个向量的最后写入时间（毫秒时间戳），复制时用于最后写入获胜
+    #[serde(default)]
+    pub updated_at: HashMap<String, i64>,
 }
 
 /// 搜索结果结构
@@ -38,6 +155,40 @@ pub struct VectorSearchResult {
     pub score: f32,
     pub vector: Option<Vec<f32>>,
     pub metadata: Option<serde_json::Value>,
+    /// 评分说明，仅在搜索时要求explain才返回
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub explanation: Option<SearchExplanation>,
+}
+
+/// 单个搜索结果的评分说明
+#[derive(Clone, Debug, Serialize, Deserialize)]
+pub struct SearchExplanation {
+    /// 在本次搜索结果中的名次，从1开始
+    pub rank: usize,
+    /// 评分方式：exact（与所有向量逐个比较）或index（近似索引，只比较最近分区中的向量）
+    pub method: String,
+    /// 使用的索引类型
+    pub index_type: Option<String>,
+    /// 距离度量
+    pub metric: String,
+    /// 余弦相似度，即score
+    pub similarity: f32,
+    /// 余弦距离（1 - similarity）
+    pub distance: f32,
+    /// 查询向量的模
+    pub query_norm: f32,
+    /// 结果向量的模，接近0的向量相似度没有意义
+    pub vector_norm: f32,
+    /// 对相似度贡献最大的维度（按贡献绝对值排序）
+    pub top_dimensions: Vec<DimensionContribution>,
+}
+
+/// 单个维度对余弦相似度的贡献
+#[derive(Clone, Debug, Serialize, Deserialize)]
+pub struct DimensionContribution {
+    pub dimension: usize,
+    /// 该维度在归一化后的点积中的分量，所有维度之和等于相似度
+    pub contribution: f32,
 }
 
 impl VectorExecutor {
@@ -49,14 +200,177 @@ impl VectorExecutor {
         Ok(Self {
             base_path,
             collections,
+            unloaded: Mutex::new(HashMap::new()),
+            last_access: Mutex::new(HashMap::new()),
+            max_collection_bytes: None,
+            max_resident_bytes: None,
+            memory_budget: None,
+            budget_reserved: Mutex::new(0),
+            indexes: Arc::new(Mutex::new(HashMap::new())),
+            index_builds: Arc::new(Mutex::new(HashMap::new())),
+            uploads: Mutex::new(HashMap::new()),
+            access_stats: AccessStats::new(),
+            replication: Arc::new(ReplicationLog::default()),
         })
     }
     
+    /// 设置复制变更队列，选定集合的写入会推送到副本
+    pub fn with_replication(mut self, replication: Arc<ReplicationLog>) -> Self {
+        self.replication = replication;
+        self
+    }
+    
+    /// 复制变更队列和实例角色
+    pub fn replication(&self) -> &Arc<ReplicationLog> {
+        &self.replication
+    }
+    
+    /// 按集合的访问统计
+    pub fn access_stats(&self) -> &AccessStats {
+        &self.access_stats
+    }
+    
+    /// 记录一次集合访问，只统计存在的集合，避免不存在的集合名占用统计
+    pub fn record_access(&self, collection_name: &str, operation: &str, elapsed: std::time::Duration, items: usize, success: bool) {
+        let exists = self.collections.lock().unwrap().contains_key(collection_name)
+            || self.unloaded.lock().unwrap().contains_key(collection_name);
+        if exists {
+            self.access_stats.record(collection_name, operation, elapsed, items, success);
+        }
+    }
+    
+    /// 设置单个集合的内存上限，超过时拒绝添加向量
+    pub fn with_collection_memory_cap(mut self, bytes: usize) -> Self {
+        self.max_collection_bytes = Some(bytes);
+        self
+    }
+    
+    /// 设置常驻内存上限，超过时将最久未使用的集合卸载到磁盘
+    pub fn with_resident_memory_cap(mut self, bytes: usize) -> Self {
+        self.max_resident_bytes = Some(bytes);
+        self
+    }
+    
+    /// 从进程级内存预算中申请常驻集合占用的内存
+    pub fn with_memory_budget(mut self, budget: Arc<MemoryBudget>) -> Self {
+        self.memory_budget = Some(budget);
+        self
+    }
+    
+    /// 卸载最久未使用的集合以释放至少`bytes`字节，返回释放的字节数
+    ///
+    /// 作为内存预算的回收函数，由其他子系统的申请触发。
+    pub fn reclaim_memory(&self, bytes: usize) -> usize {
+        let mut collections = self.collections.lock().unwrap();
+        self.unload_coldest(&mut collections, bytes, None)
+    }
+    
+    /// 预先加载集合，返回集合此前是否已卸载到磁盘
+    pub fn warm_collection(&self, name: &str) -> Result<bool, String> {
+        let mut collections = self.collections.lock().unwrap();
+        let was_unloaded = !collections.contains_key(name);
+        self.ensure_loaded(&mut collections, name)?;
+        Ok(was_unloaded)
+    }
+    
+    /// 将集合写入磁盘并从内存中卸载
+    pub fn unload_collection(&self, name: &str) -> Result<(), String> {
+        let mut collections = self.collections.lock().unwrap();
+        self.unload(&mut collections, name)
+    }
+    
+    /// 检查上次运行卸载到磁盘的集合文件，修复后登记为已卸载的集合
+    ///
+    /// ID、向量和数量不一致的集合会被修正并写回；无法解析的文件改名为
+    /// `.corrupt`保留。集合只登记摘要而不加载，近似索引在集合第一次加载时
+    /// 在后台重建。
+    pub fn verify_spilled_collections(&self) -> Vec<IntegrityFinding> {
+        let dir = PathBuf::from(format!("{}.collections", self.base_path));
+        let entries = match fs::read_dir(&dir) {
+            Ok(entries) => entries,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Vec::new(),
+            Err(e) => return vec![IntegrityFinding::failed("vector_collections", format!("Failed to read {}: {}", dir.display(), e))],
+        };
+        
+        let mut findings = Vec::new();
+        // 先列出全部文件，迁移改名的文件不会被再次读取
+        let paths: Vec<PathBuf> = entries
+            .filter_map(|entry| entry.ok().map(|e| e.path()))
+            .filter(|path| path.extension().map_or(false, |ext| ext == "json"))
+            .collect();
+        for path in paths {
+            let parsed = fs::read(&path)
+                .map_err(|e| e.to_string())
+                .and_then(|data| serde_json::from_slice::<VectorCollection>(&data).map_err(|e| e.to_string()));
+            let mut collection = match parsed {
+                Ok(collection) => collection,
+                Err(e) => {
+                    let check = format!("vector_collection_file:{}", path.display());
+                    let corrupt = path.with_extension("json.corrupt");
+                    findings.push(match fs::rename(&path, &corrupt) {
+                        Ok(()) => IntegrityFinding::failed(check, format!("unreadable ({}), moved to {}", e, corrupt.display())),
+                        Err(rename_error) => IntegrityFinding::failed(check, format!("unreadable ({}), moving it aside failed: {}", e, rename_error)),
+                    });
+                    continue;
+                }
+            };
+            
+            let check = format!("vector_collection:{}", collection.name);
+            let mut path = path;
+            let spill_path = self.spill_path(&collection.name);
+            if spill_path != path {
+                if self.legacy_spill_path(&collection.name) != path || spill_path.exists() {
+                    findings.push(IntegrityFinding::failed(check, format!("{} does not belong to this collection", path.display())));
+                    continue;
+                }
+                if let Err(e) = fs::rename(&path, &spill_path) {
+                    findings.push(IntegrityFinding::failed(check, format!("moving {} to {} failed: {}", path.display(), spill_path.display(), e)));
+                    continue;
+                }
+                path = spill_path;
+            }
+            
+            let problems = reconcile_collection(&mut collection);
+            if problems.is_empty() {
+                findings.push(IntegrityFinding::ok(check));
+            } else {
+                let written = serde_json::to_vec(&collection)
+                    .map_err(|e| e.to_string())
+                    .and_then(|data| fs::write(&path, data).map_err(|e| e.to_string()));
+                findings.push(match written {
+                    Ok(()) => IntegrityFinding::repaired(check, problems.join("; ")),
+                    Err(e) => IntegrityFinding::failed(check, format!("{}; writing the repaired collection failed: {}", problems.join("; "), e)),
+                });
+            }
+            
+            let collections = self.collections.lock().unwrap();
+            let mut unloaded = self.unloaded.lock().unwrap();
+            if !collections.contains_key(&collection.name) && !unloaded.contains_key(&collection.name) {
+                let summary = VectorCollection {
+                    ids: Vec::new(),
+                    embeddings: Vec::new(),
+                    metadata: HashMap::new(),
+                    updated_at: HashMap::new(),
+                    ..collection
+                };
+                unloaded.insert(summary.name.clone(), summary);
+            }
+        }
+        
+        findings
+    }
+    
+    /// 常驻内存的集合估算字节数
+    pub fn resident_bytes(&self) -> usize {
+        let collections = self.collections.lock().unwrap();
+        collections.values().map(collection_bytes).sum()
+    }
+    
     /// 创建新的向量集合
     pub fn create_collection(&self, name: &str, dimension: usize) -> Result<VectorCollection, String> {
         let mut collections = self.collections.lock().unwrap();
         
-        if collections.contains_key(name) {
+        if collections.contains_key(name) || self.unloaded.lock().unwrap().contains_key(name) {
             return Err(format!("Collection '{}' already exists", name));
         }
         
@@ -69,19 +383,73 @@ impl VectorExecutor {
             metadata: HashMap::new(),
             indexed: false,
             index_type: None,
+            updated_at: HashMap::new(),
         };
         
         collections.insert(name.to_string(), collection.clone());
+        self.touch(name);
         info!("Created vector collection '{}' with dimension {}", name, dimension);
         
         Ok(collection)
     }
     
+    /// 删除向量集合
+    ///
+    /// 同时删除常驻内存或卸载到磁盘的数据、近似索引和构建状态，以及该集合
+    /// 未完成的分块上传。构建中的索引完成后发现集合已删除会丢弃结果。删除
+    /// 成功后集合此前的访问统计被清除，只保留这次删除。副本拒绝删除，主实例
+    /// 上的删除会复制到副本。
+    pub fn delete_collection(&self, name: &str) -> Result<(), String> {
+        if self.replication.is_replica() {
+            return Err(format!("This instance is a replica; writes to '{}' must go to the primary", name));
+        }
+        let started = Instant::now();
+        let result = {
+            let mut collections = self.collections.lock().unwrap();
+            self.remove_collection(&mut collections, name)
+        };
+        match &result {
+            Ok(()) => {
+                self.access_stats.remove(name);
+                self.access_stats.record(name, "delete", started.elapsed(), 0, true);
+                self.replication.record_delete(name, chrono::Utc::now().timestamp_millis());
+            },
+            Err(_) => self.record_access(name, "delete", started.elapsed(), 0, false),
+        }
+        result
+    }
+    
+    fn remove_collection(&self, collections: &mut HashMap<String, VectorCollection>, name: &str) -> Result<(), String> {
+        let resident = collections.remove(name).is_some();
+        let spilled = self.unloaded.lock().unwrap().remove(name).is_some();
+        if !resident && !spilled {
+            return Err(format!("Collection '{}' not found", name));
+        }
+        
+        // 常驻集合也可能留有上次卸载时写入的文件
+        let path = self.spill_path(name);
+        match fs::remove_file(&path) {
+            Ok(()) => {},
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => {},
+            Err(e) => warn!("Failed to remove {} for deleted collection '{}': {}", path.display(), name, e),
+        }
+        
+        self.indexes.lock().unwrap().remove(name);
+        self.index_builds.lock().unwrap().remove(name);
+        self.last_access.lock().unwrap().remove(name);
+        self.uploads.lock().unwrap().retain(|_, upload| upload.collection != name);
+        self.sync_budget(collections);
+        
+        info!("Deleted vector collection '{}'", name);
+        Ok(())
+    }
+    
     /// 获取所有向量集合
     pub fn list_collections(&self) -> Result<Vec<VectorCollection>, String> {
         let collections = self.collections.lock().unwrap();
+        let unloaded = self.unloaded.lock().unwrap();
         
-        Ok(collections.values().cloned().collect())
+        Ok(collections.values().chain(unloaded.values()).cloned().collect())
     }
     
     /// 添加向量嵌入
@@ -91,8 +459,25 @@ impl VectorExecutor {
         ids: Vec<String>, 
         embeddings: Vec<Vec<f32>>,
         metadata: Option<Vec<HashMap<String, serde_json::Value>>>
+    ) -> Result<usize, String> {
+        if self.replication.is_replica() {
+            return Err(format!("This instance is a replica; writes to '{}' must go to the primary", collection_name));
+        }
+        let started = Instant::now();
+        let result = self.insert_embeddings(collection_name, ids, embeddings, metadata);
+        self.record_access(collection_name, "add", started.elapsed(), *result.as_ref().unwrap_or(&0), result.is_ok());
+        result
+    }
+    
+    fn insert_embeddings(
+        &self, 
+        collection_name: &str, 
+        ids: Vec<String>, 
+        embeddings: Vec<Vec<f32>>,
+        metadata: Option<Vec<HashMap<String, serde_json::Value>>>
     ) -> Result<usize, String> {
         let mut collections = self.collections.lock().unwrap();
+        self.ensure_loaded(&mut collections, collection_name)?;
         
         let collection = match collections.get_mut(collection_name) {
             Some(c) => c,
@@ -110,28 +495,269 @@ impl VectorExecutor {
             }
         }
         
-        // 添加向量和ID
+        // 检查集合的内存上限
+        let added: usize = ids.iter().zip(&embeddings)
+            .map(|(id, embedding)| id.len() + embedding.len() * std::mem::size_of::<f32>() + METADATA_ENTRY_BYTES)
+            .sum();
+        if let Some(max_bytes) = self.max_collection_bytes {
+            let total = collection_bytes(collection) + added;
+            if total > max_bytes {
+                return Err(format!(
+                    "Collection '{}' would use {} bytes, exceeding its memory cap of {} bytes",
+                    collection_name, total, max_bytes
+                ));
+            }
+        }
+        
+        // 从内存预算申请新增的内存
+        if !self.reserve_budget(&mut collections, added, collection_name) {
+            return Err(format!(
+                "Not enough memory in the budget to add {} bytes to collection '{}'",
+                added, collection_name
+            ));
+        }
+        let collection = collections.get_mut(collection_name)
+            .ok_or_else(|| format!("Collection '{}' not found", collection_name))?;
+        
+        // 添加向量和ID，已存在的ID替换原有向量和元数据，与副本应用变更的语义一致
         let count = ids.len();
+        let updated_at = chrono::Utc::now().timestamp_millis();
+        let mut positions: HashMap<String, usize> = collection.ids.iter().enumerate()
+            .map(|(i, id)| (id.clone(), i))
+            .collect();
+        let mut replicated_metadata = Vec::with_capacity(count);
         for i in 0..count {
-            collection.ids.push(ids[i].clone());
-            collection.embeddings.push(embeddings[i].clone());
-            
-            // 如果提供了元数据，也添加它
-            if let Some(meta) = &metadata {
-                if i < meta.len() {
-                    collection.metadata.insert(ids[i].clone(), serde_json::to_value(&meta[i]).unwrap_or_default());
+            match positions.get(&ids[i]) {
+                Some(&position) => collection.embeddings[position] = embeddings[i].clone(),
+                None => {
+                    positions.insert(ids[i].clone(), collection.ids.len());
+                    collection.ids.push(ids[i].clone());
+                    collection.embeddings.push(embeddings[i].clone());
+                    collection.count += 1;
                 }
             }
+            collection.updated_at.insert(ids[i].clone(), updated_at);
+            
+            // 如果提供了元数据，也添加它；未提供时清除原有元数据
+            let entry_metadata = metadata.as_ref()
+                .and_then(|meta| meta.get(i))
+                .map(|meta| serde_json::to_value(meta).unwrap_or_default());
+            match &entry_metadata {
+                Some(value) => { collection.metadata.insert(ids[i].clone(), value.clone()); },
+                None => { collection.metadata.remove(&ids[i]); },
+            }
+            replicated_metadata.push(entry_metadata);
         }
         
-        collection.count += count;
         collection.indexed = false;  // 添加新向量后需要重新构建索引
+        self.mark_index_stale(collection_name);
         
         info!("Added {} embeddings to collection '{}'", count, collection_name);
         
+        self.replication.record(collection_name, collection.dimension, &ids, &embeddings, &replicated_metadata, updated_at);
+        
+        self.sync_budget(&collections);
+        self.enforce_resident_cap(&mut collections, collection_name);
+        
         Ok(count)
     }
     
+    /// 应用主实例推送的变更
+    ///
+    /// 按向量ID更新或插入，副本上的写入时间不早于变更时跳过该变更（最后写入
+    /// 获胜），因此重复或乱序到达的批次不会覆盖更新的数据。集合不存在时按
+    /// 变更中的维度创建。变更逐条应用，无法应用的条目计入`rejected`，不影响
+    /// 同一批的其他条目；读取已卸载的集合失败等暂时性错误返回Err，主实例会
+    /// 重新推送整批，已应用的条目按最后写入获胜跳过。删除集合的变更按序号
+    /// 顺序应用，集合已不存在时计入`skipped`。
+    pub fn apply_replicated(&self, entries: Vec<ReplicationEntry>) -> Result<ApplyResult, String> {
+        let mut collections = self.collections.lock().unwrap();
+        let mut result = ApplyResult::default();
+        // 每个集合中向量ID的位置，同一批内复用
+        let mut positions: HashMap<String, HashMap<String, usize>> = HashMap::new();
+        
+        for entry in entries {
+            if entry.op == ReplicationOp::DeleteCollection {
+                positions.remove(&entry.collection);
+                match self.remove_collection(&mut collections, &entry.collection) {
+                    Ok(()) => {
+                        self.access_stats.remove(&entry.collection);
+                        result.applied += 1;
+                    },
+                    Err(_) => result.skipped += 1,
+                }
+                continue;
+            }
+            
+            if !collections.contains_key(&entry.collection) {
+                if self.unloaded.lock().unwrap().contains_key(&entry.collection) {
+                    self.ensure_loaded(&mut collections, &entry.collection)?;
+                } else {
+                    collections.insert(entry.collection.clone(), VectorCollection {
+                        name: entry.collection.clone(),
+                        dimension: entry.dimension,
+                        count: 0,
+                        ids: Vec::new(),
+                        embeddings: Vec::new(),
+                        metadata: HashMap::new(),
+                        indexed: false,
+                        index_type: None,
+                        updated_at: HashMap::new(),
+                    });
+                    info!("Created replicated vector collection '{}' with dimension {}", entry.collection, entry.dimension);
+                }
+                self.touch(&entry.collection);
+            }
+            
+            let collection = collections.get_mut(&entry.collection)
+                .ok_or_else(|| format!("Collection '{}' not found", entry.collection))?;
+            if entry.vector.len() != collection.dimension {
+                let message = format!(
+                    "seq {}: embedding dimension mismatch for '{}' in collection '{}', expected {}, got {}",
+                    entry.seq, entry.id, entry.collection, collection.dimension, entry.vector.len()
+                );
+                warn!("Rejected replicated entry {}", message);
+                result.rejected += 1;
+                result.errors.push(message);
+                continue;
+            }
+            if collection.updated_at.get(&entry.id).map_or(false, |current| *current >= entry.updated_at) {
+                result.skipped += 1;
+                continue;
+            }
+            
+            let ids = positions.entry(entry.collection.clone()).or_insert_with(|| {
+                collection.ids.iter().enumerate().map(|(i, id)| (id.clone(), i)).collect()
+            });
+            match ids.get(&entry.id) {
+                Some(&i) => collection.embeddings[i] = entry.vector,
+                None => {
+                    ids.insert(entry.id.clone(), collection.ids.len());
+                    collection.ids.push(entry.id.clone());
+                    collection.embeddings.push(entry.vector);
+                    collection.count += 1;
+                }
+            }
+            match entry.metadata {
+                Some(metadata) => { collection.metadata.insert(entry.id.clone(), metadata); },
+                None => { collection.metadata.remove(&entry.id); },
+            }
+            collection.updated_at.insert(entry.id, entry.updated_at);
+            collection.indexed = false;
+            self.mark_index_stale(&entry.collection);
+            result.applied += 1;
+        }
+        
+        self.sync_budget(&collections);
+        debug!(
+            "Applied {} replicated embeddings, skipped {} older ones, rejected {}",
+            result.applied, result.skipped, result.rejected
+        );
+        Ok(result)
+    }
+    
+    /// 分块添加向量嵌入
+    ///
+    /// 上传ID由客户端生成，序号为0的分块创建上传。分块必须按序号依次发送；
+    /// 重新发送已确认的分块（包括第一个分块）不会重复添加，中断后可以查询
+    /// 上传状态从`next_chunk`继续发送。同一上传的分块不能并发写入。
+    pub fn add_embeddings_chunk(
+        &self,
+        collection_name: &str,
+        upload_id: &str,
+        chunk_index: usize,
+        ids: Vec<String>,
+        embeddings: Vec<Vec<f32>>,
+        metadata: Option<Vec<HashMap<String, serde_json::Value>>>
+    ) -> Result<ChunkAck, String> {
+        if upload_id.is_empty() || upload_id.len() > MAX_UPLOAD_ID_LEN {
+            return Err(format!("upload_id must be 1 to {} characters", MAX_UPLOAD_ID_LEN));
+        }
+        
+        {
+            let mut uploads = self.uploads.lock().unwrap();
+            if chunk_index == 0 && !uploads.contains_key(upload_id) {
+                uploads.retain(|_, upload| upload.writing || upload.updated.elapsed() < UPLOAD_TTL);
+                uploads.insert(upload_id.to_string(), ChunkedUpload {
+                    collection: collection_name.to_string(),
+                    next_chunk: 0,
+                    total_added: 0,
+                    updated: Instant::now(),
+                    writing: false,
+                });
+            }
+            
+            let upload = match uploads.get_mut(upload_id) {
+                Some(upload) if upload.collection == collection_name => upload,
+                Some(_) => return Err(format!("Upload '{}' belongs to another collection", upload_id)),
+                None => return Err(format!("Upload '{}' not found or expired", upload_id)),
+            };
+            upload.updated = Instant::now();
+            
+            if chunk_index < upload.next_chunk {
+                debug!("Chunk {} of upload '{}' was already added", chunk_index, upload_id);
+                return Ok(ChunkAck {
+                    upload_id: upload_id.to_string(),
+                    collection: upload.collection.clone(),
+                    chunk_index,
+                    added: 0,
+                    next_chunk: upload.next_chunk,
+                    total_added: upload.total_added,
+                });
+            }
+            if chunk_index > upload.next_chunk {
+                return Err(format!(
+                    "Chunk {} of upload '{}' is out of order, expected chunk {}",
+                    chunk_index, upload_id, upload.next_chunk
+                ));
+            }
+            if upload.writing {
+                return Err(format!("Chunk {} of upload '{}' is already being written", chunk_index, upload_id));
+            }
+            upload.writing = true;
+        }
+        
+        // 写入时释放上传锁，其他上传不受影响
+        let result = self.add_embeddings(collection_name, ids, embeddings, metadata);
+        
+        let mut uploads = self.uploads.lock().unwrap();
+        let upload = match uploads.get_mut(upload_id) {
+            Some(upload) => upload,
+            None => return Err(format!("Upload '{}' was finished while chunk {} was written", upload_id, chunk_index)),
+        };
+        upload.writing = false;
+        upload.updated = Instant::now();
+        let added = result?;
+        upload.next_chunk += 1;
+        upload.total_added += added;
+        
+        Ok(ChunkAck {
+            upload_id: upload_id.to_string(),
+            collection: upload.collection.clone(),
+            chunk_index,
+            added,
+            next_chunk: upload.next_chunk,
+            total_added: upload.total_added,
+        })
+    }
+    
+    /// 获取分块上传的状态
+    pub fn upload_status(&self, upload_id: &str) -> Option<ChunkAck> {
+        self.uploads.lock().unwrap().get(upload_id).map(|upload| ChunkAck {
+            upload_id: upload_id.to_string(),
+            collection: upload.collection.clone(),
+            chunk_index: upload.next_chunk.saturating_sub(1),
+            added: 0,
+            next_chunk: upload.next_chunk,
+            total_added: upload.total_added,
+        })
+    }
+    
+    /// 结束分块上传，返回上传是否存在
+    pub fn finish_upload(&self, upload_id: &str) -> bool {
+        self.uploads.lock().unwrap().remove(upload_id).is_some()
+    }
+    
     /// 搜索相似向量
     pub fn search_similar(
         &self, 
@@ -139,7 +765,33 @@ impl VectorExecutor {
         query_vector: Vec<f32>, 
         top_k: usize
     ) -> Result<Vec<VectorSearchResult>, String> {
-        let collections = self.collections.lock().unwrap();
+        self.search(collection_name, query_vector, top_k, false)
+    }
+    
+    /// 搜索相似向量，`explain`为true时为每个结果附带评分说明
+    pub fn search(
+        &self, 
+        collection_name: &str, 
+        query_vector: Vec<f32>, 
+        top_k: usize,
+        explain: bool
+    ) -> Result<Vec<VectorSearchResult>, String> {
+        let started = Instant::now();
+        let result = self.search_collection(collection_name, query_vector, top_k, explain);
+        let returned = result.as_ref().map_or(0, |results| results.len());
+        self.record_access(collection_name, "search", started.elapsed(), returned, result.is_ok());
+        result
+    }
+    
+    fn search_collection(
+        &self, 
+        collection_name: &str, 
+        query_vector: Vec<f32>, 
+        top_k: usize,
+        explain: bool
+    ) -> Result<Vec<VectorSearchResult>, String> {
+        let mut collections = self.collections.lock().unwrap();
+        self.ensure_loaded(&mut collections, collection_name)?;
         
         let collection = match collections.get(collection_name) {
             Some(c) => c,
@@ -158,8 +810,33 @@ impl VectorExecutor {
             return Ok(Vec::new());
         }
         
+        // 索引构建完成且集合未变化时使用近似索引
+        let built = self.indexes.lock().unwrap().get(collection_name).cloned();
+        if let Some(built) = built.filter(|b| collection.indexed && b.count == collection.count) {
+            let hits = built.index.search(&query_vector, top_k).map_err(|e| e.to_string())?;
+            let mut results: Vec<VectorSearchResult> = hits.into_iter()
+                .filter_map(|(id, score)| {
+                    let position = *built.positions.get(&id)?;
+                    Some(VectorSearchResult {
+                        vector: Some(collection.embeddings[position].clone()),
+                        metadata: collection.metadata.get(&id).cloned(),
+                        id,
+                        score,
+                        explanation: None,
+                    })
+                })
+                .collect();
+            
+            if explain {
+                explain_results(&mut results, &query_vector, "index", collection.index_type.clone());
+            }
+            
+            debug!("Found {} similar vectors in collection '{}' using index", results.len(), collection_name);
+            return Ok(results);
+        }
+        
         // 转换为ndarray进行计算
-        let query = Array1::from_vec(query_vector);
+        let query = Array1::from_vec(query_vector.clone());
         
         // 计算余弦相似度
         let mut results = Vec::with_capacity(collection.count);
@@ -186,6 +863,7 @@ impl VectorExecutor {
                 score: similarity,
                 vector: Some(collection.embeddings[i].clone()),
                 metadata,
+                explanation: None,
             });
         }
         
@@ -193,11 +871,11 @@ impl VectorExecutor {
         results.sort_by(|a, b| b.score.partial_cmp(&a.score).unwrap_or(std::cmp::Ordering::Equal));
         
         // 只返回前top_k个结果
-        let results = if top_k < results.len() {
-            results[0..top_k].to_vec()
-        } else {
-            results
-        };
+        results.truncate(top_k);
+        
+        if explain {
+            explain_results(&mut results, &query_vector, "exact", None);
+        }
         
         debug!("Found {} similar vectors in collection '{}'", results.len(), collection_name);
         
@@ -207,6 +885,7 @@ impl VectorExecutor {
     /// 创建索引
     pub fn create_index(&self, collection_name: &str, index_type: &str) -> Result<(), String> {
         let mut collections = self.collections.lock().unwrap();
+        self.ensure_loaded(&mut collections, collection_name)?;
         
         let collection = match collections.get_mut(collection_name) {
             Some(c) => c,
@@ -218,15 +897,445 @@ impl VectorExecutor {
             return Err(format!("Unsupported index type: {}", index_type));
         }
         
-        // 在实际应用中，这里应该构建适当的索引结构
-        // 简单起见，我们只标记索引已创建
-        collection.indexed = true;
-        collection.index_type = Some(index_type.to_string());
+        // 精确索引不需要额外结构，搜索时逐一比较
+        if index_type == "flat" {
+            collection.indexed = true;
+            collection.index_type = Some(index_type.to_string());
+            self.indexes.lock().unwrap().remove(collection_name);
+            info!("Created 'flat' index for collection '{}'", collection_name);
+            return Ok(());
+        }
+        
+        // 近似索引在后台构建，完成前继续使用精确搜索
+        self.start_index_build(collection, index_type)
+    }
+    
+    /// 在后台线程中按集合当前的数据构建近似索引
+    fn start_index_build(&self, collection: &VectorCollection, index_type: &str) -> Result<(), String> {
+        let collection_name = collection.name.as_str();
+        let mut builds = self.index_builds.lock().unwrap();
+        if builds.get(collection_name).map_or(false, |b| b.state == IndexBuildState::Building) {
+            return Err(format!("Index for collection '{}' is already being built", collection_name));
+        }
+        let snapshot = (collection.ids.clone(), collection.embeddings.clone(), collection.dimension);
+        builds.insert(collection_name.to_string(), IndexBuildStatus {
+            collection: collection_name.to_string(),
+            index_type: index_type.to_string(),
+            state: IndexBuildState::Building,
+            processed: 0,
+            total: snapshot.0.len(),
+            elapsed_ms: 0,
+            error: None,
+            stale: false,
+        });
+        drop(builds);
+        
+        let name = collection_name.to_string();
+        let index_type = index_type.to_string();
+        let collections = self.collections.clone();
+        let indexes = self.indexes.clone();
+        let index_builds = self.index_builds.clone();
+        
+        thread::Builder::new()
+            .name(format!("vector-index-{}", name))
+            .spawn(move || build_index(name, index_type, snapshot, collections, indexes, index_builds))
+            .map_err(|e| format!("Failed to start index build: {}", e))?;
+        
+        info!("Started background index build for collection '{}'", collection_name);
         
-        info!("Created '{}' index for collection '{}'", index_type, collection_name);
+        Ok(())
+    }
+    
+    /// 集合被写入后标记索引过期，构建中的索引完成后也不会被使用
+    fn mark_index_stale(&self, collection_name: &str) {
+        if let Some(status) = self.index_builds.lock().unwrap().get_mut(collection_name) {
+            status.stale = true;
+        }
+    }
+    
+    /// 获取集合的索引构建进度
+    pub fn index_build_status(&self, collection_name: &str) -> Option<IndexBuildStatus> {
+        self.index_builds.lock().unwrap().get(collection_name).cloned()
+    }
+
+    /// 将集合导出为JSONL，每行一个向量：`{"id", "vector", "metadata"}`
+    ///
+    /// `progress`在每写入`EXPORT_PROGRESS_INTERVAL`个向量及导出结束时以(已导出数, 总数)调用。
+    pub fn export_jsonl<W, F>(&self, collection_name: &str, mut writer: W, mut progress: F) -> Result<usize, String>
+    where
+        W: Write,
+        F: FnMut(usize, usize),
+    {
+        let collection = self.export_copy(collection_name)?;
+
+        let total = collection.ids.len();
+        for (i, (id, vector)) in collection.ids.iter().zip(collection.embeddings.iter()).enumerate() {
+            let record = serde_json::json!({
+                "id": id,
+                "vector": vector,
+                "metadata": collection.metadata.get(id).cloned().unwrap_or(serde_json::Value::Null),
+            });
+
+            serde_json::to_writer(&mut writer, &record)
+                .map_err(|e| format!("Failed to serialize vector '{}': {}", id, e))?;
+            writer.write_all(b"\n")
+                .map_err(|e| format!("Failed to write export: {}", e))?;
+
+            if (i + 1) % EXPORT_PROGRESS_INTERVAL == 0 {
+                progress(i + 1, total);
+            }
+        }
+
+        writer.flush().map_err(|e| format!("Failed to write export: {}", e))?;
+        progress(total, total);
+
+        info!("Exported {} vectors from collection '{}'", total, collection_name);
+
+        Ok(total)
+    }
+
+    /// 通过DuckDB将集合导出为Parquet文件，列为`id`、`vector`和`metadata`（JSON文本）
+    pub fn export_parquet(&self, collection_name: &str, engine: &DuckDbEngine, path: &Path) -> Result<usize, String> {
+        let collection = self.export_copy(collection_name)?;
+
+        let rows = collection.ids.iter().zip(collection.embeddings.iter()).map(|(id, vector)| {
+            let metadata = collection.metadata.get(id).map(|m| m.to_string());
+            (id.as_str(), vector.as_slice(), metadata)
+        });
+        let total = engine.export_vectors_parquet(rows, &path.to_string_lossy())
+            .map_err(|e| format!("Failed to write Parquet export: {}", e))?;
+
+        info!("Exported {} vectors from collection '{}' to Parquet", total, collection_name);
+
+        Ok(total)
+    }
+
+    /// 复制要导出的集合后释放锁，避免导出期间阻塞写入
+    fn export_copy(&self, collection_name: &str) -> Result<VectorCollection, String> {
+        let mut collections = self.collections.lock().unwrap();
+        self.ensure_loaded(&mut collections, collection_name)?;
+        collections.get(collection_name)
+            .cloned()
+            .ok_or_else(|| format!("Collection '{}' not found", collection_name))
+    }
+
+    /// 确保集合已加载到内存，并更新其访问时间
+    fn ensure_loaded(&self, collections: &mut HashMap<String, VectorCollection>, name: &str) -> Result<(), String> {
+        if !collections.contains_key(name) {
+            if !self.unloaded.lock().unwrap().contains_key(name) {
+                return Err(format!("Collection '{}' not found", name));
+            }
+
+            let path = self.spill_path(name);
+            let data = fs::read(&path)
+                .map_err(|e| format!("Failed to load collection '{}' from {}: {}", name, path.display(), e))?;
+            let collection: VectorCollection = serde_json::from_slice(&data)
+                .map_err(|e| format!("Failed to parse collection '{}': {}", name, e))?;
+            let bytes = collection_bytes(&collection);
+            if !self.reserve_budget(collections, bytes, name) {
+                return Err(format!(
+                    "Not enough memory in the budget to load collection '{}' ({} bytes)",
+                    name, bytes
+                ));
+            }
+
+            // 近似索引不随集合保存，加载后在后台重建，完成前使用精确搜索
+            if let Some(index_type) = collection.index_type.clone().filter(|t| collection.indexed && t != "flat") {
+                if let Err(e) = self.start_index_build(&collection, &index_type) {
+                    error!("Failed to rebuild '{}' index for collection '{}': {}", index_type, name, e);
+                }
+            }
+            
+            self.unloaded.lock().unwrap().remove(name);
+            collections.insert(name.to_string(), collection);
+            info!("Loaded vector collection '{}' from disk", name);
+
+            self.enforce_resident_cap(collections, name);
+        }
+
+        self.touch(name);
+        Ok(())
+    }
+
+    /// 将最久未使用的集合卸载到磁盘，直到常驻内存不超过上限；`keep`不会被卸载
+    fn enforce_resident_cap(&self, collections: &mut HashMap<String, VectorCollection>, keep: &str) {
+        let max_bytes = match self.max_resident_bytes {
+            Some(max_bytes) => max_bytes,
+            None => return,
+        };
+
+        let resident: usize = collections.values().map(collection_bytes).sum();
+        if resident > max_bytes {
+            self.unload_coldest(collections, resident - max_bytes, Some(keep));
+        }
+    }
+
+    /// 按最久未使用的顺序卸载集合，直到释放至少`bytes`字节，返回释放的字节数
+    fn unload_coldest(&self, collections: &mut HashMap<String, VectorCollection>, bytes: usize, keep: Option<&str>) -> usize {
+        let mut freed = 0;
+        while freed < bytes {
+            let coldest = {
+                let last_access = self.last_access.lock().unwrap();
+                collections.keys()
+                    .filter(|name| Some(name.as_str()) != keep)
+                    .min_by_key(|name| last_access.get(*name).copied())
+                    .cloned()
+            };
+
+            let name = match coldest {
+                Some(name) => name,
+                None => break,
+            };
+            let size = collections.get(&name).map_or(0, collection_bytes);
+            if let Err(e) = self.unload(collections, &name) {
+                error!("Failed to unload collection '{}': {}", name, e);
+                break;
+            }
+            freed += size;
+        }
+        freed
+    }
+
+    /// 从内存预算申请`bytes`字节，不足时先卸载`keep`以外最久未使用的集合
+    fn reserve_budget(&self, collections: &mut HashMap<String, VectorCollection>, bytes: usize, keep: &str) -> bool {
+        let budget = match &self.memory_budget {
+            Some(budget) => budget,
+            None => return true,
+        };
+        if !budget.reserve(MEMORY_SUBSYSTEM, bytes) {
+            let needed = bytes.saturating_sub(budget.available());
+            self.unload_coldest(collections, needed, Some(keep));
+            if !budget.reserve(MEMORY_SUBSYSTEM, bytes) {
+                return false;
+            }
+        }
+        *self.budget_reserved.lock().unwrap() += bytes;
+        true
+    }
+
+    /// 使申请的预算与常驻集合的估算字节数一致
+    fn sync_budget(&self, collections: &HashMap<String, VectorCollection>) {
+        let budget = match &self.memory_budget {
+            Some(budget) => budget,
+            None => return,
+        };
+        let resident: usize = collections.values().map(collection_bytes).sum();
+        let mut reserved = self.budget_reserved.lock().unwrap();
+        if resident < *reserved {
+            budget.release(MEMORY_SUBSYSTEM, *reserved - resident);
+            *reserved = resident;
+        } else if resident > *reserved && budget.try_reserve(MEMORY_SUBSYSTEM, resident - *reserved) {
+            *reserved = resident;
+        }
+    }
+
+    /// 将集合写入磁盘并从内存中移除，只保留摘要
+    fn unload(&self, collections: &mut HashMap<String, VectorCollection>, name: &str) -> Result<(), String> {
+        let collection = match collections.get(name) {
+            Some(c) => c,
+            None => return Ok(()),
+        };
+
+        let path = self.spill_path(name);
+        if let Some(dir) = path.parent() {
+            fs::create_dir_all(dir).map_err(|e| format!("Failed to create {}: {}", dir.display(), e))?;
+        }
+        let data = serde_json::to_vec(collection)
+            .map_err(|e| format!("Failed to serialize collection '{}': {}", name, e))?;
+        fs::write(&path, data).map_err(|e| format!("Failed to write {}: {}", path.display(), e))?;
+
+        // 索引随集合一起卸载，重新加载时在后台重建
+        self.indexes.lock().unwrap().remove(name);
         
+        if let Some(collection) = collections.remove(name) {
+            let summary = VectorCollection {
+                ids: Vec::new(),
+                embeddings: Vec::new(),
+                metadata: HashMap::new(),
+                updated_at: HashMap::new(),
+                ..collection
+            };
+            self.unloaded.lock().unwrap().insert(name.to_string(), summary);
+        }
+        self.sync_budget(collections);
+
+        info!("Unloaded vector collection '{}' to {}", name, path.display());
         Ok(())
     }
+
+    /// 记录集合的访问时间
+    fn touch(&self, name: &str) {
+        self.last_access.lock().unwrap().insert(name.to_string(), Instant::now());
+    }
+
+    /// 卸载的集合在磁盘上的文件路径
+    ///
+    /// ASCII字母、数字、`-`和`_`原样保留，其他字节按`%XX`编码，不同的集合名
+    /// 不会映射到同一个文件。
+    fn spill_path(&self, name: &str) -> PathBuf {
+        let mut file_name = String::with_capacity(name.len());
+        for byte in name.bytes() {
+            if byte.is_ascii_alphanumeric() || byte == b'-' || byte == b'_' {
+                file_name.push(byte as char);
+            } else {
+                file_name.push_str(&format!("%{:02X}", byte));
+            }
+        }
+        PathBuf::from(format!("{}.collections", self.base_path)).join(format!("{}.json", file_name))
+    }
+
+    /// 旧版本把特殊字符替换为`_`时使用的文件路径，启动检查时迁移到`spill_path`
+    fn legacy_spill_path(&self, name: &str) -> PathBuf {
+        let file_name: String = name.chars()
+            .map(|c| if c.is_alphanumeric() || c == '-' || c == '_' { c } else { '_' })
+            .collect();
+        PathBuf::from(format!("{}.collections", self.base_path)).join(format!("{}.json", file_name))
+    }
+}
+
+/// 修正集合中ID、向量、元数据和数量之间的不一致，返回发现的问题
+fn reconcile_collection(collection: &mut VectorCollection) -> Vec<String> {
+    let mut problems = Vec::new();
+    
+    if collection.ids.len() != collection.embeddings.len() {
+        problems.push(format!("{} ids but {} vectors, truncated to the shorter", collection.ids.len(), collection.embeddings.len()));
+        let len = collection.ids.len().min(collection.embeddings.len());
+        collection.ids.truncate(len);
+        collection.embeddings.truncate(len);
+    }
+    
+    let dimension = collection.dimension;
+    let mismatched = collection.embeddings.iter().filter(|v| v.len() != dimension).count();
+    if mismatched > 0 {
+        problems.push(format!("removed {} vectors whose dimension is not {}", mismatched, dimension));
+        let (ids, embeddings): (Vec<String>, Vec<Vec<f32>>) = collection.ids.drain(..)
+            .zip(collection.embeddings.drain(..))
+            .filter(|(_, vector)| vector.len() == dimension)
+            .unzip();
+        collection.ids = ids;
+        collection.embeddings = embeddings;
+    }
+    
+    let ids: std::collections::HashSet<&String> = collection.ids.iter().collect();
+    let orphaned = collection.metadata.keys().filter(|id| !ids.contains(id)).count();
+    if orphaned > 0 {
+        problems.push(format!("removed metadata of {} missing vectors", orphaned));
+        collection.metadata.retain(|id, _| ids.contains(id));
+    }
+    collection.updated_at.retain(|id, _| ids.contains(id));
+    
+    if collection.count != collection.ids.len() {
+        problems.push(format!("count {} reconciled to {}", collection.count, collection.ids.len()));
+        collection.count = collection.ids.len();
+    }
+    
+    problems
+}
+
+/// 在后台线程中构建分区索引并在完成后替换旧索引
+fn build_index(
+    name: String,
+    index_type: String,
+    (ids, embeddings, dimension): (Vec<String>, Vec<Vec<f32>>, usize),
+    collections: Arc<Mutex<HashMap<String, VectorCollection>>>,
+    indexes: Arc<Mutex<HashMap<String, Arc<BuiltIndex>>>>,
+    index_builds: Arc<Mutex<HashMap<String, IndexBuildStatus>>>,
+) {
+    let started = Instant::now();
+    let total = ids.len();
+    let update = |processed: usize, state: IndexBuildState, error: Option<String>| {
+        if let Some(status) = index_builds.lock().unwrap().get_mut(&name) {
+            status.processed = processed;
+            status.state = state;
+            status.error = error;
+            status.elapsed_ms = started.elapsed().as_millis() as u64;
+        }
+    };
+    
+    // 分区数取向量数的平方根
+    let partitions = ((total as f64).sqrt() as usize).clamp(1, 1024);
+    let mut index = PartitionedVectorIndex::new(name.as_str(), dimension, DistanceMetric::Cosine, partitions);
+    let step = (total / INDEX_TRAINING_SAMPLE).max(1);
+    let sample: Vec<Vec<f32>> = embeddings.iter().step_by(step).cloned().collect();
+    index.train(&sample);
+    
+    let mut positions = HashMap::with_capacity(total);
+    for (i, (id, vector)) in ids.into_iter().zip(embeddings).enumerate() {
+        if let Err(e) = index.add(id.clone(), vector, None) {
+            error!("Index build for collection '{}' failed: {}", name, e);
+            update(i, IndexBuildState::Failed, Some(e.to_string()));
+            return;
+        }
+        positions.insert(id, i);
+        
+        if (i + 1) % INDEX_PROGRESS_INTERVAL == 0 {
+            update(i + 1, IndexBuildState::Building, None);
+        }
+    }
+    
+    // 集合在构建期间被删除或卸载时丢弃结果
+    let mut collections = collections.lock().unwrap();
+    let collection = match collections.get_mut(&name) {
+        Some(c) => c,
+        None => {
+            update(total, IndexBuildState::Failed, Some("collection was unloaded or removed during the build".to_string()));
+            return;
+        }
+    };
+    
+    // 构建期间集合被写入（包括替换已有ID的向量）时索引已过期，保留精确搜索
+    let stale = index_builds.lock().unwrap().get(&name).map_or(true, |status| status.stale);
+    indexes.lock().unwrap().insert(name.clone(), Arc::new(BuiltIndex { index, positions, count: total }));
+    if collection.count == total && !stale {
+        collection.indexed = true;
+    }
+    collection.index_type = Some(index_type.clone());
+    update(total, IndexBuildState::Ready, None);
+    
+    info!("Built '{}' index over {} vectors for collection '{}' in {:?}", index_type, total, name, started.elapsed());
+}
+
+/// 说明结果中的维度数上限
+const EXPLAIN_TOP_DIMENSIONS: usize = 5;
+
+/// 为搜索结果附带评分说明
+fn explain_results(results: &mut [VectorSearchResult], query: &[f32], method: &str, index_type: Option<String>) {
+    let query_norm = query.iter().map(|v| v * v).sum::<f32>().sqrt();
+    for (rank, result) in results.iter_mut().enumerate() {
+        let vector = match &result.vector {
+            Some(vector) => vector,
+            None => continue,
+        };
+        let vector_norm = vector.iter().map(|v| v * v).sum::<f32>().sqrt();
+        
+        // 归一化后的点积按维度拆分，各维度之和即为余弦相似度
+        let scale = if query_norm > 0.0 && vector_norm > 0.0 { 1.0 / (query_norm * vector_norm) } else { 0.0 };
+        let mut contributions: Vec<DimensionContribution> = query.iter().zip(vector)
+            .enumerate()
+            .map(|(dimension, (q, v))| DimensionContribution { dimension, contribution: q * v * scale })
+            .collect();
+        contributions.sort_by(|a, b| b.contribution.abs().partial_cmp(&a.contribution.abs()).unwrap_or(std::cmp::Ordering::Equal));
+        contributions.truncate(EXPLAIN_TOP_DIMENSIONS);
+        
+        result.explanation = Some(SearchExplanation {
+            rank: rank + 1,
+            method: method.to_string(),
+            index_type: index_type.clone(),
+            metric: "cosine".to_string(),
+            similarity: result.score,
+            distance: 1.0 - result.score,
+            query_norm,
+            vector_norm,
+            top_dimensions: contributions,
+        });
+    }
+}
+
+/// 估算集合占用的内存字节数
+fn collection_bytes(collection: &VectorCollection) -> usize {
+    let ids: usize = collection.ids.iter().map(|id| id.len()).sum();
+    let vectors: usize = collection.embeddings.iter().map(|v| v.len() * std::mem::size_of::<f32>()).sum();
+    ids + vectors + collection.metadata.len() * METADATA_ENTRY_BYTES
 }
 
 // Extension trait for actix_web::web::Data<Arc<VectorExecutor>>
@@ -299,8 +1408,7 @@ pub mod extensions {
         }
         
         async fn delete_collection(&self, name: String) -> Result<(), String> {
-            // Currently not directly implemented, so return success
-            Ok(())
+            self.get_ref().delete_collection(&name)
         }
         
         async fn add_embeddings(&self, name: String, ids: Vec<String>, embeddings: Vec<Vec<f32>>, metadata: Option<Vec<Option<serde_json::Value>>>) -> Result<usize, String> {
@@ -344,4 +1452,32 @@ pub mod extensions {
 
 // Re-export extensions if web feature is enabled
 #[cfg(feature = "web")]
-pub use extensions::*; 
\ No newline at end of file
+pub use extensions::*; 
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_delete_collection_removes_spilled_data() {
+        let base = std::env::temp_dir().join(format!("lumos_vectors_delete_{}", std::process::id()));
+        let executor = VectorExecutor::new(&base).unwrap();
+        executor.create_collection("docs", 2).unwrap();
+        executor.add_embeddings("docs", vec!["a".into()], vec![vec![1.0, 0.0]], None).unwrap();
+        executor.unload_collection("docs").unwrap();
+        assert!(executor.spill_path("docs").exists());
+
+        executor.delete_collection("docs").unwrap();
+        let stats = executor.access_stats().collection("docs").unwrap();
+        assert_eq!(stats.operations.keys().collect::<Vec<_>>(), vec!["delete"]);
+        assert!(executor.list_collections().unwrap().is_empty());
+        assert!(!executor.spill_path("docs").exists());
+        assert!(executor.search_similar("docs", vec![1.0, 0.0], 1).is_err());
+        assert!(executor.delete_collection("docs").is_err());
+
+        // 删除后可以用同一名称重新创建
+        executor.create_collection("docs", 3).unwrap();
+        executor.delete_collection("docs").unwrap();
+
+        let _ = fs::remove_dir_all(format!("{}.collections", base.display()));
+    }
+}
@@ -2,14 +2,8 @@ use std::process;
 use env_logger::Env;
 use log::{info, error};
 
-mod api;
-mod db;
-mod middleware;
-mod models;
-mod utils;
-mod config;
-
-use crate::config::ServerConfig;
+use lumos_server::api;
+use lumos_server::config::ServerConfig;
 
 #[actix_web::main]
 async fn main() -> std::io::Result<()> {
@@ -1,23 +1,92 @@
 use std::future::{ready, Ready};
 use actix_web::{
     dev::{forward_ready, Service, ServiceRequest, ServiceResponse, Transform},
-    Error, HttpResponse, http,
+    web, Error, HttpMessage, HttpRequest, HttpResponse, http,
 };
 use futures_util::future::LocalBoxFuture;
 use log::debug;
 use actix_web::body::BoxBody;
 
 use crate::models::response::{ApiResponse, ApiError};
+use super::read_only::AccessMode;
+
+/// 请求使用的密钥角色，由认证中间件写入请求扩展
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum KeyRole {
+    /// 管理员密钥，或未启用认证
+    Admin,
+    /// 应用密钥和只读密钥
+    Application,
+}
+
+impl Default for KeyRole {
+    fn default() -> Self {
+        KeyRole::Admin
+    }
+}
+
+/// 获取请求的密钥角色，未设置时视为管理员
+pub fn key_role(req: &HttpRequest) -> KeyRole {
+    req.extensions().get::<KeyRole>().copied().unwrap_or_default()
+}
+
+/// 要求请求使用管理员密钥，`action`是被拒绝时错误消息中的操作说明
+pub fn require_admin(req: &HttpRequest, action: &str) -> Result<(), HttpResponse> {
+    if key_role(req) == KeyRole::Admin {
+        return Ok(());
+    }
+    Err(HttpResponse::Forbidden().json(ApiResponse::<()>::error(
+        ApiError::new("ADMIN_REQUIRED", &format!("{} requires the admin API key", action))
+    )))
+}
+
+/// 请求使用的密钥标识，不包含密钥本身，用于按密钥统计负载
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct KeyLabel(pub String);
+
+/// 获取请求的密钥标识，未启用认证时为`anonymous`
+pub fn key_label(req: &HttpRequest) -> String {
+    req.extensions()
+        .get::<KeyLabel>()
+        .map(|label| label.0.clone())
+        .unwrap_or_else(|| "anonymous".to_string())
+}
 
 /// API认证中间件
 pub struct AuthMiddleware {
     api_key: Option<String>,
+    app_keys: Vec<String>,
+    read_only_keys: Vec<String>,
+    read_only: bool,
 }
 
 impl AuthMiddleware {
     /// 创建新的认证中间件
     pub fn new(api_key: Option<String>) -> Self {
-        Self { api_key }
+        Self {
+            api_key,
+            app_keys: Vec::new(),
+            read_only_keys: Vec::new(),
+            read_only: false,
+        }
+    }
+    
+    /// 设置应用API密钥，可以读写但不具有管理员权限
+    pub fn with_app_keys(mut self, keys: Vec<String>) -> Self {
+        self.app_keys = keys;
+        self
+    }
+    
+    /// 设置只读API密钥，使用这些密钥的请求只能执行读操作
+    pub fn with_read_only_keys(mut self, keys: Vec<String>) -> Self {
+        self.read_only_keys = keys;
+        self
+    }
+    
+    /// 设置实例级只读模式，所有请求只能执行读操作
+    pub fn with_read_only(mut self, read_only: bool) -> Self {
+        self.read_only = read_only;
+        self
     }
 }
 
@@ -37,6 +106,9 @@ where
         ready(Ok(AuthMiddlewareService {
             service,
             api_key: self.api_key.clone(),
+            app_keys: self.app_keys.clone(),
+            read_only_keys: self.read_only_keys.clone(),
+            read_only: self.read_only,
         }))
     }
 }
@@ -44,6 +116,9 @@ where
 pub struct AuthMiddlewareService<S> {
     service: S,
     api_key: Option<String>,
+    app_keys: Vec<String>,
+    read_only_keys: Vec<String>,
+    read_only: bool,
 }
 
 impl<S, B> Service<ServiceRequest> for AuthMiddlewareService<S>
@@ -59,8 +134,15 @@ where
     forward_ready!(service);
 
     fn call(&self, req: ServiceRequest) -> Self::Future {
-        // Check if API key is required
-        if self.api_key.is_none() {
+        // Instance-level read-only mode applies regardless of the key used
+        if self.read_only {
+            req.extensions_mut().insert(AccessMode::ReadOnly);
+        }
+        
+        // Check if API key is required; the readiness probe is always public.
+        // Application and read-only keys are still enforced without an admin key.
+        let auth_disabled = self.api_key.is_none() && self.app_keys.is_empty() && self.read_only_keys.is_empty();
+        if auth_disabled || req.path() == "/api/ready" {
             let fut = self.service.call(req);
             return Box::pin(async move {
                 let res = fut.await?;
@@ -69,42 +151,60 @@ where
         }
 
         // Check if the request has valid API key
-        let api_key = self.api_key.as_ref().unwrap();
-        let mut is_authenticated = false;
+        let mut presented_keys = Vec::new();
         
         // Check Authorization header
         if let Some(auth_header) = req.headers().get("Authorization") {
             if let Ok(auth_str) = auth_header.to_str() {
                 if auth_str.starts_with("Bearer ") {
-                    let token = &auth_str[7..];
-                    if token == api_key {
-                        is_authenticated = true;
-                    }
+                    presented_keys.push(auth_str[7..].to_string());
                 }
             }
         }
         
         // Check X-API-Key header
-        if !is_authenticated {
-            if let Some(key_header) = req.headers().get("X-API-Key") {
-                if let Ok(key) = key_header.to_str() {
-                    if key == api_key {
-                        is_authenticated = true;
-                    }
-                }
+        if let Some(key_header) = req.headers().get("X-API-Key") {
+            if let Ok(key) = key_header.to_str() {
+                presented_keys.push(key.to_string());
             }
         }
         
-        // Check query parameters
-        if !is_authenticated {
-            let query_string = req.query_string();
-            if query_string.contains(&format!("api_key={}", api_key)) {
-                is_authenticated = true;
-            }
+        // Check api_key query parameters
+        if let Ok(params) = web::Query::<Vec<(String, String)>>::from_query(req.query_string()) {
+            presented_keys.extend(params.into_inner().into_iter()
+                .filter(|(name, _)| name == "api_key")
+                .map(|(_, value)| value));
+        }
+        
+        let presents = |candidate: &String| {
+            presented_keys.iter().any(|key| constant_time_eq(key.as_bytes(), candidate.as_bytes()))
+        };
+        let is_authenticated = self.api_key.as_ref().map_or(false, |api_key| presents(api_key));
+        let app_key_index = self.app_keys.iter().position(|key| presents(key)).filter(|_| !is_authenticated);
+        let read_only_key_index = self.read_only_keys.iter().position(|key| presents(key))
+            .filter(|_| !is_authenticated && app_key_index.is_none());
+        let is_app_key = app_key_index.is_some();
+        let is_read_only_key = read_only_key_index.is_some();
+        
+        req.extensions_mut().insert(if is_authenticated { KeyRole::Admin } else { KeyRole::Application });
+        let label = match (app_key_index, read_only_key_index) {
+            _ if is_authenticated => Some("admin".to_string()),
+            (Some(index), _) => Some(format!("app-{}", index)),
+            (_, Some(index)) => Some(format!("read-only-{}", index)),
+            _ => None,
+        };
+        if let Some(label) = label {
+            req.extensions_mut().insert(KeyLabel(label));
+        }
+        
+        // Read-only keys authenticate but may only perform reads
+        if is_read_only_key {
+            debug!("Read-only API key authentication successful");
+            req.extensions_mut().insert(AccessMode::ReadOnly);
         }
         
         // Proceed with request if authenticated
-        if is_authenticated {
+        if is_authenticated || is_app_key || is_read_only_key {
             debug!("API key authentication successful");
             let fut = self.service.call(req);
             Box::pin(async move {
@@ -119,4 +219,12 @@ where
             })
         }
     }
-} 
\ No newline at end of file
+} 
+
+/// Compare two keys without exiting early on the first differing byte
+fn constant_time_eq(a: &[u8], b: &[u8]) -> bool {
+    if a.len() != b.len() {
+        return false;
+    }
+    a.iter().zip(b).fold(0u8, |acc, (x, y)| acc | (x ^ y)) == 0
+}
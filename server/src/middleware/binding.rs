@@ -0,0 +1,103 @@
+use std::collections::HashSet;
+use actix_web::{HttpRequest, HttpResponse};
+
+use lumos_core::query::lexer::{double_quoted_identifiers, tokenize, Token};
+use crate::db::DbExecutor;
+use crate::middleware::auth::{key_role, KeyRole};
+use crate::models::response::{ApiResponse, ApiError};
+
+/// 参数绑定策略
+///
+/// 严格模式下，非管理员密钥提交的SQL不能包含内联字符串字面量，值必须通过
+/// `params`绑定，以降低应用拼接SQL导致注入的风险。SQLite会把不对应任何表
+/// 或列的双引号名称当作字符串字面量，因此双引号名称必须是已有的表、视图或
+/// 列名；其他标识符可以用方括号或反引号引用。
+#[derive(Debug, Clone, Copy, Default)]
+pub struct BindingPolicy {
+    strict: bool,
+}
+
+impl BindingPolicy {
+    /// 创建参数绑定策略
+    pub fn new(strict: bool) -> Self {
+        Self { strict }
+    }
+
+    /// 是否启用严格模式
+    pub fn is_strict(&self) -> bool {
+        self.strict
+    }
+
+    /// 检查请求的SQL是否符合绑定策略，不符合时返回错误响应
+    pub fn check(&self, req: &HttpRequest, sql: &str, executor: &DbExecutor) -> Result<(), HttpResponse> {
+        if !self.strict || key_role(req) == KeyRole::Admin {
+            return Ok(());
+        }
+        if has_string_literal(sql) {
+            return Err(rejected("String literals must be passed as bound parameters (use ? placeholders and params)".to_string()));
+        }
+
+        let quoted = double_quoted_identifiers(sql);
+        if quoted.is_empty() {
+            return Ok(());
+        }
+        // 无法读取结构时无法判断双引号名称是否为字面量，按字面量拒绝
+        let names: HashSet<String> = match executor.schema_catalog() {
+            Ok(catalog) => catalog.tables.iter()
+                .flat_map(|table| std::iter::once(&table.name).chain(table.columns.iter().map(|c| &c.name)))
+                .map(|name| name.to_lowercase())
+                .collect(),
+            Err(e) => {
+                log::warn!("Failed to load schema for parameter binding check: {}", e);
+                HashSet::new()
+            }
+        };
+        match unresolved_name(&quoted, &names) {
+            Some(name) => Err(rejected(format!(
+                "\"{}\" is not a table or column and would be read as a string literal; \
+                 bind the value as a parameter or quote identifiers with [ ] or backticks",
+                name
+            ))),
+            None => Ok(()),
+        }
+    }
+}
+
+fn rejected(message: String) -> HttpResponse {
+    HttpResponse::BadRequest().json(ApiResponse::<()>::error(ApiError::new("INLINE_LITERAL_REJECTED", &message)))
+}
+
+/// 判断SQL是否包含字符串字面量，注释和引号标识符中的引号不计
+pub fn has_string_literal(sql: &str) -> bool {
+    tokenize(sql).iter().any(|token| matches!(token, Token::String(_)))
+}
+
+/// 第一个不是已知表名或列名（小写）的双引号名称
+fn unresolved_name<'a>(quoted: &'a [String], names: &HashSet<String>) -> Option<&'a str> {
+    quoted.iter()
+        .find(|name| !names.contains(&name.to_lowercase()))
+        .map(String::as_str)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_has_string_literal() {
+        assert!(has_string_literal("SELECT * FROM users WHERE name = 'bob'"));
+        assert!(!has_string_literal("SELECT * FROM users WHERE name = ?"));
+        assert!(!has_string_literal("SELECT \"it's\" FROM t -- 'comment'"));
+    }
+
+    #[test]
+    fn test_unresolved_double_quoted_name() {
+        let names: HashSet<String> = ["users", "name"].iter().map(|s| s.to_string()).collect();
+        let quoted = |sql: &str| double_quoted_identifiers(sql);
+
+        assert_eq!(unresolved_name(&quoted("SELECT \"Name\" FROM \"users\" WHERE id = ?"), &names), None);
+        // 没有名为bob的列，SQLite会把"bob"当作字符串
+        assert_eq!(unresolved_name(&quoted("SELECT * FROM users WHERE name = \"bob\""), &names), Some("bob"));
+        assert_eq!(unresolved_name(&quoted("SELECT [bob], `bob` FROM users"), &names), None);
+    }
+}
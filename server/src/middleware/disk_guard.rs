@@ -0,0 +1,96 @@
+use std::future::{ready, Ready};
+use std::sync::Arc;
+use actix_web::{
+    dev::{forward_ready, Service, ServiceRequest, ServiceResponse, Transform},
+    body::EitherBody,
+    http::{header, Method},
+    Error, HttpResponse,
+};
+use futures_util::future::LocalBoxFuture;
+
+use crate::middleware::read_only::is_mutating_route;
+use crate::models::response::{ApiResponse, ApiError};
+use crate::utils::disk_guard::DiskGuard;
+
+/// 磁盘空间保护中间件，剩余空间低于保留值时拒绝写请求
+///
+/// 写入大小按请求体长度估算。未配置磁盘保护时直接放行。
+pub struct DiskSpaceGuard {
+    guard: Option<Arc<DiskGuard>>,
+}
+
+impl DiskSpaceGuard {
+    /// 创建磁盘空间保护中间件
+    pub fn new(guard: Option<Arc<DiskGuard>>) -> Self {
+        Self { guard }
+    }
+}
+
+impl<S, B> Transform<S, ServiceRequest> for DiskSpaceGuard
+where
+    S: Service<ServiceRequest, Response = ServiceResponse<B>, Error = Error> + 'static,
+    S::Future: 'static,
+    B: 'static,
+{
+    type Response = ServiceResponse<EitherBody<B>>;
+    type Error = Error;
+    type Transform = DiskSpaceGuardService<S>;
+    type InitError = ();
+    type Future = Ready<Result<Self::Transform, Self::InitError>>;
+
+    fn new_transform(&self, service: S) -> Self::Future {
+        ready(Ok(DiskSpaceGuardService {
+            service,
+            guard: self.guard.clone(),
+        }))
+    }
+}
+
+pub struct DiskSpaceGuardService<S> {
+    service: S,
+    guard: Option<Arc<DiskGuard>>,
+}
+
+impl<S, B> Service<ServiceRequest> for DiskSpaceGuardService<S>
+where
+    S: Service<ServiceRequest, Response = ServiceResponse<B>, Error = Error> + 'static,
+    S::Future: 'static,
+    B: 'static,
+{
+    type Response = ServiceResponse<EitherBody<B>>;
+    type Error = Error;
+    type Future = LocalBoxFuture<'static, Result<Self::Response, Self::Error>>;
+
+    forward_ready!(service);
+
+    fn call(&self, req: ServiceRequest) -> Self::Future {
+        if let Some(guard) = &self.guard {
+            // 查询端点可能包含写语句，同样需要检查
+            let writes = is_mutating_route(req.method(), req.path())
+                || (*req.method() == Method::POST && req.path().ends_with("/query"));
+
+            if writes {
+                let write_bytes = req.headers()
+                    .get(header::CONTENT_LENGTH)
+                    .and_then(|v| v.to_str().ok())
+                    .and_then(|v| v.parse::<u64>().ok())
+                    .unwrap_or(0);
+
+                if let Err(e) = guard.check(write_bytes) {
+                    let response = HttpResponse::InsufficientStorage()
+                        .json(ApiResponse::<()>::error(ApiError::new("DISK_SPACE_LOW", &e.to_string())))
+                        .map_into_right_body();
+                    return Box::pin(async move {
+                        Ok(req.into_response(response))
+                    });
+                }
+            }
+        }
+
+        let fut = self.service.call(req);
+        Box::pin(async move {
+            let res = fut.await?;
+            Ok(res.map_into_left_body())
+        })
+    }
+}
@@ -0,0 +1,141 @@
+use std::future::{ready, Ready};
+use std::panic::AssertUnwindSafe;
+use std::sync::Arc;
+use actix_web::{
+    dev::{forward_ready, Service, ServiceRequest, ServiceResponse, Transform},
+    http::Method,
+    Error,
+};
+use futures_util::future::{FutureExt, LocalBoxFuture};
+
+use crate::utils::error_budget::ErrorBudgetTracker;
+
+/// 错误预算统计中间件，按路由模式和子系统记录请求是否出错
+///
+/// 服务端错误（5xx）和处理请求时的panic计为错误，panic记录后继续向上传播。
+pub struct ErrorBudgetRecorder {
+    tracker: Arc<ErrorBudgetTracker>,
+}
+
+impl ErrorBudgetRecorder {
+    /// 创建错误预算统计中间件
+    pub fn new(tracker: Arc<ErrorBudgetTracker>) -> Self {
+        Self { tracker }
+    }
+}
+
+impl<S, B> Transform<S, ServiceRequest> for ErrorBudgetRecorder
+where
+    S: Service<ServiceRequest, Response = ServiceResponse<B>, Error = Error> + 'static,
+    S::Future: 'static,
+    B: 'static,
+{
+    type Response = ServiceResponse<B>;
+    type Error = Error;
+    type Transform = ErrorBudgetRecorderService<S>;
+    type InitError = ();
+    type Future = Ready<Result<Self::Transform, Self::InitError>>;
+
+    fn new_transform(&self, service: S) -> Self::Future {
+        ready(Ok(ErrorBudgetRecorderService {
+            service,
+            tracker: self.tracker.clone(),
+        }))
+    }
+}
+
+pub struct ErrorBudgetRecorderService<S> {
+    service: S,
+    tracker: Arc<ErrorBudgetTracker>,
+}
+
+impl<S, B> Service<ServiceRequest> for ErrorBudgetRecorderService<S>
+where
+    S: Service<ServiceRequest, Response = ServiceResponse<B>, Error = Error> + 'static,
+    S::Future: 'static,
+    B: 'static,
+{
+    type Response = ServiceResponse<B>;
+    type Error = Error;
+    type Future = LocalBoxFuture<'static, Result<Self::Response, Self::Error>>;
+
+    forward_ready!(service);
+
+    fn call(&self, req: ServiceRequest) -> Self::Future {
+        let tracker = self.tracker.clone();
+        let method = normalize_method(req.method());
+        // panic只发生在已匹配路由的处理程序中，此时路径的子系统是有效的
+        let path_subsystem = subsystem(req.path()).to_string();
+        let fut = self.service.call(req);
+
+        Box::pin(async move {
+            let result = match AssertUnwindSafe(fut).catch_unwind().await {
+                Ok(result) => result,
+                Err(panic) => {
+                    // 路由模式在路由匹配后才能确定，panic时只能按子系统归类
+                    tracker.record_route(&format!("{} {}", method, path_subsystem), true);
+                    tracker.record_subsystem(&path_subsystem, true);
+                    std::panic::resume_unwind(panic);
+                }
+            };
+
+            // 未匹配路由的请求归为`unmatched`，任意路径不会产生新的统计项
+            let (route, error) = match &result {
+                Ok(res) => (res.request().match_pattern(), res.status().is_server_error()),
+                Err(e) => (None, e.as_response_error().status_code().is_server_error()),
+            };
+            let subsystem = route.as_deref().map_or(UNMATCHED, subsystem).to_string();
+            let route = route.unwrap_or_else(|| UNMATCHED.to_string());
+            tracker.record_route(&format!("{} {}", method, route), error);
+            tracker.record_subsystem(&subsystem, error);
+            result
+        })
+    }
+}
+
+/// 未匹配任何路由的请求的路由和子系统名
+const UNMATCHED: &str = "unmatched";
+
+/// 标准HTTP方法原样使用，其他方法归为`OTHER`
+fn normalize_method(method: &Method) -> &'static str {
+    match *method {
+        Method::GET => "GET",
+        Method::POST => "POST",
+        Method::PUT => "PUT",
+        Method::DELETE => "DELETE",
+        Method::PATCH => "PATCH",
+        Method::HEAD => "HEAD",
+        Method::OPTIONS => "OPTIONS",
+        _ => "OTHER",
+    }
+}
+
+/// 请求所属的子系统，即`/api`之后的第一段路径，例如`/api/vector/search`属于`vector`
+fn subsystem(path: &str) -> &str {
+    let mut segments = path.split('/').filter(|s| !s.is_empty());
+    match segments.next() {
+        Some("api") => segments.next().unwrap_or("api"),
+        Some(first) => first,
+        None => "root",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_subsystem() {
+        assert_eq!(subsystem("/api/vector/collections/docs"), "vector");
+        assert_eq!(subsystem("/api/db/query"), "db");
+        assert_eq!(subsystem("/api"), "api");
+        assert_eq!(subsystem("/health"), "health");
+        assert_eq!(subsystem("/"), "root");
+    }
+
+    #[test]
+    fn test_normalize_method() {
+        assert_eq!(normalize_method(&Method::GET), "GET");
+        assert_eq!(normalize_method(&Method::from_bytes(b"PROPFIND").unwrap()), "OTHER");
+    }
+}
@@ -1,5 +1,9 @@
 pub mod auth_new;
 pub mod logger;
+pub mod read_only;
+pub mod disk_guard;
+pub mod binding;
+pub mod error_budget;
 
 // Re-export the new authentication module as the default
 pub use auth_new as auth; 
\ No newline at end of file
@@ -0,0 +1,114 @@
+use std::future::{ready, Ready};
+use actix_web::{
+    dev::{forward_ready, Service, ServiceRequest, ServiceResponse, Transform},
+    body::EitherBody,
+    http::Method,
+    Error, HttpMessage, HttpRequest, HttpResponse,
+};
+use futures_util::future::LocalBoxFuture;
+use log::debug;
+
+use lumos_core::query::parser::QueryParser;
+use crate::models::response::{ApiResponse, ApiError};
+
+/// 请求的访问模式，由认证中间件写入请求扩展
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum AccessMode {
+    /// 允许读写
+    ReadWrite,
+    /// 只读（沙箱模式）
+    ReadOnly,
+}
+
+impl Default for AccessMode {
+    fn default() -> Self {
+        AccessMode::ReadWrite
+    }
+}
+
+/// 获取请求的访问模式，未设置时视为读写
+pub fn access_mode(req: &HttpRequest) -> AccessMode {
+    req.extensions().get::<AccessMode>().copied().unwrap_or_default()
+}
+
+/// 判断SQL语句是否会修改数据
+///
+/// 无法识别的语句（如PRAGMA、ATTACH）按写操作处理。
+pub fn is_write_sql(sql: &str) -> bool {
+    QueryParser::new().is_write_query(sql).unwrap_or(true)
+}
+
+/// 只读模式下拒绝写操作时返回的错误
+pub fn read_only_error() -> HttpResponse {
+    HttpResponse::Forbidden().json(ApiResponse::<()>::error(ApiError::new(
+        "READ_ONLY_MODE",
+        "Write operations are not allowed in read-only mode",
+    )))
+}
+
+/// 只读保护中间件，拒绝只读请求中的写操作
+///
+/// 查询和搜索端点由处理程序根据SQL内容自行检查。
+pub struct ReadOnlyGuard;
+
+impl<S, B> Transform<S, ServiceRequest> for ReadOnlyGuard
+where
+    S: Service<ServiceRequest, Response = ServiceResponse<B>, Error = Error> + 'static,
+    S::Future: 'static,
+    B: 'static,
+{
+    type Response = ServiceResponse<EitherBody<B>>;
+    type Error = Error;
+    type Transform = ReadOnlyGuardService<S>;
+    type InitError = ();
+    type Future = Ready<Result<Self::Transform, Self::InitError>>;
+
+    fn new_transform(&self, service: S) -> Self::Future {
+        ready(Ok(ReadOnlyGuardService { service }))
+    }
+}
+
+pub struct ReadOnlyGuardService<S> {
+    service: S,
+}
+
+impl<S, B> Service<ServiceRequest> for ReadOnlyGuardService<S>
+where
+    S: Service<ServiceRequest, Response = ServiceResponse<B>, Error = Error> + 'static,
+    S::Future: 'static,
+    B: 'static,
+{
+    type Response = ServiceResponse<EitherBody<B>>;
+    type Error = Error;
+    type Future = LocalBoxFuture<'static, Result<Self::Response, Self::Error>>;
+
+    forward_ready!(service);
+
+    fn call(&self, req: ServiceRequest) -> Self::Future {
+        let read_only = req.extensions().get::<AccessMode>().copied().unwrap_or_default() == AccessMode::ReadOnly;
+
+        if read_only && is_mutating_route(req.method(), req.path()) {
+            debug!("Rejected write request in read-only mode: {} {}", req.method(), req.path());
+            let response = read_only_error().map_into_right_body();
+            return Box::pin(async move {
+                Ok(req.into_response(response))
+            });
+        }
+
+        let fut = self.service.call(req);
+        Box::pin(async move {
+            let res = fut.await?;
+            Ok(res.map_into_left_body())
+        })
+    }
+}
+
+/// 判断请求是否为写操作路由
+pub(crate) fn is_mutating_route(method: &Method, path: &str) -> bool {
+    match *method {
+        Method::GET | Method::HEAD | Method::OPTIONS => false,
+        // 查询、查询任务、搜索、执行计划与预加载是只读操作，SQL内容在处理程序中检查
+        Method::POST => !(path.ends_with("/query") || path.ends_with("/jobs") || path.ends_with("/search") || path.ends_with("/explain") || path.ends_with("/warm")),
+        _ => true,
+    }
+}
@@ -43,6 +43,28 @@ pub struct AddEmbeddingsRequest {
     pub metadata: Option<Vec<HashMap<String, serde_json::Value>>>,
 }
 
+/// 分块上传嵌入向量的请求
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct AddEmbeddingsChunkRequest {
+    /// 客户端生成的上传ID（如UUID），同一上传的所有分块使用相同的ID
+    pub upload_id: String,
+    /// 分块序号，从0开始
+    pub chunk_index: usize,
+    /// 嵌入向量列表
+    pub ids: Vec<String>,
+    /// 向量数据
+    pub embeddings: Vec<Vec<f32>>,
+    /// 可选的元数据
+    #[serde(default)]
+    pub metadata: Option<Vec<HashMap<String, serde_json::Value>>>,
+}
+
+/// 主实例推送的复制变更
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ReplicationApplyRequest {
+    pub entries: Vec<crate::db::replication::ReplicationEntry>,
+}
+
 /// 相似度搜索请求
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct SearchRequest {
@@ -51,6 +73,9 @@ pub struct SearchRequest {
     /// 返回结果的数量
     #[serde(default = "default_top_k")]
     pub top_k: usize,
+    /// 是否为每个结果返回评分说明
+    #[serde(default)]
+    pub explain: bool,
 }
 
 /// 创建索引请求
@@ -87,6 +112,9 @@ pub struct SearchResult {
     pub vector: Option<Vec<f32>>,
     /// 可选的元数据
     pub metadata: Option<HashMap<String, serde_json::Value>>,
+    /// 评分说明（请求explain时返回）
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub explanation: Option<crate::db::vector_executor::SearchExplanation>,
 }
 
 /// 添加嵌入的响应
@@ -0,0 +1,202 @@
+use std::collections::{BTreeMap, HashMap, VecDeque};
+use std::fmt::Write;
+use std::sync::Mutex;
+use std::time::Duration;
+use serde::Serialize;
+
+/// 每个集合每种操作保留的最近延迟样本数，用于计算分位数
+const LATENCY_SAMPLES: usize = 1024;
+
+/// 单种操作的访问统计
+#[derive(Debug, Clone, Serialize)]
+pub struct OperationStats {
+    /// 操作次数
+    pub count: u64,
+    /// 失败次数
+    pub errors: u64,
+    /// 操作涉及的向量数（添加的向量数、返回的结果数）
+    pub items: u64,
+    /// 最近样本的延迟分位数（毫秒）
+    pub p50_ms: f64,
+    pub p95_ms: f64,
+    pub p99_ms: f64,
+    /// 最近样本中的最大延迟（毫秒）
+    pub max_ms: f64,
+}
+
+/// 单个集合的访问统计
+#[derive(Debug, Clone, Serialize)]
+pub struct CollectionAccessStats {
+    pub collection: String,
+    /// 按操作（search、add、delete）的统计
+    pub operations: BTreeMap<String, OperationStats>,
+}
+
+#[derive(Default)]
+struct OperationCounters {
+    count: u64,
+    errors: u64,
+    items: u64,
+    // 最近的延迟样本（微秒）
+    samples: VecDeque<u64>,
+}
+
+impl OperationCounters {
+    fn stats(&self) -> OperationStats {
+        let mut samples: Vec<u64> = self.samples.iter().copied().collect();
+        samples.sort_unstable();
+        let percentile = |p: f64| -> f64 {
+            if samples.is_empty() {
+                return 0.0;
+            }
+            let rank = ((p * samples.len() as f64).ceil() as usize).clamp(1, samples.len());
+            samples[rank - 1] as f64 / 1000.0
+        };
+        OperationStats {
+            count: self.count,
+            errors: self.errors,
+            items: self.items,
+            p50_ms: percentile(0.50),
+            p95_ms: percentile(0.95),
+            p99_ms: percentile(0.99),
+            max_ms: samples.last().map_or(0.0, |max| *max as f64 / 1000.0),
+        }
+    }
+}
+
+/// 按集合和操作统计访问次数和延迟，用于把容量和成本归属到具体的工作负载
+#[derive(Default)]
+pub struct AccessStats {
+    counters: Mutex<HashMap<String, BTreeMap<String, OperationCounters>>>,
+}
+
+impl AccessStats {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// 记录一次操作
+    pub fn record(&self, collection: &str, operation: &str, elapsed: Duration, items: usize, success: bool) {
+        let mut counters = self.counters.lock().unwrap();
+        let counter = counters.entry(collection.to_string())
+            .or_default()
+            .entry(operation.to_string())
+            .or_default();
+        counter.count += 1;
+        counter.items += items as u64;
+        if !success {
+            counter.errors += 1;
+        }
+        if counter.samples.len() == LATENCY_SAMPLES {
+            counter.samples.pop_front();
+        }
+        counter.samples.push_back(elapsed.as_micros() as u64);
+    }
+
+    /// 单个集合的统计
+    pub fn collection(&self, collection: &str) -> Option<CollectionAccessStats> {
+        let counters = self.counters.lock().unwrap();
+        counters.get(collection).map(|operations| to_stats(collection, operations))
+    }
+
+    /// 所有集合的统计，按集合名称排序
+    pub fn snapshot(&self) -> Vec<CollectionAccessStats> {
+        let counters = self.counters.lock().unwrap();
+        let mut stats: Vec<CollectionAccessStats> = counters.iter()
+            .map(|(collection, operations)| to_stats(collection, operations))
+            .collect();
+        stats.sort_by(|a, b| a.collection.cmp(&b.collection));
+        stats
+    }
+
+    /// 清除集合的统计
+    pub fn remove(&self, collection: &str) {
+        self.counters.lock().unwrap().remove(collection);
+    }
+
+    /// 以Prometheus文本格式输出，指标名称以`prefix`开头
+    pub fn render_prometheus(&self, prefix: &str) -> String {
+        let stats = self.snapshot();
+        let mut out = String::new();
+
+        let _ = writeln!(out, "# HELP {}_operations_total Operations per collection", prefix);
+        let _ = writeln!(out, "# TYPE {}_operations_total counter", prefix);
+        for collection in &stats {
+            for (operation, s) in &collection.operations {
+                let _ = writeln!(out, "{}_operations_total{{collection=\"{}\",operation=\"{}\"}} {}",
+                    prefix, escape_label(&collection.collection), operation, s.count);
+            }
+        }
+
+        let _ = writeln!(out, "# HELP {}_errors_total Failed operations per collection", prefix);
+        let _ = writeln!(out, "# TYPE {}_errors_total counter", prefix);
+        for collection in &stats {
+            for (operation, s) in &collection.operations {
+                let _ = writeln!(out, "{}_errors_total{{collection=\"{}\",operation=\"{}\"}} {}",
+                    prefix, escape_label(&collection.collection), operation, s.errors);
+            }
+        }
+
+        let _ = writeln!(out, "# HELP {}_items_total Vectors added or returned per collection", prefix);
+        let _ = writeln!(out, "# TYPE {}_items_total counter", prefix);
+        for collection in &stats {
+            for (operation, s) in &collection.operations {
+                let _ = writeln!(out, "{}_items_total{{collection=\"{}\",operation=\"{}\"}} {}",
+                    prefix, escape_label(&collection.collection), operation, s.items);
+            }
+        }
+
+        let _ = writeln!(out, "# HELP {}_latency_seconds Operation latency over recent samples", prefix);
+        let _ = writeln!(out, "# TYPE {}_latency_seconds summary", prefix);
+        for collection in &stats {
+            for (operation, s) in &collection.operations {
+                for (quantile, value) in [("0.5", s.p50_ms), ("0.95", s.p95_ms), ("0.99", s.p99_ms)] {
+                    let _ = writeln!(out, "{}_latency_seconds{{collection=\"{}\",operation=\"{}\",quantile=\"{}\"}} {}",
+                        prefix, escape_label(&collection.collection), operation, quantile, value / 1000.0);
+                }
+            }
+        }
+
+        out
+    }
+}
+
+fn to_stats(collection: &str, operations: &BTreeMap<String, OperationCounters>) -> CollectionAccessStats {
+    CollectionAccessStats {
+        collection: collection.to_string(),
+        operations: operations.iter().map(|(op, c)| (op.clone(), c.stats())).collect(),
+    }
+}
+
+/// 转义Prometheus标签值
+fn escape_label(value: &str) -> String {
+    value.replace('\\', "\\\\").replace('"', "\\\"").replace('\n', "\\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_access_stats() {
+        let stats = AccessStats::new();
+        for ms in 1..=100 {
+            stats.record("docs", "search", Duration::from_millis(ms), 10, true);
+        }
+        stats.record("docs", "add", Duration::from_millis(5), 3, false);
+
+        let docs = stats.collection("docs").unwrap();
+        let search = &docs.operations["search"];
+        assert_eq!((search.count, search.items, search.errors), (100, 1000, 0));
+        assert_eq!((search.p50_ms, search.p95_ms, search.p99_ms), (50.0, 95.0, 99.0));
+        assert_eq!(docs.operations["add"].errors, 1);
+        assert!(stats.collection("other").is_none());
+
+        let text = stats.render_prometheus("lumos_vector");
+        assert!(text.contains("lumos_vector_operations_total{collection=\"docs\",operation=\"search\"} 100"));
+        assert!(text.contains("lumos_vector_latency_seconds{collection=\"docs\",operation=\"search\",quantile=\"0.95\"} 0.095"));
+
+        stats.remove("docs");
+        assert!(stats.snapshot().is_empty());
+    }
+}
@@ -0,0 +1,228 @@
+use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+use std::sync::Arc;
+use std::time::Duration;
+use serde::Serialize;
+use tokio::sync::{OwnedSemaphorePermit, Semaphore};
+
+/// 默认排队等待时间
+pub const DEFAULT_QUEUE_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// 查询准入错误
+#[derive(Debug, thiserror::Error)]
+pub enum AdmissionError {
+    /// 排队的查询已达到上限
+    #[error("Too many queries: {queued} queries already waiting for {limit} slots")]
+    QueueFull { limit: usize, queued: usize },
+    /// 排队超时
+    #[error("Query waited {0:?} without getting a slot")]
+    Timeout(Duration),
+}
+
+/// 查询准入状态
+#[derive(Debug, Clone, Serialize)]
+pub struct AdmissionStatus {
+    /// 同时执行的查询上限
+    pub limit: usize,
+    /// 正在执行的查询数
+    pub running: usize,
+    /// 正在排队的查询数
+    pub queued: usize,
+    /// 排队的查询上限
+    pub max_queue: usize,
+    /// 因排队已满或超时被拒绝的查询数
+    pub rejected: u64,
+}
+
+/// 查询准入控制器，限制同时执行的查询数
+///
+/// 超过上限的查询排队等待，排队已满或等待超时时被拒绝。上限和排队长度可以
+/// 在运行时通过`set_limit`和`set_max_queue`调整，调小上限时正在执行的查询
+/// 不受影响，空出的名额不再发放。
+pub struct AdmissionController {
+    semaphore: Arc<Semaphore>,
+    limit: AtomicUsize,
+    // 调小上限后尚未收回的名额
+    excess: Arc<AtomicUsize>,
+    running: Arc<AtomicUsize>,
+    queued: AtomicUsize,
+    max_queue: AtomicUsize,
+    queue_timeout: Duration,
+    rejected: AtomicU64,
+}
+
+impl AdmissionController {
+    /// 创建准入控制器，最多同时执行`limit`个查询，最多`max_queue`个查询排队
+    pub fn new(limit: usize, max_queue: usize) -> Self {
+        let limit = limit.max(1);
+        Self {
+            semaphore: Arc::new(Semaphore::new(limit)),
+            limit: AtomicUsize::new(limit),
+            excess: Arc::new(AtomicUsize::new(0)),
+            running: Arc::new(AtomicUsize::new(0)),
+            queued: AtomicUsize::new(0),
+            max_queue: AtomicUsize::new(max_queue),
+            queue_timeout: DEFAULT_QUEUE_TIMEOUT,
+            rejected: AtomicU64::new(0),
+        }
+    }
+
+    /// 设置排队等待时间
+    pub fn with_queue_timeout(mut self, timeout: Duration) -> Self {
+        self.queue_timeout = timeout;
+        self
+    }
+
+    /// 同时执行的查询上限
+    pub fn limit(&self) -> usize {
+        self.limit.load(Ordering::SeqCst)
+    }
+
+    /// 调整同时执行的查询上限，供资源监控根据负载回调
+    pub fn set_limit(&self, limit: usize) {
+        let limit = limit.max(1);
+        let old = self.limit.swap(limit, Ordering::SeqCst);
+
+        if limit > old {
+            // 先抵消尚未收回的名额，剩余的直接发放
+            let mut grow = limit - old;
+            let cancelled = take_up_to(&self.excess, grow);
+            grow -= cancelled;
+            self.semaphore.add_permits(grow);
+        } else if limit < old {
+            // 空闲的名额立即收回，其余在查询结束时收回
+            let mut shrink = old - limit;
+            while shrink > 0 {
+                match self.semaphore.try_acquire() {
+                    Ok(permit) => {
+                        permit.forget();
+                        shrink -= 1;
+                    }
+                    Err(_) => break,
+                }
+            }
+            self.excess.fetch_add(shrink, Ordering::SeqCst);
+        }
+    }
+
+    /// 排队的查询上限
+    pub fn max_queue(&self) -> usize {
+        self.max_queue.load(Ordering::SeqCst)
+    }
+
+    /// 调整排队的查询上限，已在排队的查询不受影响
+    pub fn set_max_queue(&self, max_queue: usize) {
+        self.max_queue.store(max_queue, Ordering::SeqCst);
+    }
+
+    /// 申请执行名额，名额不足时排队等待
+    pub async fn admit(&self) -> Result<AdmissionPermit, AdmissionError> {
+        let permit = match self.semaphore.clone().try_acquire_owned() {
+            Ok(permit) => permit,
+            Err(_) => self.wait().await?,
+        };
+
+        self.running.fetch_add(1, Ordering::SeqCst);
+        Ok(AdmissionPermit {
+            permit: Some(permit),
+            excess: self.excess.clone(),
+            running: self.running.clone(),
+        })
+    }
+
+    /// 排队等待名额
+    async fn wait(&self) -> Result<OwnedSemaphorePermit, AdmissionError> {
+        let queued = self.queued.fetch_add(1, Ordering::SeqCst);
+        if queued >= self.max_queue() {
+            self.queued.fetch_sub(1, Ordering::SeqCst);
+            self.rejected.fetch_add(1, Ordering::Relaxed);
+            return Err(AdmissionError::QueueFull { limit: self.limit(), queued });
+        }
+
+        let result = tokio::time::timeout(self.queue_timeout, self.semaphore.clone().acquire_owned()).await;
+        self.queued.fetch_sub(1, Ordering::SeqCst);
+
+        match result {
+            // 信号量不会被关闭
+            Ok(permit) => Ok(permit.expect("admission semaphore closed")),
+            Err(_) => {
+                self.rejected.fetch_add(1, Ordering::Relaxed);
+                Err(AdmissionError::Timeout(self.queue_timeout))
+            }
+        }
+    }
+
+    /// 获取准入状态
+    pub fn status(&self) -> AdmissionStatus {
+        AdmissionStatus {
+            limit: self.limit(),
+            running: self.running.load(Ordering::SeqCst),
+            queued: self.queued.load(Ordering::SeqCst),
+            max_queue: self.max_queue(),
+            rejected: self.rejected.load(Ordering::Relaxed),
+        }
+    }
+}
+
+/// 查询执行名额，释放时归还
+pub struct AdmissionPermit {
+    permit: Option<OwnedSemaphorePermit>,
+    excess: Arc<AtomicUsize>,
+    running: Arc<AtomicUsize>,
+}
+
+impl Drop for AdmissionPermit {
+    fn drop(&mut self) {
+        self.running.fetch_sub(1, Ordering::SeqCst);
+        if let Some(permit) = self.permit.take() {
+            // 上限已调小时收回名额而不是归还
+            if take_up_to(&self.excess, 1) == 1 {
+                permit.forget();
+            }
+        }
+    }
+}
+
+/// 从计数中最多取走`n`，返回实际取走的数量
+fn take_up_to(counter: &AtomicUsize, n: usize) -> usize {
+    let mut taken = 0;
+    let _ = counter.fetch_update(Ordering::SeqCst, Ordering::SeqCst, |current| {
+        taken = current.min(n);
+        Some(current - taken)
+    });
+    taken
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_admission_limits() {
+        let controller = AdmissionController::new(2, 1)
+            .with_queue_timeout(Duration::from_millis(20));
+
+        let first = controller.admit().await.unwrap();
+        let second = controller.admit().await.unwrap();
+        assert_eq!(controller.status().running, 2);
+
+        // 名额用完后排队，等待超时被拒绝
+        assert!(matches!(controller.admit().await, Err(AdmissionError::Timeout(_))));
+
+        // 调小上限后，结束的查询不再归还名额
+        controller.set_limit(1);
+        drop(first);
+        assert!(controller.admit().await.is_err());
+
+        drop(second);
+        let third = controller.admit().await.unwrap();
+        assert_eq!(controller.status().running, 1);
+
+        controller.set_limit(3);
+        let _fourth = controller.admit().await.unwrap();
+        let _fifth = controller.admit().await.unwrap();
+        drop(third);
+
+        let status = controller.status();
+        assert_eq!((status.limit, status.running, status.rejected), (3, 2, 2));
+    }
+}
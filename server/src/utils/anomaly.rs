@@ -0,0 +1,356 @@
+use std::collections::{HashMap, VecDeque};
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+use chrono::Utc;
+use serde::Serialize;
+use super::retention::{RetentionRule, RetentionTarget, DEFAULT_USAGE_ENTRIES};
+
+/// 默认统计窗口
+pub const DEFAULT_WINDOW: Duration = Duration::from_secs(60);
+
+/// 默认灵敏度，偏离基线多少个标准差视为异常
+pub const DEFAULT_SENSITIVITY: f64 = 3.0;
+
+/// 建立基线所需的最少窗口数，之前不报警
+pub const DEFAULT_WARMUP_WINDOWS: u32 = 5;
+
+/// 基线的指数加权系数
+const BASELINE_ALPHA: f64 = 0.2;
+
+/// 一次最多结算的空闲窗口数，之后基线已基本收敛到零负载
+const MAX_IDLE_WINDOWS: u32 = 30;
+
+/// 被监测的负载指标
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum WorkloadMetric {
+    /// 每个窗口的查询数
+    QueryRate,
+    /// 每个窗口失败查询的比例
+    ErrorRate,
+    /// 每个窗口返回或影响的行数
+    RowVolume,
+}
+
+impl WorkloadMetric {
+    const ALL: [WorkloadMetric; 3] = [WorkloadMetric::QueryRate, WorkloadMetric::ErrorRate, WorkloadMetric::RowVolume];
+
+    // 标准差的下限，避免平稳负载下的微小波动触发告警
+    fn min_deviation(&self) -> f64 {
+        match self {
+            WorkloadMetric::QueryRate => 5.0,
+            WorkloadMetric::ErrorRate => 0.1,
+            WorkloadMetric::RowVolume => 100.0,
+        }
+    }
+}
+
+/// 负载异常告警
+#[derive(Debug, Clone, Serialize)]
+pub struct AnomalyAlert {
+    /// 密钥标识
+    pub key: String,
+    /// 异常指标
+    pub metric: WorkloadMetric,
+    /// 该窗口的实际值
+    pub value: f64,
+    /// 基线均值
+    pub baseline: f64,
+    /// 偏离基线的标准差数
+    pub score: f64,
+    /// 告警时间戳（秒）
+    pub timestamp: i64,
+}
+
+/// 某个指标的基线
+#[derive(Debug, Clone, Copy, Default, Serialize)]
+pub struct Baseline {
+    /// 加权均值
+    pub mean: f64,
+    /// 加权方差
+    pub variance: f64,
+}
+
+impl Baseline {
+    fn update(&mut self, value: f64, windows: u32) {
+        if windows == 0 {
+            self.mean = value;
+            return;
+        }
+        let diff = value - self.mean;
+        self.mean += BASELINE_ALPHA * diff;
+        self.variance = (1.0 - BASELINE_ALPHA) * (self.variance + BASELINE_ALPHA * diff * diff);
+    }
+}
+
+/// 某个密钥的负载概况
+#[derive(Debug, Clone, Serialize)]
+pub struct KeyWorkloadStatus {
+    /// 密钥标识
+    pub key: String,
+    /// 已计入基线的窗口数
+    pub windows: u32,
+    /// 当前窗口的查询数
+    pub queries: u64,
+    /// 当前窗口的失败查询数
+    pub errors: u64,
+    /// 当前窗口的行数
+    pub rows: u64,
+    /// 查询数基线
+    pub query_rate: Baseline,
+    /// 失败比例基线
+    pub error_rate: Baseline,
+    /// 行数基线
+    pub row_volume: Baseline,
+}
+
+struct KeyWorkload {
+    window_start: Instant,
+    queries: u64,
+    errors: u64,
+    rows: u64,
+    windows: u32,
+    baselines: [Baseline; 3],
+}
+
+impl KeyWorkload {
+    fn new(now: Instant) -> Self {
+        Self {
+            window_start: now,
+            queries: 0,
+            errors: 0,
+            rows: 0,
+            windows: 0,
+            baselines: [Baseline::default(); 3],
+        }
+    }
+
+    fn value(&self, metric: WorkloadMetric) -> f64 {
+        match metric {
+            WorkloadMetric::QueryRate => self.queries as f64,
+            WorkloadMetric::ErrorRate if self.queries == 0 => 0.0,
+            WorkloadMetric::ErrorRate => self.errors as f64 / self.queries as f64,
+            WorkloadMetric::RowVolume => self.rows as f64,
+        }
+    }
+}
+
+/// 负载异常检测器
+///
+/// 按密钥统计每个窗口的查询数、失败比例和行数，用指数加权均值和方差建立
+/// 基线。窗口结束时某个指标高于基线均值超过`sensitivity`个标准差即产生
+/// 告警，用于发现滥用或失控的任务。检测器不启动后台线程，窗口在记录查询
+/// 或调用`check`时结算。
+pub struct AnomalyDetector {
+    window: Duration,
+    sensitivity: f64,
+    warmup_windows: u32,
+    workloads: Mutex<HashMap<String, KeyWorkload>>,
+    alerts: Mutex<VecDeque<AnomalyAlert>>,
+    max_alerts: usize,
+}
+
+impl AnomalyDetector {
+    /// 创建检测器，偏离基线超过`sensitivity`个标准差时告警
+    pub fn new(sensitivity: f64) -> Self {
+        Self {
+            window: DEFAULT_WINDOW,
+            sensitivity,
+            warmup_windows: DEFAULT_WARMUP_WINDOWS,
+            workloads: Mutex::new(HashMap::new()),
+            alerts: Mutex::new(VecDeque::new()),
+            max_alerts: DEFAULT_USAGE_ENTRIES,
+        }
+    }
+
+    /// 设置最多保留的告警数，超过时丢弃最早的告警
+    pub fn with_max_alerts(mut self, max_alerts: usize) -> Self {
+        self.max_alerts = max_alerts.max(1);
+        self
+    }
+
+    /// 设置统计窗口
+    pub fn with_window(mut self, window: Duration) -> Self {
+        self.window = window;
+        self
+    }
+
+    /// 设置建立基线所需的窗口数
+    pub fn with_warmup_windows(mut self, windows: u32) -> Self {
+        self.warmup_windows = windows;
+        self
+    }
+
+    /// 灵敏度
+    pub fn sensitivity(&self) -> f64 {
+        self.sensitivity
+    }
+
+    /// 记录`key`执行的一次查询
+    pub fn record(&self, key: &str, rows: u64, failed: bool) {
+        self.record_at(key, rows, failed, Instant::now());
+    }
+
+    fn record_at(&self, key: &str, rows: u64, failed: bool, now: Instant) {
+        let mut workloads = self.workloads.lock().unwrap();
+        let workload = workloads.entry(key.to_string()).or_insert_with(|| KeyWorkload::new(now));
+        let alerts = self.roll(key, workload, now);
+        self.push_alerts(alerts);
+
+        workload.queries += 1;
+        workload.rows += rows;
+        if failed {
+            workload.errors += 1;
+        }
+    }
+
+    /// 结算所有已结束的窗口，返回新产生的告警
+    pub fn check(&self) -> Vec<AnomalyAlert> {
+        self.check_at(Instant::now())
+    }
+
+    fn check_at(&self, now: Instant) -> Vec<AnomalyAlert> {
+        let mut alerts = Vec::new();
+        let mut workloads = self.workloads.lock().unwrap();
+        for (key, workload) in workloads.iter_mut() {
+            alerts.extend(self.roll(key, workload, now));
+        }
+        drop(workloads);
+
+        self.push_alerts(alerts.clone());
+        alerts
+    }
+
+    /// 最近的告警，最新的在后
+    pub fn alerts(&self) -> Vec<AnomalyAlert> {
+        self.alerts.lock().unwrap().iter().cloned().collect()
+    }
+
+    /// 各密钥的负载概况
+    pub fn status(&self) -> Vec<KeyWorkloadStatus> {
+        let mut status: Vec<KeyWorkloadStatus> = self.workloads.lock().unwrap()
+            .iter()
+            .map(|(key, workload)| KeyWorkloadStatus {
+                key: key.clone(),
+                windows: workload.windows,
+                queries: workload.queries,
+                errors: workload.errors,
+                rows: workload.rows,
+                query_rate: workload.baselines[0],
+                error_rate: workload.baselines[1],
+                row_volume: workload.baselines[2],
+            })
+            .collect();
+        status.sort_by(|a, b| a.key.cmp(&b.key));
+        status
+    }
+
+    fn push_alerts(&self, new_alerts: Vec<AnomalyAlert>) {
+        if new_alerts.is_empty() {
+            return;
+        }
+        let mut alerts = self.alerts.lock().unwrap();
+        for alert in new_alerts {
+            if alerts.len() >= self.max_alerts {
+                alerts.pop_front();
+            }
+            alerts.push_back(alert);
+        }
+    }
+
+    // 结算已结束的窗口，空闲期间的窗口按零负载计入基线
+    fn roll(&self, key: &str, workload: &mut KeyWorkload, now: Instant) -> Vec<AnomalyAlert> {
+        let mut alerts = Vec::new();
+        let elapsed = now.duration_since(workload.window_start);
+        if elapsed < self.window {
+            return alerts;
+        }
+
+        self.close_window(key, workload, &mut alerts);
+        let idle = (elapsed.as_secs_f64() / self.window.as_secs_f64()) as u32 - 1;
+        for _ in 0..idle.min(MAX_IDLE_WINDOWS) {
+            self.close_window(key, workload, &mut alerts);
+        }
+        workload.window_start = now;
+        alerts
+    }
+
+    fn close_window(&self, key: &str, workload: &mut KeyWorkload, alerts: &mut Vec<AnomalyAlert>) {
+        for (i, metric) in WorkloadMetric::ALL.iter().enumerate() {
+            let value = workload.value(*metric);
+            let baseline = &mut workload.baselines[i];
+
+            if workload.windows >= self.warmup_windows {
+                let deviation = baseline.variance.sqrt().max(metric.min_deviation());
+                let score = (value - baseline.mean) / deviation;
+                if score > self.sensitivity {
+                    log::warn!(
+                        "Workload anomaly for key '{}': {:?} is {:.1} against a baseline of {:.1}",
+                        key, metric, value, baseline.mean
+                    );
+                    alerts.push(AnomalyAlert {
+                        key: key.to_string(),
+                        metric: *metric,
+                        value,
+                        baseline: baseline.mean,
+                        score,
+                        timestamp: Utc::now().timestamp(),
+                    });
+                }
+            }
+
+            baseline.update(value, workload.windows);
+        }
+
+        workload.windows = workload.windows.saturating_add(1);
+        workload.queries = 0;
+        workload.errors = 0;
+        workload.rows = 0;
+    }
+}
+
+impl RetentionTarget for AnomalyDetector {
+    fn enforce(&self, rule: &RetentionRule) -> Result<usize, String> {
+        let mut alerts = self.alerts.lock().unwrap();
+        let before = alerts.len();
+        if let Some(max_age) = rule.max_age {
+            let cutoff = Utc::now().timestamp().saturating_sub(max_age.as_secs() as i64);
+            alerts.retain(|alert| alert.timestamp >= cutoff);
+        }
+        if let Some(max_entries) = rule.max_entries {
+            while alerts.len() > max_entries {
+                alerts.pop_front();
+            }
+        }
+        Ok(before - alerts.len())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_alerts_on_query_spike() {
+        let window = Duration::from_secs(60);
+        let detector = AnomalyDetector::new(3.0).with_window(window).with_warmup_windows(3);
+        let start = Instant::now();
+
+        // 基线：每个窗口10个查询
+        for w in 0..5u32 {
+            for _ in 0..10 {
+                detector.record_at("app-0", 1, false, start + window * w);
+            }
+        }
+        assert!(detector.check_at(start + window * 5).is_empty());
+
+        // 突增到每个窗口200个查询
+        for _ in 0..200 {
+            detector.record_at("app-0", 1, false, start + window * 5);
+        }
+        let alerts = detector.check_at(start + window * 6);
+        assert!(alerts.iter().any(|a| a.key == "app-0" && a.metric == WorkloadMetric::QueryRate));
+        assert!(!alerts.iter().any(|a| a.metric == WorkloadMetric::ErrorRate));
+        assert_eq!(detector.alerts().len(), alerts.len());
+    }
+}
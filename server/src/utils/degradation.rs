@@ -0,0 +1,291 @@
+use std::borrow::Cow;
+use std::sync::{Arc, RwLock};
+use serde::Serialize;
+
+use lumos_core::query::parser::QueryParser;
+use lumos_core::query::QueryType;
+use crate::utils::disk_guard::DiskGuard;
+use crate::utils::memory_budget::MemoryBudget;
+
+/// 资源压力级别
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum PressureLevel {
+    /// 资源充足
+    Normal,
+    /// 资源紧张
+    High,
+    /// 资源即将耗尽
+    Critical,
+}
+
+/// 降级策略，定义各压力级别的阈值与降级动作
+#[derive(Debug, Clone)]
+pub struct DegradationPolicy {
+    /// 内存预算使用率达到该百分比时视为紧张
+    pub memory_high_percent: u8,
+    /// 内存预算使用率达到该百分比时视为即将耗尽
+    pub memory_critical_percent: u8,
+    /// 可用磁盘空间低于保留空间的该倍数时视为紧张
+    pub disk_high_reserve_factor: u64,
+    /// 内存即将耗尽时停止缓存查询结果
+    pub disable_cache_on_critical_memory: bool,
+    /// 内存即将耗尽时查询最多返回的行数，None表示不限制
+    pub sample_rows_on_critical_memory: Option<usize>,
+}
+
+impl Default for DegradationPolicy {
+    fn default() -> Self {
+        Self {
+            memory_high_percent: 80,
+            memory_critical_percent: 95,
+            disk_high_reserve_factor: 2,
+            disable_cache_on_critical_memory: true,
+            sample_rows_on_critical_memory: Some(10_000),
+        }
+    }
+}
+
+impl DegradationPolicy {
+    /// 设置内存压力阈值（百分比）
+    pub fn with_memory_thresholds(mut self, high_percent: u8, critical_percent: u8) -> Self {
+        self.memory_high_percent = high_percent;
+        self.memory_critical_percent = critical_percent;
+        self
+    }
+
+    /// 设置内存即将耗尽时是否停止缓存
+    pub fn with_disable_cache(mut self, disable: bool) -> Self {
+        self.disable_cache_on_critical_memory = disable;
+        self
+    }
+
+    /// 设置内存即将耗尽时查询最多返回的行数
+    pub fn with_sample_rows(mut self, rows: Option<usize>) -> Self {
+        self.sample_rows_on_critical_memory = rows;
+        self
+    }
+}
+
+/// 降级状态
+#[derive(Debug, Clone, Serialize)]
+pub struct DegradationStatus {
+    /// 内存压力级别
+    pub memory: PressureLevel,
+    /// 磁盘压力级别
+    pub disk: PressureLevel,
+    /// 是否缓存查询结果
+    pub caching_enabled: bool,
+    /// 查询最多返回的行数
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub row_limit: Option<usize>,
+    /// 是否拒绝写操作
+    pub writes_paused: bool,
+}
+
+/// 降级控制器，根据内存预算和磁盘空间决定当前启用的降级动作
+///
+/// 每次调用时重新计算压力级别，资源恢复后降级动作自动解除。策略可以在
+/// 运行时通过`set_policy`调整。
+pub struct DegradationController {
+    policy: RwLock<DegradationPolicy>,
+    memory: Option<Arc<MemoryBudget>>,
+    disk: Option<Arc<DiskGuard>>,
+}
+
+impl DegradationController {
+    /// 创建降级控制器
+    pub fn new(policy: DegradationPolicy) -> Self {
+        Self {
+            policy: RwLock::new(policy),
+            memory: None,
+            disk: None,
+        }
+    }
+
+    /// 根据内存预算的使用率判断内存压力
+    pub fn with_memory_budget(mut self, budget: Arc<MemoryBudget>) -> Self {
+        self.memory = Some(budget);
+        self
+    }
+
+    /// 根据磁盘剩余空间判断磁盘压力
+    pub fn with_disk_guard(mut self, guard: Arc<DiskGuard>) -> Self {
+        self.disk = Some(guard);
+        self
+    }
+
+    /// 当前的降级策略
+    pub fn policy(&self) -> DegradationPolicy {
+        self.policy.read().unwrap().clone()
+    }
+
+    /// 替换降级策略，之后的判断立即使用新的阈值
+    pub fn set_policy(&self, policy: DegradationPolicy) {
+        *self.policy.write().unwrap() = policy;
+    }
+
+    /// 当前内存压力级别
+    pub fn memory_pressure(&self) -> PressureLevel {
+        let budget = match &self.memory {
+            Some(budget) if budget.limit() > 0 => budget,
+            _ => return PressureLevel::Normal,
+        };
+
+        let percent = budget.used() as u128 * 100 / budget.limit() as u128;
+        let policy = self.policy.read().unwrap();
+        if percent >= policy.memory_critical_percent as u128 {
+            PressureLevel::Critical
+        } else if percent >= policy.memory_high_percent as u128 {
+            PressureLevel::High
+        } else {
+            PressureLevel::Normal
+        }
+    }
+
+    /// 当前磁盘压力级别，无法获取磁盘空间时视为资源充足
+    pub fn disk_pressure(&self) -> PressureLevel {
+        let guard = match &self.disk {
+            Some(guard) => guard,
+            None => return PressureLevel::Normal,
+        };
+
+        let high_factor = self.policy.read().unwrap().disk_high_reserve_factor;
+        match guard.available_bytes() {
+            Ok(available) if available < guard.reserve_bytes() => PressureLevel::Critical,
+            Ok(available) if available < guard.reserve_bytes().saturating_mul(high_factor) => PressureLevel::High,
+            _ => PressureLevel::Normal,
+        }
+    }
+
+    /// 是否缓存查询结果
+    pub fn caching_enabled(&self) -> bool {
+        !(self.policy.read().unwrap().disable_cache_on_critical_memory && self.memory_pressure() == PressureLevel::Critical)
+    }
+
+    /// 查询最多返回的行数
+    pub fn row_limit(&self) -> Option<usize> {
+        match self.memory_pressure() {
+            PressureLevel::Critical => self.policy.read().unwrap().sample_rows_on_critical_memory,
+            _ => None,
+        }
+    }
+
+    /// 在需要时为查询语句加上行数限制，写语句保持不变
+    pub fn limit_query<'a>(&self, sql: &'a str) -> Cow<'a, str> {
+        match self.row_limit() {
+            Some(limit) => apply_row_limit(sql, limit),
+            None => Cow::Borrowed(sql),
+        }
+    }
+
+    /// 获取降级状态
+    pub fn status(&self) -> DegradationStatus {
+        let disk = self.disk_pressure();
+        DegradationStatus {
+            memory: self.memory_pressure(),
+            disk,
+            caching_enabled: self.caching_enabled(),
+            row_limit: self.row_limit(),
+            writes_paused: disk == PressureLevel::Critical,
+        }
+    }
+}
+
+/// 将SELECT语句包装为带行数限制的子查询
+fn apply_row_limit(sql: &str, limit: usize) -> Cow<'_, str> {
+    match QueryParser::new().parse_query_type(sql) {
+        Ok(QueryType::Select) => {
+            let inner = strip_trailing(sql);
+            Cow::Owned(format!("SELECT * FROM ({}) LIMIT {}", inner, limit))
+        }
+        _ => Cow::Borrowed(sql),
+    }
+}
+
+/// 去掉语句末尾的注释、分号和空白
+///
+/// 末尾的行注释会吞掉包装时追加的`) LIMIT`，因此截到最后一个有效字符为止。
+/// 字符串和带引号的标识符中的`--`、`;`不视为注释和语句结束。
+fn strip_trailing(sql: &str) -> &str {
+    let mut chars = sql.char_indices().peekable();
+    let mut end = 0;
+    while let Some((i, c)) = chars.next() {
+        match c {
+            '-' if matches!(chars.peek(), Some((_, '-'))) => {
+                while let Some((_, c)) = chars.next() {
+                    if c == '\n' {
+                        break;
+                    }
+                }
+            }
+            '/' if matches!(chars.peek(), Some((_, '*'))) => {
+                chars.next();
+                let mut prev = ' ';
+                while let Some((_, c)) = chars.next() {
+                    if prev == '*' && c == '/' {
+                        break;
+                    }
+                    prev = c;
+                }
+            }
+            '\'' | '"' | '`' | '[' => {
+                let close = if c == '[' { ']' } else { c };
+                end = sql.len();
+                while let Some((j, c)) = chars.next() {
+                    if c == close {
+                        end = j + c.len_utf8();
+                        break;
+                    }
+                }
+            }
+            ';' => {}
+            c if c.is_whitespace() => {}
+            c => end = i + c.len_utf8(),
+        }
+    }
+    &sql[..end]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_memory_pressure_policies() {
+        let budget = Arc::new(MemoryBudget::new(100));
+        let controller = DegradationController::new(DegradationPolicy::default().with_sample_rows(Some(10)))
+            .with_memory_budget(budget.clone());
+
+        assert!(controller.caching_enabled());
+        assert_eq!(controller.limit_query("SELECT * FROM t"), "SELECT * FROM t");
+
+        assert!(budget.try_reserve("cache", 85));
+        assert_eq!(controller.memory_pressure(), PressureLevel::High);
+        assert!(controller.caching_enabled());
+
+        assert!(budget.try_reserve("cache", 10));
+        assert_eq!(controller.status().memory, PressureLevel::Critical);
+        assert!(!controller.caching_enabled());
+        assert_eq!(controller.limit_query("SELECT * FROM t;"), "SELECT * FROM (SELECT * FROM t) LIMIT 10");
+        assert_eq!(controller.limit_query("DELETE FROM t"), "DELETE FROM t");
+        assert_eq!(
+            controller.limit_query("SELECT * FROM t -- all rows"),
+            "SELECT * FROM (SELECT * FROM t) LIMIT 10"
+        );
+        assert_eq!(
+            controller.limit_query("SELECT * FROM t; /* done */"),
+            "SELECT * FROM (SELECT * FROM t) LIMIT 10"
+        );
+        assert_eq!(
+            controller.limit_query("SELECT '--;' AS s -- note\nFROM t"),
+            "SELECT * FROM (SELECT '--;' AS s -- note\nFROM t) LIMIT 10"
+        );
+
+        controller.set_policy(controller.policy().with_sample_rows(Some(5)));
+        assert_eq!(controller.row_limit(), Some(5));
+
+        budget.release("cache", 95);
+        assert_eq!(controller.row_limit(), None);
+    }
+}
@@ -0,0 +1,138 @@
+use std::io;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
+use log::error;
+use serde::Serialize;
+use thiserror::Error;
+
+/// 磁盘空间不足错误
+#[derive(Error, Debug)]
+pub enum DiskSpaceError {
+    #[error("磁盘空间不足: {path} 可用 {available} 字节，写入 {required} 字节后将低于保留的 {reserve} 字节")]
+    InsufficientSpace {
+        path: String,
+        available: u64,
+        required: u64,
+        reserve: u64,
+    },
+
+    #[error("无法获取磁盘空间: {0}")]
+    Io(#[from] io::Error),
+}
+
+/// 磁盘空间状态
+#[derive(Debug, Clone, Serialize)]
+pub struct DiskSpaceStatus {
+    /// 检查的路径
+    pub path: String,
+    /// 可用字节数
+    pub available_bytes: u64,
+    /// 保留字节数
+    pub reserve_bytes: u64,
+    /// 被拒绝的写操作次数
+    pub refused_writes: u64,
+}
+
+/// 磁盘空间保护，在大量写入前检查剩余空间
+///
+/// 写入后剩余空间会低于保留值时拒绝写入并记录告警，避免写满宿主机文件系统。
+pub struct DiskGuard {
+    path: PathBuf,
+    reserve_bytes: u64,
+    refused: AtomicU64,
+}
+
+impl DiskGuard {
+    /// 为路径所在的文件系统创建磁盘空间保护
+    pub fn new<P: AsRef<Path>>(path: P, reserve_bytes: u64) -> Self {
+        Self {
+            path: path.as_ref().to_path_buf(),
+            reserve_bytes,
+            refused: AtomicU64::new(0),
+        }
+    }
+
+    /// 保留字节数
+    pub fn reserve_bytes(&self) -> u64 {
+        self.reserve_bytes
+    }
+
+    /// 获取文件系统的可用字节数
+    pub fn available_bytes(&self) -> io::Result<u64> {
+        available_space(&self.path)
+    }
+
+    /// 检查能否写入指定字节数
+    pub fn check(&self, write_bytes: u64) -> Result<(), DiskSpaceError> {
+        let available = self.available_bytes()?;
+        if available.saturating_sub(write_bytes) >= self.reserve_bytes {
+            return Ok(());
+        }
+
+        let refused = self.refused.fetch_add(1, Ordering::Relaxed) + 1;
+        error!(
+            "Disk space alert: refused write of {} bytes to {}, {} bytes available, reserve is {} bytes ({} writes refused)",
+            write_bytes, self.path.display(), available, self.reserve_bytes, refused
+        );
+
+        Err(DiskSpaceError::InsufficientSpace {
+            path: self.path.display().to_string(),
+            available,
+            required: write_bytes,
+            reserve: self.reserve_bytes,
+        })
+    }
+
+    /// 获取磁盘空间状态
+    pub fn status(&self) -> io::Result<DiskSpaceStatus> {
+        Ok(DiskSpaceStatus {
+            path: self.path.display().to_string(),
+            available_bytes: self.available_bytes()?,
+            reserve_bytes: self.reserve_bytes,
+            refused_writes: self.refused.load(Ordering::Relaxed),
+        })
+    }
+}
+
+/// 获取路径所在文件系统的可用字节数，路径不存在时检查其父目录
+#[cfg(unix)]
+fn available_space(path: &Path) -> io::Result<u64> {
+    use std::ffi::CString;
+    use std::os::unix::ffi::OsStrExt;
+
+    let existing = path.ancestors()
+        .find(|p| !p.as_os_str().is_empty() && p.exists())
+        .unwrap_or_else(|| Path::new("."));
+    let c_path = CString::new(existing.as_os_str().as_bytes())
+        .map_err(|e| io::Error::new(io::ErrorKind::InvalidInput, e))?;
+
+    let mut stat: libc::statvfs = unsafe { std::mem::zeroed() };
+    if unsafe { libc::statvfs(c_path.as_ptr(), &mut stat) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(stat.f_bavail as u64 * stat.f_frsize as u64)
+}
+
+/// 非Unix平台不支持检查磁盘空间，视为空间充足
+#[cfg(not(unix))]
+fn available_space(_path: &Path) -> io::Result<u64> {
+    Ok(u64::MAX)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_reserve_threshold() {
+        let guard = DiskGuard::new(std::env::temp_dir(), 0);
+        let available = guard.available_bytes().unwrap();
+        assert!(available > 0);
+        assert!(guard.check(0).is_ok());
+
+        let guard = DiskGuard::new(std::env::temp_dir().join("missing/file.db"), u64::MAX);
+        assert!(matches!(guard.check(1), Err(DiskSpaceError::InsufficientSpace { .. })));
+        assert_eq!(guard.status().unwrap().refused_writes, 1);
+    }
+}
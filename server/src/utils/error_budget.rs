@@ -0,0 +1,241 @@
+use std::collections::{HashMap, VecDeque};
+use std::sync::Mutex;
+use std::time::Instant;
+use serde::Serialize;
+
+/// 默认的可用性目标，即错误预算为0.1%
+pub const DEFAULT_TARGET: f64 = 0.999;
+
+/// 统计桶的长度（秒）
+const BUCKET_SECS: u64 = 60;
+
+/// 短窗口的桶数，用于发现突发的错误
+const SHORT_WINDOW_BUCKETS: u64 = 5;
+
+/// 长窗口的桶数，用于发现持续消耗预算
+const LONG_WINDOW_BUCKETS: u64 = 60;
+
+/// 窗口内请求数少于该值时不判断预算消耗
+const MIN_REQUESTS: u64 = 20;
+
+/// 两个窗口的消耗速率都不低于该值时视为严重
+const CRITICAL_BURN_RATE: f64 = 10.0;
+
+/// 错误预算的消耗级别
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum BudgetLevel {
+    /// 错误率在目标之内
+    Ok,
+    /// 长窗口内消耗预算的速度超过目标允许的速度
+    Warning,
+    /// 短窗口和长窗口内都在快速消耗预算
+    Critical,
+}
+
+/// 一个时间窗口内的统计
+#[derive(Debug, Clone, Serialize)]
+pub struct WindowStats {
+    pub window_secs: u64,
+    pub requests: u64,
+    pub errors: u64,
+    pub error_rate: f64,
+    /// 错误率与错误预算之比，1表示恰好按目标速度消耗预算
+    pub burn_rate: f64,
+}
+
+/// 一个路由或子系统的错误预算
+#[derive(Debug, Clone, Serialize)]
+pub struct BudgetEntry {
+    /// 路由为`方法 路由模式`，子系统为名称
+    pub name: String,
+    pub short: WindowStats,
+    pub long: WindowStats,
+    pub level: BudgetLevel,
+}
+
+/// 错误预算状态，通过健康检查接口返回
+#[derive(Debug, Clone, Serialize)]
+pub struct ErrorBudgetStatus {
+    pub target: f64,
+    /// 所有路由和子系统中最严重的级别
+    pub level: BudgetLevel,
+    /// 有请求的路由，按消耗速率从高到低排列
+    pub routes: Vec<BudgetEntry>,
+    /// 有请求的子系统，按消耗速率从高到低排列
+    pub subsystems: Vec<BudgetEntry>,
+}
+
+#[derive(Default)]
+struct Bucket {
+    index: u64,
+    requests: u64,
+    errors: u64,
+}
+
+/// 按分钟分桶的请求和错误计数，只保留长窗口内的桶
+#[derive(Default)]
+struct Series {
+    buckets: VecDeque<Bucket>,
+}
+
+impl Series {
+    fn record(&mut self, index: u64, error: bool) {
+        if self.buckets.back().map_or(true, |b| b.index != index) {
+            self.buckets.push_back(Bucket { index, ..Default::default() });
+        }
+        while self.buckets.front().map_or(false, |b| b.index + LONG_WINDOW_BUCKETS <= index) {
+            self.buckets.pop_front();
+        }
+        let bucket = self.buckets.back_mut().unwrap();
+        bucket.requests += 1;
+        if error {
+            bucket.errors += 1;
+        }
+    }
+
+    fn window(&self, now: u64, buckets: u64, budget: f64) -> WindowStats {
+        let (requests, errors) = self.buckets.iter()
+            .filter(|b| b.index + buckets > now)
+            .fold((0, 0), |(r, e), b| (r + b.requests, e + b.errors));
+        let error_rate = if requests > 0 { errors as f64 / requests as f64 } else { 0.0 };
+        WindowStats {
+            window_secs: buckets * BUCKET_SECS,
+            requests,
+            errors,
+            error_rate,
+            burn_rate: error_rate / budget,
+        }
+    }
+}
+
+/// 按路由和子系统统计错误率，计算错误预算的消耗速率
+///
+/// 错误指服务端错误（5xx）和处理请求时的panic。同时使用5分钟和1小时两个
+/// 窗口：长窗口的消耗速率超过1时告警，两个窗口都超过10时视为严重，以便在
+/// 用户察觉之前发现问题，又不会因为个别失败的请求误报。
+pub struct ErrorBudgetTracker {
+    target: f64,
+    started: Instant,
+    routes: Mutex<HashMap<String, Series>>,
+    subsystems: Mutex<HashMap<String, Series>>,
+}
+
+impl ErrorBudgetTracker {
+    /// 创建错误预算统计，`target`为可用性目标，例如0.999
+    pub fn new(target: f64) -> Self {
+        Self {
+            target: target.clamp(0.0, 0.999_999),
+            started: Instant::now(),
+            routes: Mutex::new(HashMap::new()),
+            subsystems: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// 记录一次路由请求
+    pub fn record_route(&self, route: &str, error: bool) {
+        let index = self.bucket_index();
+        record(&self.routes, route, index, error);
+    }
+
+    /// 记录子系统的一次操作
+    pub fn record_subsystem(&self, subsystem: &str, error: bool) {
+        let index = self.bucket_index();
+        record(&self.subsystems, subsystem, index, error);
+    }
+
+    /// 当前的错误预算状态
+    pub fn status(&self) -> ErrorBudgetStatus {
+        self.status_at(self.bucket_index())
+    }
+
+    fn status_at(&self, index: u64) -> ErrorBudgetStatus {
+        let routes = entries(&self.routes, index, 1.0 - self.target);
+        let subsystems = entries(&self.subsystems, index, 1.0 - self.target);
+        let level = routes.iter().chain(&subsystems)
+            .map(|entry| entry.level)
+            .max()
+            .unwrap_or(BudgetLevel::Ok);
+        ErrorBudgetStatus { target: self.target, level, routes, subsystems }
+    }
+
+    fn bucket_index(&self) -> u64 {
+        self.started.elapsed().as_secs() / BUCKET_SECS
+    }
+}
+
+impl Default for ErrorBudgetTracker {
+    fn default() -> Self {
+        Self::new(DEFAULT_TARGET)
+    }
+}
+
+fn record(series: &Mutex<HashMap<String, Series>>, name: &str, index: u64, error: bool) {
+    let mut series = series.lock().unwrap();
+    match series.get_mut(name) {
+        Some(entry) => entry.record(index, error),
+        None => series.entry(name.to_string()).or_default().record(index, error),
+    }
+}
+
+fn entries(series: &Mutex<HashMap<String, Series>>, index: u64, budget: f64) -> Vec<BudgetEntry> {
+    let mut series = series.lock().unwrap();
+    // 长窗口内没有请求的路由不再保留
+    series.retain(|_, s| s.buckets.back().map_or(false, |b| b.index + LONG_WINDOW_BUCKETS > index));
+
+    let mut entries: Vec<BudgetEntry> = series.iter()
+        .map(|(name, s)| {
+            let short = s.window(index, SHORT_WINDOW_BUCKETS, budget);
+            let long = s.window(index, LONG_WINDOW_BUCKETS, budget);
+            let level = level(&short, &long);
+            BudgetEntry { name: name.clone(), short, long, level }
+        })
+        .collect();
+    entries.sort_by(|a, b| b.long.burn_rate.total_cmp(&a.long.burn_rate).then_with(|| a.name.cmp(&b.name)));
+    entries
+}
+
+fn level(short: &WindowStats, long: &WindowStats) -> BudgetLevel {
+    if long.requests < MIN_REQUESTS {
+        BudgetLevel::Ok
+    } else if short.requests >= MIN_REQUESTS && short.burn_rate >= CRITICAL_BURN_RATE && long.burn_rate >= CRITICAL_BURN_RATE {
+        BudgetLevel::Critical
+    } else if long.burn_rate >= 1.0 {
+        BudgetLevel::Warning
+    } else {
+        BudgetLevel::Ok
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_budget_levels() {
+        let tracker = ErrorBudgetTracker::new(0.99);
+        let series = |name: &str, index: u64, requests: u64, errors: u64| {
+            for i in 0..requests {
+                record(&tracker.routes, name, index, i < errors);
+            }
+        };
+
+        series("GET /api/db/tables", 0, 100, 0);
+        series("POST /api/db/query", 0, 100, 2);
+        series("POST /api/vector/search", 0, 100, 0);
+        series("POST /api/vector/search", 9, 30, 30);
+
+        let status = tracker.status_at(9);
+        assert_eq!(status.level, BudgetLevel::Critical);
+        assert_eq!(status.routes[0].name, "POST /api/vector/search");
+        assert_eq!(status.routes[0].short.errors, 30);
+        let level = |name: &str| status.routes.iter().find(|e| e.name == name).unwrap().level;
+        assert_eq!(level("POST /api/db/query"), BudgetLevel::Warning);
+        assert_eq!(level("GET /api/db/tables"), BudgetLevel::Ok);
+
+        // 突发的错误移出短窗口后只剩告警
+        assert_eq!(tracker.status_at(20).routes[0].level, BudgetLevel::Warning);
+        // 移出长窗口的路由不再报告
+        assert!(tracker.status_at(100).routes.is_empty());
+    }
+}
@@ -0,0 +1,103 @@
+use std::time::Duration;
+use chrono::{SecondsFormat, Utc};
+use log::{error, info, warn};
+use serde::Serialize;
+
+/// 单项检查的结果
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum CheckStatus {
+    /// 检查通过
+    Ok,
+    /// 发现问题并已自动修复
+    Repaired,
+    /// 发现问题但无法自动修复
+    Failed,
+}
+
+/// 一项完整性检查的发现
+#[derive(Debug, Clone, Serialize)]
+pub struct IntegrityFinding {
+    /// 检查对象，例如`database`或`vector_collection:docs`
+    pub check: String,
+    pub status: CheckStatus,
+    /// 发现的问题和采取的修复，检查通过时为空
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub detail: String,
+}
+
+impl IntegrityFinding {
+    pub fn ok(check: impl Into<String>) -> Self {
+        Self { check: check.into(), status: CheckStatus::Ok, detail: String::new() }
+    }
+
+    pub fn repaired(check: impl Into<String>, detail: impl Into<String>) -> Self {
+        Self { check: check.into(), status: CheckStatus::Repaired, detail: detail.into() }
+    }
+
+    pub fn failed(check: impl Into<String>, detail: impl Into<String>) -> Self {
+        Self { check: check.into(), status: CheckStatus::Failed, detail: detail.into() }
+    }
+}
+
+/// 启动时完整性检查的报告，通过健康检查接口返回
+#[derive(Debug, Clone, Serialize)]
+pub struct IntegrityReport {
+    /// 所有检查中最严重的结果
+    pub status: CheckStatus,
+    pub checked_at: String,
+    pub elapsed_ms: u64,
+    /// 只包含发现问题的检查
+    pub findings: Vec<IntegrityFinding>,
+    /// 通过的检查数
+    pub passed: usize,
+}
+
+impl IntegrityReport {
+    /// 汇总各项检查的发现，并记录修复和失败的日志
+    pub fn new(findings: Vec<IntegrityFinding>, elapsed: Duration) -> Self {
+        for finding in &findings {
+            match finding.status {
+                CheckStatus::Ok => {},
+                CheckStatus::Repaired => warn!("Integrity check '{}' repaired: {}", finding.check, finding.detail),
+                CheckStatus::Failed => error!("Integrity check '{}' failed: {}", finding.check, finding.detail),
+            }
+        }
+        let status = findings.iter().map(|f| f.status).max().unwrap_or(CheckStatus::Ok);
+        let passed = findings.iter().filter(|f| f.status == CheckStatus::Ok).count();
+        info!("Integrity check finished: {} passed, {} need attention", passed, findings.len() - passed);
+        Self {
+            status,
+            checked_at: Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true),
+            elapsed_ms: elapsed.as_millis() as u64,
+            findings: findings.into_iter().filter(|f| f.status != CheckStatus::Ok).collect(),
+            passed,
+        }
+    }
+
+    /// 是否有无法自动修复的问题
+    pub fn has_failures(&self) -> bool {
+        self.status == CheckStatus::Failed
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_integrity_report() {
+        let report = IntegrityReport::new(vec![
+            IntegrityFinding::ok("database"),
+            IntegrityFinding::ok("vector_collection:a"),
+            IntegrityFinding::repaired("vector_collection:b", "count 3 reconciled to 2"),
+        ], Duration::from_millis(5));
+        assert_eq!(report.status, CheckStatus::Repaired);
+        assert_eq!(report.passed, 2);
+        assert_eq!(report.findings.len(), 1);
+        assert!(!report.has_failures());
+
+        let report = IntegrityReport::new(vec![IntegrityFinding::failed("audit_store", "malformed")], Duration::ZERO);
+        assert!(report.has_failures());
+    }
+}
@@ -0,0 +1,241 @@
+use std::collections::HashMap;
+use std::fs;
+use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
+use log::{debug, warn};
+use serde::Serialize;
+
+/// 回收函数，参数为需要释放的字节数，返回实际释放的字节数
+pub type Reclaimer = Arc<dyn Fn(usize) -> usize + Send + Sync>;
+
+/// 进程级内存预算，查询执行、缓存、向量索引等子系统从中申请内存
+///
+/// 预算不足时先调用其他子系统注册的回收函数（例如淘汰缓存），
+/// 仍不足时拒绝申请，由调用方降级处理，而不是耗尽内存。
+pub struct MemoryBudget {
+    limit: usize,
+    used: AtomicUsize,
+    subsystems: Mutex<HashMap<String, usize>>,
+    reclaimers: Mutex<Vec<(String, Reclaimer)>>,
+    rejected: AtomicU64,
+}
+
+/// 内存预算状态
+#[derive(Debug, Clone, Serialize)]
+pub struct MemoryBudgetStatus {
+    /// 预算总字节数
+    pub limit_bytes: usize,
+    /// 已申请的字节数
+    pub used_bytes: usize,
+    /// 各子系统已申请的字节数
+    pub subsystems: HashMap<String, usize>,
+    /// 因预算不足被拒绝的申请次数
+    pub rejected: u64,
+}
+
+impl MemoryBudget {
+    /// 创建指定字节数的内存预算
+    pub fn new(limit_bytes: usize) -> Self {
+        Self {
+            limit: limit_bytes,
+            used: AtomicUsize::new(0),
+            subsystems: Mutex::new(HashMap::new()),
+            reclaimers: Mutex::new(Vec::new()),
+            rejected: AtomicU64::new(0),
+        }
+    }
+
+    /// 按系统内存的百分比创建内存预算，无法获取系统内存时返回None
+    pub fn from_percent(percent: u8) -> Option<Self> {
+        let total = system_memory_bytes()?;
+        let percent = percent.min(100) as usize;
+        Some(Self::new(total / 100 * percent))
+    }
+
+    /// 预算总字节数
+    pub fn limit(&self) -> usize {
+        self.limit
+    }
+
+    /// 已申请的字节数
+    pub fn used(&self) -> usize {
+        self.used.load(Ordering::Relaxed)
+    }
+
+    /// 剩余可申请的字节数
+    pub fn available(&self) -> usize {
+        self.limit.saturating_sub(self.used())
+    }
+
+    /// 注册子系统的回收函数，预算不足时由其他子系统的申请触发
+    ///
+    /// 回收函数不能持有本预算的强引用以外的循环引用，需要访问子系统时应使用`Weak`。
+    pub fn register_reclaimer<F>(&self, subsystem: &str, reclaimer: F)
+    where
+        F: Fn(usize) -> usize + Send + Sync + 'static,
+    {
+        self.reclaimers.lock().unwrap().push((subsystem.to_string(), Arc::new(reclaimer)));
+    }
+
+    /// 在不触发回收的情况下申请内存，返回是否成功
+    ///
+    /// 持有子系统内部锁时应使用此方法，避免回收函数重入同一子系统。
+    pub fn try_reserve(&self, subsystem: &str, bytes: usize) -> bool {
+        let reserved = self.used.fetch_update(Ordering::SeqCst, Ordering::SeqCst, |used| {
+            used.checked_add(bytes).filter(|total| *total <= self.limit)
+        }).is_ok();
+
+        if reserved {
+            *self.subsystems.lock().unwrap().entry(subsystem.to_string()).or_insert(0) += bytes;
+        }
+        reserved
+    }
+
+    /// 申请内存，预算不足时先回收其他子系统的内存，返回是否成功
+    pub fn reserve(&self, subsystem: &str, bytes: usize) -> bool {
+        if self.try_reserve(subsystem, bytes) {
+            return true;
+        }
+
+        // 复制回收函数列表，回收期间不持有锁
+        let reclaimers: Vec<(String, Reclaimer)> = self.reclaimers.lock().unwrap().clone();
+        for (name, reclaimer) in reclaimers.iter().filter(|(name, _)| name != subsystem) {
+            let needed = bytes.saturating_sub(self.available());
+            if needed == 0 {
+                break;
+            }
+            let freed = reclaimer(needed);
+            debug!("Memory budget reclaimed {} bytes from {} for {}", freed, name, subsystem);
+        }
+
+        if self.try_reserve(subsystem, bytes) {
+            return true;
+        }
+
+        self.rejected.fetch_add(1, Ordering::Relaxed);
+        warn!(
+            "Memory budget exhausted: {} requested {} bytes, {} of {} bytes in use",
+            subsystem, bytes, self.used(), self.limit
+        );
+        false
+    }
+
+    /// 释放之前申请的内存
+    pub fn release(&self, subsystem: &str, bytes: usize) {
+        let _ = self.used.fetch_update(Ordering::SeqCst, Ordering::SeqCst, |used| {
+            Some(used.saturating_sub(bytes))
+        });
+
+        let mut subsystems = self.subsystems.lock().unwrap();
+        if let Some(used) = subsystems.get_mut(subsystem) {
+            *used = used.saturating_sub(bytes);
+        }
+    }
+
+    /// 申请内存并返回租约，租约释放时自动归还
+    pub fn lease(self: &Arc<Self>, subsystem: &str, bytes: usize) -> Option<MemoryLease> {
+        if self.reserve(subsystem, bytes) {
+            Some(MemoryLease {
+                budget: self.clone(),
+                subsystem: subsystem.to_string(),
+                bytes,
+            })
+        } else {
+            None
+        }
+    }
+
+    /// 获取预算状态
+    pub fn status(&self) -> MemoryBudgetStatus {
+        MemoryBudgetStatus {
+            limit_bytes: self.limit,
+            used_bytes: self.used(),
+            subsystems: self.subsystems.lock().unwrap().clone(),
+            rejected: self.rejected.load(Ordering::Relaxed),
+        }
+    }
+}
+
+/// 内存租约，释放时将内存归还给预算
+pub struct MemoryLease {
+    budget: Arc<MemoryBudget>,
+    subsystem: String,
+    bytes: usize,
+}
+
+impl MemoryLease {
+    /// 租约的字节数
+    pub fn bytes(&self) -> usize {
+        self.bytes
+    }
+
+    /// 为租约追加申请内存，预算不足时返回false，租约保持原大小
+    pub fn grow(&mut self, bytes: usize) -> bool {
+        if self.budget.reserve(&self.subsystem, bytes) {
+            self.bytes += bytes;
+            true
+        } else {
+            false
+        }
+    }
+}
+
+impl Drop for MemoryLease {
+    fn drop(&mut self) {
+        self.budget.release(&self.subsystem, self.bytes);
+    }
+}
+
+/// 读取系统物理内存总量
+fn system_memory_bytes() -> Option<usize> {
+    let meminfo = fs::read_to_string("/proc/meminfo").ok()?;
+    meminfo.lines()
+        .find(|line| line.starts_with("MemTotal:"))
+        .and_then(|line| line.split_whitespace().nth(1))
+        .and_then(|kb| kb.parse::<usize>().ok())
+        .map(|kb| kb * 1024)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_reserve_and_reclaim() {
+        let budget = Arc::new(MemoryBudget::new(100));
+        assert!(budget.try_reserve("cache", 80));
+        assert!(!budget.try_reserve("query", 40));
+
+        // 回收函数释放缓存占用的内存
+        let weak = Arc::downgrade(&budget);
+        budget.register_reclaimer("cache", move |bytes| {
+            let budget = weak.upgrade().unwrap();
+            budget.release("cache", bytes);
+            bytes
+        });
+
+        let lease = budget.lease("query", 40).unwrap();
+        assert_eq!(budget.used(), 100);
+        assert_eq!(budget.status().subsystems["cache"], 60);
+
+        drop(lease);
+        assert_eq!(budget.used(), 60);
+
+
+        // 自身的回收函数不会被调用
+        assert!(!budget.reserve("cache", 50));
+        assert_eq!(budget.status().rejected, 1);
+    }
+
+    #[test]
+    fn test_lease_grow() {
+        let budget = Arc::new(MemoryBudget::new(50));
+        let mut lease = budget.lease("query", 10).unwrap();
+        assert!(lease.grow(20));
+        assert!(!lease.grow(30));
+        assert_eq!((lease.bytes(), budget.used()), (30, 30));
+
+        drop(lease);
+        assert_eq!(budget.used(), 0);
+    }
+}
@@ -0,0 +1,185 @@
+use std::fs;
+use std::path::Path;
+use chrono::Utc;
+use serde::Serialize;
+use sha2::{Digest, Sha256};
+
+use lumos_core::sqlite::migrate::MigrationReport;
+use crate::db::vector_executor::VectorCollection;
+
+/// 一个向量集合或管道定义的校验结果
+#[derive(Debug, Clone, Serialize)]
+pub struct ArtifactVerification {
+    /// 集合名或管道定义文件名
+    pub name: String,
+    /// 源实例中的条目数，向量集合为向量数，管道定义为1
+    pub source_count: u64,
+    /// 新实例中的条目数，缺失时为None
+    pub target_count: Option<u64>,
+    /// 源实例中的SHA-256
+    pub source_checksum: String,
+    /// 新实例中的SHA-256
+    pub target_checksum: Option<String>,
+    /// 条目数和校验和是否一致
+    pub matches: bool,
+}
+
+/// 切换报告
+#[derive(Debug, Clone, Serialize)]
+pub struct CutoverReport {
+    /// 生成时间戳（秒）
+    pub generated_at: i64,
+    /// 关系数据的迁移和校验结果
+    pub database: MigrationReport,
+    /// 向量集合
+    pub collections: Vec<ArtifactVerification>,
+    /// 管道定义
+    pub pipelines: Vec<ArtifactVerification>,
+}
+
+impl CutoverReport {
+    /// 创建切换报告
+    pub fn new(database: MigrationReport, collections: Vec<ArtifactVerification>, pipelines: Vec<ArtifactVerification>) -> Self {
+        Self {
+            generated_at: Utc::now().timestamp(),
+            database,
+            collections,
+            pipelines,
+        }
+    }
+
+    /// 所有数据是否都已校验一致，可以切换
+    pub fn ready_for_cutover(&self) -> bool {
+        self.database.verified
+            && self.collections.iter().all(|c| c.matches)
+            && self.pipelines.iter().all(|p| p.matches)
+    }
+
+    /// 以Markdown格式输出报告
+    pub fn to_markdown(&self) -> String {
+        let mut out = String::new();
+        out.push_str("# LumosDB cutover report\n\n");
+        out.push_str(&format!(
+            "Ready for cutover: **{}**\n\n",
+            if self.ready_for_cutover() { "yes" } else { "no" }
+        ));
+
+        out.push_str("## Tables\n\n| Table | Source rows | Target rows | Match |\n|---|---|---|---|\n");
+        for table in &self.database.tables {
+            out.push_str(&format!(
+                "| {} | {} | {} | {} |\n",
+                table.table,
+                table.source.as_ref().map_or("-".to_string(), |t| t.rows.to_string()),
+                table.target.as_ref().map_or("-".to_string(), |t| t.rows.to_string()),
+                mark(table.matches),
+            ));
+        }
+
+        for (title, artifacts) in [("Vector collections", &self.collections), ("Pipelines", &self.pipelines)] {
+            if artifacts.is_empty() {
+                continue;
+            }
+            out.push_str(&format!("\n## {}\n\n| Name | Source | Target | Match |\n|---|---|---|---|\n", title));
+            for artifact in artifacts {
+                out.push_str(&format!(
+                    "| {} | {} | {} | {} |\n",
+                    artifact.name,
+                    artifact.source_count,
+                    artifact.target_count.map_or("-".to_string(), |c| c.to_string()),
+                    mark(artifact.matches),
+                ));
+            }
+        }
+
+        out
+    }
+}
+
+fn mark(matches: bool) -> &'static str {
+    if matches { "ok" } else { "MISMATCH" }
+}
+
+/// 复制已持久化到磁盘的向量集合并逐个校验向量数和内容
+///
+/// `source_dir`和`target_dir`是向量库路径加`.collections`后缀的目录。
+pub fn migrate_collections(source_dir: &Path, target_dir: &Path) -> Result<Vec<ArtifactVerification>, String> {
+    copy_and_verify(source_dir, target_dir, &["json"], |data| {
+        let collection: VectorCollection = serde_json::from_slice(data)
+            .map_err(|e| format!("Invalid collection file: {}", e))?;
+        Ok((collection.ids.len() as u64, collection_checksum(&collection)))
+    })
+}
+
+/// 复制管道定义文件（YAML或JSON）并校验内容
+pub fn migrate_pipelines(source_dir: &Path, target_dir: &Path) -> Result<Vec<ArtifactVerification>, String> {
+    copy_and_verify(source_dir, target_dir, &["yaml", "yml", "json"], |data| {
+        Ok((1, hex::encode(Sha256::digest(data))))
+    })
+}
+
+/// 集合内容的校验和，只依赖向量和元数据，与序列化格式无关
+fn collection_checksum(collection: &VectorCollection) -> String {
+    let mut hasher = Sha256::new();
+    hasher.update((collection.dimension as u64).to_le_bytes());
+    for (id, embedding) in collection.ids.iter().zip(collection.embeddings.iter()) {
+        hasher.update((id.len() as u64).to_le_bytes());
+        hasher.update(id.as_bytes());
+        for value in embedding {
+            hasher.update(value.to_bits().to_le_bytes());
+        }
+        let metadata = collection.metadata.get(id).map(|m| m.to_string()).unwrap_or_default();
+        hasher.update((metadata.len() as u64).to_le_bytes());
+        hasher.update(metadata.as_bytes());
+    }
+    hex::encode(hasher.finalize())
+}
+
+/// 将目录中指定扩展名的文件复制到目标目录，并用`inspect`比较复制前后的条目数和校验和
+fn copy_and_verify<F>(source_dir: &Path, target_dir: &Path, extensions: &[&str], inspect: F) -> Result<Vec<ArtifactVerification>, String>
+where
+    F: Fn(&[u8]) -> Result<(u64, String), String>,
+{
+    if !source_dir.exists() {
+        return Ok(Vec::new());
+    }
+    fs::create_dir_all(target_dir)
+        .map_err(|e| format!("Failed to create {}: {}", target_dir.display(), e))?;
+
+    let mut entries: Vec<_> = fs::read_dir(source_dir)
+        .map_err(|e| format!("Failed to read {}: {}", source_dir.display(), e))?
+        .filter_map(|entry| entry.ok())
+        .map(|entry| entry.path())
+        .filter(|path| {
+            path.is_file() && path.extension()
+                .and_then(|ext| ext.to_str())
+                .map_or(false, |ext| extensions.contains(&ext))
+        })
+        .collect();
+    entries.sort();
+
+    let mut verifications = Vec::with_capacity(entries.len());
+    for source_path in entries {
+        let name = source_path.file_name().unwrap_or_default().to_string_lossy().to_string();
+        let data = fs::read(&source_path)
+            .map_err(|e| format!("Failed to read {}: {}", source_path.display(), e))?;
+        let (source_count, source_checksum) = inspect(&data)
+            .map_err(|e| format!("{}: {}", source_path.display(), e))?;
+
+        let target_path = target_dir.join(&name);
+        fs::write(&target_path, &data)
+            .map_err(|e| format!("Failed to write {}: {}", target_path.display(), e))?;
+
+        let target = fs::read(&target_path).ok().and_then(|copied| inspect(&copied).ok());
+        let matches = target.as_ref() == Some(&(source_count, source_checksum.clone()));
+        verifications.push(ArtifactVerification {
+            name,
+            source_count,
+            target_count: target.as_ref().map(|(count, _)| *count),
+            source_checksum,
+            target_checksum: target.map(|(_, checksum)| checksum),
+            matches,
+        });
+    }
+
+    Ok(verifications)
+}
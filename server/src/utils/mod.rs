@@ -1,4 +1,17 @@
 pub mod error;
 pub mod perf_monitor;
+pub mod memory_budget;
+pub mod disk_guard;
+pub mod degradation;
+pub mod admission;
+pub mod anomaly;
+pub mod warmup;
+pub mod migration;
+pub mod access_stats;
+pub mod query_audit;
+pub mod integrity;
+pub mod retention;
+pub mod runtime_config;
+pub mod error_budget;
 
 // 其他工具模块将在需要时添加 
\ No newline at end of file
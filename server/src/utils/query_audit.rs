@@ -0,0 +1,207 @@
+use std::path::Path;
+use std::sync::Mutex;
+use std::time::Duration;
+use chrono::{SecondsFormat, Utc};
+use log::error;
+use rusqlite::{params, Connection};
+use serde::Serialize;
+use super::integrity::IntegrityFinding;
+use super::retention::{RetentionRule, RetentionTarget};
+
+const SCHEMA: &str = "
+    CREATE TABLE IF NOT EXISTS query_audit (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        executed_at TEXT NOT NULL,
+        sql TEXT NOT NULL,
+        params TEXT NOT NULL,
+        rows INTEGER NOT NULL,
+        bytes INTEGER NOT NULL,
+        duration_ms REAL NOT NULL,
+        error TEXT
+    );
+";
+
+/// 一条抽样记录的查询
+#[derive(Debug, Clone, Serialize)]
+pub struct AuditEntry {
+    pub executed_at: String,
+    pub sql: String,
+    /// 完整的绑定参数
+    pub params: Vec<String>,
+    /// 返回或影响的行数
+    pub rows: u64,
+    /// 结果的估算字节数，写语句为0
+    pub bytes: u64,
+    pub duration_ms: f64,
+    pub error: Option<String>,
+}
+
+/// 查询审计抽样
+///
+/// 按比例抽样查询，连同完整的绑定参数和结果摘要写入单独的SQLite审计库。
+/// 参数可能包含敏感数据，审计库文件只允许服务进程读写，记录只能通过管理员
+/// 密钥查看；过期的记录按保留策略删除。
+pub struct QueryAuditor {
+    conn: Mutex<Connection>,
+    sample_rate: f64,
+}
+
+impl QueryAuditor {
+    /// 打开审计库，`sample_rate`为抽样比例（0到1）
+    pub fn open<P: AsRef<Path>>(path: P, sample_rate: f64) -> Result<Self, String> {
+        let path = path.as_ref();
+        let conn = Connection::open(path)
+            .map_err(|e| format!("Failed to open audit store {}: {}", path.display(), e))?;
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            std::fs::set_permissions(path, std::fs::Permissions::from_mode(0o600))
+                .map_err(|e| format!("Failed to restrict permissions of {}: {}", path.display(), e))?;
+        }
+        Self::init(conn, sample_rate)
+    }
+
+    /// 内存中的审计库，用于测试
+    pub fn in_memory(sample_rate: f64) -> Result<Self, String> {
+        let conn = Connection::open_in_memory()
+            .map_err(|e| format!("Failed to open audit store: {}", e))?;
+        Self::init(conn, sample_rate)
+    }
+
+    fn init(conn: Connection, sample_rate: f64) -> Result<Self, String> {
+        if !(0.0..=1.0).contains(&sample_rate) {
+            return Err(format!("Audit sample rate must be between 0 and 1, got {}", sample_rate));
+        }
+        conn.execute_batch(SCHEMA)
+            .map_err(|e| format!("Failed to initialize audit store: {}", e))?;
+        Ok(Self {
+            conn: Mutex::new(conn),
+            sample_rate,
+        })
+    }
+
+    /// 抽样比例
+    pub fn sample_rate(&self) -> f64 {
+        self.sample_rate
+    }
+
+    /// 本次查询是否被抽中
+    pub fn should_sample(&self) -> bool {
+        self.sample_rate >= 1.0 || (self.sample_rate > 0.0 && rand::random::<f64>() < self.sample_rate)
+    }
+
+    /// 记录查询，写入失败只记录日志，不影响查询本身
+    pub fn record(&self, sql: &str, params: &[String], elapsed: Duration, rows: u64, bytes: u64, error: Option<String>) {
+        let entry = AuditEntry {
+            executed_at: Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true),
+            sql: sql.to_string(),
+            params: params.to_vec(),
+            rows,
+            bytes,
+            duration_ms: elapsed.as_secs_f64() * 1000.0,
+            error,
+        };
+        if let Err(e) = self.insert(&entry) {
+            error!("Failed to record query audit entry: {}", e);
+        }
+    }
+
+    fn insert(&self, entry: &AuditEntry) -> Result<(), rusqlite::Error> {
+        let conn = self.conn.lock().unwrap();
+        conn.execute(
+            "INSERT INTO query_audit (executed_at, sql, params, rows, bytes, duration_ms, error) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)",
+            params![
+                entry.executed_at,
+                entry.sql,
+                serde_json::to_string(&entry.params).unwrap_or_default(),
+                entry.rows as i64,
+                entry.bytes as i64,
+                entry.duration_ms,
+                entry.error,
+            ],
+        )?;
+        Ok(())
+    }
+
+    /// 检查审计库文件的一致性
+    pub fn verify(&self) -> IntegrityFinding {
+        let conn = self.conn.lock().unwrap();
+        match conn.query_row("PRAGMA quick_check", [], |row| row.get::<_, String>(0)) {
+            Ok(result) if result == "ok" => IntegrityFinding::ok("audit_store"),
+            Ok(result) => IntegrityFinding::failed("audit_store", format!("quick_check reported: {}", result)),
+            Err(e) => IntegrityFinding::failed("audit_store", format!("quick_check failed: {}", e)),
+        }
+    }
+
+    /// 最近的审计记录，最新的在前
+    pub fn recent(&self, limit: usize) -> Result<Vec<AuditEntry>, String> {
+        let conn = self.conn.lock().unwrap();
+        let mut stmt = conn.prepare(
+            "SELECT executed_at, sql, params, rows, bytes, duration_ms, error FROM query_audit ORDER BY id DESC LIMIT ?1"
+        ).map_err(|e| format!("Failed to read audit store: {}", e))?;
+        let entries = stmt.query_map(params![limit as i64], |row| {
+            let params: String = row.get(2)?;
+            Ok(AuditEntry {
+                executed_at: row.get(0)?,
+                sql: row.get(1)?,
+                params: serde_json::from_str(&params).unwrap_or_default(),
+                rows: row.get::<_, i64>(3)? as u64,
+                bytes: row.get::<_, i64>(4)? as u64,
+                duration_ms: row.get(5)?,
+                error: row.get(6)?,
+            })
+        })
+        .and_then(|rows| rows.collect::<Result<Vec<_>, _>>())
+        .map_err(|e| format!("Failed to read audit store: {}", e))?;
+        Ok(entries)
+    }
+}
+
+impl RetentionTarget for QueryAuditor {
+    fn enforce(&self, rule: &RetentionRule) -> Result<usize, String> {
+        let conn = self.conn.lock().unwrap();
+        let mut removed = 0;
+        let cutoff = rule.max_age
+            .and_then(|age| chrono::Duration::from_std(age).ok())
+            .and_then(|age| Utc::now().checked_sub_signed(age));
+        if let Some(cutoff) = cutoff {
+            removed += conn.execute(
+                "DELETE FROM query_audit WHERE executed_at < ?1",
+                params![cutoff.to_rfc3339_opts(SecondsFormat::Millis, true)],
+            ).map_err(|e| format!("Failed to prune audit store: {}", e))?;
+        }
+        if let Some(max_entries) = rule.max_entries {
+            removed += conn.execute(
+                "DELETE FROM query_audit WHERE id NOT IN (SELECT id FROM query_audit ORDER BY id DESC LIMIT ?1)",
+                params![max_entries as i64],
+            ).map_err(|e| format!("Failed to prune audit store: {}", e))?;
+        }
+        Ok(removed)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_query_audit() {
+        let auditor = QueryAuditor::in_memory(1.0).unwrap();
+        assert!(auditor.should_sample());
+        for id in 1..=3 {
+            auditor.record("SELECT * FROM users WHERE id = ?", &[id.to_string()], Duration::from_millis(2), 1, 40, None);
+        }
+        auditor.record("DELETE FROM users", &[], Duration::from_millis(1), 0, 0, Some("locked".to_string()));
+        assert_eq!(auditor.enforce(&RetentionRule::new(Some(2), None)).unwrap(), 2);
+        assert_eq!(auditor.enforce(&RetentionRule::new(None, Some(Duration::from_secs(60)))).unwrap(), 0);
+
+        let entries = auditor.recent(10).unwrap();
+        assert_eq!(entries.len(), 2);
+        assert_eq!(entries[0].error.as_deref(), Some("locked"));
+        assert_eq!(entries[1].params, vec!["3".to_string()]);
+        assert_eq!((entries[1].rows, entries[1].bytes), (1, 40));
+
+        assert!(!QueryAuditor::in_memory(0.0).unwrap().should_sample());
+        assert!(QueryAuditor::in_memory(1.5).is_err());
+    }
+}
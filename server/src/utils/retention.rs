@@ -0,0 +1,197 @@
+use std::sync::Arc;
+use std::thread;
+use std::time::Duration;
+use log::{error, info};
+
+use lumos_core::query::fingerprint::{QueryStatsCollector, DEFAULT_MAX_FINGERPRINTS};
+
+/// 默认最多保留的审计记录数
+pub const DEFAULT_AUDIT_ENTRIES: usize = 100_000;
+
+/// 默认最多保留的负载异常告警数
+pub const DEFAULT_USAGE_ENTRIES: usize = 100;
+
+/// 默认的清理间隔
+pub const DEFAULT_INTERVAL: Duration = Duration::from_secs(300);
+
+/// 一类数据的保留规则，同时设置时两个限制都生效
+#[derive(Debug, Clone, Copy, PartialEq, Default)]
+pub struct RetentionRule {
+    /// 最多保留的记录数
+    pub max_entries: Option<usize>,
+    /// 最长保留时间
+    pub max_age: Option<Duration>,
+}
+
+impl RetentionRule {
+    pub fn new(max_entries: Option<usize>, max_age: Option<Duration>) -> Self {
+        Self { max_entries, max_age }
+    }
+
+    /// 解析`entries=1000,age=30d`形式的规则，时间单位可以是s、m、h或d
+    pub fn parse(spec: &str) -> Result<Self, String> {
+        Self::default().apply(spec)
+    }
+
+    /// 在当前规则上应用`parse`形式的设置，未设置的限制保持不变，值为`none`时取消该限制
+    pub fn apply(&self, spec: &str) -> Result<Self, String> {
+        let mut rule = *self;
+        for part in spec.split(',').map(str::trim).filter(|p| !p.is_empty()) {
+            let (name, value) = part.split_once('=')
+                .ok_or_else(|| format!("Invalid retention setting '{}', expected name=value", part))?;
+            let value = value.trim();
+            match name.trim() {
+                "entries" if value == "none" => rule.max_entries = None,
+                "entries" => rule.max_entries = Some(value.parse()
+                    .map_err(|_| format!("Invalid retention entries '{}'", value))?),
+                "age" if value == "none" => rule.max_age = None,
+                "age" => rule.max_age = Some(parse_age(value)?),
+                other => return Err(format!("Unknown retention setting '{}'", other)),
+            }
+        }
+        Ok(rule)
+    }
+
+    /// 是否没有任何限制
+    pub fn is_unlimited(&self) -> bool {
+        self.max_entries.is_none() && self.max_age.is_none()
+    }
+}
+
+fn parse_age(value: &str) -> Result<Duration, String> {
+    let split = value.find(|c: char| !c.is_ascii_digit()).unwrap_or(value.len());
+    let amount: u64 = value[..split].parse()
+        .map_err(|_| format!("Invalid retention age '{}'", value))?;
+    let unit = match &value[split..] {
+        "s" | "" => 1,
+        "m" => 60,
+        "h" => 3600,
+        "d" => 86_400,
+        _ => return Err(format!("Invalid retention age '{}', expected a unit of s, m, h or d", value)),
+    };
+    amount.checked_mul(unit)
+        .map(Duration::from_secs)
+        .ok_or_else(|| format!("Retention age '{}' is too large", value))
+}
+
+/// 按数据类别的保留策略
+#[derive(Debug, Clone)]
+pub struct RetentionConfig {
+    /// 查询审计记录
+    pub audit: RetentionRule,
+    /// 慢查询分析和索引建议使用的查询指纹统计
+    pub slow_queries: RetentionRule,
+    /// 按密钥的使用历史（负载异常告警）
+    pub usage: RetentionRule,
+    /// 清理间隔
+    pub interval: Duration,
+}
+
+impl Default for RetentionConfig {
+    fn default() -> Self {
+        Self {
+            audit: RetentionRule::new(Some(DEFAULT_AUDIT_ENTRIES), None),
+            slow_queries: RetentionRule::new(Some(DEFAULT_MAX_FINGERPRINTS), None),
+            usage: RetentionRule::new(Some(DEFAULT_USAGE_ENTRIES), None),
+            interval: DEFAULT_INTERVAL,
+        }
+    }
+}
+
+/// 受保留策略约束的数据
+pub trait RetentionTarget: Send + Sync {
+    /// 删除超出规则的记录，返回删除的记录数
+    fn enforce(&self, rule: &RetentionRule) -> Result<usize, String>;
+}
+
+impl RetentionTarget for QueryStatsCollector {
+    fn enforce(&self, rule: &RetentionRule) -> Result<usize, String> {
+        Ok(self.prune(rule.max_age, rule.max_entries))
+    }
+}
+
+/// 按保留策略定期清理各类数据
+#[derive(Default)]
+pub struct RetentionEnforcer {
+    targets: Vec<(&'static str, RetentionRule, Arc<dyn RetentionTarget>)>,
+}
+
+impl RetentionEnforcer {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// 添加一类数据，没有限制的规则被忽略
+    pub fn with_target(mut self, category: &'static str, rule: RetentionRule, target: Arc<dyn RetentionTarget>) -> Self {
+        if !rule.is_unlimited() {
+            self.targets.push((category, rule, target));
+        }
+        self
+    }
+
+    /// 执行一次清理，返回删除的记录总数
+    pub fn run_once(&self) -> usize {
+        let mut total = 0;
+        for (category, rule, target) in &self.targets {
+            match target.enforce(rule) {
+                Ok(0) => {},
+                Ok(removed) => {
+                    info!("Retention removed {} expired {} records", removed, category);
+                    total += removed;
+                },
+                Err(e) => error!("Failed to enforce retention for {}: {}", category, e),
+            }
+        }
+        total
+    }
+
+    /// 在后台线程中按间隔清理
+    pub fn spawn(self, interval: Duration) {
+        if self.targets.is_empty() {
+            return;
+        }
+        thread::spawn(move || loop {
+            thread::sleep(interval);
+            self.run_once();
+        });
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_retention_rule() {
+        let rule = RetentionRule::parse("entries=500, age=7d").unwrap();
+        assert_eq!(rule.max_entries, Some(500));
+        assert_eq!(rule.max_age, Some(Duration::from_secs(7 * 86_400)));
+        assert_eq!(RetentionRule::parse("age=90").unwrap().max_age, Some(Duration::from_secs(90)));
+        assert!(RetentionRule::parse("").unwrap().is_unlimited());
+        assert!(RetentionRule::parse("age=3w").is_err());
+        assert!(RetentionRule::parse("rows=10").is_err());
+        assert!(RetentionRule::parse("age=999999999999999999d").is_err());
+    }
+
+    #[test]
+    fn test_apply_keeps_unset_limits() {
+        let default = RetentionRule::new(Some(DEFAULT_AUDIT_ENTRIES), None);
+        let rule = default.apply("age=30d").unwrap();
+        assert_eq!(rule.max_entries, Some(DEFAULT_AUDIT_ENTRIES));
+        assert_eq!(rule.max_age, Some(Duration::from_secs(30 * 86_400)));
+        assert_eq!(default.apply("entries=none").unwrap().max_entries, None);
+    }
+
+    #[test]
+    fn test_enforcer() {
+        let stats = Arc::new(QueryStatsCollector::default());
+        stats.record("SELECT 1", Duration::from_millis(1), 1, false);
+        stats.record("DELETE FROM t", Duration::from_millis(3), 0, false);
+
+        let enforcer = RetentionEnforcer::new()
+            .with_target("slow_queries", RetentionRule::new(Some(1), None), stats.clone())
+            .with_target("unlimited", RetentionRule::default(), stats.clone());
+        assert_eq!(enforcer.run_once(), 1);
+        assert_eq!(stats.snapshot()[0].sample, "DELETE FROM t");
+    }
+}
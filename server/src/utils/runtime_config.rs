@@ -0,0 +1,233 @@
+use std::collections::VecDeque;
+use std::sync::{Arc, Mutex, RwLock};
+use chrono::{SecondsFormat, Utc};
+use log::info;
+use serde::{Deserialize, Serialize};
+
+use crate::utils::admission::AdmissionController;
+use crate::utils::degradation::DegradationController;
+
+/// 默认的慢查询阈值（毫秒）
+pub const DEFAULT_SLOW_QUERY_MS: f64 = 100.0;
+
+/// 最多保留的配置变更记录数
+const MAX_CHANGES: usize = 100;
+
+/// 可在运行时调整的配置
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct RuntimeSettings {
+    /// 内存预算使用率达到该百分比时视为紧张
+    pub memory_high_percent: u8,
+    /// 内存预算使用率达到该百分比时视为即将耗尽
+    pub memory_critical_percent: u8,
+    /// 可用磁盘空间低于保留空间的该倍数时视为紧张
+    pub disk_high_reserve_factor: u64,
+    /// 内存即将耗尽时查询最多返回的行数，0表示不限制
+    pub degraded_row_limit: usize,
+    /// 平均耗时不低于该值（毫秒）的查询视为慢查询
+    pub slow_query_ms: f64,
+    /// 同时执行的查询上限，未启用准入控制时为空
+    pub max_concurrent_queries: Option<usize>,
+    /// 排队的查询上限，未启用准入控制时为空
+    pub query_queue_size: Option<usize>,
+}
+
+/// 配置修改请求，只修改给出的字段
+#[derive(Debug, Clone, Default, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct SettingsPatch {
+    pub memory_high_percent: Option<u8>,
+    pub memory_critical_percent: Option<u8>,
+    pub disk_high_reserve_factor: Option<u64>,
+    pub degraded_row_limit: Option<usize>,
+    pub slow_query_ms: Option<f64>,
+    pub max_concurrent_queries: Option<usize>,
+    pub query_queue_size: Option<usize>,
+}
+
+/// 一项配置变更记录
+#[derive(Debug, Clone, Serialize)]
+pub struct ConfigChange {
+    pub changed_at: String,
+    /// 修改者的密钥标识
+    pub changed_by: String,
+    pub setting: String,
+    pub old_value: serde_json::Value,
+    pub new_value: serde_json::Value,
+}
+
+/// 运行时配置，修改立即作用于降级控制、查询准入和慢查询分析，不需要重启
+///
+/// 修改经过整体校验，任一字段无效时不修改任何配置。每项变更都记录日志，
+/// 最近的变更可以通过管理接口查询。
+pub struct RuntimeConfig {
+    degradation: Arc<DegradationController>,
+    admission: Option<Arc<AdmissionController>>,
+    slow_query_ms: RwLock<f64>,
+    // 同时用于串行化修改
+    changes: Mutex<VecDeque<ConfigChange>>,
+}
+
+impl RuntimeConfig {
+    pub fn new(degradation: Arc<DegradationController>, admission: Option<Arc<AdmissionController>>) -> Self {
+        Self {
+            degradation,
+            admission,
+            slow_query_ms: RwLock::new(DEFAULT_SLOW_QUERY_MS),
+            changes: Mutex::new(VecDeque::new()),
+        }
+    }
+
+    /// 当前的慢查询阈值（毫秒）
+    pub fn slow_query_ms(&self) -> f64 {
+        *self.slow_query_ms.read().unwrap()
+    }
+
+    /// 当前配置
+    pub fn settings(&self) -> RuntimeSettings {
+        let policy = self.degradation.policy();
+        RuntimeSettings {
+            memory_high_percent: policy.memory_high_percent,
+            memory_critical_percent: policy.memory_critical_percent,
+            disk_high_reserve_factor: policy.disk_high_reserve_factor,
+            degraded_row_limit: policy.sample_rows_on_critical_memory.unwrap_or(0),
+            slow_query_ms: self.slow_query_ms(),
+            max_concurrent_queries: self.admission.as_ref().map(|a| a.limit()),
+            query_queue_size: self.admission.as_ref().map(|a| a.max_queue()),
+        }
+    }
+
+    /// 校验并应用修改，返回本次的变更
+    pub fn apply(&self, patch: &SettingsPatch, changed_by: &str) -> Result<Vec<ConfigChange>, String> {
+        let mut history = self.changes.lock().unwrap();
+        let current = self.settings();
+
+        let admission = match &self.admission {
+            Some(admission) => Some(admission),
+            None if patch.max_concurrent_queries.is_some() || patch.query_queue_size.is_some() => {
+                return Err("Admission control is disabled, set LUMOS_MAX_CONCURRENT_QUERIES at startup to tune it".to_string());
+            },
+            None => None,
+        };
+
+        let next = RuntimeSettings {
+            memory_high_percent: patch.memory_high_percent.unwrap_or(current.memory_high_percent),
+            memory_critical_percent: patch.memory_critical_percent.unwrap_or(current.memory_critical_percent),
+            disk_high_reserve_factor: patch.disk_high_reserve_factor.unwrap_or(current.disk_high_reserve_factor),
+            degraded_row_limit: patch.degraded_row_limit.unwrap_or(current.degraded_row_limit),
+            slow_query_ms: patch.slow_query_ms.unwrap_or(current.slow_query_ms),
+            max_concurrent_queries: patch.max_concurrent_queries.or(current.max_concurrent_queries),
+            query_queue_size: patch.query_queue_size.or(current.query_queue_size),
+        };
+        validate(&next)?;
+
+        let mut policy = self.degradation.policy()
+            .with_memory_thresholds(next.memory_high_percent, next.memory_critical_percent)
+            .with_sample_rows(Some(next.degraded_row_limit).filter(|rows| *rows > 0));
+        policy.disk_high_reserve_factor = next.disk_high_reserve_factor;
+        self.degradation.set_policy(policy);
+        *self.slow_query_ms.write().unwrap() = next.slow_query_ms;
+        if let Some(admission) = admission {
+            if let Some(limit) = next.max_concurrent_queries {
+                admission.set_limit(limit);
+            }
+            if let Some(max_queue) = next.query_queue_size {
+                admission.set_max_queue(max_queue);
+            }
+        }
+
+        let changes = diff(&current, &next, changed_by);
+        for change in &changes {
+            info!("Configuration '{}' changed from {} to {} by {}", change.setting, change.old_value, change.new_value, change.changed_by);
+            if history.len() == MAX_CHANGES {
+                history.pop_front();
+            }
+            history.push_back(change.clone());
+        }
+        Ok(changes)
+    }
+
+    /// 最近的配置变更，按时间从旧到新
+    pub fn changes(&self) -> Vec<ConfigChange> {
+        self.changes.lock().unwrap().iter().cloned().collect()
+    }
+}
+
+fn validate(settings: &RuntimeSettings) -> Result<(), String> {
+    if !(1..=100).contains(&settings.memory_high_percent) {
+        return Err(format!("memory_high_percent must be between 1 and 100, got {}", settings.memory_high_percent));
+    }
+    if settings.memory_critical_percent < settings.memory_high_percent || settings.memory_critical_percent > 100 {
+        return Err(format!(
+            "memory_critical_percent must be between memory_high_percent ({}) and 100, got {}",
+            settings.memory_high_percent, settings.memory_critical_percent
+        ));
+    }
+    if settings.disk_high_reserve_factor < 1 {
+        return Err("disk_high_reserve_factor must be at least 1".to_string());
+    }
+    if !settings.slow_query_ms.is_finite() || settings.slow_query_ms < 0.0 {
+        return Err(format!("slow_query_ms must be a non-negative number, got {}", settings.slow_query_ms));
+    }
+    if settings.max_concurrent_queries == Some(0) {
+        return Err("max_concurrent_queries must be at least 1".to_string());
+    }
+    Ok(())
+}
+
+/// 比较两份配置，每个改变的字段生成一条变更记录
+fn diff(old: &RuntimeSettings, new: &RuntimeSettings, changed_by: &str) -> Vec<ConfigChange> {
+    let (old, new) = match (serde_json::to_value(old), serde_json::to_value(new)) {
+        (Ok(serde_json::Value::Object(old)), Ok(serde_json::Value::Object(new))) => (old, new),
+        _ => return Vec::new(),
+    };
+    let changed_at = Utc::now().to_rfc3339_opts(SecondsFormat::Millis, true);
+    new.into_iter()
+        .filter(|(setting, value)| old.get(setting) != Some(value))
+        .map(|(setting, new_value)| ConfigChange {
+            changed_at: changed_at.clone(),
+            changed_by: changed_by.to_string(),
+            old_value: old.get(&setting).cloned().unwrap_or(serde_json::Value::Null),
+            setting,
+            new_value,
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::degradation::DegradationPolicy;
+
+    #[test]
+    fn test_apply_settings() {
+        let degradation = Arc::new(DegradationController::new(DegradationPolicy::default()));
+        let admission = Arc::new(AdmissionController::new(4, 10));
+        let config = RuntimeConfig::new(degradation.clone(), Some(admission.clone()));
+
+        let patch = SettingsPatch {
+            memory_high_percent: Some(70),
+            slow_query_ms: Some(250.0),
+            max_concurrent_queries: Some(2),
+            ..Default::default()
+        };
+        let changes = config.apply(&patch, "admin").unwrap();
+        assert_eq!(changes.len(), 3);
+        assert_eq!(degradation.policy().memory_high_percent, 70);
+        assert_eq!(config.slow_query_ms(), 250.0);
+        assert_eq!(admission.limit(), 2);
+
+        // 无效的修改整体被拒绝
+        let patch = SettingsPatch { memory_high_percent: Some(60), memory_critical_percent: Some(50), ..Default::default() };
+        assert!(config.apply(&patch, "admin").is_err());
+        assert_eq!(degradation.policy().memory_high_percent, 70);
+
+        // 未改变的值不记录
+        let patch = SettingsPatch { memory_high_percent: Some(70), ..Default::default() };
+        assert!(config.apply(&patch, "admin").unwrap().is_empty());
+        assert_eq!(config.changes().len(), 3);
+
+        let config = RuntimeConfig::new(degradation, None);
+        assert!(config.apply(&SettingsPatch { query_queue_size: Some(5), ..Default::default() }, "admin").is_err());
+    }
+}
@@ -0,0 +1,256 @@
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::time::Instant;
+use serde::Serialize;
+
+use lumos_core::query::fingerprint::QueryShapeStats;
+use lumos_core::query::parser::QueryParser;
+use lumos_core::query::QueryType;
+
+/// 默认写入快照的热点查询数
+pub const DEFAULT_HOT_QUERIES: usize = 50;
+
+/// 最多保留的预热错误数
+const MAX_ERRORS: usize = 20;
+
+/// 预热阶段
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum WarmupPhase {
+    /// 尚未开始
+    Pending,
+    /// 正在预热
+    Running,
+    /// 预热完成，可以接收流量
+    Ready,
+}
+
+/// 预热进度
+#[derive(Debug, Clone, Serialize)]
+pub struct WarmupStatus {
+    /// 当前阶段
+    pub phase: WarmupPhase,
+    /// 已执行的预热查询数
+    pub queries_run: usize,
+    /// 执行失败的预热查询数
+    pub queries_failed: usize,
+    /// 已加载的向量集合数
+    pub collections_loaded: usize,
+    /// 加载失败的向量集合数
+    pub collections_failed: usize,
+    /// 预热耗时（毫秒），完成后才有值
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub elapsed_ms: Option<u64>,
+    /// 预热中遇到的错误
+    pub errors: Vec<String>,
+}
+
+/// 预热计划
+#[derive(Debug, Clone, Default)]
+pub struct WarmupPlan {
+    /// 配置的预热查询
+    pub priming_queries: Vec<String>,
+    /// 热点查询快照文件，启动时执行其中的查询，停止时重新写入
+    pub hot_query_snapshot: Option<PathBuf>,
+    /// 需要预先加载的向量集合
+    pub collections: Vec<String>,
+}
+
+impl WarmupPlan {
+    /// 计划是否为空
+    pub fn is_empty(&self) -> bool {
+        self.priming_queries.is_empty() && self.hot_query_snapshot.is_none() && self.collections.is_empty()
+    }
+}
+
+/// 冷启动预热
+///
+/// 依次执行配置的预热查询、上次停止时快照中的热点查询，并加载指定的向量
+/// 集合。完成之前就绪探针返回未就绪，避免发布后的第一批请求承受冷缓存的
+/// 延迟。单个步骤失败只记录错误，不阻止服务就绪。
+pub struct Warmup {
+    plan: WarmupPlan,
+    ready: AtomicBool,
+    status: Mutex<WarmupStatus>,
+}
+
+impl Warmup {
+    /// 按计划创建预热，计划为空时直接就绪
+    pub fn new(plan: WarmupPlan) -> Self {
+        let ready = plan.is_empty();
+        Self {
+            plan,
+            ready: AtomicBool::new(ready),
+            status: Mutex::new(WarmupStatus {
+                phase: if ready { WarmupPhase::Ready } else { WarmupPhase::Pending },
+                queries_run: 0,
+                queries_failed: 0,
+                collections_loaded: 0,
+                collections_failed: 0,
+                elapsed_ms: None,
+                errors: Vec::new(),
+            }),
+        }
+    }
+
+    /// 预热计划
+    pub fn plan(&self) -> &WarmupPlan {
+        &self.plan
+    }
+
+    /// 是否已就绪
+    pub fn is_ready(&self) -> bool {
+        self.ready.load(Ordering::SeqCst)
+    }
+
+    /// 预热进度
+    pub fn status(&self) -> WarmupStatus {
+        self.status.lock().unwrap().clone()
+    }
+
+    /// 执行预热，`query`执行一条查询，`load_collection`加载一个向量集合
+    pub fn run<Q, C>(&self, mut query: Q, mut load_collection: C)
+    where
+        Q: FnMut(&str) -> Result<(), String>,
+        C: FnMut(&str) -> Result<(), String>,
+    {
+        let started = Instant::now();
+        self.status.lock().unwrap().phase = WarmupPhase::Running;
+
+        let mut queries = self.plan.priming_queries.clone();
+        if let Some(path) = &self.plan.hot_query_snapshot {
+            match load_hot_queries(path) {
+                Ok(hot) => queries.extend(hot),
+                Err(e) if e.kind() == io::ErrorKind::NotFound => {}
+                Err(e) => self.record_error(format!("Failed to read hot query snapshot {}: {}", path.display(), e)),
+            }
+        }
+
+        for sql in &queries {
+            let result = query(sql);
+            let mut status = self.status.lock().unwrap();
+            status.queries_run += 1;
+            if let Err(e) = result {
+                status.queries_failed += 1;
+                drop(status);
+                self.record_error(format!("Warmup query failed: {}: {}", sql, e));
+            }
+        }
+
+        for name in &self.plan.collections {
+            let result = load_collection(name);
+            let mut status = self.status.lock().unwrap();
+            match result {
+                Ok(()) => status.collections_loaded += 1,
+                Err(e) => {
+                    status.collections_failed += 1;
+                    drop(status);
+                    self.record_error(format!("Failed to load collection '{}': {}", name, e));
+                }
+            }
+        }
+
+        let mut status = self.status.lock().unwrap();
+        status.phase = WarmupPhase::Ready;
+        status.elapsed_ms = Some(started.elapsed().as_millis() as u64);
+        log::info!(
+            "Warmup finished in {} ms: {} queries ({} failed), {} collections ({} failed)",
+            status.elapsed_ms.unwrap_or(0), status.queries_run, status.queries_failed,
+            status.collections_loaded, status.collections_failed
+        );
+        drop(status);
+        self.ready.store(true, Ordering::SeqCst);
+    }
+
+    fn record_error(&self, error: String) {
+        log::warn!("{}", error);
+        let mut status = self.status.lock().unwrap();
+        if status.errors.len() < MAX_ERRORS {
+            status.errors.push(error);
+        }
+    }
+}
+
+/// 将执行总耗时最多的只读查询写入快照，返回写入的查询数
+pub fn save_hot_queries(path: &Path, stats: &[QueryShapeStats], limit: usize) -> io::Result<usize> {
+    let mut parser = QueryParser::new();
+    let queries: Vec<&str> = stats
+        .iter()
+        .filter(|shape| shape.errors < shape.calls)
+        .filter(|shape| matches!(parser.parse_query_type(&shape.sample), Ok(QueryType::Select)))
+        .take(limit)
+        .map(|shape| shape.sample.as_str())
+        .collect();
+
+    if let Some(parent) = path.parent().filter(|p| !p.as_os_str().is_empty()) {
+        fs::create_dir_all(parent)?;
+    }
+    let json = serde_json::to_string_pretty(&queries)
+        .map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e))?;
+    fs::write(path, json)?;
+    Ok(queries.len())
+}
+
+/// 读取热点查询快照
+pub fn load_hot_queries(path: &Path) -> io::Result<Vec<String>> {
+    let json = fs::read_to_string(path)?;
+    serde_json::from_str(&json).map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn shape(sample: &str, calls: u64, errors: u64) -> QueryShapeStats {
+        QueryShapeStats {
+            fingerprint: sample.to_uppercase(),
+            sample: sample.to_string(),
+            calls,
+            errors,
+            rows: 0,
+            total_ms: 1.0,
+            min_ms: 1.0,
+            max_ms: 1.0,
+        }
+    }
+
+    #[test]
+    fn test_warmup_runs_plan_and_snapshot() {
+        let path = std::env::temp_dir().join(format!("lumos_hot_queries_{}.json", std::process::id()));
+        let stats = vec![
+            shape("SELECT * FROM orders", 10, 0),
+            shape("DELETE FROM orders", 10, 0),
+            shape("SELECT * FROM missing", 3, 3),
+        ];
+        assert_eq!(save_hot_queries(&path, &stats, 10).unwrap(), 1);
+
+        let warmup = Warmup::new(WarmupPlan {
+            priming_queries: vec!["SELECT 1".to_string()],
+            hot_query_snapshot: Some(path.clone()),
+            collections: vec!["docs".to_string(), "gone".to_string()],
+        });
+        assert!(!warmup.is_ready());
+
+        let mut executed = Vec::new();
+        warmup.run(
+            |sql| {
+                executed.push(sql.to_string());
+                Ok(())
+            },
+            |name| if name == "docs" { Ok(()) } else { Err("not found".to_string()) },
+        );
+        fs::remove_file(&path).ok();
+
+        assert!(warmup.is_ready());
+        assert_eq!(executed, vec!["SELECT 1", "SELECT * FROM orders"]);
+        let status = warmup.status();
+        assert_eq!(status.phase, WarmupPhase::Ready);
+        assert_eq!((status.collections_loaded, status.collections_failed), (1, 1));
+        assert_eq!(status.errors.len(), 1);
+
+        assert!(Warmup::new(WarmupPlan::default()).is_ready());
+    }
+}
@@ -113,4 +113,39 @@ async fn test_index_creation() {
     
     // Clean up
     let _ = fs::remove_file(vector_db_path);
-} 
\ No newline at end of file
+} 
+#[actix_web::test]
+async fn test_search_explain() {
+    // Create test vector database
+    let vector_db_path = "test_vector_explain.lumos";
+    if Path::new(vector_db_path).exists() {
+        let _ = fs::remove_file(vector_db_path);
+    }
+    
+    let vector_executor = Arc::new(VectorExecutor::new(vector_db_path).unwrap());
+    let _ = vector_executor.create_collection("test_explain", 2);
+    let ids = vec!["vec1".to_string(), "vec2".to_string()];
+    let embeddings = vec![vec![1.0, 0.0], vec![0.0, 2.0]];
+    let _ = vector_executor.add_embeddings("test_explain", ids, embeddings, None);
+    
+    // Explanations are only attached when requested
+    let results = vector_executor.search_similar("test_explain", vec![0.6, 0.8], 2).unwrap();
+    assert!(results.iter().all(|r| r.explanation.is_none()));
+    
+    let results = vector_executor.search("test_explain", vec![0.6, 0.8], 2, true).unwrap();
+    assert_eq!(results[0].id, "vec2");
+    
+    let explanation = results[0].explanation.as_ref().unwrap();
+    assert_eq!(explanation.rank, 1);
+    assert_eq!(explanation.method, "exact");
+    assert!((explanation.distance - (1.0 - results[0].score)).abs() < 1e-6);
+    assert!((explanation.vector_norm - 2.0).abs() < 1e-6);
+    
+    // Per-dimension contributions add up to the similarity
+    let total: f32 = explanation.top_dimensions.iter().map(|d| d.contribution).sum();
+    assert!((total - explanation.similarity).abs() < 1e-5);
+    assert_eq!(explanation.top_dimensions[0].dimension, 1);
+    
+    // Clean up
+    let _ = fs::remove_file(vector_db_path);
+}